@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/centrifugal/centrifugo/libcentrifugo"
+)
+
+// benchOptions are options of bench command.
+type benchOptions struct {
+	Engine   string
+	RedisURL string
+	libcentrifugo.BenchConfig
+}
+
+// benchApplication creates embedded node in insecure mode with engine from
+// options.
+func benchApplication(opts benchOptions) (*libcentrifugo.Application, error) {
+	c := *libcentrifugo.DefaultConfig
+	c.Name = "bench"
+	c.Version = VERSION
+	c.Insecure = true
+	app, err := libcentrifugo.NewApplication(&c)
+	if err != nil {
+		return nil, err
+	}
+	var e libcentrifugo.Engine
+	switch opts.Engine {
+	case "memory":
+		e = libcentrifugo.NewMemoryEngine(app)
+	case "redis":
+		e = libcentrifugo.NewRedisEngine(app, &libcentrifugo.RedisEngineConfig{
+			Host:           "127.0.0.1",
+			Port:           "6379",
+			DB:             "0",
+			URL:            opts.RedisURL,
+			PoolSize:       256,
+			ConnectTimeout: time.Second,
+			ReadTimeout:    libcentrifugo.DefaultConfig.NodePingInterval*3 + time.Second,
+			WriteTimeout:   time.Second,
+		})
+	default:
+		return nil, errors.New("unknown engine " + opts.Engine + ", must be memory or redis")
+	}
+	app.SetEngine(e)
+	return app, nil
+}
+
+// runBench runs benchmark on embedded node and writes results into w.
+func runBench(opts benchOptions, w io.Writer) error {
+	app, err := benchApplication(opts)
+	if err != nil {
+		return err
+	}
+	if err := app.Run(); err != nil {
+		return err
+	}
+	defer app.Shutdown()
+
+	fmt.Fprintf(w, "Engine: %s\n", opts.Engine)
+	fmt.Fprintf(w, "Publishers: %d, subscribers: %d, channels: %d, payload: %d bytes, duration: %s\n",
+		opts.Publishers, opts.Subscribers, opts.Channels, opts.PayloadSize, opts.Duration)
+	result, err := app.Bench(opts.BenchConfig)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Published: %d (%.0f msg/s), errors: %d\n", result.Published, result.PublishRate(), result.PublishErrors)
+	fmt.Fprintf(w, "Delivered: %d (%.0f msg/s), disconnected subscribers: %d\n", result.Delivered, result.DeliveryRate(), result.Disconnected)
+	fmt.Fprintf(w, "Latency: p50 %s, p90 %s, p99 %s, max %s\n", result.LatencyP50, result.LatencyP90, result.LatencyP99, result.LatencyMax)
+	return nil
+}
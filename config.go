@@ -1,18 +1,24 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"text/template"
 	"time"
 
 	"github.com/FZambia/go-logger"
 	"github.com/centrifugal/centrifugo/libcentrifugo"
-	"github.com/satori/go.uuid"
 	"github.com/spf13/viper"
 )
 
@@ -47,6 +53,20 @@ func newConfig() *libcentrifugo.Config {
 	cfg.PresencePingInterval = time.Duration(viper.GetInt("presence_ping_interval")) * time.Second
 	cfg.PresenceExpireInterval = time.Duration(viper.GetInt("presence_expire_interval")) * time.Second
 	cfg.MessageSendTimeout = time.Duration(viper.GetInt("message_send_timeout")) * time.Second
+	cfg.ShutdownTimeout = time.Duration(viper.GetInt("shutdown_timeout")) * time.Second
+	cfg.SubscribeEngineTimeout = time.Duration(viper.GetInt("subscribe_engine_timeout")) * time.Second
+	cfg.BulkDisconnectRate = viper.GetInt("bulk_disconnect_rate")
+	cfg.ClientAcksWindow = viper.GetInt("client_acks_window")
+	cfg.ClientCommandsMaxViolations = viper.GetInt("client_commands_max_violations")
+	cfg.ClientAcksAdviceThreshold = viper.GetInt("client_acks_advice_threshold")
+	cfg.AlarmConnectionsWarn = int64(viper.GetInt("alarm_connections_warn"))
+	cfg.AlarmConnectionsCritical = int64(viper.GetInt("alarm_connections_critical"))
+	cfg.AlarmQueuedBytesWarn = int64(viper.GetInt("alarm_queued_bytes_warn"))
+	cfg.AlarmQueuedBytesCritical = int64(viper.GetInt("alarm_queued_bytes_critical"))
+	cfg.AlarmPublishRateWarn = int64(viper.GetInt("alarm_publish_rate_warn"))
+	cfg.AlarmPublishRateCritical = int64(viper.GetInt("alarm_publish_rate_critical"))
+	cfg.AlarmHysteresis = viper.GetFloat64("alarm_hysteresis")
+	cfg.AlarmInterval = time.Duration(viper.GetInt("alarm_interval")) * time.Second
 	cfg.PrivateChannelPrefix = viper.GetString("private_channel_prefix")
 	cfg.NamespaceChannelBoundary = viper.GetString("namespace_channel_boundary")
 	cfg.UserChannelBoundary = viper.GetString("user_channel_boundary")
@@ -55,14 +75,86 @@ func newConfig() *libcentrifugo.Config {
 	cfg.ExpiredConnectionCloseDelay = time.Duration(viper.GetInt("expired_connection_close_delay")) * time.Second
 	cfg.StaleConnectionCloseDelay = time.Duration(viper.GetInt("stale_connection_close_delay")) * time.Second
 	cfg.ClientRequestMaxSize = viper.GetInt("client_request_max_size")
+	cfg.ClientRequestMaxCommands = viper.GetInt("client_request_max_commands")
 	cfg.ClientQueueMaxSize = viper.GetInt("client_queue_max_size")
+	cfg.ClientQueueMaxSizeSoft = viper.GetInt("client_queue_max_size_soft")
 	cfg.ClientQueueInitialCapacity = viper.GetInt("client_queue_initial_capacity")
 	cfg.ClientChannelLimit = viper.GetInt("client_channel_limit")
+	cfg.MaxClients = viper.GetInt("max_clients")
+	cfg.ClientUserConnectionLimit = viper.GetInt("client_user_connection_limit")
+	cfg.ClientUserConnectionLimitPolicy = viper.GetString("client_user_connection_limit_policy")
+	cfg.ClientAnonymousConnectionLimit = viper.GetInt("client_anonymous_connection_limit")
+	cfg.ServerSubscriptions = viper.GetStringSlice("server_subscriptions")
+	cfg.ClientChannelLimitSoft = viper.GetInt("client_channel_limit_soft")
+	cfg.LimitGracePeriod = time.Duration(viper.GetInt("limit_grace_period")) * time.Second
+	cfg.DiagMaxSize = viper.GetInt("diag_max_size")
+	cfg.SubscriptionChurnLimit = viper.GetInt("subscription_churn_limit")
+	cfg.ConnectionLogFile = viper.GetString("connection_log_file")
+	cfg.ConnectionLogMaxSize = int64(viper.GetInt("connection_log_max_size"))
+	cfg.ConnectionLogMaxBackups = viper.GetInt("connection_log_max_backups")
+	cfg.StatsdAddr = viper.GetString("statsd_addr")
+	cfg.StatsdPrefix = viper.GetString("statsd_prefix")
+	cfg.StatsdFormat = viper.GetString("statsd_format")
+	cfg.StatsdTags = viper.GetStringSlice("statsd_tags")
+	cfg.TracingEndpoint = viper.GetString("tracing_endpoint")
+	cfg.TracingProtocol = viper.GetString("tracing_protocol")
+	cfg.TracingSampleRate = viper.GetFloat64("tracing_sample_rate")
+	cfg.KafkaBrokers = viper.GetStringSlice("kafka_brokers")
+	cfg.KafkaTopics = viper.GetStringSlice("kafka_topics")
+	cfg.KafkaConsumerGroup = viper.GetString("kafka_consumer_group")
+	cfg.KafkaCommitInterval = time.Duration(viper.GetInt("kafka_commit_interval")) * time.Second
+	cfg.KafkaErrorPolicy = viper.GetString("kafka_error_policy")
+	cfg.AuditLog = viper.GetBool("audit_log")
+	cfg.AuditLogFile = viper.GetString("audit_log_file")
+	cfg.AuditLogMaxSize = int64(viper.GetInt("audit_log_max_size"))
+	cfg.AuditLogMaxBackups = viper.GetInt("audit_log_max_backups")
+	cfg.AuditLogPublish = viper.GetBool("audit_log_publish")
+	cfg.SubscriptionChurnWindow = time.Duration(viper.GetInt("subscription_churn_window")) * time.Second
+	cfg.WebsocketCompression = viper.GetBool("websocket_compression")
+	cfg.WebsocketCompressionMinSize = viper.GetInt("websocket_compression_min_size")
+	cfg.PrivateSignCacheTTL = time.Duration(viper.GetInt("private_sign_cache_ttl")) * time.Second
+	cfg.PrivateSignCacheSize = viper.GetInt("private_sign_cache_size")
+	cfg.PresenceCacheTTL = time.Duration(viper.GetInt("presence_cache_ttl")) * time.Second
+	cfg.PresenceCacheSize = viper.GetInt("presence_cache_size")
 	cfg.Insecure = viper.GetBool("insecure")
 	cfg.InsecureAPI = viper.GetBool("insecure_api")
+	cfg.APILegacyFormEnabled = viper.GetBool("api_legacy_form_enabled")
+	cfg.APILegacySignEnabled = viper.GetBool("api_legacy_sign_enabled")
+	cfg.APISignWindow = time.Duration(viper.GetInt("api_sign_window")) * time.Second
+	cfg.APIRequestsPerSecond = viper.GetInt("api_requests_per_second")
+	cfg.APIRequestsBurst = viper.GetInt("api_requests_burst")
+	cfg.APIAsyncQueueSize = viper.GetInt("api_async_queue_size")
+	cfg.AllowedOrigins = viper.GetStringSlice("allowed_origins")
+	cfg.APIAllowedOrigins = viper.GetStringSlice("api_allowed_origins")
+	cfg.ConnectProxyEndpoint = viper.GetString("connect_proxy_endpoint")
+	cfg.ConnectProxyTimeout = time.Duration(viper.GetInt("connect_proxy_timeout")) * time.Second
+	cfg.ConnectProxyRetries = viper.GetInt("connect_proxy_retries")
+	cfg.ConnectProxyFailOpen = viper.GetBool("connect_proxy_fail_open")
+	cfg.ConnectProxyHeaders = viper.GetStringSlice("connect_proxy_headers")
+	cfg.SubscribeProxyEndpoint = viper.GetString("subscribe_proxy_endpoint")
+	cfg.SubscribeProxyTimeout = time.Duration(viper.GetInt("subscribe_proxy_timeout")) * time.Second
+	cfg.SubscribeProxyRetries = viper.GetInt("subscribe_proxy_retries")
+	cfg.SubscribeProxyCacheTTL = time.Duration(viper.GetInt("subscribe_proxy_cache_ttl")) * time.Second
+	cfg.SubscribeProxyCacheSize = viper.GetInt("subscribe_proxy_cache_size")
+	cfg.PublishProxyEndpoint = viper.GetString("publish_proxy_endpoint")
+	cfg.PublishProxyTimeout = time.Duration(viper.GetInt("publish_proxy_timeout")) * time.Second
+	cfg.PublishProxyRetries = viper.GetInt("publish_proxy_retries")
+	cfg.PublishProxyPassThrough = viper.GetBool("publish_proxy_pass_through")
 	cfg.InsecureAdmin = viper.GetBool("insecure_admin") || viper.GetBool("insecure_web")
+	cfg.AdminActionsEnabled = viper.GetBool("admin_actions_enabled")
+	cfg.AdminWatchMaxDuration = time.Duration(viper.GetInt("admin_watch_max_duration")) * time.Second
+	cfg.AdminWatchRate = viper.GetInt("admin_watch_rate")
+	cfg.AdminAuthTTL = time.Duration(viper.GetInt("admin_auth_ttl")) * time.Second
+	cfg.AdminAuthLegacyEnabled = viper.GetBool("admin_auth_legacy_enabled")
+	cfg.AdminAuthMaxAttempts = viper.GetInt("admin_auth_max_attempts")
+	cfg.AdminAuthLockout = time.Duration(viper.GetInt("admin_auth_lockout")) * time.Second
+	cfg.AdminAuthLockoutMax = time.Duration(viper.GetInt("admin_auth_lockout_max")) * time.Second
+	cfg.APIAllowedIPs = viper.GetStringSlice("api_allowed_ips")
+	cfg.AdminAllowedIPs = viper.GetStringSlice("admin_allowed_ips")
+	cfg.TrustedProxies = viper.GetStringSlice("trusted_proxies")
 
 	cfg.Secret = viper.GetString("secret")
+	cfg.SecretPrevious = viper.GetString("secret_previous")
 	cfg.ConnLifetime = int64(viper.GetInt("connection_lifetime"))
 
 	cfg.Watch = viper.GetBool("watch")
@@ -73,8 +165,22 @@ func newConfig() *libcentrifugo.Config {
 	cfg.HistorySize = viper.GetInt("history_size")
 	cfg.HistoryLifetime = viper.GetInt("history_lifetime")
 	cfg.HistoryDropInactive = viper.GetBool("history_drop_inactive")
+	cfg.BinaryPayloads = viper.GetBool("binary_payloads")
+	cfg.ClientAcks = viper.GetBool("client_acks")
+	cfg.ClientCommandsPerSecond = viper.GetInt("client_commands_per_second")
+	cfg.ClientCommandsBurst = viper.GetInt("client_commands_burst")
+	cfg.PublishProxy = viper.GetBool("publish_proxy")
+	cfg.MaxMessageSize = viper.GetInt("max_message_size")
+	cfg.SlowClientPolicy = viper.GetString("slow_client_policy")
 	cfg.Recover = viper.GetBool("recover")
 	cfg.Namespaces = namespacesFromConfig(nil)
+	if viper.IsSet("api_keys") {
+		viper.MarshalKey("api_keys", &cfg.APIKeys)
+	}
+	cfg.NamespaceTemplate = libcentrifugo.DefaultConfig.NamespaceTemplate
+	if viper.IsSet("namespace_template") {
+		viper.MarshalKey("namespace_template", &cfg.NamespaceTemplate)
+	}
 
 	return cfg
 }
@@ -109,87 +215,368 @@ func pathExists(path string) (bool, error) {
 }
 
 var jsonConfigTemplate = `{
-  "secret": "{{.Secret}}"
+  "secret": "{{.Secret}}",
+  "admin_password": "{{.AdminPassword}}",
+  "admin_secret": "{{.AdminSecret}}",
+  "engine": "{{.Engine}}",
+  "port": {{quote .Port}}{{if .RedisURL}},
+  "redis_url": {{quote .RedisURL}}{{end}}{{if .NatsURL}},
+  "nats_url": {{quote .NatsURL}}{{end}}{{if .Web}},
+  "web": true{{end}}
 }
 `
 
-var tomlConfigTemplate = `secret = {{.Secret}}
+var tomlConfigTemplate = `secret = "{{.Secret}}"
+admin_password = "{{.AdminPassword}}"
+admin_secret = "{{.AdminSecret}}"
+engine = "{{.Engine}}"
+port = {{quote .Port}}
+{{- if .RedisURL}}
+redis_url = {{quote .RedisURL}}
+{{- end}}
+{{- if .NatsURL}}
+nats_url = {{quote .NatsURL}}
+{{- end}}
+{{- if .Web}}
+web = true
+{{- end}}
 `
 
-var yamlConfigTemplate = `secret: {{.Secret}}
+var yamlConfigTemplate = `secret: "{{.Secret}}"
+admin_password: "{{.AdminPassword}}"
+admin_secret: "{{.AdminSecret}}"
+engine: "{{.Engine}}"
+port: {{quote .Port}}
+{{- if .RedisURL}}
+redis_url: {{quote .RedisURL}}
+{{- end}}
+{{- if .NatsURL}}
+nats_url: {{quote .NatsURL}}
+{{- end}}
+{{- if .Web}}
+web: true
+{{- end}}
 `
 
-// generateConfig generates configuration file at provided path.
-func generateConfig(f string) error {
-	exists, err := pathExists(f)
+// genConfigOptions are options written into generated configuration file.
+type genConfigOptions struct {
+	Engine string
+	Port   string
+	// RedisURL and NatsURL written only when set for corresponding engine.
+	RedisURL string
+	NatsURL  string
+	// Web enables admin web interface.
+	Web bool
+}
+
+// engines supported in generated configuration.
+var genConfigEngines = []string{"memory", "redis", "nats"}
+
+// randomSecret returns hex encoded cryptographically random string.
+func randomSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// promptPassword asks for password. On terminal echo turned off while password
+// typed and password asked twice, otherwise it is read from first line of input.
+func promptPassword(in *os.File, out io.Writer) (string, error) {
+	reader := bufio.NewReader(in)
+	readLine := func() (string, error) {
+		password, err := reader.ReadString('\n')
+		if err != nil && (err != io.EOF || password == "") {
+			return "", err
+		}
+		return strings.TrimRight(password, "\r\n"), nil
+	}
+	restore, err := disableEcho(in)
+	if err != nil {
+		password, err := readLine()
+		if err != nil {
+			return "", err
+		}
+		if password == "" {
+			return "", errors.New("empty password")
+		}
+		return password, nil
+	}
+	defer restore()
+	read := func(prompt string) (string, error) {
+		fmt.Fprint(out, prompt)
+		defer fmt.Fprintln(out)
+		return readLine()
+	}
+	password, err := read("Password: ")
+	if err != nil {
+		return "", err
+	}
+	if password == "" {
+		return "", errors.New("empty password")
+	}
+	confirm, err := read("Repeat password: ")
+	if err != nil {
+		return "", err
+	}
+	if confirm != password {
+		return "", errors.New("passwords do not match")
+	}
+	return password, nil
+}
+
+// promptConfigOptions asks user for engine, port and whether to enable admin web
+// interface. Empty answer keeps value already set in options.
+func promptConfigOptions(in io.Reader, out io.Writer, opts *genConfigOptions) error {
+	reader := bufio.NewReader(in)
+	ask := func(question string, value string) (string, error) {
+		fmt.Fprintf(out, "%s [%s]: ", question, value)
+		answer, err := reader.ReadString('\n')
+		if err != nil && (err != io.EOF || answer == "") {
+			return "", err
+		}
+		answer = strings.TrimSpace(answer)
+		if answer == "" {
+			return value, nil
+		}
+		return answer, nil
+	}
+
+	var err error
+	opts.Engine, err = ask("Engine ("+strings.Join(genConfigEngines, ", ")+")", opts.Engine)
 	if err != nil {
 		return err
 	}
-	if exists {
-		return errors.New("output config file already exists: " + f)
+	switch opts.Engine {
+	case "redis":
+		opts.RedisURL, err = ask("Redis URL", opts.RedisURL)
+	case "nats":
+		opts.NatsURL, err = ask("NATS URL", opts.NatsURL)
 	}
-	ext := filepath.Ext(f)
+	if err != nil {
+		return err
+	}
+	opts.Port, err = ask("Port", opts.Port)
+	if err != nil {
+		return err
+	}
+	web := "n"
+	if opts.Web {
+		web = "y"
+	}
+	web, err = ask("Enable admin web interface (y/n)", web)
+	if err != nil {
+		return err
+	}
+	opts.Web = strings.HasPrefix(strings.ToLower(web), "y")
+	return nil
+}
 
-	if len(ext) > 1 {
-		ext = ext[1:]
+// generateConfig generates configuration file at provided path. Format (json,
+// toml or yaml) detected by file extension if not provided explicitly. Secret,
+// admin password and admin secret are generated randomly. Existing file is
+// only overwritten when force is set.
+func generateConfig(f string, format string, opts genConfigOptions, force bool) error {
+	exists, err := pathExists(f)
+	if err != nil {
+		return err
 	}
+	if exists && !force {
+		return errors.New("output config file already exists, use --force to overwrite it: " + f)
+	}
+	if !stringInSlice(opts.Engine, genConfigEngines) {
+		return errors.New("unknown engine: " + opts.Engine)
+	}
+	if opts.Engine != "redis" {
+		opts.RedisURL = ""
+	}
+	if opts.Engine != "nats" {
+		opts.NatsURL = ""
+	}
+	ext := configFormat(f)
 
 	supportedExts := []string{"json", "toml", "yaml", "yml"}
 
 	if !stringInSlice(ext, supportedExts) {
 		return errors.New("output config file must have one of supported extensions: " + strings.Join(supportedExts, ", "))
 	}
+	if format != "" && normalizeConfigFormat(format) != normalizeConfigFormat(ext) {
+		// format detected by extension when config loaded so they must match.
+		return errors.New("output config file extension does not match format " + format)
+	}
 
 	var t *template.Template
 
+	// values provided by user quoted as JSON strings which are valid double quoted
+	// strings in TOML and YAML too.
+	funcs := template.FuncMap{"quote": quoteConfigValue}
 	switch ext {
 	case "json":
-		t, err = template.New("config").Parse(jsonConfigTemplate)
+		t, err = template.New("config").Funcs(funcs).Parse(jsonConfigTemplate)
 	case "toml":
-		t, err = template.New("config").Parse(tomlConfigTemplate)
+		t, err = template.New("config").Funcs(funcs).Parse(tomlConfigTemplate)
 	case "yaml", "yml":
-		t, err = template.New("config").Parse(yamlConfigTemplate)
+		t, err = template.New("config").Funcs(funcs).Parse(yamlConfigTemplate)
 	}
 	if err != nil {
 		return err
 	}
 
+	var secrets [3]string
+	for i := range secrets {
+		secrets[i], err = randomSecret()
+		if err != nil {
+			return err
+		}
+	}
+
 	var output bytes.Buffer
-	t.Execute(&output, struct {
-		Secret string
+	err = t.Execute(&output, struct {
+		genConfigOptions
+		Secret        string
+		AdminPassword string
+		AdminSecret   string
 	}{
-		uuid.NewV4().String(),
+		opts,
+		secrets[0],
+		secrets[1],
+		secrets[2],
 	})
+	if err != nil {
+		return err
+	}
 
-	err = ioutil.WriteFile(f, output.Bytes(), 0644)
+	// config written into temporary file with the same extension and moved in
+	// place only when valid so existing config never lost on --force.
+	tmp := filepath.Join(filepath.Dir(f), "."+filepath.Base(f)+".tmp."+ext)
+	// file contains secrets so it is not readable by other users.
+	err = ioutil.WriteFile(tmp, output.Bytes(), 0600)
 	if err != nil {
 		return err
 	}
 
-	err = validateConfig(f)
+	_, err = validateConfig(tmp)
 	if err != nil {
-		_ = os.Remove(f)
+		_ = os.Remove(tmp)
 		return err
 	}
 
+	if err := os.Rename(tmp, f); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
 	return nil
 }
 
-// validateConfig validates config file located at provided path.
-func validateConfig(f string) error {
-	v := viper.New()
-	v.SetConfigFile(f)
-	err := v.ReadInConfig()
+// quoteConfigValue returns value as double quoted JSON string.
+func quoteConfigValue(value string) (string, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// configFormat returns config file format detected by file extension.
+func configFormat(f string) string {
+	ext := filepath.Ext(f)
+	if len(ext) > 1 {
+		ext = ext[1:]
+	}
+	return ext
+}
+
+// normalizeConfigFormat returns yaml for yml format.
+func normalizeConfigFormat(format string) string {
+	if format == "yml" {
+		return "yaml"
+	}
+	return format
+}
+
+// validateConfig validates config file located at provided path. Format of file
+// (JSON, TOML or YAML) detected by its extension. File read into global config
+// as configuration built from it, so it must not be called by running server.
+// Suspicious options which do not prevent server from running returned as warnings.
+func validateConfig(f string) ([]string, error) {
+	setDefaults()
+	viper.SetConfigFile(f)
+	err := viper.ReadInConfig()
 	if err != nil {
 		switch err.(type) {
 		case viper.ConfigParseError:
-			return err
+			return nil, err
 		default:
-			return errors.New("Unable to locate config file, use \"centrifugo genconfig -c " + f + "\" command to generate one")
+			return nil, errors.New("Unable to locate config file, use \"centrifugo genconfig -c " + f + "\" command to generate one")
 		}
 	}
 	c := newConfig()
-	return c.Validate()
+	err = c.Validate()
+	if err != nil {
+		return nil, err
+	}
+	errs, warnings := c.Lint()
+	warnings = append(warnings, unknownNamespaceKeys()...)
+	if len(errs) > 0 {
+		return warnings, errors.New(strings.Join(errs, "; "))
+	}
+	return warnings, nil
+}
+
+// unknownNamespaceKeys returns warnings about keys of namespace objects in global
+// config which do not match any namespace option – most probably misspelled options
+// silently ignored when config decoded.
+func unknownNamespaceKeys() []string {
+	known := map[string]bool{}
+	namespaceOptionKeys(reflect.TypeOf(libcentrifugo.Namespace{}), known)
+
+	namespaces, ok := viper.Get("namespaces").([]interface{})
+	if !ok {
+		return nil
+	}
+	var warnings []string
+	for i, n := range namespaces {
+		keys := map[string]interface{}{}
+		switch obj := n.(type) {
+		case map[string]interface{}:
+			keys = obj
+		case map[interface{}]interface{}:
+			for k, v := range obj {
+				keys[fmt.Sprint(k)] = v
+			}
+		default:
+			continue
+		}
+		name := fmt.Sprint(keys["name"])
+		if _, ok := keys["name"]; !ok {
+			name = fmt.Sprintf("#%d", i)
+		}
+		for key := range keys {
+			if !known[strings.ToLower(key)] {
+				warnings = append(warnings, fmt.Sprintf("namespace %s: unknown option %s", name, key))
+			}
+		}
+	}
+	return warnings
+}
+
+// namespaceOptionKeys collects config keys of struct fields into keys map. Keys
+// are lowercased as config decoding matches them case insensitively.
+func namespaceOptionKeys(t reflect.Type, keys map[string]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("mapstructure"), ",")
+		if field.Anonymous && len(tag) > 1 && tag[1] == "squash" {
+			namespaceOptionKeys(field.Type, keys)
+			continue
+		}
+		key := tag[0]
+		if key == "" {
+			key = field.Name
+		}
+		keys[strings.ToLower(key)] = true
+	}
 }
 
 func namespacesFromConfig(v *viper.Viper) []libcentrifugo.Namespace {
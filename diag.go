@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/centrifugal/centrifugo/libcentrifugo/auth"
+	"github.com/satori/go.uuid"
+	"github.com/spf13/viper"
+)
+
+// diagAPIPort returns port of local node API endpoint using settings from config.
+func diagAPIPort(v *viper.Viper) string {
+	port := v.GetString("api_port")
+	if port == "" {
+		port = v.GetString("port")
+	}
+	if port == "" {
+		port = "8000"
+	}
+	return port
+}
+
+// diagAPIURL builds URL of local node API endpoint using settings from config.
+func diagAPIURL(v *viper.Viper) string {
+	port := diagAPIPort(v)
+	address := v.GetString("address")
+	if address == "" {
+		address = "127.0.0.1"
+	}
+	host := net.JoinHostPort(address, port)
+	if _, ok := unixSocketPath(port); ok {
+		// host is not used when connecting over unix socket.
+		host = "unix"
+	}
+	scheme := "http"
+	if v.GetBool("ssl") {
+		scheme = "https"
+	}
+	prefix := strings.TrimRight(v.GetString("prefix"), "/")
+	return scheme + "://" + host + prefix + "/api/"
+}
+
+// requestDiag asks node for diagnostic bundle using config file located at provided
+// path to find API endpoint and secret. If apiURL is not empty it used as endpoint.
+func requestDiag(f string, apiURL string) ([]byte, error) {
+	v := viper.New()
+	v.SetConfigFile(f)
+	err := v.ReadInConfig()
+	if err != nil {
+		return nil, err
+	}
+	configURL := apiURL == ""
+	if configURL {
+		apiURL = diagAPIURL(v)
+	}
+
+	data := []byte(`{"method":"diag"}`)
+	req, err := http.NewRequest("POST", apiURL, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret := v.GetString("secret"); secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		nonce := uuid.NewV4().String()
+		req.Header.Set("X-API-Timestamp", timestamp)
+		req.Header.Set("X-API-Nonce", nonce)
+		req.Header.Set("X-API-Sign", auth.GenerateApiSignWithTimestamp(secret, timestamp, nonce, data))
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	if path, ok := unixSocketPath(diagAPIPort(v)); ok && configURL {
+		client.Transport = &http.Transport{
+			Dial: func(network, addr string) (net.Conn, error) {
+				return net.Dial("unix", path)
+			},
+		}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("unexpected API response status: " + resp.Status)
+	}
+
+	var replies []struct {
+		Error string          `json:"error"`
+		Body  json.RawMessage `json:"body"`
+	}
+	err = json.Unmarshal(body, &replies)
+	if err != nil {
+		return nil, err
+	}
+	if len(replies) != 1 {
+		return nil, errors.New("unexpected API response")
+	}
+	if replies[0].Error != "" {
+		return nil, errors.New(replies[0].Error)
+	}
+	var diag struct {
+		Data json.RawMessage `json:"data"`
+	}
+	err = json.Unmarshal(replies[0].Body, &diag)
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	err = json.Indent(&out, diag.Data, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	out.WriteByte('\n')
+	return out.Bytes(), nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// disableEcho is not supported on this platform so password typed into terminal
+// read with echo.
+func disableEcho(f *os.File) (func(), error) {
+	return nil, errors.New("disabling terminal echo not supported")
+}
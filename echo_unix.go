@@ -0,0 +1,37 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// disableEcho turns off echo of input typed into terminal. It returns function
+// restoring previous terminal state or error if file is not a terminal.
+func disableEcho(f *os.File) (func(), error) {
+	fd := f.Fd()
+	var state syscall.Termios
+	if err := termios(fd, ioctlGetTermios, &state); err != nil {
+		return nil, err
+	}
+	noEcho := state
+	noEcho.Lflag &^= syscall.ECHO
+	if err := termios(fd, ioctlSetTermios, &noEcho); err != nil {
+		return nil, err
+	}
+	return func() {
+		termios(fd, ioctlSetTermios, &state)
+	}, nil
+}
+
+// termios gets or sets terminal attributes depending on ioctl request.
+func termios(fd uintptr, req uintptr, state *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(unsafe.Pointer(state)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/FZambia/go-logger"
+	"github.com/centrifugal/centrifugo/libcentrifugo"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// Sources of effective configuration values.
+const (
+	sourceDefault = "default"
+	sourceFile    = "file"
+	sourceEnv     = "env"
+	sourceFlag    = "flag"
+)
+
+// effectiveOption is a value of configuration option together with a source it
+// was taken from.
+type effectiveOption struct {
+	Value  interface{} `json:"value"`
+	Source string      `json:"source"`
+}
+
+// effectiveConfig returns all options merged by viper with secret values redacted.
+// Source of value detected in the same order viper looks for it: command line
+// flags, environment variables, config (file or remote source named fileSource)
+// and defaults.
+func effectiveConfig(flags *pflag.FlagSet, fileSource string) map[string]effectiveOption {
+	// viper does not list keys set only by flags and environment variables.
+	keys := append(viper.AllKeys(), envOptions...)
+	if flags != nil {
+		keys = append(keys, flagOptions...)
+	}
+	values := map[string]interface{}{}
+	for _, key := range keys {
+		if value := viper.Get(key); value != nil {
+			values[key] = jsonValue(value)
+		}
+	}
+	values = libcentrifugo.RedactSecrets(values).(map[string]interface{})
+
+	options := make(map[string]effectiveOption, len(values))
+	for key, value := range values {
+		source := sourceDefault
+		if flags != nil && stringInSlice(key, flagOptions) && flags.Lookup(key) != nil && flags.Lookup(key).Changed {
+			source = sourceFlag
+		} else if stringInSlice(key, envOptions) && os.Getenv("CENTRIFUGO_"+strings.ToUpper(key)) != "" {
+			source = sourceEnv
+		} else if viper.InConfig(key) {
+			source = fileSource
+		}
+		options[key] = effectiveOption{Value: value, Source: source}
+	}
+	return options
+}
+
+// jsonValue converts maps decoded from YAML config with interface{} keys into maps
+// with string keys so value can be encoded to JSON.
+func jsonValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(value))
+		for k, item := range value {
+			m[fmt.Sprint(k)] = jsonValue(item)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(value))
+		for k, item := range value {
+			m[k] = jsonValue(item)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(value))
+		for i, item := range value {
+			s[i] = jsonValue(item)
+		}
+		return s
+	}
+	return v
+}
+
+// effectiveConfigDump keeps effective configuration of running node encoded to
+// JSON. It is updated by goroutine loading configuration so debug endpoint never
+// reads viper concurrently with configuration reload.
+type effectiveConfigDump struct {
+	flags      *pflag.FlagSet
+	fileSource string
+	data       atomic.Value
+}
+
+// configDump is an effective configuration of running node.
+var configDump = &effectiveConfigDump{fileSource: sourceFile}
+
+// update encodes current effective configuration.
+func (d *effectiveConfigDump) update() {
+	data, err := json.MarshalIndent(effectiveConfig(d.flags, d.fileSource), "", "  ")
+	if err != nil {
+		logger.ERROR.Printf("error encoding effective configuration: %v", err)
+		return
+	}
+	d.data.Store(data)
+}
+
+// ServeHTTP returns effective configuration of node.
+func (d *effectiveConfigDump) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	data, _ := d.data.Load().([]byte)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
@@ -0,0 +1,129 @@
+package libcentrifugo
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// ackWindow keeps tokens of messages delivered to connection in channels with
+// client acks enabled until client acknowledges them. Window size is limited –
+// oldest tokens dropped when limit reached. This is observability only, unacked
+// messages are never redelivered.
+type ackWindow struct {
+	sync.Mutex
+
+	// size is a maximum number of unacked tokens kept.
+	size int
+
+	// threshold is a number of unacked tokens after reaching which client
+	// receives advice. Zero value means no advice.
+	threshold int
+
+	// ll is a list of unacked tokens ordered by delivery time – oldest in front.
+	ll *list.List
+
+	// tokens allows to find list element by token.
+	tokens map[string]*list.Element
+
+	// dropped is a number of tokens dropped because window was full.
+	dropped int64
+}
+
+// ackItem is an unacked message delivered to connection.
+type ackItem struct {
+	token   string
+	channel Channel
+	sent    time.Time
+}
+
+// newAckWindow initializes ackWindow keeping not more than size tokens.
+func newAckWindow(size int, threshold int) *ackWindow {
+	return &ackWindow{
+		size:      size,
+		threshold: threshold,
+		ll:        list.New(),
+		tokens:    make(map[string]*list.Element),
+	}
+}
+
+// add saves token of delivered message. First returned value is true if oldest
+// token was dropped to fit window size, second is true if number of unacked tokens
+// just reached advice threshold.
+func (w *ackWindow) add(token string, ch Channel, sent time.Time) (bool, bool) {
+	w.Lock()
+	defer w.Unlock()
+	if w.size <= 0 {
+		return false, false
+	}
+	if _, ok := w.tokens[token]; ok {
+		return false, false
+	}
+	w.tokens[token] = w.ll.PushBack(&ackItem{token: token, channel: ch, sent: sent})
+	if w.ll.Len() > w.size {
+		el := w.ll.Front()
+		w.ll.Remove(el)
+		delete(w.tokens, el.Value.(*ackItem).token)
+		w.dropped++
+		return true, false
+	}
+	return false, w.threshold > 0 && w.ll.Len() == w.threshold
+}
+
+// ack removes token from window and returns time passed since message delivery.
+// It returns false if token unknown (never sent, already acked or dropped).
+func (w *ackWindow) ack(token string, now time.Time) (time.Duration, bool) {
+	w.Lock()
+	defer w.Unlock()
+	el, ok := w.tokens[token]
+	if !ok {
+		return 0, false
+	}
+	w.ll.Remove(el)
+	delete(w.tokens, token)
+	return now.Sub(el.Value.(*ackItem).sent), true
+}
+
+// outstanding returns number of unacked tokens in window.
+func (w *ackWindow) outstanding() int {
+	w.Lock()
+	defer w.Unlock()
+	return w.ll.Len()
+}
+
+// state returns information about unacked messages in window.
+func (w *ackWindow) state(now time.Time) deliveryState {
+	w.Lock()
+	defer w.Unlock()
+	state := deliveryState{
+		Outstanding: w.ll.Len(),
+		Dropped:     w.dropped,
+		Unacked:     make([]unackedMessage, 0, w.ll.Len()),
+	}
+	for el := w.ll.Front(); el != nil; el = el.Next() {
+		item := el.Value.(*ackItem)
+		state.Unacked = append(state.Unacked, unackedMessage{
+			Token:   item.token,
+			Channel: item.channel,
+			Age:     int64(now.Sub(item.sent) / time.Millisecond),
+		})
+	}
+	return state
+}
+
+// deliveryState describes unacked messages of connection.
+type deliveryState struct {
+	Client      ConnID           `json:"client"`
+	User        UserID           `json:"user"`
+	Outstanding int              `json:"outstanding"`
+	Dropped     int64            `json:"dropped"`
+	Unacked     []unackedMessage `json:"unacked"`
+}
+
+// unackedMessage describes message delivered to connection but not acked yet.
+type unackedMessage struct {
+	Token   string  `json:"token"`
+	Channel Channel `json:"channel"`
+	// Age is a time in milliseconds passed since message delivery.
+	Age int64 `json:"age"`
+}
@@ -0,0 +1,58 @@
+package libcentrifugo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAckWindow(t *testing.T) {
+	w := newAckWindow(3, 2)
+	now := time.Now()
+
+	dropped, lag := w.add("1", "channel", now)
+	assert.False(t, dropped)
+	assert.False(t, lag)
+	dropped, lag = w.add("2", "channel", now)
+	assert.False(t, dropped)
+	assert.True(t, lag)
+	assert.Equal(t, 2, w.outstanding())
+
+	latency, ok := w.ack("1", now.Add(10*time.Millisecond))
+	assert.True(t, ok)
+	assert.Equal(t, 10*time.Millisecond, latency)
+	_, ok = w.ack("1", now)
+	assert.False(t, ok)
+	_, ok = w.ack("unknown", now)
+	assert.False(t, ok)
+	assert.Equal(t, 1, w.outstanding())
+}
+
+func TestAckWindowOverflow(t *testing.T) {
+	w := newAckWindow(2, 0)
+	now := time.Now()
+	w.add("1", "channel", now)
+	w.add("2", "channel", now)
+	dropped, _ := w.add("3", "other", now.Add(time.Second))
+	assert.True(t, dropped)
+
+	// oldest token dropped and can not be acked anymore.
+	_, ok := w.ack("1", now)
+	assert.False(t, ok)
+
+	state := w.state(now.Add(2 * time.Second))
+	assert.Equal(t, 2, state.Outstanding)
+	assert.Equal(t, int64(1), state.Dropped)
+	assert.Equal(t, "2", state.Unacked[0].Token)
+	assert.Equal(t, int64(2000), state.Unacked[0].Age)
+	assert.Equal(t, Channel("other"), state.Unacked[1].Channel)
+}
+
+func TestAckWindowDisabled(t *testing.T) {
+	w := newAckWindow(0, 0)
+	dropped, lag := w.add("1", "channel", time.Now())
+	assert.False(t, dropped)
+	assert.False(t, lag)
+	assert.Equal(t, 0, w.outstanding())
+}
@@ -3,6 +3,7 @@ package libcentrifugo
 import (
 	"encoding/json"
 	"sync"
+	"time"
 
 	"github.com/FZambia/go-logger"
 	"github.com/centrifugal/centrifugo/libcentrifugo/bytequeue"
@@ -12,6 +13,26 @@ import (
 // adminQueueMaxSize sets admin queue max size to 10MB.
 const adminQueueMaxSize = 10485760
 
+// adminMethodAliases maps admin connection methods to API commands they run.
+var adminMethodAliases = map[string]string{
+	"disconnect_user":  "disconnect",
+	"unsubscribe_user": "unsubscribe",
+}
+
+// adminActions are API commands changing state of clients or channels, admin
+// connection can only run them when admin actions enabled. Also written into
+// audit log.
+var adminActions = map[string]bool{
+	"publish":           true,
+	"broadcast":         true,
+	"unsubscribe":       true,
+	"disconnect":        true,
+	"disconnect_client": true,
+	"disconnect_bulk":   true,
+	"replay":            true,
+	"replay_cancel":     true,
+}
+
 // adminClient is a wrapper over admin connection.
 type adminClient struct {
 	sync.RWMutex
@@ -20,9 +41,14 @@ type adminClient struct {
 	sess          session
 	watch         bool
 	authenticated bool
-	closeChan     chan struct{}
-	maxQueueSize  int
-	messages      bytequeue.ByteQueue
+	// expires is an expiration time of token connection authenticated with, zero
+	// if token never expires. Extended by refresh command.
+	expires      time.Time
+	expireTimer  *time.Timer
+	remoteAddr   string
+	closeChan    chan struct{}
+	maxQueueSize int
+	messages     bytequeue.ByteQueue
 }
 
 func newAdminClient(app *Application, sess session) (*adminClient, error) {
@@ -61,6 +87,38 @@ func (c *adminClient) close(reason string) error {
 	return nil
 }
 
+// setExpires sets token expiration time and (re)starts timer which closes
+// connection when token expires. Must be called with client lock held.
+func (c *adminClient) setExpires(expires time.Time) {
+	c.expires = expires
+	if c.expireTimer != nil {
+		c.expireTimer.Stop()
+		c.expireTimer = nil
+	}
+	if expires.IsZero() {
+		return
+	}
+	c.expireTimer = time.AfterFunc(expires.Sub(time.Now()), c.expire)
+}
+
+// expire closes connection if its token expired and was not refreshed
+// meanwhile.
+func (c *adminClient) expire() {
+	c.RLock()
+	expired := !c.expires.IsZero() && !time.Now().Before(c.expires)
+	c.RUnlock()
+	if !expired {
+		return
+	}
+	select {
+	case <-c.closeChan:
+		return
+	default:
+	}
+	logger.INFO.Println("admin connection token expired", c.uid())
+	c.close("expired")
+}
+
 // clean called when connection was closed to make different clean up
 // actions for a client
 func (c *adminClient) clean() error {
@@ -74,6 +132,12 @@ func (c *adminClient) clean() error {
 		close(c.closeChan)
 	}
 
+	if c.expireTimer != nil {
+		c.expireTimer.Stop()
+	}
+
+	c.app.unwatchChannel(c.uid(), "", "")
+
 	err := c.app.removeAdminConn(c)
 	if err != nil {
 		logger.ERROR.Println(err)
@@ -108,10 +172,6 @@ func (c *adminClient) uid() ConnID {
 }
 
 func (c *adminClient) send(message []byte) error {
-	if c.messages.Size() > c.maxQueueSize {
-		c.close("slow")
-		return ErrClientClosed
-	}
 	if !c.watch {
 		// At moment we only use this method to send asynchronous
 		// messages to admin client when new message published into channel with
@@ -123,6 +183,15 @@ func (c *adminClient) send(message []byte) error {
 		// @banks actually suggested).
 		return nil
 	}
+	return c.enqueue(message)
+}
+
+// enqueue adds message to queue of messages sent to admin connection.
+func (c *adminClient) enqueue(message []byte) error {
+	if c.messages.Size() > c.maxQueueSize {
+		c.close("slow")
+		return ErrClientClosed
+	}
 	ok := c.messages.Add(message)
 	if !ok {
 		return ErrClientClosed
@@ -142,7 +211,7 @@ func (c *adminClient) message(msg []byte) error {
 		return nil
 	}
 
-	var mr multiAPIResponse
+	mr := make(multiAPIResponse, 0, len(commands))
 
 	for _, command := range commands {
 
@@ -153,6 +222,12 @@ func (c *adminClient) message(msg []byte) error {
 			return ErrUnauthorized
 		}
 
+		if command.Method != "connect" && !c.expires.IsZero() && !time.Now().Before(c.expires) {
+			c.Unlock()
+			logger.INFO.Println("admin connection token expired", c.uid())
+			return ErrUnauthorized
+		}
+
 		var resp response
 
 		switch command.Method {
@@ -169,8 +244,28 @@ func (c *adminClient) message(msg []byte) error {
 			resp, err = c.pingCmd()
 		case "info":
 			resp, err = c.infoCmd()
+		case "refresh":
+			resp, err = c.refreshCmd()
+		case "watch":
+			var cmd watchAdminCommand
+			err = json.Unmarshal(command.Params, &cmd)
+			if err != nil {
+				c.Unlock()
+				logger.ERROR.Println(err)
+				return ErrInvalidMessage
+			}
+			resp, err = c.watchCmd(&cmd)
+		case "unwatch":
+			var cmd unwatchAdminCommand
+			err = json.Unmarshal(command.Params, &cmd)
+			if err != nil {
+				c.Unlock()
+				logger.ERROR.Println(err)
+				return ErrInvalidMessage
+			}
+			resp, err = c.unwatchCmd(&cmd)
 		default:
-			resp, err = c.app.apiCmd(command)
+			resp, err = c.apiCmd(command)
 		}
 		if err != nil {
 			c.Unlock()
@@ -184,7 +279,7 @@ func (c *adminClient) message(msg []byte) error {
 		mr = append(mr, resp)
 	}
 
-	respBytes, err := json.Marshal(mr)
+	respBytes, err := encodeJSON(mr)
 	if err != nil {
 		logger.ERROR.Println(err)
 		return ErrInternalServerError
@@ -202,10 +297,11 @@ func (c *adminClient) message(msg []byte) error {
 // registry if token correct
 func (c *adminClient) connectCmd(cmd *connectAdminCommand) (response, error) {
 
-	err := c.app.checkAdminAuthToken(cmd.Token)
+	expires, err := c.app.adminAuthTokenExpires(cmd.Token, time.Now())
 	if err != nil {
 		return nil, ErrUnauthorized
 	}
+	c.setExpires(expires)
 
 	err = c.app.addAdminConn(c)
 	if err != nil {
@@ -224,17 +320,97 @@ func (c *adminClient) connectCmd(cmd *connectAdminCommand) (response, error) {
 
 // infoCmd handles info command from admin client.
 func (c *adminClient) infoCmd() (response, error) {
+	nodes := c.app.clusterNodes()
 	c.app.nodesMu.Lock()
 	defer c.app.nodesMu.Unlock()
 	c.app.RLock()
 	defer c.app.RUnlock()
+	nss := c.app.dynamicNamespaces.list()
+	dynamicNamespaces := make([]dynamicNamespace, len(nss))
+	for i, ns := range nss {
+		dynamicNamespaces[i] = dynamicNamespace{Namespace: ns, Dynamic: true}
+	}
 	body := adminInfoBody{
-		Engine: c.app.engine.name(),
-		Config: c.app.config,
+		Engine:            c.app.engine.name(),
+		Config:            c.app.config,
+		DynamicNamespaces: dynamicNamespaces,
+		Nodes:             nodes,
 	}
 	return newAPIAdminInfoResponse(body), nil
 }
 
+// apiCmd runs API command from admin connection. Commands changing state of
+// clients or channels (actions) only allowed when admin_actions_enabled is on and
+// logged with admin connection id.
+func (c *adminClient) apiCmd(command apiCommand) (response, error) {
+	if method, ok := adminMethodAliases[command.Method]; ok {
+		command.Method = method
+	}
+	if !adminActions[command.Method] {
+		return c.app.apiCmd(command)
+	}
+	c.app.RLock()
+	actionsEnabled := c.app.config.AdminActionsEnabled
+	c.app.RUnlock()
+	if !actionsEnabled {
+		logger.ERROR.Printf("admin connection %s not allowed to run %s command as admin_actions_enabled is off", c.uid(), command.Method)
+		resp := newAPIErrorResponse(command.Method, responseError{ErrPermissionDenied, errorAdviceNone})
+		c.app.audit(c.auditEntry(), command, resp, nil)
+		return resp, nil
+	}
+	logger.INFO.Printf("admin connection %s runs %s command with params %s", c.uid(), command.Method, command.Params)
+	resp, err := c.app.apiCmd(command)
+	c.app.audit(c.auditEntry(), command, resp, err)
+	return resp, err
+}
+
+func (c *adminClient) auditEntry() auditEntry {
+	return auditEntry{Source: auditSourceAdmin, RemoteAddr: c.remoteAddr, Admin: c.uid()}
+}
+
+// watchCmd starts forwarding messages, joins and leaves of channel or channels
+// matching pattern to admin connection.
+func (c *adminClient) watchCmd(cmd *watchAdminCommand) (response, error) {
+	body := adminWatchBody{Channel: cmd.Channel, Pattern: cmd.Pattern}
+	expires, err := c.app.watchChannel(c, cmd.Channel, cmd.Pattern, time.Duration(cmd.Duration)*time.Second)
+	if err != nil {
+		logger.ERROR.Printf("error watching channel by admin connection %s: %v", c.uid(), err)
+		resp := newAPIAdminWatchResponse("watch", body)
+		resp.SetErr(responseError{ErrInvalidMessage, errorAdviceFix})
+		return resp, nil
+	}
+	body.Expires = expires.Unix()
+	return newAPIAdminWatchResponse("watch", body), nil
+}
+
+// unwatchCmd stops watch on channel or pattern, all watches of admin connection
+// stopped when both are empty.
+func (c *adminClient) unwatchCmd(cmd *unwatchAdminCommand) (response, error) {
+	c.app.unwatchChannel(c.uid(), cmd.Channel, cmd.Pattern)
+	return newAPIAdminWatchResponse("unwatch", adminWatchBody{Channel: cmd.Channel, Pattern: cmd.Pattern}), nil
+}
+
+// refreshCmd generates new admin token so admin web interface can renew its
+// session before token used to connect expires.
+func (c *adminClient) refreshCmd() (response, error) {
+	c.app.RLock()
+	insecure := c.app.config.InsecureAdmin
+	c.app.RUnlock()
+	if insecure {
+		return newAPIAdminRefreshResponse(adminRefreshBody{Token: insecureWebToken}), nil
+	}
+	token, expires, err := c.app.newAdminAuthToken(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	c.setExpires(expires)
+	body := adminRefreshBody{Token: token}
+	if !expires.IsZero() {
+		body.Expires = expires.Unix()
+	}
+	return newAPIAdminRefreshResponse(body), nil
+}
+
 // pingCmd handles ping command from admin client.
 func (c *adminClient) pingCmd() (response, error) {
 	return newAPIAdminPingResponse("pong"), nil
@@ -1,7 +1,9 @@
 package libcentrifugo
 
 import (
+	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/gorilla/securecookie"
 	"github.com/stretchr/testify/assert"
@@ -19,7 +21,8 @@ func (s *testAdminSession) Close(status uint32, reason string) error {
 
 func newAdminTestConfig() *Config {
 	return &Config{
-		AdminSecret: "secret",
+		AdminSecret:            "secret",
+		AdminAuthLegacyEnabled: true,
 	}
 }
 
@@ -78,6 +81,87 @@ func TestAdminClientMessageHandling(t *testing.T) {
 	assert.Equal(t, nil, err)
 }
 
+func TestAdminClientTokenExpiration(t *testing.T) {
+	c, err := newTestAdminClient()
+	assert.Equal(t, nil, err)
+	c.app.config.AdminAuthTTL = time.Hour
+	token, expires, err := c.app.newAdminAuthToken(time.Now().Add(-2 * time.Hour))
+	assert.Equal(t, nil, err)
+	assert.False(t, expires.IsZero())
+	err = c.message([]byte("{\"method\":\"connect\", \"params\": {\"token\":\"" + token + "\"}}"))
+	assert.Equal(t, ErrUnauthorized, err)
+
+	token, _, err = c.app.newAdminAuthToken(time.Now())
+	assert.Equal(t, nil, err)
+	err = c.message([]byte("{\"method\":\"connect\", \"params\": {\"token\":\"" + token + "\"}}"))
+	assert.Equal(t, nil, err)
+
+	// connection authenticated with token which expired meanwhile.
+	c.expires = time.Now().Add(-time.Second)
+	err = c.message([]byte("{\"method\":\"info\", \"params\": {}}"))
+	assert.Equal(t, ErrUnauthorized, err)
+
+	c.expires = time.Now().Add(time.Second)
+	resp, err := c.refreshCmd()
+	assert.Equal(t, nil, err)
+	assert.True(t, c.expires.After(time.Now().Add(59*time.Minute)))
+	data, _ := json.Marshal(resp)
+	var refresh struct {
+		Body adminRefreshBody `json:"body"`
+	}
+	json.Unmarshal(data, &refresh)
+	assert.Equal(t, c.expires.Unix(), refresh.Body.Expires)
+	assert.Equal(t, nil, c.app.checkAdminAuthToken(refresh.Body.Token))
+}
+
+type closeAdminSession struct {
+	testAdminSession
+	closed chan string
+}
+
+func (s *closeAdminSession) Close(status uint32, reason string) error {
+	s.closed <- reason
+	return nil
+}
+
+func TestAdminClientExpireTimer(t *testing.T) {
+	app := newAdminTestApplication()
+	sess := &closeAdminSession{closed: make(chan string, 1)}
+	c, err := newAdminClient(app, sess)
+	assert.Equal(t, nil, err)
+
+	c.Lock()
+	c.setExpires(time.Now().Add(20 * time.Millisecond))
+	// refresh extends expiration time so connection must not be closed
+	// at previous expiration time.
+	c.setExpires(time.Now().Add(100 * time.Millisecond))
+	c.Unlock()
+
+	select {
+	case <-sess.closed:
+		t.Fatal("connection closed before refreshed token expired")
+	case <-time.After(60 * time.Millisecond):
+	}
+
+	select {
+	case reason := <-sess.closed:
+		assert.Equal(t, "expired", reason)
+	case <-time.After(time.Second):
+		t.Fatal("connection not closed when token expired")
+	}
+}
+
+func TestAdminClientLegacyToken(t *testing.T) {
+	c, err := newTestAdminClient()
+	assert.Equal(t, nil, err)
+	c.app.config.AdminAuthLegacyEnabled = false
+	s := securecookie.New([]byte(c.app.config.AdminSecret), nil)
+	token, _ := s.Encode(AuthTokenKey, AuthTokenValue)
+	assert.Equal(t, ErrUnauthorized, c.app.checkAdminAuthToken(token))
+	c.app.config.AdminAuthLegacyEnabled = true
+	assert.Equal(t, nil, c.app.checkAdminAuthToken(token))
+}
+
 func TestAdminClientAuthentication(t *testing.T) {
 	c, err := newTestAdminClient()
 	assert.Equal(t, nil, err)
@@ -104,3 +188,28 @@ func TestAdminClientNotWatching(t *testing.T) {
 	assert.Equal(t, nil, err)
 	assert.Equal(t, false, c.watch)
 }
+
+func TestAdminClientActions(t *testing.T) {
+	c, err := newInsecureTestAdminClient()
+	assert.Equal(t, nil, err)
+	c.app.config.AdminActionsEnabled = true
+
+	resp, err := c.apiCmd(apiCommand{Method: "disconnect_user", Params: []byte(`{"user":"user1"}`)})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "disconnect", resp.(*apiDisconnectResponse).Method)
+	assert.Equal(t, nil, resp.(*apiDisconnectResponse).err)
+
+	resp, err = c.apiCmd(apiCommand{Method: "unsubscribe_user", Params: []byte(`{"user":"user1","channel":"test"}`)})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "unsubscribe", resp.(*apiUnsubscribeResponse).Method)
+
+	c.app.config.AdminActionsEnabled = false
+	resp, err = c.apiCmd(apiCommand{Method: "publish", Params: []byte(`{"channel":"test","data":{}}`)})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, ErrPermissionDenied, resp.(*apiResponse).err)
+
+	// commands returning information allowed.
+	resp, err = c.apiCmd(apiCommand{Method: "stats", Params: []byte(`{}`)})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, resp.(*apiStatsResponse).err)
+}
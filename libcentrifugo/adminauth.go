@@ -0,0 +1,81 @@
+package libcentrifugo
+
+import (
+	"sync"
+	"time"
+)
+
+// adminAuthLimiterPruneInterval is how often admin login limiter forgets addresses
+// without recent failed attempts.
+const adminAuthLimiterPruneInterval = time.Minute
+
+// adminAuthAttempts are failed admin login attempts from single address.
+type adminAuthAttempts struct {
+	failures    int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+// adminAuthLimiter counts failed admin login attempts per remote address and locks
+// out addresses exceeding allowed number of attempts with exponentially growing
+// lockout time.
+type adminAuthLimiter struct {
+	mu        sync.Mutex
+	attempts  map[string]*adminAuthAttempts
+	lastPrune time.Time
+}
+
+func newAdminAuthLimiter() *adminAuthLimiter {
+	return &adminAuthLimiter{
+		attempts: make(map[string]*adminAuthAttempts),
+	}
+}
+
+// attempt reserves login attempt of address before password checked. Attempt
+// counted as failed right away and successful login resets counter, so concurrent
+// requests from address can not check more passwords than allowed. It returns
+// false and time left until lockout ends if address locked out, otherwise number
+// of failed attempts in a row including this one and lockout time if address
+// locked out after this attempt.
+func (l *adminAuthLimiter) attempt(addr string, now time.Time, maxAttempts int, lockout, lockoutMax time.Duration) (bool, int, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastPrune) > adminAuthLimiterPruneInterval {
+		for k, a := range l.attempts {
+			// counter of address without failures during max lockout time reset.
+			if now.Sub(a.last) > lockoutMax && !now.Before(a.lockedUntil) {
+				delete(l.attempts, k)
+			}
+		}
+		l.lastPrune = now
+	}
+	a, ok := l.attempts[addr]
+	if !ok {
+		a = &adminAuthAttempts{}
+		l.attempts[addr] = a
+	}
+	if now.Before(a.lockedUntil) {
+		return false, a.failures, a.lockedUntil.Sub(now)
+	}
+	a.failures++
+	a.last = now
+	if maxAttempts <= 0 || a.failures < maxAttempts {
+		return true, a.failures, 0
+	}
+	duration := lockout
+	for i := maxAttempts; i < a.failures && duration < lockoutMax; i++ {
+		duration *= 2
+	}
+	if duration > lockoutMax {
+		duration = lockoutMax
+	}
+	a.lockedUntil = now.Add(duration)
+	return true, a.failures, duration
+}
+
+// reset forgets failed attempts of address after successful login.
+func (l *adminAuthLimiter) reset(addr string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.attempts, addr)
+}
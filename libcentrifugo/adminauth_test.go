@@ -0,0 +1,99 @@
+package libcentrifugo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminAuthLimiter(t *testing.T) {
+	l := newAdminAuthLimiter()
+	now := time.Now()
+	for i := 1; i < 3; i++ {
+		allowed, failures, locked := l.attempt("1.1.1.1", now, 3, time.Second, 3*time.Second)
+		assert.True(t, allowed)
+		assert.Equal(t, i, failures)
+		assert.Equal(t, time.Duration(0), locked)
+	}
+
+	allowed, _, locked := l.attempt("1.1.1.1", now, 3, time.Second, 3*time.Second)
+	assert.True(t, allowed)
+	assert.Equal(t, time.Second, locked)
+	allowed, _, locked = l.attempt("1.1.1.1", now, 3, time.Second, 3*time.Second)
+	assert.False(t, allowed)
+	assert.Equal(t, time.Second, locked)
+	allowed, _, _ = l.attempt("2.2.2.2", now, 3, time.Second, 3*time.Second)
+	assert.True(t, allowed)
+
+	now = now.Add(time.Second)
+	allowed, _, locked = l.attempt("1.1.1.1", now, 3, time.Second, 3*time.Second)
+	assert.True(t, allowed)
+	assert.Equal(t, 2*time.Second, locked)
+	now = now.Add(2 * time.Second)
+	_, _, locked = l.attempt("1.1.1.1", now, 3, time.Second, 3*time.Second)
+	assert.Equal(t, 3*time.Second, locked)
+
+	l.reset("1.1.1.1")
+	allowed, failures, _ := l.attempt("1.1.1.1", now, 3, time.Second, 3*time.Second)
+	assert.True(t, allowed)
+	assert.Equal(t, 1, failures)
+
+	// lockout disabled.
+	for i := 0; i < 5; i++ {
+		allowed, _, locked = l.attempt("3.3.3.3", now, 0, time.Second, 3*time.Second)
+		assert.True(t, allowed)
+		assert.Equal(t, time.Duration(0), locked)
+	}
+}
+
+func TestAdminAuthLimiterConcurrent(t *testing.T) {
+	l := newAdminAuthLimiter()
+	now := time.Now()
+	var wg sync.WaitGroup
+	var allowed int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, _, _ := l.attempt("1.1.1.1", now, 3, time.Minute, time.Hour); ok {
+				atomic.AddInt32(&allowed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	// passwords checked by concurrent requests limited by max attempts.
+	assert.Equal(t, int32(3), allowed)
+}
+
+func TestAuthHandlerLockout(t *testing.T) {
+	app := testApp()
+	app.config.AdminPassword = "password"
+	app.config.AdminSecret = "secret"
+	app.config.AdminAuthMaxAttempts = 2
+
+	login := func(password string) *httptest.ResponseRecorder {
+		values := url.Values{}
+		values.Set("password", password)
+		req, _ := http.NewRequest("POST", "/auth/", strings.NewReader(values.Encode()))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = "1.2.3.4:5000"
+		rec := httptest.NewRecorder()
+		app.AuthHandler(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusOK, login("password").Code)
+	assert.Equal(t, http.StatusBadRequest, login("wrong").Code)
+	assert.Equal(t, http.StatusBadRequest, login("wrong").Code)
+	rec := login("password")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "10", rec.Header().Get("Retry-After"))
+	assert.Equal(t, int64(3), app.metrics.NumAdminAuthRejected.LoadRaw())
+}
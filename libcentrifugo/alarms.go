@@ -0,0 +1,210 @@
+package libcentrifugo
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/FZambia/go-logger"
+)
+
+const (
+	alarmLevelOK       = "ok"
+	alarmLevelWarn     = "warn"
+	alarmLevelCritical = "critical"
+)
+
+// Names of values node watches to raise alarms.
+const (
+	alarmConnections = "connections"
+	alarmQueuedBytes = "queued_bytes"
+	alarmPublishRate = "publish_rate"
+)
+
+// alarmThresholds contains warn and critical thresholds for watched value.
+// Zero threshold means that corresponding level disabled.
+type alarmThresholds struct {
+	Warn     int64
+	Critical int64
+}
+
+// threshold returns threshold value for alarm level.
+func (t alarmThresholds) threshold(level int) int64 {
+	switch level {
+	case 1:
+		return t.Warn
+	case 2:
+		return t.Critical
+	}
+	return 0
+}
+
+// alarmEvent describes watched value crossing threshold.
+type alarmEvent struct {
+	Name      string `json:"name"`
+	Level     string `json:"level"`
+	Value     int64  `json:"value"`
+	Threshold int64  `json:"threshold"`
+	Node      string `json:"node"`
+}
+
+// alarmState keeps current level of alarm, last reported level and time when
+// alarm was reported raised last time.
+type alarmState struct {
+	level    int
+	notified int
+	raised   time.Time
+}
+
+// alarmHub keeps state of node alarms.
+type alarmHub struct {
+	sync.RWMutex
+	alarms map[string]*alarmState
+}
+
+func newAlarmHub() *alarmHub {
+	return &alarmHub{
+		alarms: make(map[string]*alarmState),
+	}
+}
+
+func alarmLevelName(level int) string {
+	switch level {
+	case 1:
+		return alarmLevelWarn
+	case 2:
+		return alarmLevelCritical
+	}
+	return alarmLevelOK
+}
+
+// check updates alarm state using current value. Alarm level raised as soon as value
+// reaches threshold and lowered only when value drops below threshold minus hysteresis
+// margin (fraction of threshold). Level always updated while raised alarm reported not
+// more often than once in interval, lowering of reported alarm always reported. It
+// returns event and true if alarm state change must be reported.
+func (h *alarmHub) check(name string, value int64, t alarmThresholds, hysteresis float64, interval time.Duration, now time.Time) (alarmEvent, bool) {
+	h.Lock()
+	defer h.Unlock()
+
+	state, ok := h.alarms[name]
+	if !ok {
+		state = &alarmState{}
+		h.alarms[name] = state
+	}
+
+	target := 0
+	if t.Critical > 0 && value >= t.Critical {
+		target = 2
+	} else if t.Warn > 0 && value >= t.Warn {
+		target = 1
+	}
+
+	level := state.level
+	if target > level {
+		level = target
+	} else {
+		for level > target {
+			threshold := t.threshold(level)
+			if threshold > 0 && float64(value) >= float64(threshold)*(1-hysteresis) {
+				break
+			}
+			level--
+		}
+	}
+
+	state.level = level
+	if level == state.notified {
+		return alarmEvent{}, false
+	}
+
+	raised := level > state.notified
+	if raised {
+		// alarm stays active when its report suppressed and reported by next
+		// check after interval passed.
+		if !state.raised.IsZero() && now.Sub(state.raised) < interval {
+			return alarmEvent{}, false
+		}
+		state.raised = now
+	}
+	state.notified = level
+
+	threshold := t.threshold(level)
+	if !raised {
+		threshold = t.threshold(level + 1)
+	}
+	return alarmEvent{
+		Name:      name,
+		Level:     alarmLevelName(level),
+		Value:     value,
+		Threshold: threshold,
+	}, true
+}
+
+// active returns levels of currently raised alarms.
+func (h *alarmHub) active() map[string]string {
+	h.RLock()
+	defer h.RUnlock()
+	active := make(map[string]string)
+	for name, state := range h.alarms {
+		if state.level > 0 {
+			active[name] = alarmLevelName(state.level)
+		}
+	}
+	return active
+}
+
+// checkAlarms evaluates alarm thresholds using current node metrics. Called every
+// metrics interval after metrics snapshot updated.
+func (app *Application) checkAlarms() {
+	app.RLock()
+	c := app.config
+	interval := c.NodeMetricsInterval
+	thresholds := map[string]alarmThresholds{
+		alarmConnections: {c.AlarmConnectionsWarn, c.AlarmConnectionsCritical},
+		alarmQueuedBytes: {c.AlarmQueuedBytesWarn, c.AlarmQueuedBytesCritical},
+		alarmPublishRate: {c.AlarmPublishRateWarn, c.AlarmPublishRateCritical},
+	}
+	hysteresis := c.AlarmHysteresis
+	alarmInterval := c.AlarmInterval
+	app.RUnlock()
+
+	var publishRate int64
+	if seconds := int64(interval.Seconds()); seconds > 0 {
+		publishRate = app.metrics.GetSnapshotMetrics().NumMsgPublished / seconds
+	}
+
+	values := map[string]int64{
+		alarmConnections: int64(app.clients.nClients()),
+		alarmQueuedBytes: app.clients.queuedBytes(),
+		alarmPublishRate: publishRate,
+	}
+
+	now := time.Now()
+	for _, name := range []string{alarmConnections, alarmQueuedBytes, alarmPublishRate} {
+		event, ok := app.alarms.check(name, values[name], thresholds[name], hysteresis, alarmInterval, now)
+		if !ok {
+			continue
+		}
+		event.Node = app.uid
+		app.pubAlarm(event)
+	}
+}
+
+// pubAlarm logs alarm event and sends it to admins.
+func (app *Application) pubAlarm(event alarmEvent) {
+	switch event.Level {
+	case alarmLevelCritical:
+		logger.CRITICAL.Printf("alarm name=%s level=%s value=%d threshold=%d", event.Name, event.Level, event.Value, event.Threshold)
+	case alarmLevelWarn:
+		logger.WARN.Printf("alarm name=%s level=%s value=%d threshold=%d", event.Name, event.Level, event.Value, event.Threshold)
+	default:
+		logger.INFO.Printf("alarm name=%s level=%s value=%d threshold=%d", event.Name, event.Level, event.Value, event.Threshold)
+	}
+	byteEvent, err := json.Marshal(event)
+	if err != nil {
+		logger.ERROR.Println(err)
+		return
+	}
+	app.pubAdmin("alarm", byteEvent)
+}
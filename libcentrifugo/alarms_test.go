@@ -0,0 +1,140 @@
+package libcentrifugo
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testAlarmAdminConn struct {
+	messages [][]byte
+}
+
+func (c *testAlarmAdminConn) uid() ConnID {
+	return "alarm admin"
+}
+
+func (c *testAlarmAdminConn) send(message []byte) error {
+	c.messages = append(c.messages, message)
+	return nil
+}
+
+func TestAlarmHubCheck(t *testing.T) {
+	h := newAlarmHub()
+	thresholds := alarmThresholds{Warn: 100, Critical: 200}
+	now := time.Now()
+
+	_, ok := h.check("test", 50, thresholds, 0.1, 0, now)
+	assert.False(t, ok)
+
+	event, ok := h.check("test", 100, thresholds, 0.1, 0, now)
+	assert.True(t, ok)
+	assert.Equal(t, alarmLevelWarn, event.Level)
+	assert.Equal(t, int64(100), event.Threshold)
+	assert.Equal(t, map[string]string{"test": alarmLevelWarn}, h.active())
+
+	event, ok = h.check("test", 250, thresholds, 0.1, 0, now)
+	assert.True(t, ok)
+	assert.Equal(t, alarmLevelCritical, event.Level)
+
+	// value is below critical threshold but inside hysteresis margin.
+	_, ok = h.check("test", 190, thresholds, 0.1, 0, now)
+	assert.False(t, ok)
+	assert.Equal(t, map[string]string{"test": alarmLevelCritical}, h.active())
+
+	event, ok = h.check("test", 150, thresholds, 0.1, 0, now)
+	assert.True(t, ok)
+	assert.Equal(t, alarmLevelWarn, event.Level)
+	assert.Equal(t, int64(200), event.Threshold)
+
+	_, ok = h.check("test", 95, thresholds, 0.1, 0, now)
+	assert.False(t, ok)
+
+	event, ok = h.check("test", 80, thresholds, 0.1, 0, now)
+	assert.True(t, ok)
+	assert.Equal(t, alarmLevelOK, event.Level)
+	assert.Equal(t, 0, len(h.active()))
+
+	// dropping straight from critical below warn margin clears alarm.
+	h.check("test", 300, thresholds, 0.1, 0, now)
+	event, ok = h.check("test", 10, thresholds, 0.1, 0, now)
+	assert.True(t, ok)
+	assert.Equal(t, alarmLevelOK, event.Level)
+}
+
+func TestAlarmHubRateLimit(t *testing.T) {
+	h := newAlarmHub()
+	thresholds := alarmThresholds{Warn: 100}
+	now := time.Now()
+
+	_, ok := h.check("test", 100, thresholds, 0, time.Minute, now)
+	assert.True(t, ok)
+	_, ok = h.check("test", 10, thresholds, 0, time.Minute, now)
+	assert.True(t, ok)
+
+	// repeated alarm inside interval stored but not reported.
+	_, ok = h.check("test", 100, thresholds, 0, time.Minute, now.Add(time.Second))
+	assert.False(t, ok)
+	assert.Equal(t, map[string]string{"test": alarmLevelWarn}, h.active())
+	// so its clearing not reported too.
+	_, ok = h.check("test", 10, thresholds, 0, time.Minute, now.Add(2*time.Second))
+	assert.False(t, ok)
+	assert.Equal(t, map[string]string{}, h.active())
+
+	// alarm still active after interval reported.
+	_, ok = h.check("test", 100, thresholds, 0, time.Minute, now.Add(3*time.Second))
+	assert.False(t, ok)
+	event, ok := h.check("test", 100, thresholds, 0, time.Minute, now.Add(2*time.Minute))
+	assert.True(t, ok)
+	assert.Equal(t, alarmLevelWarn, event.Level)
+}
+
+func TestCheckAlarms(t *testing.T) {
+	c := newTestConfig()
+	c.NodeMetricsInterval = time.Second
+	c.AlarmConnectionsWarn = 2
+	c.AlarmConnectionsCritical = 4
+	c.AlarmPublishRateWarn = 5
+	c.AlarmQueuedBytesWarn = 100
+	app := testMemoryAppWithConfig(&c)
+	admin := &testAlarmAdminConn{}
+	app.admins.add(admin)
+
+	app.clients.add(&testClientConn{CID: "1", UID: "1"})
+	app.updateMetricsOnce()
+	assert.Equal(t, 0, len(admin.messages))
+
+	app.clients.add(&testClientConn{CID: "2", UID: "2", Queued: 150})
+	for i := 0; i < 10; i++ {
+		app.metrics.NumMsgPublished.Inc()
+	}
+	app.updateMetricsOnce()
+	assert.Equal(t, 3, len(admin.messages))
+
+	var resp struct {
+		Method string     `json:"method"`
+		Body   alarmEvent `json:"body"`
+	}
+	err := json.Unmarshal(admin.messages[0], &resp)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "alarm", resp.Method)
+	assert.Equal(t, alarmConnections, resp.Body.Name)
+	assert.Equal(t, alarmLevelWarn, resp.Body.Level)
+	assert.Equal(t, int64(2), resp.Body.Value)
+	assert.Equal(t, app.uid, resp.Body.Node)
+	assert.Equal(t, map[string]string{
+		alarmConnections: alarmLevelWarn,
+		alarmQueuedBytes: alarmLevelWarn,
+		alarmPublishRate: alarmLevelWarn,
+	}, app.alarms.active())
+
+	// publish rate dropped to zero in last interval, alarm cleared.
+	app.updateMetricsOnce()
+	assert.Equal(t, 4, len(admin.messages))
+	err = json.Unmarshal(admin.messages[3], &resp)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, alarmPublishRate, resp.Body.Name)
+	assert.Equal(t, alarmLevelOK, resp.Body.Level)
+}
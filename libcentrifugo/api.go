@@ -4,10 +4,17 @@ import (
 	"encoding/json"
 
 	"github.com/FZambia/go-logger"
+	"github.com/centrifugal/centrifugo/libcentrifugo/raw"
 )
 
 // apiCmd builds API command and dispatches it into correct handler method.
 func (app *Application) apiCmd(command apiCommand) (response, error) {
+	return app.tracedAPICmd(command, nil)
+}
+
+// tracedAPICmd runs API command recording message publishing as children of span
+// sp if it is not nil.
+func (app *Application) tracedAPICmd(command apiCommand, sp *span) (response, error) {
 
 	var err error
 	var resp response
@@ -23,7 +30,7 @@ func (app *Application) apiCmd(command apiCommand) (response, error) {
 			logger.ERROR.Println(err)
 			return nil, ErrInvalidMessage
 		}
-		resp, err = app.publishCmd(&cmd)
+		resp, err = app.publishCmd(&cmd, sp)
 	case "broadcast":
 		var cmd broadcastAPICommand
 		err = json.Unmarshal(params, &cmd)
@@ -31,7 +38,7 @@ func (app *Application) apiCmd(command apiCommand) (response, error) {
 			logger.ERROR.Println(err)
 			return nil, ErrInvalidMessage
 		}
-		resp, err = app.broadcastCmd(&cmd)
+		resp, err = app.broadcastCmd(&cmd, sp)
 	case "unsubscribe":
 		var cmd unsubscribeAPICommand
 		err = json.Unmarshal(params, &cmd)
@@ -48,6 +55,54 @@ func (app *Application) apiCmd(command apiCommand) (response, error) {
 			return nil, ErrInvalidMessage
 		}
 		resp, err = app.disconnectCmd(&cmd)
+	case "delivery_state":
+		var cmd deliveryStateAPICommand
+		err = json.Unmarshal(params, &cmd)
+		if err != nil {
+			logger.ERROR.Println(err)
+			return nil, ErrInvalidMessage
+		}
+		resp, err = app.deliveryStateCmd(&cmd)
+	case "disconnect_client":
+		var cmd disconnectClientAPICommand
+		err = json.Unmarshal(params, &cmd)
+		if err != nil {
+			logger.ERROR.Println(err)
+			return nil, ErrInvalidMessage
+		}
+		resp, err = app.disconnectClientCmd(&cmd)
+	case "user_connections":
+		var cmd userConnectionsAPICommand
+		err = json.Unmarshal(params, &cmd)
+		if err != nil {
+			logger.ERROR.Println(err)
+			return nil, ErrInvalidMessage
+		}
+		resp, err = app.userConnectionsCmd(&cmd)
+	case "disconnect_bulk":
+		var cmd disconnectBulkAPICommand
+		err = json.Unmarshal(params, &cmd)
+		if err != nil {
+			logger.ERROR.Println(err)
+			return nil, ErrInvalidMessage
+		}
+		resp, err = app.disconnectBulkCmd(&cmd)
+	case "replay":
+		var cmd replayAPICommand
+		err = json.Unmarshal(params, &cmd)
+		if err != nil {
+			logger.ERROR.Println(err)
+			return nil, ErrInvalidMessage
+		}
+		resp, err = app.replayCmd(&cmd)
+	case "replay_cancel":
+		var cmd replayCancelAPICommand
+		err = json.Unmarshal(params, &cmd)
+		if err != nil {
+			logger.ERROR.Println(err)
+			return nil, ErrInvalidMessage
+		}
+		resp, err = app.replayCancelCmd(&cmd)
 	case "presence":
 		var cmd presenceAPICommand
 		err = json.Unmarshal(params, &cmd)
@@ -64,12 +119,32 @@ func (app *Application) apiCmd(command apiCommand) (response, error) {
 			return nil, ErrInvalidMessage
 		}
 		resp, err = app.historyCmd(&cmd)
+	case "channel_info":
+		var cmd channelInfoAPICommand
+		err = json.Unmarshal(params, &cmd)
+		if err != nil {
+			logger.ERROR.Println(err)
+			return nil, ErrInvalidMessage
+		}
+		resp, err = app.channelInfoCmd(&cmd)
 	case "channels":
-		resp, err = app.channelsCmd()
+		var cmd channelsAPICommand
+		if len(params) > 0 {
+			err = json.Unmarshal(params, &cmd)
+			if err != nil {
+				logger.ERROR.Println(err)
+				return nil, ErrInvalidMessage
+			}
+		}
+		resp, err = app.channelsCmd(&cmd)
 	case "stats":
 		resp, err = app.statsCmd()
 	case "node":
 		resp, err = app.nodeCmd()
+	case "nodes":
+		resp, err = app.nodesCmd()
+	case "diag":
+		resp, err = app.diagCmd()
 	default:
 		return nil, ErrMethodNotFound
 	}
@@ -83,20 +158,44 @@ func (app *Application) apiCmd(command apiCommand) (response, error) {
 }
 
 // publishCmd publishes data into channel.
-func (app *Application) publishCmd(cmd *publishAPICommand) (response, error) {
+func (app *Application) publishCmd(cmd *publishAPICommand, sp *span) (response, error) {
 	channel := cmd.Channel
 	data := cmd.Data
-	err := app.publish(channel, data, cmd.Client, nil, false)
+	if cmd.Async {
+		resp := newAPIPublishResponse()
+		err := app.publishQueued([]Channel{channel}, data, cmd.Encoding, cmd.Client)
+		if err == ErrQueueFull {
+			return nil, err
+		}
+		if err == ErrLimitExceeded {
+			resp.(*apiPublishResponse).Body = apiPublishBody{
+				Size: len(data),
+			}
+		}
+		if err != nil {
+			resp.SetErr(responseError{err, errorAdviceNone})
+		}
+		return resp, nil
+	}
+	uid, err := app.publish(channel, data, cmd.Encoding, cmd.Client, nil, false, sp)
 	resp := newAPIPublishResponse()
+	if err == ErrLimitExceeded {
+		resp.(*apiPublishResponse).Body = apiPublishBody{
+			Size: len(data),
+		}
+	}
 	if err != nil {
 		resp.SetErr(responseError{err, errorAdviceNone})
 		return resp, nil
 	}
+	resp.(*apiPublishResponse).Body = apiPublishBody{
+		UID: uid,
+	}
 	return resp, nil
 }
 
 // broadcastCmd publishes data into multiple channels.
-func (app *Application) broadcastCmd(cmd *broadcastAPICommand) (response, error) {
+func (app *Application) broadcastCmd(cmd *broadcastAPICommand, sp *span) (response, error) {
 	resp := newAPIBroadcastResponse()
 	channels := cmd.Channels
 	data := cmd.Data
@@ -105,9 +204,19 @@ func (app *Application) broadcastCmd(cmd *broadcastAPICommand) (response, error)
 		resp.SetErr(responseError{ErrInvalidMessage, errorAdviceNone})
 		return resp, nil
 	}
+	if cmd.Async {
+		err := app.publishQueued(channels, data, "", cmd.Client)
+		if err == ErrQueueFull {
+			return nil, err
+		}
+		if err != nil {
+			resp.SetErr(responseError{err, errorAdviceNone})
+		}
+		return resp, nil
+	}
 	errs := make([]<-chan error, len(channels))
 	for i, channel := range channels {
-		errs[i] = app.publishAsync(channel, data, cmd.Client, nil, false)
+		_, errs[i] = app.publishAsync(channel, data, "", cmd.Client, nil, false, sp)
 	}
 	var firstErr error
 	for i := range errs {
@@ -128,10 +237,8 @@ func (app *Application) broadcastCmd(cmd *broadcastAPICommand) (response, error)
 // unsubscribeCmd unsubscribes project's user from channel and sends
 // unsubscribe control message to other nodes.
 func (app *Application) unsubcribeCmd(cmd *unsubscribeAPICommand) (response, error) {
-	resp := newAPIUnsubscribeResponse()
-	channel := cmd.Channel
-	user := cmd.User
-	err := app.Unsubscribe(user, channel)
+	affected, err := app.unsubscribe(cmd.User, cmd.Channel)
+	resp := newAPIUnsubscribeResponse(unsubscribeAPIBody{Affected: affected})
 	if err != nil {
 		resp.SetErr(responseError{err, errorAdviceNone})
 		return resp, nil
@@ -143,8 +250,7 @@ func (app *Application) unsubcribeCmd(cmd *unsubscribeAPICommand) (response, err
 // control message to other nodes so they could also disconnect this user.
 func (app *Application) disconnectCmd(cmd *disconnectAPICommand) (response, error) {
 	resp := newAPIDisconnectResponse()
-	user := cmd.User
-	err := app.Disconnect(user)
+	err := app.disconnect(cmd.User, cmd.Reconnect)
 	if err != nil {
 		resp.SetErr(responseError{err, errorAdviceNone})
 		return resp, nil
@@ -152,6 +258,106 @@ func (app *Application) disconnectCmd(cmd *disconnectAPICommand) (response, erro
 	return resp, nil
 }
 
+// disconnectBulkCmd disconnects all connections matching filter on this node and
+// sends control message to other nodes so they could do the same. In dry run
+// mode it only returns number of matching connections on this node.
+func (app *Application) disconnectBulkCmd(cmd *disconnectBulkAPICommand) (response, error) {
+	body := disconnectBulkBody{
+		DryRun: cmd.DryRun,
+	}
+	if cmd.disconnectFilter.empty() {
+		return nil, ErrInvalidMessage
+	}
+	if cmd.DryRun {
+		body.Matched = len(app.clients.filterConnections(cmd.disconnectFilter))
+		return newAPIDisconnectBulkResponse(body), nil
+	}
+	matched, err := app.DisconnectBulk(cmd.disconnectFilter)
+	body.Matched = matched
+	if err != nil {
+		resp := newAPIDisconnectBulkResponse(body)
+		resp.SetErr(responseError{err, errorAdviceNone})
+		return resp, nil
+	}
+	return newAPIDisconnectBulkResponse(body), nil
+}
+
+// deliveryStateCmd returns state of messages waiting for acknowledgement from
+// user connections on this node.
+func (app *Application) deliveryStateCmd(cmd *deliveryStateAPICommand) (response, error) {
+	if cmd.User == "" {
+		return nil, ErrInvalidMessage
+	}
+	body := deliveryStateBody{
+		User: cmd.User,
+		Data: app.DeliveryState(cmd.User),
+	}
+	return newAPIDeliveryStateResponse(body), nil
+}
+
+// disconnectClientCmd closes single connection on any node.
+func (app *Application) disconnectClientCmd(cmd *disconnectClientAPICommand) (response, error) {
+	if cmd.Client == "" {
+		return nil, ErrInvalidMessage
+	}
+	body := disconnectClientBody{
+		Client: cmd.Client,
+	}
+	found, err := app.DisconnectClient(cmd.Client, cmd.Reconnect)
+	body.Found = found
+	resp := newAPIDisconnectClientResponse(body)
+	if err != nil {
+		resp.SetErr(responseError{err, errorAdviceNone})
+	}
+	return resp, nil
+}
+
+// userConnectionsCmd returns connections of user on all nodes which answered
+// in time.
+func (app *Application) userConnectionsCmd(cmd *userConnectionsAPICommand) (response, error) {
+	conns, complete, err := app.UserConnections(cmd.User)
+	if err != nil {
+		return nil, err
+	}
+	body := userConnectionsBody{
+		User:       cmd.User,
+		Data:       conns,
+		Incomplete: !complete,
+	}
+	return newAPIUserConnectionsResponse(body), nil
+}
+
+// replayCmd starts republishing history of source channel into target channel.
+func (app *Application) replayCmd(cmd *replayAPICommand) (response, error) {
+	if cmd.SourceChannel == "" || cmd.TargetChannel == "" || cmd.SourceChannel == cmd.TargetChannel || cmd.Speed < 0 {
+		return nil, ErrInvalidMessage
+	}
+	body := replayBody{
+		SourceChannel: cmd.SourceChannel,
+		TargetChannel: cmd.TargetChannel,
+	}
+	total, err := app.startReplay(cmd.SourceChannel, cmd.TargetChannel, cmd.Speed, cmd.Since)
+	if err != nil {
+		resp := newAPIReplayResponse(body)
+		resp.SetErr(responseError{err, errorAdviceNone})
+		return resp, nil
+	}
+	body.Total = total
+	return newAPIReplayResponse(body), nil
+}
+
+// replayCancelCmd stops replay into target channel.
+func (app *Application) replayCancelCmd(cmd *replayCancelAPICommand) (response, error) {
+	if cmd.TargetChannel == "" {
+		return nil, ErrInvalidMessage
+	}
+	body := replayCancelBody{
+		TargetChannel: cmd.TargetChannel,
+		Cancelled:     app.cancelReplay(cmd.TargetChannel),
+	}
+	return newAPIReplayCancelResponse(body), nil
+}
+
 // presenceCmd returns response with presense information for channel.
 func (app *Application) presenceCmd(cmd *presenceAPICommand) (response, error) {
 	channel := cmd.Channel
@@ -184,16 +390,85 @@ func (app *Application) historyCmd(cmd *historyAPICommand) (response, error) {
 	return newAPIHistoryResponse(body), nil
 }
 
-// channelsCmd returns active channels.
-func (app *Application) channelsCmd() (response, error) {
+// channelInfoCmd returns response with information about channel: number of
+// subscribers in cluster, resolved channel options and presence and history
+// summaries when they are enabled for channel.
+func (app *Application) channelInfoCmd(cmd *channelInfoAPICommand) (response, error) {
+	channel := cmd.Channel
+	body := channelInfoBody{
+		Channel: channel,
+	}
+	if string(channel) == "" {
+		return nil, ErrInvalidMessage
+	}
+	numSubscribers, err := app.NumSubscribers(channel)
+	if err != nil {
+		logger.ERROR.Println(err)
+		resp := newAPIChannelInfoResponse(body)
+		resp.SetErr(responseError{ErrInternalServerError, errorAdviceNone})
+		return resp, nil
+	}
+	body.NumSubscribers = numSubscribers
+
+	chOpts, err := app.channelOpts(channel)
+	if err != nil {
+		resp := newAPIChannelInfoResponse(body)
+		resp.SetErr(responseError{err, errorAdviceNone})
+		return resp, nil
+	}
+	body.Options = &chOpts
+
+	if chOpts.Presence {
+		numClients, numUsers, err := app.PresenceStats(channel)
+		if err != nil {
+			resp := newAPIChannelInfoResponse(body)
+			resp.SetErr(responseError{err, errorAdviceNone})
+			return resp, nil
+		}
+		body.Presence = &channelPresenceInfo{
+			NumClients: numClients,
+			NumUsers:   numUsers,
+		}
+	}
+
+	if chOpts.HistorySize > 0 && chOpts.HistoryLifetime > 0 {
+		info, err := app.historyInfo(channel)
+		if err != nil {
+			resp := newAPIChannelInfoResponse(body)
+			resp.SetErr(responseError{err, errorAdviceNone})
+			return resp, nil
+		}
+		body.History = info
+	}
+	return newAPIChannelInfoResponse(body), nil
+}
+
+// channelsDefaultLimit is a max number of channels returned by channels command
+// when limit not provided.
+const channelsDefaultLimit = 1000
+
+// channelsCmd returns active channels matching optional pattern.
+func (app *Application) channelsCmd(cmd *channelsAPICommand) (response, error) {
+	if cmd.Limit < 0 {
+		return nil, ErrInvalidMessage
+	}
+	limit := cmd.Limit
+	if limit == 0 {
+		limit = channelsDefaultLimit
+	}
+
 	body := channelsBody{}
-	channels, err := app.channels()
+	channels, err := app.channelsByPattern(cmd.Pattern)
 	if err != nil {
 		logger.ERROR.Println(err)
 		resp := newAPIChannelsResponse(body)
 		resp.SetErr(responseError{ErrInternalServerError, errorAdviceNone})
 		return resp, nil
 	}
+	if len(channels) > limit {
+		channels = channels[:limit]
+		body.Truncated = true
+	}
 	body.Data = channels
 	return newAPIChannelsResponse(body), nil
 }
@@ -205,9 +480,28 @@ func (app *Application) statsCmd() (response, error) {
 	return newAPIStatsResponse(body), nil
 }
 
+// diagCmd returns diagnostic bundle of current node with secrets redacted.
+func (app *Application) diagCmd() (response, error) {
+	data, err := app.diag()
+	if err != nil {
+		logger.ERROR.Println(err)
+		return nil, ErrInternalServerError
+	}
+	body := diagBody{}
+	body.Data = raw.Raw(data)
+	return newAPIDiagResponse(body), nil
+}
+
 // nodeCmd returns simple counter metrics which update in real time for the current node only.
 func (app *Application) nodeCmd() (response, error) {
 	body := nodeBody{}
 	body.Data = app.node()
 	return newAPINodeResponse(body), nil
 }
+
+// nodesCmd returns short information about all known nodes of cluster.
+func (app *Application) nodesCmd() (response, error) {
+	body := nodesBody{}
+	body.Data = app.clusterNodes()
+	return newAPINodesResponse(body), nil
+}
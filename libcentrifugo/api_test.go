@@ -3,7 +3,10 @@ package libcentrifugo
 import (
 	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -131,6 +134,37 @@ func TestAPICmd(t *testing.T) {
 	resp, err = app.apiCmd(cmd)
 	assert.Equal(t, nil, err)
 	assert.Equal(t, nil, resp.(*apiNodeResponse).err)
+
+	cmd = apiCommand{
+		Method: "nodes",
+		Params: []byte("{}"),
+	}
+	resp, err = app.apiCmd(cmd)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, resp.(*apiNodesResponse).err)
+}
+
+func TestAPINodes(t *testing.T) {
+	app := testApp()
+	now := time.Now().Unix()
+	app.pingCmd(&pingControlCommand{Info: nodeInfo{UID: "2", Name: "node2", Clients: 10, Started: now - 100}})
+	app.pingCmd(&pingControlCommand{Info: nodeInfo{UID: "1", Name: "node1", Clients: 5}})
+	app.nodesMu.Lock()
+	info := app.nodes["1"]
+	info.updated = now - int64(app.config.NodeInfoMaxDelay.Seconds()) - 1
+	app.nodes["1"] = info
+	app.nodesMu.Unlock()
+
+	resp, err := app.nodesCmd()
+	assert.Equal(t, nil, err)
+	nodes := resp.(*apiNodesResponse).Body.Data
+	assert.Equal(t, 2, len(nodes))
+	assert.Equal(t, "node1", nodes[0].Name)
+	assert.True(t, nodes[0].Stale)
+	assert.Equal(t, "node2", nodes[1].Name)
+	assert.False(t, nodes[1].Stale)
+	assert.Equal(t, 10, nodes[1].Clients)
+	assert.True(t, nodes[1].Uptime >= 100)
 }
 
 func TestAPIPublish(t *testing.T) {
@@ -139,39 +173,60 @@ func TestAPIPublish(t *testing.T) {
 		Channel: "channel",
 		Data:    []byte("null"),
 	}
-	resp, err := app.publishCmd(cmd)
+	resp, err := app.publishCmd(cmd, nil)
 	assert.Equal(t, nil, err)
 	assert.Equal(t, nil, resp.(*apiPublishResponse).err)
+	assert.NotEqual(t, "", resp.(*apiPublishResponse).Body.(apiPublishBody).UID)
 	cmd = &publishAPICommand{
 		Channel: "nonexistentnamespace:channel-2",
 		Data:    []byte("null"),
 	}
-	resp, err = app.publishCmd(cmd)
+	resp, err = app.publishCmd(cmd, nil)
 	assert.Equal(t, nil, err)
 	assert.Equal(t, ErrNamespaceNotFound, resp.(*apiPublishResponse).err)
 }
 
+func TestAPIPublishMaxMessageSize(t *testing.T) {
+	app := testApp()
+	app.config.Namespaces[0].MaxMessageSize = 4
+	for _, async := range []bool{false, true} {
+		cmd := &publishAPICommand{
+			Channel: "test:channel",
+			Data:    []byte(`"12345"`),
+			Async:   async,
+		}
+		resp, err := app.publishCmd(cmd, nil)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, ErrLimitExceeded, resp.(*apiPublishResponse).err)
+		assert.Equal(t, 7, resp.(*apiPublishResponse).Body.(apiPublishBody).Size)
+	}
+	// limit of namespace does not apply to other channels.
+	resp, err := app.publishCmd(&publishAPICommand{Channel: "channel", Data: []byte(`"12345"`)}, nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, resp.(*apiPublishResponse).err)
+}
+
 func TestAPIBroadcast(t *testing.T) {
 	app := testApp()
 	cmd := &broadcastAPICommand{
 		Channels: []Channel{"channel-1", "channel-2"},
 		Data:     []byte("null"),
 	}
-	resp, err := app.broadcastCmd(cmd)
+	resp, err := app.broadcastCmd(cmd, nil)
 	assert.Equal(t, nil, err)
 	assert.Equal(t, nil, resp.(*apiBroadcastResponse).err)
 	cmd = &broadcastAPICommand{
 		Channels: []Channel{"channel-1", "nonexistentnamespace:channel-2"},
 		Data:     []byte("null"),
 	}
-	resp, err = app.broadcastCmd(cmd)
+	resp, err = app.broadcastCmd(cmd, nil)
 	assert.Equal(t, nil, err)
 	assert.Equal(t, ErrNamespaceNotFound, resp.(*apiBroadcastResponse).err)
 	cmd = &broadcastAPICommand{
 		Channels: []Channel{},
 		Data:     []byte("null"),
 	}
-	resp, err = app.broadcastCmd(cmd)
+	resp, err = app.broadcastCmd(cmd, nil)
 	assert.Equal(t, nil, err)
 	assert.Equal(t, ErrInvalidMessage, resp.(*apiBroadcastResponse).err)
 }
@@ -196,6 +251,36 @@ func TestAPIUnsubscribe(t *testing.T) {
 	assert.Equal(t, nil, resp.(*apiUnsubscribeResponse).err)
 }
 
+func TestAPIUnsubscribeAffected(t *testing.T) {
+	app := testApp()
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	var clients []*client
+	for i := 0; i < 3; i++ {
+		c, err := newClient(app, &testSession{})
+		assert.Equal(t, nil, err)
+		cmds := []clientCommand{testConnectCmd(timestamp), testSubscribeCmd("other")}
+		if i < 2 {
+			cmds = append(cmds, testSubscribeCmd("test"))
+		}
+		assert.Equal(t, nil, c.handleCommands(cmds))
+		clients = append(clients, c)
+	}
+
+	resp, err := app.unsubcribeCmd(&unsubscribeAPICommand{User: "user1", Channel: "test"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, resp.(*apiUnsubscribeResponse).Body.Affected)
+	for _, c := range clients {
+		assert.Equal(t, []Channel{"other"}, c.channels())
+	}
+
+	resp, err = app.unsubcribeCmd(&unsubscribeAPICommand{User: "user1"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, resp.(*apiUnsubscribeResponse).Body.Affected)
+	for _, c := range clients {
+		assert.Equal(t, 0, len(c.channels()))
+	}
+}
+
 func TestAPIDisconnect(t *testing.T) {
 	app := testApp()
 	cmd := &disconnectAPICommand{
@@ -204,6 +289,66 @@ func TestAPIDisconnect(t *testing.T) {
 	resp, err := app.disconnectCmd(cmd)
 	assert.Equal(t, nil, err)
 	assert.Equal(t, nil, resp.(*apiDisconnectResponse).err)
+
+	app = testMemoryApp()
+	c := newTestUserCC()
+	app.clients.add(c)
+	params, _ := json.Marshal(disconnectAPICommand{User: UserID(c.UID), Reconnect: true})
+	_, err = app.apiCmd(apiCommand{Method: "disconnect", Params: params})
+	assert.Equal(t, nil, err)
+	assert.True(t, c.Closed)
+	assert.True(t, c.Reconnect)
+}
+
+func TestAPIDisconnectBulk(t *testing.T) {
+	app := testMemoryApp()
+	c := &testClientConn{CID: "1", UID: "user-1", Transport: "jsonp"}
+	app.clients.add(c)
+
+	// at least one filter condition required.
+	_, err := app.disconnectBulkCmd(&disconnectBulkAPICommand{DryRun: true})
+	assert.Equal(t, ErrInvalidMessage, err)
+
+	cmd := &disconnectBulkAPICommand{
+		disconnectFilter: disconnectFilter{UserPrefix: "user-"},
+		DryRun:           true,
+	}
+	resp, err := app.disconnectBulkCmd(cmd)
+	assert.Equal(t, nil, err)
+	body := resp.(*apiDisconnectBulkResponse).Body
+	assert.Equal(t, 1, body.Matched)
+	assert.Equal(t, true, body.DryRun)
+	// dry run does not disconnect anyone.
+	assert.False(t, c.Closed)
+
+	resp, err = app.apiCmd(apiCommand{
+		Method: "disconnect_bulk",
+		Params: []byte(`{"transport": "jsonp", "dry_run": true}`),
+	})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, resp.(*apiDisconnectBulkResponse).Body.Matched)
+}
+
+func TestAPIDeliveryState(t *testing.T) {
+	app := testMemoryApp()
+	c := &testClientConn{CID: "1", UID: "user", Acks: newAckWindow(10, 0)}
+	app.clients.add(c)
+	app.clients.add(&testClientConn{CID: "2", UID: "user"})
+	c.Acks.add("token", "channel", time.Now())
+
+	_, err := app.deliveryStateCmd(&deliveryStateAPICommand{})
+	assert.Equal(t, ErrInvalidMessage, err)
+
+	resp, err := app.apiCmd(apiCommand{
+		Method: "delivery_state",
+		Params: []byte(`{"user": "user"}`),
+	})
+	assert.Equal(t, nil, err)
+	body := resp.(*apiDeliveryStateResponse).Body
+	assert.Equal(t, UserID("user"), body.User)
+	assert.Equal(t, 1, len(body.Data))
+	assert.Equal(t, 1, body.Data[0].Outstanding)
+	assert.Equal(t, "token", body.Data[0].Unacked[0].Token)
 }
 
 func TestAPIPresence(t *testing.T) {
@@ -228,15 +373,118 @@ func TestAPIHistory(t *testing.T) {
 
 func TestAPIChannels(t *testing.T) {
 	app := testApp()
-	resp, err := app.channelsCmd()
+	resp, err := app.channelsCmd(&channelsAPICommand{})
 	assert.Equal(t, nil, err)
 	assert.Equal(t, nil, resp.(*apiChannelsResponse).err)
 	app = testMemoryApp()
 	createTestClients(app, 10, 1, nil)
-	resp, err = app.channelsCmd()
+	resp, err = app.channelsCmd(&channelsAPICommand{})
 	assert.Equal(t, nil, err)
 	body := resp.(*apiChannelsResponse).Body
 	assert.Equal(t, 10, len(body.Data))
+	assert.Equal(t, false, body.Truncated)
+}
+
+func TestAPIChannelsPattern(t *testing.T) {
+	app := testMemoryApp()
+	createTestClients(app, 20, 1, nil)
+
+	resp, err := app.channelsCmd(&channelsAPICommand{Pattern: "channel-1*"})
+	assert.Equal(t, nil, err)
+	body := resp.(*apiChannelsResponse).Body
+	// channel-1 and channel-10...channel-19.
+	assert.Equal(t, 11, len(body.Data))
+	for _, ch := range body.Data {
+		assert.True(t, strings.HasPrefix(string(ch), "channel-1"))
+	}
+
+	resp, err = app.channelsCmd(&channelsAPICommand{Pattern: "channel-1*", Limit: 5})
+	assert.Equal(t, nil, err)
+	body = resp.(*apiChannelsResponse).Body
+	assert.Equal(t, 5, len(body.Data))
+	assert.Equal(t, true, body.Truncated)
+
+	_, err = app.channelsCmd(&channelsAPICommand{Limit: -1})
+	assert.Equal(t, ErrInvalidMessage, err)
+
+	params, _ := json.Marshal(channelsAPICommand{Pattern: "nothing:*"})
+	apiResp, err := app.apiCmd(apiCommand{Method: "channels", Params: params})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(apiResp.(*apiChannelsResponse).Body.Data))
+
+	apiResp, err = app.apiCmd(apiCommand{Method: "channels"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 20, len(apiResp.(*apiChannelsResponse).Body.Data))
+}
+
+func TestAPIDisconnectClient(t *testing.T) {
+	app := testMemoryApp()
+	c := newTestUserCC()
+	app.clients.add(c)
+
+	params, _ := json.Marshal(disconnectClientAPICommand{})
+	_, err := app.apiCmd(apiCommand{Method: "disconnect_client", Params: params})
+	assert.Equal(t, ErrInvalidMessage, err)
+
+	params, _ = json.Marshal(disconnectClientAPICommand{Client: c.CID})
+	resp, err := app.apiCmd(apiCommand{Method: "disconnect_client", Params: params})
+	assert.Equal(t, nil, err)
+	body := resp.(*apiDisconnectClientResponse).Body
+	assert.Equal(t, c.CID, body.Client)
+	assert.True(t, body.Found)
+	assert.True(t, c.Closed)
+}
+
+func TestAPIChannelInfo(t *testing.T) {
+	c := newTestConfig()
+	c.ChannelOptions.Presence = false
+	c.ChannelOptions.HistorySize = 0
+	app := testMemoryAppWithConfig(&c)
+	createTestClients(app, 1, 3, nil)
+	cmd := &channelInfoAPICommand{
+		Channel: "channel-0",
+	}
+	resp, err := app.channelInfoCmd(cmd)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, resp.(*apiChannelInfoResponse).err)
+	body := resp.(*apiChannelInfoResponse).Body
+	assert.Equal(t, Channel("channel-0"), body.Channel)
+	assert.Equal(t, 3, body.NumSubscribers)
+	assert.NotEqual(t, nil, body.Options)
+	assert.Equal(t, (*channelPresenceInfo)(nil), body.Presence)
+	assert.Equal(t, (*channelHistoryInfo)(nil), body.History)
+
+	_, err = app.channelInfoCmd(&channelInfoAPICommand{})
+	assert.Equal(t, ErrInvalidMessage, err)
+
+	resp, err = app.channelInfoCmd(&channelInfoAPICommand{Channel: "nonexistent:channel"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, ErrNamespaceNotFound, resp.(*apiChannelInfoResponse).err)
+}
+
+func TestAPIChannelInfoPresenceHistory(t *testing.T) {
+	app := testMemoryApp()
+	createTestClients(app, 1, 3, nil)
+
+	resp, err := app.channelInfoCmd(&channelInfoAPICommand{Channel: "channel-0"})
+	assert.Equal(t, nil, err)
+	body := resp.(*apiChannelInfoResponse).Body
+	assert.Equal(t, 3, body.Presence.NumClients)
+	assert.Equal(t, 0, body.History.Length)
+	assert.Equal(t, "", body.History.OldestTimestamp)
+
+	e := app.engine.(*MemoryEngine)
+	for _, ts := range []string{"100", "200"} {
+		msg := newMessage("channel-0", []byte("{}"), "", nil)
+		msg.Timestamp = ts
+		e.historyHub.add("channel-0", *msg, addHistoryOpts{Size: 10, Lifetime: 60})
+	}
+	resp, err = app.channelInfoCmd(&channelInfoAPICommand{Channel: "channel-0"})
+	assert.Equal(t, nil, err)
+	body = resp.(*apiChannelInfoResponse).Body
+	assert.Equal(t, 2, body.History.Length)
+	assert.Equal(t, "100", body.History.OldestTimestamp)
+	assert.Equal(t, "200", body.History.NewestTimestamp)
 }
 
 func TestAPIStats(t *testing.T) {
@@ -244,6 +492,21 @@ func TestAPIStats(t *testing.T) {
 	resp, err := app.statsCmd()
 	assert.Equal(t, nil, err)
 	assert.Equal(t, nil, resp.(*apiStatsResponse).err)
+
+	app = testMemoryApp()
+	app.started -= 10
+	createTestClients(app, 2, 1, nil)
+	assert.Equal(t, nil, app.pubPing())
+	resp, err = app.statsCmd()
+	assert.Equal(t, nil, err)
+	body := resp.(*apiStatsResponse).Body
+	assert.Equal(t, int64(60), body.Data.MetricsInterval)
+	assert.Equal(t, 1, len(body.Data.Nodes))
+	node := body.Data.Nodes[0]
+	assert.Equal(t, app.uid, node.UID)
+	assert.Equal(t, 1, node.Clients)
+	assert.Equal(t, 2, node.Channels)
+	assert.True(t, node.Uptime >= 10)
 }
 
 func TestAPINode(t *testing.T) {
@@ -328,7 +591,7 @@ func BenchmarkAPIRequestPublish(b *testing.B) {
 	jsonData := getPublishJSON("channel")
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_, err := app.processAPIData(jsonData)
+		_, err := app.processAPIData(jsonData, nil, "", nil)
 		if err != nil {
 			b.Error(err)
 		}
@@ -343,7 +606,7 @@ func BenchmarkAPIRequestPublishParallel(b *testing.B) {
 	b.ResetTimer()
 	b.RunParallel(func(pb *testing.PB) {
 		for pb.Next() {
-			_, err := app.processAPIData(jsonData)
+			_, err := app.processAPIData(jsonData, nil, "", nil)
 			if err != nil {
 				b.Error(err)
 			}
@@ -358,7 +621,7 @@ func BenchmarkAPIRequestPublishMany(b *testing.B) {
 	jsonData := getNPublishJSON("channel", 1000)
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_, err := app.processAPIData(jsonData)
+		_, err := app.processAPIData(jsonData, nil, "", nil)
 		if err != nil {
 			b.Error(err)
 		}
@@ -373,7 +636,7 @@ func BenchmarkAPIRequestPublishManyParallel(b *testing.B) {
 	b.ResetTimer()
 	b.RunParallel(func(pb *testing.PB) {
 		for pb.Next() {
-			_, err := app.processAPIData(jsonData)
+			_, err := app.processAPIData(jsonData, nil, "", nil)
 			if err != nil {
 				b.Error(err)
 			}
@@ -388,7 +651,7 @@ func BenchmarkAPIRequestBroadcast(b *testing.B) {
 	jsonData := getNChannelsBroadcastJSON(1000)
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_, err := app.processAPIData(jsonData)
+		_, err := app.processAPIData(jsonData, nil, "", nil)
 		if err != nil {
 			b.Error(err)
 		}
@@ -402,7 +665,7 @@ func BenchmarkAPIRequestBroadcastMany(b *testing.B) {
 	jsonData := getManyNChannelsBroadcastJSON(100, 100)
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_, err := app.processAPIData(jsonData)
+		_, err := app.processAPIData(jsonData, nil, "", nil)
 		if err != nil {
 			b.Error(err)
 		}
@@ -0,0 +1,160 @@
+package libcentrifugo
+
+import (
+	"sync"
+	"time"
+
+	"github.com/FZambia/go-logger"
+)
+
+// apiAsyncShutdownTimeout is a max time to wait on shutdown until queued async API
+// publications passed to engine.
+const apiAsyncShutdownTimeout = 5 * time.Second
+
+// asyncPublication is a publication from publish or broadcast API command with
+// async flag waiting in queue to be passed to engine.
+type asyncPublication struct {
+	channel  Channel
+	data     []byte
+	encoding string
+	client   ConnID
+}
+
+// apiAsyncQueue is a bounded queue of async API publications drained into engine
+// by background worker started in Run.
+type apiAsyncQueue struct {
+	// mu protects closed flag so publications never sent into closed queue.
+	mu      sync.RWMutex
+	closed  bool
+	running bool
+	queue   chan asyncPublication
+	done    chan struct{}
+}
+
+func newAPIAsyncQueue(size int) *apiAsyncQueue {
+	return &apiAsyncQueue{
+		queue: make(chan asyncPublication, size),
+		done:  make(chan struct{}),
+	}
+}
+
+// len returns number of publications waiting in queue.
+func (q *apiAsyncQueue) len() int {
+	return len(q.queue)
+}
+
+// add puts all publications into queue without blocking or none of them, it returns
+// false if queue has no space for all publications or already closed.
+func (q *apiAsyncQueue) add(ps []asyncPublication) bool {
+	// exclusive lock so space checked can not be taken by other publications,
+	// worker only frees space.
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed || cap(q.queue)-len(q.queue) < len(ps) {
+		return false
+	}
+	for _, p := range ps {
+		q.queue <- p
+	}
+	return true
+}
+
+// close stops accepting publications and waits until queued ones passed to engine
+// but not longer than timeout.
+func (q *apiAsyncQueue) close(timeout time.Duration) {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	q.closed = true
+	close(q.queue)
+	running := q.running
+	q.mu.Unlock()
+	if !running {
+		return
+	}
+	select {
+	case <-q.done:
+	case <-time.After(timeout):
+		logger.ERROR.Printf("timeout waiting for %d async API publications", len(q.queue))
+	}
+}
+
+// asyncPublishResult is a result of async publication engine reports later.
+type asyncPublishResult struct {
+	channel Channel
+	err     <-chan error
+}
+
+// startAPIAsync starts worker passing queued publications to engine.
+func (app *Application) startAPIAsync(q *apiAsyncQueue) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.running || q.closed {
+		return
+	}
+	q.running = true
+	go app.runAPIAsync(q)
+}
+
+// runAPIAsync passes queued publications to engine keeping their order. Engine
+// results awaited separately so worker does not wait for engine round trip.
+func (app *Application) runAPIAsync(q *apiAsyncQueue) {
+	results := make(chan asyncPublishResult, cap(q.queue))
+	resultsDone := make(chan struct{})
+	go func() {
+		defer close(resultsDone)
+		for r := range results {
+			if err := <-r.err; err != nil {
+				logger.ERROR.Printf("error publishing async API message into channel %s: %v", r.channel, err)
+			}
+		}
+	}()
+	for p := range q.queue {
+		_, errCh := app.publishAsync(p.channel, p.data, p.encoding, p.client, nil, false, nil)
+		results <- asyncPublishResult{channel: p.channel, err: errCh}
+	}
+	close(results)
+	<-resultsDone
+	close(q.done)
+}
+
+// validatePublish checks API publication before it queued so obviously wrong
+// publications still reported to caller in response.
+func (app *Application) validatePublish(ch Channel, data []byte, encoding string) error {
+	if string(ch) == "" || len(data) == 0 {
+		return ErrInvalidMessage
+	}
+	chOpts, err := app.channelOpts(ch)
+	if err != nil {
+		return err
+	}
+	if encoding == PayloadEncodingBinary && !chOpts.BinaryPayloads {
+		return ErrPermissionDenied
+	}
+	if !messageSizeAllowed(chOpts, data) {
+		return ErrLimitExceeded
+	}
+	_, err = decodePayload(data, encoding)
+	return err
+}
+
+// publishQueued validates publications and puts them into async queue. It returns
+// ErrQueueFull if queue has no space for publications into all channels, nothing
+// queued in this case.
+func (app *Application) publishQueued(channels []Channel, data []byte, encoding string, client ConnID) error {
+	ps := make([]asyncPublication, 0, len(channels))
+	for _, ch := range channels {
+		if err := app.validatePublish(ch, data, encoding); err != nil {
+			return err
+		}
+		ps = append(ps, asyncPublication{channel: ch, data: data, encoding: encoding, client: client})
+	}
+	if !app.apiAsync.add(ps) {
+		logger.ERROR.Println("async API queue is full")
+		app.metrics.NumAPIAsyncRejected.Inc()
+		return ErrQueueFull
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package libcentrifugo
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/centrifugal/centrifugo/libcentrifugo/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIPublishAsync(t *testing.T) {
+	c := newTestConfig()
+	c.ChannelOptions.HistorySize = 10
+	c.ChannelOptions.HistoryLifetime = 60
+	app := testMemoryAppWithConfig(&c)
+	assert.Equal(t, nil, app.Run())
+
+	resp, err := app.publishCmd(&publishAPICommand{Channel: "test", Data: []byte(`{}`), Async: true}, nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, resp.(*apiPublishResponse).err)
+
+	resp, err = app.broadcastCmd(&broadcastAPICommand{Channels: []Channel{"test1", "test2"}, Data: []byte(`{}`), Async: true}, nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, resp.(*apiBroadcastResponse).err)
+
+	// invalid publications reported without queueing.
+	resp, err = app.publishCmd(&publishAPICommand{Channel: "nonexistent:test", Data: []byte(`{}`), Async: true}, nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, ErrNamespaceNotFound, resp.(*apiPublishResponse).err)
+
+	// queued publications delivered on shutdown.
+	app.Shutdown()
+	for _, ch := range []Channel{"test", "test1", "test2"} {
+		history, err := app.History(ch)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, 1, len(history))
+	}
+}
+
+func TestAPIPublishAsyncQueueFull(t *testing.T) {
+	c := newTestConfig()
+	c.APIAsyncQueueSize = 1
+	app := testMemoryAppWithConfig(&c)
+
+	// worker not started so queue is not drained.
+	data := `{"method":"publish","params":{"channel":"test","data":{},"async":true}}`
+	sign := auth.GenerateApiSign("secret", []byte(data))
+	rec := httptest.NewRecorder()
+	app.APIHandler(rec, newTestAPIJSONRequest(data, sign))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var replies []struct {
+		Error *string         `json:"error"`
+		Body  json.RawMessage `json:"body"`
+	}
+	assert.Equal(t, nil, json.Unmarshal(rec.Body.Bytes(), &replies))
+	assert.Equal(t, (*string)(nil), replies[0].Error)
+
+	rec = httptest.NewRecorder()
+	app.APIHandler(rec, newTestAPIJSONRequest(data, sign))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, int64(1), app.metrics.NumAPIAsyncRejected.LoadRaw())
+
+	// broadcast not fitting into queue is not queued partially.
+	app.apiAsync = newAPIAsyncQueue(2)
+	err := app.publishQueued([]Channel{"test1", "test2", "test3"}, []byte("{}"), "", "")
+	assert.Equal(t, ErrQueueFull, err)
+	assert.Equal(t, 0, app.apiAsync.len())
+}
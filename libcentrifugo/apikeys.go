@@ -0,0 +1,86 @@
+package libcentrifugo
+
+import (
+	"encoding/json"
+)
+
+// apiKey returns API key with provided name.
+func (c *Config) apiKey(name string) (APIKey, bool) {
+	for _, k := range c.APIKeys {
+		if k.Name == name {
+			return k, true
+		}
+	}
+	return APIKey{}, false
+}
+
+// adminOnlyAPIMethods are API methods affecting all connections of node or
+// exposing node internals, they can only be run using project secret or API key
+// listing method explicitly.
+var adminOnlyAPIMethods = map[string]bool{
+	"disconnect_bulk": true,
+	"diag":            true,
+	"replay":          true,
+	"replay_cancel":   true,
+}
+
+// allows checks that API key has permission to run command.
+func (k APIKey) allows(command apiCommand) bool {
+	if len(k.Methods) == 0 && adminOnlyAPIMethods[command.Method] {
+		return false
+	}
+	if len(k.Methods) > 0 && !stringInSlice(command.Method, k.Methods) {
+		return false
+	}
+	if len(k.Channels) == 0 {
+		return true
+	}
+	channels, ok := apiCommandChannels(command)
+	if !ok || len(channels) == 0 {
+		// commands without channel affect channels outside of allowed patterns.
+		return false
+	}
+	for _, ch := range channels {
+		matched := false
+		for _, pattern := range k.Channels {
+			if globMatch(pattern, string(ch)) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// apiCommandChannels returns channels API command operates on including channel
+// of disconnect_bulk filter. Unsubscribe from all channels reported as empty channel
+// name so only key allowed to use all channels can run it.
+func apiCommandChannels(command apiCommand) ([]Channel, bool) {
+	var params struct {
+		Channel       Channel   `json:"channel"`
+		Channels      []Channel `json:"channels"`
+		SourceChannel Channel   `json:"source_channel"`
+		TargetChannel Channel   `json:"target_channel"`
+		Filter        struct {
+			Channel Channel `json:"channel"`
+		} `json:"filter"`
+	}
+	if len(command.Params) > 0 {
+		if err := json.Unmarshal(command.Params, &params); err != nil {
+			return nil, false
+		}
+	}
+	channels := params.Channels
+	for _, ch := range []Channel{params.Channel, params.SourceChannel, params.TargetChannel, params.Filter.Channel} {
+		if ch != "" {
+			channels = append(channels, ch)
+		}
+	}
+	if command.Method == "unsubscribe" && params.Channel == "" {
+		channels = append(channels, "")
+	}
+	return channels, true
+}
@@ -0,0 +1,104 @@
+package libcentrifugo
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/centrifugal/centrifugo/libcentrifugo/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIKeyAllows(t *testing.T) {
+	key := APIKey{Methods: []string{"publish", "broadcast", "unsubscribe"}, Channels: []string{"news:*"}}
+	assert.True(t, key.allows(apiCommand{Method: "publish", Params: []byte(`{"channel":"news:sport"}`)}))
+	assert.False(t, key.allows(apiCommand{Method: "publish", Params: []byte(`{"channel":"user#1"}`)}))
+	assert.False(t, key.allows(apiCommand{Method: "disconnect", Params: []byte(`{"user":"1"}`)}))
+	assert.True(t, key.allows(apiCommand{Method: "broadcast", Params: []byte(`{"channels":["news:1","news:2"]}`)}))
+	assert.False(t, key.allows(apiCommand{Method: "broadcast", Params: []byte(`{"channels":["news:1","user#1"]}`)}))
+	// unsubscribe from all channels touches channels outside of allowed patterns.
+	assert.False(t, key.allows(apiCommand{Method: "unsubscribe", Params: []byte(`{"user":"1"}`)}))
+	// commands without channel denied to channel restricted key.
+	key.Methods = nil
+	assert.False(t, key.allows(apiCommand{Method: "channels"}))
+	assert.False(t, key.allows(apiCommand{Method: "stats", Params: []byte(`{}`)}))
+	// disconnect_bulk filter channel checked against key.
+	key.Methods = []string{"disconnect_bulk"}
+	assert.True(t, key.allows(apiCommand{Method: "disconnect_bulk", Params: []byte(`{"filter":{"channel":"news:1"}}`)}))
+	assert.False(t, key.allows(apiCommand{Method: "disconnect_bulk", Params: []byte(`{"filter":{"channel":"user#1"}}`)}))
+	assert.False(t, key.allows(apiCommand{Method: "disconnect_bulk", Params: []byte(`{"filter":{"transport":"jsonp"}}`)}))
+	assert.True(t, APIKey{}.allows(apiCommand{Method: "disconnect", Params: []byte(`{"user":"1"}`)}))
+	// disconnect_bulk must be listed explicitly.
+	assert.False(t, APIKey{}.allows(apiCommand{Method: "disconnect_bulk", Params: []byte(`{"filter":{"transport":"jsonp"}}`)}))
+	assert.True(t, APIKey{Methods: []string{"disconnect_bulk"}}.allows(apiCommand{Method: "disconnect_bulk", Params: []byte(`{"filter":{"transport":"jsonp"}}`)}))
+	assert.False(t, APIKey{}.allows(apiCommand{Method: "diag"}))
+	assert.True(t, APIKey{Methods: []string{"diag"}}.allows(apiCommand{Method: "diag"}))
+}
+
+func TestAPIHandlerAPIKey(t *testing.T) {
+	c := newTestConfig()
+	c.APIKeys = []APIKey{{Name: "publisher", Secret: "publisher secret", Methods: []string{"publish"}}}
+	app := testMemoryAppWithConfig(&c)
+
+	data := `[{"method":"publish","params":{"channel":"test","data":{}}},{"method":"disconnect","params":{"user":"1"}}]`
+	req := newTestAPIJSONRequest(data, auth.GenerateApiSign("publisher secret", []byte(data)))
+	req.Header.Set("X-API-Key", "publisher")
+	rec := httptest.NewRecorder()
+	app.APIHandler(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var replies []struct {
+		Method string  `json:"method"`
+		Error  *string `json:"error"`
+	}
+	assert.Equal(t, nil, json.Unmarshal(rec.Body.Bytes(), &replies))
+	assert.Equal(t, 2, len(replies))
+	assert.Equal(t, (*string)(nil), replies[0].Error)
+	assert.Equal(t, "disconnect", replies[1].Method)
+	assert.Equal(t, ErrPermissionDenied.Error(), *replies[1].Error)
+	assert.Equal(t, map[string]int64{"publisher": 1}, app.metrics.GetRawMetrics().NumAPIKeyRequests)
+
+	// key secret must be used to sign request.
+	req = newTestAPIJSONRequest(data, auth.GenerateApiSign("secret", []byte(data)))
+	req.Header.Set("X-API-Key", "publisher")
+	rec = httptest.NewRecorder()
+	app.APIHandler(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	req = newTestAPIJSONRequest(data, auth.GenerateApiSign("publisher secret", []byte(data)))
+	req.Header.Set("X-API-Key", "unknown")
+	rec = httptest.NewRecorder()
+	app.APIHandler(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// project secret still allows everything.
+	rec = httptest.NewRecorder()
+	app.APIHandler(rec, newTestAPIJSONRequest(data, auth.GenerateApiSign("secret", []byte(data))))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, nil, json.Unmarshal(rec.Body.Bytes(), &replies))
+	assert.Equal(t, (*string)(nil), replies[1].Error)
+}
+
+func TestAPIHandlerAPIKeyAdminOnly(t *testing.T) {
+	c := newTestConfig()
+	c.APIKeys = []APIKey{{Name: "publisher", Secret: "publisher secret"}}
+	app := testMemoryAppWithConfig(&c)
+
+	data := `[{"method":"replay","params":{"source_channel":"source","target_channel":"target"}},{"method":"replay_cancel","params":{"target_channel":"target"}}]`
+	req := newTestAPIJSONRequest(data, auth.GenerateApiSign("publisher secret", []byte(data)))
+	req.Header.Set("X-API-Key", "publisher")
+	rec := httptest.NewRecorder()
+	app.APIHandler(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var replies []struct {
+		Method string  `json:"method"`
+		Error  *string `json:"error"`
+	}
+	assert.Equal(t, nil, json.Unmarshal(rec.Body.Bytes(), &replies))
+	assert.Equal(t, 2, len(replies))
+	for _, reply := range replies {
+		if assert.NotNil(t, reply.Error, reply.Method) {
+			assert.Equal(t, ErrPermissionDenied.Error(), *reply.Error)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package libcentrifugo
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// apiNonceCache remembers nonces of timestamp signed API requests during sign
+// window so the same request can not be replayed. Nonces older than window are
+// forgotten as requests with such timestamps rejected anyway.
+type apiNonceCache struct {
+	mu        sync.Mutex
+	nonces    map[string]time.Time
+	lastPrune time.Time
+}
+
+func newAPINonceCache() *apiNonceCache {
+	return &apiNonceCache{
+		nonces: make(map[string]time.Time),
+	}
+}
+
+// add remembers nonce and returns false if nonce was already used during window.
+func (c *apiNonceCache) add(nonce string, window time.Duration, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.Sub(c.lastPrune) > window {
+		for n, seen := range c.nonces {
+			if now.Sub(seen) > window {
+				delete(c.nonces, n)
+			}
+		}
+		c.lastPrune = now
+	}
+	if seen, ok := c.nonces[nonce]; ok && now.Sub(seen) <= window {
+		return false
+	}
+	c.nonces[nonce] = now
+	return true
+}
+
+// checkAPIRequestTime checks that timestamp of signed API request is inside sign
+// window around current time.
+func checkAPIRequestTime(timestamp string, window time.Duration, now time.Time) bool {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	diff := now.Sub(time.Unix(ts, 0))
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff <= window
+}
@@ -3,9 +3,15 @@ package libcentrifugo
 
 import (
 	"encoding/json"
+	"fmt"
+	"net"
+	"path"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/FZambia/go-logger"
@@ -37,6 +43,12 @@ type Application struct {
 	// hub to manage admin connections.
 	admins *adminHub
 
+	// adminWatches contains channel watches of admin connections.
+	adminWatches *adminWatchHub
+
+	// engineSubs serializes engine subscribe and unsubscribe of node per channel.
+	engineSubs *engineSubHub
+
 	// engine to use - in memory or redis.
 	engine Engine
 
@@ -49,26 +61,114 @@ type Application struct {
 	// shutdown is a flag which is only true when application is going to shut down.
 	shutdown bool
 
-	// shutdownCh is a channel which is closed when shutdown happens.
+	// shutdownCh is a channel which is closed when shutdown happens – after client
+	// connections drained so engine can stop.
 	shutdownCh chan struct{}
 
+	// drainCh is a channel which is closed when shutdown starts, before client
+	// connections drained.
+	drainCh chan struct{}
+
+	// dynamicNamespaces keeps namespaces created from namespace template.
+	dynamicNamespaces *dynamicNamespaceHub
+
+	// namespaceMatchers are compiled patterns of namespaces from config.
+	namespaceMatchers []namespaceMatcher
+	// ipFilter contains parsed networks allowed to use API and admin endpoints.
+	ipFilter ipFilter
+
+	// signCache keeps recent successful private channel sign verifications.
+	signCache *signCache
+
+	// presenceCache keeps recently loaded channel presence.
+	presenceCache *presenceCache
+
+	// replays keeps running channel history replays.
+	replays *replayHub
+
+	// connLog writes connection log if connection_log_file configured.
+	connLog *connLogger
+
+	// statsd exports node metrics if statsd_addr configured.
+	statsd *statsdExporter
+
+	// tracer exports spans of API requests if tracing_endpoint configured.
+	tracer *tracer
+
+	// kafka publishes records of Kafka topics into channels if kafka_brokers
+	// configured.
+	kafka *kafkaBridge
+
+	// auditLog writes audit log of API commands if audit_log enabled.
+	auditLog *connLogger
+
+	// alarms keeps state of alarms raised when node metrics cross thresholds.
+	alarms *alarmHub
+
 	// metrics holds various counters and timers different parts of Centrifugo update.
 	metrics *metricsRegistry
+
+	// previousSecretLogged is a time (unix nanoseconds) when use of previous secret
+	// was last logged.
+	previousSecretLogged int64
+
+	// apiLegacyFormWarning used to log deprecation warning about legacy form
+	// encoded API requests only once.
+	apiLegacyFormWarning sync.Once
+
+	// apiNonces remembers nonces of timestamp signed API requests to reject replays.
+	apiNonces *apiNonceCache
+	// peerNonces remembers nonces of messages received from Memory Engine peers.
+	peerNonces *apiNonceCache
+
+	// apiRateLimiter limits rate of HTTP API requests.
+	apiRateLimiter *apiRateLimiter
+
+	// adminAuthLimiter locks out addresses with failed admin login attempts.
+	adminAuthLimiter *adminAuthLimiter
+
+	// apiAsync is a queue of publications from async API commands.
+	apiAsync *apiAsyncQueue
+
+	// subscribeProxyCache keeps recent subscribe proxy replies.
+	subscribeProxyCache *subscribeProxyCache
+
+	// controlRequests keeps control requests waiting for replies of other nodes.
+	controlRequests *controlRequestHub
 }
 
 // NewApplication returns new Application instance, the only required argument is
 // config, structure and engine must be set via corresponding methods.
 func NewApplication(config *Config) (*Application, error) {
 	app := &Application{
-		uid:        uuid.NewV4().String(),
-		config:     config,
-		clients:    newClientHub(),
-		admins:     newAdminHub(),
-		nodes:      make(map[string]nodeInfo),
-		started:    time.Now().Unix(),
-		metrics:    newMetricsRegistry(),
-		shutdownCh: make(chan struct{}),
-	}
+		uid:                 uuid.NewV4().String(),
+		config:              config,
+		clients:             newClientHub(),
+		admins:              newAdminHub(),
+		adminWatches:        newAdminWatchHub(),
+		engineSubs:          newEngineSubHub(),
+		nodes:               make(map[string]nodeInfo),
+		dynamicNamespaces:   newDynamicNamespaceHub(),
+		started:             time.Now().Unix(),
+		metrics:             newMetricsRegistry(),
+		alarms:              newAlarmHub(),
+		signCache:           newSignCache(),
+		presenceCache:       newPresenceCache(),
+		replays:             newReplayHub(),
+		apiNonces:           newAPINonceCache(),
+		peerNonces:          newAPINonceCache(),
+		apiRateLimiter:      newAPIRateLimiter(),
+		adminAuthLimiter:    newAdminAuthLimiter(),
+		apiAsync:            newAPIAsyncQueue(config.APIAsyncQueueSize),
+		controlRequests:     newControlRequestHub(),
+		subscribeProxyCache: newSubscribeProxyCache(),
+		shutdownCh:          make(chan struct{}),
+		drainCh:             make(chan struct{}),
+	}
+	// patterns already checked when config validated.
+	app.namespaceMatchers, _ = config.namespaceMatchers()
+	app.ipFilter, _ = config.ipFilter()
+	app.metrics.namespaces.setFormat(config.PrivateChannelPrefix, config.NamespaceChannelBoundary)
 	return app, nil
 }
 
@@ -78,9 +178,59 @@ func (app *Application) Run() error {
 	if err := app.engine.run(); err != nil {
 		return err
 	}
+	app.RLock()
+	connLogFile := app.config.ConnectionLogFile
+	connLogMaxSize := app.config.ConnectionLogMaxSize
+	connLogMaxBackups := app.config.ConnectionLogMaxBackups
+	auditLog := app.config.AuditLog
+	auditLogFile := app.config.AuditLogFile
+	auditLogMaxSize := app.config.AuditLogMaxSize
+	auditLogMaxBackups := app.config.AuditLogMaxBackups
+	statsdAddr := app.config.StatsdAddr
+	statsdPrefix := statsdPrefix(app.config)
+	statsdFormat := app.config.StatsdFormat
+	statsdTags := statsdTags(app.config)
+	tracingEndpoint := app.config.TracingEndpoint
+	tracingProtocol := app.config.TracingProtocol
+	tracingSampleRate := app.config.TracingSampleRate
+	name := app.config.Name
+	version := app.config.Version
+	if len(app.config.KafkaBrokers) > 0 {
+		app.kafka = newKafkaBridge(app, app.config)
+	}
+	app.RUnlock()
+	if connLogFile != "" {
+		connLog, err := newConnLogger(connLogFile, connLogMaxSize, connLogMaxBackups, connLogQueueSize, &app.metrics.NumConnectionLogDropped)
+		if err != nil {
+			return err
+		}
+		app.connLog = connLog
+	}
+	if auditLog && auditLogFile != "" {
+		l, err := newConnLogger(auditLogFile, auditLogMaxSize, auditLogMaxBackups, connLogQueueSize, &app.metrics.NumAuditDropped)
+		if err != nil {
+			return err
+		}
+		app.auditLog = l
+	} else if auditLog {
+		app.auditLog = newMainLogWriter("AUDIT", connLogQueueSize, &app.metrics.NumAuditDropped)
+	}
+	if statsdAddr != "" {
+		if _, err := net.ResolveUDPAddr("udp", statsdAddr); err != nil {
+			logger.WARN.Printf("statsd address %s not resolved, will retry on every export: %v", statsdAddr, err)
+		}
+		app.statsd = newStatsdExporter(statsdAddr, statsdPrefix, statsdFormat, statsdTags)
+	}
+	if tracingEndpoint != "" {
+		app.tracer = newTracer(tracingEndpoint, tracingProtocol, tracingSampleRate, name, version, &app.metrics.NumTracingSpansDropped)
+	}
 	go app.sendNodePingMsg()
 	go app.cleanNodeInfo()
 	go app.updateMetrics()
+	app.startAPIAsync(app.apiAsync)
+	if app.kafka != nil {
+		app.kafka.run()
+	}
 
 	return nil
 }
@@ -93,14 +243,54 @@ func (app *Application) Shutdown() {
 		app.Unlock()
 		return
 	}
+	// new client connections and API requests rejected from this moment.
 	app.shutdown = true
-	close(app.shutdownCh)
+	close(app.drainCh)
+	timeout := app.config.ShutdownTimeout
 	app.Unlock()
-	app.clients.shutdown()
+	if app.kafka != nil {
+		// stop consuming so offsets of records not published are not committed.
+		app.kafka.close()
+	}
+	// publications already accepted by API must reach engine before exit.
+	app.apiAsync.close(apiAsyncShutdownTimeout)
+	app.clients.drain(timeout)
+	if app.connLog != nil {
+		// connections cleaned up asynchronously after close, give them a chance
+		// to write disconnect events before flushing connection log.
+		deadline := time.Now().Add(connLogShutdownTimeout)
+		for app.clients.nClients() > 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		app.connLog.close()
+	}
+	if app.auditLog != nil {
+		app.auditLog.close()
+	}
+	if app.tracer != nil {
+		app.tracer.close()
+	}
+	close(app.shutdownCh)
+}
+
+// NotifyShutdown returns a channel which is closed when application starts
+// shutting down, before client connections drained.
+func (app *Application) NotifyShutdown() <-chan struct{} {
+	return app.drainCh
 }
 
 func (app *Application) updateMetricsOnce() {
+	atomic.StoreInt64(&app.metrics.NumConnections, app.clients.connections())
+	queueMax, queueP99, queueAge := app.clients.queueStats()
+	atomic.StoreInt64(&app.metrics.ClientQueueSizeMax, int64(queueMax))
+	atomic.StoreInt64(&app.metrics.ClientQueueSizeP99, int64(queueP99))
+	atomic.StoreInt64(&app.metrics.ClientQueueAgeMax, int64(queueAge/time.Millisecond))
+	if app.kafka != nil {
+		atomic.StoreInt64(&app.metrics.KafkaLag, app.kafka.lag())
+	}
 	app.metrics.UpdateSnapshot()
+	app.checkAlarms()
+	app.exportStatsd()
 }
 
 func (app *Application) updateMetrics() {
@@ -153,9 +343,25 @@ func (app *Application) SetConfig(c *Config) {
 	app.Lock()
 	defer app.Unlock()
 	app.config = c
+	app.namespaceMatchers, _ = c.namespaceMatchers()
+	app.metrics.namespaces.setFormat(c.PrivateChannelPrefix, c.NamespaceChannelBoundary)
+	app.ipFilter, _ = c.ipFilter()
+	// Template or its namespace could change so dynamic namespaces will be
+	// created again on demand using new configuration.
+	app.dynamicNamespaces.reset()
+	// Secret could change so all cached sign verifications must be dropped.
+	app.signCache.reset()
+	// Channel options could change so presence can become unavailable.
+	app.presenceCache.reset()
+	atomic.StoreInt64(&app.metrics.NumDynamicNamespaces, 0)
 	if app.config.Insecure {
 		logger.WARN.Println("libcentrifugo: application in INSECURE MODE")
 	}
+	if stringInSlice(corsAllowOrigin, app.config.AllowedOrigins) || stringInSlice(corsAllowOrigin, app.config.APIAllowedOrigins) {
+		logger.WARN.Println("libcentrifugo: \"*\" in allowed origins allows browsers to connect from any origin")
+	} else if len(app.config.AllowedOrigins) == 0 {
+		logger.WARN.Println("libcentrifugo: allowed origins not set, browsers can connect from any origin")
+	}
 }
 
 // SetEngine binds engine to application.
@@ -176,11 +382,41 @@ func (app *Application) channels() ([]Channel, error) {
 	return app.engine.channels()
 }
 
+// channelsByPattern returns active channels matching glob pattern, empty pattern
+// matches all channels. Engines not able to filter channels themselves return all
+// active channels which are filtered here.
+func (app *Application) channelsByPattern(pattern string) ([]Channel, error) {
+	if pattern == "" {
+		return app.channels()
+	}
+	if e, ok := app.engine.(patternChannelsEngine); ok {
+		return e.channelsByPattern(pattern)
+	}
+	channels, err := app.channels()
+	if err != nil {
+		return nil, err
+	}
+	matched := make([]Channel, 0)
+	for _, ch := range channels {
+		if globMatch(pattern, string(ch)) {
+			matched = append(matched, ch)
+		}
+	}
+	return matched, nil
+}
+
+// NumSubscribers returns number of subscribers in channel on all nodes.
+func (app *Application) NumSubscribers(ch Channel) (int, error) {
+	return app.engine.numSubscribers(ch)
+}
+
 func (app *Application) stats() serverStats {
+	now := time.Now().Unix()
 	app.nodesMu.Lock()
 	nodes := make([]nodeInfo, len(app.nodes))
 	i := 0
 	for _, info := range app.nodes {
+		info.Uptime = nodeUptime(info.Started, now)
 		nodes[i] = info
 		i++
 	}
@@ -196,6 +432,43 @@ func (app *Application) stats() serverStats {
 	}
 }
 
+// clusterNodes returns information about all known nodes sorted by name.
+func (app *Application) clusterNodes() []clusterNode {
+	app.RLock()
+	maxDelay := int64(app.config.NodeInfoMaxDelay.Seconds())
+	app.RUnlock()
+
+	now := time.Now().Unix()
+	app.nodesMu.Lock()
+	nodes := make([]clusterNode, 0, len(app.nodes))
+	for _, info := range app.nodes {
+		lastPing := now - info.updated
+		if lastPing < 0 {
+			lastPing = 0
+		}
+		nodes = append(nodes, clusterNode{
+			UID:      info.UID,
+			Name:     info.Name,
+			Clients:  info.Clients,
+			Unique:   info.Unique,
+			Channels: info.Channels,
+			Started:  info.Started,
+			Uptime:   nodeUptime(info.Started, now),
+			LastPing: lastPing,
+			Stale:    lastPing > maxDelay,
+		})
+	}
+	app.nodesMu.Unlock()
+
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].Name != nodes[j].Name {
+			return nodes[i].Name < nodes[j].Name
+		}
+		return nodes[i].UID < nodes[j].UID
+	})
+	return nodes
+}
+
 func (app *Application) node() nodeInfo {
 	app.nodesMu.Lock()
 	info, ok := app.nodes[app.uid]
@@ -205,10 +478,19 @@ func (app *Application) node() nodeInfo {
 	app.nodesMu.Unlock()
 
 	info.metrics = *app.metrics.GetRawMetrics()
+	info.Uptime = nodeUptime(info.Started, time.Now().Unix())
 
 	return info
 }
 
+// nodeUptime returns number of seconds since node start.
+func nodeUptime(started int64, now int64) int64 {
+	if started == 0 || now < started {
+		return 0
+	}
+	return now - started
+}
+
 // controlMsg handles messages from control channel - control messages used for internal
 // communication between nodes to share state or commands.
 func (app *Application) controlMsg(cmd *ControlMessage) error {
@@ -241,7 +523,8 @@ func (app *Application) controlMsg(cmd *ControlMessage) error {
 			logger.ERROR.Println(err)
 			return ErrInvalidMessage
 		}
-		return app.unsubscribeUser(cmd.User, cmd.Channel)
+		_, err = app.unsubscribeUser(cmd.User, cmd.Channel)
+		return err
 	case "disconnect":
 		var cmd disconnectControlCommand
 		err := json.Unmarshal(*params, &cmd)
@@ -249,7 +532,70 @@ func (app *Application) controlMsg(cmd *ControlMessage) error {
 			logger.ERROR.Println(err)
 			return ErrInvalidMessage
 		}
-		return app.disconnectUser(cmd.User)
+		return app.disconnectUser(cmd.User, cmd.Reconnect)
+	case "disconnect_bulk":
+		var cmd disconnectBulkControlCommand
+		err := json.Unmarshal(*params, &cmd)
+		if err != nil {
+			logger.ERROR.Println(err)
+			return ErrInvalidMessage
+		}
+		if cmd.Filter.empty() {
+			return ErrInvalidMessage
+		}
+		go app.disconnectBulk(cmd.Filter)
+		return nil
+	case "user_connections":
+		var cmd userConnectionsControlCommand
+		err := json.Unmarshal(*params, &cmd)
+		if err != nil {
+			logger.ERROR.Println(err)
+			return ErrInvalidMessage
+		}
+		return app.userConnectionsControlCmd(&cmd)
+	case "disconnect_client":
+		var cmd disconnectClientControlCommand
+		err := json.Unmarshal(*params, &cmd)
+		if err != nil {
+			logger.ERROR.Println(err)
+			return ErrInvalidMessage
+		}
+		return app.disconnectClientControlCmd(&cmd)
+	case "user_connections_reply":
+		var cmd userConnectionsReplyControlCommand
+		err := json.Unmarshal(*params, &cmd)
+		if err != nil {
+			logger.ERROR.Println(err)
+			return ErrInvalidMessage
+		}
+		app.controlRequests.reply(cmd.RequestID, cmd.Connections)
+		return nil
+	case "disconnect_client_reply":
+		var cmd disconnectClientReplyControlCommand
+		err := json.Unmarshal(*params, &cmd)
+		if err != nil {
+			logger.ERROR.Println(err)
+			return ErrInvalidMessage
+		}
+		app.controlRequests.reply(cmd.RequestID, cmd.Found)
+		return nil
+	case "channel_subscribers":
+		var cmd channelSubscribersControlCommand
+		err := json.Unmarshal(*params, &cmd)
+		if err != nil {
+			logger.ERROR.Println(err)
+			return ErrInvalidMessage
+		}
+		return app.channelSubscribersControlCmd(&cmd)
+	case "channel_subscribers_reply":
+		var cmd channelSubscribersReplyControlCommand
+		err := json.Unmarshal(*params, &cmd)
+		if err != nil {
+			logger.ERROR.Println(err)
+			return ErrInvalidMessage
+		}
+		app.controlRequests.reply(cmd.RequestID, cmd.NumSubscribers)
+		return nil
 	default:
 		logger.ERROR.Println("unknown control message method", method)
 		return ErrInvalidMessage
@@ -268,7 +614,7 @@ func (app *Application) adminMsg(message *AdminMessage) error {
 	if !hasAdmins {
 		return nil
 	}
-	resp := newAPIAdminMessageResponse(message.Params)
+	resp := newAPIAdminMessageResponse(message.Method, message.Params)
 	byteMessage, err := json.Marshal(resp)
 	if err != nil {
 		return err
@@ -280,6 +626,7 @@ func (app *Application) adminMsg(message *AdminMessage) error {
 // The goal of this method to deliver this message to all clients on this node subscribed
 // on channel.
 func (app *Application) clientMsg(ch Channel, message *Message) error {
+	app.watchMsg(ch, "message", message)
 	numSubscribers := app.clients.numSubscribers(ch)
 	if logger.TRACE.Enabled() {
 		logger.TRACE.Printf("Client message into channel %s (%d subscribers): %s", message.Channel, numSubscribers, message.Data)
@@ -290,11 +637,36 @@ func (app *Application) clientMsg(ch Channel, message *Message) error {
 	}
 	resp := newClientMessage()
 	resp.Body = *message
+	if chOpts, err := app.channelOpts(ch); err == nil && chOpts.ClientAcks {
+		// message UID used as ack token.
+		resp.Ack = message.UID
+	}
 	byteMessage, err := resp.Marshal()
 	if err != nil {
 		return err
 	}
-	return app.clients.broadcast(ch, byteMessage)
+	nsMetrics := app.namespaceMetrics(ch)
+	nsMetrics.NumMsgDelivered.Add(int64(numSubscribers))
+	nsMetrics.BytesDelivered.Add(int64(numSubscribers * len(byteMessage)))
+	var binaryMessage []byte
+	if message.Encoding == PayloadEncodingBinary {
+		// connections negotiated binary frames receive message encoded with protobuf.
+		binaryMessage, err = message.Marshal()
+		if err != nil {
+			return err
+		}
+	}
+	dropped, lagging, err := app.clients.broadcastMessage(ch, byteMessage, binaryMessage, resp.Ack, true)
+	if err != nil {
+		return err
+	}
+	if dropped > 0 {
+		app.metrics.NumAcksDropped.Add(int64(dropped))
+	}
+	for _, c := range lagging {
+		app.sendAckAdvice(c)
+	}
+	return nil
 }
 
 // Publish sends a message to all clients subscribed on channel with provided data, client and ClientInfo.
@@ -308,10 +680,14 @@ func (app *Application) Publish(ch Channel, data []byte, client ConnID, info *Cl
 	if err != nil {
 		return err
 	}
+	if !messageSizeAllowed(chOpts, data) {
+		return ErrLimitExceeded
+	}
 
-	errCh := app.pubClient(ch, chOpts, data, client, info)
+	_, errCh := app.pubClient(ch, chOpts, data, "", client, info, nil)
 	err = <-errCh
 	if err != nil {
+		app.metrics.NumEngineErrors.Inc()
 		logger.ERROR.Println(err)
 		return ErrInternalServerError
 	}
@@ -319,6 +695,12 @@ func (app *Application) Publish(ch Channel, data []byte, client ConnID, info *Cl
 	return nil
 }
 
+// messageSizeAllowed checks message data size against max_message_size limit of
+// channel.
+func messageSizeAllowed(chOpts ChannelOptions, data []byte) bool {
+	return chOpts.MaxMessageSize <= 0 || len(data) <= chOpts.MaxMessageSize
+}
+
 func makeErrChan(err error) <-chan error {
 	ret := make(chan error, 1)
 	ret <- err
@@ -327,15 +709,18 @@ func makeErrChan(err error) <-chan error {
 
 // publish sends a message into channel with provided data, client and client info.
 // If fromClient argument is true then internally this method will check client permission to
-// publish into this channel.
-func (app *Application) publishAsync(ch Channel, data []byte, client ConnID, info *ClientInfo, fromClient bool) <-chan error {
+// publish into this channel. Data with binary encoding must be base64 encoded JSON string and
+// only allowed in channels with binary payloads enabled. It returns UID of published message
+// which is empty if message was not published. Publishing recorded as child of span sp
+// if it is not nil.
+func (app *Application) publishAsync(ch Channel, data []byte, encoding string, client ConnID, info *ClientInfo, fromClient bool, sp *span) (string, <-chan error) {
 	if string(ch) == "" || len(data) == 0 {
-		return makeErrChan(ErrInvalidMessage)
+		return "", makeErrChan(ErrInvalidMessage)
 	}
 
 	chOpts, err := app.channelOpts(ch)
 	if err != nil {
-		return makeErrChan(err)
+		return "", makeErrChan(err)
 	}
 
 	app.RLock()
@@ -343,7 +728,18 @@ func (app *Application) publishAsync(ch Channel, data []byte, client ConnID, inf
 	app.RUnlock()
 
 	if fromClient && !chOpts.Publish && !insecure {
-		return makeErrChan(ErrPermissionDenied)
+		return "", makeErrChan(ErrPermissionDenied)
+	}
+
+	if encoding == PayloadEncodingBinary && !chOpts.BinaryPayloads {
+		return "", makeErrChan(ErrPermissionDenied)
+	}
+	if !messageSizeAllowed(chOpts, data) {
+		return "", makeErrChan(ErrLimitExceeded)
+	}
+	data, err = decodePayload(data, encoding)
+	if err != nil {
+		return "", makeErrChan(err)
 	}
 
 	if app.mediator != nil {
@@ -351,18 +747,19 @@ func (app *Application) publishAsync(ch Channel, data []byte, client ConnID, inf
 		// immediately as mediator will decide itself what to do with it.
 		pass := app.mediator.Message(ch, data, client, info)
 		if !pass {
-			return makeErrChan(nil)
+			return "", makeErrChan(nil)
 		}
 	}
 
-	return app.pubClient(ch, chOpts, data, client, info)
+	return app.pubClient(ch, chOpts, data, encoding, client, info, sp)
 }
 
 // publish sends a message into channel with provided data, client and client info.
 // If fromClient argument is true then internally this method will check client permission to
-// publish into this channel.
-func (app *Application) publish(ch Channel, data []byte, client ConnID, info *ClientInfo, fromClient bool) error {
-	return <-app.publishAsync(ch, data, client, info, fromClient)
+// publish into this channel. It returns UID of published message.
+func (app *Application) publish(ch Channel, data []byte, encoding string, client ConnID, info *ClientInfo, fromClient bool, sp *span) (string, error) {
+	uid, errCh := app.publishAsync(ch, data, encoding, client, info, fromClient, sp)
+	return uid, <-errCh
 }
 
 // pubControl publishes message into control channel so all running
@@ -378,9 +775,19 @@ func (app *Application) pubAdmin(method string, params []byte) <-chan error {
 
 // pubClient publishes message into channel so all running nodes
 // will receive it and will send to all clients on node subscribed on channel.
-func (app *Application) pubClient(ch Channel, chOpts ChannelOptions, data []byte, client ConnID, info *ClientInfo) <-chan error {
+// Message UID generated here before passing message to engine and returned
+// so publisher can correlate it with message received from channel. Engine publish
+// recorded as child of span sp if it is not nil.
+func (app *Application) pubClient(ch Channel, chOpts ChannelOptions, data []byte, encoding string, client ConnID, info *ClientInfo, sp *span) (string, <-chan error) {
+	if !messageSizeAllowed(chOpts, data) {
+		// data already checked by callers, messages replayed from history of
+		// another channel can still exceed limit of target channel.
+		return "", makeErrChan(ErrLimitExceeded)
+	}
 	message := newMessage(ch, data, client, info)
+	message.Encoding = encoding
 	app.metrics.NumMsgPublished.Inc()
+	app.namespaceMetrics(ch).NumMsgPublished.Inc()
 	if chOpts.Watch {
 		byteMessage, err := json.Marshal(message)
 		if err != nil {
@@ -389,22 +796,36 @@ func (app *Application) pubClient(ch Channel, chOpts ChannelOptions, data []byte
 			app.pubAdmin("message", byteMessage)
 		}
 	}
-	return app.engine.publishMessage(ch, message, &chOpts)
+	if sp == nil {
+		return message.UID, app.engine.publishMessage(ch, message, &chOpts)
+	}
+	esp := sp.child("engine.publish")
+	esp.setAttr("channel", string(ch))
+	esp.setAttr("engine", app.engine.name())
+	if e, ok := app.engine.(tracedPublishEngine); ok {
+		return message.UID, e.publishMessageTraced(ch, message, &chOpts, esp)
+	}
+	errCh := app.engine.publishMessage(ch, message, &chOpts)
+	esp.finish()
+	return message.UID, errCh
 }
 
 // pubJoin allows to publish join message into channel when someone subscribes on it
 // or leave message when someone unsubscribes from channel.
 func (app *Application) pubJoin(ch Channel, info ClientInfo) error {
+	app.namespaceMetrics(ch).NumJoinMsg.Inc()
 	return <-app.engine.publishJoin(ch, newJoinMessage(ch, info))
 }
 
 // pubLeave allows to publish join message into channel when someone subscribes on it
 // or leave message when someone unsubscribes from channel.
 func (app *Application) pubLeave(ch Channel, info ClientInfo) error {
+	app.namespaceMetrics(ch).NumLeaveMsg.Inc()
 	return <-app.engine.publishLeave(ch, newLeaveMessage(ch, info))
 }
 
 func (app *Application) joinMsg(ch Channel, message *JoinMessage) error {
+	app.watchMsg(ch, "join", message)
 	numSubscribers := app.clients.numSubscribers(ch)
 	if logger.TRACE.Enabled() {
 		logger.TRACE.Printf("Join message into channel %s (%d subscribers): user %s", message.Channel, numSubscribers, message.Data.User)
@@ -423,6 +844,7 @@ func (app *Application) joinMsg(ch Channel, message *JoinMessage) error {
 }
 
 func (app *Application) leaveMsg(ch Channel, message *LeaveMessage) error {
+	app.watchMsg(ch, "leave", message)
 	numSubscribers := app.clients.numSubscribers(ch)
 	if logger.TRACE.Enabled() {
 		logger.TRACE.Printf("Leave message into channel %s (%d subscribers): user %s", message.Channel, numSubscribers, message.Data.User)
@@ -445,16 +867,18 @@ func (app *Application) leaveMsg(ch Channel, message *LeaveMessage) error {
 func (app *Application) pubPing() error {
 	app.RLock()
 	info := nodeInfo{
-		UID:        app.uid,
-		Name:       app.config.Name,
-		Clients:    app.nClients(),
-		Unique:     app.nUniqueClients(),
-		Channels:   app.nChannels(),
-		Started:    app.started,
-		Goroutines: runtime.NumGoroutine(),
-		NumCPU:     runtime.NumCPU(),
-		Gomaxprocs: runtime.GOMAXPROCS(-1),
-		metrics:    *app.metrics.GetSnapshotMetrics(),
+		UID:           app.uid,
+		Name:          app.config.Name,
+		Clients:       app.nClients(),
+		Unique:        app.nUniqueClients(),
+		Channels:      app.nChannels(),
+		Subscriptions: app.clients.nSubscriptions(),
+		Started:       app.started,
+		Goroutines:    runtime.NumGoroutine(),
+		NumCPU:        runtime.NumCPU(),
+		Gomaxprocs:    runtime.GOMAXPROCS(-1),
+		metrics:       *app.metrics.GetSnapshotMetrics(),
+		Alarms:        app.alarms.active(),
 	}
 	app.RUnlock()
 	cmd := &pingControlCommand{Info: info}
@@ -491,10 +915,11 @@ func (app *Application) pubUnsubscribe(user UserID, ch Channel) error {
 
 // pubDisconnect publishes disconnect control message to all nodes – so all
 // nodes could disconnect user from Centrifugo.
-func (app *Application) pubDisconnect(user UserID) error {
+func (app *Application) pubDisconnect(user UserID, reconnect bool) error {
 
 	cmd := &disconnectControlCommand{
-		User: user,
+		User:      user,
+		Reconnect: reconnect,
 	}
 
 	cmdBytes, err := json.Marshal(cmd)
@@ -505,6 +930,57 @@ func (app *Application) pubDisconnect(user UserID) error {
 	return app.pubControl("disconnect", cmdBytes)
 }
 
+// sendAckAdvice tells client that number of messages it has not acknowledged
+// reached client_acks_advice_threshold.
+func (app *Application) sendAckAdvice(c clientConn) {
+	resp := newClientAckAdviceResponse(ackAdviceBody{
+		Outstanding: c.acks().outstanding(),
+	})
+	byteMessage, err := encodeJSON(resp)
+	if err != nil {
+		logger.ERROR.Println(err)
+		return
+	}
+	err = c.send(byteMessage)
+	if err != nil {
+		logger.ERROR.Println(err)
+	}
+}
+
+// DeliveryState returns state of messages waiting for acknowledgement from
+// connections of user on this node.
+func (app *Application) DeliveryState(user UserID) []deliveryState {
+	now := time.Now()
+	states := []deliveryState{}
+	for _, c := range app.clients.userConnections(user) {
+		window := c.acks()
+		if window == nil {
+			continue
+		}
+		state := window.state(now)
+		state.Client = c.uid()
+		state.User = c.user()
+		states = append(states, state)
+	}
+	return states
+}
+
+// pubDisconnectBulk publishes disconnect_bulk control message to all nodes so
+// all nodes could disconnect connections matching filter.
+func (app *Application) pubDisconnectBulk(filter disconnectFilter) error {
+
+	cmd := &disconnectBulkControlCommand{
+		Filter: filter,
+	}
+
+	cmdBytes, err := json.Marshal(cmd)
+	if err != nil {
+		return err
+	}
+
+	return app.pubControl("disconnect_bulk", cmdBytes)
+}
+
 // pingCmd handles ping control command i.e. updates information about known nodes.
 func (app *Application) pingCmd(cmd *pingControlCommand) error {
 	info := cmd.Info
@@ -515,10 +991,92 @@ func (app *Application) pingCmd(cmd *pingControlCommand) error {
 	return nil
 }
 
+// numRemoteSubscriptions returns total number of subscriptions on other nodes
+// according to last ping control messages.
+func (app *Application) numRemoteSubscriptions() int {
+	app.nodesMu.Lock()
+	defer app.nodesMu.Unlock()
+	total := 0
+	for uid, info := range app.nodes {
+		if uid != app.uid {
+			total += info.Subscriptions
+		}
+	}
+	return total
+}
+
+// remoteSubscribers asks other nodes for number of their subscribers in channel.
+// Returns total number of subscribers on other nodes and number of nodes which
+// answered with at least one subscriber in channel. Nodes are not asked when
+// none of them reported subscriptions in ping control messages.
+func (app *Application) remoteSubscribers(ch Channel) (int, int) {
+	if app.numRemoteSubscriptions() == 0 {
+		return 0, 0
+	}
+	replies, _, err := app.requestNodes("channel_subscribers", func(requestID string) interface{} {
+		return &channelSubscribersControlCommand{
+			RequestID: requestID,
+			Channel:   ch,
+		}
+	})
+	if err != nil {
+		logger.ERROR.Println(err)
+		return 0, 0
+	}
+	numSubscribers := 0
+	numNodes := 0
+	for _, reply := range replies {
+		if n, ok := reply.(int); ok && n > 0 {
+			numSubscribers += n
+			numNodes++
+		}
+	}
+	return numSubscribers, numNodes
+}
+
+// channelSubscribersControlCmd answers channel subscribers request of other node.
+func (app *Application) channelSubscribersControlCmd(cmd *channelSubscribersControlCommand) error {
+	cmdBytes, err := json.Marshal(&channelSubscribersReplyControlCommand{
+		RequestID:      cmd.RequestID,
+		NumSubscribers: app.clients.numSubscribers(cmd.Channel),
+	})
+	if err != nil {
+		return err
+	}
+	return app.pubControl("channel_subscribers_reply", cmdBytes)
+}
+
+// Policies applied when user reached connection limit.
+const (
+	connectionLimitReject      = "reject"
+	connectionLimitCloseOldest = "close_oldest"
+)
+
 // addConn registers authenticated connection in clientConnectionHub
-// this allows to make operations with user connection on demand.
+// this allows to make operations with user connection on demand. It returns
+// ErrLimitExceeded if user already has client_user_connection_limit connections
+// on this node (client_anonymous_connection_limit for anonymous connections) and
+// connection limit policy is reject. With close_oldest policy connection added
+// and oldest connections of user closed.
 func (app *Application) addConn(c clientConn) error {
-	return app.clients.add(c)
+	app.RLock()
+	limit := app.config.ClientUserConnectionLimit
+	if c.user() == "" {
+		limit = app.config.ClientAnonymousConnectionLimit
+	}
+	policy := app.config.ClientUserConnectionLimitPolicy
+	app.RUnlock()
+	if policy != connectionLimitCloseOldest {
+		if !app.clients.addLimited(c, limit) {
+			return ErrLimitExceeded
+		}
+		return nil
+	}
+	for _, cc := range app.clients.addEvicting(c, limit) {
+		logger.INFO.Printf("closing oldest connection %s of user %s as connection limit reached", cc.uid(), cc.user())
+		cc.close("connection limit exceeded", false)
+	}
+	return nil
 }
 
 // removeConn removes client connection from connection registry.
@@ -528,25 +1086,113 @@ func (app *Application) removeConn(c clientConn) error {
 
 // addSub registers subscription of connection on channel in both
 // engine and clientSubscriptionHub.
+// When connection is the first subscriber of channel on this node then node waits
+// for engine subscribe result no longer than subscribe_engine_timeout – on error or
+// timeout subscription removed from clientSubscriptionHub. Connections subscribing
+// meanwhile wait for the same result.
 func (app *Application) addSub(ch Channel, c clientConn) error {
+	s := app.engineSubs.lock(ch)
+	defer app.engineSubs.unlock(ch, s)
+
 	first, err := app.clients.addSub(ch, c)
 	if err != nil {
 		return err
 	}
-	if first {
-		return app.engine.subscribe(ch)
+	if !first {
+		p := s.pending
+		if p == nil {
+			return nil
+		}
+		s.Unlock()
+		<-p.done
+		s.Lock()
+		if p.err != nil {
+			app.rollbackSub(ch, c, s, p)
+		}
+		return p.err
 	}
+
+	app.RLock()
+	timeout := app.config.SubscribeEngineTimeout
+	app.RUnlock()
+
+	started := time.Now()
+	p := &pendingSubscribe{done: make(chan struct{}), errCh: app.engine.subscribe(ch)}
+	s.pending = p
+	s.Unlock()
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		select {
+		case err = <-p.errCh:
+			timer.Stop()
+		case <-timer.C:
+			err = ErrSubscribeTimeout
+		}
+	} else {
+		err = <-p.errCh
+	}
+	app.metrics.histograms.RecordMicroseconds("engine_subscribe", time.Now().Sub(started))
+	s.Lock()
+	p.err = err
+	close(p.done)
+
+	if err != nil {
+		app.metrics.NumEngineErrors.Inc()
+		logger.ERROR.Printf("error subscribing node on channel %s: %v", ch, err)
+		app.rollbackSub(ch, c, s, p)
+		return err
+	}
+	s.pending = nil
 	return nil
 }
 
+// rollbackSub removes subscription of connection after engine subscribe failed.
+// Pending subscribe ends when its last subscriber removed so next connection
+// subscribes node again. Must be called with engine subscription locked.
+func (app *Application) rollbackSub(ch Channel, c clientConn, s *engineSub, p *pendingSubscribe) {
+	empty, err := app.clients.removeSub(ch, c)
+	if err != nil {
+		logger.ERROR.Println(err)
+	}
+	if !empty {
+		return
+	}
+	s.pending = nil
+	if p.err == ErrSubscribeTimeout {
+		// Engine can still subscribe node on channel after timeout so we wait
+		// for result without blocking client as engine can be unavailable.
+		go app.unsubscribeLate(ch, p.errCh)
+	}
+}
+
+// unsubscribeLate waits for result of engine subscribe which timed out and
+// unsubscribes node from channel if engine subscribed it after all. Node stays
+// subscribed if channel got new subscribers or admin watches meanwhile.
+func (app *Application) unsubscribeLate(ch Channel, errCh <-chan error) {
+	if err := <-errCh; err != nil {
+		return
+	}
+	s := app.engineSubs.lock(ch)
+	defer app.engineSubs.unlock(ch, s)
+	if app.clients.numSubscribers(ch) > 0 || app.adminWatches.watched(ch) {
+		return
+	}
+	if err := app.engine.unsubscribe(ch); err != nil {
+		logger.ERROR.Println(err)
+	}
+}
+
 // removeSub removes subscription of connection on channel
 // from both engine and clientSubscriptionHub.
 func (app *Application) removeSub(ch Channel, c clientConn) error {
+	s := app.engineSubs.lock(ch)
+	defer app.engineSubs.unlock(ch, s)
 	empty, err := app.clients.removeSub(ch, c)
 	if err != nil {
 		return err
 	}
-	if empty {
+	if empty && !app.adminWatches.watched(ch) {
+		// node stays subscribed on channel watched by admin connections.
 		return app.engine.unsubscribe(ch)
 	}
 	return nil
@@ -555,80 +1201,210 @@ func (app *Application) removeSub(ch Channel, c clientConn) error {
 // Unsubscribe unsubscribes user from channel, if channel is equal to empty
 // string then user will be unsubscribed from all channels.
 func (app *Application) Unsubscribe(user UserID, ch Channel) error {
+	_, err := app.unsubscribe(user, ch)
+	return err
+}
+
+// unsubscribe unsubscribes user from channel on all nodes and returns number of
+// connections on this node which were unsubscribed.
+func (app *Application) unsubscribe(user UserID, ch Channel) (int, error) {
 
 	if string(user) == "" {
-		return ErrInvalidMessage
+		return 0, ErrInvalidMessage
 	}
 
 	if string(ch) != "" {
 		_, err := app.channelOpts(ch)
 		if err != nil {
-			return err
+			return 0, err
 		}
 	}
 
 	// First unsubscribe on this node.
-	err := app.unsubscribeUser(user, ch)
+	affected, err := app.unsubscribeUser(user, ch)
 	if err != nil {
-		return ErrInternalServerError
+		return affected, ErrInternalServerError
 	}
 	// Second send unsubscribe control message to other nodes.
 	err = app.pubUnsubscribe(user, ch)
 	if err != nil {
-		return ErrInternalServerError
+		return affected, ErrInternalServerError
 	}
-	return nil
+	return affected, nil
 }
 
 // unsubscribeUser unsubscribes user from channel on this node. If channel
-// is an empty string then user will be unsubscribed from all channels.
-func (app *Application) unsubscribeUser(user UserID, ch Channel) error {
+// is an empty string then user will be unsubscribed from all channels. It
+// returns number of connections which were subscribed on channel.
+func (app *Application) unsubscribeUser(user UserID, ch Channel) (int, error) {
+	affected := 0
 	userConnections := app.clients.userConnections(user)
 	for _, c := range userConnections {
 		var channels []Channel
-		if string(ch) == "" {
-			// unsubscribe from all channels
-			channels = c.channels()
-		} else {
-			channels = []Channel{ch}
+		for _, channel := range c.channels() {
+			if string(ch) == "" || channel == ch {
+				channels = append(channels, channel)
+			}
 		}
+		if len(channels) == 0 {
+			continue
+		}
+		affected++
 
 		for _, channel := range channels {
-			err := c.unsubscribe(channel)
+			err := c.unsubscribe(channel, false)
 			if err != nil {
-				return err
+				return affected, err
 			}
 		}
 	}
-	return nil
+	return affected, nil
 }
 
 // Disconnect allows to close all user connections to Centrifugo. Note that user still
 // can try to reconnect to the server after being disconnected.
 func (app *Application) Disconnect(user UserID) error {
+	return app.disconnect(user, false)
+}
+
+// disconnect disconnects user from all nodes advising clients whether they should
+// reconnect.
+func (app *Application) disconnect(user UserID, reconnect bool) error {
 
 	if string(user) == "" {
 		return ErrInvalidMessage
 	}
 
 	// first disconnect user from this node
-	err := app.disconnectUser(user)
+	err := app.disconnectUser(user, reconnect)
 	if err != nil {
 		return ErrInternalServerError
 	}
 	// second send disconnect control message to other nodes
-	err = app.pubDisconnect(user)
+	err = app.pubDisconnect(user, reconnect)
 	if err != nil {
 		return ErrInternalServerError
 	}
 	return nil
 }
 
+// DisconnectClient closes single connection with provided ConnID advising client
+// whether it should reconnect. Connection looked up on this node first and then
+// on other nodes, it returns true if connection was found and closed.
+func (app *Application) DisconnectClient(client ConnID, reconnect bool) (bool, error) {
+
+	if string(client) == "" {
+		return false, ErrInvalidMessage
+	}
+
+	found, err := app.disconnectClient(client, reconnect)
+	if err != nil {
+		return false, ErrInternalServerError
+	}
+	if found {
+		return true, nil
+	}
+
+	replies, _, err := app.requestNodes("disconnect_client", func(requestID string) interface{} {
+		return &disconnectClientControlCommand{
+			RequestID: requestID,
+			Client:    client,
+			Reconnect: reconnect,
+		}
+	})
+	if err != nil {
+		logger.ERROR.Println(err)
+		return false, ErrInternalServerError
+	}
+	for _, reply := range replies {
+		if found, ok := reply.(bool); ok && found {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// disconnectClient closes connection with provided ConnID if it exists on current
+// node.
+func (app *Application) disconnectClient(client ConnID, reconnect bool) (bool, error) {
+	c, ok := app.clients.connection(client)
+	if !ok {
+		return false, nil
+	}
+	return true, c.close("disconnect", reconnect)
+}
+
+// disconnectClientControlCmd closes connection requested by other node and
+// replies whether connection was found on current node.
+func (app *Application) disconnectClientControlCmd(cmd *disconnectClientControlCommand) error {
+	found, err := app.disconnectClient(cmd.Client, cmd.Reconnect)
+	if err != nil {
+		logger.ERROR.Println(err)
+	}
+	cmdBytes, err := json.Marshal(&disconnectClientReplyControlCommand{
+		RequestID: cmd.RequestID,
+		Found:     found,
+	})
+	if err != nil {
+		return err
+	}
+	return app.pubControl("disconnect_client_reply", cmdBytes)
+}
+
+// DisconnectBulk disconnects all connections matching filter. Connections on
+// current node disconnected in background paced according to bulk_disconnect_rate
+// option, other nodes receive control message with filter. It returns number
+// of matching connections on current node.
+func (app *Application) DisconnectBulk(filter disconnectFilter) (int, error) {
+
+	if filter.empty() {
+		return 0, ErrInvalidMessage
+	}
+
+	conns := app.clients.filterConnections(filter)
+	go app.disconnectConnections(conns)
+
+	err := app.pubDisconnectBulk(filter)
+	if err != nil {
+		return len(conns), ErrInternalServerError
+	}
+	return len(conns), nil
+}
+
+// disconnectBulk closes client connections matching filter on current node.
+func (app *Application) disconnectBulk(filter disconnectFilter) {
+	app.disconnectConnections(app.clients.filterConnections(filter))
+}
+
+// disconnectConnections closes connections one by one making sure that no more
+// than bulk_disconnect_rate connections closed per second to prevent reconnect
+// storms. Zero rate means closing connections without pause.
+func (app *Application) disconnectConnections(conns []clientConn) {
+	app.RLock()
+	rate := app.config.BulkDisconnectRate
+	app.RUnlock()
+
+	var interval time.Duration
+	if rate > 0 {
+		interval = time.Second / time.Duration(rate)
+	}
+
+	for i, c := range conns {
+		if i > 0 && interval > 0 {
+			time.Sleep(interval)
+		}
+		err := c.close("disconnect", true)
+		if err != nil {
+			logger.ERROR.Println(err)
+		}
+	}
+}
+
 // disconnectUser closes client connections of user on current node.
-func (app *Application) disconnectUser(user UserID) error {
+func (app *Application) disconnectUser(user UserID, reconnect bool) error {
 	userConnections := app.clients.userConnections(user)
 	for _, c := range userConnections {
-		err := c.close("disconnect")
+		err := c.close("disconnect", reconnect)
 		if err != nil {
 			return err
 		}
@@ -648,20 +1424,117 @@ func (app *Application) namespaceKey(ch Channel) NamespaceKey {
 
 // channelOpts returns channel options for channel using current application structure.
 func (app *Application) channelOpts(ch Channel) (ChannelOptions, error) {
+	_, opts, err := app.channelNamespace(ch)
+	return opts, err
+}
+
+// channelNamespace returns name and channel options of namespace channel belongs
+// to. Namespace with name from channel prefix takes precedence over first namespace
+// with pattern matching channel, then top level options used for channels without
+// namespace prefix and namespace template for unknown namespaces.
+func (app *Application) channelNamespace(ch Channel) (NamespaceKey, ChannelOptions, error) {
+	return app.lookupChannelNamespace(ch, true)
+}
+
+// lookupChannelNamespace works like channelNamespace but namespace matching template
+// only created if create is true, otherwise template options returned.
+func (app *Application) lookupChannelNamespace(ch Channel, create bool) (NamespaceKey, ChannelOptions, error) {
 	app.RLock()
 	defer app.RUnlock()
 	nk := app.namespaceKey(ch)
-	return app.config.channelOpts(nk)
+	if nk != NamespaceKey("") {
+		if opts, err := app.config.channelOpts(nk); err == nil {
+			return nk, opts, nil
+		}
+	}
+	if len(app.namespaceMatchers) > 0 {
+		name := strings.TrimPrefix(string(ch), app.config.PrivateChannelPrefix)
+		for _, m := range app.namespaceMatchers {
+			if m.match(name) {
+				return m.name, m.opts, nil
+			}
+		}
+	}
+	if nk == NamespaceKey("") {
+		return nk, app.config.ChannelOptions, nil
+	}
+	opts, err := app.dynamicChannelOpts(nk, create)
+	return nk, opts, err
 }
 
-// addPresence proxies presence adding to engine.
+// previousSecretLogInterval is a minimal interval between log messages about tokens
+// and signs valid only with previous secret.
+const previousSecretLogInterval = time.Minute
+
+// previousSecretUsed counts token or sign (what) valid only with previous secret
+// and logs it at most once in previousSecretLogInterval.
+func (app *Application) previousSecretUsed(what string) {
+	app.metrics.NumSecretPreviousUsed.Inc()
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&app.previousSecretLogged)
+	if now-last < int64(previousSecretLogInterval) || !atomic.CompareAndSwapInt64(&app.previousSecretLogged, last, now) {
+		return
+	}
+	logger.WARN.Printf("%s valid only with previous secret, secret_previous still in use", what)
+}
+
+// namespaceSecret returns secret of namespace channel belongs to, empty string if
+// namespace has no own secret. Namespaces created from template use secret of
+// template namespace.
+func (app *Application) namespaceSecret(ch Channel) string {
+	nk, _, err := app.channelNamespace(ch)
+	if err != nil || nk == NamespaceKey("") {
+		return ""
+	}
+	app.RLock()
+	defer app.RUnlock()
+	if _, err := app.config.channelOpts(nk); err == nil {
+		return app.config.namespaceSecret(nk)
+	}
+	if _, ok := app.dynamicNamespaces.get(nk); ok {
+		return app.config.namespaceSecret(app.config.NamespaceTemplate.Namespace)
+	}
+	return ""
+}
+
+// dynamicChannelOpts returns channel options for namespace not found in configuration
+// creating it from namespace template if namespace name matches template pattern
+// and create is true. Must be called with application read lock held.
+func (app *Application) dynamicChannelOpts(nk NamespaceKey, create bool) (ChannelOptions, error) {
+	tmpl := app.config.NamespaceTemplate
+	if tmpl.Pattern == "" {
+		return ChannelOptions{}, ErrNamespaceNotFound
+	}
+	if opts, ok := app.dynamicNamespaces.get(nk); ok {
+		return opts, nil
+	}
+	if match, _ := path.Match(tmpl.Pattern, string(nk)); !match {
+		return ChannelOptions{}, ErrNamespaceNotFound
+	}
+	opts, err := app.config.channelOpts(tmpl.Namespace)
+	if err != nil || !create {
+		return opts, err
+	}
+	n := app.dynamicNamespaces.add(nk, opts, tmpl.MaxNamespaces)
+	atomic.StoreInt64(&app.metrics.NumDynamicNamespaces, int64(n))
+	return opts, nil
+}
+
+// addPresence proxies presence adding to engine. Cached presence of channel
+// dropped unless client already in it (periodic presence update).
 func (app *Application) addPresence(ch Channel, uid ConnID, info ClientInfo) error {
-	return app.engine.addPresence(ch, uid, info)
+	err := app.engine.addPresence(ch, uid, info)
+	if !app.presenceCache.contains(ch, uid) {
+		app.presenceCache.invalidate(ch)
+	}
+	return err
 }
 
 // removePresence proxies presence removing to engine.
 func (app *Application) removePresence(ch Channel, uid ConnID) error {
-	return app.engine.removePresence(ch, uid)
+	err := app.engine.removePresence(ch, uid)
+	app.presenceCache.invalidate(ch)
+	return err
 }
 
 // Presence returns a map of active clients in project channel.
@@ -680,17 +1553,96 @@ func (app *Application) Presence(ch Channel) (map[ConnID]ClientInfo, error) {
 		return map[ConnID]ClientInfo{}, ErrNotAvailable
 	}
 
+	app.RLock()
+	ttl := app.config.PresenceCacheTTL
+	size := app.config.PresenceCacheSize
+	app.RUnlock()
+	cacheEnabled := ttl > 0 && size > 0
+
+	if cacheEnabled {
+		if presence, ok := app.presenceCache.get(ch, time.Now()); ok {
+			app.metrics.NumPresenceCacheHits.Inc()
+			return presence, nil
+		}
+		app.metrics.NumPresenceCacheMisses.Inc()
+	}
+
+	var generation uint64
+	if cacheEnabled {
+		generation = app.presenceCache.begin(ch)
+	}
 	presence, err := app.engine.presence(ch)
 	if err != nil {
+		if cacheEnabled {
+			app.presenceCache.abort(ch, generation)
+		}
 		logger.ERROR.Println(err)
 		return map[ConnID]ClientInfo{}, ErrInternalServerError
 	}
+	if cacheEnabled {
+		app.presenceCache.set(ch, generation, presence, ttl, size, time.Now())
+	}
 	return presence, nil
 }
 
+// PresenceStats returns number of clients and number of unique users
+// currently subscribed on channel.
+func (app *Application) PresenceStats(ch Channel) (int, int, error) {
+	presence, err := app.Presence(ch)
+	if err != nil {
+		return 0, 0, err
+	}
+	users := make(map[string]struct{}, len(presence))
+	for _, info := range presence {
+		users[info.User] = struct{}{}
+	}
+	return len(presence), len(users), nil
+}
+
 // History returns a slice of last messages published into project channel.
 func (app *Application) History(ch Channel) ([]Message, error) {
+	return app.history(ch, false)
+}
+
+// historyInfo returns summary of channel history. Engines implementing
+// historyInfoEngine count messages themselves, otherwise whole history loaded.
+func (app *Application) historyInfo(ch Channel) (*channelHistoryInfo, error) {
+	var n int
+	var newest, oldest *Message
+	if e, ok := app.engine.(historyInfoEngine); ok {
+		var err error
+		n, newest, oldest, err = e.historyInfo(ch)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		history, err := app.History(ch)
+		if err != nil {
+			return nil, err
+		}
+		n = len(history)
+		if n > 0 {
+			// history ordered from newest to oldest message.
+			newest, oldest = &history[0], &history[n-1]
+		}
+	}
+	info := &channelHistoryInfo{
+		Length: n,
+	}
+	if newest != nil && oldest != nil {
+		info.NewestTimestamp = newest.Timestamp
+		info.OldestTimestamp = oldest.Timestamp
+	}
+	return info, nil
+}
 
+// recoveryHistory returns history used to recover missed messages. Engines
+// implementing recoveryHistoryEngine decide themselves where to read it from.
+func (app *Application) recoveryHistory(ch Channel) ([]Message, error) {
+	return app.history(ch, true)
+}
+
+func (app *Application) history(ch Channel, recovery bool) ([]Message, error) {
 	if string(ch) == "" {
 		return []Message{}, ErrInvalidMessage
 	}
@@ -704,7 +1656,12 @@ func (app *Application) History(ch Channel) ([]Message, error) {
 		return []Message{}, ErrNotAvailable
 	}
 
-	history, err := app.engine.history(ch, 0)
+	var history []Message
+	if e, ok := app.engine.(recoveryHistoryEngine); ok && recovery {
+		history, err = e.recoveryHistory(ch, 0)
+	} else {
+		history, err = app.engine.history(ch, 0)
+	}
 	if err != nil {
 		logger.ERROR.Println(err)
 		return []Message{}, ErrInternalServerError
@@ -733,7 +1690,8 @@ func (app *Application) privateChannel(ch Channel) bool {
 
 // userAllowed checks if user can subscribe on channel - as channel
 // can contain special part in the end to indicate which users allowed
-// to subscribe on it.
+// to subscribe on it. Anonymous user never allowed to subscribe on user
+// limited channel, empty user part allows nobody.
 func (app *Application) userAllowed(ch Channel, user UserID) bool {
 	app.RLock()
 	defer app.RUnlock()
@@ -741,6 +1699,9 @@ func (app *Application) userAllowed(ch Channel, user UserID) bool {
 		return true
 	}
 	parts := strings.Split(string(ch), app.config.UserChannelBoundary)
+	if user == "" {
+		return false
+	}
 	allowedUsers := strings.Split(parts[len(parts)-1], app.config.UserChannelSeparator)
 	for _, allowedUser := range allowedUsers {
 		if string(user) == allowedUser {
@@ -795,52 +1756,116 @@ func (app *Application) nUniqueClients() int {
 const (
 	// AuthTokenKey is a key for admin authorization token.
 	AuthTokenKey = "token"
-	// AuthTokenValue is a value for secure admin authorization token.
+	// AuthTokenValue is a value for secure admin authorization token. Tokens
+	// with expiration have value with issue and expiration unix times appended
+	// like "authorized:1500000000:1500086400".
 	AuthTokenValue = "authorized"
 )
 
+// adminTokenCodecMaxAge is a max age of admin tokens checked by securecookie, it
+// also limits age of legacy tokens without expiration.
+const adminTokenCodecMaxAge = 86400 * 30
+
+// adminTokenCodec returns codec of admin tokens accepting tokens not older than
+// ttl.
+func adminTokenCodec(secret string, ttl time.Duration) *securecookie.SecureCookie {
+	maxAge := adminTokenCodecMaxAge
+	if ttl == 0 || int(ttl.Seconds()) > maxAge {
+		// expiration of tokens checked using time in token value.
+		maxAge = 0
+	}
+	return securecookie.New([]byte(secret), nil).MaxAge(maxAge)
+}
+
 func (app *Application) adminAuthToken() (string, error) {
+	token, _, err := app.newAdminAuthToken(time.Now())
+	return token, err
+}
+
+// newAdminAuthToken generates admin token valid for admin_auth_ttl and returns
+// it together with its expiration time, zero time means token never expires.
+func (app *Application) newAdminAuthToken(now time.Time) (string, time.Time, error) {
 	app.RLock()
 	secret := app.config.AdminSecret
+	ttl := app.config.AdminAuthTTL
 	app.RUnlock()
 	if secret == "" {
 		logger.ERROR.Println("provide web_secret in configuration")
-		return "", ErrInternalServerError
+		return "", time.Time{}, ErrInternalServerError
+	}
+	var expires time.Time
+	var exp int64
+	if ttl > 0 {
+		expires = now.Add(ttl)
+		exp = expires.Unix()
+	}
+	value := fmt.Sprintf("%s:%d:%d", AuthTokenValue, now.Unix(), exp)
+	token, err := adminTokenCodec(secret, ttl).Encode(AuthTokenKey, value)
+	if err != nil {
+		return "", time.Time{}, err
 	}
-	s := securecookie.New([]byte(secret), nil)
-	return s.Encode(AuthTokenKey, AuthTokenValue)
+	return token, expires, nil
 }
 
 // checkAdminAuthToken checks admin connection token which Centrifugo returns after admin login.
 func (app *Application) checkAdminAuthToken(token string) error {
+	_, err := app.adminAuthTokenExpires(token, time.Now())
+	return err
+}
+
+// adminAuthTokenExpires checks admin token and returns its expiration time, zero
+// time returned for tokens without expiration and in insecure admin mode.
+func (app *Application) adminAuthTokenExpires(token string, now time.Time) (time.Time, error) {
 
 	app.RLock()
 	insecure := app.config.InsecureAdmin
 	secret := app.config.AdminSecret
+	ttl := app.config.AdminAuthTTL
+	legacyEnabled := app.config.AdminAuthLegacyEnabled
 	app.RUnlock()
 
 	if insecure {
-		return nil
+		return time.Time{}, nil
 	}
 
 	if secret == "" {
 		logger.ERROR.Println("provide admin_secret in configuration")
-		return ErrUnauthorized
+		return time.Time{}, ErrUnauthorized
 	}
 
 	if token == "" {
-		return ErrUnauthorized
+		return time.Time{}, ErrUnauthorized
 	}
 
-	s := securecookie.New([]byte(secret), nil)
 	var val string
-	err := s.Decode(AuthTokenKey, token, &val)
+	err := adminTokenCodec(secret, ttl).Decode(AuthTokenKey, token, &val)
 	if err != nil {
-		return ErrUnauthorized
+		return time.Time{}, ErrUnauthorized
 	}
 
-	if val != AuthTokenValue {
-		return ErrUnauthorized
+	if val == AuthTokenValue {
+		// token generated by older versions without expiration.
+		if !legacyEnabled {
+			logger.ERROR.Println("admin token without expiration rejected as admin_auth_legacy_enabled is off")
+			return time.Time{}, ErrUnauthorized
+		}
+		return time.Time{}, nil
 	}
-	return nil
+
+	parts := strings.Split(val, ":")
+	if len(parts) != 3 || parts[0] != AuthTokenValue {
+		return time.Time{}, ErrUnauthorized
+	}
+	exp, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return time.Time{}, ErrUnauthorized
+	}
+	if exp == 0 {
+		return time.Time{}, nil
+	}
+	expires := time.Unix(exp, 0)
+	if !now.Before(expires) {
+		return time.Time{}, ErrUnauthorized
+	}
+	return expires, nil
 }
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -11,8 +13,10 @@ import (
 )
 
 type testSession struct {
+	sync.Mutex
 	sink   chan []byte
 	closed bool
+	reason string
 }
 
 func (t *testSession) Send(msg []byte) error {
@@ -23,7 +27,10 @@ func (t *testSession) Send(msg []byte) error {
 }
 
 func (t *testSession) Close(status uint32, reason string) error {
+	t.Lock()
+	defer t.Unlock()
 	t.closed = true
+	t.reason = reason
 	return nil
 }
 
@@ -114,6 +121,12 @@ func TestUserAllowed(t *testing.T) {
 	assert.Equal(t, true, app.userAllowed("channel#1,2", "1"))
 	assert.Equal(t, true, app.userAllowed("channel#1,2", "2"))
 	assert.Equal(t, false, app.userAllowed("channel#1,2", "3"))
+	// empty user part allows nobody.
+	assert.Equal(t, false, app.userAllowed("channel#", "1"))
+	assert.Equal(t, false, app.userAllowed("channel#", ""))
+	// anonymous user never allowed.
+	assert.Equal(t, false, app.userAllowed("channel#1,,2", ""))
+	assert.Equal(t, true, app.userAllowed("channel", ""))
 }
 
 func TestSetConfig(t *testing.T) {
@@ -169,6 +182,137 @@ func TestApplicationNode(t *testing.T) {
 	assert.NotEqual(t, 0, info.Started)
 }
 
+func TestRemoteSubscribers(t *testing.T) {
+	app := testMemoryApp()
+	createTestClients(app, 1, 2, nil)
+	err := app.pubPing()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, app.nodes[app.uid].Subscriptions)
+
+	// other nodes not asked while they report no subscriptions.
+	app.pingCmd(&pingControlCommand{Info: nodeInfo{UID: "other"}})
+	numSubscribers, numNodes := app.remoteSubscribers(Channel("channel-0"))
+	assert.Equal(t, 0, numSubscribers)
+	assert.Equal(t, 0, numNodes)
+	assert.Equal(t, 0, len(app.controlRequests.requests))
+
+	app.pingCmd(&pingControlCommand{Info: nodeInfo{UID: "other", Subscriptions: 5}})
+	done := make(chan int)
+	go func() {
+		numSubscribers, numNodes := app.remoteSubscribers(Channel("channel-0"))
+		assert.Equal(t, 1, numNodes)
+		done <- numSubscribers
+	}()
+	var requestID string
+	assert.True(t, waitCondition(func() bool {
+		app.controlRequests.Lock()
+		defer app.controlRequests.Unlock()
+		for id := range app.controlRequests.requests {
+			requestID = id
+		}
+		return requestID != ""
+	}))
+	reply, _ := json.Marshal(channelSubscribersReplyControlCommand{
+		RequestID:      requestID,
+		NumSubscribers: 5,
+	})
+	assert.Equal(t, nil, app.controlMsg(newControlMessage("other", "channel_subscribers_reply", reply)))
+	assert.Equal(t, 5, <-done)
+}
+
+func TestDisconnectUserNoReconnect(t *testing.T) {
+	app := testMemoryApp()
+	c := newTestUserCC()
+	app.clients.add(c)
+	err := app.disconnectUser(c.UID, false)
+	assert.Equal(t, nil, err)
+	assert.True(t, c.Closed)
+	assert.False(t, c.Reconnect)
+}
+
+func TestDisconnectUserReconnect(t *testing.T) {
+	app := testMemoryApp()
+	c := newTestUserCC()
+	app.clients.add(c)
+	params, _ := json.Marshal(disconnectControlCommand{User: UserID(c.UID), Reconnect: true})
+	err := app.controlMsg(newControlMessage("another node", "disconnect", params))
+	assert.Equal(t, nil, err)
+	assert.True(t, c.Closed)
+	assert.True(t, c.Reconnect)
+}
+
+func TestDisconnectClient(t *testing.T) {
+	app := testMemoryApp()
+	c := newTestUserCC()
+	other := &testClientConn{CID: "other uid", UID: c.UID}
+	app.clients.add(c)
+	app.clients.add(other)
+
+	_, err := app.DisconnectClient("", false)
+	assert.Equal(t, ErrInvalidMessage, err)
+
+	found, err := app.DisconnectClient("unknown", false)
+	assert.Equal(t, nil, err)
+	assert.False(t, found)
+
+	found, err = app.DisconnectClient(c.CID, true)
+	assert.Equal(t, nil, err)
+	assert.True(t, found)
+	assert.True(t, c.Closed)
+	assert.True(t, c.Reconnect)
+	assert.False(t, other.Closed)
+}
+
+func TestDisconnectClientControl(t *testing.T) {
+	app := testMemoryApp()
+	c := newTestUserCC()
+	app.clients.add(c)
+	params, _ := json.Marshal(disconnectClientControlCommand{RequestID: "1", Client: c.CID})
+	err := app.controlMsg(newControlMessage("another node", "disconnect_client", params))
+	assert.Equal(t, nil, err)
+	assert.True(t, c.Closed)
+	assert.False(t, c.Reconnect)
+}
+
+func TestDisconnectConnectionsPacing(t *testing.T) {
+	app := testMemoryApp()
+	conns := []clientConn{newTestUserCC(), newTestUserCC(), newTestUserCC()}
+
+	app.config.BulkDisconnectRate = 20
+	started := time.Now()
+	app.disconnectConnections(conns)
+	// 3 connections with rate 20 per second require at least 2 pauses of 50ms.
+	assert.True(t, time.Since(started) >= 100*time.Millisecond)
+	for _, c := range conns {
+		assert.True(t, c.(*testClientConn).Closed)
+		assert.True(t, c.(*testClientConn).Reconnect)
+	}
+
+	app.config.BulkDisconnectRate = 0
+	conns = []clientConn{newTestUserCC(), newTestUserCC(), newTestUserCC()}
+	started = time.Now()
+	app.disconnectConnections(conns)
+	assert.True(t, time.Since(started) < 50*time.Millisecond)
+	for _, c := range conns {
+		assert.True(t, c.(*testClientConn).Closed)
+	}
+}
+
+func TestDisconnectBulk(t *testing.T) {
+	app := testMemoryApp()
+	_, err := app.DisconnectBulk(disconnectFilter{})
+	assert.Equal(t, ErrInvalidMessage, err)
+
+	c := &testClientConn{CID: "1", UID: "user-1", Transport: "jsonp"}
+	app.clients.add(c)
+	matched, err := app.DisconnectBulk(disconnectFilter{Transport: "websocket"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, matched)
+	matched, err = app.DisconnectBulk(disconnectFilter{Transport: "jsonp"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, matched)
+}
+
 func BenchmarkNamespaceKey(b *testing.B) {
 	app := testApp()
 	ch := Channel("test")
@@ -224,6 +368,95 @@ func TestPublish(t *testing.T) {
 
 }
 
+func TestPublishClientAcks(t *testing.T) {
+	c := newTestConfig()
+	c.ClientAcksWindow = 2
+	c.ClientAcksAdviceThreshold = 2
+	app := testMemoryAppWithConfig(&c)
+
+	conn := &testClientConn{CID: "1", UID: "1", Acks: newAckWindow(2, 2)}
+	app.clients.add(conn)
+	app.clients.addSub("channel", conn)
+
+	// acks disabled in channel.
+	_, err := app.publish(Channel("channel"), []byte(`{}`), "", "", nil, false, nil)
+	assert.Equal(t, nil, err)
+	assert.False(t, strings.Contains(string(conn.Messages[0]), `"ack"`))
+	assert.Equal(t, 0, conn.Acks.outstanding())
+
+	app.config.ChannelOptions.ClientAcks = true
+	_, err = app.publish(Channel("channel"), []byte(`{}`), "", "", nil, false, nil)
+	assert.Equal(t, nil, err)
+	assert.True(t, strings.Contains(string(conn.Messages[1]), `{"method":"message","ack":"`))
+	assert.Equal(t, 1, conn.Acks.outstanding())
+
+	// reaching threshold sends advice to client.
+	_, err = app.publish(Channel("channel"), []byte(`{}`), "", "", nil, false, nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 4, len(conn.Messages))
+	assert.True(t, strings.Contains(string(conn.Messages[3]), `"method":"ack_advice"`))
+	assert.True(t, strings.Contains(string(conn.Messages[3]), `"outstanding":2`))
+
+	_, err = app.publish(Channel("channel"), []byte(`{}`), "", "", nil, false, nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(1), app.metrics.NumAcksDropped.LoadRaw())
+
+	states := app.DeliveryState("1")
+	assert.Equal(t, 1, len(states))
+	assert.Equal(t, ConnID("1"), states[0].Client)
+	assert.Equal(t, 2, states[0].Outstanding)
+	assert.Equal(t, int64(1), states[0].Dropped)
+}
+
+func TestPublishBinary(t *testing.T) {
+	c := newTestConfig()
+	c.ChannelOptions.HistorySize = 10
+	c.ChannelOptions.HistoryLifetime = 10
+	app := testMemoryAppWithConfig(&c)
+
+	binaryConn := &testClientConn{CID: "binary", UID: "1", Binary: true}
+	textConn := &testClientConn{CID: "text", UID: "2"}
+	app.clients.add(binaryConn)
+	app.clients.add(textConn)
+	app.clients.addSub("channel", binaryConn)
+	app.clients.addSub("channel", textConn)
+
+	// binary payloads disabled.
+	_, err := app.publish(Channel("channel"), []byte(`"AAEC"`), PayloadEncodingBinary, "", nil, false, nil)
+	assert.Equal(t, ErrPermissionDenied, err)
+
+	app.config.ChannelOptions.BinaryPayloads = true
+	_, err = app.publish(Channel("channel"), []byte(`"AAEC"`), PayloadEncodingBinary, "", nil, false, nil)
+	assert.Equal(t, nil, err)
+	_, err = app.publish(Channel("channel"), []byte(`{"json": true}`), "", "", nil, false, nil)
+	assert.Equal(t, nil, err)
+
+	// binary connection receives protobuf encoded message for binary payload
+	// and JSON for ordinary one.
+	assert.Equal(t, 2, len(binaryConn.Messages))
+	var msg Message
+	err = msg.Unmarshal(binaryConn.Messages[0])
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []byte{0, 1, 2}, []byte(*msg.Data))
+	assert.Equal(t, PayloadEncodingBinary, msg.Encoding)
+	assert.True(t, strings.Contains(string(binaryConn.Messages[1]), `"data":{"json": true}`))
+
+	// other connections receive base64 encoded data.
+	assert.Equal(t, 2, len(textConn.Messages))
+	assert.True(t, strings.Contains(string(textConn.Messages[0]), `"data":"AAEC","encoding":"binary"`))
+	assert.True(t, strings.Contains(string(textConn.Messages[1]), `"data":{"json": true}`))
+
+	// history keeps raw binary data with encoding.
+	history, err := app.History(Channel("channel"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(history))
+	assert.Equal(t, PayloadEncodingBinary, history[1].Encoding)
+	assert.Equal(t, []byte{0, 1, 2}, []byte(*history[1].Data))
+	historyJSON, err := json.Marshal(history)
+	assert.Equal(t, nil, err)
+	assert.True(t, strings.Contains(string(historyJSON), `"data":"AAEC"`))
+}
+
 func TestPublishJoinLeave(t *testing.T) {
 	app := testMemoryApp()
 	createTestClients(app, 10, 1, nil)
@@ -249,6 +482,10 @@ func TestControlMessages(t *testing.T) {
 	assert.Equal(t, nil, err)
 	err = app.controlMsg(testDisconnectControlCmd("another node"))
 	assert.Equal(t, nil, err)
+	err = app.controlMsg(newControlMessage("another node", "disconnect_bulk", []byte("{}")))
+	assert.Equal(t, ErrInvalidMessage, err)
+	err = app.controlMsg(newControlMessage("another node", "disconnect_bulk", []byte(`{"filter": {"transport": "jsonp"}}`)))
+	assert.Equal(t, nil, err)
 }
 
 func TestUpdateMetrics(t *testing.T) {
@@ -417,3 +654,23 @@ func BenchmarkReceiveBroadcast(b *testing.B) {
 	}
 	b.StopTimer()
 }
+
+func TestNotifyShutdown(t *testing.T) {
+	app := testMemoryApp()
+	select {
+	case <-app.NotifyShutdown():
+		t.Fatal("shutdown notified before shutdown")
+	default:
+	}
+	app.Shutdown()
+	select {
+	case <-app.NotifyShutdown():
+	default:
+		t.Fatal("shutdown not notified")
+	}
+	select {
+	case <-app.shutdownCh:
+	default:
+		t.Fatal("engine not stopped after shutdown")
+	}
+}
@@ -0,0 +1,79 @@
+package libcentrifugo
+
+import (
+	"time"
+)
+
+// auditParamsMaxSize is a max size of command params kept in audit entry, longer
+// params truncated.
+const auditParamsMaxSize = 256
+
+// Sources of audited commands.
+const (
+	auditSourceAPI      = "api"
+	auditSourceRedisAPI = "redis_api"
+	auditSourceAdmin    = "admin"
+)
+
+// auditResultOK is a result of audited command finished without error.
+const auditResultOK = "ok"
+
+// auditEntry is a line written into audit log.
+type auditEntry struct {
+	Time       string `json:"time"`
+	Source     string `json:"source"`
+	RemoteAddr string `json:"remote_addr,omitempty"`
+	// APIKey set for HTTP API requests signed with named API key, Admin set for
+	// commands sent over admin connection.
+	APIKey string `json:"api_key,omitempty"`
+	Admin  ConnID `json:"admin,omitempty"`
+	Method string `json:"method"`
+	Params string `json:"params"`
+	// Result is ok or error command finished with.
+	Result string `json:"result"`
+}
+
+// errResponse is implemented by API responses to get error command finished with.
+type errResponse interface {
+	responseErr() error
+}
+
+// audited returns true if command must be written into audit log. Publications
+// only audited when audit_log_publish enabled as they are the bulk of API traffic.
+func (app *Application) audited(method string) bool {
+	if app.auditLog == nil || !adminActions[method] {
+		return false
+	}
+	if method == "publish" || method == "broadcast" {
+		app.RLock()
+		publish := app.config.AuditLogPublish
+		app.RUnlock()
+		return publish
+	}
+	return true
+}
+
+// audit adds command with its result into audit log if it is enabled and command
+// changes state of clients or channels. Error returned by command handler takes
+// precedence over error set in response.
+func (app *Application) audit(entry auditEntry, command apiCommand, resp response, err error) {
+	if !app.audited(command.Method) {
+		return
+	}
+	entry.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	entry.Method = command.Method
+	entry.Params = string(command.Params)
+	if len(entry.Params) > auditParamsMaxSize {
+		entry.Params = entry.Params[:auditParamsMaxSize] + "..."
+	}
+	if err == nil {
+		if r, ok := resp.(errResponse); ok {
+			err = r.responseErr()
+		}
+	}
+	entry.Result = auditResultOK
+	if err != nil {
+		entry.Result = err.Error()
+	}
+	app.auditLog.log(entry)
+}
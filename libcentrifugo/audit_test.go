@@ -0,0 +1,168 @@
+package libcentrifugo
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/centrifugal/centrifugo/libcentrifugo/auth"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func readAuditLog(t *testing.T, path string) []auditEntry {
+	data, err := ioutil.ReadFile(path)
+	assert.Equal(t, nil, err)
+	var entries []auditEntry
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry auditEntry
+		assert.Equal(t, nil, json.Unmarshal([]byte(line), &entry))
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestAuditAPI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	app := testMemoryApp()
+	app.auditLog, err = newConnLogger(path, 0, 0, 100, &app.metrics.NumAuditDropped)
+	assert.Equal(t, nil, err)
+
+	data := []byte(`[{"method":"publish","params":{"channel":"test","data":{}}},{"method":"disconnect","params":{"user":"user1"}},{"method":"channels","params":{}}]`)
+	_, err = app.processAPIData(data, nil, "127.0.0.1", nil)
+	assert.Equal(t, nil, err)
+
+	key := &APIKey{Name: "backend", Methods: []string{"publish"}}
+	_, err = app.processAPIData([]byte(`{"method":"unsubscribe","params":{"user":"user1","channel":"test"}}`), key, "127.0.0.2", nil)
+	assert.Equal(t, nil, err)
+
+	app.config.AuditLogPublish = true
+	_, err = app.processAPIData(getPublishJSON("test"), nil, "", nil)
+	assert.Equal(t, nil, err)
+	app.auditLog.close()
+
+	entries := readAuditLog(t, path)
+	assert.Equal(t, 3, len(entries))
+	assert.Equal(t, auditSourceAPI, entries[0].Source)
+	assert.Equal(t, "disconnect", entries[0].Method)
+	assert.Equal(t, "127.0.0.1", entries[0].RemoteAddr)
+	assert.Equal(t, auditResultOK, entries[0].Result)
+	assert.Equal(t, `{"user":"user1"}`, entries[0].Params)
+	assert.Equal(t, "unsubscribe", entries[1].Method)
+	assert.Equal(t, "backend", entries[1].APIKey)
+	assert.Equal(t, ErrPermissionDenied.Error(), entries[1].Result)
+	assert.Equal(t, "publish", entries[2].Method)
+}
+
+func TestAuditAPIHandlerRemoteAddr(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	app := testMemoryApp()
+	c := *app.config
+	c.TrustedProxies = []string{"127.0.0.1"}
+	app.SetConfig(&c)
+	app.auditLog, err = newConnLogger(path, 0, 0, 100, &app.metrics.NumAuditDropped)
+	assert.Equal(t, nil, err)
+
+	data := `{"method":"disconnect","params":{"user":"user1"}}`
+	sign := auth.GenerateApiSign(app.config.Secret, []byte(data))
+
+	// forwarded headers of untrusted client are ignored.
+	req := newTestAPIJSONRequest(data, sign)
+	req.RemoteAddr = "10.0.0.1:5000"
+	req.Header.Set("X-Real-IP", "10.0.0.2")
+	req.Header.Set("X-Forwarded-For", "10.0.0.2")
+	app.APIHandler(httptest.NewRecorder(), req)
+
+	// trusted proxy forwards real client address.
+	req = newTestAPIJSONRequest(data, sign)
+	req.RemoteAddr = "127.0.0.1:5000"
+	req.Header.Set("X-Forwarded-For", "10.0.0.3")
+	app.APIHandler(httptest.NewRecorder(), req)
+	app.auditLog.close()
+
+	entries := readAuditLog(t, path)
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, "10.0.0.1", entries[0].RemoteAddr)
+	assert.Equal(t, "10.0.0.3", entries[1].RemoteAddr)
+}
+
+func TestAuditAdminRemoteAddr(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	app := testMemoryApp()
+	app.config.Admin = true
+	app.config.AdminSecret = "secret"
+	app.config.AdminActionsEnabled = true
+	app.auditLog, err = newConnLogger(path, 0, 0, 100, &app.metrics.NumAuditDropped)
+	assert.Equal(t, nil, err)
+	token, err := app.adminAuthToken()
+	assert.Equal(t, nil, err)
+
+	opts := DefaultMuxOptions
+	opts.Admin = true
+	server := httptest.NewServer(DefaultMux(app, opts))
+	defer server.Close()
+
+	// forwarded headers of untrusted client are ignored.
+	header := http.Header{}
+	header.Set("X-Real-IP", "10.0.0.2")
+	header.Set("X-Forwarded-For", "10.0.0.2")
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:]+"/socket", header)
+	assert.Equal(t, nil, err)
+	var reply interface{}
+	// replies sent to admin connections with watch option only.
+	conn.WriteJSON(map[string]interface{}{"method": "connect", "params": map[string]interface{}{"token": token, "watch": true}})
+	conn.ReadJSON(&reply)
+	conn.WriteJSON(map[string]interface{}{"method": "disconnect_user", "params": map[string]string{"user": "user1"}})
+	conn.ReadJSON(&reply)
+	conn.Close()
+	app.auditLog.close()
+
+	entries := readAuditLog(t, path)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, "127.0.0.1", entries[0].RemoteAddr)
+}
+
+func TestAuditAdmin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	c, err := newTestAdminClient()
+	assert.Equal(t, nil, err)
+	c.remoteAddr = "127.0.0.1"
+	c.app.auditLog, err = newConnLogger(path, 0, 0, 100, &c.app.metrics.NumAuditDropped)
+	assert.Equal(t, nil, err)
+
+	params := []byte(`{"user":"user1","reason":"` + strings.Repeat("x", auditParamsMaxSize) + `"}`)
+	_, err = c.apiCmd(apiCommand{Method: "disconnect_user", Params: params})
+	assert.Equal(t, nil, err)
+	c.app.auditLog.close()
+
+	entries := readAuditLog(t, path)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, auditSourceAdmin, entries[0].Source)
+	assert.Equal(t, c.uid(), entries[0].Admin)
+	assert.Equal(t, "disconnect", entries[0].Method)
+	assert.Equal(t, auditParamsMaxSize+3, len(entries[0].Params))
+}
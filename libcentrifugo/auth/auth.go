@@ -5,10 +5,14 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
+	"strconv"
 )
 
 // Centrifugo uses sha256 as digest algorithm for HMAC tokens and signs
-// so all correct tokens must be of fixed length set by HMACLength.
+// so all correct tokens must be of fixed length set by HMACLength. There are
+// no tokens or signs generated with weaker digest algorithms to stay compatible
+// with.
 const (
 	HMACLength = 64
 )
@@ -16,20 +20,48 @@ const (
 // GenerateClientToken generates client token based on project secret key and provided
 // connection parameters such as user ID, timestamp and info JSON string.
 func GenerateClientToken(secret, user, timestamp, info string) string {
+	return GenerateClientTokenWithExp(secret, user, timestamp, info, "")
+}
+
+// GenerateClientTokenWithExp generates client token which also includes explicit
+// expiration time exp (unix timestamp string). Token with empty exp is the same as
+// token generated by GenerateClientToken. Otherwise every field prefixed with its
+// length so exp can not be moved into info turning token into never expiring one.
+func GenerateClientTokenWithExp(secret, user, timestamp, info, exp string) string {
 	token := hmac.New(sha256.New, []byte(secret))
-	token.Write([]byte(user))
-	token.Write([]byte(timestamp))
-	token.Write([]byte(info))
+	if exp == "" {
+		token.Write([]byte(user))
+		token.Write([]byte(timestamp))
+		token.Write([]byte(info))
+		return hex.EncodeToString(token.Sum(nil))
+	}
+	for _, field := range []string{user, timestamp, info, exp} {
+		token.Write([]byte(strconv.Itoa(len(field))))
+		token.Write([]byte(":"))
+		token.Write([]byte(field))
+	}
 	return hex.EncodeToString(token.Sum(nil))
 }
 
 // CheckClientToken validates correctness of provided (by client connection) token
 // comparing it with generated one
 func CheckClientToken(secret, user, timestamp, info, providedToken string) bool {
+	return CheckClientTokenWithExp(secret, user, timestamp, info, "", providedToken)
+}
+
+// CheckClientTokenWithExp validates correctness of provided token generated with
+// explicit expiration time exp. Non empty info must be valid JSON.
+func CheckClientTokenWithExp(secret, user, timestamp, info, exp, providedToken string) bool {
 	if len(providedToken) != HMACLength {
 		return false
 	}
-	token := GenerateClientToken(secret, user, timestamp, info)
+	if info != "" {
+		var v interface{}
+		if err := json.Unmarshal([]byte(info), &v); err != nil {
+			return false
+		}
+	}
+	token := GenerateClientTokenWithExp(secret, user, timestamp, info, exp)
 	return hmac.Equal([]byte(token), []byte(providedToken))
 }
 
@@ -50,6 +82,29 @@ func CheckApiSign(secret string, data []byte, providedSign string) bool {
 	return hmac.Equal([]byte(sign), []byte(providedSign))
 }
 
+// GenerateApiSignWithTimestamp generates sign of HTTP API request which also covers
+// request timestamp (unix seconds) and unique nonce sent in X-API-Timestamp and
+// X-API-Nonce headers so server can reject replayed requests.
+func GenerateApiSignWithTimestamp(secret, timestamp, nonce string, data []byte) string {
+	sign := hmac.New(sha256.New, []byte(secret))
+	sign.Write([]byte(timestamp))
+	sign.Write([]byte(":"))
+	sign.Write([]byte(nonce))
+	sign.Write([]byte(":"))
+	sign.Write(data)
+	return hex.EncodeToString(sign.Sum(nil))
+}
+
+// CheckApiSignWithTimestamp validates correctness of provided sign generated with
+// GenerateApiSignWithTimestamp.
+func CheckApiSignWithTimestamp(secret, timestamp, nonce string, data []byte, providedSign string) bool {
+	if len(providedSign) != HMACLength {
+		return false
+	}
+	sign := GenerateApiSignWithTimestamp(secret, timestamp, nonce, data)
+	return hmac.Equal([]byte(sign), []byte(providedSign))
+}
+
 // GenerateChannelSign generates sign which is used to prove permission of
 // client to subscribe on private channel
 func GenerateChannelSign(secret, client, channel, channelData string) string {
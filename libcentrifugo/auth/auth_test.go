@@ -1,6 +1,10 @@
 package auth
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
 	"testing"
 )
 
@@ -35,6 +39,56 @@ func TestCheckClientToken(t *testing.T) {
 	}
 }
 
+func TestClientTokenWithExp(t *testing.T) {
+	var (
+		secretKey = "secret"
+		user      = "user"
+		timestamp = "1430669930"
+		info      = "{}"
+	)
+	token := GenerateClientToken(secretKey, user, timestamp, info)
+	if GenerateClientTokenWithExp(secretKey, user, timestamp, info, "") != token {
+		t.Error("token without exp must be the same as token generated without exp")
+	}
+	tokenWithExp := GenerateClientTokenWithExp(secretKey, user, timestamp, info, "1430670000")
+	if tokenWithExp == token {
+		t.Error("exp must be part of token")
+	}
+	if !CheckClientTokenWithExp(secretKey, user, timestamp, info, "1430670000", tokenWithExp) {
+		t.Error("correct client token with exp must pass check")
+	}
+	if CheckClientTokenWithExp(secretKey, user, timestamp, info, "1430679999", tokenWithExp) {
+		t.Error("token must not pass check with different exp")
+	}
+	if CheckClientToken(secretKey, user, timestamp, info+"1430670000", tokenWithExp) {
+		t.Error("exp must not be moved into info")
+	}
+	tokenWithInfo := GenerateClientTokenWithExp(secretKey, user, timestamp, "{", "")
+	if CheckClientToken(secretKey, user, timestamp, "{", tokenWithInfo) {
+		t.Error("token must not pass check with info which is not JSON")
+	}
+}
+
+func hmacSHA256(secret string, parts ...string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	for _, part := range parts {
+		mac.Write([]byte(part))
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestHMACSHA256(t *testing.T) {
+	if GenerateClientToken("secret", "user", "1430669930", "{}") != hmacSHA256("secret", "user", "1430669930", "{}") {
+		t.Error("client token must be HMAC-SHA-256")
+	}
+	if GenerateChannelSign("secret", "client", "$channel", "{}") != hmacSHA256("secret", "client", "$channel", "{}") {
+		t.Error("channel sign must be HMAC-SHA-256")
+	}
+	if GenerateApiSign("secret", []byte("{}")) != hmacSHA256("secret", "{}") {
+		t.Error("API sign must be HMAC-SHA-256")
+	}
+}
+
 func TestGenerateApiSign(t *testing.T) {
 	var (
 		secretKey   = "secret"
@@ -94,3 +148,47 @@ func TestCheckChannelSign(t *testing.T) {
 		t.Error("correct sign must pass check")
 	}
 }
+
+func TestClientJWT(t *testing.T) {
+	token, err := GenerateClientJWT("secret", ClientClaims{Sub: "user", Info: []byte(`{"name":"Alex"}`), Exp: 1430670000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsJWT(token) {
+		t.Error("generated token must be JWT")
+	}
+	if IsJWT(GenerateClientToken("secret", "user", "1430669930", "")) {
+		t.Error("client token must not be JWT")
+	}
+	claims, err := ParseClientJWT("secret", token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Sub != "user" || string(claims.Info) != `{"name":"Alex"}` || claims.Exp != 1430670000 {
+		t.Error("wrong claims parsed from JWT")
+	}
+	if _, err := ParseClientJWT("other", token); err != ErrInvalidJWT {
+		t.Error("JWT signed with another secret must not pass check")
+	}
+	// alg none must never be accepted.
+	unsigned := "eyJhbGciOiJub25lIn0." + strings.Split(token, ".")[1] + "."
+	if _, err := ParseClientJWT("secret", unsigned); err != ErrInvalidJWT {
+		t.Error("unsigned JWT must not pass check")
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	hash, err := GeneratePasswordHash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsPasswordHash(hash) || IsPasswordHash("password") {
+		t.Error("password hash not detected")
+	}
+	if !CheckPassword(hash, "password") || CheckPassword(hash, "wrong") {
+		t.Error("hashed password check failed")
+	}
+	if !CheckPassword("password", "password") || CheckPassword("password", "wrong") {
+		t.Error("plain password check failed")
+	}
+}
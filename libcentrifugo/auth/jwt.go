@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// ErrInvalidJWT returned when JWT connection token malformed, signed with algorithm
+// other than HS256 or its signature does not match.
+var ErrInvalidJWT = errors.New("invalid JWT")
+
+// ClientClaims are claims of JWT connection token. Sub is user ID, Info is optional
+// JSON with user information, Exp and Iat are optional unix timestamps.
+type ClientClaims struct {
+	Sub  string          `json:"sub"`
+	Info json.RawMessage `json:"info,omitempty"`
+	Exp  int64           `json:"exp,omitempty"`
+	Iat  int64           `json:"iat,omitempty"`
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// IsJWT reports whether token looks like JWT and not like hex encoded client token.
+func IsJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+func jwtSign(secret, signingInput string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signingInput))
+	return mac.Sum(nil)
+}
+
+// GenerateClientJWT generates HS256 JWT connection token with provided claims.
+func GenerateClientJWT(secret string, claims ClientClaims) (string, error) {
+	header, err := json.Marshal(jwtHeader{Alg: "HS256", Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(jwtSign(secret, signingInput)), nil
+}
+
+// ParseClientJWT checks HS256 signature of JWT connection token and returns its
+// claims. Expiration time is not checked here – caller decides what to do with
+// expired credentials.
+func ParseClientJWT(secret, token string) (ClientClaims, error) {
+	var claims ClientClaims
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, ErrInvalidJWT
+	}
+	headerData, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return claims, ErrInvalidJWT
+	}
+	var header jwtHeader
+	if err := json.Unmarshal(headerData, &header); err != nil || header.Alg != "HS256" {
+		return claims, ErrInvalidJWT
+	}
+	sign, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(sign, jwtSign(secret, parts[0]+"."+parts[1])) {
+		return claims, ErrInvalidJWT
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return claims, ErrInvalidJWT
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return claims, ErrInvalidJWT
+	}
+	return claims, nil
+}
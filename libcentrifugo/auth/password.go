@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordHashPrefixes are prefixes of bcrypt hashes.
+var passwordHashPrefixes = []string{"$2a$", "$2b$", "$2y$"}
+
+// IsPasswordHash checks whether password from configuration is a bcrypt hash.
+func IsPasswordHash(password string) bool {
+	for _, prefix := range passwordHashPrefixes {
+		if strings.HasPrefix(password, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// GeneratePasswordHash generates bcrypt hash of password to keep in configuration
+// instead of password itself.
+func GeneratePasswordHash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// CheckPassword compares provided password with password from configuration which
+// can be a bcrypt hash or plain password.
+func CheckPassword(configured, provided string) bool {
+	if IsPasswordHash(configured) {
+		return bcrypt.CompareHashAndPassword([]byte(configured), []byte(provided)) == nil
+	}
+	return subtle.ConstantTimeCompare([]byte(configured), []byte(provided)) == 1
+}
@@ -0,0 +1,243 @@
+package libcentrifugo
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	hdr "github.com/codahale/hdrhistogram"
+)
+
+const (
+	// benchChannelPrefix is a prefix of channels used by benchmark.
+	benchChannelPrefix = "bench"
+	// benchDrainTimeout is a max time to wait for messages published before
+	// benchmark stopped to be delivered.
+	benchDrainTimeout = 5 * time.Second
+	// benchMaxLatency is a max latency tracked by benchmark histogram.
+	benchMaxLatency = int64(time.Minute / time.Microsecond)
+)
+
+// BenchConfig contains options of node benchmark.
+type BenchConfig struct {
+	// Publishers is a number of goroutines publishing messages using server API.
+	Publishers int
+	// Subscribers is a number of in-process client connections.
+	Subscribers int
+	// Channels is a number of channels subscribers spread over.
+	Channels int
+	// Duration is a time publishers work.
+	Duration time.Duration
+	// PayloadSize is a size of data published in each message in bytes.
+	PayloadSize int
+	// Rate limits messages published by each publisher per second. Zero value
+	// means publishing as fast as possible.
+	Rate int
+}
+
+// BenchResult contains throughput and delivery latency measured by benchmark.
+// Latency is a time between publishing message and writing it into subscriber
+// session.
+type BenchResult struct {
+	Duration      time.Duration
+	Published     int64
+	PublishErrors int64
+	Delivered     int64
+	Disconnected  int64
+	LatencyP50    time.Duration
+	LatencyP90    time.Duration
+	LatencyP99    time.Duration
+	LatencyMax    time.Duration
+}
+
+// PublishRate returns number of messages published per second.
+func (r *BenchResult) PublishRate() float64 {
+	return float64(r.Published) / r.Duration.Seconds()
+}
+
+// DeliveryRate returns number of messages delivered to subscribers per second.
+func (r *BenchResult) DeliveryRate() float64 {
+	return float64(r.Delivered) / r.Duration.Seconds()
+}
+
+// benchPayload is a data published by benchmark. T is a publish time in
+// nanoseconds.
+type benchPayload struct {
+	T int64  `json:"t"`
+	P string `json:"p,omitempty"`
+}
+
+// benchResponse contains fields of client message response needed to measure
+// latency.
+type benchResponse struct {
+	Method string `json:"method"`
+	Body   struct {
+		Data benchPayload `json:"data"`
+	} `json:"body"`
+}
+
+// benchSession is an in-process session which records delivery latency of
+// messages instead of writing them to network.
+type benchSession struct {
+	mu        sync.Mutex
+	latency   *hdr.Histogram
+	delivered *int64
+	closed    *int64
+}
+
+func newBenchSession(delivered, closed *int64) *benchSession {
+	return &benchSession{
+		latency:   hdr.New(1, benchMaxLatency, 3),
+		delivered: delivered,
+		closed:    closed,
+	}
+}
+
+func (sess *benchSession) Send(message []byte) error {
+	now := time.Now().UnixNano()
+	var responses []benchResponse
+	if len(message) > 0 && message[0] == arrayJSONPrefix {
+		if err := json.Unmarshal(message, &responses); err != nil {
+			return err
+		}
+	} else {
+		var resp benchResponse
+		if err := json.Unmarshal(message, &resp); err != nil {
+			return err
+		}
+		responses = []benchResponse{resp}
+	}
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	for _, resp := range responses {
+		if resp.Method != "message" || resp.Body.Data.T == 0 {
+			continue
+		}
+		atomic.AddInt64(sess.delivered, 1)
+		sess.latency.RecordValue((now - resp.Body.Data.T) / int64(time.Microsecond))
+	}
+	return nil
+}
+
+func (sess *benchSession) Close(status uint32, reason string) error {
+	atomic.AddInt64(sess.closed, 1)
+	return nil
+}
+
+// Bench simulates publishers and subscribers on node using in-process sessions
+// and measures throughput and delivery latency. Application must be running and
+// configured in insecure mode as benchmark clients connect without token.
+func (app *Application) Bench(c BenchConfig) (*BenchResult, error) {
+	if c.Publishers <= 0 || c.Subscribers <= 0 || c.Channels <= 0 || c.Duration <= 0 {
+		return nil, errors.New("publishers, subscribers, channels and duration must be positive")
+	}
+	app.RLock()
+	insecure := app.config.Insecure
+	app.RUnlock()
+	if !insecure {
+		return nil, errors.New("benchmark requires insecure mode")
+	}
+
+	channels := make([]Channel, c.Channels)
+	for i := range channels {
+		channels[i] = Channel(benchChannelPrefix + strconv.Itoa(i))
+	}
+
+	var delivered, disconnected int64
+	sessions := make([]*benchSession, 0, c.Subscribers)
+	clients := make([]*client, 0, c.Subscribers)
+	defer func() {
+		for _, cl := range clients {
+			cl.teardown("benchmark finished")
+		}
+	}()
+	for i := 0; i < c.Subscribers; i++ {
+		sess := newBenchSession(&delivered, &disconnected)
+		cl, err := newClient(app, sess)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, cl)
+		connect, _ := json.Marshal(connectClientCommand{User: UserID(strconv.Itoa(i))})
+		subscribe, _ := json.Marshal(subscribeClientCommand{Channel: channels[i%len(channels)]})
+		err = cl.handleCommands([]clientCommand{
+			{Method: "connect", Params: connect},
+			{Method: "subscribe", Params: subscribe},
+		})
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, sess)
+	}
+
+	padding := make([]byte, c.PayloadSize)
+	for i := range padding {
+		padding[i] = 'x'
+	}
+
+	var published, publishErrors int64
+	var wg sync.WaitGroup
+	started := time.Now()
+	deadline := started.Add(c.Duration)
+	for i := 0; i < c.Publishers; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			var tick <-chan time.Time
+			if c.Rate > 0 {
+				ticker := time.NewTicker(time.Second / time.Duration(c.Rate))
+				defer ticker.Stop()
+				tick = ticker.C
+			}
+			for j := n; time.Now().Before(deadline); j++ {
+				if tick != nil {
+					<-tick
+				}
+				data, _ := json.Marshal(benchPayload{T: time.Now().UnixNano(), P: string(padding)})
+				if err := app.Publish(channels[j%len(channels)], data, "", nil); err != nil {
+					atomic.AddInt64(&publishErrors, 1)
+					continue
+				}
+				atomic.AddInt64(&published, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	elapsed := time.Since(started)
+
+	// wait until messages still queued in connections delivered.
+	drainDeadline := time.Now().Add(benchDrainTimeout)
+	last := int64(-1)
+	for time.Now().Before(drainDeadline) {
+		n := atomic.LoadInt64(&delivered)
+		if n == last {
+			break
+		}
+		last = n
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	latency := hdr.New(1, benchMaxLatency, 3)
+	for _, sess := range sessions {
+		sess.mu.Lock()
+		latency.Merge(sess.latency)
+		sess.mu.Unlock()
+	}
+	micro := func(v int64) time.Duration {
+		return time.Duration(v) * time.Microsecond
+	}
+	return &BenchResult{
+		Duration:      elapsed,
+		Published:     atomic.LoadInt64(&published),
+		PublishErrors: atomic.LoadInt64(&publishErrors),
+		Delivered:     atomic.LoadInt64(&delivered),
+		Disconnected:  atomic.LoadInt64(&disconnected),
+		LatencyP50:    micro(latency.ValueAtQuantile(50)),
+		LatencyP90:    micro(latency.ValueAtQuantile(90)),
+		LatencyP99:    micro(latency.ValueAtQuantile(99)),
+		LatencyMax:    micro(latency.Max()),
+	}, nil
+}
@@ -0,0 +1,39 @@
+package libcentrifugo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBench(t *testing.T) {
+	c := newTestConfig()
+	c.Insecure = true
+	app := testMemoryAppWithConfig(&c)
+	result, err := app.Bench(BenchConfig{
+		Publishers:  2,
+		Subscribers: 10,
+		Channels:    2,
+		Duration:    100 * time.Millisecond,
+		PayloadSize: 16,
+		Rate:        100,
+	})
+	assert.Equal(t, nil, err)
+	assert.True(t, result.Published > 0)
+	assert.Equal(t, int64(0), result.PublishErrors)
+	// every message delivered to half of subscribers.
+	assert.Equal(t, result.Published*5, result.Delivered)
+	assert.True(t, result.LatencyMax >= result.LatencyP50)
+	assert.True(t, waitCondition(func() bool {
+		return app.clients.nClients() == 0
+	}))
+}
+
+func TestBenchInsecureRequired(t *testing.T) {
+	app := testMemoryApp()
+	_, err := app.Bench(BenchConfig{Publishers: 1, Subscribers: 1, Channels: 1, Duration: time.Millisecond})
+	assert.NotEqual(t, nil, err)
+	_, err = app.Bench(BenchConfig{})
+	assert.NotEqual(t, nil, err)
+}
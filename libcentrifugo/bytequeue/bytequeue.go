@@ -3,6 +3,7 @@ package bytequeue
 
 import (
 	"sync"
+	"time"
 )
 
 // ByteQueue is an unbounded queue of []byte.
@@ -14,11 +15,20 @@ type ByteQueue interface {
 	// In that case the []byte is dropped.
 	Add(i []byte) bool
 
+	// AddDroppable adds a []byte to the back of the queue like Add but
+	// allows RemoveDroppable to remove it before it reaches the front.
+	AddDroppable(i []byte) bool
+
 	// Remove will remove a []byte from the queue.
 	// If false is returned, it either means 1) there were no items on the queue
 	// or 2) the queue is closed.
 	Remove() ([]byte, bool)
 
+	// RemoveDroppable removes the oldest []byte added with AddDroppable.
+	// The last []byte in the queue is never removed. If false is returned
+	// there were no such items on the queue.
+	RemoveDroppable() ([]byte, bool)
+
 	// Close the queue and discard all entried in the queue
 	// all goroutines in wait() will return
 	Close()
@@ -43,12 +53,23 @@ type ByteQueue interface {
 
 	// Size returns the current size of the queue in bytes.
 	Size() int
+
+	// Age returns how long the first []byte in the queue waits to be removed,
+	// zero if the queue is empty.
+	Age() time.Duration
+}
+
+// node is a queued []byte with Unix nanoseconds time it was added.
+type node struct {
+	data      []byte
+	added     int64
+	droppable bool
 }
 
 type byteQueue struct {
 	mu      sync.RWMutex
 	cond    *sync.Cond
-	nodes   [][]byte
+	nodes   []node
 	head    int
 	tail    int
 	cnt     int
@@ -61,7 +82,7 @@ type byteQueue struct {
 func New(initialCapacity int) ByteQueue {
 	sq := &byteQueue{
 		initCap: initialCapacity,
-		nodes:   make([][]byte, initialCapacity),
+		nodes:   make([]node, initialCapacity),
 	}
 	sq.cond = sync.NewCond(&sq.mu)
 	return sq
@@ -69,7 +90,7 @@ func New(initialCapacity int) ByteQueue {
 
 // Write mutex must be held when calling
 func (q *byteQueue) resize(n int) {
-	nodes := make([][]byte, n)
+	nodes := make([]node, n)
 	if q.head < q.tail {
 		copy(nodes, q.nodes[q.head:q.tail])
 	} else {
@@ -86,6 +107,16 @@ func (q *byteQueue) resize(n int) {
 // will return false if the queue is closed.
 // In that case the []byte is dropped.
 func (q *byteQueue) Add(i []byte) bool {
+	return q.add(i, false)
+}
+
+// AddDroppable adds a []byte to the back of the queue like Add but
+// allows RemoveDroppable to remove it before it reaches the front.
+func (q *byteQueue) AddDroppable(i []byte) bool {
+	return q.add(i, true)
+}
+
+func (q *byteQueue) add(i []byte, droppable bool) bool {
 	q.mu.Lock()
 	if q.closed {
 		q.mu.Unlock()
@@ -96,7 +127,7 @@ func (q *byteQueue) Add(i []byte) bool {
 		// In Go this resulted in a higher memory usage.
 		q.resize(q.cnt * 2)
 	}
-	q.nodes[q.tail] = i
+	q.nodes[q.tail] = node{data: i, added: time.Now().UnixNano(), droppable: droppable}
 	q.tail = (q.tail + 1) % len(q.nodes)
 	q.size += len(i)
 	q.cnt++
@@ -156,7 +187,8 @@ func (q *byteQueue) Remove() ([]byte, bool) {
 		q.mu.Unlock()
 		return []byte{}, false
 	}
-	i := q.nodes[q.head]
+	i := q.nodes[q.head].data
+	q.nodes[q.head] = node{}
 	q.head = (q.head + 1) % len(q.nodes)
 	q.cnt--
 	q.size -= len(i)
@@ -169,6 +201,35 @@ func (q *byteQueue) Remove() ([]byte, bool) {
 	return i, true
 }
 
+// RemoveDroppable removes the oldest []byte added with AddDroppable.
+// The last []byte in the queue is never removed. If false is returned
+// there were no such items on the queue.
+func (q *byteQueue) RemoveDroppable() ([]byte, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := len(q.nodes)
+	for k := 0; k < q.cnt-1; k++ {
+		idx := (q.head + k) % n
+		if !q.nodes[idx].droppable {
+			continue
+		}
+		i := q.nodes[idx].data
+		// shift items before removed one towards tail to keep order.
+		for j := k; j > 0; j-- {
+			q.nodes[(q.head+j)%n] = q.nodes[(q.head+j-1)%n]
+		}
+		q.nodes[q.head] = node{}
+		q.head = (q.head + 1) % n
+		q.cnt--
+		q.size -= len(i)
+		if n := n / 2; n >= q.initCap && q.cnt <= n {
+			q.resize(n)
+		}
+		return i, true
+	}
+	return []byte{}, false
+}
+
 // Return the capacity (without allocations)
 func (q *byteQueue) Cap() int {
 	q.mu.RLock()
@@ -192,3 +253,13 @@ func (q *byteQueue) Size() int {
 	q.mu.RUnlock()
 	return s
 }
+
+// Return how long the first item waits in the queue.
+func (q *byteQueue) Age() time.Duration {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.cnt == 0 {
+		return 0
+	}
+	return time.Duration(time.Now().UnixNano() - q.nodes[q.head].added)
+}
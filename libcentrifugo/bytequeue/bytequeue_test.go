@@ -3,6 +3,7 @@ package bytequeue
 import (
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -34,6 +35,19 @@ func TestByteQueueResize(t *testing.T) {
 	assert.Equal(t, initialCapacity+2, q.Len())
 }
 
+func TestByteQueueAge(t *testing.T) {
+	q := New(2)
+	assert.Equal(t, time.Duration(0), q.Age())
+	q.Add([]byte("1"))
+	time.Sleep(10 * time.Millisecond)
+	q.Add([]byte("2"))
+	assert.True(t, q.Age() >= 10*time.Millisecond)
+	q.Remove()
+	assert.True(t, q.Age() < 10*time.Millisecond)
+	q.Remove()
+	assert.Equal(t, time.Duration(0), q.Age())
+}
+
 func TestByteQueueSize(t *testing.T) {
 	initialCapacity := 2
 	q := New(initialCapacity)
@@ -45,6 +59,33 @@ func TestByteQueueSize(t *testing.T) {
 	assert.Equal(t, 1, q.Size())
 }
 
+func TestByteQueueRemoveDroppable(t *testing.T) {
+	q := New(2)
+	q.Add([]byte("1"))
+	q.AddDroppable([]byte("22"))
+	q.Add([]byte("3"))
+	q.AddDroppable([]byte("44"))
+	q.AddDroppable([]byte("55"))
+
+	i, ok := q.RemoveDroppable()
+	assert.True(t, ok)
+	assert.Equal(t, "22", string(i))
+	i, ok = q.RemoveDroppable()
+	assert.True(t, ok)
+	assert.Equal(t, "44", string(i))
+	// last item is kept.
+	_, ok = q.RemoveDroppable()
+	assert.False(t, ok)
+	assert.Equal(t, 3, q.Len())
+	assert.Equal(t, 4, q.Size())
+
+	for _, expected := range []string{"1", "3", "55"} {
+		i, ok = q.Remove()
+		assert.True(t, ok)
+		assert.Equal(t, expected, string(i))
+	}
+}
+
 func TestByteQueueWait(t *testing.T) {
 	initialCapacity := 2
 	q := New(initialCapacity)
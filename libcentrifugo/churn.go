@@ -0,0 +1,82 @@
+package libcentrifugo
+
+import (
+	"time"
+
+	"github.com/FZambia/go-logger"
+)
+
+// churnCounter approximates number of events during rolling window using counters
+// of current and previous fixed windows – previous window count weighted by part of
+// it still inside rolling window. Memory used does not depend on number of events.
+// It is not safe for concurrent use – client accesses it under client lock.
+type churnCounter struct {
+	start    time.Time
+	current  int
+	previous int
+}
+
+// advance moves counter windows forward to now.
+func (c *churnCounter) advance(window time.Duration, now time.Time) {
+	elapsed := now.Sub(c.start)
+	switch {
+	case elapsed >= 2*window:
+		c.previous = 0
+		c.current = 0
+		c.start = now
+	case elapsed >= window:
+		c.previous = c.current
+		c.current = 0
+		c.start = c.start.Add(window)
+	}
+}
+
+// add counts one event.
+func (c *churnCounter) add(window time.Duration, now time.Time) {
+	c.advance(window, now)
+	c.current++
+}
+
+// count returns approximate number of events during window before now.
+func (c *churnCounter) count(window time.Duration, now time.Time) float64 {
+	c.advance(window, now)
+	weight := 1 - float64(now.Sub(c.start))/float64(window)
+	return float64(c.previous)*weight + float64(c.current)
+}
+
+// churnSettings returns subscription churn limit and window, zero limit means no limit.
+func (c *client) churnSettings() (int, time.Duration) {
+	c.app.RLock()
+	defer c.app.RUnlock()
+	if c.app.config.SubscriptionChurnWindow <= 0 {
+		return 0, 0
+	}
+	return c.app.config.SubscriptionChurnLimit, c.app.config.SubscriptionChurnWindow
+}
+
+// addChurn counts subscription change of connection. Must be called with client
+// lock held.
+func (c *client) addChurn(now time.Time) {
+	limit, window := c.churnSettings()
+	if limit <= 0 {
+		return
+	}
+	c.churn.add(window, now)
+}
+
+// allowSubscribe checks that connection does not exceed subscription churn limit.
+// Must be called with client lock held.
+func (c *client) allowSubscribe(now time.Time) bool {
+	limit, window := c.churnSettings()
+	if limit <= 0 || c.churn.count(window, now) < float64(limit) {
+		c.churnLimited = false
+		return true
+	}
+	c.app.metrics.NumClientChurnLimited.Inc()
+	if !c.churnLimited {
+		// log once until churn goes down to not flood logs.
+		logger.INFO.Printf("client %s (user %s) exceeded subscription churn limit %d", c.uid(), c.user(), limit)
+		c.churnLimited = true
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package libcentrifugo
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChurnCounter(t *testing.T) {
+	window := 10 * time.Second
+	start := time.Unix(1000, 0)
+	c := churnCounter{start: start}
+
+	for i := 0; i < 10; i++ {
+		c.add(window, start.Add(time.Duration(i)*time.Second))
+	}
+	assert.Equal(t, float64(10), c.count(window, start.Add(9*time.Second)))
+	// half of previous window still inside rolling window.
+	assert.Equal(t, float64(5), c.count(window, start.Add(15*time.Second)))
+	assert.Equal(t, float64(0), c.count(window, start.Add(25*time.Second)))
+}
+
+func TestClientSubscriptionChurnLimit(t *testing.T) {
+	app := testApp()
+	app.config.SubscriptionChurnLimit = 10
+	app.config.SubscriptionChurnWindow = time.Minute
+	c, err := newClient(app, &testSession{})
+	assert.Equal(t, nil, err)
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	err = c.handleCommands([]clientCommand{testConnectCmd(timestamp)})
+	assert.Equal(t, nil, err)
+
+	// flapping client subscribes and unsubscribes the same channel.
+	for i := 0; i < 5; i++ {
+		resp, err := c.subscribeCmd(&subscribeClientCommand{Channel: "test"})
+		assert.Equal(t, nil, err)
+		assert.Equal(t, nil, resp.(*clientSubscribeResponse).err)
+		_, err = c.unsubscribeCmd(&unsubscribeClientCommand{Channel: "test"})
+		assert.Equal(t, nil, err)
+	}
+
+	resp, err := c.subscribeCmd(&subscribeClientCommand{Channel: "test"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, ErrTooMuchChurn, resp.(*clientSubscribeResponse).err)
+	assert.Equal(t, 0, len(c.channels()))
+	assert.Equal(t, int64(1), app.metrics.NumClientChurnLimited.LoadRaw())
+
+	// churn goes down after window passes.
+	c.churn.start = c.churn.start.Add(-2 * time.Minute)
+	resp, err = c.subscribeCmd(&subscribeClientCommand{Channel: "test"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, resp.(*clientSubscribeResponse).err)
+	assert.Equal(t, 1, len(c.channels()))
+}
+
+func TestClientSubscriptionChurnNoLimit(t *testing.T) {
+	app := testApp()
+	c, err := newClient(app, &testSession{})
+	assert.Equal(t, nil, err)
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	err = c.handleCommands([]clientCommand{testConnectCmd(timestamp)})
+	assert.Equal(t, nil, err)
+
+	for i := 0; i < 100; i++ {
+		resp, err := c.subscribeCmd(&subscribeClientCommand{Channel: "test"})
+		assert.Equal(t, nil, err)
+		assert.Equal(t, nil, resp.(*clientSubscribeResponse).err)
+		_, err = c.unsubscribeCmd(&unsubscribeClientCommand{Channel: "test"})
+		assert.Equal(t, nil, err)
+	}
+}
@@ -1,14 +1,21 @@
 package libcentrifugo
 
 import (
+	"bytes"
 	"encoding/json"
+	"net/http"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/FZambia/go-logger"
 	"github.com/centrifugal/centrifugo/libcentrifugo/auth"
 	"github.com/centrifugal/centrifugo/libcentrifugo/bytequeue"
+	"github.com/centrifugal/centrifugo/libcentrifugo/encode"
 	"github.com/centrifugal/centrifugo/libcentrifugo/raw"
 	"github.com/satori/go.uuid"
 )
@@ -16,53 +23,105 @@ import (
 const (
 	// CloseStatus is status code set when closing client connections.
 	CloseStatus = 3000
+	// CloseStatusTryAgainLater is status code set when closing SockJS sessions
+	// rejected because node reached max_clients limit.
+	CloseStatusTryAgainLater = 1013
 )
 
+// disconnectSlow is a reason connection closed with when it can not keep up
+// with messages sent to it.
+const disconnectSlow = "slow"
+
+// serverSubscriptionUserPlaceholder is replaced with connection user ID in
+// server_subscriptions channels.
+const serverSubscriptionUserPlaceholder = "{user}"
+
 // client represents clien connection to Centrifugo - at moment this can be Websocket
 // or SockJS connection. It abstracts away protocol of incoming connection having
 // session interface. Session allows to Send messages via connection and to Close connection.
 type client struct {
 	sync.RWMutex
-	app            *Application
-	sess           session
-	UID            ConnID
-	User           UserID
-	timestamp      int64
-	defaultInfo    []byte
-	authenticated  bool
-	channelInfo    map[Channel][]byte
-	Channels       map[Channel]bool
-	messages       bytequeue.ByteQueue
-	closeChan      chan struct{}
-	staleTimer     *time.Timer
-	expireTimer    *time.Timer
-	presenceTimer  *time.Timer
-	sendTimeout    time.Duration
-	maxQueueSize   int
-	maxRequestSize int
+	app             *Application
+	sess            session
+	UID             ConnID
+	User            UserID
+	timestamp       int64
+	exp             int64
+	proxyAuth       bool
+	transportName   string
+	remoteAddr      string
+	headers         http.Header
+	connectedAt     int64
+	binaryFrames    bool
+	msgpackFrames   bool
+	ackWindow       *ackWindow
+	rateLimit       *tokenBucket
+	limitViolations int
+	churn           churnCounter
+	churnLimited    bool
+	defaultInfo     []byte
+	authenticated   bool
+	channelInfo     map[Channel][]byte
+	Channels        map[Channel]bool
+	messages        bytequeue.ByteQueue
+	closeChan       chan struct{}
+	staleTimer      *time.Timer
+	expireTimer     *time.Timer
+	presenceTimer   *time.Timer
+	sendTimeout     time.Duration
+	maxQueueSize    int
+	queueLimitSoft  int
+	limitGrace      time.Duration
+	maxRequestSize  int
+	maxCommands     int
+	// Unix nanoseconds when channel and queue limits exceeded, accessed atomically.
+	channelGrace int64
+	queueGrace   int64
+	// channelGraceTimer checks channel limit when grace period ends.
+	channelGraceTimer *time.Timer
+	// queueWarned is 1 if client warned that queue size reached watermark,
+	// accessed atomically.
+	queueWarned int32
+	// queueDropOldest is 1 if every channel client subscribed to has drop_oldest
+	// slow client policy, accessed atomically.
+	queueDropOldest int32
 }
 
 // newClient creates new ready to communicate client.
 func newClient(app *Application, s session) (*client, error) {
+	c := initClient(app, s)
+	app.RLock()
+	staleCloseDelay := app.config.StaleConnectionCloseDelay
+	app.RUnlock()
+	go c.sendMessages()
+	if staleCloseDelay > 0 {
+		c.staleTimer = time.AfterFunc(staleCloseDelay, c.closeUnauthenticated)
+	}
+	return c, nil
+}
+
+// initClient creates client with empty message queue, messages are not sent
+// from queue until sendMessages started.
+func initClient(app *Application, s session) *client {
 	c := client{
-		UID:       ConnID(uuid.NewV4().String()),
-		app:       app,
-		sess:      s,
-		closeChan: make(chan struct{}),
+		UID:         ConnID(uuid.NewV4().String()),
+		app:         app,
+		sess:        s,
+		closeChan:   make(chan struct{}),
+		connectedAt: time.Now().Unix(),
 	}
 	app.RLock()
-	staleCloseDelay := app.config.StaleConnectionCloseDelay
 	queueInitialCapacity := app.config.ClientQueueInitialCapacity
 	c.maxQueueSize = app.config.ClientQueueMaxSize
+	c.queueLimitSoft = app.config.ClientQueueMaxSizeSoft
+	c.limitGrace = app.config.LimitGracePeriod
 	c.maxRequestSize = app.config.ClientRequestMaxSize
+	c.maxCommands = app.config.ClientRequestMaxCommands
 	c.sendTimeout = app.config.MessageSendTimeout
+	c.ackWindow = newAckWindow(app.config.ClientAcksWindow, app.config.ClientAcksAdviceThreshold)
 	app.RUnlock()
 	c.messages = bytequeue.New(queueInitialCapacity)
-	go c.sendMessages()
-	if staleCloseDelay > 0 {
-		c.staleTimer = time.AfterFunc(staleCloseDelay, c.closeUnauthenticated)
-	}
-	return &c, nil
+	return &c
 }
 
 // sendMessages waits for messages from queue and sends them to client.
@@ -76,9 +135,14 @@ func (c *client) sendMessages() {
 			continue
 		}
 		err := c.sendMsgTimeout(msg)
-		if err != nil {
+		if err == ErrSendTimeout {
+			logger.INFO.Println("send timeout for", c.uid())
+			c.app.metrics.NumClientSendTimeouts.Inc()
+			c.close(disconnectSlow, true)
+			return
+		} else if err != nil {
 			logger.INFO.Println("error sending to", c.uid(), err.Error())
-			c.close("error sending message")
+			c.close("error sending message", true)
 			return
 		}
 		c.app.metrics.NumMsgSent.Inc()
@@ -122,7 +186,7 @@ func (c *client) closeUnauthenticated() {
 	c.RLock()
 	defer c.RUnlock()
 	if !c.authenticated {
-		c.close("stale")
+		c.close("stale", true)
 	}
 }
 
@@ -160,6 +224,43 @@ func (c *client) user() UserID {
 	return c.User
 }
 
+func (c *client) transport() string {
+	return c.transportName
+}
+
+func (c *client) connected() int64 {
+	return c.connectedAt
+}
+
+func (c *client) binary() bool {
+	return c.binaryFrames
+}
+
+func (c *client) msgpack() bool {
+	return c.msgpackFrames
+}
+
+// encodeFrame converts JSON message to MessagePack if client negotiated msgpack
+// format. Messages already encoded returned as is.
+func (c *client) encodeFrame(message []byte) ([]byte, error) {
+	if !c.msgpackFrames || !isJSONFrame(message) {
+		return message, nil
+	}
+	return encode.JSONToMsgpack(message)
+}
+
+func (c *client) queuedBytes() int {
+	return c.messages.Size()
+}
+
+func (c *client) queueAge() time.Duration {
+	return c.messages.Age()
+}
+
+func (c *client) acks() *ackWindow {
+	return c.ackWindow
+}
+
 func (c *client) channels() []Channel {
 	c.RLock()
 	defer c.RUnlock()
@@ -172,46 +273,216 @@ func (c *client) channels() []Channel {
 	return keys
 }
 
-func (c *client) unsubscribe(ch Channel) error {
+func (c *client) unsubscribe(ch Channel, resubscribe bool) error {
 	c.Lock()
 	defer c.Unlock()
+	respJSON, err := c.serverUnsubscribe(ch, resubscribe)
+	if err != nil {
+		return err
+	}
+	return c.send(respJSON)
+}
+
+// serverUnsubscribe unsubscribes client from channel on behalf of server and
+// returns unsubscribe message for client with resubscribe advice.
+func (c *client) serverUnsubscribe(ch Channel, resubscribe bool) ([]byte, error) {
 	cmd := &unsubscribeClientCommand{
 		Channel: ch,
 	}
 	resp, err := c.unsubscribeCmd(cmd)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	respJSON, err := json.Marshal(resp)
-	if err != nil {
-		return err
+	resp.(*clientUnsubscribeResponse).Body.Advice = &unsubscribeAdvice{
+		Resubscribe: resubscribe,
 	}
-	return c.send(respJSON)
+	return json.Marshal(resp)
 }
 
 func (c *client) send(message []byte) error {
-	ok := c.messages.Add(message)
+	return c.enqueue(message, false)
+}
+
+// sendPublication sends channel publication to client. Unlike other messages
+// publications can be dropped from queue on overflow if client channels allow it.
+func (c *client) sendPublication(message []byte) error {
+	return c.enqueue(message, true)
+}
+
+func (c *client) enqueue(message []byte, publication bool) error {
+	message, err := c.encodeFrame(message)
+	if err != nil {
+		logger.ERROR.Println(err)
+		return ErrInternalServerError
+	}
+	var ok bool
+	if publication {
+		ok = c.messages.AddDroppable(message)
+	} else {
+		ok = c.messages.Add(message)
+	}
 	if !ok {
 		return ErrClientClosed
 	}
 	c.app.metrics.NumMsgQueued.Inc()
-	if c.messages.Size() > c.maxQueueSize {
-		c.close("slow")
+	size := c.messages.Size()
+	allowed, _ := checkSoftLimit(size, c.maxQueueSize, c.queueLimitSoft, c.limitGrace, &c.queueGrace, time.Now())
+	if !allowed && atomic.LoadInt32(&c.queueDropOldest) == 1 && c.dropQueued() {
+		return nil
+	}
+	if !allowed {
+		c.app.metrics.NumClientSlow.Inc()
+		c.close(disconnectSlow, true)
 		return ErrClientClosed
 	}
+	c.checkQueueAdvice(size)
 	return nil
 }
 
-func (c *client) close(reason string) error {
-	// TODO: better locking for client - at moment we close message queue in 2 places, here and in clean() method
+// dropQueued removes oldest queued publications until queue size is within
+// client_queue_max_size. Newest message always kept. Returns false if queue
+// still exceeds limit because other messages can not be dropped.
+func (c *client) dropQueued() bool {
+	for c.messages.Size() > c.maxQueueSize {
+		if _, ok := c.messages.RemoveDroppable(); !ok {
+			return c.messages.Len() <= 1
+		}
+		c.app.metrics.NumClientQueueDropped.Inc()
+	}
+	return true
+}
+
+// updateQueuePolicy checks whether oldest queued messages can be dropped instead
+// of closing connection when queue overflows. Must be called with client lock
+// held after client subscribed to or unsubscribed from channel.
+func (c *client) updateQueuePolicy() {
+	dropOldest := int32(len(c.Channels))
+	for ch := range c.Channels {
+		chOpts, err := c.app.channelOpts(ch)
+		if err != nil || chOpts.SlowClientPolicy != slowClientPolicyDropOldest {
+			dropOldest = 0
+			break
+		}
+	}
+	if dropOldest > 0 {
+		dropOldest = 1
+	}
+	atomic.StoreInt32(&c.queueDropOldest, dropOldest)
+}
+
+// slowClosed logs user and channels of connection closed because it was slow
+// and counts it in metrics of namespaces of its channels. Must be called with
+// client lock held.
+func (c *client) slowClosed() {
+	channels := make([]string, 0, len(c.Channels))
+	counted := map[*namespaceCounters]bool{}
+	for ch := range c.Channels {
+		channels = append(channels, string(ch))
+		counters := c.app.metrics.namespaces.channel(ch)
+		if !counted[counters] {
+			counters.shard(ch).NumClientSlow.Inc()
+			counted[counters] = true
+		}
+	}
+	sort.Strings(channels)
+	logger.INFO.Printf("slow client %s of user %s disconnected, channels: %s", c.uid(), c.User, strings.Join(channels, ", "))
+}
+
+// close closes client connection. Before closing disconnect message with reason
+// and reconnect advice sent directly into session as message queue is discarded.
+// The same payload used as close reason. Session closed and connection state cleaned
+// up using teardown in separate goroutine as close can be called while client or hub
+// locks held and sending into session of slow client can block.
+func (c *client) close(reason string, reconnect bool) error {
+	return c.closeWithMessages(nil, reason, reconnect)
+}
+
+// closeSendTimeout bounds time spent sending final messages before session closed
+// as session can be blocked by slow client.
+const closeSendTimeout = time.Second
+
+// closeWithMessages closes client connection like close but sends messages to
+// client before disconnect message. Messages sent directly into session as message
+// queue is discarded on close.
+func (c *client) closeWithMessages(messages [][]byte, reason string, reconnect bool) error {
 	c.messages.Close()
-	c.sess.Close(CloseStatus, reason)
+	body := disconnectBody{
+		Reason:    reason,
+		Reconnect: reconnect,
+	}
+	jsonResp, err := json.Marshal(newClientDisconnectResponse(body))
+	if err == nil {
+		jsonResp, err = c.encodeFrame(jsonResp)
+	}
+	if err != nil {
+		logger.ERROR.Println(err)
+		jsonResp = nil
+	}
+	if jsonResp != nil {
+		messages = append(messages, jsonResp)
+	}
+	go func() {
+		// session writes are serialized so messages wait for message being sent
+		// by sendMessages, session closed anyway if they are not sent in time.
+		sent := make(chan struct{})
+		go func() {
+			defer close(sent)
+			for _, msg := range messages {
+				if err := c.sess.Send(msg); err != nil {
+					return
+				}
+			}
+		}()
+		select {
+		case <-sent:
+		case <-time.After(closeSendTimeout):
+		}
+		c.sess.Close(CloseStatus, disconnectReason(reason, reconnect))
+		c.teardown(reason)
+	}()
 	return nil
 }
 
-// clean called when connection was closed to make different clean up
-// actions for a client
-func (c *client) clean() error {
+// disconnectReason returns JSON disconnect payload used as session close reason.
+// Reason shortened so payload fits into Websocket close frame.
+func disconnectReason(reason string, reconnect bool) string {
+	body := disconnectBody{
+		Reason:    reason,
+		Reconnect: reconnect,
+	}
+	for {
+		jsonBody, err := json.Marshal(body)
+		if err != nil {
+			return truncateCloseReason(reason)
+		}
+		excess := len(jsonBody) - maxCloseReasonLength
+		if excess <= 0 || body.Reason == "" {
+			return string(jsonBody)
+		}
+		if excess > len(body.Reason) {
+			excess = len(body.Reason)
+		}
+		n := len(body.Reason) - excess
+		for n > 0 && !utf8.RuneStart(body.Reason[n]) {
+			n--
+		}
+		body.Reason = body.Reason[:n]
+	}
+}
+
+// shouldReconnect returns reconnect advice for error returned from client message
+// handling. In case of any internal server error we give client an advice to reconnect.
+// Any other error results in disconnect without reconnect.
+func shouldReconnect(err error) bool {
+	return err == ErrInternalServerError
+}
+
+// teardown is the only place where state of closed connection cleaned up. All
+// termination paths (transport error, session close, queue overflow, expiration,
+// disconnect by server) end here. It is idempotent: connection unsubscribed from
+// all channels and removed from hub only once, disconnect event and metric emitted
+// only once too.
+func (c *client) teardown(reason string) error {
 	c.Lock()
 	defer c.Unlock()
 
@@ -222,6 +493,16 @@ func (c *client) clean() error {
 		close(c.closeChan)
 	}
 
+	logger.DEBUG.Printf("Teardown connection %s: %s", c.uid(), reason)
+
+	if reason == disconnectSlow {
+		c.slowClosed()
+	}
+
+	if c.staleTimer != nil {
+		c.staleTimer.Stop()
+	}
+
 	if len(c.Channels) > 0 {
 		// unsubscribe from all channels
 		for channel := range c.Channels {
@@ -235,19 +516,25 @@ func (c *client) clean() error {
 		}
 	}
 
-	if c.authenticated {
-		err := c.app.removeConn(c)
-		if err != nil {
-			logger.ERROR.Println(err)
-		}
+	// remove connection even if it's not authenticated – removing is a no-op
+	// for connections not registered in hub.
+	err := c.app.removeConn(c)
+	if err != nil {
+		logger.ERROR.Println(err)
 	}
 
 	c.messages.Close()
 
+	c.app.metrics.NumClientDisconnects.Inc()
+
 	if c.authenticated && c.app.mediator != nil {
 		c.app.mediator.Disconnect(c.UID, c.User)
 	}
 
+	if c.authenticated {
+		c.app.logConnection(c, connLogDisconnect, reason)
+	}
+
 	if c.expireTimer != nil {
 		c.expireTimer.Stop()
 	}
@@ -256,6 +543,10 @@ func (c *client) clean() error {
 		c.presenceTimer.Stop()
 	}
 
+	if c.channelGraceTimer != nil {
+		c.channelGraceTimer.Stop()
+	}
+
 	c.authenticated = false
 
 	return nil
@@ -283,8 +574,16 @@ func (c *client) info(ch Channel) ClientInfo {
 	return *newClientInfo(c.User, c.UID, rawDefaultInfo, rawChannelInfo)
 }
 
-func cmdFromClientMsg(msgBytes []byte) ([]clientCommand, error) {
+// cmdFromClientMsg decodes commands from client request. Request type detected by
+// first non-whitespace byte so request decoded only once. Array of commands decoded
+// one by one so decoding stops with ErrLimitExceeded as soon as array contains more
+// than maxCommands commands. Zero maxCommands means no limit.
+func cmdFromClientMsg(msgBytes []byte, maxCommands int) ([]clientCommand, error) {
 	var commands []clientCommand
+	msgBytes = bytes.TrimLeft(msgBytes, " \t\r\n")
+	if len(msgBytes) == 0 {
+		return nil, ErrInvalidMessage
+	}
 	firstByte := msgBytes[0]
 	switch firstByte {
 	case objectJSONPrefix:
@@ -297,10 +596,28 @@ func cmdFromClientMsg(msgBytes []byte) ([]clientCommand, error) {
 		commands = append(commands, command)
 	case arrayJSONPrefix:
 		// array of commands received
-		err := json.Unmarshal(msgBytes, &commands)
-		if err != nil {
+		dec := json.NewDecoder(bytes.NewReader(msgBytes))
+		// opening bracket.
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		for dec.More() {
+			if maxCommands > 0 && len(commands) >= maxCommands {
+				return nil, ErrLimitExceeded
+			}
+			var command clientCommand
+			err := dec.Decode(&command)
+			if err != nil {
+				return nil, err
+			}
+			commands = append(commands, command)
+		}
+		// closing bracket.
+		if _, err := dec.Token(); err != nil {
 			return nil, err
 		}
+	default:
+		return nil, ErrInvalidMessage
 	}
 	return commands, nil
 }
@@ -323,13 +640,31 @@ func (c *client) message(msg []byte) error {
 		time.Sleep(waitBeforeClose)
 		return ErrInvalidMessage
 	} else if len(msg) > c.maxRequestSize {
-		logger.ERROR.Println("client request exceeds max request size limit")
-		c.disconnect(ErrLimitExceeded.Error(), false)
+		// size checked before parsing to avoid decoding huge requests.
+		logger.ERROR.Printf("client request size %d exceeds max request size limit %d", len(msg), c.maxRequestSize)
+		c.disconnect("request size limit exceeded", false)
 		time.Sleep(waitBeforeClose)
 		return ErrLimitExceeded
 	}
 
-	commands, err := cmdFromClientMsg(msg)
+	if c.msgpackFrames {
+		var err error
+		msg, err = encode.MsgpackToJSON(msg)
+		if err != nil {
+			logger.ERROR.Println(err)
+			c.disconnect(ErrInvalidMessage.Error(), false)
+			time.Sleep(waitBeforeClose)
+			return ErrInvalidMessage
+		}
+	}
+
+	commands, err := cmdFromClientMsg(msg, c.maxCommands)
+	if err == ErrLimitExceeded {
+		logger.ERROR.Printf("client request exceeds max commands per request limit %d", c.maxCommands)
+		c.disconnect("request commands limit exceeded", false)
+		time.Sleep(waitBeforeClose)
+		return ErrLimitExceeded
+	}
 	if err != nil {
 		logger.ERROR.Println(err)
 		c.disconnect(ErrInvalidMessage.Error(), false)
@@ -347,13 +682,11 @@ func (c *client) message(msg []byte) error {
 	}
 
 	err = c.handleCommands(commands)
+	if err == ErrClientClosed {
+		return err
+	}
 	if err != nil {
-		reconnect := false
-		if err == ErrInternalServerError {
-			// In case of any internal server error we give client an advice to reconnect.
-			// Any other error results in disconnect without reconnect.
-			reconnect = true
-		}
+		reconnect := shouldReconnect(err)
 		c.disconnect(err.Error(), reconnect)
 		if !reconnect {
 			time.Sleep(waitBeforeClose)
@@ -368,27 +701,80 @@ func (c *client) disconnect(reason string, reconnect bool) error {
 		Reconnect: reconnect,
 	}
 	resp := newClientDisconnectResponse(body)
-	jsonResp, err := json.Marshal(resp)
+	jsonResp, err := encodeJSON(resp)
 	if err != nil {
 		return err
 	}
 	return c.send(jsonResp)
 }
 
+// fatalCommandErrors contains errors returned by command handlers after which
+// connection must be closed. Other errors sent to client in command response.
+var fatalCommandErrors = map[error]bool{
+	ErrUnauthorized: true,
+	ErrInvalidToken: true,
+	ErrClientClosed: true,
+}
+
+// handleCommands handles batch of commands from client and sends responses to all
+// of them in the same order. It returns error only if connection must be closed.
 func (c *client) handleCommands(commands []clientCommand) error {
 	c.Lock()
 	defer c.Unlock()
+	select {
+	case <-c.closeChan:
+		// connection already torn down, commands must not register it in hub again.
+		return ErrClientClosed
+	default:
+	}
+	c.app.RLock()
+	maxViolations := c.app.config.ClientCommandsMaxViolations
+	c.app.RUnlock()
 	var err error
-	var mr multiClientResponse
+	var connectSeen bool
+	mr := make(multiClientResponse, 0, len(commands))
 	for _, command := range commands {
+		if command.Method == "connect" {
+			if connectSeen {
+				// only first connect command of batch handled so one message can
+				// not make many connect proxy requests.
+				resp := newClientErrorResponse(command.Method, responseError{ErrInvalidMessage, errorAdviceFix})
+				resp.SetUID(command.UID)
+				mr = append(mr, resp)
+				continue
+			}
+			connectSeen = true
+		}
+		if !c.allowCommand(command, time.Now()) {
+			c.app.metrics.NumClientLimitExceeded.Inc()
+			c.limitViolations++
+			if maxViolations > 0 && c.limitViolations >= maxViolations {
+				logger.INFO.Printf("client %s exceeded command rate limit %d times in a row", c.uid(), c.limitViolations)
+				return ErrLimitExceeded
+			}
+			resp := newClientErrorResponse(command.Method, responseError{ErrLimitExceeded, errorAdviceRetry})
+			resp.SetUID(command.UID)
+			mr = append(mr, resp)
+			continue
+		}
+		c.limitViolations = 0
 		resp, err := c.handleCmd(command)
 		if err != nil {
-			return err
+			if fatalCommandErrors[err] {
+				return err
+			}
+			// error of one command must not prevent other commands in batch from
+			// being handled, send it to client in command response.
+			advice := errorAdviceFix
+			if err == ErrInternalServerError {
+				advice = errorAdviceRetry
+			}
+			resp = newClientErrorResponse(command.Method, responseError{err, advice})
 		}
 		resp.SetUID(command.UID)
 		mr = append(mr, resp)
 	}
-	jsonResp, err := json.Marshal(mr)
+	jsonResp, err := encodeJSON(mr)
 	if err != nil {
 		logger.ERROR.Println(err)
 		return ErrInvalidMessage
@@ -397,6 +783,21 @@ func (c *client) handleCommands(commands []clientCommand) error {
 	return err
 }
 
+// unlocked runs f with client lock released so slow backend requests do not block
+// other client operations. Must be called with client lock held, it returns true
+// if connection closed while lock was released.
+func (c *client) unlocked(f func()) bool {
+	c.Unlock()
+	f()
+	c.Lock()
+	select {
+	case <-c.closeChan:
+		return true
+	default:
+		return false
+	}
+}
+
 // handleCmd dispatches clientCommand into correct command handler
 func (c *client) handleCmd(command clientCommand) (response, error) {
 
@@ -439,6 +840,15 @@ func (c *client) handleCmd(command clientCommand) (response, error) {
 			return nil, ErrInvalidMessage
 		}
 		resp, err = c.unsubscribeCmd(&cmd)
+	case "unsubscribe_all":
+		resp, err = c.unsubscribeAllCmd()
+	case "ack":
+		var cmd ackClientCommand
+		err = json.Unmarshal(params, &cmd)
+		if err != nil {
+			return nil, ErrInvalidMessage
+		}
+		resp, err = c.ackCmd(&cmd)
 	case "publish":
 		var cmd publishClientCommand
 		err = json.Unmarshal(params, &cmd)
@@ -448,9 +858,12 @@ func (c *client) handleCmd(command clientCommand) (response, error) {
 		resp, err = c.publishCmd(&cmd)
 	case "ping":
 		var cmd pingClientCommand
-		err = json.Unmarshal(params, &cmd)
-		if err != nil {
-			return nil, ErrInvalidMessage
+		// params are optional for ping command.
+		if len(params) > 0 {
+			err = json.Unmarshal(params, &cmd)
+			if err != nil {
+				return nil, ErrInvalidMessage
+			}
 		}
 		resp, err = c.pingCmd(&cmd)
 	case "presence":
@@ -460,6 +873,13 @@ func (c *client) handleCmd(command clientCommand) (response, error) {
 			return nil, ErrInvalidMessage
 		}
 		resp, err = c.presenceCmd(&cmd)
+	case "presence_stats":
+		var cmd presenceStatsClientCommand
+		err = json.Unmarshal(params, &cmd)
+		if err != nil {
+			return nil, ErrInvalidMessage
+		}
+		resp, err = c.presenceStatsCmd(&cmd)
 	case "history":
 		var cmd historyClientCommand
 		err = json.Unmarshal(params, &cmd)
@@ -479,10 +899,18 @@ func (c *client) handleCmd(command clientCommand) (response, error) {
 
 // pingCmd handles ping command from client - this is necessary sometimes
 // for example Heroku closes websocket connection after 55 seconds
-// of inactive period when no messages with payload travelled over wire
+// of inactive period when no messages with payload travelled over wire.
+// Data provided by client echoed back so client can correlate concurrent
+// pings, "pong" returned if no data provided. Response also contains server
+// time in milliseconds.
 func (c *client) pingCmd(cmd *pingClientCommand) (response, error) {
+	data := cmd.Data
+	if data == "" {
+		data = "pong"
+	}
 	body := pingBody{
-		Data: cmd.Data,
+		Data: data,
+		Time: time.Now().UnixNano() / int64(time.Millisecond),
 	}
 	resp := newClientPingResponse(body)
 	return resp, nil
@@ -496,17 +924,30 @@ func (c *client) expire() {
 	connLifetime := c.app.config.ConnLifetime
 	c.app.RUnlock()
 
-	if connLifetime <= 0 {
+	expireAt := credentialsExpireAt(c.timestamp, c.exp, connLifetime)
+	if expireAt <= 0 {
 		return
 	}
 
-	timeToExpire := c.timestamp + connLifetime - time.Now().Unix()
+	timeToExpire := expireAt - time.Now().Unix()
 	if timeToExpire > 0 {
 		// connection was succesfully refreshed
 		return
 	}
 
-	c.close("expired")
+	// let client know about channels it lost, client can resubscribe after
+	// reconnecting with new credentials.
+	var messages [][]byte
+	for ch := range c.Channels {
+		respJSON, err := c.serverUnsubscribe(ch, true)
+		if err != nil {
+			logger.ERROR.Println(err)
+			continue
+		}
+		messages = append(messages, respJSON)
+	}
+
+	c.closeWithMessages(messages, "expired", true)
 	return
 }
 
@@ -526,39 +967,59 @@ func (c *client) connectCmd(cmd *connectClientCommand) (response, error) {
 	info := cmd.Info
 
 	c.app.RLock()
-	secret := c.app.config.Secret
+	secrets := c.app.config.tokenSecrets()
 	insecure := c.app.config.Insecure
 	closeDelay := c.app.config.ExpiredConnectionCloseDelay
 	connLifetime := c.app.config.ConnLifetime
 	version := c.app.config.Version
 	presenceInterval := c.app.config.PresencePingInterval
+	proxyEndpoint := c.app.config.ConnectProxyEndpoint
+	proxyFailOpen := c.app.config.ConnectProxyFailOpen
 	c.app.RUnlock()
 
-	var timestamp string
-	var token string
-	if !insecure {
-		timestamp = cmd.Timestamp
-		token = cmd.Token
-	} else {
-		timestamp = ""
-		token = ""
-	}
-
-	if !insecure {
-		isValid := auth.CheckClientToken(secret, string(user), timestamp, info, token)
-		if !isValid {
-			logger.ERROR.Println("invalid token for user", user)
-			return nil, ErrInvalidToken
+	if proxyEndpoint != "" {
+		req := c.connectProxyRequest(cmd.User, cmd.Timestamp, cmd.Info, cmd.Exp, cmd.Token, false)
+		var reply *connectProxyReply
+		var err error
+		if closed := c.unlocked(func() { reply, err = c.app.connectProxy(req) }); closed {
+			return nil, ErrClientClosed
 		}
-	}
-
-	if !insecure {
-		ts, err := strconv.Atoi(timestamp)
+		c.timestamp = time.Now().Unix()
 		if err != nil {
-			logger.ERROR.Println(err)
-			return nil, ErrInvalidMessage
+			c.app.metrics.NumConnectProxyErrors.Inc()
+			logger.ERROR.Printf("connect proxy error: %v", err)
+			if !proxyFailOpen {
+				resp := newClientConnectResponse(connectBody{Version: version})
+				resp.SetErr(responseError{ErrInternalServerError, errorAdviceRetry})
+				return resp, nil
+			}
+			// fail open – connection accepted as anonymous.
+			user = ""
+			info = ""
+		} else if reply.Error != nil {
+			resp := newClientConnectResponse(connectBody{Version: version})
+			resp.SetErr(responseError{reply.Error, errorAdviceFix})
+			return resp, nil
+		} else {
+			user = reply.User
+			info = string(reply.Info)
+			if reply.TTL > 0 {
+				c.exp = c.timestamp + reply.TTL
+			}
+			c.proxyAuth = true
+		}
+	} else if !insecure {
+		creds, err := checkCredentials(secrets, user, cmd.Timestamp, info, cmd.Exp, cmd.Token)
+		if err != nil {
+			return nil, err
 		}
-		c.timestamp = int64(ts)
+		if creds.previousSecret {
+			c.app.previousSecretUsed("connection token")
+		}
+		user = creds.user
+		info = creds.info
+		c.timestamp = creds.timestamp
+		c.exp = creds.exp
 	} else {
 		c.timestamp = time.Now().Unix()
 	}
@@ -567,17 +1028,30 @@ func (c *client) connectCmd(cmd *connectClientCommand) (response, error) {
 
 	body := connectBody{}
 	body.Version = version
-	body.Expires = connLifetime > 0
-	body.TTL = connLifetime
 
 	var timeToExpire int64
 
-	if connLifetime > 0 && !insecure {
-		timeToExpire = c.timestamp + connLifetime - time.Now().Unix()
+	if expireAt := credentialsExpireAt(c.timestamp, c.exp, connLifetime); expireAt > 0 && !insecure {
+		// ttl is a number of seconds left until connection credentials expire.
+		timeToExpire = expireAt - time.Now().Unix()
+		body.Expires = true
 		if timeToExpire <= 0 {
 			body.Expired = true
 			return newClientConnectResponse(body), nil
 		}
+		body.TTL = timeToExpire
+	}
+
+	err := c.app.addConn(c)
+	if err == ErrLimitExceeded {
+		logger.ERROR.Printf("maximum limit of connections per user reached for user %s", user)
+		resp := newClientConnectResponse(body)
+		resp.SetErr(responseError{ErrLimitExceeded, errorAdviceFix})
+		return resp, nil
+	}
+	if err != nil {
+		logger.ERROR.Println(err)
+		return nil, ErrInternalServerError
 	}
 
 	c.authenticated = true
@@ -591,77 +1065,220 @@ func (c *client) connectCmd(cmd *connectClientCommand) (response, error) {
 
 	c.presenceTimer = time.AfterFunc(presenceInterval, c.updatePresence)
 
-	err := c.app.addConn(c)
-	if err != nil {
-		logger.ERROR.Println(err)
-		return nil, ErrInternalServerError
-	}
-
 	if c.app.mediator != nil {
 		c.app.mediator.Connect(c.UID, c.User)
 	}
 
+	c.app.logConnection(c, connLogConnect, "")
+
 	if timeToExpire > 0 {
+		// client has closeDelay after credentials expired to send refresh command.
 		duration := closeDelay + time.Duration(timeToExpire)*time.Second
 		c.expireTimer = time.AfterFunc(duration, c.expire)
 	}
 
 	body.Client = c.UID
+	body.Subs = c.serverSubscribe()
 	return newClientConnectResponse(body), nil
 }
 
-// refreshCmd handle refresh command to update connection with new
-// timestamp - this is only required when connection lifetime option set.
-func (c *client) refreshCmd(cmd *refreshClientCommand) (response, error) {
-
-	user := cmd.User
-	info := cmd.Info
-	timestamp := cmd.Timestamp
-	token := cmd.Token
+// parseExp parses optional explicit expiration time of connection credentials.
+func parseExp(exp string) (int64, error) {
+	if exp == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(exp, 10, 64)
+}
 
-	c.app.RLock()
-	secret := c.app.config.Secret
-	c.app.RUnlock()
+// credentials are connection credentials provided by client in connect or refresh
+// command.
+type credentials struct {
+	user      UserID
+	timestamp int64
+	info      string
+	exp       int64
+	// jwt is true when credentials came from JWT connection token.
+	jwt bool
+	// previousSecret is true when token valid only with previous secret.
+	previousSecret bool
+	// proxy is true when credentials provided by connect proxy.
+	proxy bool
+}
 
-	isValid := auth.CheckClientToken(secret, string(user), timestamp, info, token)
+// checkCredentials validates credentials provided by client. Token can be HS256 JWT
+// signed with project secret – in this case user, info and expiration time taken
+// from token claims (sub, info and exp) and other command fields ignored, JWT must
+// have exp or iat claim. Token checked with each of secrets in order. Expired
+// credentials are not an error here.
+func checkCredentials(secrets []string, user UserID, timestamp, info, exp, token string) (credentials, error) {
+	if auth.IsJWT(token) {
+		var claims auth.ClientClaims
+		var err error
+		var n int
+		for n = range secrets {
+			claims, err = auth.ParseClientJWT(secrets[n], token)
+			if err == nil {
+				break
+			}
+		}
+		if err != nil {
+			logger.ERROR.Printf("invalid JWT connection token: %v", err)
+			return credentials{}, ErrInvalidToken
+		}
+		if claims.Iat == 0 && claims.Exp == 0 {
+			// such token could be reused forever as its lifetime can not be checked.
+			logger.ERROR.Println("JWT connection token without exp and iat claims")
+			return credentials{}, ErrInvalidToken
+		}
+		ts := claims.Iat
+		if ts == 0 {
+			ts = time.Now().Unix()
+		}
+		return credentials{
+			user:           UserID(claims.Sub),
+			timestamp:      ts,
+			info:           string(claims.Info),
+			exp:            claims.Exp,
+			jwt:            true,
+			previousSecret: n > 0,
+		}, nil
+	}
+
+	isValid := false
+	var n int
+	for n = range secrets {
+		if auth.CheckClientTokenWithExp(secrets[n], string(user), timestamp, info, exp, token) {
+			isValid = true
+			break
+		}
+	}
 	if !isValid {
-		logger.ERROR.Println("invalid refresh token for user", user)
-		return nil, ErrInvalidToken
+		logger.ERROR.Println("invalid token for user", user)
+		return credentials{}, ErrInvalidToken
 	}
 
-	ts, err := strconv.Atoi(timestamp)
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
 	if err != nil {
 		logger.ERROR.Println(err)
-		return nil, ErrInvalidMessage
+		return credentials{}, ErrInvalidMessage
+	}
+
+	expAt, err := parseExp(exp)
+	if err != nil {
+		logger.ERROR.Println(err)
+		return credentials{}, ErrInvalidMessage
+	}
+
+	return credentials{user: user, timestamp: ts, info: info, exp: expAt, previousSecret: n > 0}, nil
+}
+
+// credentialsExpireAt returns unix time connection credentials issued at timestamp
+// expire at, zero means credentials never expire. Explicit expiration time exp
+// overrides connection lifetime.
+func credentialsExpireAt(timestamp int64, exp int64, connLifetime int64) int64 {
+	if exp > 0 {
+		return exp
 	}
+	if connLifetime > 0 {
+		return timestamp + connLifetime
+	}
+	return 0
+}
+
+// refreshCmd handle refresh command to update connection with new
+// timestamp - this is only required when connection lifetime option set.
+// Connections authenticated by connect proxy refreshed by connect proxy too.
+func (c *client) refreshCmd(cmd *refreshClientCommand) (response, error) {
 
 	c.app.RLock()
+	secrets := c.app.config.tokenSecrets()
 	closeDelay := c.app.config.ExpiredConnectionCloseDelay
 	connLifetime := c.app.config.ConnLifetime
 	version := c.app.config.Version
 	c.app.RUnlock()
 
+	var creds credentials
+	if c.proxyAuth {
+		req := c.connectProxyRequest(cmd.User, cmd.Timestamp, cmd.Info, cmd.Exp, cmd.Token, true)
+		var reply *connectProxyReply
+		var err error
+		if closed := c.unlocked(func() { reply, err = c.app.connectProxy(req) }); closed {
+			return nil, ErrClientClosed
+		}
+		if err != nil {
+			c.app.metrics.NumConnectProxyErrors.Inc()
+			logger.ERROR.Printf("connect proxy error: %v", err)
+			resp := newClientRefreshResponse(connectBody{Version: version, Client: c.UID})
+			resp.SetErr(responseError{ErrInternalServerError, errorAdviceRetry})
+			return resp, nil
+		}
+		if reply.Error != nil {
+			resp := newClientRefreshResponse(connectBody{Version: version, Client: c.UID})
+			resp.SetErr(responseError{reply.Error, errorAdviceFix})
+			return resp, nil
+		}
+		creds = credentials{
+			user:      reply.User,
+			timestamp: time.Now().Unix(),
+			info:      string(reply.Info),
+			proxy:     true,
+		}
+		if reply.TTL > 0 {
+			creds.exp = creds.timestamp + reply.TTL
+		}
+	} else {
+		var err error
+		creds, err = checkCredentials(secrets, cmd.User, cmd.Timestamp, cmd.Info, cmd.Exp, cmd.Token)
+		if err != nil {
+			return nil, err
+		}
+		if creds.previousSecret {
+			c.app.previousSecretUsed("connection token")
+		}
+	}
+	if creds.user != c.User {
+		logger.ERROR.Printf("refresh credentials of user %s provided for user %s", creds.user, c.User)
+		return nil, ErrInvalidToken
+	}
+
+	expireAt := credentialsExpireAt(creds.timestamp, creds.exp, connLifetime)
+
 	body := connectBody{}
 	body.Version = version
-	body.Expires = connLifetime > 0
-	body.TTL = connLifetime
+	body.Expires = expireAt > 0
 	body.Client = c.UID
 
-	if connLifetime > 0 {
+	if expireAt > 0 {
 		// connection check enabled
-		timeToExpire := int64(ts) + connLifetime - time.Now().Unix()
-		if timeToExpire > 0 {
-			// connection refreshed, update client timestamp and set new expiration timeout
-			c.timestamp = int64(ts)
-			c.defaultInfo = []byte(info)
-			if c.expireTimer != nil {
-				c.expireTimer.Stop()
+		timeToExpire := expireAt - time.Now().Unix()
+		stale := creds.timestamp <= c.timestamp
+		if creds.jwt || creds.proxy {
+			// JWT issued in the same second as current credentials is still fresh
+			// if it extends connection lifetime, the same for connect proxy reply.
+			stale = expireAt <= credentialsExpireAt(c.timestamp, c.exp, connLifetime)
+		}
+		if timeToExpire <= 0 || stale {
+			// refresh must extend connection lifetime – stale timestamps rejected
+			// and connection will be closed when current credentials expire.
+			body.Expired = timeToExpire <= 0
+			body.TTL = credentialsExpireAt(c.timestamp, c.exp, connLifetime) - time.Now().Unix()
+			if body.TTL < 0 {
+				body.TTL = 0
 			}
-			duration := time.Duration(timeToExpire)*time.Second + closeDelay
-			c.expireTimer = time.AfterFunc(duration, c.expire)
-		} else {
-			body.Expired = true
+			resp := newClientRefreshResponse(body)
+			resp.SetErr(responseError{ErrConnectionExpired, errorAdviceFix})
+			return resp, nil
 		}
+		// connection refreshed, update client timestamp and set new expiration timeout
+		c.timestamp = creds.timestamp
+		c.exp = creds.exp
+		c.defaultInfo = []byte(creds.info)
+		if c.expireTimer != nil {
+			c.expireTimer.Stop()
+		}
+		duration := time.Duration(timeToExpire)*time.Second + closeDelay
+		c.expireTimer = time.AfterFunc(duration, c.expire)
+		body.TTL = timeToExpire
 	}
 	return newClientRefreshResponse(body), nil
 }
@@ -699,16 +1316,135 @@ func recoverMessages(last MessageID, messages []Message) ([]Message, bool) {
 // client if it provided last message id seen in channel.
 func (c *client) subscribeCmd(cmd *subscribeClientCommand) (response, error) {
 
+	if len(cmd.Channels) > 0 {
+		return c.batchSubscribeCmd(cmd)
+	}
+
 	channel := cmd.Channel
 	if channel == "" {
 		return nil, ErrInvalidMessage
 	}
 
+	body, respErr, err := c.subscribe(cmd)
+	resp := newClientSubscribeResponse(body)
+	if err != nil {
+		return resp, err
+	}
+	if respErr.err != nil {
+		resp.SetErr(respErr)
+	}
+	return resp, nil
+}
+
+// batchSubscribeCmd subscribes client on all channels provided in Channels field of
+// subscribe command. Result for every channel returned in map keyed by channel, only
+// first occurrence of channel repeated in batch is used. Note that client_channel_limit
+// is checked against total amount of channels client will be subscribed on.
+func (c *client) batchSubscribeCmd(cmd *subscribeClientCommand) (response, error) {
+
+	body := batchSubscribeBody{}
+
+	channels := make([]subscribeChannelCommand, 0, len(cmd.Channels))
+	seen := make(map[Channel]bool, len(cmd.Channels))
+	numNewChannels := 0
+	for _, ch := range cmd.Channels {
+		if ch.Channel == "" {
+			return nil, ErrInvalidMessage
+		}
+		if seen[ch.Channel] {
+			continue
+		}
+		seen[ch.Channel] = true
+		channels = append(channels, ch)
+		if _, ok := c.Channels[ch.Channel]; !ok {
+			numNewChannels++
+		}
+	}
+
+	if !c.checkChannelLimit(len(c.Channels) + numNewChannels) {
+		resp := newClientBatchSubscribeResponse(body)
+		resp.SetErr(responseError{ErrLimitExceeded, errorAdviceFix})
+		return resp, nil
+	}
+
+	for _, ch := range channels {
+		channelCmd := &subscribeClientCommand{
+			Channel: ch.Channel,
+			Client:  cmd.Client,
+			Last:    ch.Last,
+			Recover: ch.Recover,
+			Info:    ch.Info,
+			Sign:    ch.Sign,
+			History: ch.History,
+		}
+		channelBody, respErr, err := c.subscribe(channelCmd)
+		if err == ErrClientClosed {
+			return nil, err
+		}
+		body[ch.Channel] = newSubscribeResult(channelBody, respErr, err)
+	}
+
+	return newClientBatchSubscribeResponse(body), nil
+}
+
+// newSubscribeResult returns result of subscription on one channel built from
+// values returned by subscribe.
+func newSubscribeResult(body subscribeBody, respErr responseError, err error) subscribeResult {
+	result := subscribeResult{subscribeBody: body}
+	if err != nil {
+		respErr = responseError{err, errorAdviceRetry}
+	}
+	if respErr.err != nil {
+		result.Error = respErr.err.Error()
+		result.Advice = respErr.Advice
+	}
+	return result
+}
+
+// serverSubscribe subscribes connection on channels from server_subscriptions
+// right after connect. All checks of client subscriptions applied. {user}
+// placeholder in channel template replaced with connection user ID, templates
+// with placeholder skipped for anonymous connections.
+func (c *client) serverSubscribe() batchSubscribeBody {
+	c.app.RLock()
+	templates := c.app.config.ServerSubscriptions
+	c.app.RUnlock()
+
+	if len(templates) == 0 {
+		return nil
+	}
+
+	body := batchSubscribeBody{}
+	for _, template := range templates {
+		if c.User == "" && strings.Contains(template, serverSubscriptionUserPlaceholder) {
+			continue
+		}
+		channel := Channel(strings.Replace(template, serverSubscriptionUserPlaceholder, string(c.User), -1))
+		if channel == "" {
+			continue
+		}
+		channelBody, respErr, err := c.subscribe(&subscribeClientCommand{Channel: channel})
+		if err == ErrClientClosed {
+			break
+		}
+		if err != nil || respErr.err != nil {
+			logger.ERROR.Printf("server subscription of client %s on channel %s failed", c.uid(), channel)
+		}
+		body[channel] = newSubscribeResult(channelBody, respErr, err)
+	}
+	return body
+}
+
+// subscribe subscribes client on one channel. It returns subscribe response body,
+// error which must be sent to client in response and internal error if any.
+func (c *client) subscribe(cmd *subscribeClientCommand) (subscribeBody, responseError, error) {
+
+	channel := cmd.Channel
+
 	c.app.RLock()
-	secret := c.app.config.Secret
 	maxChannelLength := c.app.config.MaxChannelLength
-	channelLimit := c.app.config.ClientChannelLimit
 	insecure := c.app.config.Insecure
+	subscribeProxy := c.app.config.SubscribeProxyEndpoint != ""
 	c.app.RUnlock()
 
 	body := subscribeBody{
@@ -717,66 +1453,87 @@ func (c *client) subscribeCmd(cmd *subscribeClientCommand) (response, error) {
 
 	if len(channel) > maxChannelLength {
 		logger.ERROR.Printf("channel too long: max %d, got %d", maxChannelLength, len(channel))
-		resp := newClientSubscribeResponse(body)
-		resp.SetErr(responseError{ErrLimitExceeded, errorAdviceFix})
-		return resp, nil
+		return body, responseError{ErrLimitExceeded, errorAdviceFix}, nil
 	}
 
-	if len(c.Channels) >= channelLimit {
-		logger.ERROR.Printf("maximimum limit of channels per client reached: %d", channelLimit)
-		resp := newClientSubscribeResponse(body)
-		resp.SetErr(responseError{ErrLimitExceeded, errorAdviceFix})
-		return resp, nil
+	if _, ok := c.Channels[channel]; ok {
+		return body, responseError{ErrAlreadySubscribed, errorAdviceFix}, nil
 	}
 
-	if _, ok := c.Channels[channel]; ok {
-		resp := newClientSubscribeResponse(body)
-		resp.SetErr(responseError{ErrAlreadySubscribed, errorAdviceFix})
-		return resp, nil
+	if !c.allowSubscribe(time.Now()) {
+		return body, responseError{ErrTooMuchChurn, errorAdviceRetry}, nil
 	}
 
-	if !c.app.userAllowed(channel, c.User) || !c.app.clientAllowed(channel, c.UID) {
-		resp := newClientSubscribeResponse(body)
-		resp.SetErr(responseError{ErrPermissionDenied, errorAdviceFix})
-		return resp, nil
+	if !c.checkChannelLimit(len(c.Channels) + 1) {
+		return body, responseError{ErrLimitExceeded, errorAdviceFix}, nil
+	}
+
+	// In insecure mode user ID is not verified so user limited channels are
+	// not protected.
+	if (!insecure && !c.app.userAllowed(channel, c.User)) || !c.app.clientAllowed(channel, c.UID) {
+		return body, responseError{ErrPermissionDenied, errorAdviceFix}, nil
 	}
 
 	chOpts, err := c.app.channelOpts(channel)
 	if err != nil {
-		resp := newClientSubscribeResponse(body)
-		resp.SetErr(responseError{err, errorAdviceFix})
-		return resp, nil
+		return body, responseError{err, errorAdviceFix}, nil
 	}
 
 	if !chOpts.Anonymous && c.User == "" && !insecure {
-		resp := newClientSubscribeResponse(body)
-		resp.SetErr(responseError{ErrPermissionDenied, errorAdviceFix})
-		return resp, nil
+		return body, responseError{ErrPermissionDenied, errorAdviceFix}, nil
 	}
 
-	if c.app.privateChannel(channel) {
+	if c.app.privateChannel(channel) && subscribeProxy {
+		// private channel - backend decides whether client can subscribe.
+		req := c.subscribeProxyRequest(channel)
+		var reply *subscribeProxyReply
+		if closed := c.unlocked(func() { reply, err = c.app.subscribeProxy(req) }); closed {
+			return body, responseError{}, ErrClientClosed
+		}
+		// client state could change while lock was released.
+		if _, ok := c.Channels[channel]; ok {
+			return body, responseError{ErrAlreadySubscribed, errorAdviceFix}, nil
+		}
+		if !c.checkChannelLimit(len(c.Channels) + 1) {
+			return body, responseError{ErrLimitExceeded, errorAdviceFix}, nil
+		}
+		if err != nil {
+			c.app.metrics.NumSubscribeProxyErrors.Inc()
+			logger.ERROR.Printf("subscribe proxy error: %v", err)
+			return body, responseError{ErrInternalServerError, errorAdviceRetry}, nil
+		}
+		if reply.Error != nil {
+			return body, responseError{reply.Error, errorAdviceFix}, nil
+		}
+		c.channelInfo[channel] = []byte(reply.Info)
+	} else if c.app.privateChannel(channel) {
 		// private channel - subscription must be properly signed
 		if string(c.UID) != string(cmd.Client) {
-			resp := newClientSubscribeResponse(body)
-			resp.SetErr(responseError{ErrPermissionDenied, errorAdviceFix})
-			return resp, nil
+			return body, responseError{ErrPermissionDenied, errorAdviceFix}, nil
 		}
-		isValid := auth.CheckChannelSign(secret, string(cmd.Client), string(channel), cmd.Info, cmd.Sign)
+		isValid := c.app.checkChannelSign(c.UID, channel, cmd.Info, cmd.Sign)
 		if !isValid {
-			resp := newClientSubscribeResponse(body)
-			resp.SetErr(responseError{ErrPermissionDenied, errorAdviceFix})
-			return resp, nil
+			return body, responseError{ErrPermissionDenied, errorAdviceFix}, nil
 		}
 		c.channelInfo[channel] = []byte(cmd.Info)
 	}
 
 	c.Channels[channel] = true
+	c.addChurn(time.Now())
+	c.updateQueuePolicy()
 
 	err = c.app.addSub(channel, c)
 	if err != nil {
-		logger.ERROR.Println(err)
-		resp := newClientSubscribeResponse(body)
-		return resp, ErrInternalServerError
+		// Node was not subscribed on channel by engine so subscription rolled back
+		// and client must retry subscribe later.
+		delete(c.Channels, channel)
+		c.resetChannelLimit()
+		c.updateQueuePolicy()
+		delete(c.channelInfo, channel)
+		if err == ErrSubscribeTimeout {
+			return body, responseError{ErrSubscribeTimeout, errorAdviceRetry}, nil
+		}
+		return body, responseError{ErrInternalServerError, errorAdviceRetry}, nil
 	}
 
 	info := c.info(channel)
@@ -785,8 +1542,7 @@ func (c *client) subscribeCmd(cmd *subscribeClientCommand) (response, error) {
 		err = c.app.addPresence(channel, c.UID, info)
 		if err != nil {
 			logger.ERROR.Println(err)
-			resp := newClientSubscribeResponse(body)
-			return resp, ErrInternalServerError
+			return body, responseError{}, ErrInternalServerError
 		}
 	}
 
@@ -795,7 +1551,7 @@ func (c *client) subscribeCmd(cmd *subscribeClientCommand) (response, error) {
 			// Client provided subscribe request with recover flag on. Try to recover missed messages
 			// automatically from history (we suppose here that history configured wisely) based on
 			// provided last message id value.
-			messages, err := c.app.History(channel)
+			messages, err := c.app.recoveryHistory(channel)
 			if err != nil {
 				logger.ERROR.Printf("can't recover messages for channel %s: %s", string(channel), err)
 				body.Messages = []Message{}
@@ -803,6 +1559,11 @@ func (c *client) subscribeCmd(cmd *subscribeClientCommand) (response, error) {
 				recoveredMessages, recovered := recoverMessages(cmd.Last, messages)
 				body.Messages = recoveredMessages
 				body.Recovered = recovered
+				if cmd.Last == MessageID("") && len(messages) > 0 {
+					// Client has not seen any message in channel yet so we also return
+					// last message id to let it track messages from this point.
+					body.Last = MessageID(messages[0].UID)
+				}
 			}
 		} else {
 			// Client don't want to recover messages yet, we just return last message id to him here.
@@ -815,9 +1576,27 @@ func (c *client) subscribeCmd(cmd *subscribeClientCommand) (response, error) {
 		}
 	}
 
+	if cmd.History > 0 && chOpts.HistorySize > 0 && chOpts.HistoryLifetime > 0 {
+		// History fetched after node subscribed on channel in engine so no message
+		// is lost between history and new messages – though the same message can
+		// come both in history and as new message.
+		limit := cmd.History
+		if limit > chOpts.HistorySize {
+			limit = chOpts.HistorySize
+		}
+		messages, err := c.app.engine.history(channel, limit)
+		if err != nil {
+			logger.ERROR.Printf("can't get history for channel %s: %s", string(channel), err)
+		} else {
+			body.History = messages
+		}
+	}
+
 	if chOpts.JoinLeave {
+		// join message published asynchronously so subscribed client receives it
+		// too – possibly even before subscribe response.
 		go func() {
-			err = c.app.pubJoin(channel, info)
+			err := c.app.pubJoin(channel, info)
 			if err != nil {
 				logger.ERROR.Println(err)
 			}
@@ -830,7 +1609,7 @@ func (c *client) subscribeCmd(cmd *subscribeClientCommand) (response, error) {
 
 	body.Status = true
 
-	return newClientSubscribeResponse(body), nil
+	return body, responseError{}, nil
 }
 
 // unsubscribeCmd handles unsubscribe command from client - it allows to
@@ -859,19 +1638,15 @@ func (c *client) unsubscribeCmd(cmd *unsubscribeClientCommand) (response, error)
 	if ok {
 
 		delete(c.Channels, channel)
+		c.resetChannelLimit()
+		c.addChurn(time.Now())
+		c.updateQueuePolicy()
 
 		err = c.app.removePresence(channel, c.UID)
 		if err != nil {
 			logger.ERROR.Println(err)
 		}
 
-		if chOpts.JoinLeave {
-			err = c.app.pubLeave(channel, info)
-			if err != nil {
-				logger.ERROR.Println(err)
-			}
-		}
-
 		err = c.app.removeSub(channel, c)
 		if err != nil {
 			logger.ERROR.Println(err)
@@ -880,6 +1655,15 @@ func (c *client) unsubscribeCmd(cmd *unsubscribeClientCommand) (response, error)
 			return resp, nil
 		}
 
+		if chOpts.JoinLeave {
+			// leave message published after client removed from channel subscribers
+			// so client does not receive its own leave message.
+			err = c.app.pubLeave(channel, info)
+			if err != nil {
+				logger.ERROR.Println(err)
+			}
+		}
+
 		if c.app.mediator != nil {
 			c.app.mediator.Unsubscribe(channel, c.UID, c.User)
 		}
@@ -891,6 +1675,35 @@ func (c *client) unsubscribeCmd(cmd *unsubscribeClientCommand) (response, error)
 	return newClientUnsubscribeResponse(body), nil
 }
 
+// unsubscribeAllCmd handles unsubscribe_all command from client - it allows to
+// unsubscribe connection from all channels it subscribed to without listing
+// them. Response contains channels connection was unsubscribed from.
+func (c *client) unsubscribeAllCmd() (response, error) {
+
+	body := unsubscribeAllBody{
+		Channels: []Channel{},
+	}
+
+	var respErr error
+	for channel := range c.Channels {
+		resp, err := c.unsubscribeCmd(&unsubscribeClientCommand{Channel: channel})
+		if err != nil {
+			return nil, err
+		}
+		if resp.(*clientUnsubscribeResponse).err != nil {
+			respErr = ErrInternalServerError
+			continue
+		}
+		body.Channels = append(body.Channels, channel)
+	}
+
+	resp := newClientUnsubscribeAllResponse(body)
+	if respErr != nil {
+		resp.SetErr(responseError{respErr, errorAdviceRetry})
+	}
+	return resp, nil
+}
+
 // publishCmd handles publish command - clients can publish messages into
 // channels themselves if `publish` allowed by channel options. In most cases clients not
 // allowed to publish into channels directly - web application publishes messages
@@ -910,9 +1723,58 @@ func (c *client) publishCmd(cmd *publishClientCommand) (response, error) {
 		return resp, nil
 	}
 
+	chOpts, err := c.app.channelOpts(channel)
+	if err != nil {
+		resp := newClientPublishResponse(body)
+		resp.SetErr(responseError{err, errorAdviceFix})
+		return resp, nil
+	}
+
+	c.app.RLock()
+	proxyEndpoint := c.app.config.PublishProxyEndpoint
+	proxyPassThrough := c.app.config.PublishProxyPassThrough
+	c.app.RUnlock()
+
+	if chOpts.Publish && chOpts.PublishProxy && proxyEndpoint != "" {
+		req := c.publishProxyRequest(cmd)
+		var reply *publishProxyReply
+		if closed := c.unlocked(func() { reply, err = c.app.publishProxy(req) }); closed {
+			return nil, ErrClientClosed
+		}
+		// client could be unsubscribed while lock was released.
+		if _, ok := c.Channels[channel]; !ok {
+			resp := newClientPublishResponse(body)
+			resp.SetErr(responseError{ErrPermissionDenied, errorAdviceFix})
+			return resp, nil
+		}
+		if err != nil {
+			logger.ERROR.Printf("publish proxy error: %v", err)
+			if !proxyPassThrough {
+				c.app.metrics.NumPublishProxyRejected.Inc()
+				resp := newClientPublishResponse(body)
+				resp.SetErr(responseError{ErrInternalServerError, errorAdviceRetry})
+				return resp, nil
+			}
+		} else if reply.Error != nil {
+			c.app.metrics.NumPublishProxyRejected.Inc()
+			resp := newClientPublishResponse(body)
+			resp.SetErr(responseError{reply.Error, errorAdviceFix})
+			return resp, nil
+		} else if len(reply.Data) > 0 {
+			// backend transformed data, it becomes message payload.
+			data = reply.Data
+		}
+	}
+
 	info := c.info(channel)
 
-	err := c.app.publish(channel, data, c.UID, &info, true)
+	uid, err := c.app.publish(channel, data, cmd.Encoding, c.UID, &info, true, nil)
+	if err == ErrLimitExceeded {
+		body.Size = len(data)
+		resp := newClientPublishResponse(body)
+		resp.SetErr(responseError{err, errorAdviceFix})
+		return resp, nil
+	}
 	if err != nil {
 		resp := newClientPublishResponse(body)
 		resp.SetErr(responseError{err, errorAdviceRetry})
@@ -921,6 +1783,7 @@ func (c *client) publishCmd(cmd *publishClientCommand) (response, error) {
 
 	// message successfully published to engine.
 	body.Status = true
+	body.UID = uid
 
 	return newClientPublishResponse(body), nil
 }
@@ -955,6 +1818,53 @@ func (c *client) presenceCmd(cmd *presenceClientCommand) (response, error) {
 	return newClientPresenceResponse(body), nil
 }
 
+// ackCmd handles ack command from client – it removes acknowledged message from
+// connection ack window and records ack latency.
+func (c *client) ackCmd(cmd *ackClientCommand) (response, error) {
+	if cmd.Token == "" {
+		return nil, ErrInvalidMessage
+	}
+	body := ackBody{
+		Token: cmd.Token,
+	}
+	latency, ok := c.ackWindow.ack(cmd.Token, time.Now())
+	if ok {
+		c.app.metrics.NumAcks.Inc()
+		c.app.metrics.histograms.RecordMicroseconds("client_ack", latency)
+		body.Status = true
+	}
+	return newClientAckResponse(body), nil
+}
+
+// presenceStatsCmd handles presence_stats command from client – it returns
+// number of clients and unique users in channel without full presence data.
+func (c *client) presenceStatsCmd(cmd *presenceStatsClientCommand) (response, error) {
+
+	channel := cmd.Channel
+
+	body := presenceStatsBody{
+		Channel: channel,
+	}
+
+	if _, ok := c.Channels[channel]; !ok {
+		resp := newClientPresenceStatsResponse(body)
+		resp.SetErr(responseError{ErrPermissionDenied, errorAdviceFix})
+		return resp, nil
+	}
+
+	numClients, numUsers, err := c.app.PresenceStats(channel)
+	if err != nil {
+		resp := newClientPresenceStatsResponse(body)
+		resp.SetErr(responseError{err, errorAdviceRetry})
+		return resp, nil
+	}
+
+	body.NumClients = numClients
+	body.NumUsers = numUsers
+
+	return newClientPresenceStatsResponse(body), nil
+}
+
 // historyCmd handles history command - it shows last M messages published
 // into channel. M is history size and can be configured for project or namespace
 // via channel options. Also this method checks that history available for channel
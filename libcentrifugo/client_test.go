@@ -2,13 +2,17 @@ package libcentrifugo
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/centrifugal/centrifugo/libcentrifugo/auth"
+	"github.com/centrifugal/centrifugo/libcentrifugo/encode"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -25,7 +29,7 @@ func TestUnauthenticatedClient(t *testing.T) {
 	assert.Equal(t, []Channel{}, c.channels())
 
 	// check that unauthenticated client can be cleaned correctly
-	err = c.clean()
+	err = c.teardown("test")
 	assert.Equal(t, nil, err)
 }
 
@@ -71,6 +75,95 @@ func TestClientMessage(t *testing.T) {
 	assert.Equal(t, ErrUnauthorized, err)
 }
 
+func TestCmdFromClientMsgLimit(t *testing.T) {
+	msg := []byte(`[{"method":"ping"},{"method":"ping"}]`)
+	cmds, err := cmdFromClientMsg(msg, 2)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(cmds))
+	_, err = cmdFromClientMsg(msg, 1)
+	assert.Equal(t, ErrLimitExceeded, err)
+	cmds, err = cmdFromClientMsg(msg, 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(cmds))
+
+	// malformed array.
+	_, err = cmdFromClientMsg([]byte(`[{"method":"ping"}`), 0)
+	assert.NotEqual(t, nil, err)
+}
+
+func TestCmdFromClientMsg(t *testing.T) {
+	cmds, err := cmdFromClientMsg([]byte(" \n{\"method\":\"ping\"}"), 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(cmds))
+	assert.Equal(t, "ping", cmds[0].Method)
+
+	cmds, err = cmdFromClientMsg([]byte("\t[{\"method\":\"ping\"}]"), 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(cmds))
+
+	_, err = cmdFromClientMsg([]byte(`"ping"`), 0)
+	assert.Equal(t, ErrInvalidMessage, err)
+	_, err = cmdFromClientMsg([]byte("  "), 0)
+	assert.Equal(t, ErrInvalidMessage, err)
+	_, err = cmdFromClientMsg([]byte(`{"method":"ping"}}`), 0)
+	assert.NotEqual(t, nil, err)
+}
+
+func BenchmarkCmdFromClientMsg(b *testing.B) {
+	msg := []byte(`{"method":"subscribe","params":{"channel":"test"}}`)
+	for i := 0; i < b.N; i++ {
+		_, err := cmdFromClientMsg(msg, 0)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCmdFromClientMsgArray(b *testing.B) {
+	msg := []byte(`[{"method":"subscribe","params":{"channel":"test1"}},{"method":"subscribe","params":{"channel":"test2"}}]`)
+	for i := 0; i < b.N; i++ {
+		_, err := cmdFromClientMsg(msg, 0)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestClientMessageLimits(t *testing.T) {
+	app := testApp()
+	sink := make(chan []byte, 10)
+	c, err := newClient(app, &testSession{sink: sink})
+	assert.Equal(t, nil, err)
+
+	// single huge command rejected before parsing.
+	huge := []byte(`{"method":"publish","params":{"channel":"test","data":"` + strings.Repeat("x", 1024*65) + `"}}`)
+	err = c.message(huge)
+	assert.Equal(t, ErrLimitExceeded, err)
+	assert.True(t, strings.Contains(string(waitMessage(t, sink)), `"reason":"request size limit exceeded"`))
+
+	// huge array of tiny commands.
+	c, err = newClient(app, &testSession{sink: sink})
+	assert.Equal(t, nil, err)
+	c.maxRequestSize = 10 * 1024 * 1024
+	cmds := make([]string, 100000)
+	for i := range cmds {
+		cmds[i] = `{"method":"ping"}`
+	}
+	err = c.message([]byte("[" + strings.Join(cmds, ",") + "]"))
+	assert.Equal(t, ErrLimitExceeded, err)
+	assert.True(t, strings.Contains(string(waitMessage(t, sink)), `"reason":"request commands limit exceeded"`))
+}
+
+func waitMessage(t *testing.T, sink chan []byte) []byte {
+	select {
+	case msg := <-sink:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for message")
+	}
+	return nil
+}
+
 func TestSingleObjectMessage(t *testing.T) {
 	app := testApp()
 	c, err := newClient(app, &testSession{})
@@ -234,15 +327,15 @@ func TestClientConnect(t *testing.T) {
 	clientInfo := c.info(Channel(""))
 	assert.Equal(t, "user1", clientInfo.User)
 
-	assert.Equal(t, 1, len(app.clients.conns))
+	assert.Equal(t, 1, app.clients.nConns())
 
 	assert.NotEqual(t, "", c.uid(), "uid must be already set")
 	assert.NotEqual(t, "", c.user(), "user must be already set")
 
-	err = c.clean()
+	err = c.teardown("test")
 	assert.Equal(t, nil, err)
 
-	assert.Equal(t, 0, len(app.clients.conns))
+	assert.Equal(t, 0, app.clients.nConns())
 }
 
 func TestClientRefresh(t *testing.T) {
@@ -260,6 +353,262 @@ func TestClientRefresh(t *testing.T) {
 	assert.Equal(t, nil, err)
 }
 
+func TestClientConnectTTL(t *testing.T) {
+	app := testApp()
+	app.config.ConnLifetime = 60
+	c, err := newClient(app, &testSession{})
+	assert.Equal(t, nil, err)
+
+	timestamp := strconv.FormatInt(time.Now().Unix()-10, 10)
+	cmd := testConnectCmd(timestamp)
+	var connectCmd connectClientCommand
+	assert.Equal(t, nil, json.Unmarshal(cmd.Params, &connectCmd))
+	resp, err := c.connectCmd(&connectCmd)
+	assert.Equal(t, nil, err)
+	body := resp.(*clientConnectResponse).Body
+	assert.Equal(t, true, body.Expires)
+	assert.Equal(t, false, body.Expired)
+	assert.True(t, body.TTL > 45 && body.TTL <= 50)
+	assert.NotEqual(t, nil, c.expireTimer)
+
+	// expired credentials.
+	c, _ = newClient(app, &testSession{})
+	timestamp = strconv.FormatInt(time.Now().Unix()-100, 10)
+	assert.Equal(t, nil, json.Unmarshal(testConnectCmd(timestamp).Params, &connectCmd))
+	resp, err = c.connectCmd(&connectCmd)
+	assert.Equal(t, nil, err)
+	body = resp.(*clientConnectResponse).Body
+	assert.Equal(t, true, body.Expired)
+	assert.Equal(t, false, c.authenticated)
+}
+
+func TestClientRefreshExtendsLifetime(t *testing.T) {
+	app := testApp()
+	app.config.ConnLifetime = 60
+	c, err := newClient(app, &testSession{})
+	assert.Equal(t, nil, err)
+
+	now := time.Now().Unix()
+	err = c.handleCommands([]clientCommand{testConnectCmd(strconv.FormatInt(now-30, 10))})
+	assert.Equal(t, nil, err)
+
+	var refreshCmd refreshClientCommand
+
+	// fresh timestamp extends lifetime.
+	assert.Equal(t, nil, json.Unmarshal(testRefreshCmd(strconv.FormatInt(now, 10)).Params, &refreshCmd))
+	resp, err := c.refreshCmd(&refreshCmd)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, resp.(*clientRefreshResponse).responseError.err)
+	assert.Equal(t, now, c.timestamp)
+	assert.True(t, resp.(*clientRefreshResponse).Body.TTL > 55)
+
+	// stale timestamp rejected.
+	assert.Equal(t, nil, json.Unmarshal(testRefreshCmd(strconv.FormatInt(now-10, 10)).Params, &refreshCmd))
+	resp, err = c.refreshCmd(&refreshCmd)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, ErrConnectionExpired, resp.(*clientRefreshResponse).responseError.err)
+	assert.Equal(t, false, resp.(*clientRefreshResponse).Body.Expired)
+	assert.Equal(t, now, c.timestamp)
+
+	// expired timestamp rejected.
+	assert.Equal(t, nil, json.Unmarshal(testRefreshCmd(strconv.FormatInt(now-100, 10)).Params, &refreshCmd))
+	resp, err = c.refreshCmd(&refreshCmd)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, ErrConnectionExpired, resp.(*clientRefreshResponse).responseError.err)
+	assert.Equal(t, true, resp.(*clientRefreshResponse).Body.Expired)
+}
+
+func TestClientConnectExp(t *testing.T) {
+	app := testApp()
+	c, err := newClient(app, &testSession{})
+	assert.Equal(t, nil, err)
+
+	now := time.Now().Unix()
+	timestamp := strconv.FormatInt(now, 10)
+	exp := strconv.FormatInt(now+30, 10)
+	connectCmd := connectClientCommand{
+		User:      "user1",
+		Timestamp: timestamp,
+		Exp:       exp,
+		Token:     auth.GenerateClientTokenWithExp("secret", "user1", timestamp, "", exp),
+	}
+
+	// exp must be signed.
+	tampered := connectCmd
+	tampered.Exp = strconv.FormatInt(now+3600, 10)
+	_, err = c.connectCmd(&tampered)
+	assert.Equal(t, ErrInvalidToken, err)
+
+	// exp works without connection lifetime configured.
+	resp, err := c.connectCmd(&connectCmd)
+	assert.Equal(t, nil, err)
+	body := resp.(*clientConnectResponse).Body
+	assert.Equal(t, true, body.Expires)
+	assert.True(t, body.TTL > 25 && body.TTL <= 30)
+	assert.NotEqual(t, nil, c.expireTimer)
+
+	// refresh with new exp extends connection.
+	refreshTimestamp := strconv.FormatInt(now+1, 10)
+	refreshExp := strconv.FormatInt(now+120, 10)
+	refreshCmd := refreshClientCommand{
+		User:      "user1",
+		Timestamp: refreshTimestamp,
+		Exp:       refreshExp,
+		Token:     auth.GenerateClientTokenWithExp("secret", "user1", refreshTimestamp, "", refreshExp),
+	}
+	resp, err = c.refreshCmd(&refreshCmd)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, resp.(*clientRefreshResponse).responseError.err)
+	assert.True(t, resp.(*clientRefreshResponse).Body.TTL > 115)
+	assert.Equal(t, now+120, c.exp)
+}
+
+func TestClientConnectExpOverridesLifetime(t *testing.T) {
+	app := testApp()
+	app.config.ConnLifetime = 3600
+	c, err := newClient(app, &testSession{})
+	assert.Equal(t, nil, err)
+
+	now := time.Now().Unix()
+	timestamp := strconv.FormatInt(now, 10)
+	exp := strconv.FormatInt(now+30, 10)
+	resp, err := c.connectCmd(&connectClientCommand{
+		User:      "user1",
+		Timestamp: timestamp,
+		Exp:       exp,
+		Token:     auth.GenerateClientTokenWithExp("secret", "user1", timestamp, "", exp),
+	})
+	assert.Equal(t, nil, err)
+	assert.True(t, resp.(*clientConnectResponse).Body.TTL <= 30)
+
+	// expired exp.
+	c, _ = newClient(app, &testSession{})
+	exp = strconv.FormatInt(now-1, 10)
+	resp, err = c.connectCmd(&connectClientCommand{
+		User:      "user1",
+		Timestamp: timestamp,
+		Exp:       exp,
+		Token:     auth.GenerateClientTokenWithExp("secret", "user1", timestamp, "", exp),
+	})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, resp.(*clientConnectResponse).Body.Expired)
+	assert.Equal(t, false, c.authenticated)
+}
+
+func testJWT(t *testing.T, claims auth.ClientClaims) string {
+	token, err := auth.GenerateClientJWT("secret", claims)
+	assert.Equal(t, nil, err)
+	return token
+}
+
+func TestClientConnectJWT(t *testing.T) {
+	app := testApp()
+	c, err := newClient(app, &testSession{})
+	assert.Equal(t, nil, err)
+
+	now := time.Now().Unix()
+
+	// expired JWT gives expired connect response so client can refresh token.
+	resp, err := c.connectCmd(&connectClientCommand{Token: testJWT(t, auth.ClientClaims{Sub: "user1", Exp: now - 1})})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, resp.(*clientConnectResponse).Body.Expired)
+	assert.Equal(t, false, c.authenticated)
+
+	_, err = c.connectCmd(&connectClientCommand{Token: testJWT(t, auth.ClientClaims{Sub: "user1"}) + "x"})
+	assert.Equal(t, ErrInvalidToken, err)
+
+	// JWT without exp and iat could be reused forever.
+	_, err = c.connectCmd(&connectClientCommand{Token: testJWT(t, auth.ClientClaims{Sub: "user1"})})
+	assert.Equal(t, ErrInvalidToken, err)
+
+	// user and info taken from claims, legacy fields ignored.
+	token := testJWT(t, auth.ClientClaims{Sub: "user1", Info: []byte(`{"a":1}`), Exp: now + 30})
+	resp, err = c.connectCmd(&connectClientCommand{User: "user2", Token: token})
+	assert.Equal(t, nil, err)
+	body := resp.(*clientConnectResponse).Body
+	assert.Equal(t, true, body.Expires)
+	assert.True(t, body.TTL > 25 && body.TTL <= 30)
+	assert.Equal(t, UserID("user1"), c.user())
+	assert.Equal(t, `{"a":1}`, string(c.defaultInfo))
+
+	// refresh with new JWT.
+	token = testJWT(t, auth.ClientClaims{Sub: "user1", Exp: now + 120})
+	resp, err = c.refreshCmd(&refreshClientCommand{Token: token})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, resp.(*clientRefreshResponse).responseError.err)
+	assert.Equal(t, now+120, c.exp)
+
+	// JWT not extending lifetime rejected.
+	token = testJWT(t, auth.ClientClaims{Sub: "user1", Exp: now + 60})
+	resp, err = c.refreshCmd(&refreshClientCommand{Token: token})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, ErrConnectionExpired, resp.(*clientRefreshResponse).responseError.err)
+
+	// JWT of another user rejected.
+	token = testJWT(t, auth.ClientClaims{Sub: "user2", Exp: now + 600})
+	_, err = c.refreshCmd(&refreshClientCommand{Token: token})
+	assert.Equal(t, ErrInvalidToken, err)
+}
+
+func TestClientConnectPreviousSecret(t *testing.T) {
+	app := testApp()
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	// token signed with old secret rejected without secret_previous.
+	app.config.Secret = "new"
+	c, err := newClient(app, &testSession{})
+	assert.Equal(t, nil, err)
+	_, err = c.connectCmd(&connectClientCommand{User: "user1", Timestamp: timestamp, Token: auth.GenerateClientToken("secret", "user1", timestamp, "")})
+	assert.Equal(t, ErrInvalidToken, err)
+
+	app.config.SecretPrevious = "secret"
+	err = c.handleCommands([]clientCommand{testConnectCmd(timestamp)})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, c.authenticated)
+	assert.Equal(t, int64(1), app.metrics.NumSecretPreviousUsed.LoadRaw())
+
+	// token signed with current secret accepted too.
+	c, err = newClient(app, &testSession{})
+	assert.Equal(t, nil, err)
+	_, err = c.connectCmd(&connectClientCommand{User: "user1", Timestamp: timestamp, Token: auth.GenerateClientToken("new", "user1", timestamp, "")})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, c.authenticated)
+	assert.Equal(t, int64(1), app.metrics.NumSecretPreviousUsed.LoadRaw())
+}
+
+func TestClientExpireClose(t *testing.T) {
+	app := testApp()
+	app.config.ConnLifetime = 1
+	app.config.ExpiredConnectionCloseDelay = 0
+	sink := make(chan []byte, 100)
+	c, err := newClient(app, &testSession{sink: sink})
+	assert.Equal(t, nil, err)
+
+	err = c.handleCommands([]clientCommand{testConnectCmd(strconv.FormatInt(time.Now().Unix(), 10))})
+	assert.Equal(t, nil, err)
+
+	select {
+	case <-c.closeChan:
+	case <-time.After(3 * time.Second):
+		t.Fatal("expired connection must be closed")
+	}
+	var resp struct {
+		Method string         `json:"method"`
+		Body   disconnectBody `json:"body"`
+	}
+	for msg := range sink {
+		// skip responses to commands.
+		if msg[0] == objectJSONPrefix {
+			assert.Equal(t, nil, json.Unmarshal(msg, &resp))
+			if resp.Method == "disconnect" {
+				break
+			}
+		}
+	}
+	assert.Equal(t, "expired", resp.Body.Reason)
+	assert.Equal(t, true, resp.Body.Reconnect)
+}
+
 func TestClientPublish(t *testing.T) {
 	app := testApp()
 	c, err := newClient(app, &testSession{})
@@ -281,6 +630,60 @@ func TestClientPublish(t *testing.T) {
 	assert.Equal(t, nil, resp.(*clientPublishResponse).err)
 }
 
+func TestClientPublishUID(t *testing.T) {
+	c := newTestConfig()
+	c.ChannelOptions.HistorySize = 10
+	c.ChannelOptions.HistoryLifetime = 10
+	app := testMemoryAppWithConfig(&c)
+	client, err := newClient(app, &testSession{})
+	assert.Equal(t, nil, err)
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	err = client.handleCommands([]clientCommand{testConnectCmd(timestamp), testSubscribeCmd("test")})
+	assert.Equal(t, nil, err)
+
+	resp, err := client.handleCmd(testPublishCmd("test"))
+	assert.Equal(t, nil, err)
+	body := resp.(*clientPublishResponse).Body
+	assert.Equal(t, true, body.Status)
+
+	// returned UID matches UID of message published into channel.
+	history, err := app.History(Channel("test"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(history))
+	assert.Equal(t, history[0].UID, body.UID)
+}
+
+func TestClientPublishMaxMessageSize(t *testing.T) {
+	c := newTestConfig()
+	c.ChannelOptions.HistorySize = 10
+	c.ChannelOptions.HistoryLifetime = 10
+	c.ChannelOptions.MaxMessageSize = 5
+	app := testMemoryAppWithConfig(&c)
+	client, err := newClient(app, &testSession{})
+	assert.Equal(t, nil, err)
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	err = client.handleCommands([]clientCommand{testConnectCmd(timestamp), testSubscribeCmd("test")})
+	assert.Equal(t, nil, err)
+
+	params, _ := json.Marshal(publishClientCommand{Channel: "test", Data: []byte(`{"a":1}`)})
+	resp, err := client.handleCmd(clientCommand{Method: "publish", Params: params})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, ErrLimitExceeded, resp.(*clientPublishResponse).err)
+	assert.Equal(t, 7, resp.(*clientPublishResponse).Body.Size)
+
+	params, _ = json.Marshal(publishClientCommand{Channel: "test", Data: []byte(`{}`)})
+	resp, err = client.handleCmd(clientCommand{Method: "publish", Params: params})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, resp.(*clientPublishResponse).err)
+
+	// over-limit message never stored in history.
+	history, err := app.History(Channel("test"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(history))
+}
+
 func TestClientSubscribe(t *testing.T) {
 	app := testApp()
 	c, err := newClient(app, &testSession{})
@@ -292,13 +695,13 @@ func TestClientSubscribe(t *testing.T) {
 	assert.Equal(t, nil, err)
 	assert.Equal(t, 1, len(c.channels()))
 
-	assert.Equal(t, 1, len(app.clients.subs))
+	assert.Equal(t, 1, app.clients.nChannels())
 	assert.Equal(t, 1, len(c.channels()))
 
-	err = c.clean()
+	err = c.teardown("test")
 	assert.Equal(t, nil, err)
 
-	assert.Equal(t, 0, len(app.clients.subs))
+	assert.Equal(t, 0, app.clients.nChannels())
 }
 
 func TestClientSubscribePrivate(t *testing.T) {
@@ -359,6 +762,208 @@ func TestClientSubscribeLimits(t *testing.T) {
 
 }
 
+func TestClientBatchSubscribe(t *testing.T) {
+	app := testApp()
+	c, err := newClient(app, &testSession{})
+	assert.Equal(t, nil, err)
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	cmds := []clientCommand{testConnectCmd(timestamp)}
+	err = c.handleCommands(cmds)
+	assert.Equal(t, nil, err)
+
+	params := fmt.Sprintf(`{"client": "%s", "channels": ["test1", "test2", {"channel": "$test", "sign": "%s"}, {"channel": "$wrong", "sign": "wrong"}]}`, c.UID, testChannelSign(c.UID, "$test"))
+	resp, err := c.handleCmd(clientCommand{Method: "subscribe", Params: []byte(params)})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, resp.(*clientBatchSubscribeResponse).err)
+	body := resp.(*clientBatchSubscribeResponse).Body
+	assert.Equal(t, 4, len(body))
+	assert.Equal(t, true, body["test1"].Status)
+	assert.Equal(t, true, body["test2"].Status)
+	assert.Equal(t, true, body["$test"].Status)
+	assert.Equal(t, false, body["$wrong"].Status)
+	assert.Equal(t, ErrPermissionDenied.Error(), body["$wrong"].Error)
+	assert.Equal(t, errorAdviceFix, body["$wrong"].Advice)
+	assert.Equal(t, 3, len(c.channels()))
+
+	// limit is checked against total amount of channels.
+	c.app.config.ClientChannelLimit = 4
+	resp, err = c.subscribeCmd(&subscribeClientCommand{
+		Channels: []subscribeChannelCommand{{Channel: "test3"}, {Channel: "test4"}},
+	})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, ErrLimitExceeded, resp.(*clientBatchSubscribeResponse).err)
+	assert.Equal(t, 3, len(c.channels()))
+
+	// channel repeated in batch subscribed once and counted against limit once.
+	resp, err = c.subscribeCmd(&subscribeClientCommand{
+		Channels: []subscribeChannelCommand{{Channel: "test3"}, {Channel: "test3"}},
+	})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, resp.(*clientBatchSubscribeResponse).err)
+	body = resp.(*clientBatchSubscribeResponse).Body
+	assert.Equal(t, 1, len(body))
+	assert.Equal(t, true, body["test3"].Status)
+	assert.Equal(t, "", body["test3"].Error)
+	assert.Equal(t, 4, len(c.channels()))
+
+	// empty channel name in batch.
+	_, err = c.subscribeCmd(&subscribeClientCommand{
+		Channels: []subscribeChannelCommand{{Channel: ""}},
+	})
+	assert.Equal(t, ErrInvalidMessage, err)
+}
+
+// testSubscribeEngine is an engine which returns subscribe results from provided
+// channels, one channel per subscribe call, and counts unsubscribe calls.
+type testSubscribeEngine struct {
+	*testEngine
+	sync.Mutex
+	subscribeErrs []chan error
+	unsubscribed  int
+}
+
+func (e *testSubscribeEngine) subscribe(ch Channel) <-chan error {
+	e.Lock()
+	defer e.Unlock()
+	errCh := e.subscribeErrs[0]
+	e.subscribeErrs = e.subscribeErrs[1:]
+	return errCh
+}
+
+func (e *testSubscribeEngine) unsubscribe(ch Channel) error {
+	e.Lock()
+	defer e.Unlock()
+	e.unsubscribed++
+	return nil
+}
+
+func (e *testSubscribeEngine) numUnsubscribed() int {
+	e.Lock()
+	defer e.Unlock()
+	return e.unsubscribed
+}
+
+func TestClientSubscribeEngineFailure(t *testing.T) {
+	app := testApp()
+	app.config.SubscribeEngineTimeout = 10 * time.Millisecond
+	results := []chan error{make(chan error, 1), make(chan error, 1), make(chan error, 1)}
+	e := &testSubscribeEngine{testEngine: newTestEngine(), subscribeErrs: results}
+	app.SetEngine(e)
+
+	c, err := newClient(app, &testSession{})
+	assert.Equal(t, nil, err)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	err = c.handleCommands([]clientCommand{testConnectCmd(timestamp)})
+	assert.Equal(t, nil, err)
+
+	// engine returns error.
+	results[0] <- errors.New("engine error")
+	resp, err := c.subscribeCmd(&subscribeClientCommand{Channel: Channel("test")})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, ErrInternalServerError, resp.(*clientSubscribeResponse).err)
+	assert.Equal(t, errorAdviceRetry, resp.(*clientSubscribeResponse).Advice)
+	assert.Equal(t, 0, len(c.channels()))
+	assert.Equal(t, 0, app.clients.numSubscribers(Channel("test")))
+	assert.Equal(t, 0, e.numUnsubscribed())
+
+	// engine does not answer in time.
+	resp, err = c.subscribeCmd(&subscribeClientCommand{Channel: Channel("test")})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, ErrSubscribeTimeout, resp.(*clientSubscribeResponse).err)
+	assert.Equal(t, errorAdviceRetry, resp.(*clientSubscribeResponse).Advice)
+	assert.Equal(t, 0, len(c.channels()))
+	assert.Equal(t, 0, app.clients.numSubscribers(Channel("test")))
+
+	// engine subscribes successfully.
+	results[2] <- nil
+	resp, err = c.subscribeCmd(&subscribeClientCommand{Channel: Channel("test")})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, resp.(*clientSubscribeResponse).err)
+	assert.Equal(t, 1, len(c.channels()))
+	assert.Equal(t, 1, app.clients.numSubscribers(Channel("test")))
+
+	// late success of timed out subscribe must not unsubscribe node from channel
+	// which has subscribers now.
+	results[1] <- nil
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, 0, e.numUnsubscribed())
+}
+
+func TestClientSubscribeEngineLateSuccess(t *testing.T) {
+	app := testApp()
+	app.config.SubscribeEngineTimeout = 10 * time.Millisecond
+	results := []chan error{make(chan error, 1)}
+	e := &testSubscribeEngine{testEngine: newTestEngine(), subscribeErrs: results}
+	app.SetEngine(e)
+
+	c, err := newClient(app, &testSession{})
+	assert.Equal(t, nil, err)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	err = c.handleCommands([]clientCommand{testConnectCmd(timestamp)})
+	assert.Equal(t, nil, err)
+
+	resp, err := c.subscribeCmd(&subscribeClientCommand{Channel: Channel("test")})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, ErrSubscribeTimeout, resp.(*clientSubscribeResponse).err)
+
+	// engine subscribed node after timeout, node unsubscribed as channel has
+	// no subscribers.
+	results[0] <- nil
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, 1, e.numUnsubscribed())
+}
+
+func TestClientSubscribeEnginePending(t *testing.T) {
+	app := testApp()
+	app.config.SubscribeEngineTimeout = 5 * time.Second
+	results := []chan error{make(chan error, 1), make(chan error, 1)}
+	e := &testSubscribeEngine{testEngine: newTestEngine(), subscribeErrs: results}
+	app.SetEngine(e)
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	var clients []*client
+	for i := 0; i < 2; i++ {
+		c, err := newClient(app, &testSession{})
+		assert.Equal(t, nil, err)
+		err = c.handleCommands([]clientCommand{testConnectCmd(timestamp)})
+		assert.Equal(t, nil, err)
+		clients = append(clients, c)
+	}
+
+	// second connection waits for engine subscribe started by first one and
+	// gets the same result.
+	replies := make(chan *clientSubscribeResponse, len(clients))
+	for i, c := range clients {
+		go func(c *client) {
+			resp, _ := c.subscribeCmd(&subscribeClientCommand{Channel: Channel("test")})
+			replies <- resp.(*clientSubscribeResponse)
+		}(c)
+		for j := 0; j < 100 && app.clients.numSubscribers(Channel("test")) < i+1; j++ {
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	assert.Equal(t, 2, app.clients.numSubscribers(Channel("test")))
+	results[0] <- errors.New("engine error")
+	for range clients {
+		select {
+		case resp := <-replies:
+			assert.Equal(t, ErrInternalServerError, resp.err)
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for subscribe")
+		}
+	}
+	assert.Equal(t, 0, app.clients.numSubscribers(Channel("test")))
+
+	// next subscribe starts new engine subscribe.
+	results[1] <- nil
+	resp, err := clients[1].subscribeCmd(&subscribeClientCommand{Channel: Channel("test")})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, resp.(*clientSubscribeResponse).err)
+	assert.Equal(t, 1, app.clients.numSubscribers(Channel("test")))
+	assert.Equal(t, 0, e.numUnsubscribed())
+}
+
 func TestClientUnsubscribe(t *testing.T) {
 	app := testApp()
 	c, err := newClient(app, &testSession{})
@@ -377,7 +982,7 @@ func TestClientUnsubscribe(t *testing.T) {
 	err = c.handleCommands(cmds)
 	assert.Equal(t, nil, err)
 
-	assert.Equal(t, 0, len(app.clients.subs))
+	assert.Equal(t, 0, app.clients.nChannels())
 }
 
 func TestClientUnsubscribeExternal(t *testing.T) {
@@ -390,12 +995,164 @@ func TestClientUnsubscribeExternal(t *testing.T) {
 	err = c.handleCommands(cmds)
 	assert.Equal(t, nil, err)
 
-	err = c.unsubscribe(Channel("test"))
+	err = c.unsubscribe(Channel("test"), false)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, app.clients.nChannels())
+	assert.Equal(t, 0, len(c.channels()))
+}
+
+func TestClientUnsubscribeAll(t *testing.T) {
+	app := testApp()
+	c, err := newClient(app, &testSession{})
+	assert.Equal(t, nil, err)
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	cmds := []clientCommand{testConnectCmd(timestamp), testSubscribeCmd("test1"), testSubscribeCmd("test2")}
+	err = c.handleCommands(cmds)
+	assert.Equal(t, nil, err)
+
+	resp, err := c.handleCmd(clientCommand{Method: "unsubscribe_all"})
+	assert.Equal(t, nil, err)
+	body := resp.(*clientUnsubscribeAllResponse).Body
+	assert.Equal(t, 2, len(body.Channels))
+	assert.True(t, stringInSlice("test1", []string{string(body.Channels[0]), string(body.Channels[1])}))
+	assert.True(t, stringInSlice("test2", []string{string(body.Channels[0]), string(body.Channels[1])}))
+	assert.Equal(t, 0, app.clients.nChannels())
+	assert.Equal(t, 0, len(c.channels()))
+
+	// nothing to unsubscribe from.
+	resp, err = c.handleCmd(clientCommand{Method: "unsubscribe_all"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(resp.(*clientUnsubscribeAllResponse).Body.Channels))
+}
+
+// waitUnsubscribeMessage reads messages sent into session until unsubscribe message
+// with advice received.
+func waitUnsubscribeMessage(t *testing.T, sink chan []byte) unsubscribeBody {
+	for {
+		select {
+		case msg := <-sink:
+			var resp struct {
+				Method string          `json:"method"`
+				Body   unsubscribeBody `json:"body"`
+			}
+			json.Unmarshal(msg, &resp)
+			if resp.Method == "unsubscribe" && resp.Body.Advice != nil {
+				return resp.Body
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for unsubscribe message")
+		}
+	}
+}
+
+func TestClientUnsubscribeAdvice(t *testing.T) {
+	app := testApp()
+	sink := make(chan []byte, 100)
+	c, err := newClient(app, &testSession{sink: sink})
+	assert.Equal(t, nil, err)
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	err = c.handleCommands([]clientCommand{testConnectCmd(timestamp), testSubscribeCmd("test")})
+	assert.Equal(t, nil, err)
+
+	_, err = app.unsubscribeUser(c.User, "test")
+	assert.Equal(t, nil, err)
+	body := waitUnsubscribeMessage(t, sink)
+	assert.Equal(t, Channel("test"), body.Channel)
+	assert.Equal(t, false, body.Advice.Resubscribe)
+}
+
+func TestClientExpireUnsubscribe(t *testing.T) {
+	app := testApp()
+	app.config.ConnLifetime = 10
+	sink := make(chan []byte, 100)
+	c, err := newClient(app, &testSession{sink: sink})
 	assert.Equal(t, nil, err)
-	assert.Equal(t, 0, len(app.clients.subs))
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	err = c.handleCommands([]clientCommand{testConnectCmd(timestamp), testSubscribeCmd("test")})
+	assert.Equal(t, nil, err)
+
+	c.timestamp = time.Now().Unix() - 100
+	c.expire()
+	body := waitUnsubscribeMessage(t, sink)
+	assert.Equal(t, Channel("test"), body.Channel)
+	assert.Equal(t, true, body.Advice.Resubscribe)
 	assert.Equal(t, 0, len(c.channels()))
 }
 
+func TestClientCloseDisconnectMessage(t *testing.T) {
+	app := testApp()
+	sink := make(chan []byte, 100)
+	sess := &testSession{sink: sink}
+	c, err := newClient(app, sess)
+	assert.Equal(t, nil, err)
+
+	err = c.close("shutting down", true)
+	assert.Equal(t, nil, err)
+
+	var resp struct {
+		Method string         `json:"method"`
+		Body   disconnectBody `json:"body"`
+	}
+	err = json.Unmarshal(waitMessage(t, sink), &resp)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "disconnect", resp.Method)
+	assert.Equal(t, "shutting down", resp.Body.Reason)
+	assert.Equal(t, true, resp.Body.Reconnect)
+
+	// session closed after disconnect message sent.
+	<-c.closeChan
+	sess.Lock()
+	assert.True(t, sess.closed)
+	assert.Equal(t, `{"reason":"shutting down","reconnect":true}`, sess.reason)
+	sess.Unlock()
+}
+
+// blockingSession is a session of slow client – Send blocks until release closed.
+type blockingSession struct {
+	testSession
+	release chan struct{}
+}
+
+func (s *blockingSession) Send(msg []byte) error {
+	<-s.release
+	return nil
+}
+
+func TestClientSlowEviction(t *testing.T) {
+	app := testApp()
+	app.config.ClientQueueMaxSize = 100
+	sess := &blockingSession{release: make(chan struct{})}
+	defer close(sess.release)
+	c, err := newClient(app, sess)
+	assert.Equal(t, nil, err)
+
+	msg := []byte(strings.Repeat("x", 30))
+	var i int
+	for ; i < 10; i++ {
+		// send never blocks even if client does not read messages.
+		err = c.send(msg)
+		if err != nil {
+			break
+		}
+	}
+	assert.Equal(t, ErrClientClosed, err)
+	assert.True(t, i <= 5)
+	assert.Equal(t, int64(1), app.metrics.NumClientSlow.LoadRaw())
+}
+
+func TestDisconnectReason(t *testing.T) {
+	assert.Equal(t, `{"reason":"invalid token","reconnect":false}`, disconnectReason(ErrInvalidToken.Error(), shouldReconnect(ErrInvalidToken)))
+	assert.True(t, shouldReconnect(ErrInternalServerError))
+	reason := disconnectReason(strings.Repeat("ы", 100), true)
+	assert.True(t, len(reason) <= maxCloseReasonLength)
+	var body disconnectBody
+	assert.Equal(t, nil, json.Unmarshal([]byte(reason), &body))
+	assert.True(t, body.Reconnect)
+}
+
 func TestClientPresence(t *testing.T) {
 	app := testApp()
 	c, err := newClient(app, &testSession{})
@@ -416,6 +1173,70 @@ func TestClientPresence(t *testing.T) {
 	assert.Equal(t, nil, resp.(*clientPresenceResponse).err)
 }
 
+func TestClientPresenceStats(t *testing.T) {
+	app := testMemoryApp()
+	createTestClients(app, 1, 3, nil)
+
+	c, err := newClient(app, &testSession{})
+	assert.Equal(t, nil, err)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	err = c.handleCommands([]clientCommand{testConnectCmd(timestamp)})
+	assert.Equal(t, nil, err)
+
+	cmd := clientCommand{
+		Method: "presence_stats",
+		Params: []byte(`{"channel": "channel-0"}`),
+	}
+	resp, err := c.handleCmd(cmd)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, ErrPermissionDenied, resp.(*clientPresenceStatsResponse).err)
+
+	_, _ = c.handleCmd(testSubscribeCmd("channel-0"))
+	resp, err = c.handleCmd(cmd)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, resp.(*clientPresenceStatsResponse).err)
+	body := resp.(*clientPresenceStatsResponse).Body
+	assert.Equal(t, 4, body.NumClients)
+	assert.Equal(t, 4, body.NumUsers)
+}
+
+func TestClientAck(t *testing.T) {
+	app := testMemoryApp()
+	app.config.ClientAcks = true
+	c, err := newClient(app, &testSession{})
+	assert.Equal(t, nil, err)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	err = c.handleCommands([]clientCommand{testConnectCmd(timestamp), testSubscribeCmd("test")})
+	assert.Equal(t, nil, err)
+
+	_, err = app.publish(Channel("test"), []byte(`{}`), "", "", nil, false, nil)
+	assert.Equal(t, nil, err)
+	state := c.acks().state(time.Now())
+	assert.Equal(t, 1, state.Outstanding)
+	token := state.Unacked[0].Token
+
+	resp, err := c.handleCmd(clientCommand{
+		Method: "ack",
+		Params: []byte(`{"token": "` + token + `"}`),
+	})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, resp.(*clientAckResponse).Body.Status)
+	assert.Equal(t, 0, c.acks().outstanding())
+	assert.Equal(t, int64(1), app.metrics.NumAcks.LoadRaw())
+
+	// repeated ack of the same token not counted.
+	resp, err = c.handleCmd(clientCommand{
+		Method: "ack",
+		Params: []byte(`{"token": "` + token + `"}`),
+	})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, resp.(*clientAckResponse).Body.Status)
+	assert.Equal(t, int64(1), app.metrics.NumAcks.LoadRaw())
+
+	_, err = c.handleCmd(clientCommand{Method: "ack", Params: []byte(`{}`)})
+	assert.Equal(t, ErrInvalidMessage, err)
+}
+
 func TestClientUpdatePresence(t *testing.T) {
 	app := testApp()
 	c, err := newClient(app, &testSession{})
@@ -466,6 +1287,17 @@ func TestClientPing(t *testing.T) {
 	resp, err := c.handleCmd(testPingCmd())
 	assert.Equal(t, nil, err)
 	assert.Equal(t, nil, resp.(*clientPingResponse).err)
+	assert.Equal(t, "pong", resp.(*clientPingResponse).Body.Data)
+
+	resp, err = c.handleCmd(clientCommand{Method: "ping"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "pong", resp.(*clientPingResponse).Body.Data)
+
+	start := time.Now().UnixNano() / int64(time.Millisecond)
+	resp, err = c.handleCmd(clientCommand{Method: "ping", Params: []byte(`{"data":"ping-1"}`)})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "ping-1", resp.(*clientPingResponse).Body.Data)
+	assert.True(t, resp.(*clientPingResponse).Body.Time >= start)
 }
 
 func testSubscribeRecoverCmd(channel string, last string, rec bool) clientCommand {
@@ -570,4 +1402,522 @@ func TestSubscribeRecover(t *testing.T) {
 	assert.Equal(t, nil, err)
 	assert.Equal(t, 5, len(resp.(*clientSubscribeResponse).Body.Messages))
 	assert.Equal(t, false, resp.(*clientSubscribeResponse).Body.Recovered)
+
+	// test recover with empty last returns last message id
+	messages, _ = app.History(Channel("test"))
+	c, _ = newClient(app, &testSession{})
+	cmds = []clientCommand{testConnectCmd(timestamp)}
+	err = c.handleCommands(cmds)
+	assert.Equal(t, nil, err)
+	subscribeLastCmd = testSubscribeRecoverCmd("test", "", true)
+	resp, err = c.handleCmd(subscribeLastCmd)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 5, len(resp.(*clientSubscribeResponse).Body.Messages))
+	assert.Equal(t, false, resp.(*clientSubscribeResponse).Body.Recovered)
+	assert.Equal(t, MessageID(messages[0].UID), resp.(*clientSubscribeResponse).Body.Last)
+}
+
+// teardownMediator counts disconnect events for every connection.
+type teardownMediator struct {
+	testMediator
+	sync.Mutex
+	disconnects map[ConnID]int
+}
+
+func (m *teardownMediator) Unsubscribe(ch Channel, client ConnID, user UserID) {}
+
+func (m *teardownMediator) Disconnect(client ConnID, user UserID) {
+	m.Lock()
+	defer m.Unlock()
+	m.disconnects[client]++
+}
+
+func TestClientTeardownStress(t *testing.T) {
+	app := testMemoryApp()
+	m := &teardownMediator{disconnects: map[ConnID]int{}}
+	app.SetMediator(m)
+
+	numClients := 2000
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	clients := make([]*client, numClients)
+	for i := 0; i < numClients; i++ {
+		c, err := newClient(app, &testSession{})
+		assert.Equal(t, nil, err)
+		user := UserID(fmt.Sprintf("user-%d", i%100))
+		cmdBytes, _ := json.Marshal(connectClientCommand{
+			Timestamp: timestamp,
+			User:      user,
+			Token:     auth.GenerateClientToken("secret", string(user), timestamp, ""),
+		})
+		cmds := []clientCommand{
+			{Method: "connect", Params: cmdBytes},
+			testSubscribeCmd(fmt.Sprintf("channel-%d", i%10)),
+			testSubscribeCmd("common"),
+		}
+		err = c.handleCommands(cmds)
+		assert.Equal(t, nil, err)
+		clients[i] = c
+	}
+	assert.Equal(t, numClients, app.clients.nClients())
+
+	var wg sync.WaitGroup
+	for i, c := range clients {
+		wg.Add(1)
+		go func(i int, c *client) {
+			defer wg.Done()
+			switch i % 5 {
+			case 0:
+				// transport closed.
+				c.teardown("connection closed")
+			case 1:
+				// server closed connection, transport handler also tears down.
+				c.close("shutting down", true)
+				c.teardown("connection closed")
+			case 2:
+				// queue overflow.
+				c.maxQueueSize = 0
+				c.send([]byte("message"))
+			case 3:
+				c.close("expired", true)
+			case 4:
+				// kick – closes other connections of user too.
+				app.disconnectUser(c.user(), false)
+			}
+		}(i, c)
+	}
+	wg.Wait()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for app.metrics.NumClientDisconnects.LoadRaw() < int64(numClients) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// give duplicate teardowns a chance to run if any.
+	time.Sleep(50 * time.Millisecond)
+
+	assert.Equal(t, int64(numClients), app.metrics.NumClientDisconnects.LoadRaw())
+	assert.Equal(t, 0, app.clients.nConns())
+	assert.Equal(t, 0, app.clients.nUniqueClients())
+	assert.Equal(t, 0, app.clients.nChannels())
+
+	m.Lock()
+	assert.Equal(t, numClients, len(m.disconnects))
+	for _, n := range m.disconnects {
+		assert.Equal(t, 1, n)
+	}
+	m.Unlock()
+
+	// commands of torn down connection do not register it in hub again.
+	err := clients[0].handleCommands([]clientCommand{testSubscribeCmd("channel-0")})
+	assert.Equal(t, ErrClientClosed, err)
+	assert.Equal(t, 0, app.clients.nClients())
+}
+
+// testClientResponses sends commands to client one by one and returns all frames
+// client received decoded to generic JSON values.
+func testClientResponses(t *testing.T, msgpack bool, commands []string) []interface{} {
+	app := testMemoryApp()
+	sink := make(chan []byte, 100)
+	c, err := newClient(app, &testSession{sink: sink})
+	assert.Equal(t, nil, err)
+	c.msgpackFrames = msgpack
+
+	var frames []interface{}
+	for _, command := range commands {
+		msg := []byte(command)
+		if msgpack {
+			msg, err = encode.JSONToMsgpack(msg)
+			assert.Equal(t, nil, err)
+		}
+		assert.Equal(t, nil, c.message(msg), command)
+		frame := waitMessage(t, sink)
+		if msgpack {
+			assert.False(t, isJSONFrame(frame))
+			frame, err = encode.MsgpackToJSON(frame)
+			assert.Equal(t, nil, err, command)
+		}
+		var v interface{}
+		assert.Equal(t, nil, json.Unmarshal(frame, &v), command)
+		frames = append(frames, v)
+	}
+	return frames
+}
+
+func TestClientMsgpackCommands(t *testing.T) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	token := auth.GenerateClientToken("secret", "user1", timestamp, "")
+	commands := []string{
+		`{"method":"connect","params":{"user":"user1","timestamp":"` + timestamp + `","info":"","token":"` + token + `"}}`,
+		`{"method":"refresh","params":{"user":"user1","timestamp":"` + timestamp + `","info":"","token":"` + token + `"}}`,
+		`{"uid":"1","method":"subscribe","params":{"channel":"test"}}`,
+		`{"uid":"2","method":"presence","params":{"channel":"test"}}`,
+		`{"uid":"3","method":"presence_stats","params":{"channel":"test"}}`,
+		`{"uid":"4","method":"history","params":{"channel":"test"}}`,
+		`{"uid":"5","method":"ping","params":{"data":"hello"}}`,
+		`[{"uid":"6","method":"ping","params":{}},{"uid":"7","method":"unsubscribe","params":{"channel":"test"}}]`,
+		`{"uid":"8","method":"publish","params":{"channel":"test","data":{"input":[1,"2",null]}}}`,
+	}
+	jsonFrames := testClientResponses(t, false, commands)
+	msgpackFrames := testClientResponses(t, true, commands)
+	assert.Equal(t, len(jsonFrames), len(msgpackFrames))
+
+	// responses contain connection specific values so compare structure only.
+	var shape func(v interface{}) interface{}
+	shape = func(v interface{}) interface{} {
+		switch value := v.(type) {
+		case map[string]interface{}:
+			result := make(map[string]interface{})
+			for _, k := range []string{"method", "uid", "error"} {
+				result[k] = value[k]
+			}
+			if body, ok := value["body"].(map[string]interface{}); ok {
+				var keys []string
+				for k := range body {
+					keys = append(keys, k)
+				}
+				sort.Strings(keys)
+				result["body"] = keys
+			}
+			return result
+		case []interface{}:
+			result := make([]interface{}, len(value))
+			for i, item := range value {
+				result[i] = shape(item)
+			}
+			return result
+		}
+		return v
+	}
+	for i := range jsonFrames {
+		assert.Equal(t, shape(jsonFrames[i]), shape(msgpackFrames[i]), commands[i])
+	}
+}
+
+func TestClientMsgpackBroadcast(t *testing.T) {
+	app := testMemoryApp()
+	app.config.Insecure = true
+	jsonSink := make(chan []byte, 10)
+	msgpackSink := make(chan []byte, 10)
+	jsonClient, _ := newClient(app, &testSession{sink: jsonSink})
+	msgpackClient, _ := newClient(app, &testSession{sink: msgpackSink})
+	msgpackClient.msgpackFrames = true
+	for _, c := range []*client{jsonClient, msgpackClient} {
+		_, err := c.connectCmd(&connectClientCommand{User: "user"})
+		assert.Equal(t, nil, err)
+		_, err = c.subscribeCmd(&subscribeClientCommand{Channel: "test"})
+		assert.Equal(t, nil, err)
+	}
+
+	_, err := app.publish("test", []byte(`{"input":"hello"}`), "", "", nil, false, nil)
+	assert.Equal(t, nil, err)
+
+	jsonMsg := waitMessage(t, jsonSink)
+	msgpackMsg := waitMessage(t, msgpackSink)
+	assert.True(t, isJSONFrame(jsonMsg))
+	assert.False(t, isJSONFrame(msgpackMsg))
+	decoded, err := encode.MsgpackToJSON(msgpackMsg)
+	assert.Equal(t, nil, err)
+	var expected, got interface{}
+	assert.Equal(t, nil, json.Unmarshal(jsonMsg, &expected))
+	assert.Equal(t, nil, json.Unmarshal(decoded, &got))
+	assert.Equal(t, expected, got)
+}
+
+// waitMethod waits for message with method from sink skipping other messages.
+func waitMethod(t *testing.T, sink chan []byte, method string) []byte {
+	for {
+		msg := waitMessage(t, sink)
+		if strings.Contains(string(msg), `"method":"`+method+`"`) {
+			return msg
+		}
+	}
+}
+
+func TestClientJoinLeave(t *testing.T) {
+	c := newTestConfig()
+	c.ChannelOptions.JoinLeave = true
+	app := testMemoryAppWithConfig(&c)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	sink1 := make(chan []byte, 64)
+	c1, err := newClient(app, &testSession{sink: sink1})
+	assert.Equal(t, nil, err)
+	err = c1.handleCommands([]clientCommand{testConnectCmd(timestamp), testSubscribeCmd("test")})
+	assert.Equal(t, nil, err)
+	// subscribed client receives its own join.
+	waitMethod(t, sink1, "join")
+
+	sink2 := make(chan []byte, 64)
+	c2, err := newClient(app, &testSession{sink: sink2})
+	assert.Equal(t, nil, err)
+	err = c2.handleCommands([]clientCommand{testConnectCmd(timestamp), testSubscribeCmd("test")})
+	assert.Equal(t, nil, err)
+	join := waitMethod(t, sink1, "join")
+	assert.True(t, strings.Contains(string(join), `"client":"`+string(c2.uid())+`"`))
+
+	err = c2.handleCommands([]clientCommand{testUnsubscribeCmd("test")})
+	assert.Equal(t, nil, err)
+	leave := waitMethod(t, sink1, "leave")
+	assert.True(t, strings.Contains(string(leave), `"client":"`+string(c2.uid())+`"`))
+	waitMethod(t, sink2, "unsubscribe")
+
+	// disconnect without unsubscribe sends leave too.
+	err = c2.handleCommands([]clientCommand{testSubscribeCmd("test")})
+	assert.Equal(t, nil, err)
+	waitMethod(t, sink1, "join")
+	err = c2.teardown("test")
+	assert.Equal(t, nil, err)
+	leave = waitMethod(t, sink1, "leave")
+	assert.True(t, strings.Contains(string(leave), `"client":"`+string(c2.uid())+`"`))
+
+	for len(sink2) > 0 {
+		assert.False(t, strings.Contains(string(<-sink2), `"method":"leave"`))
+	}
+}
+
+func TestClientUserConnectionLimit(t *testing.T) {
+	app := testApp()
+	app.config.ClientUserConnectionLimit = 2
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	var clients []*client
+	for i := 0; i < 3; i++ {
+		c, err := newClient(app, &testSession{})
+		assert.Equal(t, nil, err)
+		err = c.handleCommands([]clientCommand{testConnectCmd(timestamp)})
+		assert.Equal(t, nil, err)
+		clients = append(clients, c)
+	}
+	assert.True(t, clients[0].authenticated)
+	assert.True(t, clients[1].authenticated)
+	assert.False(t, clients[2].authenticated)
+	assert.Equal(t, 2, len(app.clients.userConnections("user1")))
+
+	// connection slot freed after disconnect.
+	err := clients[0].teardown("test")
+	assert.Equal(t, nil, err)
+	err = clients[2].handleCommands([]clientCommand{testConnectCmd(timestamp)})
+	assert.Equal(t, nil, err)
+	assert.True(t, clients[2].authenticated)
+	assert.Equal(t, 2, len(app.clients.userConnections("user1")))
+}
+
+func TestClientUserConnectionLimitCloseOldest(t *testing.T) {
+	app := testApp()
+	app.config.ClientUserConnectionLimit = 2
+	app.config.ClientUserConnectionLimitPolicy = connectionLimitCloseOldest
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	var sessions []*testSession
+	for i := 0; i < 3; i++ {
+		sess := &testSession{}
+		c, err := newClient(app, sess)
+		assert.Equal(t, nil, err)
+		c.connectedAt = time.Now().Unix() - int64(10-i)
+		err = c.handleCommands([]clientCommand{testConnectCmd(timestamp)})
+		assert.Equal(t, nil, err)
+		assert.True(t, c.authenticated)
+		sessions = append(sessions, sess)
+	}
+	assert.Equal(t, 2, len(app.clients.userConnections("user1")))
+	// oldest connection closed asynchronously.
+	assert.True(t, waitCondition(func() bool {
+		sessions[0].Lock()
+		defer sessions[0].Unlock()
+		return sessions[0].closed
+	}))
+	sessions[0].Lock()
+	assert.Contains(t, sessions[0].reason, "connection limit exceeded")
+	sessions[0].Unlock()
+	sessions[1].Lock()
+	assert.False(t, sessions[1].closed)
+	sessions[1].Unlock()
+}
+
+func TestClientAnonymousConnectionLimit(t *testing.T) {
+	app := testApp()
+	app.config.ClientAnonymousConnectionLimit = 1
+	assert.Equal(t, nil, app.addConn(newTestUserCC()))
+	assert.Equal(t, nil, app.addConn(&testClientConn{CID: "anon-1"}))
+	assert.Equal(t, ErrLimitExceeded, app.addConn(&testClientConn{CID: "anon-2"}))
+}
+
+func TestClientSubscribeHistory(t *testing.T) {
+	conf := newTestConfig()
+	conf.ChannelOptions.HistorySize = 10
+	conf.ChannelOptions.HistoryLifetime = 60
+	app := testMemoryAppWithConfig(&conf)
+	c, err := newClient(app, &testSession{})
+	assert.Equal(t, nil, err)
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	err = c.handleCommands([]clientCommand{testConnectCmd(timestamp)})
+	assert.Equal(t, nil, err)
+
+	for i := 0; i < 3; i++ {
+		_, err = app.publish("test", []byte(strconv.Itoa(i)), "", "", nil, false, nil)
+		assert.Equal(t, nil, err)
+	}
+
+	resp, err := c.subscribeCmd(&subscribeClientCommand{Channel: "test", History: 2})
+	assert.Equal(t, nil, err)
+	body := resp.(*clientSubscribeResponse).Body
+	assert.Equal(t, 2, len(body.History))
+	assert.Equal(t, "2", string(*body.History[0].Data))
+	assert.Equal(t, "1", string(*body.History[1].Data))
+
+	_, err = app.publish("test2", []byte("0"), "", "", nil, false, nil)
+	assert.Equal(t, nil, err)
+	resp, err = c.subscribeCmd(&subscribeClientCommand{Channels: []subscribeChannelCommand{{Channel: "test2", History: 5}}})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(resp.(*clientBatchSubscribeResponse).Body["test2"].History))
+
+	// history disabled for channel – skipped silently.
+	app.config.ChannelOptions.HistorySize = 0
+	resp, err = c.subscribeCmd(&subscribeClientCommand{Channel: "test3", History: 2})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, resp.(*clientSubscribeResponse).err)
+	assert.Equal(t, 0, len(resp.(*clientSubscribeResponse).Body.History))
+}
+
+func TestClientSubscribeUserLimited(t *testing.T) {
+	app := testApp()
+	c, err := newClient(app, &testSession{})
+	assert.Equal(t, nil, err)
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	err = c.handleCommands([]clientCommand{testConnectCmd(timestamp)})
+	assert.Equal(t, nil, err)
+
+	resp, err := c.subscribeCmd(&subscribeClientCommand{Channel: "dialog#user1,user2"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, resp.(*clientSubscribeResponse).err)
+
+	resp, err = c.subscribeCmd(&subscribeClientCommand{Channel: "dialog#user2,user3"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, ErrPermissionDenied, resp.(*clientSubscribeResponse).err)
+
+	resp, err = c.subscribeCmd(&subscribeClientCommand{Channel: "personal&another-client"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, ErrPermissionDenied, resp.(*clientSubscribeResponse).err)
+
+	resp, err = c.subscribeCmd(&subscribeClientCommand{Channel: Channel("personal&" + string(c.uid()))})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, resp.(*clientSubscribeResponse).err)
+
+	// insecure mode does not check user limited channels.
+	app.config.Insecure = true
+	resp, err = c.subscribeCmd(&subscribeClientCommand{Channel: "dialog#user2,user3"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, resp.(*clientSubscribeResponse).err)
+}
+
+func TestClientServerSubscriptions(t *testing.T) {
+	conf := newTestConfig()
+	conf.ServerSubscriptions = []string{"user#{user}", "test:news", "$private"}
+	app := testMemoryAppWithConfig(&conf)
+	c, err := newClient(app, &testSession{})
+	assert.Equal(t, nil, err)
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	resp, err := c.handleCmd(testConnectCmd(timestamp))
+	assert.Equal(t, nil, err)
+	subs := resp.(*clientConnectResponse).Body.Subs
+	assert.Equal(t, 3, len(subs))
+	assert.Equal(t, true, subs["user#user1"].Status)
+	assert.Equal(t, true, subs["test:news"].Status)
+	assert.Equal(t, ErrPermissionDenied.Error(), subs["$private"].Error)
+	assert.Equal(t, 2, len(c.channels()))
+
+	presence, err := app.Presence("test:news")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(presence))
+
+	// channel limit applies to server subscriptions too.
+	app.config.ClientChannelLimit = 1
+	c, err = newClient(app, &testSession{})
+	assert.Equal(t, nil, err)
+	resp, err = c.handleCmd(testConnectCmd(timestamp))
+	assert.Equal(t, nil, err)
+	subs = resp.(*clientConnectResponse).Body.Subs
+	assert.Equal(t, true, subs["user#user1"].Status)
+	assert.Equal(t, ErrLimitExceeded.Error(), subs["test:news"].Error)
+	assert.Equal(t, 1, len(c.channels()))
+}
+
+func TestClientCommandUID(t *testing.T) {
+	app := testApp()
+	sink := make(chan []byte, 8)
+	c, err := newClient(app, &testSession{sink: sink})
+	assert.Equal(t, nil, err)
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	err = c.handleCommands([]clientCommand{testConnectCmd(timestamp)})
+	assert.Equal(t, nil, err)
+	waitMessage(t, sink)
+
+	sub1 := testSubscribeCmd("channel1")
+	sub1.UID = "1"
+	sub2 := testSubscribeCmd("channel2")
+	sub2.UID = "2"
+	err = c.handleCommands([]clientCommand{sub1, sub2, testSubscribeCmd("channel3")})
+	assert.Equal(t, nil, err)
+
+	var responses []map[string]interface{}
+	err = json.Unmarshal(waitMessage(t, sink), &responses)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, len(responses))
+	assert.Equal(t, "1", responses[0]["uid"])
+	assert.Equal(t, "channel1", responses[0]["body"].(map[string]interface{})["channel"])
+	assert.Equal(t, "2", responses[1]["uid"])
+	assert.Equal(t, "channel2", responses[1]["body"].(map[string]interface{})["channel"])
+	_, ok := responses[2]["uid"]
+	assert.False(t, ok)
+}
+
+func TestClientCommandsErrorInBatch(t *testing.T) {
+	app := testApp()
+	sink := make(chan []byte, 8)
+	c, err := newClient(app, &testSession{sink: sink})
+	assert.Equal(t, nil, err)
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	cmds := []clientCommand{
+		testConnectCmd(timestamp),
+		testSubscribeCmd(""),
+		{Method: "unknown", Params: []byte("{}")},
+		testSubscribeCmd("test"),
+	}
+	err = c.handleCommands(cmds)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(c.channels()))
+
+	var responses []map[string]interface{}
+	err = json.Unmarshal(waitMessage(t, sink), &responses)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 4, len(responses))
+	assert.Equal(t, "connect", responses[0]["method"])
+	assert.Equal(t, ErrInvalidMessage.Error(), responses[1]["error"])
+	assert.Equal(t, ErrMethodNotFound.Error(), responses[2]["error"])
+	assert.Equal(t, "subscribe", responses[3]["method"])
+	_, ok := responses[3]["error"]
+	assert.False(t, ok)
+}
+
+func BenchmarkClientHandleCommands(b *testing.B) {
+	app := testMemoryApp()
+	c, _ := newClient(app, &testSession{})
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	err := c.handleCommands([]clientCommand{testConnectCmd(timestamp)})
+	if err != nil {
+		b.Fatal(err)
+	}
+	cmds := []clientCommand{testPingCmd(), testPingCmd(), testPresenceCmd("test")}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := c.handleCommands(cmds)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
 }
@@ -15,6 +15,9 @@ type (
 	MessageID string
 )
 
+// clientCommand is a command sent by client. Optional UID copied verbatim into
+// response to this command so client can match responses of pipelined commands
+// with the same method, responses without UID have no uid field at all.
 type clientCommand struct {
 	UID    string          `json:"uid"`
 	Method string          `json:"method"`
@@ -30,12 +33,17 @@ type apiCommand struct {
 // connectClientCommand is a command to authorize connection - it contains user ID
 // in web application, additional connection information as JSON string, timestamp
 // with unix seconds on moment when connect parameters generated and HMAC token to
-// prove correctness of all those parameters.
+// prove correctness of all those parameters. Token can also be HS256 JWT signed
+// with project secret with user ID in sub claim, optional info and exp claims – in
+// this case other fields ignored.
 type connectClientCommand struct {
 	User      UserID `json:"user"`
 	Timestamp string `json:"timestamp"`
 	Info      string `json:"info"`
 	Token     string `json:"token"`
+	// Exp is an optional unix timestamp connection credentials expire at. It is
+	// part of token and overrides connection_lifetime for connection.
+	Exp string `json:"exp,omitempty"`
 }
 
 // refreshClientCommand is used to prolong connection lifetime when connection check
@@ -45,18 +53,45 @@ type refreshClientCommand struct {
 	Timestamp string `json:"timestamp"`
 	Info      string `json:"info"`
 	Token     string `json:"token"`
+	Exp       string `json:"exp,omitempty"`
 }
 
 // subscribeClientCommand is used to subscribe on channel.
 // It can only be sent by client after successfull connect.
 // It also can have Client, Info and Sign properties when channel is private.
+// When Channels provided client subscribes on all of them in one command.
 type subscribeClientCommand struct {
+	Channel  Channel                   `json:"channel"`
+	Channels []subscribeChannelCommand `json:"channels"`
+	Client   ConnID                    `json:"client"`
+	Last     MessageID                 `json:"last"`
+	Recover  bool                      `json:"recover"`
+	Info     string                    `json:"info"`
+	Sign     string                    `json:"sign"`
+	// History is a number of last channel history messages to return in
+	// subscribe response.
+	History int `json:"history"`
+}
+
+// subscribeChannelCommand describes one channel in batch subscribe command. It can be
+// sent as channel name string or as object with Info and Sign properties when channel
+// is private and with Last and Recover properties to recover missed messages.
+type subscribeChannelCommand struct {
 	Channel Channel   `json:"channel"`
-	Client  ConnID    `json:"client"`
 	Last    MessageID `json:"last"`
 	Recover bool      `json:"recover"`
 	Info    string    `json:"info"`
 	Sign    string    `json:"sign"`
+	History int       `json:"history"`
+}
+
+// UnmarshalJSON allows to use channel name string instead of object.
+func (cmd *subscribeChannelCommand) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &cmd.Channel)
+	}
+	type plain subscribeChannelCommand
+	return json.Unmarshal(data, (*plain)(cmd))
 }
 
 // unsubscribeClientCommand is used to unsubscribe from channel.
@@ -64,10 +99,22 @@ type unsubscribeClientCommand struct {
 	Channel Channel `json:"channel"`
 }
 
+// presenceStatsClientCommand is used to get short presence summary for channel.
+type presenceStatsClientCommand struct {
+	Channel Channel `json:"channel"`
+}
+
+// ackClientCommand is used to acknowledge message received in channel
+// with client acks enabled.
+type ackClientCommand struct {
+	Token string `json:"token"`
+}
+
 // publishClientCommand is used to publish messages into channel.
 type publishClientCommand struct {
-	Channel Channel         `json:"channel"`
-	Data    json.RawMessage `json:"data"`
+	Channel  Channel         `json:"channel"`
+	Data     json.RawMessage `json:"data"`
+	Encoding string          `json:"encoding"`
 }
 
 // presenceClientCommand is used to get presence (actual channel subscriptions).
@@ -83,14 +130,19 @@ type historyClientCommand struct {
 
 // pingClientCommand is used to ping server.
 type pingClientCommand struct {
+	// Data is an optional string echoed back in ping response.
 	Data string `json:"data"`
 }
 
 // publishApiCommand is used to publish messages into channel.
 type publishAPICommand struct {
-	Channel Channel         `json:"channel"`
-	Client  ConnID          `json:"client"`
-	Data    json.RawMessage `json:"data"`
+	Channel  Channel         `json:"channel"`
+	Client   ConnID          `json:"client"`
+	Data     json.RawMessage `json:"data"`
+	Encoding string          `json:"encoding"`
+	// Async allows to respond before engine confirms publish, message is queued
+	// and response has no message UID.
+	Async bool `json:"async"`
 }
 
 // broadcastApiCommand is used to publish messages into multiple channels.
@@ -98,6 +150,7 @@ type broadcastAPICommand struct {
 	Channels []Channel       `json:"channels"`
 	Data     json.RawMessage `json:"data"`
 	Client   ConnID          `json:"client"`
+	Async    bool            `json:"async"`
 }
 
 // unsubscribeApiCommand is used to unsubscribe user from channel.
@@ -106,11 +159,72 @@ type unsubscribeAPICommand struct {
 	User    UserID  `json:"user"`
 }
 
-// disconnectApiCommand is used to disconnect user.
+// disconnectApiCommand is used to disconnect user. Reconnect is an advice to
+// client whether it should try to reconnect, by default it should not.
 type disconnectAPICommand struct {
+	User      UserID `json:"user"`
+	Reconnect bool   `json:"reconnect"`
+}
+
+// disconnectFilter describes client connections which must be disconnected
+// by disconnect_bulk command. All non empty fields must match connection.
+type disconnectFilter struct {
+	// Transport is a name of transport connection established with.
+	Transport string `json:"transport,omitempty"`
+	// ConnectedBefore is a unix timestamp – only connections established
+	// before this moment match.
+	ConnectedBefore int64 `json:"connected_before,omitempty"`
+	// UserPrefix matches connections of users with ID starting with it.
+	UserPrefix string `json:"user_prefix,omitempty"`
+	// Channel matches connections subscribed on channel.
+	Channel Channel `json:"channel,omitempty"`
+}
+
+// empty returns true if filter has no conditions set.
+func (f disconnectFilter) empty() bool {
+	return f.Transport == "" && f.ConnectedBefore <= 0 && f.UserPrefix == "" && f.Channel == ""
+}
+
+// disconnectBulkAPICommand is used to disconnect all connections matching filter.
+type disconnectBulkAPICommand struct {
+	disconnectFilter
+	DryRun bool `json:"dry_run"`
+}
+
+// deliveryStateAPICommand is used to get state of messages waiting for
+// acknowledgement from user connections.
+type deliveryStateAPICommand struct {
 	User UserID `json:"user"`
 }
 
+// disconnectClientAPICommand is used to close single connection.
+type disconnectClientAPICommand struct {
+	Client    ConnID `json:"client"`
+	Reconnect bool   `json:"reconnect"`
+}
+
+// userConnectionsAPICommand is used to get connections of user across cluster.
+type userConnectionsAPICommand struct {
+	User UserID `json:"user"`
+}
+
+// replayAPICommand is used to republish history of source channel into target
+// channel keeping relative timing of messages scaled by speed.
+type replayAPICommand struct {
+	SourceChannel Channel `json:"source_channel"`
+	TargetChannel Channel `json:"target_channel"`
+	// Speed is a replay speed multiplier, 1.0 if not set.
+	Speed float64 `json:"speed"`
+	// Since is a unix timestamp – only messages published since this
+	// moment replayed, whole history if not set.
+	Since int64 `json:"since"`
+}
+
+// replayCancelAPICommand is used to stop replay into target channel.
+type replayCancelAPICommand struct {
+	TargetChannel Channel `json:"target_channel"`
+}
+
 // presenceApiCommand is used to get presence (actual channel subscriptions)
 // information for channel.
 type presenceAPICommand struct {
@@ -122,6 +236,19 @@ type historyAPICommand struct {
 	Channel Channel `json:"channel"`
 }
 
+// channelInfoAPICommand is used to get various information about channel.
+type channelInfoAPICommand struct {
+	Channel Channel `json:"channel"`
+}
+
+// channelsAPICommand is used to get active channels. Pattern is an optional glob
+// pattern channels must match (e.g. "news:*") and Limit is a max number of channels
+// returned.
+type channelsAPICommand struct {
+	Pattern string `json:"pattern"`
+	Limit   int    `json:"limit"`
+}
+
 // pingControlCommand allows nodes to know about each other - node sends this
 // control command periodically.
 type pingControlCommand struct {
@@ -138,7 +265,56 @@ type unsubscribeControlCommand struct {
 
 // disconnectControlCommand required to disconnect user from all nodes.
 type disconnectControlCommand struct {
-	User UserID `json:"user"`
+	User      UserID `json:"user"`
+	Reconnect bool   `json:"reconnect"`
+}
+
+// disconnectBulkControlCommand required to disconnect connections matching
+// filter from all nodes.
+type disconnectBulkControlCommand struct {
+	Filter disconnectFilter `json:"filter"`
+}
+
+// userConnectionsControlCommand asks other nodes to report connections of user.
+type userConnectionsControlCommand struct {
+	RequestID string `json:"request_id"`
+	User      UserID `json:"user"`
+}
+
+// disconnectClientControlCommand asks other nodes to close connection with
+// provided ConnID.
+type disconnectClientControlCommand struct {
+	RequestID string `json:"request_id"`
+	Client    ConnID `json:"client"`
+	Reconnect bool   `json:"reconnect"`
+}
+
+// channelSubscribersControlCommand asks other nodes to report number of their
+// subscribers in channel.
+type channelSubscribersControlCommand struct {
+	RequestID string  `json:"request_id"`
+	Channel   Channel `json:"channel"`
+}
+
+// userConnectionsReplyControlCommand is an answer of node to user connections
+// request of other node.
+type userConnectionsReplyControlCommand struct {
+	RequestID   string           `json:"request_id"`
+	Connections []userConnection `json:"connections"`
+}
+
+// disconnectClientReplyControlCommand is an answer of node to disconnect client
+// request of other node.
+type disconnectClientReplyControlCommand struct {
+	RequestID string `json:"request_id"`
+	Found     bool   `json:"found"`
+}
+
+// channelSubscribersReplyControlCommand is an answer of node to channel
+// subscribers request of other node.
+type channelSubscribersReplyControlCommand struct {
+	RequestID      string `json:"request_id"`
+	NumSubscribers int    `json:"num_subscribers"`
 }
 
 // connectAdminCommand required to authorize admin connection and provide
@@ -148,6 +324,21 @@ type connectAdminCommand struct {
 	Watch bool   `json:"watch"`
 }
 
+// watchAdminCommand starts watching messages of channel or channels matching
+// glob pattern for Duration seconds, admin_watch_max_duration used when zero.
+type watchAdminCommand struct {
+	Channel  Channel `json:"channel"`
+	Pattern  string  `json:"pattern"`
+	Duration int     `json:"duration"`
+}
+
+// unwatchAdminCommand stops watching channel or pattern, all watches stopped when
+// both are empty.
+type unwatchAdminCommand struct {
+	Channel Channel `json:"channel"`
+	Pattern string  `json:"pattern"`
+}
+
 // pingAdminCommand is used to ping server.
 type pingAdminCommand struct {
 	Data string `json:"data"`
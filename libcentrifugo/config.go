@@ -2,6 +2,7 @@ package libcentrifugo
 
 import (
 	"errors"
+	"path"
 	"regexp"
 	"time"
 )
@@ -31,8 +32,9 @@ type ChannelOptions struct {
 	Presence bool `json:"presence"`
 
 	// JoinLeave turns on(off) join/leave messages for channels. When client subscribes on channel
-	// join message sent to all clients in this channel. When client leaves channel (unsubscribes)
-	// leave message sent.
+	// join message sent to all clients in this channel including subscribed client itself. When
+	// client leaves channel (unsubscribes or disconnects) leave message sent to all other clients
+	// in this channel.
 	JoinLeave bool `mapstructure:"join_leave" json:"join_leave"`
 
 	// HistorySize determines max amount of history messages for channel, 0 means no history for channel.
@@ -54,8 +56,52 @@ type ChannelOptions struct {
 	// least one active subscriber. This can give a huge memory saving, with only minor edgecases that are
 	// different from without it as noted on https://github.com/centrifugal/centrifugo/issues/50.
 	HistoryDropInactive bool `mapstructure:"history_drop_inactive" json:"history_drop_inactive"`
+
+	// BinaryPayloads allows to publish messages with binary data into channels. Such
+	// messages delivered to Websocket clients negotiated binary subprotocol as binary
+	// frames, other clients receive data encoded into base64 string.
+	BinaryPayloads bool `mapstructure:"binary_payloads" json:"binary_payloads"`
+
+	// ClientAcks turns on acknowledgements of messages by clients. Messages in channel
+	// delivered with ack token, client must send it back using ack command. Node keeps
+	// unacked tokens to expose them via delivery_state API, messages never redelivered.
+	ClientAcks bool `mapstructure:"client_acks" json:"client_acks"`
+
+	// ClientCommandsPerSecond limits rate of commands from each client connection
+	// targeting channels of namespace (or commands without channel for top level
+	// options). Connection has one limit for all its commands, options of namespace
+	// applied to commands targeting its channels. Ping commands not limited. Zero
+	// value means no limit.
+	ClientCommandsPerSecond int `mapstructure:"client_commands_per_second" json:"client_commands_per_second"`
+
+	// ClientCommandsBurst is a number of commands client can send at once exceeding
+	// ClientCommandsPerSecond rate. If zero then ClientCommandsPerSecond used.
+	ClientCommandsBurst int `mapstructure:"client_commands_burst" json:"client_commands_burst"`
+
+	// PublishProxy turns on validation of messages clients publish into channels
+	// by publish_proxy_endpoint.
+	PublishProxy bool `mapstructure:"publish_proxy" json:"publish_proxy"`
+
+	// MaxMessageSize is a max size in bytes of message data published into channel.
+	// Limit applies to raw JSON data as sent by publisher (base64 encoded string for
+	// binary payloads). Zero value means no limit.
+	MaxMessageSize int `mapstructure:"max_message_size" json:"max_message_size"`
+
+	// SlowClientPolicy is what happens when connection message queue exceeds
+	// client_queue_max_size: disconnect (default) closes connection, drop_oldest
+	// drops oldest queued publications which is fine for channels where only latest
+	// message matters (tickers). Publications dropped only if every channel connection
+	// subscribed to allows it, connection closed if queue still exceeds limit with
+	// other messages.
+	SlowClientPolicy string `mapstructure:"slow_client_policy" json:"slow_client_policy"`
 }
 
+// Slow client policies.
+const (
+	slowClientPolicyDisconnect = "disconnect"
+	slowClientPolicyDropOldest = "drop_oldest"
+)
+
 // NamespaceKey is a name of namespace unique for project.
 type NamespaceKey string
 
@@ -64,10 +110,57 @@ type Namespace struct {
 	// Name is a unique namespace name.
 	Name NamespaceKey `json:"name"`
 
+	// Pattern is a shell pattern (in path.Match format, ex. "game:*:chat") matched
+	// against full channel name without private channel prefix. Channels not
+	// belonging to configured namespace get options of first namespace in config
+	// order with matching Pattern or Regexp.
+	Pattern string `json:"pattern"`
+
+	// Regexp is a regular expression matched against full channel name, works like
+	// Pattern. Only one of Pattern and Regexp can be set.
+	Regexp string `json:"regexp"`
+
+	// Secret is used to check private channel signs in namespace instead of project
+	// secret. API requests are always signed with project secret.
+	Secret string `json:"-"`
+
 	// ChannelOptions for namespace determine channel options for channels belonging to this namespace.
 	ChannelOptions `mapstructure:",squash"`
 }
 
+// APIKey is an additional key to sign HTTP API requests with. Requests signed with
+// API key must have key name in X-API-Key header and can only call allowed methods.
+type APIKey struct {
+	// Name is a unique API key name.
+	Name string `json:"name"`
+	// Secret is used to sign requests instead of project secret.
+	Secret string `json:"secret"`
+	// Methods is a list of API methods key allowed to call, empty means all methods
+	// except disconnect_bulk which must be listed explicitly.
+	Methods []string `json:"methods"`
+	// Channels is a list of glob patterns of channels key allowed to use in commands,
+	// empty means all channels. Key with channels can not run commands without channel.
+	Channels []string `json:"channels"`
+}
+
+// NamespaceTemplate allows to create namespaces automatically on first use – channels
+// referencing unknown namespace with name matching Pattern get channel options of
+// template Namespace.
+type NamespaceTemplate struct {
+	// Pattern is a shell pattern (in path.Match format, ex. "tenant_*") unknown
+	// namespace name must match to be created from template. Empty pattern disables
+	// dynamic namespaces.
+	Pattern string `json:"pattern"`
+
+	// Namespace is a name of configured namespace which channel options will be
+	// cloned into dynamic namespaces.
+	Namespace NamespaceKey `json:"namespace"`
+
+	// MaxNamespaces is a maximum amount of dynamic namespaces kept by node. When
+	// exceeded least recently used dynamic namespaces evicted.
+	MaxNamespaces int `mapstructure:"max_namespaces" json:"max_namespaces"`
+}
+
 // Config contains Application configuration options.
 type Config struct {
 	// Version is a version of node as string, in most cases this will
@@ -83,7 +176,8 @@ type Config struct {
 
 	// Admin enables admin socket.
 	Admin bool
-	// AdminPassword is an admin password.
+	// AdminPassword is an admin password or its bcrypt hash generated by
+	// centrifugo genpassword command.
 	AdminPassword string `json:"-"`
 	// AdminSecret is a secret to generate auth token for admin socket connection.
 	AdminSecret string `json:"-"`
@@ -117,6 +211,50 @@ type Config struct {
 	// presence info valid after receiving presence ping.
 	PresenceExpireInterval time.Duration `json:"presence_expire_interval"`
 
+	// SubscribeEngineTimeout is an interval node waits for engine to subscribe on
+	// channel before answering client subscribe command with error. Zero value
+	// means waiting without timeout.
+	SubscribeEngineTimeout time.Duration `json:"subscribe_engine_timeout"`
+
+	// AlarmConnectionsWarn and AlarmConnectionsCritical are thresholds of node client
+	// connections number to raise alarm. Zero value disables alarm level.
+	AlarmConnectionsWarn     int64 `json:"alarm_connections_warn"`
+	AlarmConnectionsCritical int64 `json:"alarm_connections_critical"`
+
+	// AlarmQueuedBytesWarn and AlarmQueuedBytesCritical are thresholds of total size
+	// of messages in client queues on node to raise alarm.
+	AlarmQueuedBytesWarn     int64 `json:"alarm_queued_bytes_warn"`
+	AlarmQueuedBytesCritical int64 `json:"alarm_queued_bytes_critical"`
+
+	// AlarmPublishRateWarn and AlarmPublishRateCritical are thresholds of messages
+	// published per second on node to raise alarm.
+	AlarmPublishRateWarn     int64 `json:"alarm_publish_rate_warn"`
+	AlarmPublishRateCritical int64 `json:"alarm_publish_rate_critical"`
+
+	// AlarmHysteresis is a fraction of threshold value must drop below threshold
+	// to clear raised alarm.
+	AlarmHysteresis float64 `json:"alarm_hysteresis"`
+
+	// AlarmInterval is a minimal interval between repeated alarms of the same kind.
+	AlarmInterval time.Duration `json:"alarm_interval"`
+
+	// ClientCommandsMaxViolations is a number of consecutive client commands rejected
+	// because of rate limit after which client disconnected. Zero value means that
+	// client never disconnected for exceeding rate limit.
+	ClientCommandsMaxViolations int `json:"client_commands_max_violations"`
+
+	// ClientAcksWindow is a maximum number of unacked messages kept for each
+	// connection, oldest messages dropped from window when limit reached.
+	ClientAcksWindow int `json:"client_acks_window"`
+
+	// ClientAcksAdviceThreshold is a number of unacked messages after reaching which
+	// client receives ack_advice message. Zero value disables advice.
+	ClientAcksAdviceThreshold int `json:"client_acks_advice_threshold"`
+
+	// BulkDisconnectRate is a maximum number of connections closed per second
+	// by disconnect_bulk command on every node. Zero value means no limit.
+	BulkDisconnectRate int `json:"bulk_disconnect_rate"`
+
 	// ExpiredConnectionCloseDelay is an interval given to client to
 	// refresh its connection in the end of connection lifetime.
 	ExpiredConnectionCloseDelay time.Duration `json:"expired_connection_close_delay"`
@@ -127,13 +265,27 @@ type Config struct {
 
 	// MessageSendTimeout is an interval how long time the node
 	// may take to send a message to a client before disconnecting the client.
+	// For raw Websocket connections it is applied as write deadline.
 	MessageSendTimeout time.Duration `json:"message_send_timeout"`
 
+	// ShutdownTimeout is an interval in seconds during which client connections
+	// closed in randomized batches on shutdown so clients do not reconnect to
+	// other nodes all at once. Zero value means closing all connections at once.
+	ShutdownTimeout time.Duration `json:"shutdown_timeout"`
+
 	// ClientRequestMaxSize sets maximum size in bytes of allowed client request.
 	ClientRequestMaxSize int `json:"client_request_max_size"`
+	// ClientRequestMaxCommands sets maximum number of commands in one client request.
+	// Zero value means no limit.
+	ClientRequestMaxCommands int `json:"client_request_max_commands"`
 	// ClientQueueMaxSize is a maximum size of client's message queue in bytes.
 	// After this queue size exceeded Centrifugo closes client's connection.
 	ClientQueueMaxSize int `json:"client_queue_max_size"`
+	// ClientQueueMaxSizeSoft allows client queue to exceed ClientQueueMaxSize by this
+	// percentage during LimitGracePeriod. Client receives limit advice message when queue
+	// size reaches 80% of ClientQueueMaxSize. Zero value means that connection closed as
+	// soon as queue size exceeds ClientQueueMaxSize.
+	ClientQueueMaxSizeSoft int `json:"client_queue_max_size_soft"`
 	// ClientQueueInitialCapacity sets initial amount of slots in client message
 	// queue. When these slots are full client queue is automatically resized to
 	// a bigger size. This option can reduce amount of allocations when message
@@ -144,6 +296,156 @@ type Config struct {
 	// ClientChannelLimit sets upper limit of channels each client can subscribe to.
 	ClientChannelLimit int `json:"client_channel_limit"`
 
+	// MaxClients sets upper limit of client connections on node. New Websocket,
+	// SockJS and SSE connections rejected when limit reached. Zero value means no
+	// limit.
+	MaxClients int `json:"max_clients"`
+
+	// ClientUserConnectionLimit sets upper limit of connections each user can have
+	// on node. Connect command of user reached limit rejected. Anonymous connections
+	// not limited. Zero value means no limit.
+	ClientUserConnectionLimit int `json:"client_user_connection_limit"`
+
+	// ClientUserConnectionLimitPolicy sets what happens when user reached connection
+	// limit: "reject" (default) rejects new connection, "close_oldest" accepts it and
+	// closes oldest connections of user.
+	ClientUserConnectionLimitPolicy string `json:"client_user_connection_limit_policy"`
+
+	// ClientAnonymousConnectionLimit sets upper limit of anonymous connections on
+	// node, connection limit policy applied to them too. Zero value means no limit.
+	ClientAnonymousConnectionLimit int `json:"client_anonymous_connection_limit"`
+
+	// ClientChannelLimitSoft allows client to exceed ClientChannelLimit by this percentage
+	// during LimitGracePeriod. Client receives limit advice message when it exceeds
+	// ClientChannelLimit and closed if it is still subscribed on more channels when
+	// grace period ends. Zero value means no soft limit.
+	ClientChannelLimitSoft int `json:"client_channel_limit_soft"`
+
+	// LimitGracePeriod is a time client can stay above hard limit when soft limits enabled.
+	LimitGracePeriod time.Duration `json:"limit_grace_period"`
+
+	// PrivateSignCacheTTL is a time successful private channel sign verification cached
+	// for connection. Zero value disables cache.
+	PrivateSignCacheTTL time.Duration `json:"private_sign_cache_ttl"`
+
+	// PrivateSignCacheSize is a max number of cached private channel sign verifications.
+	PrivateSignCacheSize int `json:"private_sign_cache_size"`
+
+	// PresenceCacheTTL is a time channel presence loaded from engine cached on node.
+	// Local joins and leaves drop cached presence at once, changes on other nodes
+	// visible after TTL. Zero value disables cache.
+	PresenceCacheTTL time.Duration `json:"presence_cache_ttl"`
+
+	// PresenceCacheSize is a max number of channels with cached presence.
+	PresenceCacheSize int `json:"presence_cache_size"`
+
+	// WebsocketCompression enables permessage-deflate compression for raw Websocket
+	// connections if client supports it.
+	WebsocketCompression bool `json:"websocket_compression"`
+
+	// WebsocketCompressionMinSize is a min size of message in bytes to compress.
+	// Smaller messages sent uncompressed.
+	WebsocketCompressionMinSize int `json:"websocket_compression_min_size"`
+
+	// ServerSubscriptions is a list of channels node subscribes every connection to
+	// right after successful connect. {user} placeholder in channel is replaced with
+	// user ID of connection, channels with placeholder skipped for anonymous users.
+	ServerSubscriptions []string `json:"server_subscriptions"`
+
+	// ConnectionLogFile is a path to file client connect and disconnect events
+	// appended to as JSON lines. Empty value disables connection log. Changing it
+	// requires restart.
+	ConnectionLogFile string `json:"connection_log_file"`
+
+	// ConnectionLogMaxSize is a size of connection log file in bytes after which
+	// file rotated. Zero value means no rotation.
+	ConnectionLogMaxSize int64 `json:"connection_log_max_size"`
+
+	// ConnectionLogMaxBackups is a number of rotated connection log files kept.
+	ConnectionLogMaxBackups int `json:"connection_log_max_backups"`
+
+	// StatsdAddr is an address (host:port) of statsd or DogStatsD agent node metrics
+	// sent to over UDP every node_metrics_interval. Empty disables export. Changing
+	// it requires restart.
+	StatsdAddr string `json:"statsd_addr"`
+
+	// StatsdPrefix is a prefix of exported metric names.
+	StatsdPrefix string `json:"statsd_prefix"`
+
+	// StatsdFormat is a format of exported metrics – statsd or dogstatsd. In statsd
+	// format node name is a part of metric name, in dogstatsd it is sent as tag.
+	StatsdFormat string `json:"statsd_format"`
+
+	// StatsdTags is a list of extra tags (like "env:prod") sent with metrics in
+	// dogstatsd format.
+	StatsdTags []string `json:"statsd_tags"`
+
+	// TracingEndpoint is an URL of OpenTelemetry collector OTLP/HTTP traces receiver
+	// (like http://localhost:4318/v1/traces) spans of HTTP API requests and message
+	// publishing exported to. Empty disables tracing. Changing it requires restart.
+	TracingEndpoint string `json:"tracing_endpoint"`
+
+	// TracingProtocol is a protocol of span export – http/protobuf or http/json.
+	TracingProtocol string `json:"tracing_protocol"`
+
+	// TracingSampleRate is a share of API requests traced when request does not
+	// continue trace of caller (has no traceparent header), from 0 to 1.
+	TracingSampleRate float64 `json:"tracing_sample_rate"`
+
+	// KafkaBrokers is a list of Kafka broker addresses (host:port) Kafka bridge
+	// connects to. Empty disables bridge. Changing Kafka options requires restart.
+	KafkaBrokers []string `json:"kafka_brokers"`
+
+	// KafkaTopics is a list of Kafka topics bridge consumes. Every record must be
+	// JSON object with channel and data fields, record key used as channel when
+	// channel field not set.
+	KafkaTopics []string `json:"kafka_topics"`
+
+	// KafkaConsumerGroup is a Kafka consumer group of bridge, nodes of cluster share
+	// topic partitions within group.
+	KafkaConsumerGroup string `json:"kafka_consumer_group"`
+
+	// KafkaCommitInterval is an interval offsets of published records committed to
+	// Kafka. Zero value means offset committed after every published record.
+	KafkaCommitInterval time.Duration `json:"kafka_commit_interval"`
+
+	// KafkaErrorPolicy is what bridge does when record can not be published because
+	// of engine error – skip moves to next record, retry publishes record again
+	// until it succeeds. Malformed records and records rejected by channel options
+	// are always skipped.
+	KafkaErrorPolicy string `json:"kafka_error_policy"`
+
+	// AuditLog enables audit log of API commands changing state of clients or
+	// channels sent via HTTP API, Redis API and admin connections. Changing it
+	// requires restart.
+	AuditLog bool `json:"audit_log"`
+
+	// AuditLogFile is a path to file audit entries appended to as JSON lines, when
+	// empty entries written into main log with AUDIT marker.
+	AuditLogFile string `json:"audit_log_file"`
+
+	// AuditLogMaxSize is a size of audit log file in bytes after which file rotated.
+	// Zero value means no rotation.
+	AuditLogMaxSize int64 `json:"audit_log_max_size"`
+
+	// AuditLogMaxBackups is a number of rotated audit log files kept.
+	AuditLogMaxBackups int `json:"audit_log_max_backups"`
+
+	// AuditLogPublish adds publish and broadcast commands to audit log.
+	AuditLogPublish bool `json:"audit_log_publish"`
+
+	// SubscriptionChurnLimit is a max number of subscribes and unsubscribes connection
+	// can make during SubscriptionChurnWindow. Subscribe commands above limit rejected
+	// until churn goes down. Zero value means no limit.
+	SubscriptionChurnLimit int `json:"subscription_churn_limit"`
+
+	// SubscriptionChurnWindow is a rolling window subscription churn counted over.
+	SubscriptionChurnWindow time.Duration `json:"subscription_churn_window"`
+
+	// DiagMaxSize is a max size of diagnostic bundle in bytes. Goroutine stacks and
+	// top channels trimmed from bundle until it fits.
+	DiagMaxSize int `json:"diag_max_size"`
+
 	// PrivateChannelPrefix is a prefix in channel name which indicates that
 	// channel is private.
 	PrivateChannelPrefix string `json:"private_channel_prefix"`
@@ -160,6 +462,50 @@ type Config struct {
 	// that channel.
 	ClientChannelBoundary string `json:"client_channel_separator"`
 
+	// ConnectProxyEndpoint is an URL connect commands sent to for authentication
+	// instead of checking connection token. Response of endpoint defines user ID,
+	// info and time to live of connection or rejects connection. Refresh commands of
+	// connections authenticated this way sent to endpoint too with refresh flag set.
+	ConnectProxyEndpoint string `json:"connect_proxy_endpoint"`
+	// ConnectProxyTimeout is a timeout of HTTP request to connect proxy endpoint.
+	ConnectProxyTimeout time.Duration `json:"connect_proxy_timeout"`
+	// ConnectProxyRetries is a number of retries when connect proxy endpoint is not
+	// available or responded with server error.
+	ConnectProxyRetries int `json:"connect_proxy_retries"`
+	// ConnectProxyFailOpen allows anonymous connections when connect proxy endpoint
+	// is not available, by default such connections rejected.
+	ConnectProxyFailOpen bool `json:"connect_proxy_fail_open"`
+	// ConnectProxyHeaders are headers of HTTP request established connection
+	// forwarded to connect proxy endpoint.
+	ConnectProxyHeaders []string `json:"connect_proxy_headers"`
+
+	// SubscribeProxyEndpoint is an URL asked whether client can subscribe on private
+	// channel instead of checking channel sign.
+	SubscribeProxyEndpoint string `json:"subscribe_proxy_endpoint"`
+	// SubscribeProxyTimeout is a timeout of HTTP request to subscribe proxy endpoint.
+	SubscribeProxyTimeout time.Duration `json:"subscribe_proxy_timeout"`
+	// SubscribeProxyRetries is a number of retries when subscribe proxy endpoint is
+	// not available or responded with server error.
+	SubscribeProxyRetries int `json:"subscribe_proxy_retries"`
+	// SubscribeProxyCacheTTL is an interval in seconds subscribe proxy replies cached
+	// for per user and channel. Zero value disables cache. Replies for anonymous
+	// connections are not cached.
+	SubscribeProxyCacheTTL time.Duration `json:"subscribe_proxy_cache_ttl"`
+	// SubscribeProxyCacheSize is a max number of cached subscribe proxy replies.
+	SubscribeProxyCacheSize int `json:"subscribe_proxy_cache_size"`
+
+	// PublishProxyEndpoint is an URL messages clients publish into channels with
+	// publish_proxy option sent to. Backend can reject message or replace its data.
+	PublishProxyEndpoint string `json:"publish_proxy_endpoint"`
+	// PublishProxyTimeout is a timeout of HTTP request to publish proxy endpoint.
+	PublishProxyTimeout time.Duration `json:"publish_proxy_timeout"`
+	// PublishProxyRetries is a number of retries when publish proxy endpoint is not
+	// available or responded with server error.
+	PublishProxyRetries int `json:"publish_proxy_retries"`
+	// PublishProxyPassThrough allows to publish messages as is when publish proxy
+	// endpoint is not available, by default such messages rejected.
+	PublishProxyPassThrough bool `json:"publish_proxy_pass_through"`
+
 	// Insecure turns on insecure mode - when it's turned on then no authentication
 	// required at all when connecting to Centrifugo, anonymous access and publish
 	// allowed for all channels, no connection check performed. This can be suitable
@@ -170,14 +516,88 @@ type Config struct {
 	// to sign every request - for example if you closed API endpoint with firewall
 	// or you want to play with API commands from command line using CURL.
 	InsecureAPI bool `json:"insecure_api"`
+	// AllowedOrigins is a list of origins (like "https://example.com") browsers allowed
+	// to connect to client endpoints from, wildcard subdomains like
+	// "https://*.example.com" supported. Empty list allows any origin, "*" allows
+	// any origin too but logged as a warning as it is likely a mistake.
+	AllowedOrigins []string `json:"allowed_origins"`
+	// APIAllowedOrigins is a list of origins browsers allowed to call HTTP API from,
+	// AllowedOrigins used when empty. CORS headers set for allowed origins only.
+	APIAllowedOrigins []string `json:"api_allowed_origins"`
+	// APIKeys are additional keys with restricted permissions to sign HTTP API requests.
+	APIKeys []APIKey `json:"api_keys"`
+	// APIRequestsPerSecond limits rate of HTTP API requests from every API key or,
+	// for requests signed with project secret, from every remote address. Zero means
+	// no limit. Requests exceeding limit get 429 Too Many Requests response.
+	APIRequestsPerSecond int `json:"api_requests_per_second"`
+	// APIRequestsBurst is a number of API requests which can be sent at once exceeding
+	// APIRequestsPerSecond rate. If zero then APIRequestsPerSecond used.
+	APIRequestsBurst int `json:"api_requests_burst"`
+	// APIAsyncQueueSize is a max number of publications from API commands with async
+	// flag waiting to be passed to engine. When queue is full API responds with 503
+	// Service Unavailable status code. Size is not changed on configuration reload.
+	APIAsyncQueueSize int `json:"api_async_queue_size"`
+	// APILegacyFormEnabled allows HTTP API requests encoded as application/x-www-form-urlencoded
+	// with data and sign fields as sent by older API clients. This format is deprecated in favour
+	// of raw JSON body with X-API-Sign header – when disabled such requests rejected with 415
+	// Unsupported Media Type status code.
+	APILegacyFormEnabled bool `json:"api_legacy_form_enabled"`
+	// APILegacySignEnabled allows HTTP API requests signed over body only. When disabled
+	// every request must have X-API-Timestamp and X-API-Nonce headers covered by sign so
+	// captured requests can not be replayed.
+	APILegacySignEnabled bool `json:"api_legacy_sign_enabled"`
+	// APISignWindow is a max difference between timestamp of signed API request and
+	// server time, nonces of requests are remembered during this window.
+	APISignWindow time.Duration `json:"api_sign_window"`
 	// InsecureAdmin turns on insecure mode for admin endpoints - no auth required to
 	// connect to admin socket and web interface. Protect admin resources with firewall
 	// rules in production when enabling this option.
 	InsecureAdmin bool `json:"insecure_admin"`
+	// AdminActionsEnabled allows admin connections to run API commands changing
+	// state of clients and channels: publish, broadcast, disconnect_user (disconnect),
+	// unsubscribe_user (unsubscribe) etc. Commands returning information always
+	// allowed.
+	AdminActionsEnabled bool `json:"admin_actions_enabled"`
+	// AdminWatchMaxDuration is a max duration of channel watch started by admin
+	// connection, watch stops automatically after it. Zero means no limit for
+	// duration requested in command, 5 minutes used when command has no duration.
+	AdminWatchMaxDuration time.Duration `json:"admin_watch_max_duration"`
+	// AdminWatchRate is a max number of messages per second forwarded to admin
+	// connection from watched channels, excess messages dropped. Zero means no limit.
+	AdminWatchRate int `json:"admin_watch_rate"`
+	// AdminAuthTTL is a lifetime of admin tokens, admin web interface renews token
+	// using refresh command of admin connection. Zero means tokens never expire.
+	AdminAuthTTL time.Duration `json:"admin_auth_ttl"`
+	// AdminAuthLegacyEnabled allows admin tokens generated by older versions
+	// without expiration time.
+	AdminAuthLegacyEnabled bool `json:"admin_auth_legacy_enabled"`
+	// AdminAuthMaxAttempts is a number of failed admin login attempts from remote
+	// address after which address locked out. Zero disables lockout.
+	AdminAuthMaxAttempts int `json:"admin_auth_max_attempts"`
+	// AdminAuthLockout is a lockout time after AdminAuthMaxAttempts failed attempts,
+	// it doubles on every next failed attempt up to AdminAuthLockoutMax.
+	AdminAuthLockout time.Duration `json:"admin_auth_lockout"`
+	// AdminAuthLockoutMax is a max lockout time of remote address.
+	AdminAuthLockoutMax time.Duration `json:"admin_auth_lockout_max"`
+	// APIAllowedIPs is a list of addresses or CIDR networks (like "10.0.0.0/8")
+	// allowed to call HTTP API. Empty list allows any address.
+	APIAllowedIPs []string `json:"api_allowed_ips"`
+	// AdminAllowedIPs is a list of addresses or CIDR networks allowed to use admin
+	// socket and web interface. Empty list allows any address.
+	AdminAllowedIPs []string `json:"admin_allowed_ips"`
+	// TrustedProxies is a list of addresses or CIDR networks of reverse proxies.
+	// Address of client checked against allowed IPs is taken from X-Forwarded-For
+	// header only when request came from trusted proxy.
+	TrustedProxies []string `json:"trusted_proxies"`
 
 	// Secret is a secret key, used to sign API requests and client connection tokens.
 	Secret string `json:"secret"`
 
+	// SecretPrevious is an old secret still accepted when checking client connection
+	// tokens and private channel signs while secret rotated. Everything generated by
+	// server signed with Secret.
+	SecretPrevious string `json:"secret_previous"`
+
 	// ConnLifetime determines time until connection expire, 0 means no connection expire at all.
 	ConnLifetime int64 `json:"connection_lifetime"`
 
@@ -186,6 +606,9 @@ type Config struct {
 
 	// Namespaces - list of namespaces for custom channel options.
 	Namespaces []Namespace `json:"namespaces"`
+
+	// NamespaceTemplate configures automatic namespace creation on first use.
+	NamespaceTemplate NamespaceTemplate `json:"namespace_template"`
 }
 
 func stringInSlice(a string, list []string) bool {
@@ -215,9 +638,156 @@ func (c *Config) Validate() error {
 		nss = append(nss, name)
 	}
 
+	var keys []string
+	for _, k := range c.APIKeys {
+		if k.Name == "" || k.Secret == "" {
+			return errors.New(errPrefix + "API key must have name and secret")
+		}
+		if stringInSlice(k.Name, keys) {
+			return errors.New(errPrefix + "API key name must be unique")
+		}
+		keys = append(keys, k.Name)
+	}
+
+	if _, err := c.namespaceMatchers(); err != nil {
+		return errors.New(errPrefix + err.Error())
+	}
+
+	if _, err := c.ipFilter(); err != nil {
+		return errors.New(errPrefix + err.Error())
+	}
+
+	if c.NamespaceTemplate.Pattern != "" {
+		if _, err := path.Match(c.NamespaceTemplate.Pattern, ""); err != nil {
+			return errors.New(errPrefix + "wrong namespace template pattern – " + c.NamespaceTemplate.Pattern)
+		}
+		if !stringInSlice(string(c.NamespaceTemplate.Namespace), nss) {
+			return errors.New(errPrefix + "namespace template refers to unknown namespace – " + string(c.NamespaceTemplate.Namespace))
+		}
+		if c.NamespaceTemplate.MaxNamespaces <= 0 {
+			return errors.New(errPrefix + "namespace template max_namespaces must be positive")
+		}
+	}
+
+	switch c.ClientUserConnectionLimitPolicy {
+	case "", connectionLimitReject, connectionLimitCloseOldest:
+	default:
+		return errors.New(errPrefix + "unknown client_user_connection_limit_policy – " + c.ClientUserConnectionLimitPolicy)
+	}
+
+	if err := validateSlowClientPolicy(c.ChannelOptions.SlowClientPolicy); err != nil {
+		return errors.New(errPrefix + err.Error())
+	}
+	for _, n := range c.Namespaces {
+		if err := validateSlowClientPolicy(n.ChannelOptions.SlowClientPolicy); err != nil {
+			return errors.New(errPrefix + "namespace " + string(n.Name) + ": " + err.Error())
+		}
+	}
+
+	if c.APIAsyncQueueSize < 0 {
+		return errors.New(errPrefix + "api_async_queue_size must not be negative")
+	}
+
+	if c.AdminAuthMaxAttempts > 0 && (c.AdminAuthLockout <= 0 || c.AdminAuthLockoutMax <= 0) {
+		return errors.New(errPrefix + "admin_auth_lockout and admin_auth_lockout_max must be positive when admin_auth_max_attempts set")
+	}
+
+	switch c.StatsdFormat {
+	case "", statsdFormatStatsd, statsdFormatDogStatsd:
+	default:
+		return errors.New(errPrefix + "unknown statsd_format – " + c.StatsdFormat)
+	}
+
+	if err := validateTracingProtocol(c.TracingProtocol); err != nil {
+		return errors.New(errPrefix + err.Error())
+	}
+	if c.TracingSampleRate < 0 || c.TracingSampleRate > 1 {
+		return errors.New(errPrefix + "tracing_sample_rate must be in range [0, 1]")
+	}
+
+	if len(c.KafkaBrokers) > 0 {
+		if len(c.KafkaTopics) == 0 {
+			return errors.New(errPrefix + "kafka_topics required when kafka_brokers set")
+		}
+		if c.KafkaConsumerGroup == "" {
+			return errors.New(errPrefix + "kafka_consumer_group required when kafka_brokers set")
+		}
+	}
+	switch c.KafkaErrorPolicy {
+	case "", kafkaErrorPolicySkip, kafkaErrorPolicyRetry:
+	default:
+		return errors.New(errPrefix + "unknown kafka_error_policy – " + c.KafkaErrorPolicy)
+	}
+
+	if c.AlarmHysteresis < 0 || c.AlarmHysteresis >= 1 {
+		return errors.New(errPrefix + "alarm_hysteresis must be in range [0, 1)")
+	}
+
 	return nil
 }
 
+func validateSlowClientPolicy(policy string) error {
+	switch policy {
+	case "", slowClientPolicyDisconnect, slowClientPolicyDropOldest:
+		return nil
+	}
+	return errors.New("unknown slow_client_policy – " + policy)
+}
+
+// Lint checks option combinations which pass Validate but most probably are
+// mistakes. Errors are combinations where feature can not work at all (for example
+// recover without history), warnings are options without effect.
+func (c *Config) Lint() (errs []string, warnings []string) {
+	lintOpts := func(prefix string, opts ChannelOptions) {
+		if opts.Recover && (opts.HistorySize <= 0 || opts.HistoryLifetime <= 0) {
+			errs = append(errs, prefix+"recover requires history_size and history_lifetime")
+		}
+		if opts.HistoryDropInactive && (opts.HistorySize <= 0 || opts.HistoryLifetime <= 0) {
+			warnings = append(warnings, prefix+"history_drop_inactive has no effect without history")
+		}
+	}
+	lintOpts("", c.ChannelOptions)
+	for _, n := range c.Namespaces {
+		lintOpts("namespace "+string(n.Name)+": ", n.ChannelOptions)
+	}
+
+	if c.PresenceExpireInterval <= c.PresencePingInterval {
+		errs = append(errs, "presence_expire_interval must be greater than presence_ping_interval")
+	}
+	if c.SecretPrevious != "" && c.SecretPrevious == c.Secret {
+		warnings = append(warnings, "secret_previous is the same as secret")
+	}
+	for _, n := range c.Namespaces {
+		if n.Secret != "" && n.Secret == c.Secret {
+			warnings = append(warnings, "namespace "+string(n.Name)+": secret is the same as project secret")
+		}
+	}
+	if c.ConnLifetime > 0 && time.Duration(c.ConnLifetime)*time.Second < c.ExpiredConnectionCloseDelay {
+		warnings = append(warnings, "connection_lifetime is shorter than expired_connection_close_delay")
+	}
+	return errs, warnings
+}
+
+// tokenSecrets returns secrets client connection tokens can be signed with, current
+// secret goes first.
+func (c *Config) tokenSecrets() []string {
+	if c.SecretPrevious == "" || c.SecretPrevious == c.Secret {
+		return []string{c.Secret}
+	}
+	return []string{c.Secret, c.SecretPrevious}
+}
+
+// namespaceSecret returns secret of namespace with specified key, empty string if
+// namespace not found or has no own secret.
+func (c *Config) namespaceSecret(nk NamespaceKey) string {
+	for _, n := range c.Namespaces {
+		if n.Name == nk {
+			return n.Secret
+		}
+	}
+	return ""
+}
+
 // channelOpts searches for channel options for specified namespace key.
 func (c *Config) channelOpts(nk NamespaceKey) (ChannelOptions, error) {
 	if nk == NamespaceKey("") {
@@ -231,6 +801,49 @@ func (c *Config) channelOpts(nk NamespaceKey) (ChannelOptions, error) {
 	return ChannelOptions{}, ErrNamespaceNotFound
 }
 
+// namespaceMatcher matches channel names against pattern of namespace.
+type namespaceMatcher struct {
+	name    NamespaceKey
+	opts    ChannelOptions
+	pattern string
+	re      *regexp.Regexp
+}
+
+func (m namespaceMatcher) match(ch string) bool {
+	if m.re != nil {
+		return m.re.MatchString(ch)
+	}
+	match, _ := path.Match(m.pattern, ch)
+	return match
+}
+
+// namespaceMatchers compiles patterns of namespaces in config order.
+func (c *Config) namespaceMatchers() ([]namespaceMatcher, error) {
+	var matchers []namespaceMatcher
+	for _, n := range c.Namespaces {
+		if n.Pattern == "" && n.Regexp == "" {
+			continue
+		}
+		if n.Pattern != "" && n.Regexp != "" {
+			return nil, errors.New("namespace " + string(n.Name) + " must have only one of pattern and regexp")
+		}
+		m := namespaceMatcher{name: n.Name, opts: n.ChannelOptions, pattern: n.Pattern}
+		if n.Pattern != "" {
+			if _, err := path.Match(n.Pattern, ""); err != nil {
+				return nil, errors.New("wrong pattern of namespace " + string(n.Name) + " – " + n.Pattern)
+			}
+		} else {
+			re, err := regexp.Compile(n.Regexp)
+			if err != nil {
+				return nil, errors.New("wrong regexp of namespace " + string(n.Name) + " – " + err.Error())
+			}
+			m.re = re
+		}
+		matchers = append(matchers, m)
+	}
+	return matchers, nil
+}
+
 const (
 	defaultName             = "libcentrifugo"
 	defaultChannelPrefix    = "libcentrifugo"
@@ -254,6 +867,21 @@ var DefaultConfig = &Config{
 	PresencePingInterval:        25 * time.Second,
 	PresenceExpireInterval:      60 * time.Second,
 	MessageSendTimeout:          0,
+	ShutdownTimeout:             10 * time.Second,
+	ConnectProxyTimeout:         time.Second,
+	ConnectProxyRetries:         1,
+	ConnectProxyHeaders:         []string{"Cookie", "Authorization"},
+	SubscribeProxyTimeout:       time.Second,
+	SubscribeProxyRetries:       1,
+	SubscribeProxyCacheSize:     10000,
+	PublishProxyTimeout:         time.Second,
+	PublishProxyRetries:         1,
+	SubscribeEngineTimeout:      5 * time.Second,
+	BulkDisconnectRate:          100,
+	ClientAcksWindow:            100,
+	ClientCommandsMaxViolations: 10,
+	AlarmHysteresis:             0.1,
+	AlarmInterval:               60 * time.Second,
 	PrivateChannelPrefix:        "$", // so private channel will look like "$gossips"
 	NamespaceChannelBoundary:    ":", // so namespace "public" can be used "public:news"
 	ClientChannelBoundary:       "&", // so client channel is sth like "client&7a37e561-c720-4608-52a8-a964a9db7a8a"
@@ -264,6 +892,39 @@ var DefaultConfig = &Config{
 	ClientRequestMaxSize:        65536,    // 64KB by default
 	ClientQueueMaxSize:          10485760, // 10MB by default
 	ClientQueueInitialCapacity:  2,
+	ClientRequestMaxCommands:    1000,
 	ClientChannelLimit:          100,
+	LimitGracePeriod:            30 * time.Second,
+	DiagMaxSize:                 1048576, // 1MB by default
+	SubscriptionChurnWindow:     10 * time.Second,
+	ConnectionLogMaxSize:        104857600, // 100MB by default
+	ConnectionLogMaxBackups:     5,
+	AuditLogMaxSize:             104857600,
+	StatsdPrefix:                "centrifugo",
+	StatsdFormat:                statsdFormatStatsd,
+	TracingProtocol:             tracingProtocolProtobuf,
+	TracingSampleRate:           1,
+	KafkaConsumerGroup:          "centrifugo",
+	KafkaErrorPolicy:            kafkaErrorPolicySkip,
+	AuditLogMaxBackups:          5,
+	WebsocketCompressionMinSize: 512,
+	PrivateSignCacheSize:        10000,
+	PresenceCacheTTL:            time.Second,
+	PresenceCacheSize:           10000,
 	Insecure:                    false,
+	APILegacyFormEnabled:        true,
+	APILegacySignEnabled:        true,
+	APISignWindow:               30 * time.Second,
+	AdminActionsEnabled:         true,
+	AdminWatchMaxDuration:       5 * time.Minute,
+	AdminWatchRate:              100,
+	AdminAuthTTL:                24 * time.Hour,
+	AdminAuthLegacyEnabled:      true,
+	AdminAuthMaxAttempts:        5,
+	AdminAuthLockout:            10 * time.Second,
+	AdminAuthLockoutMax:         time.Hour,
+	APIAsyncQueueSize:           10000,
+	NamespaceTemplate: NamespaceTemplate{
+		MaxNamespaces: 1000,
+	},
 }
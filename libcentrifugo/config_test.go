@@ -2,6 +2,7 @@ package libcentrifugo
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -62,6 +63,115 @@ func TestValidateErrorNamespaceNotUnique(t *testing.T) {
 	assert.NotEqual(t, nil, err)
 }
 
+func TestValidateErrorAPIKeys(t *testing.T) {
+	c := *DefaultConfig
+	c.APIKeys = []APIKey{{Name: "publisher", Secret: "1"}, {Name: "publisher", Secret: "2"}}
+	assert.NotEqual(t, nil, c.Validate())
+	c.APIKeys = []APIKey{{Name: "publisher"}}
+	assert.NotEqual(t, nil, c.Validate())
+	c.APIKeys = []APIKey{{Name: "publisher", Secret: "1"}}
+	assert.Equal(t, nil, c.Validate())
+}
+
+func TestValidateConnectionLimitPolicy(t *testing.T) {
+	c := *DefaultConfig
+	c.ClientUserConnectionLimitPolicy = connectionLimitCloseOldest
+	assert.Equal(t, nil, c.Validate())
+	c.ClientUserConnectionLimitPolicy = "close_newest"
+	assert.NotEqual(t, nil, c.Validate())
+}
+
+func TestValidateAPIAsyncQueueSize(t *testing.T) {
+	c := *DefaultConfig
+	c.APIAsyncQueueSize = 0
+	assert.Equal(t, nil, c.Validate())
+	c.APIAsyncQueueSize = -1
+	assert.NotEqual(t, nil, c.Validate())
+}
+
+func TestValidateAdminAuthLockout(t *testing.T) {
+	c := *DefaultConfig
+	c.AdminAuthLockoutMax = 0
+	assert.NotEqual(t, nil, c.Validate())
+	c.AdminAuthMaxAttempts = 0
+	assert.Equal(t, nil, c.Validate())
+}
+
+func TestValidateSlowClientPolicy(t *testing.T) {
+	c := *DefaultConfig
+	c.SlowClientPolicy = slowClientPolicyDropOldest
+	assert.Equal(t, nil, c.Validate())
+	c.SlowClientPolicy = "drop_newest"
+	assert.NotEqual(t, nil, c.Validate())
+
+	c = *DefaultConfig
+	c.Namespaces = []Namespace{{Name: "tickers", ChannelOptions: ChannelOptions{SlowClientPolicy: "drop"}}}
+	assert.NotEqual(t, nil, c.Validate())
+}
+
+func TestValidateTracing(t *testing.T) {
+	c := *DefaultConfig
+	c.TracingProtocol = tracingProtocolJSON
+	assert.Equal(t, nil, c.Validate())
+	c.TracingProtocol = "grpc"
+	assert.NotEqual(t, nil, c.Validate())
+
+	c = *DefaultConfig
+	c.TracingSampleRate = 1.5
+	assert.NotEqual(t, nil, c.Validate())
+}
+
+func TestValidateKafka(t *testing.T) {
+	c := *DefaultConfig
+	c.KafkaBrokers = []string{"localhost:9092"}
+	assert.NotEqual(t, nil, c.Validate())
+	c.KafkaTopics = []string{"events"}
+	assert.Equal(t, nil, c.Validate())
+	c.KafkaConsumerGroup = ""
+	assert.NotEqual(t, nil, c.Validate())
+
+	c = *DefaultConfig
+	c.KafkaErrorPolicy = kafkaErrorPolicyRetry
+	assert.Equal(t, nil, c.Validate())
+	c.KafkaErrorPolicy = "drop"
+	assert.NotEqual(t, nil, c.Validate())
+}
+
+func TestLint(t *testing.T) {
+	c := *DefaultConfig
+	errs, warnings := c.Lint()
+	assert.Equal(t, 0, len(errs))
+	assert.Equal(t, 0, len(warnings))
+
+	c.Recover = true
+	c.HistoryDropInactive = true
+	ns := getTestNamespace("test")
+	ns.Recover = true
+	ns.HistoryLifetime = 0
+	c.Namespaces = []Namespace{ns}
+	c.PresenceExpireInterval = c.PresencePingInterval
+	c.ConnLifetime = 10
+	c.ExpiredConnectionCloseDelay = 25 * time.Second
+	errs, warnings = c.Lint()
+	assert.Equal(t, []string{
+		"recover requires history_size and history_lifetime",
+		"namespace test: recover requires history_size and history_lifetime",
+		"presence_expire_interval must be greater than presence_ping_interval",
+	}, errs)
+	assert.Equal(t, []string{
+		"history_drop_inactive has no effect without history",
+		"connection_lifetime is shorter than expired_connection_close_delay",
+	}, warnings)
+
+	c = *DefaultConfig
+	c.Secret = "secret"
+	ns = getTestNamespace("test")
+	ns.Secret = "secret"
+	c.Namespaces = []Namespace{ns}
+	_, warnings = c.Lint()
+	assert.Equal(t, []string{"namespace test: secret is the same as project secret"}, warnings)
+}
+
 func TestValidateErrorNamespaceWrongName(t *testing.T) {
 	c := *DefaultConfig
 	var ns []Namespace
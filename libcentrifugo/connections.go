@@ -1,5 +1,7 @@
 package libcentrifugo
 
+import "time"
+
 // clientConn is an interface abstracting all methods used
 // by application to interact with client connection
 type clientConn interface {
@@ -11,10 +13,28 @@ type clientConn interface {
 	channels() []Channel
 	// send allows to send message to connection client.
 	send(message []byte) error
-	// unsubscribe allows to unsubscribe connection from channel.
-	unsubscribe(ch Channel) error
+	// sendPublication sends channel publication to connection, it can be
+	// dropped from connection queue according to channel slow client policy.
+	sendPublication(message []byte) error
+	// unsubscribe allows to unsubscribe connection from channel. Connection
+	// receives unsubscribe message with advice whether to resubscribe.
+	unsubscribe(ch Channel, resubscribe bool) error
 	// close closes client's connection.
-	close(reason string) error
+	close(reason string, reconnect bool) error
+	// transport returns name of transport connection established with.
+	transport() string
+	// connected returns unix time when connection was established.
+	connected() int64
+	// binary returns true if connection can receive binary frames.
+	binary() bool
+	// msgpack returns true if connection negotiated MessagePack format.
+	msgpack() bool
+	// queuedBytes returns size of messages waiting to be sent to connection.
+	queuedBytes() int
+	// queueAge returns how long oldest message waits in connection queue.
+	queueAge() time.Duration
+	// acks returns window of messages waiting for client acknowledgement.
+	acks() *ackWindow
 }
 
 // adminConn is an interface abstracting all methods used
@@ -0,0 +1,111 @@
+package libcentrifugo
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// connectProxyRequest is a body of request sent to connect_proxy_endpoint.
+type connectProxyRequest struct {
+	Client     ConnID            `json:"client"`
+	Transport  string            `json:"transport"`
+	RemoteAddr string            `json:"remote_addr"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	// Credentials provided by client in connect command, if any.
+	User      UserID `json:"user,omitempty"`
+	Timestamp string `json:"timestamp,omitempty"`
+	Info      string `json:"info,omitempty"`
+	Exp       string `json:"exp,omitempty"`
+	Token     string `json:"token,omitempty"`
+	// Refresh is true when proxy asked to prolong already authenticated connection.
+	Refresh bool `json:"refresh,omitempty"`
+}
+
+// connectProxyReply is a body of connect_proxy_endpoint response. Backend either
+// sets user (empty user means anonymous connection), optional info and ttl in
+// seconds (connection_lifetime used when zero) or rejects connection with error.
+type connectProxyReply struct {
+	User  UserID          `json:"user"`
+	Info  json.RawMessage `json:"info,omitempty"`
+	TTL   int64           `json:"ttl,omitempty"`
+	Error *proxyError     `json:"error,omitempty"`
+}
+
+// proxyError is a rejection of connection or subscription by proxy backend, code
+// and message passed to client in response.
+type proxyError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *proxyError) Error() string {
+	if e.Message == "" {
+		return ErrUnauthorized.Error()
+	}
+	return e.Message
+}
+
+// connectProxyHeaders returns headers of HTTP request which established
+// connection to forward to connect proxy.
+func (app *Application) connectProxyHeaders(r *http.Request) http.Header {
+	app.RLock()
+	names := app.config.ConnectProxyHeaders
+	app.RUnlock()
+	headers := http.Header{}
+	for _, name := range names {
+		if value := r.Header.Get(name); value != "" {
+			headers.Set(name, value)
+		}
+	}
+	return headers
+}
+
+// connectProxyRequest returns request to connect proxy endpoint. Must be called
+// with client lock held.
+func (c *client) connectProxyRequest(user UserID, timestamp, info, exp, token string, refresh bool) connectProxyRequest {
+	req := connectProxyRequest{
+		Client:     c.UID,
+		Transport:  c.transportName,
+		RemoteAddr: c.remoteAddr,
+		User:       user,
+		Timestamp:  timestamp,
+		Info:       info,
+		Exp:        exp,
+		Token:      token,
+		Refresh:    refresh,
+	}
+	if len(c.headers) > 0 {
+		req.Headers = make(map[string]string, len(c.headers))
+		for name := range c.headers {
+			req.Headers[name] = c.headers.Get(name)
+		}
+	}
+	return req
+}
+
+// connectProxy asks connect proxy endpoint to authenticate connection retrying
+// when endpoint is not available.
+func (app *Application) connectProxy(req connectProxyRequest) (*connectProxyReply, error) {
+	app.RLock()
+	endpoint := app.config.ConnectProxyEndpoint
+	timeout := app.config.ConnectProxyTimeout
+	retries := app.config.ConnectProxyRetries
+	app.RUnlock()
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	started := time.Now()
+	defer func() {
+		app.metrics.histograms.RecordMicroseconds("connect_proxy", time.Now().Sub(started))
+	}()
+
+	var reply connectProxyReply
+	if err := requestProxy(endpoint, timeout, retries, data, &reply); err != nil {
+		return nil, err
+	}
+	return &reply, nil
+}
@@ -0,0 +1,166 @@
+package libcentrifugo
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testConnectProxyClient(t *testing.T, endpoint string) *client {
+	app := testApp()
+	app.config.ConnectProxyEndpoint = endpoint
+	c, err := newClient(app, &testSession{})
+	assert.Equal(t, nil, err)
+	c.transportName = "raw_websocket"
+	c.headers = http.Header{"Cookie": {"session=1"}}
+	return c
+}
+
+// connectProxyCmd handles connect command with client lock held as handler
+// releases it while connect proxy requested.
+func connectProxyCmd(c *client, cmd *connectClientCommand) (response, error) {
+	c.Lock()
+	defer c.Unlock()
+	return c.connectCmd(cmd)
+}
+
+func TestConnectProxy(t *testing.T) {
+	var req connectProxyRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&req)
+		w.Write([]byte(`{"user": "42", "info": {"name": "Alex"}, "ttl": 60}`))
+	}))
+	defer server.Close()
+
+	c := testConnectProxyClient(t, server.URL)
+	resp, err := connectProxyCmd(c, &connectClientCommand{Token: "backend token"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, resp.(*clientConnectResponse).err)
+	body := resp.(*clientConnectResponse).Body
+	assert.True(t, body.Expires)
+	assert.True(t, body.TTL > 0 && body.TTL <= 60)
+	assert.Equal(t, UserID("42"), c.User)
+	assert.Equal(t, `{"name": "Alex"}`, string(c.defaultInfo))
+
+	assert.Equal(t, c.UID, req.Client)
+	assert.Equal(t, "raw_websocket", req.Transport)
+	assert.Equal(t, "backend token", req.Token)
+	assert.Equal(t, "session=1", req.Headers["Cookie"])
+}
+
+func TestConnectProxyReject(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"error": {"code": 4001, "message": "banned"}}`))
+	}))
+	defer server.Close()
+
+	c := testConnectProxyClient(t, server.URL)
+	resp, err := connectProxyCmd(c, &connectClientCommand{})
+	assert.Equal(t, nil, err)
+	assert.False(t, c.authenticated)
+	data, _ := json.Marshal(resp)
+	var decoded map[string]interface{}
+	json.Unmarshal(data, &decoded)
+	assert.Equal(t, "banned", decoded["error"])
+	assert.Equal(t, float64(4001), decoded["code"])
+}
+
+func TestConnectProxyUnavailable(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	c := testConnectProxyClient(t, server.URL)
+	resp, err := connectProxyCmd(c, &connectClientCommand{User: "user1"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, ErrInternalServerError, resp.(*clientConnectResponse).err)
+	assert.Equal(t, errorAdviceRetry, resp.(*clientConnectResponse).Advice)
+	assert.False(t, c.authenticated)
+	// one retry configured by default.
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+	assert.Equal(t, int64(1), c.app.metrics.NumConnectProxyErrors.LoadRaw())
+
+	c = testConnectProxyClient(t, server.URL)
+	c.app.config.ConnectProxyFailOpen = true
+	resp, err = connectProxyCmd(c, &connectClientCommand{User: "user1"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, resp.(*clientConnectResponse).err)
+	assert.True(t, c.authenticated)
+	assert.Equal(t, UserID(""), c.User)
+}
+
+func TestConnectProxyRefresh(t *testing.T) {
+	var c *client
+	var refreshed, locked int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req connectProxyRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if !req.Refresh {
+			w.Write([]byte(`{"user": "42", "ttl": 10}`))
+			return
+		}
+		atomic.AddInt32(&refreshed, 1)
+		// proxy requested without client lock held.
+		acquired := make(chan struct{})
+		go func() {
+			c.Lock()
+			c.Unlock()
+			close(acquired)
+		}()
+		select {
+		case <-acquired:
+		case <-time.After(time.Second):
+			atomic.StoreInt32(&locked, 1)
+		}
+		w.Write([]byte(`{"user": "42", "info": {"v": 2}, "ttl": 120}`))
+	}))
+	defer server.Close()
+
+	c = testConnectProxyClient(t, server.URL)
+	assert.Equal(t, nil, c.handleCommands([]clientCommand{{Method: "connect", Params: []byte("{}")}}))
+	assert.True(t, c.authenticated)
+	assert.True(t, c.proxyAuth)
+	exp := c.exp
+
+	assert.Equal(t, nil, c.handleCommands([]clientCommand{{Method: "refresh", Params: []byte("{}")}}))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&refreshed))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&locked))
+	assert.True(t, c.exp > exp)
+	assert.Equal(t, `{"v": 2}`, string(c.defaultInfo))
+}
+
+func TestConnectProxyRequestsLimited(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write([]byte(`{"error": {"code": 4001, "message": "banned"}}`))
+	}))
+	defer server.Close()
+
+	c := testConnectProxyClient(t, server.URL)
+	connect := clientCommand{Method: "connect", Params: []byte("{}")}
+	refresh := clientCommand{Method: "refresh", Params: []byte("{}")}
+
+	// only first connect of batch proxied, refresh of unauthenticated connection
+	// never proxied.
+	err := c.handleCommands([]clientCommand{connect, connect, connect, refresh})
+	assert.Equal(t, ErrUnauthorized, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	// rate limit applied before proxy requested.
+	c = testConnectProxyClient(t, server.URL)
+	c.app.config.ChannelOptions.ClientCommandsPerSecond = 1
+	c.app.config.ChannelOptions.ClientCommandsBurst = 1
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, nil, c.handleCommands([]clientCommand{connect}))
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+}
@@ -0,0 +1,218 @@
+package libcentrifugo
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/FZambia/go-logger"
+)
+
+// connLogQueueSize is a max number of connection log events waiting to be written
+// to file. Events dropped when writer can not keep up.
+const connLogQueueSize = 8192
+
+// connLogShutdownTimeout is a max time to wait on shutdown for disconnect events
+// of closed connections before connection log flushed.
+const connLogShutdownTimeout = time.Second
+
+// Connection log event types.
+const (
+	connLogConnect    = "connect"
+	connLogDisconnect = "disconnect"
+)
+
+// connLogEvent is a line written into connection log file.
+type connLogEvent struct {
+	Time       string `json:"time"`
+	Event      string `json:"event"`
+	User       UserID `json:"user"`
+	Client     ConnID `json:"client"`
+	Transport  string `json:"transport"`
+	RemoteAddr string `json:"remote_addr"`
+	// Reason and Duration (in seconds) set for disconnect events only.
+	Reason   string `json:"reason,omitempty"`
+	Duration int64  `json:"duration,omitempty"`
+}
+
+// connLogger appends connection log (and audit log) events to file as JSON lines.
+// Events written asynchronously, file rotated when it exceeds max size – current
+// file renamed to file.1, file.1 to file.2 and so on keeping at most maxBackups old
+// files. Logger without file writes events into main log prefixed with marker.
+type connLogger struct {
+	// mu protects closed flag so events never sent into closed queue.
+	mu         sync.RWMutex
+	closed     bool
+	path       string
+	maxSize    int64
+	maxBackups int
+	queue      chan interface{}
+	dropped    *metricCounter
+	marker     string
+	file       *os.File
+	writer     *bufio.Writer
+	size       int64
+	done       chan struct{}
+}
+
+// newConnLogger opens connection log file and starts writer.
+func newConnLogger(path string, maxSize int64, maxBackups int, queueSize int, dropped *metricCounter) (*connLogger, error) {
+	l := &connLogger{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+		queue:      make(chan interface{}, queueSize),
+		dropped:    dropped,
+		done:       make(chan struct{}),
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	go l.run()
+	return l, nil
+}
+
+func (l *connLogger) open() error {
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.file = file
+	l.writer = bufio.NewWriter(file)
+	l.size = info.Size()
+	return nil
+}
+
+// newMainLogWriter starts writer of events into main log, every line prefixed with
+// marker.
+func newMainLogWriter(marker string, queueSize int, dropped *metricCounter) *connLogger {
+	l := &connLogger{
+		marker:  marker,
+		queue:   make(chan interface{}, queueSize),
+		dropped: dropped,
+		done:    make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+// log puts event into writer queue without blocking.
+func (l *connLogger) log(event interface{}) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		return
+	}
+	select {
+	case l.queue <- event:
+	default:
+		l.dropped.Inc()
+	}
+}
+
+// close stops accepting new events and waits until queued events written to file.
+func (l *connLogger) close() {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return
+	}
+	l.closed = true
+	close(l.queue)
+	l.mu.Unlock()
+	<-l.done
+}
+
+func (l *connLogger) run() {
+	defer close(l.done)
+	for event := range l.queue {
+		if err := l.write(event); err != nil {
+			logger.ERROR.Printf("error writing connection log: %v", err)
+		}
+		if len(l.queue) == 0 && l.file != nil {
+			// flush when there are no more events waiting so log lines appear in file
+			// promptly but writes still batched under load.
+			if err := l.writer.Flush(); err != nil {
+				logger.ERROR.Printf("error writing connection log: %v", err)
+			}
+		}
+	}
+	if l.file == nil {
+		return
+	}
+	if err := l.writer.Flush(); err != nil {
+		logger.ERROR.Printf("error writing connection log: %v", err)
+	}
+	l.file.Close()
+}
+
+func (l *connLogger) write(event interface{}) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if l.file == nil {
+		logger.INFO.Printf("%s %s", l.marker, line)
+		return nil
+	}
+	line = append(line, '\n')
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := l.writer.Write(line)
+	l.size += int64(n)
+	return err
+}
+
+// rotate closes current file, shifts old files and opens new empty file.
+func (l *connLogger) rotate() error {
+	if err := l.writer.Flush(); err != nil {
+		return err
+	}
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	if l.maxBackups > 0 {
+		for i := l.maxBackups - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1))
+		}
+		if err := os.Rename(l.path, l.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(l.path); err != nil {
+		return err
+	}
+	return l.open()
+}
+
+// logConnection adds client connect or disconnect event into connection log if it
+// is enabled.
+func (app *Application) logConnection(c *client, event string, reason string) {
+	if app.connLog == nil {
+		return
+	}
+	now := time.Now()
+	e := connLogEvent{
+		Time:       now.UTC().Format(time.RFC3339Nano),
+		Event:      event,
+		User:       c.User,
+		Client:     c.UID,
+		Transport:  c.transportName,
+		RemoteAddr: c.remoteAddr,
+	}
+	if event == connLogDisconnect {
+		e.Reason = reason
+		e.Duration = now.Unix() - c.connectedAt
+	}
+	app.connLog.log(e)
+}
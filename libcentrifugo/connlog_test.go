@@ -0,0 +1,100 @@
+package libcentrifugo
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readConnLog(t *testing.T, path string) []connLogEvent {
+	data, err := ioutil.ReadFile(path)
+	assert.Equal(t, nil, err)
+	var events []connLogEvent
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if line == "" {
+			continue
+		}
+		var event connLogEvent
+		assert.Equal(t, nil, json.Unmarshal([]byte(line), &event))
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestConnLoggerRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "connlog")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "connections.log")
+
+	var dropped metricCounter
+	l, err := newConnLogger(path, 300, 2, 100, &dropped)
+	assert.Equal(t, nil, err)
+	for i := 0; i < 20; i++ {
+		l.log(connLogEvent{Event: connLogConnect, Client: ConnID(strconv.Itoa(i))})
+	}
+	l.close()
+
+	assert.Equal(t, int64(0), dropped.LoadRaw())
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(name)
+		assert.Equal(t, nil, err)
+		assert.True(t, info.Size() <= 300)
+	}
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+
+	events := readConnLog(t, path)
+	assert.Equal(t, ConnID("19"), events[len(events)-1].Client)
+}
+
+func TestConnLoggerDrop(t *testing.T) {
+	var dropped metricCounter
+	// writer not started so queue is not drained.
+	l := &connLogger{
+		queue:   make(chan interface{}, 1),
+		dropped: &dropped,
+	}
+	l.log(connLogEvent{})
+	l.log(connLogEvent{})
+	l.log(connLogEvent{})
+	assert.Equal(t, int64(2), dropped.LoadRaw())
+}
+
+func TestConnLogShutdownFlush(t *testing.T) {
+	dir, err := ioutil.TempDir("", "connlog")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	c := newTestConfig()
+	c.ConnectionLogFile = filepath.Join(dir, "connections.log")
+	app := testMemoryAppWithConfig(&c)
+	assert.Equal(t, nil, app.Run())
+
+	client, err := newClient(app, &testSession{})
+	assert.Equal(t, nil, err)
+	client.transportName = "raw_websocket"
+	client.remoteAddr = "127.0.0.1"
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	err = client.handleCommands([]clientCommand{testConnectCmd(timestamp)})
+	assert.Equal(t, nil, err)
+
+	app.Shutdown()
+
+	events := readConnLog(t, c.ConnectionLogFile)
+	assert.Equal(t, 2, len(events))
+	assert.Equal(t, connLogConnect, events[0].Event)
+	assert.Equal(t, UserID("user1"), events[0].User)
+	assert.Equal(t, client.uid(), events[0].Client)
+	assert.Equal(t, "raw_websocket", events[0].Transport)
+	assert.Equal(t, "127.0.0.1", events[0].RemoteAddr)
+	assert.Equal(t, connLogDisconnect, events[1].Event)
+	assert.Equal(t, "shutting down", events[1].Reason)
+}
@@ -0,0 +1,105 @@
+package libcentrifugo
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/FZambia/go-logger"
+	"github.com/satori/go.uuid"
+)
+
+// controlRequestTimeout is a max time to wait for other nodes to answer control
+// request.
+const controlRequestTimeout = time.Second
+
+// controlRequestHub keeps control requests of this node waiting for replies from
+// other nodes.
+type controlRequestHub struct {
+	sync.Mutex
+	requests map[string]chan interface{}
+}
+
+func newControlRequestHub() *controlRequestHub {
+	return &controlRequestHub{
+		requests: make(map[string]chan interface{}),
+	}
+}
+
+func (h *controlRequestHub) add(requestID string, size int) chan interface{} {
+	h.Lock()
+	defer h.Unlock()
+	ch := make(chan interface{}, size)
+	h.requests[requestID] = ch
+	return ch
+}
+
+func (h *controlRequestHub) remove(requestID string) {
+	h.Lock()
+	defer h.Unlock()
+	delete(h.requests, requestID)
+}
+
+// reply passes reply to waiting request, replies to requests of other nodes or
+// to requests already finished ignored.
+func (h *controlRequestHub) reply(requestID string, data interface{}) {
+	h.Lock()
+	defer h.Unlock()
+	ch, ok := h.requests[requestID]
+	if !ok {
+		return
+	}
+	select {
+	case ch <- data:
+	default:
+	}
+}
+
+// numRemoteNodes returns number of other nodes known from ping control messages.
+func (app *Application) numRemoteNodes() int {
+	app.nodesMu.Lock()
+	defer app.nodesMu.Unlock()
+	n := 0
+	for uid := range app.nodes {
+		if uid != app.uid {
+			n++
+		}
+	}
+	return n
+}
+
+// requestNodes publishes control command built for new request ID and collects
+// replies of other nodes during controlRequestTimeout. Replies are values passed
+// to controlRequestHub reply by corresponding reply control method. Returned bool
+// is false when not all known nodes answered in time.
+func (app *Application) requestNodes(method string, build func(requestID string) interface{}) ([]interface{}, bool, error) {
+	numNodes := app.numRemoteNodes()
+	if numNodes == 0 {
+		return nil, true, nil
+	}
+
+	requestID := uuid.NewV4().String()
+	replies := app.controlRequests.add(requestID, numNodes)
+	defer app.controlRequests.remove(requestID)
+
+	cmdBytes, err := json.Marshal(build(requestID))
+	if err != nil {
+		return nil, false, err
+	}
+	if err := app.pubControl(method, cmdBytes); err != nil {
+		return nil, false, err
+	}
+
+	var data []interface{}
+	timeout := time.After(controlRequestTimeout)
+	for answered := 0; answered < numNodes; answered++ {
+		select {
+		case reply := <-replies:
+			data = append(data, reply)
+		case <-timeout:
+			logger.INFO.Printf("%s control request: %d of %d nodes answered in time", method, answered, numNodes)
+			return data, false, nil
+		}
+	}
+	return data, true, nil
+}
@@ -0,0 +1,132 @@
+package libcentrifugo
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/FZambia/go-logger"
+)
+
+// corsAllowOrigin is a special allowed origin matching any origin.
+const corsAllowOrigin = "*"
+
+// corsAPIHeaders are request headers browser allowed to send to HTTP API.
+const corsAPIHeaders = "Content-Type, X-API-Sign, X-API-Key, X-API-Timestamp, X-API-Nonce"
+
+// originAllowed checks request Origin against allowed origins. Requests without
+// Origin header are not made by browsers and always allowed as well as any request
+// when list of allowed origins is empty.
+func originAllowed(r *http.Request, allowed []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || len(allowed) == 0 {
+		return true
+	}
+	for _, o := range allowed {
+		if originMatches(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// originMatches checks origin against allowed origin pattern. Pattern can contain
+// wildcard subdomain like "https://*.example.com" matching any subdomain of
+// example.com but not example.com itself.
+func originMatches(pattern, origin string) bool {
+	if pattern == corsAllowOrigin {
+		return true
+	}
+	pattern = strings.ToLower(pattern)
+	origin = strings.ToLower(origin)
+	i := strings.Index(pattern, "://*.")
+	if i < 0 {
+		return pattern == origin
+	}
+	scheme, domain := pattern[:i+3], pattern[i+4:]
+	if !strings.HasPrefix(origin, scheme) {
+		return false
+	}
+	host := strings.TrimPrefix(origin, scheme)
+	return len(host) > len(domain) && strings.HasSuffix(host, domain)
+}
+
+// originExplicitlyAllowed checks that request Origin matches one of allowed origins
+// other than "*". Only such origins can be echoed back together with credentials.
+func originExplicitlyAllowed(r *http.Request, allowed []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	for _, o := range allowed {
+		if o != corsAllowOrigin && originMatches(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// originRejected logs and counts request rejected because of its Origin.
+func (app *Application) originRejected(r *http.Request) {
+	logger.DEBUG.Printf("request from origin %s rejected", r.Header.Get("Origin"))
+	app.metrics.NumOriginRejected.Inc()
+}
+
+// allowedOrigins returns origins allowed for API or client endpoints. API falls
+// back to client origins when api_allowed_origins not set.
+func (app *Application) allowedOrigins(api bool) []string {
+	app.RLock()
+	defer app.RUnlock()
+	if api && len(app.config.APIAllowedOrigins) > 0 {
+		return app.config.APIAllowedOrigins
+	}
+	return app.config.AllowedOrigins
+}
+
+// checkOrigin checks Origin of Websocket upgrade request.
+func (app *Application) checkOrigin(r *http.Request) bool {
+	if !originAllowed(r, app.allowedOrigins(false)) {
+		app.originRejected(r)
+		return false
+	}
+	return true
+}
+
+// WrapCORS rejects browser requests from origins not in allowed_origins. For API
+// endpoint it also sets CORS headers and answers preflight requests, SockJS sets
+// CORS headers itself so client endpoints only checked. Origin is echoed back
+// with credentials allowed only when it is explicitly listed, while "*" results
+// in wildcard header. Without configured allowed origins handler called as is.
+func (app *Application) WrapCORS(h http.Handler, api bool) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		allowed := app.allowedOrigins(api)
+		if len(allowed) == 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+		origin := r.Header.Get("Origin")
+		if !originAllowed(r, allowed) {
+			app.originRejected(r)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if api && origin != "" {
+			header := w.Header()
+			if originExplicitlyAllowed(r, allowed) {
+				header.Set("Access-Control-Allow-Origin", origin)
+				header.Set("Access-Control-Allow-Credentials", "true")
+			} else {
+				// Origin allowed by "*" only – browsers must not send credentials.
+				header.Set("Access-Control-Allow-Origin", corsAllowOrigin)
+			}
+			header.Set("Access-Control-Allow-Headers", corsAPIHeaders)
+			header.Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+			header.Add("Vary", "Origin")
+			if r.Method == "OPTIONS" {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
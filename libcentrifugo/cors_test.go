@@ -0,0 +1,86 @@
+package libcentrifugo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOriginAllowed(t *testing.T) {
+	r, _ := http.NewRequest("GET", "/", nil)
+	assert.True(t, originAllowed(r, nil))
+	assert.True(t, originAllowed(r, []string{"https://example.com"}))
+	r.Header.Set("Origin", "https://Example.com")
+	assert.True(t, originAllowed(r, []string{"https://example.com"}))
+	assert.True(t, originAllowed(r, []string{corsAllowOrigin}))
+	assert.False(t, originAllowed(r, []string{"https://example.org"}))
+}
+
+func TestOriginMatches(t *testing.T) {
+	assert.True(t, originMatches("https://*.example.com", "https://a.example.com"))
+	assert.True(t, originMatches("https://*.example.com", "https://a.b.Example.com"))
+	assert.False(t, originMatches("https://*.example.com", "https://example.com"))
+	assert.False(t, originMatches("https://*.example.com", "https://aexample.com"))
+	assert.False(t, originMatches("https://*.example.com", "http://a.example.com"))
+	assert.False(t, originMatches("https://*.example.com", "https://a.example.com.evil.org"))
+}
+
+func TestWrapCORS(t *testing.T) {
+	app := testApp()
+	app.config.AllowedOrigins = []string{"https://example.com"}
+	app.config.APIAllowedOrigins = []string{"https://admin.example.com"}
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req, _ := http.NewRequest("OPTIONS", "/api/", nil)
+	req.Header.Set("Origin", "https://admin.example.com")
+	rec := httptest.NewRecorder()
+	app.WrapCORS(h, true).ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "https://admin.example.com", rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", rec.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, corsAPIHeaders, rec.Header().Get("Access-Control-Allow-Headers"))
+
+	req, _ = http.NewRequest("POST", "/api/", nil)
+	req.Header.Set("Origin", "https://example.com")
+	rec = httptest.NewRecorder()
+	app.WrapCORS(h, true).ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = httptest.NewRecorder()
+	app.WrapCORS(h, false).ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "", rec.Header().Get("Access-Control-Allow-Origin"))
+
+	req.Header.Set("Origin", "https://example.org")
+	assert.False(t, app.checkOrigin(req))
+	assert.Equal(t, int64(2), app.metrics.NumOriginRejected.LoadRaw())
+	rec = httptest.NewRecorder()
+	app.WrapCORS(h, false).ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func TestWrapCORSWildcard(t *testing.T) {
+	app := testApp()
+	app.config.APIAllowedOrigins = []string{corsAllowOrigin, "https://admin.example.com"}
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req, _ := http.NewRequest("POST", "/api/", nil)
+	req.Header.Set("Origin", "https://evil.org")
+	rec := httptest.NewRecorder()
+	app.WrapCORS(h, true).ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, corsAllowOrigin, rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "", rec.Header().Get("Access-Control-Allow-Credentials"))
+
+	req.Header.Set("Origin", "https://admin.example.com")
+	rec = httptest.NewRecorder()
+	app.WrapCORS(h, true).ServeHTTP(rec, req)
+	assert.Equal(t, "https://admin.example.com", rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", rec.Header().Get("Access-Control-Allow-Credentials"))
+}
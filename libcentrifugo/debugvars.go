@@ -0,0 +1,67 @@
+package libcentrifugo
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+
+	"github.com/FZambia/go-logger"
+)
+
+// debugVars are node variables served by debug vars endpoint in addition to
+// standard expvar variables (cmdline and memstats).
+type debugVars struct {
+	NumConnections int64 `json:"num_connections"`
+	NumClients     int   `json:"num_clients"`
+	NumChannels    int   `json:"num_channels"`
+	// Namespaces contains message counters by channel namespace since node started.
+	Namespaces map[string]namespaceMetrics `json:"namespaces"`
+	// QueueDepths is a number of items waiting in internal queues: async API queue
+	// and engine queues if engine has them.
+	QueueDepths map[string]int `json:"queue_depths"`
+}
+
+func (app *Application) debugVars() debugVars {
+	queues := map[string]int{"api_async": app.apiAsync.len()}
+	if e, ok := app.engine.(queueDepthEngine); ok {
+		for name, depth := range e.queueDepths() {
+			queues["engine_"+name] = depth
+		}
+	}
+	return debugVars{
+		NumConnections: app.clients.connections(),
+		NumClients:     app.clients.nClients(),
+		NumChannels:    app.clients.nChannels(),
+		Namespaces:     app.metrics.namespaces.values(true),
+		QueueDepths:    queues,
+	}
+}
+
+// DebugVarsHandler serves expvar variables with node variables under centrifugo
+// key. Response has the same format as expvar handler so expvar tools can read it,
+// node variables not published into global expvar registry as several
+// applications can run in one process.
+func (app *Application) DebugVarsHandler(w http.ResponseWriter, r *http.Request) {
+	vars, err := json.Marshal(app.debugVars())
+	if err != nil {
+		logger.ERROR.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprintf(w, "{\n")
+	first := true
+	expvar.Do(func(kv expvar.KeyValue) {
+		if !first {
+			fmt.Fprintf(w, ",\n")
+		}
+		first = false
+		fmt.Fprintf(w, "%q: %s", kv.Key, kv.Value)
+	})
+	if !first {
+		fmt.Fprintf(w, ",\n")
+	}
+	fmt.Fprintf(w, "%q: %s", "centrifugo", vars)
+	fmt.Fprintf(w, "\n}\n")
+}
@@ -0,0 +1,48 @@
+package libcentrifugo
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDebugVarsHandler(t *testing.T) {
+	app := testMemoryApp()
+	assert.Equal(t, nil, app.Publish("channel", []byte(`{}`), "", nil))
+	assert.Equal(t, nil, app.Publish("test:channel", []byte(`{}`), "", nil))
+	assert.Equal(t, nil, app.Publish("test:channel", []byte(`{}`), "", nil))
+
+	opts := DefaultMuxOptions
+	opts.HandlerFlags |= HandlerDebug
+	server := httptest.NewServer(DefaultMux(app, opts))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/debug/vars")
+	assert.Equal(t, nil, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var vars struct {
+		Memstats   json.RawMessage `json:"memstats"`
+		Centrifugo debugVars       `json:"centrifugo"`
+	}
+	assert.Equal(t, nil, json.NewDecoder(resp.Body).Decode(&vars))
+	assert.True(t, len(vars.Memstats) > 0)
+	assert.Equal(t, int64(1), vars.Centrifugo.Namespaces[namespaceMetricsDefault].NumMsgPublished)
+	assert.Equal(t, int64(2), vars.Centrifugo.Namespaces["test"].NumMsgPublished)
+	assert.Equal(t, 0, vars.Centrifugo.QueueDepths["api_async"])
+}
+
+func TestDebugVarsNotServedWithoutDebug(t *testing.T) {
+	app := testMemoryApp()
+	server := httptest.NewServer(DefaultMux(app, DefaultMuxOptions))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/debug/vars")
+	assert.Equal(t, nil, err)
+	resp.Body.Close()
+	assert.NotEqual(t, http.StatusOK, resp.StatusCode)
+}
@@ -0,0 +1,276 @@
+package libcentrifugo
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// diagTopChannels is a max number of channels with most subscribers included
+// into diagnostic bundle.
+const diagTopChannels = 20
+
+// diagRedacted replaces values of secret settings in diagnostic bundle.
+const diagRedacted = "[redacted]"
+
+// diagSecretKeys contains substrings of keys which values must never leave node
+// in diagnostic bundle.
+var diagSecretKeys = []string{"secret", "password", "token"}
+
+// diagPublicKeys contains keys which values never redacted even if names inside
+// look like secret ones – metrics are counters only.
+var diagPublicKeys = map[string]bool{"metrics": true}
+
+// diagBundle contains node and cluster state useful to investigate problems.
+type diagBundle struct {
+	Node        string               `json:"node"`
+	Version     string               `json:"version"`
+	GeneratedAt int64                `json:"generated_at"`
+	Config      *Config              `json:"config"`
+	Stats       serverStats          `json:"stats"`
+	Metrics     metrics              `json:"metrics"`
+	Engine      diagEngine           `json:"engine"`
+	TopChannels []channelSubscribers `json:"top_channels"`
+	Goroutines  diagGoroutines       `json:"goroutines"`
+	Alarms      map[string]string    `json:"alarms"`
+	// Truncated is true when bundle was trimmed to fit DiagMaxSize.
+	Truncated bool `json:"truncated"`
+}
+
+// diagEngine describes engine state at moment of bundle generation.
+type diagEngine struct {
+	Name     string `json:"name"`
+	OK       bool   `json:"ok"`
+	Error    string `json:"error,omitempty"`
+	Latency  int64  `json:"latency_ms"`
+	Channels int    `json:"num_channels"`
+}
+
+// diagGoroutines is a summary of goroutine profile – goroutines with the same
+// stack grouped together.
+type diagGoroutines struct {
+	Total  int         `json:"total"`
+	Stacks []diagStack `json:"stacks"`
+}
+
+type diagStack struct {
+	Count     int      `json:"count"`
+	Functions []string `json:"functions"`
+}
+
+// channelSubscribers contains number of node subscribers in channel.
+type channelSubscribers struct {
+	Channel     Channel `json:"channel"`
+	Subscribers int     `json:"subscribers"`
+}
+
+type bySubscribers []channelSubscribers
+
+func (s bySubscribers) Len() int      { return len(s) }
+func (s bySubscribers) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s bySubscribers) Less(i, j int) bool {
+	if s[i].Subscribers == s[j].Subscribers {
+		return s[i].Channel < s[j].Channel
+	}
+	return s[i].Subscribers > s[j].Subscribers
+}
+
+// diag returns diagnostic bundle encoded to JSON with all secret values redacted.
+// Bundle built from copies of node state so generating it does not block message
+// processing.
+func (app *Application) diag() ([]byte, error) {
+	app.RLock()
+	config := *app.config
+	app.RUnlock()
+
+	bundle := &diagBundle{
+		Node:        app.uid,
+		Version:     config.Version,
+		GeneratedAt: time.Now().Unix(),
+		Config:      &config,
+		Stats:       app.stats(),
+		Metrics:     *app.metrics.GetSnapshotMetrics(),
+		Engine:      app.diagEngine(),
+		TopChannels: app.clients.topChannels(diagTopChannels),
+		Goroutines:  diagGoroutineSummary(),
+		Alarms:      app.alarms.active(),
+	}
+
+	for {
+		data, err := encodeDiag(bundle)
+		if err != nil {
+			return nil, err
+		}
+		if config.DiagMaxSize <= 0 || len(data) <= config.DiagMaxSize || !bundle.trim() {
+			return data, nil
+		}
+	}
+}
+
+// trim removes half of goroutine stacks or top channels from bundle. It returns
+// false if there is nothing left to remove.
+func (b *diagBundle) trim() bool {
+	b.Truncated = true
+	if n := len(b.Goroutines.Stacks); n > 0 {
+		b.Goroutines.Stacks = b.Goroutines.Stacks[:n/2]
+		return true
+	}
+	if n := len(b.TopChannels); n > 0 {
+		b.TopChannels = b.TopChannels[:n/2]
+		return true
+	}
+	return false
+}
+
+// diagEngine checks that engine responds and measures its latency.
+func (app *Application) diagEngine() diagEngine {
+	started := time.Now()
+	channels, err := app.engine.channels()
+	state := diagEngine{
+		Name:     app.engine.name(),
+		OK:       err == nil,
+		Latency:  int64(time.Since(started) / time.Millisecond),
+		Channels: len(channels),
+	}
+	if err != nil {
+		state.Error = err.Error()
+	}
+	return state
+}
+
+// diagGoroutineSummary parses goroutine profile grouped by stack and keeps only
+// function names of every stack.
+func diagGoroutineSummary() diagGoroutines {
+	summary := diagGoroutines{
+		Total:  runtime.NumGoroutine(),
+		Stacks: []diagStack{},
+	}
+	var buf bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&buf, 1); err != nil {
+		return summary
+	}
+	var stack *diagStack
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.Contains(line, " @ "):
+			count, err := strconv.Atoi(line[:strings.Index(line, " @ ")])
+			if err != nil {
+				stack = nil
+				continue
+			}
+			summary.Stacks = append(summary.Stacks, diagStack{Count: count, Functions: []string{}})
+			stack = &summary.Stacks[len(summary.Stacks)-1]
+		case stack != nil && strings.HasPrefix(line, "#"):
+			fields := strings.Fields(line)
+			if len(fields) >= 3 {
+				stack.Functions = append(stack.Functions, fields[2])
+			}
+		case line == "":
+			stack = nil
+		}
+	}
+	return summary
+}
+
+// encodeDiag encodes bundle to JSON and then redacts it.
+func encodeDiag(bundle *diagBundle) ([]byte, error) {
+	data, err := json.Marshal(bundle)
+	if err != nil {
+		return nil, err
+	}
+	var decoded interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&decoded); err != nil {
+		return nil, err
+	}
+	return json.Marshal(redactDiag(decoded))
+}
+
+// RedactSecrets replaces values of all keys looking like secret ones (secret,
+// password, token) in decoded JSON value and strips credentials from URL values
+// (keys ending with url) so it can be shown to operators.
+func RedactSecrets(v interface{}) interface{} {
+	return redactDiag(v)
+}
+
+// redactDiag walks over decoded JSON value and replaces values of all keys looking
+// like secret ones and credentials in URLs.
+func redactDiag(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for k, item := range value {
+			if diagPublicKeys[k] {
+				continue
+			}
+			if diagSecretKey(k) {
+				if s, ok := item.(string); !ok || s != "" {
+					value[k] = diagRedacted
+				}
+				continue
+			}
+			if strings.HasSuffix(strings.ToLower(k), "url") {
+				value[k] = redactURLValue(item)
+				continue
+			}
+			value[k] = redactDiag(item)
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = redactDiag(item)
+		}
+	}
+	return v
+}
+
+// redactURLValue strips userinfo from URL option value which is a string or list
+// of strings.
+func redactURLValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case string:
+		return redactURLs(value)
+	case []interface{}:
+		for i, item := range value {
+			value[i] = redactURLValue(item)
+		}
+	}
+	return v
+}
+
+// redactURLs strips userinfo from comma separated list of URLs.
+func redactURLs(value string) string {
+	urls := strings.Split(value, ",")
+	for i, u := range urls {
+		parsed, err := url.Parse(strings.TrimSpace(u))
+		if err != nil {
+			if strings.Contains(u, "@") {
+				// unparsable URL can still contain credentials.
+				urls[i] = diagRedacted
+			}
+			continue
+		}
+		if parsed.User != nil {
+			parsed.User = nil
+			urls[i] = parsed.String()
+		}
+	}
+	return strings.Join(urls, ",")
+}
+
+func diagSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range diagSecretKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package libcentrifugo
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiagBundle(t *testing.T) {
+	app := testMemoryApp()
+	app.config.AdminPassword = "admin-password"
+	app.config.AdminSecret = "admin-secret"
+	createTestClients(app, 3, 5, nil)
+
+	data, err := app.diag()
+	assert.Equal(t, nil, err)
+
+	var bundle map[string]interface{}
+	err = json.Unmarshal(data, &bundle)
+	assert.Equal(t, nil, err)
+	for _, key := range []string{"node", "version", "generated_at", "config", "stats", "metrics", "engine", "top_channels", "goroutines", "alarms", "truncated"} {
+		_, ok := bundle[key]
+		assert.True(t, ok, key)
+	}
+	assert.Equal(t, false, bundle["truncated"])
+
+	config := bundle["config"].(map[string]interface{})
+	assert.Equal(t, diagRedacted, config["secret"])
+	assert.False(t, strings.Contains(string(data), `"secret":"secret"`))
+	assert.False(t, strings.Contains(string(data), "admin-password"))
+	assert.False(t, strings.Contains(string(data), "admin-secret"))
+
+	engine := bundle["engine"].(map[string]interface{})
+	assert.Equal(t, "In memory – single node only", engine["name"])
+	assert.Equal(t, true, engine["ok"])
+
+	top := bundle["top_channels"].([]interface{})
+	assert.Equal(t, 3, len(top))
+	assert.Equal(t, float64(5), top[0].(map[string]interface{})["subscribers"])
+
+	goroutines := bundle["goroutines"].(map[string]interface{})
+	assert.True(t, goroutines["total"].(float64) > 0)
+	assert.True(t, len(goroutines["stacks"].([]interface{})) > 0)
+}
+
+func TestDiagBundleMaxSize(t *testing.T) {
+	app := testMemoryApp()
+	createTestClients(app, 50, 1, nil)
+	app.config.DiagMaxSize = 1
+
+	data, err := app.diag()
+	assert.Equal(t, nil, err)
+
+	var bundle diagBundle
+	err = json.Unmarshal(data, &bundle)
+	assert.Equal(t, nil, err)
+	assert.True(t, bundle.Truncated)
+	assert.Equal(t, 0, len(bundle.Goroutines.Stacks))
+	assert.Equal(t, 0, len(bundle.TopChannels))
+}
+
+func TestRedactDiag(t *testing.T) {
+	var v interface{}
+	err := json.Unmarshal([]byte(`{"secret":"s","nested":[{"api_token":"t","Password":1,"name":"n"}],"empty_secret":""}`), &v)
+	assert.Equal(t, nil, err)
+	redacted := redactDiag(v).(map[string]interface{})
+	assert.Equal(t, diagRedacted, redacted["secret"])
+	assert.Equal(t, "", redacted["empty_secret"])
+	nested := redacted["nested"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, diagRedacted, nested["api_token"])
+	assert.Equal(t, diagRedacted, nested["Password"])
+	assert.Equal(t, "n", nested["name"])
+
+	err = json.Unmarshal([]byte(`{"metrics":{"num_secret_previous_used":1}}`), &v)
+	assert.Equal(t, nil, err)
+	redacted = redactDiag(v).(map[string]interface{})
+	assert.Equal(t, float64(1), redacted["metrics"].(map[string]interface{})["num_secret_previous_used"])
+
+	err = json.Unmarshal([]byte(`{"redis_url":"redis://:pass@host:6379/0","nats_url":"nats://u:p@a:4222,nats://b:4222","redis_replica_url":["redis://h","redis://:p@h2"]}`), &v)
+	assert.Equal(t, nil, err)
+	redacted = redactDiag(v).(map[string]interface{})
+	assert.Equal(t, "redis://host:6379/0", redacted["redis_url"])
+	assert.Equal(t, "nats://a:4222,nats://b:4222", redacted["nats_url"])
+	assert.Equal(t, []interface{}{"redis://h", "redis://h2"}, redacted["redis_replica_url"])
+}
+
+func TestAPIDiag(t *testing.T) {
+	app := testMemoryApp()
+	resp, err := app.apiCmd(apiCommand{Method: "diag"})
+	assert.Equal(t, nil, err)
+	data, err := json.Marshal(resp)
+	assert.Equal(t, nil, err)
+	assert.True(t, strings.Contains(string(data), `"method":"diag"`))
+	assert.True(t, strings.Contains(string(data), `"secret":"[redacted]"`))
+}
@@ -0,0 +1,318 @@
+package encode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// ErrMsgpackInvalid returned when data can not be decoded from MessagePack.
+var ErrMsgpackInvalid = errors.New("invalid msgpack data")
+
+// maxMsgpackDepth limits nesting of MessagePack containers to protect decoder
+// from deeply nested input.
+const maxMsgpackDepth = 256
+
+// JSONToMsgpack converts JSON document to MessagePack. Object keys are sorted so
+// output is deterministic. Integer numbers encoded as MessagePack integers, other
+// numbers as float64.
+func JSONToMsgpack(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := writeMsgpack(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// MsgpackToJSON converts MessagePack document to JSON. Map keys must be strings,
+// binary values encoded as base64 strings, extension types not supported.
+func MsgpackToJSON(data []byte) ([]byte, error) {
+	r := &msgpackReader{data: data}
+	v, err := r.read(0)
+	if err != nil {
+		return nil, err
+	}
+	if r.pos != len(data) {
+		return nil, ErrMsgpackInvalid
+	}
+	return json.Marshal(v)
+}
+
+func writeMsgpack(buf *bytes.Buffer, v interface{}) error {
+	switch value := v.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if value {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case json.Number:
+		if i, err := strconv.ParseInt(string(value), 10, 64); err == nil {
+			writeMsgpackInt(buf, i)
+			return nil
+		}
+		if u, err := strconv.ParseUint(string(value), 10, 64); err == nil {
+			buf.WriteByte(0xcf)
+			writeUint(buf, u, 8)
+			return nil
+		}
+		f, err := value.Float64()
+		if err != nil {
+			return err
+		}
+		buf.WriteByte(0xcb)
+		writeUint(buf, math.Float64bits(f), 8)
+	case string:
+		writeMsgpackString(buf, value)
+	case []interface{}:
+		writeMsgpackHeader(buf, len(value), 0x90, 0xdc, 0xdd)
+		for _, item := range value {
+			if err := writeMsgpack(buf, item); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		writeMsgpackHeader(buf, len(value), 0x80, 0xde, 0xdf)
+		keys := make([]string, 0, len(value))
+		for k := range value {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			writeMsgpackString(buf, k)
+			if err := writeMsgpack(buf, value[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return errors.New("unsupported type for msgpack encoding")
+	}
+	return nil
+}
+
+func writeMsgpackInt(buf *bytes.Buffer, i int64) {
+	switch {
+	case i >= 0 && i <= 127:
+		buf.WriteByte(byte(i))
+	case i < 0 && i >= -32:
+		buf.WriteByte(byte(i))
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		buf.WriteByte(0xd0)
+		buf.WriteByte(byte(i))
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		buf.WriteByte(0xd1)
+		writeUint(buf, uint64(i), 2)
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		buf.WriteByte(0xd2)
+		writeUint(buf, uint64(i), 4)
+	default:
+		buf.WriteByte(0xd3)
+		writeUint(buf, uint64(i), 8)
+	}
+}
+
+func writeMsgpackString(buf *bytes.Buffer, s string) {
+	n := len(s)
+	switch {
+	case n < 32:
+		buf.WriteByte(0xa0 | byte(n))
+	case n <= math.MaxUint8:
+		buf.WriteByte(0xd9)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(0xda)
+		writeUint(buf, uint64(n), 2)
+	default:
+		buf.WriteByte(0xdb)
+		writeUint(buf, uint64(n), 4)
+	}
+	buf.WriteString(s)
+}
+
+// writeMsgpackHeader writes array or map header with n elements using fix, 16 and
+// 32 bit format codes.
+func writeMsgpackHeader(buf *bytes.Buffer, n int, fix, code16, code32 byte) {
+	switch {
+	case n < 16:
+		buf.WriteByte(fix | byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(code16)
+		writeUint(buf, uint64(n), 2)
+	default:
+		buf.WriteByte(code32)
+		writeUint(buf, uint64(n), 4)
+	}
+}
+
+func writeUint(buf *bytes.Buffer, u uint64, size int) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], u)
+	buf.Write(b[8-size:])
+}
+
+type msgpackReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *msgpackReader) next(n int) ([]byte, error) {
+	if n < 0 || len(r.data)-r.pos < n {
+		return nil, ErrMsgpackInvalid
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+func (r *msgpackReader) uint(size int) (uint64, error) {
+	b, err := r.next(size)
+	if err != nil {
+		return 0, err
+	}
+	var u uint64
+	for _, c := range b {
+		u = u<<8 | uint64(c)
+	}
+	return u, nil
+}
+
+func (r *msgpackReader) read(depth int) (interface{}, error) {
+	if depth > maxMsgpackDepth {
+		return nil, ErrMsgpackInvalid
+	}
+	b, err := r.next(1)
+	if err != nil {
+		return nil, err
+	}
+	code := b[0]
+	switch {
+	case code <= 0x7f:
+		return int64(code), nil
+	case code >= 0xe0:
+		return int64(int8(code)), nil
+	case code&0xe0 == 0xa0:
+		return r.str(int(code & 0x1f))
+	case code&0xf0 == 0x90:
+		return r.array(int(code&0x0f), depth)
+	case code&0xf0 == 0x80:
+		return r.mapping(int(code&0x0f), depth)
+	}
+	switch code {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return r.uint(1 << (code - 0xcc))
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (code - 0xd0)
+		u, err := r.uint(size)
+		if err != nil {
+			return nil, err
+		}
+		shift := uint(64 - 8*size)
+		return int64(u<<shift) >> shift, nil
+	case 0xca:
+		u, err := r.uint(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(uint32(u))), nil
+	case 0xcb:
+		u, err := r.uint(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(u), nil
+	case 0xd9, 0xda, 0xdb:
+		n, err := r.uint(1 << (code - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return r.str(int(n))
+	case 0xc4, 0xc5, 0xc6:
+		n, err := r.uint(1 << (code - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		b, err := r.next(int(n))
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), b...), nil
+	case 0xdc, 0xdd:
+		n, err := r.uint(2 << (code - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return r.array(int(n), depth)
+	case 0xde, 0xdf:
+		n, err := r.uint(2 << (code - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return r.mapping(int(n), depth)
+	}
+	return nil, ErrMsgpackInvalid
+}
+
+func (r *msgpackReader) str(n int) (string, error) {
+	b, err := r.next(n)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (r *msgpackReader) array(n int, depth int) (interface{}, error) {
+	if n > len(r.data)-r.pos {
+		// every element takes at least one byte.
+		return nil, ErrMsgpackInvalid
+	}
+	items := make([]interface{}, n)
+	for i := 0; i < n; i++ {
+		item, err := r.read(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		items[i] = item
+	}
+	return items, nil
+}
+
+func (r *msgpackReader) mapping(n int, depth int) (interface{}, error) {
+	if n > len(r.data)-r.pos {
+		return nil, ErrMsgpackInvalid
+	}
+	items := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		key, err := r.read(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		k, ok := key.(string)
+		if !ok {
+			return nil, ErrMsgpackInvalid
+		}
+		value, err := r.read(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		items[k] = value
+	}
+	return items, nil
+}
@@ -0,0 +1,77 @@
+package encode
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMsgpackRoundTrip(t *testing.T) {
+	items := make([]string, 20)
+	for i := range items {
+		items[i] = `"item"`
+	}
+	fields := make([]string, 20)
+	for i := range fields {
+		fields[i] = `"k` + strings.Repeat("x", i) + `":` + strings.Repeat("1", i%5+1)
+	}
+	docs := []string{
+		`null`,
+		`true`,
+		`false`,
+		`0`,
+		`127`,
+		`128`,
+		`-1`,
+		`-32`,
+		`-33`,
+		`-129`,
+		`70000`,
+		`-70000`,
+		`9223372036854775807`,
+		`-9223372036854775808`,
+		`18446744073709551615`,
+		`1.5`,
+		`-0.25`,
+		`""`,
+		`"привет"`,
+		`"` + strings.Repeat("a", 31) + `"`,
+		`"` + strings.Repeat("a", 300) + `"`,
+		`"` + strings.Repeat("a", 70000) + `"`,
+		`[]`,
+		`[` + strings.Join(items, ",") + `]`,
+		`{}`,
+		`{` + strings.Join(fields, ",") + `}`,
+		`{"method":"publish","params":{"channel":"test","data":{"input":[1,"2",null,{"nested":true}]}}}`,
+	}
+	for _, doc := range docs {
+		packed, err := JSONToMsgpack([]byte(doc))
+		assert.Equal(t, nil, err, doc)
+		unpacked, err := MsgpackToJSON(packed)
+		assert.Equal(t, nil, err, doc)
+		var expected, got interface{}
+		assert.Equal(t, nil, json.Unmarshal([]byte(doc), &expected))
+		assert.Equal(t, nil, json.Unmarshal(unpacked, &got))
+		assert.Equal(t, expected, got, doc)
+	}
+}
+
+func TestMsgpackInvalid(t *testing.T) {
+	invalid := [][]byte{
+		{},
+		{0xc1},
+		{0xa5, 'a'},
+		{0xdd, 0xff, 0xff, 0xff, 0xff},
+		{0x81, 0x01, 0x01},
+		{0xc0, 0xc0},
+		{0xd4, 0x01, 0x01},
+	}
+	for _, data := range invalid {
+		_, err := MsgpackToJSON(data)
+		assert.NotEqual(t, nil, err)
+	}
+	_, err := JSONToMsgpack([]byte(`{"a":`))
+	assert.NotEqual(t, nil, err)
+}
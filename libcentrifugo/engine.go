@@ -25,13 +25,16 @@ type Engine interface {
 	// publishAdmin allows to send admin message to all connected admins.
 	publishAdmin(*AdminMessage) <-chan error
 
-	// subscribe on channel.
-	subscribe(Channel) error
+	// subscribe on channel. The returned value is channel in which we will send
+	// error as soon as engine finishes subscribe operation.
+	subscribe(Channel) <-chan error
 	// unsubscribe from channel.
 	unsubscribe(Channel) error
 	// channels returns slice of currently active channels (with one or more subscribers)
 	// on all Centrifugo nodes.
 	channels() ([]Channel, error)
+	// numSubscribers returns number of subscribers in channel on all Centrifugo nodes.
+	numSubscribers(Channel) (int, error)
 
 	// addPresence sets or updates presence info in channel for connection with uid.
 	addPresence(Channel, ConnID, ClientInfo) error
@@ -46,6 +49,54 @@ type Engine interface {
 	history(ch Channel, limit int) ([]Message, error)
 }
 
+// recoveryHistoryEngine can be implemented by engines which distinguish history
+// reads used to recover missed messages, for example to read them from consistent
+// storage while ordinary reads can be stale.
+type recoveryHistoryEngine interface {
+	recoveryHistory(ch Channel, limit int) ([]Message, error)
+}
+
+// historyInfoEngine can be implemented by engines which can count messages in
+// channel history without loading all of them.
+type historyInfoEngine interface {
+	// historyInfo returns number of messages in channel history and its newest
+	// and oldest messages, messages are nil when history is empty.
+	historyInfo(ch Channel) (int, *Message, *Message, error)
+}
+
+// tracedPublishEngine can be implemented by engines which record spans of message
+// publish steps. Engine must finish span when publish operation done.
+type tracedPublishEngine interface {
+	publishMessageTraced(Channel, *Message, *ChannelOptions, *span) <-chan error
+}
+
+// patternChannelsEngine can be implemented by engines which can filter active
+// channels by glob pattern themselves instead of returning all active channels.
+type patternChannelsEngine interface {
+	channelsByPattern(pattern string) ([]Channel, error)
+}
+
+// healthCheckEngine can be implemented by engines which depend on external
+// service and can check it is available.
+type healthCheckEngine interface {
+	healthCheck() error
+}
+
+// queueDepthEngine can be implemented by engines which buffer operations in
+// internal queues before sending them to external service.
+type queueDepthEngine interface {
+	queueDepths() map[string]int
+}
+
+// nilErrChan is a closed channel so receiving from it always returns nil error
+// immediately. Engines can return it when operation finished successfully without
+// allocating new channel.
+var nilErrChan = func() chan error {
+	ch := make(chan error)
+	close(ch)
+	return ch
+}()
+
 func decodeEngineClientMessage(data []byte) (*Message, error) {
 	var msg Message
 	err := msg.Unmarshal(data)
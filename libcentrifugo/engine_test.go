@@ -2,6 +2,7 @@ package libcentrifugo
 
 import (
 	"bytes"
+	"strconv"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -51,8 +52,8 @@ func (e *testEngine) publishControl(message *ControlMessage) <-chan error {
 	return eChan
 }
 
-func (e *testEngine) subscribe(ch Channel) error {
-	return nil
+func (e *testEngine) subscribe(ch Channel) <-chan error {
+	return nilErrChan
 }
 
 func (e *testEngine) unsubscribe(ch Channel) error {
@@ -79,6 +80,10 @@ func (e *testEngine) channels() ([]Channel, error) {
 	return []Channel{}, nil
 }
 
+func (e *testEngine) numSubscribers(ch Channel) (int, error) {
+	return 0, nil
+}
+
 func TestEngineEncodeDecode(t *testing.T) {
 	message := newMessage(Channel("encode_decode_test"), []byte("{}"), "", nil)
 	byteMessage, err := encodeEngineClientMessage(message)
@@ -89,6 +94,15 @@ func TestEngineEncodeDecode(t *testing.T) {
 	assert.Equal(t, nil, err)
 	assert.Equal(t, "encode_decode_test", decodedMessage.Channel)
 
+	binaryMessage := newMessage(Channel("encode_decode_test"), []byte{0, 1, 2}, "", nil)
+	binaryMessage.Encoding = PayloadEncodingBinary
+	byteMessage, err = encodeEngineClientMessage(binaryMessage)
+	assert.Equal(t, nil, err)
+	decodedMessage, err = decodeEngineClientMessage(byteMessage)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, PayloadEncodingBinary, decodedMessage.Encoding)
+	assert.Equal(t, []byte{0, 1, 2}, []byte(*decodedMessage.Data))
+
 	joinMessage := newJoinMessage(Channel("encode_decode_test"), ClientInfo{})
 	byteMessage, err = encodeEngineJoinMessage(joinMessage)
 	assert.Equal(t, nil, err)
@@ -125,3 +139,56 @@ func TestEngineEncodeDecode(t *testing.T) {
 	assert.Equal(t, nil, err)
 	assert.Equal(t, "test_encode_decode", decodedAdminMessage.Method)
 }
+
+// benchmarkEnginePublish measures publishing messages with history into channel.
+func benchmarkEnginePublish(b *testing.B, e Engine) {
+	opts := &ChannelOptions{HistorySize: 10, HistoryLifetime: 60}
+	msg := newMessage(Channel("bench"), []byte(`{"input": "test"}`), "", nil)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := <-e.publishMessage(Channel("bench"), msg, opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// benchmarkEngineHistory measures reading full channel history.
+func benchmarkEngineHistory(b *testing.B, e Engine) {
+	opts := &ChannelOptions{HistorySize: 10, HistoryLifetime: 60}
+	for i := 0; i < 10; i++ {
+		msg := newMessage(Channel("bench"), []byte(`{"input": "test"}`), "", nil)
+		if err := <-e.publishMessage(Channel("bench"), msg, opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		history, err := e.history(Channel("bench"), 0)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(history) != 10 {
+			b.Fatal("unexpected history length")
+		}
+	}
+}
+
+// benchmarkEnginePresence measures reading presence of channel with 100 clients.
+func benchmarkEnginePresence(b *testing.B, e Engine) {
+	for i := 0; i < 100; i++ {
+		uid := ConnID(strconv.Itoa(i))
+		if err := e.addPresence(Channel("bench"), uid, ClientInfo{User: string(uid), Client: string(uid)}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		presence, err := e.presence(Channel("bench"))
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(presence) != 100 {
+			b.Fatal("unexpected presence length")
+		}
+	}
+}
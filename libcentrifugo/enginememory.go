@@ -12,32 +12,78 @@ import (
 // MemoryEngine allows to run Centrifugo without using Redis at all. All data managed inside process
 // memory. With this engine you can only run single Centrifugo node. If you need to scale you should
 // use Redis engine instead.
+//
+// Optionally nodes with Memory Engine can send control and admin messages to peers over HTTP so
+// they know about each other and propagate disconnect and unsubscribe commands. Messages published
+// into channels are still delivered only to clients of node they were published on.
 type MemoryEngine struct {
 	app         *Application
 	presenceHub *memoryPresenceHub
 	historyHub  *memoryHistoryHub
+	peers       []*memoryPeer
+}
+
+// MemoryEngineConfig is struct with Memory Engine options.
+type MemoryEngineConfig struct {
+	// Peers is a list of other node URLs (for example http://node2:8000) to send control
+	// and admin messages to. Peer endpoint of nodes is restricted by api_allowed_ips.
+	Peers []string
+	// PeerRetries is a number of retries when sending message to peer failed.
+	PeerRetries int
+	// PeerTimeout is a timeout of HTTP request to peer.
+	PeerTimeout time.Duration
 }
 
 // NewMemoryEngine initializes Memory Engine.
 func NewMemoryEngine(app *Application) *MemoryEngine {
+	return NewMemoryEngineWithConfig(app, nil)
+}
+
+// NewMemoryEngineWithConfig initializes Memory Engine with options.
+func NewMemoryEngineWithConfig(app *Application, conf *MemoryEngineConfig) *MemoryEngine {
 	e := &MemoryEngine{
 		app:         app,
 		presenceHub: newMemoryPresenceHub(),
 		historyHub:  newMemoryHistoryHub(),
 	}
 	e.historyHub.initialize()
+	if conf != nil {
+		for _, url := range conf.Peers {
+			e.peers = append(e.peers, newMemoryPeer(app, url, conf.PeerRetries, conf.PeerTimeout))
+		}
+	}
 	return e
 }
 
 func (e *MemoryEngine) name() string {
+	if len(e.peers) > 0 {
+		return "In memory – control messages sent to peers"
+	}
 	return "In memory – single node only"
 }
 
 func (e *MemoryEngine) run() error {
+	for _, peer := range e.peers {
+		logger.INFO.Printf("Memory Engine peer: %s", peer.url)
+		go peer.run()
+	}
 	return nil
 }
 
+// sendPeers puts message into queues of all peers.
+func (e *MemoryEngine) sendPeers(path string, data []byte) {
+	for _, peer := range e.peers {
+		peer.add(peerMessage{path: path, data: data})
+	}
+}
+
 func (e *MemoryEngine) publishMessage(ch Channel, message *Message, opts *ChannelOptions) <-chan error {
+	return e.publishMessageTraced(ch, message, opts, nil)
+}
+
+// publishMessageTraced saves message into history and broadcasts it to node clients
+// recording both steps as children of span sp.
+func (e *MemoryEngine) publishMessageTraced(ch Channel, message *Message, opts *ChannelOptions, sp *span) <-chan error {
 	hasCurrentSubscribers := e.app.clients.numSubscribers(ch) > 0
 
 	if opts != nil && opts.HistorySize > 0 && opts.HistoryLifetime > 0 {
@@ -46,14 +92,24 @@ func (e *MemoryEngine) publishMessage(ch Channel, message *Message, opts *Channe
 			Lifetime:     opts.HistoryLifetime,
 			DropInactive: (opts.HistoryDropInactive && !hasCurrentSubscribers),
 		}
+		hsp := sp.child("history.add")
 		err := e.historyHub.add(ch, *message, histOpts)
 		if err != nil {
 			logger.ERROR.Println(err)
 		}
+		hsp.setError(err)
+		hsp.finish()
 	}
 
+	bsp := sp.child("broadcast")
+	err := e.app.clientMsg(ch, message)
+	bsp.setError(err)
+	bsp.finish()
+	sp.setError(err)
+	sp.finish()
+
 	eChan := make(chan error, 1)
-	eChan <- e.app.clientMsg(ch, message)
+	eChan <- err
 	return eChan
 }
 
@@ -71,18 +127,34 @@ func (e *MemoryEngine) publishLeave(ch Channel, message *LeaveMessage) <-chan er
 
 func (e *MemoryEngine) publishControl(message *ControlMessage) <-chan error {
 	eChan := make(chan error, 1)
+	if len(e.peers) > 0 {
+		byteMessage, err := encodeEngineControlMessage(message)
+		if err != nil {
+			eChan <- err
+			return eChan
+		}
+		e.sendPeers(peerControlPath, byteMessage)
+	}
 	eChan <- e.app.controlMsg(message)
 	return eChan
 }
 
 func (e *MemoryEngine) publishAdmin(message *AdminMessage) <-chan error {
 	eChan := make(chan error, 1)
+	if len(e.peers) > 0 {
+		byteMessage, err := encodeEngineAdminMessage(message)
+		if err != nil {
+			eChan <- err
+			return eChan
+		}
+		e.sendPeers(peerAdminPath, byteMessage)
+	}
 	eChan <- e.app.adminMsg(message)
 	return eChan
 }
 
-func (e *MemoryEngine) subscribe(ch Channel) error {
-	return nil
+func (e *MemoryEngine) subscribe(ch Channel) <-chan error {
+	return nilErrChan
 }
 
 func (e *MemoryEngine) unsubscribe(ch Channel) error {
@@ -105,10 +177,19 @@ func (e *MemoryEngine) history(ch Channel, limit int) ([]Message, error) {
 	return e.historyHub.get(ch, limit)
 }
 
+func (e *MemoryEngine) historyInfo(ch Channel) (int, *Message, *Message, error) {
+	n, newest, oldest := e.historyHub.info(ch)
+	return n, newest, oldest, nil
+}
+
 func (e *MemoryEngine) channels() ([]Channel, error) {
 	return e.app.clients.channels(), nil
 }
 
+func (e *MemoryEngine) numSubscribers(ch Channel) (int, error) {
+	return e.app.clients.numSubscribers(ch), nil
+}
+
 type memoryPresenceHub struct {
 	sync.RWMutex
 	presence map[Channel]map[ConnID]ClientInfo
@@ -280,6 +361,21 @@ func (h *memoryHistoryHub) add(ch Channel, message Message, opts addHistoryOpts)
 	return nil
 }
 
+// info returns number of messages in channel history and its newest and oldest
+// messages.
+func (h *memoryHistoryHub) info(ch Channel) (int, *Message, *Message) {
+	h.RLock()
+	defer h.RUnlock()
+
+	hItem, ok := h.history[ch]
+	if !ok || hItem.isExpired() || len(hItem.messages) == 0 {
+		return 0, nil, nil
+	}
+	newest := hItem.messages[0]
+	oldest := hItem.messages[len(hItem.messages)-1]
+	return len(hItem.messages), &newest, &oldest
+}
+
 func (h *memoryHistoryHub) get(ch Channel, limit int) ([]Message, error) {
 	h.RLock()
 	defer h.RUnlock()
@@ -33,10 +33,35 @@ func (t *TestConn) channels() []Channel {
 func (t *TestConn) send(message []byte) error {
 	return nil
 }
-func (t *TestConn) unsubscribe(ch Channel) error {
+func (t *TestConn) sendPublication(message []byte) error {
 	return nil
 }
-func (t *TestConn) close(reason string) error {
+func (t *TestConn) unsubscribe(ch Channel, resubscribe bool) error {
+	return nil
+}
+func (t *TestConn) close(reason string, reconnect bool) error {
+	return nil
+}
+func (t *TestConn) transport() string {
+	return ""
+}
+func (t *TestConn) connected() int64 {
+	return 0
+}
+func (t *TestConn) binary() bool {
+	return false
+}
+func (t *TestConn) msgpack() bool {
+	return false
+}
+func (t *TestConn) queueAge() time.Duration {
+	return 0
+}
+
+func (t *TestConn) queuedBytes() int {
+	return 0
+}
+func (t *TestConn) acks() *ackWindow {
 	return nil
 }
 
@@ -55,7 +80,7 @@ func TestMemoryEngine(t *testing.T) {
 	err = <-e.publishMessage(Channel("channel"), newTestMessage(), nil)
 	assert.Equal(t, nil, err)
 
-	assert.Equal(t, nil, e.subscribe(Channel("channel")))
+	assert.Equal(t, nil, <-e.subscribe(Channel("channel")))
 
 	// Memory engine is actually tightly coupled to application hubs in implementation
 	// so calling subscribe on the engine alone is actually a no-op since Application already
@@ -202,6 +227,22 @@ func TestMemoryHistoryHub(t *testing.T) {
 	assert.Equal(t, 1, len(hist))
 }
 
+func TestMemoryHistoryHubInfo(t *testing.T) {
+	h := newMemoryHistoryHub()
+	ch := Channel("channel")
+	n, newest, oldest := h.info(ch)
+	assert.Equal(t, 0, n)
+	assert.Nil(t, newest)
+	assert.Nil(t, oldest)
+	h.add(ch, Message{Timestamp: "100"}, addHistoryOpts{10, 10, false})
+	h.add(ch, Message{Timestamp: "200"}, addHistoryOpts{10, 10, false})
+	h.add(ch, Message{Timestamp: "300"}, addHistoryOpts{10, 10, false})
+	n, newest, oldest = h.info(ch)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, "300", newest.Timestamp)
+	assert.Equal(t, "100", oldest.Timestamp)
+}
+
 func TestMemoryChannels(t *testing.T) {
 	app := testMemoryApp()
 	channels, err := app.engine.channels()
@@ -212,3 +253,15 @@ func TestMemoryChannels(t *testing.T) {
 	assert.Equal(t, nil, err)
 	assert.Equal(t, 10, len(channels))
 }
+
+func BenchmarkMemoryEnginePublish(b *testing.B) {
+	benchmarkEnginePublish(b, testMemoryEngine())
+}
+
+func BenchmarkMemoryEngineHistory(b *testing.B) {
+	benchmarkEngineHistory(b, testMemoryEngine())
+}
+
+func BenchmarkMemoryEnginePresence(b *testing.B) {
+	benchmarkEnginePresence(b, testMemoryEngine())
+}
@@ -0,0 +1,187 @@
+package libcentrifugo
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/FZambia/go-logger"
+	"github.com/centrifugal/centrifugo/libcentrifugo/auth"
+	"github.com/satori/go.uuid"
+)
+
+// peerQueueSize is a max number of messages waiting to be sent to peer. Messages
+// dropped when peer can not keep up.
+const peerQueueSize = 1024
+
+// peerRetryBackoff is a base interval between retries of sending message to peer.
+const peerRetryBackoff = 100 * time.Millisecond
+
+// Paths of peer endpoint for control and admin messages.
+const (
+	peerControlPath = "/peer/control"
+	peerAdminPath   = "/peer/admin"
+)
+
+// peerMessage is encoded control or admin message to send to peer.
+type peerMessage struct {
+	path string
+	data []byte
+}
+
+// memoryPeer is other node Memory Engine sends control and admin messages to.
+type memoryPeer struct {
+	app     *Application
+	url     string
+	client  *http.Client
+	retries int
+	queue   chan peerMessage
+	// healthy is 1 when last message was successfully delivered to peer.
+	healthy int32
+}
+
+func newMemoryPeer(app *Application, url string, retries int, timeout time.Duration) *memoryPeer {
+	return &memoryPeer{
+		app:     app,
+		url:     strings.TrimRight(url, "/"),
+		client:  &http.Client{Timeout: timeout},
+		retries: retries,
+		queue:   make(chan peerMessage, peerQueueSize),
+		healthy: 1,
+	}
+}
+
+// add puts message into peer queue without blocking.
+func (p *memoryPeer) add(msg peerMessage) {
+	select {
+	case p.queue <- msg:
+	default:
+		logger.ERROR.Printf("peer %s queue is full, message dropped", p.url)
+	}
+}
+
+// run sends queued messages to peer until queue closed.
+func (p *memoryPeer) run() {
+	for msg := range p.queue {
+		p.deliver(msg)
+	}
+}
+
+// deliver sends message to peer retrying on errors. Unhealthy peer gets only one
+// attempt so its queue does not grow while it is down.
+func (p *memoryPeer) deliver(msg peerMessage) {
+	attempts := 1
+	if p.isHealthy() {
+		attempts += p.retries
+	}
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			time.Sleep(time.Duration(i) * peerRetryBackoff)
+		}
+		err = p.send(msg)
+		if err == nil {
+			if atomic.SwapInt32(&p.healthy, 1) == 0 {
+				logger.INFO.Printf("peer %s is available again", p.url)
+			}
+			return
+		}
+	}
+	if atomic.SwapInt32(&p.healthy, 0) == 1 {
+		logger.ERROR.Printf("peer %s is unavailable: %v", p.url, err)
+	}
+}
+
+func (p *memoryPeer) send(msg peerMessage) error {
+	p.app.RLock()
+	secret := p.app.config.Secret
+	p.app.RUnlock()
+
+	req, err := http.NewRequest("POST", p.url+msg.path, bytes.NewReader(msg.data))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := uuid.NewV4().String()
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-API-Timestamp", timestamp)
+	req.Header.Set("X-API-Nonce", nonce)
+	req.Header.Set("X-API-Sign", auth.GenerateApiSignWithTimestamp(secret, timestamp, nonce, msg.data))
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("unexpected peer response status: " + resp.Status)
+	}
+	return nil
+}
+
+func (p *memoryPeer) isHealthy() bool {
+	return atomic.LoadInt32(&p.healthy) == 1
+}
+
+// PeerHandler receives control and admin messages sent by Memory Engine of other
+// nodes. Messages must be signed with secret together with timestamp and nonce so
+// captured messages can not be replayed.
+func (app *Application) PeerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	app.RLock()
+	secret := app.config.Secret
+	signWindow := app.config.APISignWindow
+	app.RUnlock()
+
+	timestamp := r.Header.Get("X-API-Timestamp")
+	nonce := r.Header.Get("X-API-Nonce")
+	if secret == "" || timestamp == "" || nonce == "" || !auth.CheckApiSignWithTimestamp(secret, timestamp, nonce, data, r.Header.Get("X-API-Sign")) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	now := time.Now()
+	if !checkAPIRequestTime(timestamp, signWindow, now) || !app.peerNonces.add(nonce, signWindow, now) {
+		logger.ERROR.Println("peer message rejected as expired or replayed")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case strings.HasSuffix(r.URL.Path, peerControlPath):
+		var message *ControlMessage
+		message, err = decodeEngineControlMessage(data)
+		if err == nil {
+			err = app.controlMsg(message)
+		}
+	case strings.HasSuffix(r.URL.Path, peerAdminPath):
+		var message *AdminMessage
+		message, err = decodeEngineAdminMessage(data)
+		if err == nil {
+			err = app.adminMsg(message)
+		}
+	default:
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.ERROR.Println(err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
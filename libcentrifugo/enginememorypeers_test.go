@@ -0,0 +1,164 @@
+package libcentrifugo
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/centrifugal/centrifugo/libcentrifugo/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+// testPeerApps returns two applications with Memory Engine sending control messages
+// to each other over HTTP.
+func testPeerApps(t *testing.T) (*Application, *Application, func()) {
+	conf1 := newTestConfig()
+	conf2 := newTestConfig()
+	app1, _ := NewApplication(&conf1)
+	app2, _ := NewApplication(&conf2)
+	// peer endpoint registered only for Memory Engine so muxes created after
+	// engines set while engines need server URLs.
+	var mux1, mux2 http.Handler
+	server1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux1.ServeHTTP(w, r)
+	}))
+	server2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux2.ServeHTTP(w, r)
+	}))
+	app1.SetEngine(NewMemoryEngineWithConfig(app1, &MemoryEngineConfig{
+		Peers:       []string{server2.URL},
+		PeerRetries: 1,
+		PeerTimeout: time.Second,
+	}))
+	app2.SetEngine(NewMemoryEngineWithConfig(app2, &MemoryEngineConfig{
+		Peers:       []string{server1.URL},
+		PeerRetries: 1,
+		PeerTimeout: time.Second,
+	}))
+	mux1 = DefaultMux(app1, DefaultMuxOptions)
+	mux2 = DefaultMux(app2, DefaultMuxOptions)
+	assert.Equal(t, nil, app1.engine.run())
+	assert.Equal(t, nil, app2.engine.run())
+	return app1, app2, func() {
+		server1.Close()
+		server2.Close()
+	}
+}
+
+// waitCondition polls condition until it is true or timeout reached.
+func waitCondition(condition func() bool) bool {
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if condition() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestMemoryEnginePeersPing(t *testing.T) {
+	app1, app2, closeFn := testPeerApps(t)
+	defer closeFn()
+
+	assert.Equal(t, nil, app1.pubPing())
+	assert.Equal(t, nil, app2.pubPing())
+
+	assert.True(t, waitCondition(func() bool {
+		return len(app1.stats().Nodes) == 2 && len(app2.stats().Nodes) == 2
+	}))
+}
+
+func TestMemoryEnginePeersDisconnect(t *testing.T) {
+	app1, app2, closeFn := testPeerApps(t)
+	defer closeFn()
+
+	createTestClients(app2, 1, 1, nil)
+	assert.Equal(t, 1, app2.clients.nClients())
+
+	assert.Equal(t, nil, app1.Disconnect(UserID("user-0")))
+	assert.True(t, waitCondition(func() bool {
+		return app2.clients.nClients() == 0
+	}))
+}
+
+func TestMemoryEnginePeerHealth(t *testing.T) {
+	app := testMemoryApp()
+	server := httptest.NewServer(DefaultMux(app, DefaultMuxOptions))
+	url := server.URL
+	server.Close()
+
+	peer := newMemoryPeer(app, url, 1, 100*time.Millisecond)
+	msg, err := encodeEngineControlMessage(newControlMessage(app.uid, "ping", nil))
+	assert.Equal(t, nil, err)
+
+	peer.deliver(peerMessage{path: peerControlPath, data: msg})
+	assert.False(t, peer.isHealthy())
+
+	server = httptest.NewServer(DefaultMux(app, DefaultMuxOptions))
+	defer server.Close()
+	peer.url = server.URL
+	peer.deliver(peerMessage{path: peerControlPath, data: msg})
+	assert.True(t, peer.isHealthy())
+}
+
+func TestPeerHandlerSign(t *testing.T) {
+	app := testMemoryApp()
+	server := httptest.NewServer(DefaultMux(app, DefaultMuxOptions))
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+peerControlPath, "application/octet-stream", bytes.NewReader([]byte("data")))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp, err = http.Get(server.URL + peerControlPath)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+	msg, err := encodeEngineControlMessage(newControlMessage(app.uid, "ping", nil))
+	assert.Equal(t, nil, err)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	newRequest := func(timestamp string, nonce string) *http.Request {
+		req, _ := http.NewRequest("POST", server.URL+peerControlPath, bytes.NewReader(msg))
+		req.Header.Set("X-API-Timestamp", timestamp)
+		req.Header.Set("X-API-Nonce", nonce)
+		req.Header.Set("X-API-Sign", auth.GenerateApiSignWithTimestamp(app.config.Secret, timestamp, nonce, msg))
+		return req
+	}
+	resp, err = http.DefaultClient.Do(newRequest(timestamp, "1"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// replayed message rejected.
+	resp, err = http.DefaultClient.Do(newRequest(timestamp, "1"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// old message rejected.
+	resp, err = http.DefaultClient.Do(newRequest(strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10), "2"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestPeerHandlerRegistration(t *testing.T) {
+	// peer endpoint not available with other engines.
+	app := testApp()
+	server := httptest.NewServer(DefaultMux(app, DefaultMuxOptions))
+	defer server.Close()
+	resp, err := http.Post(server.URL+peerControlPath, "application/octet-stream", bytes.NewReader([]byte("data")))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// peer endpoint restricted by api_allowed_ips.
+	c := newTestConfig()
+	c.APIAllowedIPs = []string{"10.0.0.0/8"}
+	app = testMemoryAppWithConfig(&c)
+	server = httptest.NewServer(DefaultMux(app, DefaultMuxOptions))
+	defer server.Close()
+	resp, err = http.Post(server.URL+peerControlPath, "application/octet-stream", bytes.NewReader([]byte("data")))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
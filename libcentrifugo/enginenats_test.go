@@ -0,0 +1,46 @@
+package libcentrifugo
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateNatsConfig(t *testing.T) {
+	c := *DefaultConfig
+	c.Namespaces = []Namespace{Namespace{Name: "test"}}
+	assert.Equal(t, nil, ValidateNatsConfig(&c))
+
+	c.ChannelOptions.Presence = true
+	assert.Equal(t, ErrNatsChannelOptions, ValidateNatsConfig(&c))
+
+	c.ChannelOptions.Presence = false
+	c.Namespaces[0].HistorySize = 10
+	assert.NotEqual(t, nil, ValidateNatsConfig(&c))
+}
+
+func TestNatsEngineChannelFromSubject(t *testing.T) {
+	app := testApp()
+	e := NewNatsEngine(app, &NatsEngineConfig{})
+	ch, msgType := e.channelFromSubject(e.messageSubject(Channel("test")))
+	assert.Equal(t, Channel("test"), ch)
+	assert.Equal(t, "message", msgType)
+	ch, msgType = e.channelFromSubject(e.joinSubject(Channel("test")))
+	assert.Equal(t, Channel("test"), ch)
+	assert.Equal(t, "join", msgType)
+	ch, msgType = e.channelFromSubject(e.leaveSubject(Channel("test")))
+	assert.Equal(t, Channel("test"), ch)
+	assert.Equal(t, "leave", msgType)
+	_, msgType = e.channelFromSubject(e.controlSubject)
+	assert.Equal(t, "unknown", msgType)
+
+	// channel encoded into single subject token without wildcards.
+	subject := e.messageSubject(Channel("news.sport *>"))
+	assert.False(t, strings.ContainsAny(strings.TrimPrefix(subject, e.messagePrefix), ". *>"))
+	ch, msgType = e.channelFromSubject(subject)
+	assert.Equal(t, Channel("news.sport *>"), ch)
+	assert.Equal(t, "message", msgType)
+	_, msgType = e.channelFromSubject(e.messagePrefix + "*")
+	assert.Equal(t, "unknown", msgType)
+}
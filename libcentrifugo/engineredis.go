@@ -8,6 +8,7 @@ import (
 	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/FZambia/go-logger"
@@ -52,21 +53,24 @@ const (
 // connected to the same Redis and load balance clients between instances.
 type RedisEngine struct {
 	sync.RWMutex
-	app               *Application
-	config            *RedisEngineConfig
-	pool              *redis.Pool
-	api               bool
-	numApiShards      int
-	subCh             chan subRequest
-	unSubCh           chan subRequest
-	pubCh             chan *pubRequest
-	pubScript         *redis.Script
-	addPresenceScript *redis.Script
-	remPresenceScript *redis.Script
-	presenceScript    *redis.Script
-	messagePrefix     string
-	joinPrefix        string
-	leavePrefix       string
+	app                *Application
+	config             *RedisEngineConfig
+	pool               *redis.Pool
+	replicaPools       []*redis.Pool
+	replicaIndex       uint32
+	api                bool
+	numApiShards       int
+	subCh              chan subRequest
+	unSubCh            chan subRequest
+	pubCh              chan *pubRequest
+	pubScript          *redis.Script
+	addPresenceScript  *redis.Script
+	remPresenceScript  *redis.Script
+	presenceScript     *redis.Script
+	presenceReadScript *redis.Script
+	messagePrefix      string
+	joinPrefix         string
+	leavePrefix        string
 }
 
 // RedisEngineConfig is struct with Redis Engine options.
@@ -102,8 +106,28 @@ type RedisEngineConfig struct {
 	WriteTimeout time.Duration
 	// Timeout on connect operation
 	ConnectTimeout time.Duration
+
+	// ReplicaURLs is a slice of Redis replica URLs in format redis://:password@hostname:port/db_number.
+	// Replicas used for read operations tolerating slight staleness – history and presence.
+	// If replica not available then read falls back to master.
+	ReplicaURLs []string
+	// ReplicaPoolSize is a size of connection pool for each replica. If zero then PoolSize used.
+	ReplicaPoolSize int
+	// RecoverReadFrom sets where history is read from when recovering messages on
+	// resubscribe – "master" (default) or "replica".
+	RecoverReadFrom string
+
+	// replica is true for configuration of replica connection pool.
+	replica bool
 }
 
+const (
+	// RedisReadFromMaster means that read must be done from Redis master.
+	RedisReadFromMaster = "master"
+	// RedisReadFromReplica means that read can be done from Redis replica.
+	RedisReadFromReplica = "replica"
+)
+
 // subRequest is an internal request to subscribe or unsubscribe from one or more channels
 type subRequest struct {
 	Channel ChannelID
@@ -118,7 +142,9 @@ func newSubRequest(chID ChannelID, wantResponse bool) subRequest {
 		Channel: chID,
 	}
 	if wantResponse {
-		eChan := make(chan error)
+		// Buffered so subscriber goroutine never blocks if caller stopped
+		// waiting for result (for example on timeout).
+		eChan := make(chan error, 1)
 		r.err = &eChan
 	}
 	return r
@@ -139,6 +165,14 @@ func (sr *subRequest) result() error {
 	return <-*(sr.err)
 }
 
+// resultChan returns channel in which result of request will be sent.
+func (sr *subRequest) resultChan() <-chan error {
+	if sr.err == nil {
+		return nilErrChan
+	}
+	return *(sr.err)
+}
+
 func newPool(conf *RedisEngineConfig) *redis.Pool {
 
 	host := conf.Host
@@ -182,7 +216,9 @@ func newPool(conf *RedisEngineConfig) *redis.Pool {
 	if conf.API {
 		shardsSuffix = fmt.Sprintf(", num shard queues: %d", conf.NumAPIShards)
 	}
-	if !useSentinel {
+	if conf.replica {
+		logger.INFO.Printf("Redis replica: %s/%s, pool: %d, using password: %s\n", serverAddr, db, conf.PoolSize, usingPassword)
+	} else if !useSentinel {
 		logger.INFO.Printf("Redis engine: %s/%s, pool: %d, using password: %s, API enabled: %s%s\n", serverAddr, db, conf.PoolSize, usingPassword, apiEnabled, shardsSuffix)
 	} else {
 		logger.INFO.Printf("Redis engine: Sentinel for name: %s, db: %s, pool: %d, using password: %s, API enabled: %s%s\n", conf.MasterName, db, conf.PoolSize, usingPassword, apiEnabled, shardsSuffix)
@@ -285,6 +321,24 @@ func newPool(conf *RedisEngineConfig) *redis.Pool {
 	}
 }
 
+// newReplicaPool creates connection pool for Redis replica. Pool uses the same
+// timeouts as master pool.
+func newReplicaPool(conf *RedisEngineConfig, replicaURL string) *redis.Pool {
+	poolSize := conf.ReplicaPoolSize
+	if poolSize <= 0 {
+		poolSize = conf.PoolSize
+	}
+	replicaConf := &RedisEngineConfig{
+		URL:            replicaURL,
+		PoolSize:       poolSize,
+		ReadTimeout:    conf.ReadTimeout,
+		WriteTimeout:   conf.WriteTimeout,
+		ConnectTimeout: conf.ConnectTimeout,
+		replica:        true,
+	}
+	return newPool(replicaConf)
+}
+
 func yesno(condition bool) string {
 	if condition {
 		return "yes"
@@ -352,21 +406,46 @@ end
 return redis.call("hgetall", KEYS[2])
 	`
 
+// presenceReadSource is a read-only variant of presenceSource which can be
+// executed on replica – expired entries skipped but not removed.
+// KEYS[1] - presence set key
+// KEYS[2] - presence hash key
+// ARGV[1] - now string
+var presenceReadSource = `
+local alive = redis.call("zrangebyscore", KEYS[1], "(" .. ARGV[1], "+inf")
+local result = {}
+for num = 1, #alive do
+  local info = redis.call("hget", KEYS[2], alive[num])
+  if info then
+    result[#result+1] = alive[num]
+    result[#result+1] = info
+  end
+end
+return result
+	`
+
 // NewRedisEngine initializes Redis Engine.
 func NewRedisEngine(app *Application, conf *RedisEngineConfig) *RedisEngine {
 
 	pool := newPool(conf)
 
+	var replicaPools []*redis.Pool
+	for _, replicaURL := range conf.ReplicaURLs {
+		replicaPools = append(replicaPools, newReplicaPool(conf, replicaURL))
+	}
+
 	e := &RedisEngine{
-		app:               app,
-		config:            conf,
-		pool:              pool,
-		api:               conf.API,
-		numApiShards:      conf.NumAPIShards,
-		pubScript:         redis.NewScript(1, pubScriptSource),
-		addPresenceScript: redis.NewScript(2, addPresenceSource),
-		remPresenceScript: redis.NewScript(2, remPresenceSource),
-		presenceScript:    redis.NewScript(2, presenceSource),
+		app:                app,
+		config:             conf,
+		pool:               pool,
+		replicaPools:       replicaPools,
+		api:                conf.API,
+		numApiShards:       conf.NumAPIShards,
+		pubScript:          redis.NewScript(1, pubScriptSource),
+		addPresenceScript:  redis.NewScript(2, addPresenceSource),
+		remPresenceScript:  redis.NewScript(2, remPresenceSource),
+		presenceScript:     redis.NewScript(2, presenceSource),
+		presenceReadScript: redis.NewScript(2, presenceReadSource),
 	}
 	e.pubCh = make(chan *pubRequest, RedisPublishChannelSize)
 	e.subCh = make(chan subRequest, RedisSubscribeChannelSize)
@@ -384,6 +463,16 @@ func (e *RedisEngine) name() string {
 	return "Redis"
 }
 
+// queueDepths returns number of publish and subscription requests waiting to be
+// sent to Redis.
+func (e *RedisEngine) queueDepths() map[string]int {
+	return map[string]int{
+		"publish":     len(e.pubCh),
+		"subscribe":   len(e.subCh),
+		"unsubscribe": len(e.unSubCh),
+	}
+}
+
 func (e *RedisEngine) run() error {
 	e.RLock()
 	api := e.api
@@ -482,10 +571,11 @@ func (e *RedisEngine) runAPI() {
 						continue
 					}
 					for _, command := range req.Data {
-						_, err := e.app.apiCmd(command)
+						resp, err := e.app.apiCmd(command)
 						if err != nil {
 							logger.ERROR.Println(err)
 						}
+						e.app.audit(auditEntry{Source: auditSourceRedisAPI}, command, resp, err)
 					}
 				case <-done:
 					return
@@ -706,9 +796,13 @@ type pubRequest struct {
 	historyKey string
 	opts       *ChannelOptions
 	err        *chan error
+	// span finished when request done if publish traced.
+	span *span
 }
 
 func (pr *pubRequest) done(err error) {
+	pr.span.setError(err)
+	pr.span.finish()
 	*(pr.err) <- err
 }
 
@@ -813,10 +907,19 @@ func (e *RedisEngine) channelFromChannelID(chID ChannelID) (Channel, string) {
 }
 
 func (e *RedisEngine) publishMessage(ch Channel, message *Message, opts *ChannelOptions) <-chan error {
+	return e.publishMessageTraced(ch, message, opts, nil)
+}
+
+// publishMessageTraced sends message to Redis finishing span sp when publish (and
+// history write done by the same script) completed. Message broadcasted to node
+// clients when received back from Redis so broadcast not part of span.
+func (e *RedisEngine) publishMessageTraced(ch Channel, message *Message, opts *ChannelOptions, sp *span) <-chan error {
 	eChan := make(chan error, 1)
 
 	byteMessage, err := encodeEngineClientMessage(message)
 	if err != nil {
+		sp.setError(err)
+		sp.finish()
 		eChan <- err
 		return eChan
 	}
@@ -830,7 +933,9 @@ func (e *RedisEngine) publishMessage(ch Channel, message *Message, opts *Channel
 			historyKey: e.getHistoryKey(chID),
 			opts:       opts,
 			err:        &eChan,
+			span:       sp,
 		}
+		sp.setAttr("history", "true")
 		e.pubCh <- pr
 		return eChan
 	}
@@ -839,6 +944,7 @@ func (e *RedisEngine) publishMessage(ch Channel, message *Message, opts *Channel
 		channel: chID,
 		message: byteMessage,
 		err:     &eChan,
+		span:    sp,
 	}
 	e.pubCh <- pr
 	return eChan
@@ -924,7 +1030,7 @@ func (e *RedisEngine) publishAdmin(message *AdminMessage) <-chan error {
 	return eChan
 }
 
-func (e *RedisEngine) subscribe(ch Channel) error {
+func (e *RedisEngine) subscribe(ch Channel) <-chan error {
 	logger.TRACE.Println("Subscribe node on channel", ch)
 	r := newSubRequest(e.joinChannelID(ch), false)
 	e.subCh <- r
@@ -932,7 +1038,7 @@ func (e *RedisEngine) subscribe(ch Channel) error {
 	e.subCh <- r
 	r = newSubRequest(e.messageChannelID(ch), true)
 	e.subCh <- r
-	return r.result()
+	return r.resultChan()
 }
 
 func (e *RedisEngine) unsubscribe(ch Channel) error {
@@ -1040,14 +1146,65 @@ func mapStringClientInfo(result interface{}, err error) (map[ConnID]ClientInfo,
 	return m, nil
 }
 
-func (e *RedisEngine) presence(ch Channel) (map[ConnID]ClientInfo, error) {
-	chID := e.messageChannelID(ch)
+// replicaPool returns next replica connection pool in round-robin order or
+// nil if no replicas configured.
+func (e *RedisEngine) replicaPool() *redis.Pool {
+	if len(e.replicaPools) == 0 {
+		return nil
+	}
+	idx := atomic.AddUint32(&e.replicaIndex, 1)
+	return e.replicaPools[int(idx)%len(e.replicaPools)]
+}
+
+// redisReplicaErrors are prefixes of Redis error replies meaning that replica can
+// not serve reads at the moment: it loads dataset, lost link to master or is
+// read only while operation needs writes.
+var redisReplicaErrors = []string{"LOADING", "MASTERDOWN", "READONLY"}
+
+// replicaUnavailable checks that error returned by replica means it is unavailable
+// rather than operation failed.
+func replicaUnavailable(err error) bool {
+	redisErr, ok := err.(redis.Error)
+	if !ok {
+		return true
+	}
+	for _, prefix := range redisReplicaErrors {
+		if strings.HasPrefix(string(redisErr), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// readReplica executes read operation on replica if replicas configured and
+// falls back to master pool if replica unreachable or replies it can not serve
+// reads now. Other errors returned by Redis itself are not considered as replica
+// unavailability.
+func (e *RedisEngine) readReplica(replicaFn func(redis.Conn) (interface{}, error), masterFn func(redis.Conn) (interface{}, error)) (interface{}, error) {
+	if pool := e.replicaPool(); pool != nil {
+		conn := pool.Get()
+		reply, err := replicaFn(conn)
+		conn.Close()
+		if err == nil || !replicaUnavailable(err) {
+			return reply, err
+		}
+		logger.ERROR.Printf("error reading from Redis replica, falling back to master: %v", err)
+	}
 	conn := e.pool.Get()
 	defer conn.Close()
+	return masterFn(conn)
+}
+
+func (e *RedisEngine) presence(ch Channel) (map[ConnID]ClientInfo, error) {
+	chID := e.messageChannelID(ch)
 	hashKey := e.getHashKey(chID)
 	setKey := e.getSetKey(chID)
 	now := int(time.Now().Unix())
-	reply, err := e.presenceScript.Do(conn, setKey, hashKey, now)
+	reply, err := e.readReplica(func(conn redis.Conn) (interface{}, error) {
+		return e.presenceReadScript.Do(conn, setKey, hashKey, now)
+	}, func(conn redis.Conn) (interface{}, error) {
+		return e.presenceScript.Do(conn, setKey, hashKey, now)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -1076,15 +1233,34 @@ func sliceOfMessages(result interface{}, err error) ([]Message, error) {
 }
 
 func (e *RedisEngine) history(ch Channel, limit int) ([]Message, error) {
+	return e.readHistory(ch, limit, true)
+}
+
+// recoveryHistory reads history used to recover missed messages. Read done from
+// master unless recover_read_from set to replica.
+func (e *RedisEngine) recoveryHistory(ch Channel, limit int) ([]Message, error) {
+	return e.readHistory(ch, limit, e.config.RecoverReadFrom == RedisReadFromReplica)
+}
+
+func (e *RedisEngine) readHistory(ch Channel, limit int, fromReplica bool) ([]Message, error) {
 	chID := e.messageChannelID(ch)
-	conn := e.pool.Get()
-	defer conn.Close()
 	var rangeBound int = -1
 	if limit > 0 {
 		rangeBound = limit - 1 // Redis includes last index into result
 	}
 	historyKey := e.getHistoryKey(chID)
-	reply, err := conn.Do("LRANGE", historyKey, 0, rangeBound)
+	read := func(conn redis.Conn) (interface{}, error) {
+		return conn.Do("LRANGE", historyKey, 0, rangeBound)
+	}
+	var reply interface{}
+	var err error
+	if fromReplica {
+		reply, err = e.readReplica(read, read)
+	} else {
+		conn := e.pool.Get()
+		reply, err = read(conn)
+		conn.Close()
+	}
 	if err != nil {
 		logger.ERROR.Printf("%#v", err)
 		return nil, err
@@ -1092,8 +1268,108 @@ func (e *RedisEngine) history(ch Channel, limit int) ([]Message, error) {
 	return sliceOfMessages(reply, nil)
 }
 
+// historyInfo uses LLEN to count messages in channel history and reads its newest
+// and oldest messages with LINDEX in one round trip.
+func (e *RedisEngine) historyInfo(ch Channel) (int, *Message, *Message, error) {
+	historyKey := e.getHistoryKey(e.messageChannelID(ch))
+	read := func(conn redis.Conn) (interface{}, error) {
+		conn.Send("LLEN", historyKey)
+		conn.Send("LINDEX", historyKey, 0)
+		conn.Send("LINDEX", historyKey, -1)
+		replies, err := redis.Values(conn.Do(""))
+		if err != nil {
+			return nil, err
+		}
+		for _, reply := range replies {
+			if err, ok := reply.(redis.Error); ok {
+				return nil, err
+			}
+		}
+		return replies, nil
+	}
+	reply, err := e.readReplica(read, read)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	replies := reply.([]interface{})
+	n, err := redis.Int(replies[0], nil)
+	if err != nil || n == 0 {
+		return 0, nil, nil, err
+	}
+	var messages [2]*Message
+	for i, value := range replies[1:] {
+		data, ok := value.([]byte)
+		if !ok {
+			// history trimmed or expired between commands.
+			return 0, nil, nil, nil
+		}
+		var m Message
+		if err := m.Unmarshal(data); err != nil {
+			return 0, nil, nil, errors.New("can not unmarshal value to Message")
+		}
+		messages[i] = &m
+	}
+	return n, messages[0], messages[1], nil
+}
+
 // Requires Redis >= 2.8.0 (http://redis.io/commands/pubsub)
 func (e *RedisEngine) channels() ([]Channel, error) {
+	return e.channelsByPattern("*")
+}
+
+// numSubscribers uses PUBSUB NUMSUB to get number of nodes subscribed on channel and
+// asks other nodes for their subscriber counts only when some of them subscribed.
+// Nodes which have not answered in time are counted as having one subscriber.
+func (e *RedisEngine) numSubscribers(ch Channel) (int, error) {
+	conn := e.pool.Get()
+	defer conn.Close()
+
+	reply, err := conn.Do("PUBSUB", "NUMSUB", e.messageChannelID(ch))
+	if err != nil {
+		return 0, err
+	}
+
+	values, err := redis.Values(reply, err)
+	if err != nil {
+		return 0, err
+	}
+	if len(values) != 2 {
+		return 0, errors.New("wrong PUBSUB NUMSUB reply length")
+	}
+	numNodes, err := redis.Int(values[1], nil)
+	if err != nil {
+		return 0, err
+	}
+
+	local := e.app.clients.numSubscribers(ch)
+	if numNodes == 0 {
+		return local, nil
+	}
+	if local > 0 {
+		numNodes--
+	}
+	if numNodes == 0 {
+		return local, nil
+	}
+	remote, numReported := e.app.remoteSubscribers(ch)
+	numSubscribers := local + remote
+	if numNodes > numReported {
+		numSubscribers += numNodes - numReported
+	}
+	return numSubscribers, nil
+}
+
+// healthCheck sends PING to Redis through connection pool.
+func (e *RedisEngine) healthCheck() error {
+	conn := e.pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("PING")
+	return err
+}
+
+// channelsByPattern passes pattern to Redis PUBSUB CHANNELS so only matching
+// channels transferred from Redis.
+func (e *RedisEngine) channelsByPattern(pattern string) ([]Channel, error) {
 	conn := e.pool.Get()
 	defer conn.Close()
 
@@ -1101,7 +1377,7 @@ func (e *RedisEngine) channels() ([]Channel, error) {
 	joinPrefix := e.joinPrefix
 	leavePrefix := e.leavePrefix
 
-	reply, err := conn.Do("PUBSUB", "CHANNELS", messagePrefix+"*")
+	reply, err := conn.Do("PUBSUB", "CHANNELS", messagePrefix+pattern)
 	if err != nil {
 		return nil, err
 	}
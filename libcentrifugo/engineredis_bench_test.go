@@ -0,0 +1,41 @@
+//go:build redis
+// +build redis
+
+package libcentrifugo
+
+import (
+	"testing"
+)
+
+// Redis engine benchmarks need Redis server on 127.0.0.1:6379 with empty
+// database 9, run them with go test -tags redis -run ^$ -bench RedisEngine.
+
+func benchRedisEngine(b *testing.B) (*RedisEngine, testRedisConn) {
+	c := dial()
+	app := testApp()
+	e := testRedisEngine(app)
+	if err := e.run(); err != nil {
+		c.close()
+		b.Fatal(err)
+	}
+	app.SetEngine(e)
+	return e, c
+}
+
+func BenchmarkRedisEnginePublish(b *testing.B) {
+	e, c := benchRedisEngine(b)
+	defer c.close()
+	benchmarkEnginePublish(b, e)
+}
+
+func BenchmarkRedisEngineHistory(b *testing.B) {
+	e, c := benchRedisEngine(b)
+	defer c.close()
+	benchmarkEngineHistory(b, e)
+}
+
+func BenchmarkRedisEnginePresence(b *testing.B) {
+	e, c := benchRedisEngine(b)
+	defer c.close()
+	benchmarkEnginePresence(b, e)
+}
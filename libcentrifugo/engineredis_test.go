@@ -1,9 +1,14 @@
 package libcentrifugo
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
+	"io"
 	"net"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -96,7 +101,7 @@ func TestRedisEngine(t *testing.T) {
 
 	err = <-e.publishMessage(Channel("channel"), testMsg, nil)
 	assert.Equal(t, nil, err)
-	assert.Equal(t, nil, e.subscribe(Channel("channel")))
+	assert.Equal(t, nil, <-e.subscribe(Channel("channel")))
 	// Now we've subscribed...
 	err = <-e.publishMessage(Channel("channel"), testMsg, nil)
 	assert.Equal(t, nil, e.unsubscribe(Channel("channel")))
@@ -230,3 +235,216 @@ func TestHandleClientMessage(t *testing.T) {
 	err = e.handleRedisClientMessage(chID, byteLeaveMsg)
 	assert.Equal(t, nil, err)
 }
+
+// testRedisServer is a minimal Redis protocol server which records received
+// commands except PING and replies with empty results.
+type testRedisServer struct {
+	sync.Mutex
+	listener net.Listener
+	commands []string
+	// errReply if set is returned as error reply to all commands.
+	errReply string
+}
+
+func newTestRedisServer(t *testing.T) *testRedisServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testRedisServer{listener: l}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *testRedisServer) url() string {
+	return "redis://" + s.listener.Addr().String() + "/0"
+}
+
+func (s *testRedisServer) close() {
+	s.listener.Close()
+}
+
+func (s *testRedisServer) received() []string {
+	s.Lock()
+	defer s.Unlock()
+	commands := make([]string, len(s.commands))
+	copy(commands, s.commands)
+	return commands
+}
+
+func (s *testRedisServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readTestRedisCommand(r)
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(args[0])
+		if command == "PING" {
+			// pool checks borrowed connections with PING, do not record it.
+			conn.Write([]byte("+PONG\r\n"))
+			continue
+		}
+		s.Lock()
+		s.commands = append(s.commands, command)
+		errReply := s.errReply
+		s.Unlock()
+		switch {
+		case errReply != "":
+			conn.Write([]byte("-" + errReply + "\r\n"))
+		case command == "EVALSHA":
+			conn.Write([]byte("-NOSCRIPT No matching script\r\n"))
+		case command == "LLEN":
+			conn.Write([]byte(":0\r\n"))
+		case command == "LINDEX":
+			conn.Write([]byte("$-1\r\n"))
+		default:
+			conn.Write([]byte("*0\r\n"))
+		}
+	}
+}
+
+func readTestRedisCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := 0; i < n; i++ {
+		line, err = r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err = io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func testReplicaEngine(master *testRedisServer, replica *testRedisServer, recoverReadFrom string) *RedisEngine {
+	app := testMemoryApp()
+	conf := &RedisEngineConfig{
+		URL:             master.url(),
+		PoolSize:        2,
+		ConnectTimeout:  time.Second,
+		ReadTimeout:     time.Second,
+		WriteTimeout:    time.Second,
+		ReplicaURLs:     []string{replica.url()},
+		RecoverReadFrom: recoverReadFrom,
+	}
+	return NewRedisEngine(app, conf)
+}
+
+func TestReplicaReadRouting(t *testing.T) {
+	master := newTestRedisServer(t)
+	defer master.close()
+	replica := newTestRedisServer(t)
+	defer replica.close()
+
+	e := testReplicaEngine(master, replica, RedisReadFromMaster)
+
+	_, err := e.history(Channel("test"), 0)
+	assert.Equal(t, nil, err)
+	_, err = e.presence(Channel("test"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"LRANGE", "EVALSHA", "EVAL"}, replica.received())
+	assert.Equal(t, 0, len(master.received()))
+
+	// recovery reads go to master by default.
+	_, err = e.recoveryHistory(Channel("test"), 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"LRANGE"}, master.received())
+
+	e = testReplicaEngine(master, replica, RedisReadFromReplica)
+	_, err = e.recoveryHistory(Channel("test"), 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"LRANGE"}, master.received())
+	assert.Equal(t, 4, len(replica.received()))
+}
+
+func TestReplicaReadFallback(t *testing.T) {
+	master := newTestRedisServer(t)
+	defer master.close()
+	replica := newTestRedisServer(t)
+
+	e := testReplicaEngine(master, replica, RedisReadFromMaster)
+	replica.close()
+
+	_, err := e.history(Channel("test"), 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"LRANGE"}, master.received())
+	assert.Equal(t, 0, len(replica.received()))
+}
+
+func TestReplicaReadFallbackErrorReply(t *testing.T) {
+	master := newTestRedisServer(t)
+	defer master.close()
+	replica := newTestRedisServer(t)
+	defer replica.close()
+
+	e := testReplicaEngine(master, replica, RedisReadFromMaster)
+	for _, reply := range []string{
+		"LOADING Redis is loading the dataset in memory",
+		"MASTERDOWN Link with MASTER is down and replica-serve-stale-data is set to 'no'.",
+		"READONLY You can't write against a read only replica.",
+	} {
+		replica.Lock()
+		replica.errReply = reply
+		replica.Unlock()
+		_, err := e.history(Channel("test"), 0)
+		assert.Equal(t, nil, err, reply)
+	}
+	assert.Equal(t, []string{"LRANGE", "LRANGE", "LRANGE"}, master.received())
+
+	// other errors of replica returned as is.
+	replica.Lock()
+	replica.errReply = "WRONGTYPE Operation against a key holding the wrong kind of value"
+	replica.Unlock()
+	_, err := e.history(Channel("test"), 0)
+	assert.NotEqual(t, nil, err)
+	assert.Equal(t, 3, len(master.received()))
+}
+
+func TestReplicaHistoryInfo(t *testing.T) {
+	master := newTestRedisServer(t)
+	defer master.close()
+	replica := newTestRedisServer(t)
+	defer replica.close()
+
+	e := testReplicaEngine(master, replica, RedisReadFromMaster)
+	n, newest, oldest, err := e.historyInfo(Channel("test"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, n)
+	assert.Nil(t, newest)
+	assert.Nil(t, oldest)
+	assert.Equal(t, []string{"LLEN", "LINDEX", "LINDEX"}, replica.received())
+	assert.Equal(t, 0, len(master.received()))
+
+	// error replies in pipeline make reads fall back to master too.
+	replica.Lock()
+	replica.errReply = "LOADING Redis is loading the dataset in memory"
+	replica.Unlock()
+	_, _, _, err = e.historyInfo(Channel("test"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"LLEN", "LINDEX", "LINDEX"}, master.received())
+}
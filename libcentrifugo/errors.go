@@ -30,6 +30,17 @@ var (
 	ErrNotAvailable = errors.New("not available")
 	// ErrSendTimeout means that timeout occurred when sending message into connection.
 	ErrSendTimeout = errors.New("send timeout")
+	// ErrSubscribeTimeout means that engine did not subscribe node on channel in time.
+	ErrSubscribeTimeout = errors.New("subscribe timeout")
+	// ErrConnectionExpired means that connection credentials expired or refresh
+	// timestamp does not extend connection lifetime.
+	ErrConnectionExpired = errors.New("connection expired")
+	// ErrTooMuchChurn means that connection subscribes and unsubscribes too often,
+	// client should retry subscribe later.
+	ErrTooMuchChurn = errors.New("too much churn")
+	// ErrQueueFull means that internal queue has no space for request, request
+	// should be retried later.
+	ErrQueueFull = errors.New("queue full")
 	// ErrClientClosed means that client connection already closed.
 	ErrClientClosed = errors.New("client is closed")
 )
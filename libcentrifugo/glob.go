@@ -0,0 +1,87 @@
+package libcentrifugo
+
+// globMatch reports whether channel name matches glob pattern. Pattern syntax is
+// the same as in Redis PUBSUB CHANNELS and KEYS commands so patterns behave in the
+// same way with all engines: * matches any sequence of bytes, ? matches a single
+// byte, [abc], [a-z] and [^a] match byte classes and \ escapes special character.
+func globMatch(pattern, name string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(name); i++ {
+				if globMatch(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(name) == 0 {
+				return false
+			}
+			pattern = pattern[1:]
+			name = name[1:]
+		case '[':
+			if len(name) == 0 {
+				return false
+			}
+			matched, rest, ok := globMatchClass(pattern[1:], name[0])
+			if !ok || !matched {
+				return false
+			}
+			pattern = rest
+			name = name[1:]
+		default:
+			if pattern[0] == '\\' && len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			if len(name) == 0 || name[0] != pattern[0] {
+				return false
+			}
+			pattern = pattern[1:]
+			name = name[1:]
+		}
+	}
+	return len(name) == 0
+}
+
+// globMatchClass matches byte c against class pattern following opening bracket.
+// It returns pattern left after closing bracket, ok is false when class is not
+// terminated.
+func globMatchClass(pattern string, c byte) (matched bool, rest string, ok bool) {
+	negate := len(pattern) > 0 && pattern[0] == '^'
+	if negate {
+		pattern = pattern[1:]
+	}
+	for i := 0; i < len(pattern); {
+		if pattern[i] == ']' {
+			return matched != negate, pattern[i+1:], true
+		}
+		lo := pattern[i]
+		if lo == '\\' && i+1 < len(pattern) {
+			i++
+			lo = pattern[i]
+		}
+		if i+2 < len(pattern) && pattern[i+1] == '-' && pattern[i+2] != ']' {
+			hi := pattern[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			if c >= lo && c <= hi {
+				matched = true
+			}
+			i += 3
+			continue
+		}
+		if c == lo {
+			matched = true
+		}
+		i++
+	}
+	return false, "", false
+}
@@ -0,0 +1,34 @@
+package libcentrifugo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		match   bool
+	}{
+		{"*", "", true},
+		{"*", "news:sport", true},
+		{"news:*", "news:sport", true},
+		{"news:*", "user#42", false},
+		{"news:*:live", "news:sport/football:live", true},
+		{"user#?", "user#4", true},
+		{"user#?", "user#42", false},
+		{"channel-[0-4]", "channel-3", true},
+		{"channel-[0-4]", "channel-7", false},
+		{"channel-[^0-4]", "channel-7", true},
+		{"channel-[ab]", "channel-b", true},
+		{"channel-[ab", "channel-a", false},
+		{`news\*`, "news*", true},
+		{`news\*`, "news:sport", false},
+		{"news", "news:sport", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.match, globMatch(tt.pattern, tt.name), tt.pattern+" "+tt.name)
+	}
+}
@@ -0,0 +1,553 @@
+package libcentrifugo
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/FZambia/go-logger"
+	"github.com/gogo/protobuf/proto"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpccredentials "google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// Messages of gRPC API, see grpcapi.proto for schema.
+
+// PublishRequest is a request of Publish method publishing data into channel.
+// Data is JSON encoded like data of HTTP API publish command, Async publishes
+// through API async queue.
+type PublishRequest struct {
+	Channel  string `protobuf:"bytes,1,opt,name=channel,proto3" json:"channel"`
+	Data     []byte `protobuf:"bytes,2,opt,name=data,proto3" json:"data"`
+	Client   string `protobuf:"bytes,3,opt,name=client,proto3" json:"client"`
+	Encoding string `protobuf:"bytes,4,opt,name=encoding,proto3" json:"encoding"`
+	Async    bool   `protobuf:"varint,5,opt,name=async,proto3" json:"async"`
+}
+
+func (m *PublishRequest) Reset()         { *m = PublishRequest{} }
+func (m *PublishRequest) String() string { return proto.CompactTextString(m) }
+func (*PublishRequest) ProtoMessage()    {}
+
+// PublishReply contains UID of published message.
+type PublishReply struct {
+	UID string `protobuf:"bytes,1,opt,name=uid,proto3" json:"uid"`
+}
+
+func (m *PublishReply) Reset()         { *m = PublishReply{} }
+func (m *PublishReply) String() string { return proto.CompactTextString(m) }
+func (*PublishReply) ProtoMessage()    {}
+
+// BroadcastRequest is a request of Broadcast method publishing the same data into
+// many channels.
+type BroadcastRequest struct {
+	Channels []string `protobuf:"bytes,1,rep,name=channels" json:"channels"`
+	Data     []byte   `protobuf:"bytes,2,opt,name=data,proto3" json:"data"`
+	Client   string   `protobuf:"bytes,3,opt,name=client,proto3" json:"client"`
+	Async    bool     `protobuf:"varint,4,opt,name=async,proto3" json:"async"`
+}
+
+func (m *BroadcastRequest) Reset()         { *m = BroadcastRequest{} }
+func (m *BroadcastRequest) String() string { return proto.CompactTextString(m) }
+func (*BroadcastRequest) ProtoMessage()    {}
+
+// BroadcastReply is a reply of Broadcast method.
+type BroadcastReply struct{}
+
+func (m *BroadcastReply) Reset()         { *m = BroadcastReply{} }
+func (m *BroadcastReply) String() string { return proto.CompactTextString(m) }
+func (*BroadcastReply) ProtoMessage()    {}
+
+// UnsubscribeRequest is a request of Unsubscribe method unsubscribing user from
+// channel, from all channels when Channel is empty.
+type UnsubscribeRequest struct {
+	Channel string `protobuf:"bytes,1,opt,name=channel,proto3" json:"channel"`
+	User    string `protobuf:"bytes,2,opt,name=user,proto3" json:"user"`
+}
+
+func (m *UnsubscribeRequest) Reset()         { *m = UnsubscribeRequest{} }
+func (m *UnsubscribeRequest) String() string { return proto.CompactTextString(m) }
+func (*UnsubscribeRequest) ProtoMessage()    {}
+
+// UnsubscribeReply contains number of connections unsubscribed.
+type UnsubscribeReply struct {
+	Affected int32 `protobuf:"varint,1,opt,name=affected,proto3" json:"affected"`
+}
+
+func (m *UnsubscribeReply) Reset()         { *m = UnsubscribeReply{} }
+func (m *UnsubscribeReply) String() string { return proto.CompactTextString(m) }
+func (*UnsubscribeReply) ProtoMessage()    {}
+
+// DisconnectRequest is a request of Disconnect method disconnecting user.
+type DisconnectRequest struct {
+	User      string `protobuf:"bytes,1,opt,name=user,proto3" json:"user"`
+	Reconnect bool   `protobuf:"varint,2,opt,name=reconnect,proto3" json:"reconnect"`
+}
+
+func (m *DisconnectRequest) Reset()         { *m = DisconnectRequest{} }
+func (m *DisconnectRequest) String() string { return proto.CompactTextString(m) }
+func (*DisconnectRequest) ProtoMessage()    {}
+
+// DisconnectReply is a reply of Disconnect method.
+type DisconnectReply struct{}
+
+func (m *DisconnectReply) Reset()         { *m = DisconnectReply{} }
+func (m *DisconnectReply) String() string { return proto.CompactTextString(m) }
+func (*DisconnectReply) ProtoMessage()    {}
+
+// PresenceRequest is a request of Presence method.
+type PresenceRequest struct {
+	Channel string `protobuf:"bytes,1,opt,name=channel,proto3" json:"channel"`
+}
+
+func (m *PresenceRequest) Reset()         { *m = PresenceRequest{} }
+func (m *PresenceRequest) String() string { return proto.CompactTextString(m) }
+func (*PresenceRequest) ProtoMessage()    {}
+
+// PresenceReply contains info of clients subscribed on channel by client ID.
+type PresenceReply struct {
+	Presence map[string]*ClientInfo `protobuf:"bytes,1,rep,name=presence" json:"presence" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value"`
+}
+
+func (m *PresenceReply) Reset()         { *m = PresenceReply{} }
+func (m *PresenceReply) String() string { return proto.CompactTextString(m) }
+func (*PresenceReply) ProtoMessage()    {}
+
+// PresenceStatsRequest is a request of PresenceStats method.
+type PresenceStatsRequest struct {
+	Channel string `protobuf:"bytes,1,opt,name=channel,proto3" json:"channel"`
+}
+
+func (m *PresenceStatsRequest) Reset()         { *m = PresenceStatsRequest{} }
+func (m *PresenceStatsRequest) String() string { return proto.CompactTextString(m) }
+func (*PresenceStatsRequest) ProtoMessage()    {}
+
+// PresenceStatsReply contains number of clients and unique users in channel.
+type PresenceStatsReply struct {
+	NumClients int32 `protobuf:"varint,1,opt,name=num_clients,proto3" json:"num_clients"`
+	NumUsers   int32 `protobuf:"varint,2,opt,name=num_users,proto3" json:"num_users"`
+}
+
+func (m *PresenceStatsReply) Reset()         { *m = PresenceStatsReply{} }
+func (m *PresenceStatsReply) String() string { return proto.CompactTextString(m) }
+func (*PresenceStatsReply) ProtoMessage()    {}
+
+// HistoryRequest is a request of History method.
+type HistoryRequest struct {
+	Channel string `protobuf:"bytes,1,opt,name=channel,proto3" json:"channel"`
+}
+
+func (m *HistoryRequest) Reset()         { *m = HistoryRequest{} }
+func (m *HistoryRequest) String() string { return proto.CompactTextString(m) }
+func (*HistoryRequest) ProtoMessage()    {}
+
+// HistoryReply contains messages kept in channel history, newest first.
+type HistoryReply struct {
+	Publications []*Message `protobuf:"bytes,1,rep,name=publications" json:"publications"`
+}
+
+func (m *HistoryReply) Reset()         { *m = HistoryReply{} }
+func (m *HistoryReply) String() string { return proto.CompactTextString(m) }
+func (*HistoryReply) ProtoMessage()    {}
+
+// ChannelsRequest is a request of Channels method returning active channels
+// matching optional glob Pattern, at most Limit channels returned when set.
+type ChannelsRequest struct {
+	Pattern string `protobuf:"bytes,1,opt,name=pattern,proto3" json:"pattern"`
+	Limit   int32  `protobuf:"varint,2,opt,name=limit,proto3" json:"limit"`
+}
+
+func (m *ChannelsRequest) Reset()         { *m = ChannelsRequest{} }
+func (m *ChannelsRequest) String() string { return proto.CompactTextString(m) }
+func (*ChannelsRequest) ProtoMessage()    {}
+
+// ChannelsReply contains active channels, Truncated set when limit of channels
+// reached.
+type ChannelsReply struct {
+	Channels  []string `protobuf:"bytes,1,rep,name=channels" json:"channels"`
+	Truncated bool     `protobuf:"varint,2,opt,name=truncated,proto3" json:"truncated"`
+}
+
+func (m *ChannelsReply) Reset()         { *m = ChannelsReply{} }
+func (m *ChannelsReply) String() string { return proto.CompactTextString(m) }
+func (*ChannelsReply) ProtoMessage()    {}
+
+// grpcCodec encodes gRPC messages with gogo protobuf as Message and ClientInfo
+// have fields of custom types.
+type grpcCodec struct{}
+
+func (grpcCodec) Marshal(v interface{}) ([]byte, error) {
+	return proto.Marshal(v.(proto.Message))
+}
+
+func (grpcCodec) Unmarshal(data []byte, v interface{}) error {
+	return proto.Unmarshal(data, v.(proto.Message))
+}
+
+func (grpcCodec) String() string {
+	return "proto"
+}
+
+// CentrifugoAPIServer is a server of CentrifugoAPI gRPC service.
+type CentrifugoAPIServer interface {
+	Publish(context.Context, *PublishRequest) (*PublishReply, error)
+	Broadcast(context.Context, *BroadcastRequest) (*BroadcastReply, error)
+	Unsubscribe(context.Context, *UnsubscribeRequest) (*UnsubscribeReply, error)
+	Disconnect(context.Context, *DisconnectRequest) (*DisconnectReply, error)
+	Presence(context.Context, *PresenceRequest) (*PresenceReply, error)
+	PresenceStats(context.Context, *PresenceStatsRequest) (*PresenceStatsReply, error)
+	History(context.Context, *HistoryRequest) (*HistoryReply, error)
+	Channels(context.Context, *ChannelsRequest) (*ChannelsReply, error)
+}
+
+const grpcAPIServiceName = "libcentrifugo.CentrifugoAPI"
+
+// grpcUnaryMethod returns description of unary gRPC API method. Request decoded
+// into message returned by newRequest and passed to call.
+func grpcUnaryMethod(name string, newRequest func() interface{}, call func(CentrifugoAPIServer, context.Context, interface{}) (interface{}, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			in := newRequest()
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return call(srv.(CentrifugoAPIServer), ctx, req)
+			}
+			if interceptor == nil {
+				return handler(ctx, in)
+			}
+			info := &grpc.UnaryServerInfo{
+				Server:     srv,
+				FullMethod: "/" + grpcAPIServiceName + "/" + name,
+			}
+			return interceptor(ctx, in, info, handler)
+		},
+	}
+}
+
+var grpcAPIServiceDesc = grpc.ServiceDesc{
+	ServiceName: grpcAPIServiceName,
+	HandlerType: (*CentrifugoAPIServer)(nil),
+	Methods: []grpc.MethodDesc{
+		grpcUnaryMethod("Publish", func() interface{} { return new(PublishRequest) }, func(s CentrifugoAPIServer, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.Publish(ctx, req.(*PublishRequest))
+		}),
+		grpcUnaryMethod("Broadcast", func() interface{} { return new(BroadcastRequest) }, func(s CentrifugoAPIServer, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.Broadcast(ctx, req.(*BroadcastRequest))
+		}),
+		grpcUnaryMethod("Unsubscribe", func() interface{} { return new(UnsubscribeRequest) }, func(s CentrifugoAPIServer, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.Unsubscribe(ctx, req.(*UnsubscribeRequest))
+		}),
+		grpcUnaryMethod("Disconnect", func() interface{} { return new(DisconnectRequest) }, func(s CentrifugoAPIServer, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.Disconnect(ctx, req.(*DisconnectRequest))
+		}),
+		grpcUnaryMethod("Presence", func() interface{} { return new(PresenceRequest) }, func(s CentrifugoAPIServer, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.Presence(ctx, req.(*PresenceRequest))
+		}),
+		grpcUnaryMethod("PresenceStats", func() interface{} { return new(PresenceStatsRequest) }, func(s CentrifugoAPIServer, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.PresenceStats(ctx, req.(*PresenceStatsRequest))
+		}),
+		grpcUnaryMethod("History", func() interface{} { return new(HistoryRequest) }, func(s CentrifugoAPIServer, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.History(ctx, req.(*HistoryRequest))
+		}),
+		grpcUnaryMethod("Channels", func() interface{} { return new(ChannelsRequest) }, func(s CentrifugoAPIServer, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.Channels(ctx, req.(*ChannelsRequest))
+		}),
+	},
+	Streams: []grpc.StreamDesc{},
+}
+
+// GRPCAPIServer returns gRPC server of API. Calls run the same API commands as
+// HTTP API requests do, options usually contain TLS credentials of server.
+func (app *Application) GRPCAPIServer(opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts, grpc.CustomCodec(grpcCodec{}))
+	server := grpc.NewServer(opts...)
+	server.RegisterService(&grpcAPIServiceDesc, &grpcAPIServer{app: app})
+	return server
+}
+
+// grpcAPIServer implements CentrifugoAPIServer.
+type grpcAPIServer struct {
+	app *Application
+}
+
+// grpcError converts error returned by API command into gRPC status error.
+func grpcError(err error) error {
+	code := codes.Internal
+	switch err {
+	case ErrInvalidMessage:
+		code = codes.InvalidArgument
+	case ErrUnauthorized:
+		code = codes.Unauthenticated
+	case ErrPermissionDenied:
+		code = codes.PermissionDenied
+	case ErrMethodNotFound:
+		code = codes.Unimplemented
+	case ErrNamespaceNotFound:
+		code = codes.NotFound
+	case ErrNotAvailable:
+		code = codes.FailedPrecondition
+	case ErrLimitExceeded, ErrQueueFull:
+		code = codes.ResourceExhausted
+	}
+	return grpc.Errorf(code, "%s", err.Error())
+}
+
+// grpcPeerAddr returns IP address of gRPC API client.
+func grpcPeerAddr(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	addr := p.Addr.String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// grpcAddrAllowed checks address of gRPC API client against api_allowed_ips.
+// Connections over unix socket have no address and considered coming from
+// loopback like HTTP requests.
+func (app *Application) grpcAddrAllowed(addr string) bool {
+	app.RLock()
+	allowed := app.ipFilter.api
+	app.RUnlock()
+	if len(allowed) == 0 {
+		return true
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		ip = net.IPv4(127, 0, 0, 1)
+	}
+	return ipInNets(ip, allowed)
+}
+
+// grpcAPIAuth checks credentials of gRPC API call. Calls over TLS connection
+// with verified client certificate allowed, otherwise authorization metadata
+// must contain project secret or secret of API key named in x-api-key metadata.
+func (app *Application) grpcAPIAuth(ctx context.Context) (*APIKey, error) {
+	app.RLock()
+	defer app.RUnlock()
+	if app.config.InsecureAPI {
+		return nil, nil
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(grpccredentials.TLSInfo); ok && len(info.State.VerifiedChains) > 0 {
+			return nil, nil
+		}
+	}
+	md, _ := metadata.FromContext(ctx)
+	secret := app.config.Secret
+	var key *APIKey
+	if names := md["x-api-key"]; len(names) > 0 {
+		k, ok := app.config.apiKey(names[0])
+		if !ok {
+			logger.ERROR.Printf("unknown API key %s", names[0])
+			return nil, ErrUnauthorized
+		}
+		key = &k
+		secret = k.Secret
+	}
+	var token string
+	if values := md["authorization"]; len(values) > 0 && strings.HasPrefix(values[0], "apikey ") {
+		token = strings.TrimPrefix(values[0], "apikey ")
+	}
+	if secret == "" || token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+		logger.ERROR.Println("invalid gRPC API credentials")
+		return nil, ErrUnauthorized
+	}
+	return key, nil
+}
+
+// grpcAPICmd runs API command with params on behalf of gRPC API call. API key
+// permissions, rate limits and audit log applied like for HTTP API requests.
+// Error set in command response returned as error.
+func (app *Application) grpcAPICmd(ctx context.Context, method string, params interface{}) (response, error) {
+	app.metrics.NumAPIRequests.Inc()
+
+	addr := grpcPeerAddr(ctx)
+	if !app.grpcAddrAllowed(addr) {
+		logger.ERROR.Printf("gRPC API call %s from %s rejected as address not allowed", method, addr)
+		app.metrics.NumIPDenied.Inc()
+		return nil, grpcError(ErrPermissionDenied)
+	}
+
+	key, err := app.grpcAPIAuth(ctx)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	data, err := json.Marshal(params)
+	if err != nil {
+		logger.ERROR.Println(err)
+		return nil, grpcError(ErrInvalidMessage)
+	}
+	command := apiCommand{Method: method, Params: data}
+
+	if key != nil {
+		app.metrics.apiKeyRequests.inc(key.Name)
+	}
+
+	app.RLock()
+	apiRate := app.config.APIRequestsPerSecond
+	apiBurst := app.config.APIRequestsBurst
+	app.RUnlock()
+	if apiRate > 0 {
+		limitKey := "addr:" + addr
+		if key != nil {
+			limitKey = "key:" + key.Name
+		}
+		if allowed, _ := app.apiRateLimiter.allow(limitKey, apiRate, apiBurst, time.Now()); !allowed {
+			app.metrics.NumAPIRateLimited.Inc()
+			return nil, grpc.Errorf(codes.ResourceExhausted, "too many requests")
+		}
+	}
+
+	entry := auditEntry{Source: auditSourceAPI, RemoteAddr: addr}
+	if key != nil {
+		entry.APIKey = key.Name
+		if !key.allows(command) {
+			logger.ERROR.Printf("API key %s not allowed to run %s command", key.Name, command.Method)
+			resp := newAPIErrorResponse(command.Method, responseError{ErrPermissionDenied, errorAdviceNone})
+			app.audit(entry, command, resp, nil)
+			return nil, grpcError(ErrPermissionDenied)
+		}
+	}
+
+	resp, err := app.apiCmd(command)
+	app.audit(entry, command, resp, err)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if r, ok := resp.(errResponse); ok && r.responseErr() != nil {
+		return nil, grpcError(r.responseErr())
+	}
+	return resp, nil
+}
+
+func (s *grpcAPIServer) Publish(ctx context.Context, req *PublishRequest) (*PublishReply, error) {
+	resp, err := s.app.grpcAPICmd(ctx, "publish", publishAPICommand{
+		Channel:  Channel(req.Channel),
+		Client:   ConnID(req.Client),
+		Data:     json.RawMessage(req.Data),
+		Encoding: req.Encoding,
+		Async:    req.Async,
+	})
+	if err != nil {
+		return nil, err
+	}
+	reply := &PublishReply{}
+	if body, ok := resp.(*apiPublishResponse).Body.(apiPublishBody); ok {
+		reply.UID = body.UID
+	}
+	return reply, nil
+}
+
+func (s *grpcAPIServer) Broadcast(ctx context.Context, req *BroadcastRequest) (*BroadcastReply, error) {
+	channels := make([]Channel, len(req.Channels))
+	for i, ch := range req.Channels {
+		channels[i] = Channel(ch)
+	}
+	_, err := s.app.grpcAPICmd(ctx, "broadcast", broadcastAPICommand{
+		Channels: channels,
+		Data:     json.RawMessage(req.Data),
+		Client:   ConnID(req.Client),
+		Async:    req.Async,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &BroadcastReply{}, nil
+}
+
+func (s *grpcAPIServer) Unsubscribe(ctx context.Context, req *UnsubscribeRequest) (*UnsubscribeReply, error) {
+	resp, err := s.app.grpcAPICmd(ctx, "unsubscribe", unsubscribeAPICommand{
+		Channel: Channel(req.Channel),
+		User:    UserID(req.User),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &UnsubscribeReply{Affected: int32(resp.(*apiUnsubscribeResponse).Body.Affected)}, nil
+}
+
+func (s *grpcAPIServer) Disconnect(ctx context.Context, req *DisconnectRequest) (*DisconnectReply, error) {
+	_, err := s.app.grpcAPICmd(ctx, "disconnect", disconnectAPICommand{
+		User:      UserID(req.User),
+		Reconnect: req.Reconnect,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &DisconnectReply{}, nil
+}
+
+// presence runs presence command returning presence of channel.
+func (s *grpcAPIServer) presence(ctx context.Context, ch string) (map[ConnID]ClientInfo, error) {
+	resp, err := s.app.grpcAPICmd(ctx, "presence", presenceAPICommand{Channel: Channel(ch)})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*apiPresenceResponse).Body.Data, nil
+}
+
+func (s *grpcAPIServer) Presence(ctx context.Context, req *PresenceRequest) (*PresenceReply, error) {
+	presence, err := s.presence(ctx, req.Channel)
+	if err != nil {
+		return nil, err
+	}
+	reply := &PresenceReply{Presence: make(map[string]*ClientInfo, len(presence))}
+	for uid, info := range presence {
+		info := info
+		reply.Presence[string(uid)] = &info
+	}
+	return reply, nil
+}
+
+func (s *grpcAPIServer) PresenceStats(ctx context.Context, req *PresenceStatsRequest) (*PresenceStatsReply, error) {
+	presence, err := s.presence(ctx, req.Channel)
+	if err != nil {
+		return nil, err
+	}
+	users := map[string]struct{}{}
+	for _, info := range presence {
+		users[info.User] = struct{}{}
+	}
+	return &PresenceStatsReply{NumClients: int32(len(presence)), NumUsers: int32(len(users))}, nil
+}
+
+func (s *grpcAPIServer) History(ctx context.Context, req *HistoryRequest) (*HistoryReply, error) {
+	resp, err := s.app.grpcAPICmd(ctx, "history", historyAPICommand{Channel: Channel(req.Channel)})
+	if err != nil {
+		return nil, err
+	}
+	history := resp.(*apiHistoryResponse).Body.Data
+	reply := &HistoryReply{Publications: make([]*Message, len(history))}
+	for i := range history {
+		reply.Publications[i] = &history[i]
+	}
+	return reply, nil
+}
+
+func (s *grpcAPIServer) Channels(ctx context.Context, req *ChannelsRequest) (*ChannelsReply, error) {
+	resp, err := s.app.grpcAPICmd(ctx, "channels", channelsAPICommand{
+		Pattern: req.Pattern,
+		Limit:   int(req.Limit),
+	})
+	if err != nil {
+		return nil, err
+	}
+	body := resp.(*apiChannelsResponse).Body
+	reply := &ChannelsReply{Channels: make([]string, len(body.Data)), Truncated: body.Truncated}
+	for i, ch := range body.Data {
+		reply.Channels[i] = string(ch)
+	}
+	return reply, nil
+}
@@ -0,0 +1,121 @@
+package libcentrifugo
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+func testGRPCAPI(t *testing.T, app *Application) (*grpc.ClientConn, func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	server := app.GRPCAPIServer()
+	go server.Serve(ln)
+	conn, err := grpc.Dial(ln.Addr().String(), grpc.WithInsecure(), grpc.WithCodec(grpcCodec{}))
+	assert.Equal(t, nil, err)
+	return conn, func() {
+		conn.Close()
+		server.Stop()
+	}
+}
+
+func grpcAPIContext(pairs ...string) context.Context {
+	return metadata.NewContext(context.Background(), metadata.Pairs(pairs...))
+}
+
+func TestGRPCAPI(t *testing.T) {
+	app := testMemoryApp()
+	conn, stop := testGRPCAPI(t, app)
+	defer stop()
+
+	ctx := grpcAPIContext("authorization", "apikey secret")
+	publish := &PublishRequest{Channel: "test", Data: []byte(`{"input": "test"}`)}
+	var publishReply PublishReply
+	err := grpc.Invoke(ctx, "/libcentrifugo.CentrifugoAPI/Publish", publish, &publishReply, conn)
+	assert.Equal(t, nil, err)
+	assert.NotEqual(t, "", publishReply.UID)
+
+	var historyReply HistoryReply
+	err = grpc.Invoke(ctx, "/libcentrifugo.CentrifugoAPI/History", &HistoryRequest{Channel: "test"}, &historyReply, conn)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(historyReply.Publications))
+	assert.Equal(t, publishReply.UID, historyReply.Publications[0].UID)
+	assert.Equal(t, `{"input":"test"}`, string(*historyReply.Publications[0].Data))
+
+	app.addPresence("test", "uid1", ClientInfo{User: "user1", Client: "uid1"})
+	app.addPresence("test", "uid2", ClientInfo{User: "user1", Client: "uid2"})
+	var presenceReply PresenceReply
+	err = grpc.Invoke(ctx, "/libcentrifugo.CentrifugoAPI/Presence", &PresenceRequest{Channel: "test"}, &presenceReply, conn)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(presenceReply.Presence))
+	assert.Equal(t, "user1", presenceReply.Presence["uid1"].User)
+	var statsReply PresenceStatsReply
+	err = grpc.Invoke(ctx, "/libcentrifugo.CentrifugoAPI/PresenceStats", &PresenceStatsRequest{Channel: "test"}, &statsReply, conn)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int32(2), statsReply.NumClients)
+	assert.Equal(t, int32(1), statsReply.NumUsers)
+
+	// invalid JSON data rejected.
+	publish.Data = []byte("{")
+	err = grpc.Invoke(ctx, "/libcentrifugo.CentrifugoAPI/Publish", publish, &publishReply, conn)
+	assert.Equal(t, codes.InvalidArgument, grpc.Code(err))
+
+	// error of command returned as status.
+	err = grpc.Invoke(ctx, "/libcentrifugo.CentrifugoAPI/History", &HistoryRequest{Channel: "unknown:test"}, &historyReply, conn)
+	assert.Equal(t, codes.NotFound, grpc.Code(err))
+}
+
+func TestGRPCAPIAuth(t *testing.T) {
+	app := testMemoryApp()
+	app.config.APIKeys = []APIKey{{Name: "backend", Secret: "key_secret", Methods: []string{"publish"}}}
+	conn, stop := testGRPCAPI(t, app)
+	defer stop()
+
+	request := &ChannelsRequest{}
+	var reply ChannelsReply
+	err := grpc.Invoke(context.Background(), "/libcentrifugo.CentrifugoAPI/Channels", request, &reply, conn)
+	assert.Equal(t, codes.Unauthenticated, grpc.Code(err))
+	err = grpc.Invoke(grpcAPIContext("authorization", "apikey wrong"), "/libcentrifugo.CentrifugoAPI/Channels", request, &reply, conn)
+	assert.Equal(t, codes.Unauthenticated, grpc.Code(err))
+	err = grpc.Invoke(grpcAPIContext("authorization", "apikey secret"), "/libcentrifugo.CentrifugoAPI/Channels", request, &reply, conn)
+	assert.Equal(t, nil, err)
+
+	// API key allows only methods listed.
+	ctx := grpcAPIContext("authorization", "apikey key_secret", "x-api-key", "backend")
+	err = grpc.Invoke(ctx, "/libcentrifugo.CentrifugoAPI/Channels", request, &reply, conn)
+	assert.Equal(t, codes.PermissionDenied, grpc.Code(err))
+	var publishReply PublishReply
+	err = grpc.Invoke(ctx, "/libcentrifugo.CentrifugoAPI/Publish", &PublishRequest{Channel: "test", Data: []byte("{}")}, &publishReply, conn)
+	assert.Equal(t, nil, err)
+	err = grpc.Invoke(grpcAPIContext("authorization", "apikey secret", "x-api-key", "backend"), "/libcentrifugo.CentrifugoAPI/Publish", &PublishRequest{Channel: "test", Data: []byte("{}")}, &publishReply, conn)
+	assert.Equal(t, codes.Unauthenticated, grpc.Code(err))
+
+	app.config.InsecureAPI = true
+	err = grpc.Invoke(context.Background(), "/libcentrifugo.CentrifugoAPI/Channels", request, &reply, conn)
+	assert.Equal(t, nil, err)
+}
+
+func TestGRPCAPIAllowedIPs(t *testing.T) {
+	app := testMemoryApp()
+	c := *app.config
+	c.APIAllowedIPs = []string{"10.0.0.0/8"}
+	app.SetConfig(&c)
+	conn, stop := testGRPCAPI(t, app)
+	defer stop()
+
+	ctx := grpcAPIContext("authorization", "apikey secret")
+	var reply ChannelsReply
+	err := grpc.Invoke(ctx, "/libcentrifugo.CentrifugoAPI/Channels", &ChannelsRequest{}, &reply, conn)
+	assert.Equal(t, codes.PermissionDenied, grpc.Code(err))
+	assert.Equal(t, int64(1), app.metrics.NumIPDenied.LoadRaw())
+
+	c.APIAllowedIPs = []string{"127.0.0.1"}
+	app.SetConfig(&c)
+	err = grpc.Invoke(ctx, "/libcentrifugo.CentrifugoAPI/Channels", &ChannelsRequest{}, &reply, conn)
+	assert.Equal(t, nil, err)
+}
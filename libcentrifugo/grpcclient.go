@@ -0,0 +1,210 @@
+package libcentrifugo
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/FZambia/go-logger"
+	"github.com/gogo/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// Command is a message sent by client over gRPC stream, see grpcclient.proto
+// for schema.
+type Command struct {
+	// UID is an optional command id copied into reply to match it with command.
+	UID string `protobuf:"bytes,1,opt,name=uid,proto3" json:"uid"`
+	// Method is a name of client command, the same as in Websocket protocol.
+	Method string `protobuf:"bytes,2,opt,name=method,proto3" json:"method"`
+	// Params is a JSON encoded object with command parameters.
+	Params []byte `protobuf:"bytes,3,opt,name=params,proto3" json:"params"`
+}
+
+func (m *Command) Reset()         { *m = Command{} }
+func (m *Command) String() string { return proto.CompactTextString(m) }
+func (*Command) ProtoMessage()    {}
+
+// Reply is a message sent to client over gRPC stream, see grpcclient.proto
+// for schema.
+type Reply struct {
+	// Data is a JSON encoded response or asynchronous message, empty in pings.
+	Data []byte `protobuf:"bytes,1,opt,name=data,proto3" json:"data"`
+}
+
+func (m *Reply) Reset()         { *m = Reply{} }
+func (m *Reply) String() string { return proto.CompactTextString(m) }
+func (*Reply) ProtoMessage()    {}
+
+// CentrifugoClientServer is a server of CentrifugoClient gRPC service.
+type CentrifugoClientServer interface {
+	Communicate(grpc.ServerStream) error
+}
+
+var grpcClientServiceDesc = grpc.ServiceDesc{
+	ServiceName: "libcentrifugo.CentrifugoClient",
+	HandlerType: (*CentrifugoClientServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName: "Communicate",
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				return srv.(CentrifugoClientServer).Communicate(stream)
+			},
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+}
+
+// GRPCClientServer returns gRPC server of client transport, options usually
+// contain TLS credentials of server.
+func (app *Application) GRPCClientServer(opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts, grpc.CustomCodec(grpcCodec{}))
+	server := grpc.NewServer(opts...)
+	server.RegisterService(&grpcClientServiceDesc, &grpcClientServer{app: app})
+	return server
+}
+
+// grpcClientServer implements CentrifugoClientServer.
+type grpcClientServer struct {
+	app *Application
+}
+
+// grpcSession is a session of client connected over gRPC bidirectional stream.
+// Messages sent to client as Reply messages.
+type grpcSession struct {
+	mu sync.Mutex
+	// sendMu serializes stream writes. Separate from mu so session can be
+	// stopped while write blocked by slow client.
+	sendMu  sync.Mutex
+	stream  grpc.ServerStream
+	closed  bool
+	closeCh chan struct{}
+}
+
+func newGRPCSession(stream grpc.ServerStream) *grpcSession {
+	return &grpcSession{
+		stream:  stream,
+		closeCh: make(chan struct{}),
+	}
+}
+
+func (sess *grpcSession) Send(message []byte) error {
+	sess.mu.Lock()
+	closed := sess.closed
+	sess.mu.Unlock()
+	if closed {
+		return nil
+	}
+	sess.sendMu.Lock()
+	defer sess.sendMu.Unlock()
+	return sess.stream.SendMsg(&Reply{Data: message})
+}
+
+// Close stops session, stream finished when handler returns. Disconnect
+// message with reason already sent to client by this moment.
+func (sess *grpcSession) Close(status uint32, reason string) error {
+	sess.stop()
+	return nil
+}
+
+// ping sends empty reply to keep stream alive.
+func (sess *grpcSession) ping() error {
+	return sess.Send(nil)
+}
+
+// stop marks session closed so nothing sent into stream after handler returned.
+func (sess *grpcSession) stop() {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.closed {
+		return
+	}
+	sess.closed = true
+	close(sess.closeCh)
+}
+
+// Communicate handles client connection over gRPC stream. Every received
+// Command handled like command sent over Websocket.
+func (s *grpcClientServer) Communicate(stream grpc.ServerStream) error {
+	app := s.app
+	if !app.acquireConnection() {
+		return grpc.Errorf(codes.Unavailable, errTooManyClients)
+	}
+	defer app.clients.release()
+
+	app.RLock()
+	pingInterval := app.config.PingInterval
+	app.RUnlock()
+
+	sess := newGRPCSession(stream)
+	defer sess.stop()
+
+	c, err := newClient(app, sess)
+	if err != nil {
+		return grpc.Errorf(codes.Internal, "%s", err.Error())
+	}
+	c.transportName = "grpc"
+	c.remoteAddr = grpcPeerAddr(stream.Context())
+	logger.DEBUG.Printf("New gRPC session established with uid %s\n", c.uid())
+	defer c.teardown("connection closed")
+
+	// commands read in separate goroutine as stream has no read deadline, it
+	// stops when stream finished after handler returned.
+	commands := make(chan *Command)
+	go func() {
+		defer close(commands)
+		for {
+			cmd := &Command{}
+			if err := stream.RecvMsg(cmd); err != nil {
+				return
+			}
+			select {
+			case commands <- cmd:
+			case <-sess.closeCh:
+				return
+			}
+		}
+	}()
+
+	var ticker *time.Ticker
+	var tick <-chan time.Time
+	if pingInterval > 0 {
+		ticker = time.NewTicker(pingInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case cmd, ok := <-commands:
+			if !ok {
+				return nil
+			}
+			command := clientCommand{
+				UID:    cmd.UID,
+				Method: cmd.Method,
+				Params: json.RawMessage(cmd.Params),
+			}
+			if len(command.Params) == 0 {
+				command.Params = nil
+			}
+			msg, err := json.Marshal(command)
+			if err != nil {
+				logger.ERROR.Println(err)
+				msg = nil
+			}
+			if err := c.message(msg); err != nil {
+				sess.Close(CloseStatus, disconnectReason(err.Error(), shouldReconnect(err)))
+				return nil
+			}
+		case <-sess.closeCh:
+			return nil
+		case <-tick:
+			if err := sess.ping(); err != nil {
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package libcentrifugo
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+func testGRPCClientStream(t *testing.T, app *Application) (grpc.ClientStream, func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	server := app.GRPCClientServer()
+	go server.Serve(ln)
+	conn, err := grpc.Dial(ln.Addr().String(), grpc.WithInsecure(), grpc.WithCodec(grpcCodec{}))
+	assert.Equal(t, nil, err)
+	stream, err := grpc.NewClientStream(context.Background(), &grpcClientServiceDesc.Streams[0], conn, "/libcentrifugo.CentrifugoClient/Communicate")
+	assert.Equal(t, nil, err)
+	return stream, func() {
+		conn.Close()
+		server.Stop()
+	}
+}
+
+// grpcReplies reads replies from stream in background skipping pings.
+func grpcReplies(stream grpc.ClientStream) <-chan string {
+	replies := make(chan string)
+	go func() {
+		defer close(replies)
+		for {
+			reply := &Reply{}
+			if err := stream.RecvMsg(reply); err != nil {
+				return
+			}
+			if len(reply.Data) == 0 {
+				continue
+			}
+			replies <- string(reply.Data)
+		}
+	}()
+	return replies
+}
+
+func readGRPCReply(t *testing.T, replies <-chan string) string {
+	select {
+	case reply, ok := <-replies:
+		if !ok {
+			t.Fatal("stream closed")
+		}
+		return reply
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for reply")
+	}
+	return ""
+}
+
+func TestGRPCClient(t *testing.T) {
+	app := testMemoryApp()
+	stream, stop := testGRPCClientStream(t, app)
+	defer stop()
+	replies := grpcReplies(stream)
+
+	connect := testConnectCmd("1234567890")
+	err := stream.SendMsg(&Command{Method: connect.Method, Params: connect.Params})
+	assert.Equal(t, nil, err)
+	reply := readGRPCReply(t, replies)
+	assert.True(t, strings.Contains(reply, `"method":"connect"`))
+	assert.False(t, strings.Contains(reply, `"error"`))
+
+	params, _ := json.Marshal(subscribeClientCommand{Channel: "test"})
+	err = stream.SendMsg(&Command{UID: "1", Method: "subscribe", Params: params})
+	assert.Equal(t, nil, err)
+	reply = readGRPCReply(t, replies)
+	assert.True(t, strings.Contains(reply, `"uid":"1"`))
+	assert.True(t, strings.Contains(reply, `"method":"subscribe"`))
+	assert.Equal(t, 1, app.clients.nClients())
+
+	err = app.Publish("test", []byte(`{"input":"test"}`), "", nil)
+	assert.Equal(t, nil, err)
+	reply = readGRPCReply(t, replies)
+	assert.True(t, strings.Contains(reply, `"method":"message"`))
+	assert.True(t, strings.Contains(reply, `{"input":"test"}`))
+
+	// connection closed when client finishes sending.
+	err = stream.CloseSend()
+	assert.Equal(t, nil, err)
+	for range replies {
+	}
+	for i := 0; i < 100 && app.clients.nClients() > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 0, app.clients.nClients())
+}
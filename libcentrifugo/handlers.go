@@ -1,11 +1,15 @@
 package libcentrifugo
 
 import (
+	"container/list"
 	"encoding/json"
 	"io/ioutil"
+	"math"
 	"net/http"
 	"net/http/pprof"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/FZambia/go-logger"
@@ -28,6 +32,10 @@ const (
 	HandlerAdmin
 	// HandlerDebug enables debug handlers.
 	HandlerDebug
+	// HandlerHealth enables health check handler.
+	HandlerHealth
+	// HandlerSSE enables Server-Sent Events (EventSource) handler.
+	HandlerSSE
 )
 
 var handlerText = map[HandlerFlag]string{
@@ -36,10 +44,12 @@ var handlerText = map[HandlerFlag]string{
 	HandlerAPI:    "API",
 	HandlerAdmin:  "admin",
 	HandlerDebug:  "debug",
+	HandlerHealth: "health",
+	HandlerSSE:    "SSE",
 }
 
 func (flags HandlerFlag) String() string {
-	flagsOrdered := []HandlerFlag{HandlerRawWS, HandlerSockJS, HandlerAPI, HandlerAdmin, HandlerDebug}
+	flagsOrdered := []HandlerFlag{HandlerRawWS, HandlerSockJS, HandlerAPI, HandlerAdmin, HandlerDebug, HandlerHealth, HandlerSSE}
 	endpoints := []string{}
 	for _, flag := range flagsOrdered {
 		text, ok := handlerText[flag]
@@ -66,7 +76,7 @@ type MuxOptions struct {
 
 // DefaultMuxOptions contain default SockJS options.
 var DefaultMuxOptions = MuxOptions{
-	HandlerFlags:  HandlerRawWS | HandlerSockJS | HandlerAPI | HandlerAdmin,
+	HandlerFlags:  HandlerRawWS | HandlerSockJS | HandlerAPI | HandlerAdmin | HandlerHealth | HandlerSSE,
 	SockjsOptions: sockjs.DefaultOptions,
 }
 
@@ -88,40 +98,59 @@ func DefaultMux(app *Application, muxOpts MuxOptions) *http.ServeMux {
 		mux.Handle(prefix+"/debug/pprof/profile", http.HandlerFunc(pprof.Profile))
 		mux.Handle(prefix+"/debug/pprof/symbol", http.HandlerFunc(pprof.Symbol))
 		mux.Handle(prefix+"/debug/pprof/trace", http.HandlerFunc(pprof.Trace))
+		mux.Handle(prefix+"/debug/vars", http.HandlerFunc(app.DebugVarsHandler))
+	}
+
+	if flags&HandlerHealth != 0 {
+		// register health check endpoint, it is not wrapped with shutdown handler
+		// as it reports draining status itself.
+		mux.Handle(prefix+"/health", http.HandlerFunc(app.HealthHandler))
 	}
 
 	if flags&HandlerRawWS != 0 {
 		// register raw Websocket endpoint.
-		mux.Handle(prefix+"/connection/websocket", app.Logged(app.WrapShutdown(http.HandlerFunc(app.RawWebsocketHandler))))
+		mux.Handle(prefix+"/connection/websocket", app.Logged(app.WrapShutdown(app.WrapCORS(http.HandlerFunc(app.RawWebsocketHandler), false))))
+	}
+
+	if flags&HandlerSSE != 0 {
+		// register Server-Sent Events endpoint.
+		mux.Handle(prefix+"/connection/sse", app.Logged(app.WrapShutdown(app.WrapCORS(http.HandlerFunc(app.SSEHandler), false))))
 	}
 
 	if flags&HandlerSockJS != 0 {
 		// register SockJS endpoints.
 		sjsh := NewSockJSHandler(app, prefix+"/connection", muxOpts.SockjsOptions)
-		mux.Handle(prefix+"/connection/", app.Logged(app.WrapShutdown(sjsh)))
+		mux.Handle(prefix+"/connection/", app.Logged(app.WrapShutdown(app.WrapCORS(sjsh, false))))
 	}
 
 	if flags&HandlerAPI != 0 {
 		// register HTTP API endpoint.
-		mux.Handle(prefix+"/api/", app.Logged(app.WrapShutdown(http.HandlerFunc(app.APIHandler))))
+		mux.Handle(prefix+"/api/", app.Logged(app.WrapShutdown(app.WrapAllowedIPs(app.WrapCORS(http.HandlerFunc(app.APIHandler), true), false))))
+		app.RLock()
+		_, memoryEngine := app.engine.(*MemoryEngine)
+		app.RUnlock()
+		if memoryEngine {
+			// register endpoint receiving control and admin messages from Memory Engine peers.
+			mux.Handle(prefix+"/peer/", app.Logged(app.WrapShutdown(app.WrapAllowedIPs(http.HandlerFunc(app.PeerHandler), false))))
+		}
 	}
 
 	if admin && flags&HandlerAdmin != 0 {
 		// register admin websocket endpoint.
-		mux.Handle(prefix+"/socket", app.Logged(http.HandlerFunc(app.AdminWebsocketHandler)))
+		mux.Handle(prefix+"/socket", app.Logged(app.WrapAllowedIPs(http.HandlerFunc(app.AdminWebsocketHandler), true)))
 
 		// optionally serve admin web interface.
 		if web {
 			// register admin web interface API endpoints.
-			mux.Handle(prefix+"/auth/", app.Logged(http.HandlerFunc(app.AuthHandler)))
+			mux.Handle(prefix+"/auth/", app.Logged(app.WrapAllowedIPs(http.HandlerFunc(app.AuthHandler), true)))
 
 			// serve web interface single-page application.
 			if webPath != "" {
 				webPrefix := prefix + "/"
-				mux.Handle(webPrefix, http.StripPrefix(webPrefix, http.FileServer(http.Dir(webPath))))
+				mux.Handle(webPrefix, app.WrapAllowedIPs(http.StripPrefix(webPrefix, http.FileServer(http.Dir(webPath))), true))
 			} else if webFS != nil {
 				webPrefix := prefix + "/"
-				mux.Handle(webPrefix, http.StripPrefix(webPrefix, http.FileServer(webFS)))
+				mux.Handle(webPrefix, app.WrapAllowedIPs(http.StripPrefix(webPrefix, http.FileServer(webFS)), true))
 			}
 		}
 	}
@@ -133,7 +162,133 @@ func DefaultMux(app *Application, muxOpts MuxOptions) *http.ServeMux {
 // SockJS handler has several handlers inside responsible for various tasks
 // according to SockJS protocol.
 func NewSockJSHandler(app *Application, sockjsPrefix string, sockjsOpts sockjs.Options) http.Handler {
-	return sockjs.NewHandler(sockjsPrefix, sockjsOpts, app.sockJSHandler)
+	transports := newSockjsTransports()
+	handler := sockjs.NewHandler(sockjsPrefix, sockjsOpts, func(s sockjs.Session) {
+		info := transports.start(s.ID())
+		defer transports.end(s.ID())
+		app.sockJSHandler(s, info)
+	})
+	transports.addr = app.requestAddr
+	transports.headers = app.connectProxyHeaders
+	return transports.wrap(sockjsPrefix, handler)
+}
+
+const (
+	// sockjsPendingTTL is how long info of SockJS session which has not
+	// started yet is remembered.
+	sockjsPendingTTL = time.Minute
+	// sockjsMaxPending is max number of remembered sessions which have not
+	// started yet, oldest are evicted first.
+	sockjsMaxPending = 10000
+)
+
+// sockjsSessionInfo contains information about SockJS session request.
+type sockjsSessionInfo struct {
+	transport  string
+	remoteAddr string
+	headers    http.Header
+}
+
+type sockjsPendingSession struct {
+	id      string
+	info    sockjsSessionInfo
+	expires time.Time
+}
+
+// sockjsTransports remembers SockJS transport name and remote address for every
+// session as sockjs.Session does not expose them. Info of session kept in bounded
+// pending list until session started so requests with random session IDs which
+// never establish session do not grow memory, then session only marked active
+// until it ends so its following requests are not remembered again.
+type sockjsTransports struct {
+	sync.Mutex
+	ll      *list.List
+	pending map[string]*list.Element
+	active  map[string]struct{}
+	// addr returns remote address of request.
+	addr func(r *http.Request) string
+	// headers returns request headers remembered for session.
+	headers func(r *http.Request) http.Header
+}
+
+func newSockjsTransports() *sockjsTransports {
+	return &sockjsTransports{
+		ll:      list.New(),
+		pending: make(map[string]*list.Element),
+		active:  make(map[string]struct{}),
+	}
+}
+
+// wrap returns handler which extracts session ID and transport name from
+// SockJS URL in format {prefix}/{server}/{session}/{transport} before passing
+// request to SockJS handler. Remote address of first session request remembered.
+func (t *sockjsTransports) wrap(prefix string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.Trim(strings.TrimPrefix(r.URL.Path, prefix), "/")
+		parts := strings.Split(path, "/")
+		if len(parts) == 3 {
+			sessionID, transport := parts[1], parts[2]
+			// send requests do not establish session.
+			if transport != "xhr_send" && transport != "jsonp_send" {
+				info := sockjsSessionInfo{
+					transport:  transport,
+					remoteAddr: t.addr(r),
+				}
+				if t.headers != nil {
+					info.headers = t.headers(r)
+				}
+				t.remember(sessionID, info, time.Now())
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// remember saves info of session if session is not known yet.
+func (t *sockjsTransports) remember(sessionID string, info sockjsSessionInfo, now time.Time) {
+	t.Lock()
+	defer t.Unlock()
+	// pending sessions ordered by expiration time, newest at front.
+	for el := t.ll.Back(); el != nil; el = t.ll.Back() {
+		entry := el.Value.(*sockjsPendingSession)
+		if now.Before(entry.expires) && t.ll.Len() < sockjsMaxPending {
+			break
+		}
+		t.ll.Remove(el)
+		delete(t.pending, entry.id)
+	}
+	if _, ok := t.active[sessionID]; ok {
+		return
+	}
+	if _, ok := t.pending[sessionID]; ok {
+		return
+	}
+	t.pending[sessionID] = t.ll.PushFront(&sockjsPendingSession{
+		id:      sessionID,
+		info:    info,
+		expires: now.Add(sockjsPendingTTL),
+	})
+}
+
+// start returns info remembered for session and marks session active.
+func (t *sockjsTransports) start(sessionID string) sockjsSessionInfo {
+	t.Lock()
+	defer t.Unlock()
+	t.active[sessionID] = struct{}{}
+	el, ok := t.pending[sessionID]
+	if !ok {
+		return sockjsSessionInfo{}
+	}
+	t.ll.Remove(el)
+	delete(t.pending, sessionID)
+	return el.Value.(*sockjsPendingSession).info
+}
+
+// end forgets session.
+func (t *sockjsTransports) end(sessionID string) {
+	t.Lock()
+	defer t.Unlock()
+	delete(t.active, sessionID)
 }
 
 type sockjsConn struct {
@@ -162,7 +317,12 @@ func (conn *sockjsConn) Close(status uint32, reason string) error {
 }
 
 // sockJSHandler called when new client connection comes to SockJS endpoint.
-func (app *Application) sockJSHandler(s sockjs.Session) {
+func (app *Application) sockJSHandler(s sockjs.Session, info sockjsSessionInfo) {
+	if !app.acquireConnection() {
+		s.Close(CloseStatusTryAgainLater, disconnectReason(errTooManyClients, true))
+		return
+	}
+	defer app.clients.release()
 
 	conn := newSockjsConn(s)
 	defer close(conn.closeCh)
@@ -172,7 +332,10 @@ func (app *Application) sockJSHandler(s sockjs.Session) {
 		logger.ERROR.Println(err)
 		return
 	}
-	defer c.clean()
+	c.transportName = info.transport
+	c.remoteAddr = info.remoteAddr
+	c.headers = info.headers
+	defer c.teardown("connection closed")
 	logger.DEBUG.Printf("New SockJS session established with uid %s\n", c.uid())
 
 	for {
@@ -180,7 +343,7 @@ func (app *Application) sockJSHandler(s sockjs.Session) {
 			err = c.message([]byte(msg))
 			if err != nil {
 				logger.ERROR.Println(err)
-				s.Close(CloseStatus, "error handling message")
+				s.Close(CloseStatus, disconnectReason(err.Error(), shouldReconnect(err)))
 				break
 			}
 			continue
@@ -189,10 +352,80 @@ func (app *Application) sockJSHandler(s sockjs.Session) {
 	}
 }
 
+// errTooManyClients is a reason new connection rejected with when node reached
+// max_clients limit.
+const errTooManyClients = "too many connections"
+
+// acquireConnection counts new client connection. It returns false if node reached
+// max_clients limit and connection must be rejected.
+func (app *Application) acquireConnection() bool {
+	app.RLock()
+	limit := app.config.MaxClients
+	app.RUnlock()
+	if !app.clients.acquire(limit) {
+		app.metrics.NumClientsRejected.Inc()
+		logger.DEBUG.Printf("connection rejected as node reached max clients limit %d", limit)
+		return false
+	}
+	return true
+}
+
+// compressionRequested returns true if client offered permessage-deflate extension.
+func compressionRequested(r *http.Request) bool {
+	for _, value := range r.Header["Sec-Websocket-Extensions"] {
+		for _, ext := range strings.Split(value, ",") {
+			if strings.TrimSpace(strings.Split(ext, ";")[0]) == "permessage-deflate" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // RawWebsocketHandler called when new client connection comes to raw Websocket endpoint.
 func (app *Application) RawWebsocketHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.acquireConnection() {
+		http.Error(w, errTooManyClients, http.StatusServiceUnavailable)
+		return
+	}
+	defer app.clients.release()
 
-	ws, err := websocket.Upgrade(w, r, nil, sockjs.WebSocketReadBufSize, sockjs.WebSocketWriteBufSize)
+	var responseHeader http.Header
+	binary := false
+	msgpack := r.URL.Query().Get("format") == "msgpack"
+	for _, protocol := range websocket.Subprotocols(r) {
+		if protocol == WebsocketBinarySubprotocol || protocol == WebsocketMsgpackSubprotocol {
+			responseHeader = http.Header{"Sec-Websocket-Protocol": {protocol}}
+			binary = protocol == WebsocketBinarySubprotocol
+			msgpack = protocol == WebsocketMsgpackSubprotocol
+			break
+		}
+	}
+
+	app.RLock()
+	pingInterval := app.config.PingInterval
+	compression := app.config.WebsocketCompression
+	compressionMinSize := app.config.WebsocketCompressionMinSize
+	sendTimeout := app.config.MessageSendTimeout
+	app.RUnlock()
+
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:  sockjs.WebSocketReadBufSize,
+		WriteBufferSize: sockjs.WebSocketWriteBufSize,
+		// Compression uses no context takeover so compressor state is not kept
+		// between messages and flate writers are shared between connections.
+		EnableCompression: compression,
+		Error:             func(w http.ResponseWriter, r *http.Request, status int, reason error) {},
+		CheckOrigin:       app.checkOrigin,
+	}
+	var writer http.ResponseWriter = w
+	var counter *countingResponseWriter
+	if compression && compressionRequested(r) {
+		counter = &countingResponseWriter{ResponseWriter: w}
+		writer = counter
+	}
+
+	ws, err := upgrader.Upgrade(writer, r, responseHeader)
 	if _, ok := err.(websocket.HandshakeError); ok {
 		http.Error(w, `Can "Upgrade" only to "WebSocket".`, http.StatusBadRequest)
 		return
@@ -202,20 +435,32 @@ func (app *Application) RawWebsocketHandler(w http.ResponseWriter, r *http.Reque
 	}
 	defer ws.Close()
 
-	app.RLock()
-	pingInterval := app.config.PingInterval
-	app.RUnlock()
 	pongWait := pingInterval * 10 / 9 // https://github.com/gorilla/websocket/blob/master/examples/chat/conn.go#L22
 
 	sess := newWSSession(ws, pingInterval)
+	sess.binary = binary || msgpack
+	sess.writeTimeout = sendTimeout
+	if counter != nil {
+		sess.compression = counter.conn
+		sess.compressionMinSize = compressionMinSize
+		sess.metrics = app.metrics
+	}
 	defer close(sess.closeCh)
 
 	c, err := newClient(app, sess)
 	if err != nil {
 		return
 	}
+	c.transportName = "raw_websocket"
+	// session applies send timeout as write deadline itself so write does not
+	// keep blocking after timeout.
+	c.sendTimeout = 0
+	c.remoteAddr = app.requestAddr(r)
+	c.headers = app.connectProxyHeaders(r)
+	c.binaryFrames = binary
+	c.msgpackFrames = msgpack
 	logger.DEBUG.Printf("New raw Websocket session established with uid %s\n", c.uid())
-	defer c.clean()
+	defer c.teardown("connection closed")
 
 	ws.SetReadDeadline(time.Now().Add(pongWait))
 	ws.SetPongHandler(func(string) error { ws.SetReadDeadline(time.Now().Add(pongWait)); return nil })
@@ -227,7 +472,7 @@ func (app *Application) RawWebsocketHandler(w http.ResponseWriter, r *http.Reque
 		}
 		err = c.message(message)
 		if err != nil {
-			sess.Close(CloseStatus, err.Error())
+			sess.Close(CloseStatus, disconnectReason(err.Error(), shouldReconnect(err)))
 			break
 		}
 	}
@@ -268,7 +513,11 @@ func cmdFromRequestMsg(msg []byte) ([]apiCommand, error) {
 	return commands, nil
 }
 
-func (app *Application) processAPIData(data []byte) ([]byte, error) {
+// processAPIData runs API commands from request data. When request signed with API
+// key commands not allowed for key get permission denied error. Addr is a remote
+// address of request written into audit log. Commands recorded as children of span
+// sp if it is not nil.
+func (app *Application) processAPIData(data []byte, key *APIKey, addr string, sp *span) ([]byte, error) {
 
 	commands, err := cmdFromRequestMsg(data)
 	if err != nil {
@@ -276,17 +525,41 @@ func (app *Application) processAPIData(data []byte) ([]byte, error) {
 		return nil, ErrInvalidMessage
 	}
 
-	var mr multiAPIResponse
+	mr := make(multiAPIResponse, 0, len(commands))
+
+	entry := auditEntry{Source: auditSourceAPI, RemoteAddr: addr}
+	if key != nil {
+		entry.APIKey = key.Name
+	}
 
 	for _, command := range commands {
-		resp, err := app.apiCmd(command)
+		if key != nil && !key.allows(command) {
+			logger.ERROR.Printf("API key %s not allowed to run %s command", key.Name, command.Method)
+			resp := newAPIErrorResponse(command.Method, responseError{ErrPermissionDenied, errorAdviceNone})
+			resp.SetUID(command.UID)
+			app.audit(entry, command, resp, nil)
+			mr = append(mr, resp)
+			continue
+		}
+		csp := sp.child("apiCmd")
+		csp.setAttr("method", command.Method)
+		resp, err := app.tracedAPICmd(command, csp)
+		if r, ok := resp.(errResponse); ok && err == nil {
+			csp.setError(r.responseErr())
+		}
+		csp.setError(err)
+		csp.finish()
+		app.audit(entry, command, resp, err)
+		if err == ErrQueueFull {
+			return nil, err
+		}
 		if err != nil {
 			logger.ERROR.Println(err)
 			return nil, ErrInvalidMessage
 		}
 		mr = append(mr, resp)
 	}
-	jsonResp, err := json.Marshal(mr)
+	jsonResp, err := encodeJSON(mr)
 	if err != nil {
 		logger.ERROR.Println(err)
 		return nil, ErrInternalServerError
@@ -297,7 +570,9 @@ func (app *Application) processAPIData(data []byte) ([]byte, error) {
 // APIHandler is responsible for receiving API commands over HTTP.
 func (app *Application) APIHandler(w http.ResponseWriter, r *http.Request) {
 	started := time.Now()
+	sp := app.tracer.startRequest("api", r)
 	defer func() {
+		sp.finish()
 		app.metrics.histograms.RecordMicroseconds("http_api", time.Now().Sub(started))
 	}()
 	app.metrics.NumAPIRequests.Inc()
@@ -311,6 +586,24 @@ func (app *Application) APIHandler(w http.ResponseWriter, r *http.Request) {
 	app.RLock()
 	secret := app.config.Secret
 	insecure := app.config.InsecureAPI
+	legacyFormEnabled := app.config.APILegacyFormEnabled
+	legacySignEnabled := app.config.APILegacySignEnabled
+	signWindow := app.config.APISignWindow
+	apiRate := app.config.APIRequestsPerSecond
+	apiBurst := app.config.APIRequestsBurst
+	trusted := app.ipFilter.trusted
+	var key *APIKey
+	if keyName := r.Header.Get("X-API-Key"); keyName != "" && !insecure {
+		k, ok := app.config.apiKey(keyName)
+		if !ok {
+			app.RUnlock()
+			logger.ERROR.Printf("unknown API key %s", keyName)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		key = &k
+		secret = k.Secret
+	}
 	app.RUnlock()
 
 	if strings.HasPrefix(strings.ToLower(contentType), "application/json") {
@@ -327,7 +620,17 @@ func (app *Application) APIHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	} else {
-		// application/x-www-form-urlencoded request
+		// application/x-www-form-urlencoded request, this is a legacy format used by
+		// older API clients – sign is computed over data field value.
+		if !legacyFormEnabled {
+			logger.ERROR.Println("legacy form encoded API request rejected as api_legacy_form_enabled is off")
+			http.Error(w, "Unsupported Media Type", http.StatusUnsupportedMediaType)
+			return
+		}
+		app.metrics.NumAPILegacyFormRequests.Inc()
+		app.apiLegacyFormWarning.Do(func() {
+			logger.WARN.Println("form encoded API requests are deprecated, send JSON body with X-API-Sign header instead")
+		})
 		if !insecure {
 			sign = r.FormValue("sign")
 		}
@@ -352,19 +655,73 @@ func (app *Application) APIHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Bad Request", http.StatusBadRequest)
 			return
 		}
-		isValid := auth.CheckApiSign(secret, data, sign)
-		if !isValid {
-			logger.ERROR.Println("invalid sign")
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		timestamp := r.Header.Get("X-API-Timestamp")
+		if timestamp == "" {
+			if !legacySignEnabled {
+				logger.ERROR.Println("API request without timestamp rejected as api_legacy_sign_enabled is off")
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			isValid := auth.CheckApiSign(secret, data, sign)
+			if !isValid {
+				logger.ERROR.Println("invalid sign")
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		} else {
+			nonce := r.Header.Get("X-API-Nonce")
+			isValid := nonce != "" && auth.CheckApiSignWithTimestamp(secret, timestamp, nonce, data, sign)
+			if !isValid {
+				logger.ERROR.Println("invalid sign")
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			now := time.Now()
+			if !checkAPIRequestTime(timestamp, signWindow, now) {
+				logger.ERROR.Println("API request timestamp is outside of api_sign_window")
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if !app.apiNonces.add(nonce, signWindow, now) {
+				logger.ERROR.Println("API request nonce already used, request rejected as replayed")
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+	}
+
+	if key != nil {
+		app.metrics.apiKeyRequests.inc(key.Name)
+	}
+
+	if apiRate > 0 {
+		// forwarded headers only trusted from proxies in trusted_proxies.
+		limitKey := "addr:" + requestIP(r, trusted).String()
+		if key != nil {
+			limitKey = "key:" + key.Name
+		}
+		allowed, retryAfter := app.apiRateLimiter.allow(limitKey, apiRate, apiBurst, time.Now())
+		if !allowed {
+			app.metrics.NumAPIRateLimited.Inc()
+			seconds := int64(math.Ceil(retryAfter.Seconds()))
+			if seconds < 1 {
+				seconds = 1
+			}
+			w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
 			return
 		}
 	}
 
-	jsonResp, err := app.processAPIData(data)
+	jsonResp, err := app.processAPIData(data, key, requestIP(r, trusted).String(), sp)
 	if err != nil {
+		sp.setError(err)
 		if err == ErrInvalidMessage {
 			http.Error(w, "Bad Request", http.StatusBadRequest)
 			return
+		} else if err == ErrQueueFull {
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			return
 		} else {
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
@@ -384,6 +741,10 @@ func (app *Application) AuthHandler(w http.ResponseWriter, r *http.Request) {
 	insecure := app.config.InsecureAdmin
 	adminPassword := app.config.AdminPassword
 	adminSecret := app.config.AdminSecret
+	maxAttempts := app.config.AdminAuthMaxAttempts
+	lockout := app.config.AdminAuthLockout
+	lockoutMax := app.config.AdminAuthLockoutMax
+	trusted := app.ipFilter.trusted
 	app.RUnlock()
 
 	if insecure {
@@ -398,7 +759,17 @@ func (app *Application) AuthHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Bad Request", http.StatusBadRequest)
 		return
 	}
-	if password == adminPassword {
+	addr := requestIP(r, trusted).String()
+	allowed, failures, locked := app.adminAuthLimiter.attempt(addr, time.Now(), maxAttempts, lockout, lockoutMax)
+	if !allowed {
+		app.metrics.NumAdminAuthRejected.Inc()
+		logger.WARN.Printf("admin login from %s rejected as address locked out for %s", addr, locked)
+		w.Header().Set("Retry-After", strconv.FormatInt(int64(math.Ceil(locked.Seconds())), 10))
+		http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+		return
+	}
+	if auth.CheckPassword(adminPassword, password) {
+		app.adminAuthLimiter.reset(addr)
 		w.Header().Set("Content-Type", "application/json")
 		token, err := app.adminAuthToken()
 		if err != nil {
@@ -411,6 +782,12 @@ func (app *Application) AuthHandler(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(resp)
 		return
 	}
+	app.metrics.NumAdminAuthRejected.Inc()
+	if locked > 0 {
+		logger.WARN.Printf("admin login from %s failed, %d failed attempts, address locked out for %s", addr, failures, locked)
+	} else {
+		logger.WARN.Printf("admin login from %s failed, %d failed attempts", addr, failures)
+	}
 	http.Error(w, "Bad Request", http.StatusBadRequest)
 }
 
@@ -434,13 +811,7 @@ func (app *Application) WrapShutdown(h http.Handler) http.Handler {
 func (app *Application) Logged(h http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		addr := r.Header.Get("X-Real-IP")
-		if addr == "" {
-			addr = r.Header.Get("X-Forwarded-For")
-			if addr == "" {
-				addr = r.RemoteAddr
-			}
-		}
+		addr := app.requestAddr(r)
 		h.ServeHTTP(w, r)
 		if logger.DEBUG.Enabled() {
 			logger.DEBUG.Printf("%s %s from %s completed in %s\n", r.Method, r.URL.Path, addr, time.Since(start))
@@ -480,6 +851,7 @@ func (app *Application) AdminWebsocketHandler(w http.ResponseWriter, r *http.Req
 
 	app.RLock()
 	pingInterval := app.config.PingInterval
+	trusted := app.ipFilter.trusted
 	app.RUnlock()
 	pongWait := pingInterval * 10 / 9 // https://github.com/gorilla/websocket/blob/master/examples/chat/conn.go#L22
 
@@ -490,6 +862,9 @@ func (app *Application) AdminWebsocketHandler(w http.ResponseWriter, r *http.Req
 	if err != nil {
 		return
 	}
+	// address recorded in audit log of admin actions, forwarded headers only
+	// trusted from proxies in trusted_proxies.
+	c.remoteAddr = requestIP(r, trusted).String()
 	start := time.Now()
 	logger.DEBUG.Printf("New admin session established with uid %s\n", c.uid())
 	defer c.clean()
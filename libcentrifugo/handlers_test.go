@@ -2,6 +2,8 @@ package libcentrifugo
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -106,6 +108,81 @@ func TestRawWsHandler(t *testing.T) {
 	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
 }
 
+func TestRawWsHandlerMaxClients(t *testing.T) {
+	app := testApp()
+	app.config.MaxClients = 1
+	mux := DefaultMux(app, DefaultMuxOptions)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	url := "ws" + server.URL[4:]
+	conn, _, err := websocket.DefaultDialer.Dial(url+"/connection/websocket", nil)
+	assert.Equal(t, nil, err)
+	assert.True(t, waitCondition(func() bool {
+		return app.clients.connections() == 1
+	}))
+
+	_, resp, err := websocket.DefaultDialer.Dial(url+"/connection/websocket", nil)
+	assert.NotEqual(t, nil, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, int64(1), app.metrics.NumClientsRejected.LoadRaw())
+
+	conn.Close()
+	assert.True(t, waitCondition(func() bool {
+		return app.clients.connections() == 0
+	}))
+	conn, _, err = websocket.DefaultDialer.Dial(url+"/connection/websocket", nil)
+	assert.Equal(t, nil, err)
+	conn.Close()
+}
+
+func TestRawWsHandlerCompression(t *testing.T) {
+	app := testMemoryApp()
+	app.config.Insecure = true
+	app.config.WebsocketCompression = true
+	app.config.WebsocketCompressionMinSize = 500
+	mux := DefaultMux(app, DefaultMuxOptions)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	url := "ws" + server.URL[4:]
+
+	dialer := websocket.Dialer{EnableCompression: true}
+	conn, resp, err := dialer.Dial(url+"/connection/websocket", nil)
+	assert.Equal(t, nil, err)
+	defer conn.Close()
+	assert.Equal(t, "permessage-deflate; server_no_context_takeover; client_no_context_takeover", resp.Header.Get("Sec-Websocket-Extensions"))
+
+	// small frames sent uncompressed.
+	assert.Equal(t, nil, conn.WriteMessage(websocket.TextMessage, []byte(`{"method":"connect","params":{"user":"user"}}`)))
+	_, _, err = conn.ReadMessage()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(0), app.metrics.BytesUncompressedOut.LoadRaw())
+
+	data := strings.Repeat("a", 2000)
+	assert.Equal(t, nil, conn.WriteMessage(websocket.TextMessage, []byte(`{"method":"ping","params":{"data":"`+data+`"}}`)))
+	_, msg, err := conn.ReadMessage()
+	assert.Equal(t, nil, err)
+	assert.True(t, strings.Contains(string(msg), data))
+	uncompressed := app.metrics.BytesUncompressedOut.LoadRaw()
+	compressed := app.metrics.BytesCompressedOut.LoadRaw()
+	assert.Equal(t, int64(len(msg)), uncompressed)
+	assert.True(t, compressed > 0)
+	assert.True(t, compressed < uncompressed)
+}
+
+func TestRawWsHandlerCompressionDisabled(t *testing.T) {
+	app := testMemoryApp()
+	mux := DefaultMux(app, DefaultMuxOptions)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	url := "ws" + server.URL[4:]
+
+	dialer := websocket.Dialer{EnableCompression: true}
+	conn, resp, err := dialer.Dial(url+"/connection/websocket", nil)
+	assert.Equal(t, nil, err)
+	defer conn.Close()
+	assert.Equal(t, "", resp.Header.Get("Sec-Websocket-Extensions"))
+}
+
 func TestAdminWebsocketHandlerNotFound(t *testing.T) {
 	app := testApp()
 	opts := DefaultMuxOptions
@@ -159,6 +236,35 @@ func TestSockJSHandler(t *testing.T) {
 	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
 }
 
+func TestSockjsTransports(t *testing.T) {
+	transports := newSockjsTransports()
+	now := time.Now()
+	transports.remember("1", sockjsSessionInfo{transport: "xhr_streaming"}, now)
+	// info of first request kept.
+	transports.remember("1", sockjsSessionInfo{transport: "xhr"}, now)
+	assert.Equal(t, "xhr_streaming", transports.start("1").transport)
+	// requests of started session are not remembered.
+	transports.remember("1", sockjsSessionInfo{transport: "xhr"}, now)
+	assert.Equal(t, 0, len(transports.pending))
+	transports.end("1")
+	assert.Equal(t, 0, len(transports.active))
+
+	// sessions which never started expire.
+	transports.remember("2", sockjsSessionInfo{transport: "xhr"}, now)
+	transports.remember("3", sockjsSessionInfo{transport: "xhr"}, now.Add(sockjsPendingTTL))
+	assert.Equal(t, 1, len(transports.pending))
+	assert.Equal(t, "", transports.start("2").transport)
+	transports.end("2")
+
+	// number of pending sessions bounded.
+	for i := 0; i < sockjsMaxPending+10; i++ {
+		transports.remember(strconv.Itoa(i+10), sockjsSessionInfo{transport: "xhr"}, now.Add(sockjsPendingTTL))
+	}
+	assert.Equal(t, sockjsMaxPending, len(transports.pending))
+	assert.Equal(t, "", transports.start("10").transport)
+	assert.Equal(t, "xhr", transports.start(strconv.Itoa(sockjsMaxPending+19)).transport)
+}
+
 func TestRawWSHandler(t *testing.T) {
 	app := testApp()
 	opts := DefaultMuxOptions
@@ -290,6 +396,153 @@ func TestAPIHandler(t *testing.T) {
 	assert.Equal(t, http.StatusBadRequest, rec.Code)
 }
 
+func newTestAPIFormRequest(data, sign string) *http.Request {
+	values := url.Values{}
+	values.Set("sign", sign)
+	values.Add("data", data)
+	req, _ := http.NewRequest("POST", "/api/", strings.NewReader(values.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("Content-Length", strconv.Itoa(len(values.Encode())))
+	return req
+}
+
+func newTestAPIJSONRequest(data, sign string) *http.Request {
+	req, _ := http.NewRequest("POST", "/api/", bytes.NewBuffer([]byte(data)))
+	req.Header.Add("X-API-Sign", sign)
+	req.Header.Add("Content-Type", "application/json")
+	return req
+}
+
+func TestAPIHandlerEncodings(t *testing.T) {
+	app := testApp()
+	data := "{\"method\":\"publish\",\"params\":{\"channel\": \"test\", \"data\":{}}}"
+	sign := auth.GenerateApiSign("secret", []byte(data))
+	wrongSign := auth.GenerateApiSign("wrong", []byte(data))
+
+	rec := httptest.NewRecorder()
+	app.APIHandler(rec, newTestAPIFormRequest(data, sign))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, int64(1), app.metrics.NumAPILegacyFormRequests.LoadRaw())
+
+	rec = httptest.NewRecorder()
+	app.APIHandler(rec, newTestAPIFormRequest(data, wrongSign))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = httptest.NewRecorder()
+	app.APIHandler(rec, newTestAPIJSONRequest(data, sign))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = httptest.NewRecorder()
+	app.APIHandler(rec, newTestAPIJSONRequest(data, wrongSign))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, int64(2), app.metrics.NumAPILegacyFormRequests.LoadRaw())
+
+	app.Lock()
+	app.config.APILegacyFormEnabled = false
+	app.Unlock()
+
+	rec = httptest.NewRecorder()
+	app.APIHandler(rec, newTestAPIFormRequest(data, sign))
+	assert.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+	assert.Equal(t, int64(2), app.metrics.NumAPILegacyFormRequests.LoadRaw())
+
+	rec = httptest.NewRecorder()
+	app.APIHandler(rec, newTestAPIJSONRequest(data, sign))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestAPIHandlerJSONErrors(t *testing.T) {
+	app := testApp()
+
+	// empty body.
+	rec := httptest.NewRecorder()
+	app.APIHandler(rec, newTestAPIJSONRequest("", auth.GenerateApiSign("secret", []byte(""))))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// no sign header.
+	data := "{\"method\":\"publish\",\"params\":{\"channel\": \"test\", \"data\":{}}}"
+	rec = httptest.NewRecorder()
+	app.APIHandler(rec, newTestAPIJSONRequest(data, ""))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// malformed JSON.
+	for _, data := range []string{"{\"method\":", "[{\"method\":\"publish\"}", "not json", "   "} {
+		rec = httptest.NewRecorder()
+		app.APIHandler(rec, newTestAPIJSONRequest(data, auth.GenerateApiSign("secret", []byte(data))))
+		assert.Equal(t, http.StatusBadRequest, rec.Code, data)
+	}
+
+	// array of commands.
+	data = "[{\"method\":\"publish\",\"params\":{\"channel\": \"test\", \"data\":{}}},{\"method\":\"stats\",\"params\":{}}]"
+	rec = httptest.NewRecorder()
+	app.APIHandler(rec, newTestAPIJSONRequest(data, auth.GenerateApiSign("secret", []byte(data))))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+}
+
+func newTestAPITimestampRequest(data string, timestamp int64, nonce string) *http.Request {
+	ts := strconv.FormatInt(timestamp, 10)
+	req := newTestAPIJSONRequest(data, auth.GenerateApiSignWithTimestamp("secret", ts, nonce, []byte(data)))
+	req.Header.Set("X-API-Timestamp", ts)
+	req.Header.Set("X-API-Nonce", nonce)
+	return req
+}
+
+func TestAPIHandlerTimestampSign(t *testing.T) {
+	app := testApp()
+	data := "{\"method\":\"publish\",\"params\":{\"channel\": \"test\", \"data\":{}}}"
+	now := time.Now().Unix()
+
+	rec := httptest.NewRecorder()
+	app.APIHandler(rec, newTestAPITimestampRequest(data, now, "nonce1"))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// replayed request rejected.
+	rec = httptest.NewRecorder()
+	app.APIHandler(rec, newTestAPITimestampRequest(data, now, "nonce1"))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// request outside of sign window rejected.
+	rec = httptest.NewRecorder()
+	app.APIHandler(rec, newTestAPITimestampRequest(data, now-60, "nonce2"))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// timestamp must be covered by sign.
+	req := newTestAPITimestampRequest(data, now-60, "nonce3")
+	req.Header.Set("X-API-Timestamp", strconv.FormatInt(now, 10))
+	rec = httptest.NewRecorder()
+	app.APIHandler(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// nonce required.
+	rec = httptest.NewRecorder()
+	app.APIHandler(rec, newTestAPITimestampRequest(data, now, ""))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	app.Lock()
+	app.config.APILegacySignEnabled = false
+	app.Unlock()
+
+	rec = httptest.NewRecorder()
+	app.APIHandler(rec, newTestAPIJSONRequest(data, auth.GenerateApiSign("secret", []byte(data))))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = httptest.NewRecorder()
+	app.APIHandler(rec, newTestAPITimestampRequest(data, now, "nonce4"))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestAPINonceCache(t *testing.T) {
+	c := newAPINonceCache()
+	now := time.Now()
+	assert.True(t, c.add("a", time.Second, now))
+	assert.False(t, c.add("a", time.Second, now.Add(500*time.Millisecond)))
+	assert.True(t, c.add("b", time.Second, now.Add(500*time.Millisecond)))
+	// old nonces forgotten after window.
+	assert.True(t, c.add("c", time.Second, now.Add(2*time.Second)))
+	assert.Equal(t, 1, len(c.nonces))
+}
+
 func TestAuthHandler(t *testing.T) {
 	app := testApp()
 
@@ -326,4 +579,100 @@ func TestAuthHandler(t *testing.T) {
 	assert.Equal(t, http.StatusOK, r.Code)
 	body, _ = ioutil.ReadAll(r.Body)
 	assert.Equal(t, true, strings.Contains(string(body), "token"))
+
+	// bcrypt hash of password in config.
+	hash, _ := auth.GeneratePasswordHash("password")
+	app.Lock()
+	app.config.AdminPassword = hash
+	app.Unlock()
+	r = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/auth/", strings.NewReader(values.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	app.AuthHandler(r, req)
+	assert.Equal(t, http.StatusOK, r.Code)
+
+	r = httptest.NewRecorder()
+	values.Set("password", hash)
+	req, _ = http.NewRequest("POST", "/auth/", strings.NewReader(values.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	app.AuthHandler(r, req)
+	assert.Equal(t, http.StatusBadRequest, r.Code)
+}
+
+func TestAPIHandlerRateLimit(t *testing.T) {
+	app := testApp()
+	app.config.APIRequestsPerSecond = 1
+	data := "{\"method\":\"publish\",\"params\":{\"channel\": \"test\", \"data\":{}}}"
+	sign := auth.GenerateApiSign("secret", []byte(data))
+
+	rec := httptest.NewRecorder()
+	app.APIHandler(rec, newTestAPIJSONRequest(data, sign))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = httptest.NewRecorder()
+	app.APIHandler(rec, newTestAPIJSONRequest(data, sign))
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+	assert.Equal(t, int64(1), app.metrics.NumAPIRateLimited.LoadRaw())
+
+	// forwarded headers of untrusted client do not bypass limit.
+	req := newTestAPIJSONRequest(data, sign)
+	req.Header.Set("X-Real-IP", "10.0.0.2")
+	req.Header.Set("X-Forwarded-For", "10.0.0.2")
+	rec = httptest.NewRecorder()
+	app.APIHandler(rec, req)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+
+	// requests from other address limited separately, port ignored.
+	req = newTestAPIJSONRequest(data, sign)
+	req.RemoteAddr = "10.0.0.2:1234"
+	rec = httptest.NewRecorder()
+	app.APIHandler(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	req = newTestAPIJSONRequest(data, sign)
+	req.RemoteAddr = "10.0.0.2:1235"
+	rec = httptest.NewRecorder()
+	app.APIHandler(rec, req)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+}
+
+type testUnhealthyEngine struct {
+	testEngine
+}
+
+func (e *testUnhealthyEngine) healthCheck() error {
+	return errors.New("connection refused")
+}
+
+func TestHealthHandler(t *testing.T) {
+	app := testApp()
+	mux := DefaultMux(app, DefaultMuxOptions)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	getHealth := func() (int, healthBody) {
+		resp, err := http.Get(server.URL + "/health")
+		assert.Equal(t, nil, err)
+		defer resp.Body.Close()
+		var body healthBody
+		assert.Equal(t, nil, json.NewDecoder(resp.Body).Decode(&body))
+		return resp.StatusCode, body
+	}
+
+	code, body := getHealth()
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, healthStatusOK, body.Status)
+
+	app.engine = &testUnhealthyEngine{}
+	code, body = getHealth()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, healthStatusError, body.Status)
+	assert.Equal(t, "engine test engine", body.Component)
+	assert.Equal(t, "connection refused", body.Error)
+
+	app.engine = newTestEngine()
+	app.Shutdown()
+	code, body = getHealth()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, healthStatusDraining, body.Status)
 }
@@ -0,0 +1,82 @@
+package libcentrifugo
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/FZambia/go-logger"
+)
+
+// healthCheckTimeout is a max time engine has to answer health check.
+const healthCheckTimeout = time.Second
+
+// errHealthCheckTimeout returned when engine did not answer health check in time.
+var errHealthCheckTimeout = errors.New("health check timeout")
+
+// Node health statuses reported by health endpoint.
+const (
+	healthStatusOK       = "ok"
+	healthStatusDraining = "draining"
+	healthStatusError    = "error"
+)
+
+// healthBody is a body of health endpoint response.
+type healthBody struct {
+	Status string `json:"status"`
+	// Component and Error set when some node component is not healthy.
+	Component string `json:"component,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// checkEngineHealth runs health check of engine if engine supports it.
+func (app *Application) checkEngineHealth() error {
+	e, ok := app.engine.(healthCheckEngine)
+	if !ok {
+		return nil
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- e.healthCheck()
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-time.After(healthCheckTimeout):
+		return errHealthCheckTimeout
+	}
+}
+
+// health returns current node health status.
+func (app *Application) health() healthBody {
+	app.RLock()
+	shutdown := app.shutdown
+	app.RUnlock()
+	if shutdown {
+		return healthBody{Status: healthStatusDraining}
+	}
+	if err := app.checkEngineHealth(); err != nil {
+		return healthBody{
+			Status:    healthStatusError,
+			Component: "engine " + app.engine.name(),
+			Error:     err.Error(),
+		}
+	}
+	return healthBody{Status: healthStatusOK}
+}
+
+// HealthHandler reports node health for load balancers and orchestration probes.
+// It responds with 200 when node is healthy and with 503 when engine is not
+// available or node is shutting down so new connections routed to other nodes.
+func (app *Application) HealthHandler(w http.ResponseWriter, r *http.Request) {
+	body := app.health()
+	if body.Status == healthStatusError {
+		logger.ERROR.Printf("health check failed: %s: %s", body.Component, body.Error)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if body.Status != healthStatusOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(body)
+}
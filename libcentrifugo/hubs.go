@@ -1,112 +1,312 @@
 package libcentrifugo
 
 import (
+	"math/rand"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/FZambia/go-logger"
+	"github.com/centrifugal/centrifugo/libcentrifugo/encode"
 )
 
-// clientHub manages client connections.
-type clientHub struct {
+// clientHubShards is a number of shards client hub registries split into so
+// connections and subscriptions of different users and channels rarely contend on
+// the same lock.
+const clientHubShards = 64
+
+// hubShard returns shard index of key using FNV-1a hash.
+func hubShard(key string) int {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return int(h % clientHubShards)
+}
+
+// connShard holds client connections with ConnID hashing into shard.
+type connShard struct {
+	sync.RWMutex
+	conns map[ConnID]clientConn
+}
+
+// userShard holds ids of client connections grouped by UserID hashing into shard.
+type userShard struct {
+	sync.RWMutex
+	users map[UserID]map[ConnID]struct{}
+}
+
+// subShard holds subscribers of channels hashing into shard.
+type subShard struct {
 	sync.RWMutex
+	subs map[Channel]map[ConnID]clientConn
+}
+
+// clientHub manages client connections. Registries are sharded: connections by
+// ConnID, user connections by UserID and channel subscribers by channel. When
+// both user and connection shards locked user shard always locked first.
+type clientHub struct {
+	// numConns is a number of client transport connections, accessed atomically.
+	// It is first field to be 64-bit aligned.
+	numConns int64
 
 	// match ConnID with actual client connection.
-	conns map[ConnID]clientConn
+	conns [clientHubShards]*connShard
 
 	// registry to hold active client connections grouped by UserID.
-	users map[UserID]map[ConnID]struct{}
+	users [clientHubShards]*userShard
 
 	// registry to hold active subscriptions of clients on channels.
-	subs map[Channel]map[ConnID]struct{}
+	subs [clientHubShards]*subShard
 }
 
 // newClientHub initializes clientHub.
 func newClientHub() *clientHub {
-	return &clientHub{
-		conns: make(map[ConnID]clientConn),
-		users: make(map[UserID]map[ConnID]struct{}),
-		subs:  make(map[Channel]map[ConnID]struct{}),
+	h := &clientHub{}
+	for i := 0; i < clientHubShards; i++ {
+		h.conns[i] = &connShard{conns: make(map[ConnID]clientConn)}
+		h.users[i] = &userShard{users: make(map[UserID]map[ConnID]struct{})}
+		h.subs[i] = &subShard{subs: make(map[Channel]map[ConnID]clientConn)}
 	}
+	return h
+}
+
+func (h *clientHub) connShard(uid ConnID) *connShard {
+	return h.conns[hubShard(string(uid))]
+}
+
+func (h *clientHub) userShard(user UserID) *userShard {
+	return h.users[hubShard(string(user))]
 }
 
+func (h *clientHub) subShard(ch Channel) *subShard {
+	return h.subs[hubShard(string(ch))]
+}
+
+// shutdownDrainBatches is a max number of batches client connections split into
+// when drained on shutdown.
+const shutdownDrainBatches = 10
+
 // shutdown unsubscribes users from all channels and disconnects them.
 func (h *clientHub) shutdown() {
-	var wg sync.WaitGroup
-	h.RLock()
-	for _, user := range h.users {
-		wg.Add(len(user))
-		for uid := range user {
-			cc, ok := h.conns[uid]
-			if !ok {
-				wg.Done()
-				continue
-			}
-			go func(cc clientConn) {
-				for _, ch := range cc.channels() {
-					cc.unsubscribe(ch)
+	h.drain(0)
+}
+
+// drain unsubscribes users from all channels and disconnects them advising to
+// reconnect. Connections shuffled and closed in batches spread evenly over timeout
+// so clients do not reconnect to other nodes all at once.
+func (h *clientHub) drain(timeout time.Duration) {
+	var conns []clientConn
+	for _, us := range h.users {
+		us.RLock()
+		for _, user := range us.users {
+			for uid := range user {
+				if cc, ok := h.connection(uid); ok {
+					conns = append(conns, cc)
 				}
-				cc.close("shutting down")
-				wg.Done()
-			}(cc)
+			}
+		}
+		us.RUnlock()
+	}
+
+	numBatches := shutdownDrainBatches
+	if len(conns) < numBatches {
+		numBatches = len(conns)
+	}
+	if timeout <= 0 || numBatches <= 1 {
+		closeShutdown(conns)
+		return
+	}
+	for i, j := range rand.Perm(len(conns)) {
+		conns[i], conns[j] = conns[j], conns[i]
+	}
+	interval := timeout / time.Duration(numBatches)
+	batchSize := (len(conns) + numBatches - 1) / numBatches
+	for start := 0; start < len(conns); start += batchSize {
+		if start > 0 {
+			time.Sleep(interval)
 		}
+		end := start + batchSize
+		if end > len(conns) {
+			end = len(conns)
+		}
+		closeShutdown(conns[start:end])
+	}
+}
+
+// closeShutdown concurrently unsubscribes connections from all channels and closes
+// them with shutdown reason.
+func closeShutdown(conns []clientConn) {
+	var wg sync.WaitGroup
+	wg.Add(len(conns))
+	for _, cc := range conns {
+		go func(cc clientConn) {
+			for _, ch := range cc.channels() {
+				cc.unsubscribe(ch, true)
+			}
+			cc.close("shutting down", true)
+			wg.Done()
+		}(cc)
 	}
-	h.RUnlock()
 	wg.Wait()
 }
 
+// acquire counts new transport connection if there are less than limit
+// connections on node. Zero limit means no limit. It returns false if connection
+// must be rejected, release must be called for acquired connection when it closed.
+func (h *clientHub) acquire(limit int) bool {
+	n := atomic.AddInt64(&h.numConns, 1)
+	if limit > 0 && n > int64(limit) {
+		atomic.AddInt64(&h.numConns, -1)
+		return false
+	}
+	return true
+}
+
+// release stops counting closed transport connection.
+func (h *clientHub) release() {
+	atomic.AddInt64(&h.numConns, -1)
+}
+
+// connections returns number of transport connections on node.
+func (h *clientHub) connections() int64 {
+	return atomic.LoadInt64(&h.numConns)
+}
+
 // add adds connection into clientHub connections registry.
 func (h *clientHub) add(c clientConn) error {
-	h.Lock()
-	defer h.Unlock()
+	h.addLimited(c, 0)
+	return nil
+}
 
+// addLimited adds connection into clientHub connections registry if user has
+// less than limit connections registered. Zero limit means no limit. It returns
+// false if connection was not added.
+func (h *clientHub) addLimited(c clientConn, limit int) bool {
 	uid := c.uid()
 	user := c.user()
 
-	h.conns[uid] = c
+	us := h.userShard(user)
+	us.Lock()
+	defer us.Unlock()
+
+	if _, ok := us.users[user][uid]; !ok && limit > 0 && len(us.users[user]) >= limit {
+		return false
+	}
+
+	h.setConn(c)
 
-	_, ok := h.users[user]
+	_, ok := us.users[user]
 	if !ok {
-		h.users[user] = make(map[ConnID]struct{})
+		us.users[user] = make(map[ConnID]struct{})
 	}
-	h.users[user][uid] = struct{}{}
-	return nil
+	us.users[user][uid] = struct{}{}
+	return true
+}
+
+// setConn puts connection into connections registry.
+func (h *clientHub) setConn(c clientConn) {
+	cs := h.connShard(c.uid())
+	cs.Lock()
+	cs.conns[c.uid()] = c
+	cs.Unlock()
+}
+
+// addEvicting adds connection into clientHub connections registry and returns
+// oldest connections of the same user exceeding limit which must be closed by
+// caller. Returned connections already removed from user registry so concurrent
+// connections of user do not evict them again. Zero limit means no limit.
+func (h *clientHub) addEvicting(c clientConn, limit int) []clientConn {
+	uid := c.uid()
+	user := c.user()
+
+	us := h.userShard(user)
+	us.Lock()
+	defer us.Unlock()
+
+	h.setConn(c)
+	_, ok := us.users[user]
+	if !ok {
+		us.users[user] = make(map[ConnID]struct{})
+	}
+	us.users[user][uid] = struct{}{}
+
+	if limit <= 0 || len(us.users[user]) <= limit {
+		return nil
+	}
+	others := make([]clientConn, 0, len(us.users[user])-1)
+	for id := range us.users[user] {
+		if id == uid {
+			continue
+		}
+		if other, ok := h.connection(id); ok {
+			others = append(others, other)
+		}
+	}
+	sort.Slice(others, func(i, j int) bool {
+		return others[i].connected() < others[j].connected()
+	})
+	if excess := len(us.users[user]) - limit; len(others) > excess {
+		others = others[:excess]
+	}
+	for _, other := range others {
+		delete(us.users[user], other.uid())
+	}
+	return others
 }
 
 // remove removes connection from clientHub connections registry.
 func (h *clientHub) remove(c clientConn) error {
-	h.Lock()
-	defer h.Unlock()
-
 	uid := c.uid()
 	user := c.user()
 
-	delete(h.conns, uid)
+	us := h.userShard(user)
+	us.Lock()
+	defer us.Unlock()
+
+	cs := h.connShard(uid)
+	cs.Lock()
+	delete(cs.conns, uid)
+	cs.Unlock()
 
 	// try to find connection to delete, return early if not found.
-	if _, ok := h.users[user]; !ok {
+	if _, ok := us.users[user]; !ok {
 		return nil
 	}
-	if _, ok := h.users[user][uid]; !ok {
+	if _, ok := us.users[user][uid]; !ok {
 		return nil
 	}
 
 	// actually remove connection from hub.
-	delete(h.users[user], uid)
+	delete(us.users[user], uid)
 
 	// clean up users map if it's needed.
-	if len(h.users[user]) == 0 {
-		delete(h.users, user)
+	if len(us.users[user]) == 0 {
+		delete(us.users, user)
 	}
 
 	return nil
 }
 
+// connection returns connection with provided ConnID if it exists on this node.
+func (h *clientHub) connection(uid ConnID) (clientConn, bool) {
+	cs := h.connShard(uid)
+	cs.RLock()
+	defer cs.RUnlock()
+	c, ok := cs.conns[uid]
+	return c, ok
+}
+
 // userConnections returns all connections of user with UserID in project.
 func (h *clientHub) userConnections(user UserID) map[ConnID]clientConn {
-	h.RLock()
-	defer h.RUnlock()
+	us := h.userShard(user)
+	us.RLock()
+	defer us.RUnlock()
 
-	userConnections, ok := h.users[user]
+	userConnections, ok := us.users[user]
 	if !ok {
 		return map[ConnID]clientConn{}
 	}
@@ -114,7 +314,7 @@ func (h *clientHub) userConnections(user UserID) map[ConnID]clientConn {
 	var conns map[ConnID]clientConn
 	conns = make(map[ConnID]clientConn, len(userConnections))
 	for uid := range userConnections {
-		c, ok := h.conns[uid]
+		c, ok := h.connection(uid)
 		if !ok {
 			continue
 		}
@@ -124,20 +324,76 @@ func (h *clientHub) userConnections(user UserID) map[ConnID]clientConn {
 	return conns
 }
 
+// filterConnections returns connections matching all conditions of filter.
+// Channel and user indexes used to narrow down connections to check when
+// corresponding conditions set.
+func (h *clientHub) filterConnections(f disconnectFilter) []clientConn {
+	var candidates []ConnID
+	if f.Channel != "" {
+		ss := h.subShard(f.Channel)
+		ss.RLock()
+		for uid := range ss.subs[f.Channel] {
+			candidates = append(candidates, uid)
+		}
+		ss.RUnlock()
+	} else if f.UserPrefix != "" {
+		for _, us := range h.users {
+			us.RLock()
+			for user, conns := range us.users {
+				if !strings.HasPrefix(string(user), f.UserPrefix) {
+					continue
+				}
+				for uid := range conns {
+					candidates = append(candidates, uid)
+				}
+			}
+			us.RUnlock()
+		}
+	} else {
+		for _, cs := range h.conns {
+			cs.RLock()
+			for uid := range cs.conns {
+				candidates = append(candidates, uid)
+			}
+			cs.RUnlock()
+		}
+	}
+
+	var conns []clientConn
+	for _, uid := range candidates {
+		c, ok := h.connection(uid)
+		if !ok {
+			continue
+		}
+		if f.UserPrefix != "" && !strings.HasPrefix(string(c.user()), f.UserPrefix) {
+			continue
+		}
+		if f.Transport != "" && c.transport() != f.Transport {
+			continue
+		}
+		if f.ConnectedBefore > 0 && c.connected() >= f.ConnectedBefore {
+			continue
+		}
+		conns = append(conns, c)
+	}
+	return conns
+}
+
 // addSub adds connection into clientHub subscriptions registry.
 func (h *clientHub) addSub(ch Channel, c clientConn) (bool, error) {
-	h.Lock()
-	defer h.Unlock()
-
 	uid := c.uid()
 
-	h.conns[uid] = c
+	h.setConn(c)
+
+	ss := h.subShard(ch)
+	ss.Lock()
+	defer ss.Unlock()
 
-	_, ok := h.subs[ch]
+	_, ok := ss.subs[ch]
 	if !ok {
-		h.subs[ch] = make(map[ConnID]struct{})
+		ss.subs[ch] = make(map[ConnID]clientConn)
 	}
-	h.subs[ch][uid] = struct{}{}
+	ss.subs[ch][uid] = c
 	if !ok {
 		return true, nil
 	}
@@ -146,25 +402,26 @@ func (h *clientHub) addSub(ch Channel, c clientConn) (bool, error) {
 
 // removeSub removes connection from clientHub subscriptions registry.
 func (h *clientHub) removeSub(ch Channel, c clientConn) (bool, error) {
-	h.Lock()
-	defer h.Unlock()
+	ss := h.subShard(ch)
+	ss.Lock()
+	defer ss.Unlock()
 
 	uid := c.uid()
 
 	// try to find subscription to delete, return early if not found.
-	if _, ok := h.subs[ch]; !ok {
+	if _, ok := ss.subs[ch]; !ok {
 		return true, nil
 	}
-	if _, ok := h.subs[ch][uid]; !ok {
+	if _, ok := ss.subs[ch][uid]; !ok {
 		return true, nil
 	}
 
 	// actually remove subscription from hub.
-	delete(h.subs[ch], uid)
+	delete(ss.subs[ch], uid)
 
 	// clean up subs map if it's needed.
-	if len(h.subs[ch]) == 0 {
-		delete(h.subs, ch)
+	if len(ss.subs[ch]) == 0 {
+		delete(ss.subs, ch)
 		return true, nil
 	}
 
@@ -173,78 +430,198 @@ func (h *clientHub) removeSub(ch Channel, c clientConn) (bool, error) {
 
 // broadcast sends message to all clients subscribed on channel.
 func (h *clientHub) broadcast(ch Channel, message []byte) error {
-	h.RLock()
-	defer h.RUnlock()
+	_, _, err := h.broadcastMessage(ch, message, nil, "", false)
+	return err
+}
+
+// broadcastMessage sends message to all clients subscribed on channel. Message is
+// encoded by caller once per channel and the same bytes put into every connection
+// queue, so messages with per-client data must be sent to connections one by one.
+// Clients supporting binary frames receive binaryMessage instead if it is not nil.
+// Clients negotiated MessagePack format receive message converted once for all of
+// them. If ackToken set then it is saved in connection ack window. Publication
+// messages can be dropped from queues of slow connections. It returns
+// number of tokens dropped from ack windows and connections which reached unacked
+// messages advice threshold.
+func (h *clientHub) broadcastMessage(ch Channel, message []byte, binaryMessage []byte, ackToken string, publication bool) (int, []clientConn, error) {
+	ss := h.subShard(ch)
+	ss.RLock()
+	defer ss.RUnlock()
 
 	// get connections currently subscribed on channel
-	channelSubscriptions, ok := h.subs[ch]
+	channelSubscriptions, ok := ss.subs[ch]
 	if !ok {
-		return nil
+		return 0, nil, nil
 	}
 
+	var dropped int
+	var lagging []clientConn
+	var msgpackMessage []byte
+	now := time.Now()
+
 	// iterate over them and send message individually
-	for uid := range channelSubscriptions {
-		c, ok := h.conns[uid]
-		if !ok {
-			continue
+	for _, c := range channelSubscriptions {
+		if ackToken != "" {
+			if window := c.acks(); window != nil {
+				windowDropped, lag := window.add(ackToken, ch, now)
+				if windowDropped {
+					dropped++
+				}
+				if lag {
+					lagging = append(lagging, c)
+				}
+			}
+		}
+		var err error
+		send := c.send
+		if publication {
+			send = c.sendPublication
+		}
+		if c.msgpack() {
+			if msgpackMessage == nil {
+				msgpackMessage, err = encode.JSONToMsgpack(message)
+				if err != nil {
+					return dropped, lagging, err
+				}
+			}
+			err = send(msgpackMessage)
+		} else if binaryMessage != nil && c.binary() {
+			err = send(binaryMessage)
+		} else {
+			err = send(message)
 		}
-		err := c.send(message)
 		if err != nil {
 			logger.ERROR.Println(err)
 		}
 	}
-	return nil
+	return dropped, lagging, nil
 }
 
 // nClients returns total number of client connections.
 func (h *clientHub) nClients() int {
-	h.RLock()
-	defer h.RUnlock()
 	total := 0
-	for _, clientConnections := range h.users {
-		total += len(clientConnections)
+	for _, us := range h.users {
+		us.RLock()
+		for _, clientConnections := range us.users {
+			total += len(clientConnections)
+		}
+		us.RUnlock()
+	}
+	return total
+}
+
+// queuedBytes returns total size of messages waiting in client queues.
+func (h *clientHub) queuedBytes() int64 {
+	var total int64
+	for _, cs := range h.conns {
+		cs.RLock()
+		for _, c := range cs.conns {
+			total += int64(c.queuedBytes())
+		}
+		cs.RUnlock()
 	}
 	return total
 }
 
+// queueStats returns max and 99th percentile of sizes of client queues in bytes
+// and max time oldest message waits in client queue.
+func (h *clientHub) queueStats() (int, int, time.Duration) {
+	var sizes []int
+	var maxAge time.Duration
+	for _, cs := range h.conns {
+		cs.RLock()
+		for _, c := range cs.conns {
+			sizes = append(sizes, c.queuedBytes())
+			if age := c.queueAge(); age > maxAge {
+				maxAge = age
+			}
+		}
+		cs.RUnlock()
+	}
+	if len(sizes) == 0 {
+		return 0, 0, 0
+	}
+	sort.Ints(sizes)
+	return sizes[len(sizes)-1], sizes[(len(sizes)-1)*99/100], maxAge
+}
+
 // nUniqueClients returns a number of unique users connected.
 func (h *clientHub) nUniqueClients() int {
-	h.RLock()
-	defer h.RUnlock()
-	return len(h.users)
+	total := 0
+	for _, us := range h.users {
+		us.RLock()
+		total += len(us.users)
+		us.RUnlock()
+	}
+	return total
 }
 
 // nChannels returns a total number of different channels.
 func (h *clientHub) nChannels() int {
-	h.RLock()
-	defer h.RUnlock()
-	return len(h.subs)
+	total := 0
+	for _, ss := range h.subs {
+		ss.RLock()
+		total += len(ss.subs)
+		ss.RUnlock()
+	}
+	return total
 }
 
 // channels returns a slice of all active channels.
 func (h *clientHub) channels() []Channel {
-	h.RLock()
-	defer h.RUnlock()
-	channels := make([]Channel, len(h.subs))
-	i := 0
-	for ch := range h.subs {
-		channels[i] = ch
-		i++
+	var channels []Channel
+	for _, ss := range h.subs {
+		ss.RLock()
+		for ch := range ss.subs {
+			channels = append(channels, ch)
+		}
+		ss.RUnlock()
 	}
 	return channels
 }
 
+// topChannels returns up to n channels with most subscribers on this node.
+func (h *clientHub) topChannels(n int) []channelSubscribers {
+	var top []channelSubscribers
+	for _, ss := range h.subs {
+		ss.RLock()
+		for ch, subs := range ss.subs {
+			top = append(top, channelSubscribers{Channel: ch, Subscribers: len(subs)})
+		}
+		ss.RUnlock()
+	}
+	sort.Sort(bySubscribers(top))
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
 // numSubscribers returns number of current subscribers for a given channel.
 func (h *clientHub) numSubscribers(ch Channel) int {
-	h.RLock()
-	defer h.RUnlock()
-	conns, ok := h.subs[ch]
+	ss := h.subShard(ch)
+	ss.RLock()
+	defer ss.RUnlock()
+	conns, ok := ss.subs[ch]
 	if !ok {
 		return 0
 	}
 	return len(conns)
 }
 
+// nSubscriptions returns total amount of subscriptions in all channels.
+func (h *clientHub) nSubscriptions() int {
+	total := 0
+	for _, ss := range h.subs {
+		ss.RLock()
+		for _, conns := range ss.subs {
+			total += len(conns)
+		}
+		ss.RUnlock()
+	}
+	return total
+}
+
 // adminHub manages admin connections from web interface.
 type adminHub struct {
 	sync.RWMutex
@@ -288,3 +665,66 @@ func (h *adminHub) broadcast(message []byte) error {
 	}
 	return nil
 }
+
+// engineSub is a state of node engine subscription on channel, its lock
+// serializes engine subscribe and unsubscribe calls on channel.
+type engineSub struct {
+	sync.Mutex
+
+	// refs is a number of goroutines using state, state removed from hub when
+	// it drops to zero.
+	refs int
+
+	// pending is engine subscribe in progress or failed one which still has
+	// subscribers to roll back.
+	pending *pendingSubscribe
+}
+
+// pendingSubscribe is a result of engine subscribe shared by all connections
+// subscribed on channel while it was in progress.
+type pendingSubscribe struct {
+	// done closed when result known.
+	done chan struct{}
+	err  error
+
+	// errCh is a channel engine sends subscribe result to.
+	errCh <-chan error
+}
+
+// engineSubHub keeps engine subscription states of channels in use.
+type engineSubHub struct {
+	sync.Mutex
+	subs map[Channel]*engineSub
+}
+
+// newEngineSubHub initializes new engineSubHub.
+func newEngineSubHub() *engineSubHub {
+	return &engineSubHub{
+		subs: make(map[Channel]*engineSub),
+	}
+}
+
+// lock returns locked engine subscription state of channel.
+func (h *engineSubHub) lock(ch Channel) *engineSub {
+	h.Lock()
+	s, ok := h.subs[ch]
+	if !ok {
+		s = &engineSub{}
+		h.subs[ch] = s
+	}
+	s.refs++
+	h.Unlock()
+	s.Lock()
+	return s
+}
+
+// unlock unlocks engine subscription state of channel returned by lock.
+func (h *engineSubHub) unlock(ch Channel, s *engineSub) {
+	h.Lock()
+	s.refs--
+	if s.refs == 0 {
+		delete(h.subs, ch)
+	}
+	h.Unlock()
+	s.Unlock()
+}
@@ -2,6 +2,7 @@ package libcentrifugo
 
 import (
 	"fmt"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -13,9 +14,28 @@ type testClientConn struct {
 	UID      UserID
 	Channels []Channel
 
-	Messages [][]byte
-	Closed   bool
-	sess     *testSession
+	Transport string
+	Connected int64
+	Binary    bool
+	Msgpack   bool
+	Queued    int
+	Acks      *ackWindow
+
+	Messages  [][]byte
+	Closed    bool
+	Reconnect bool
+	sess      *testSession
+}
+
+// nConns returns number of connections in connections registry.
+func (h *clientHub) nConns() int {
+	total := 0
+	for _, cs := range h.conns {
+		cs.RLock()
+		total += len(cs.conns)
+		cs.RUnlock()
+	}
+	return total
 }
 
 func newTestUserCC() *testClientConn {
@@ -42,7 +62,11 @@ func (c *testClientConn) send(message []byte) error {
 	return nil
 }
 
-func (c *testClientConn) unsubscribe(channel Channel) error {
+func (c *testClientConn) sendPublication(message []byte) error {
+	return c.send(message)
+}
+
+func (c *testClientConn) unsubscribe(channel Channel, resubscribe bool) error {
 	for i, ch := range c.Channels {
 		if ch == channel {
 			c.Channels = c.Channels[:i+copy(c.Channels[i:], c.Channels[i+1:])]
@@ -52,11 +76,40 @@ func (c *testClientConn) unsubscribe(channel Channel) error {
 	return fmt.Errorf("channel '%s' not found", string(channel))
 }
 
-func (c *testClientConn) close(reason string) error {
+func (c *testClientConn) transport() string {
+	return c.Transport
+}
+
+func (c *testClientConn) connected() int64 {
+	return c.Connected
+}
+
+func (c *testClientConn) binary() bool {
+	return c.Binary
+}
+
+func (c *testClientConn) msgpack() bool {
+	return c.Msgpack
+}
+
+func (c *testClientConn) queueAge() time.Duration {
+	return 0
+}
+
+func (c *testClientConn) queuedBytes() int {
+	return c.Queued
+}
+
+func (c *testClientConn) acks() *ackWindow {
+	return c.Acks
+}
+
+func (c *testClientConn) close(reason string, reconnect bool) error {
 	if c.Closed {
 		return fmt.Errorf("duplicate close")
 	}
 	c.Closed = true
+	c.Reconnect = reconnect
 	return nil
 }
 
@@ -74,21 +127,33 @@ func TestClientHub(t *testing.T) {
 	h := newClientHub()
 	c := newTestUserCC()
 	h.add(c)
-	assert.Equal(t, len(h.users), 1)
+	assert.Equal(t, h.nUniqueClients(), 1)
 	conns := h.userConnections("test user")
 	assert.Equal(t, 1, len(conns))
 	assert.Equal(t, 1, h.nClients())
 	assert.Equal(t, 1, h.nUniqueClients())
 	h.remove(c)
-	assert.Equal(t, len(h.users), 0)
+	assert.Equal(t, h.nUniqueClients(), 0)
 	assert.Equal(t, 1, len(conns))
 }
 
+func TestClientHubAcquire(t *testing.T) {
+	h := newClientHub()
+	assert.True(t, h.acquire(2))
+	assert.True(t, h.acquire(2))
+	assert.False(t, h.acquire(2))
+	assert.Equal(t, int64(2), h.connections())
+	h.release()
+	assert.True(t, h.acquire(2))
+	assert.True(t, h.acquire(0))
+	assert.Equal(t, int64(3), h.connections())
+}
+
 func TestShutdown(t *testing.T) {
 	h := newClientHub()
 	c := newTestUserCC()
 	h.add(c)
-	assert.Equal(t, len(h.users), 1)
+	assert.Equal(t, h.nUniqueClients(), 1)
 	h.shutdown()
 }
 
@@ -110,11 +175,56 @@ func TestSubHub(t *testing.T) {
 	assert.Equal(t, err, nil)
 	h.removeSub("test1", c)
 	h.removeSub("test2", c)
-	assert.Equal(t, len(h.subs), 0)
+	assert.Equal(t, h.nChannels(), 0)
 	assert.False(t, h.numSubscribers(Channel("test1")) > 0)
 	assert.False(t, h.numSubscribers(Channel("test2")) > 0)
 }
 
+func testFilterHub() *clientHub {
+	h := newClientHub()
+	conns := []*testClientConn{
+		{CID: "1", UID: "admin-1", Transport: "jsonp", Connected: 100},
+		{CID: "2", UID: "admin-2", Transport: "websocket", Connected: 200},
+		{CID: "3", UID: "user-1", Transport: "jsonp", Connected: 300},
+	}
+	for _, c := range conns {
+		h.add(c)
+	}
+	h.addSub("news", conns[1])
+	h.addSub("news", conns[2])
+	return h
+}
+
+func TestClientHubShutdown(t *testing.T) {
+	h := newClientHub()
+	c := newTestUserCC()
+	h.add(c)
+	h.shutdown()
+	assert.True(t, c.Closed)
+	assert.True(t, c.Reconnect)
+}
+
+func TestClientHubFilterConnections(t *testing.T) {
+	h := testFilterHub()
+	assert.Equal(t, 2, len(h.filterConnections(disconnectFilter{Transport: "jsonp"})))
+	assert.Equal(t, 0, len(h.filterConnections(disconnectFilter{Transport: "xhr"})))
+	assert.Equal(t, 2, len(h.filterConnections(disconnectFilter{ConnectedBefore: 300})))
+	assert.Equal(t, 0, len(h.filterConnections(disconnectFilter{ConnectedBefore: 100})))
+	assert.Equal(t, 2, len(h.filterConnections(disconnectFilter{UserPrefix: "admin-"})))
+	assert.Equal(t, 0, len(h.filterConnections(disconnectFilter{UserPrefix: "guest"})))
+	assert.Equal(t, 2, len(h.filterConnections(disconnectFilter{Channel: "news"})))
+	assert.Equal(t, 0, len(h.filterConnections(disconnectFilter{Channel: "sport"})))
+
+	// all conditions must match.
+	conns := h.filterConnections(disconnectFilter{Channel: "news", Transport: "jsonp"})
+	assert.Equal(t, 1, len(conns))
+	assert.Equal(t, ConnID("3"), conns[0].uid())
+	conns = h.filterConnections(disconnectFilter{UserPrefix: "admin-", ConnectedBefore: 150})
+	assert.Equal(t, 1, len(conns))
+	assert.Equal(t, ConnID("1"), conns[0].uid())
+	assert.Equal(t, 0, len(h.filterConnections(disconnectFilter{Channel: "news", UserPrefix: "user-", Transport: "websocket"})))
+}
+
 func TestAdminHub(t *testing.T) {
 	h := newAdminHub()
 	c := newTestUserCC()
@@ -169,3 +279,164 @@ func BenchmarkSubHubBroadCast(b *testing.B) {
 		b.Logf("%d messages/sec", total*int(time.Second)/int(dur))
 	}
 }
+
+func TestClientHubBroadcastSharedMessage(t *testing.T) {
+	h, conns := setupHub(3, 1, 1)
+	message := []byte(`{"method":"message"}`)
+	assert.Equal(t, nil, h.broadcast("chan-0", message))
+	for _, c := range conns {
+		assert.Equal(t, 1, len(c.Messages))
+		// every connection gets the same encoded message, not a copy.
+		assert.True(t, &c.Messages[0][0] == &message[0])
+	}
+}
+
+// discardClientConn drops messages so broadcast benchmarks do not accumulate them.
+type discardClientConn struct {
+	*testClientConn
+}
+
+func (c *discardClientConn) send(message []byte) error {
+	return nil
+}
+
+func (c *discardClientConn) sendPublication(message []byte) error {
+	return nil
+}
+
+func setupBroadcastHub(numSubscribers int) (*clientHub, []clientConn) {
+	h := newClientHub()
+	conns := make([]clientConn, numSubscribers)
+	for i := range conns {
+		c := &discardClientConn{newTestUserCC()}
+		c.CID = ConnID(fmt.Sprintf("cid-%d", i))
+		h.add(c)
+		h.addSub("test", c)
+		conns[i] = c
+	}
+	return h, conns
+}
+
+// BenchmarkBroadcastEncodePerClient marshals message for every subscriber as it
+// would be done without preparing message once per channel.
+func BenchmarkBroadcastEncodePerClient(b *testing.B) {
+	_, conns := setupBroadcastHub(10000)
+	message := newMessage("test", []byte(`{"hello world": true}`), "", nil)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, c := range conns {
+			resp := newClientMessage()
+			resp.Body = *message
+			data, err := resp.Marshal()
+			if err != nil {
+				b.Fatal(err)
+			}
+			c.send(data)
+		}
+	}
+}
+
+// BenchmarkBroadcastEncodeOnce marshals message once and sends the same bytes to
+// all subscribers like clientMsg does.
+func BenchmarkBroadcastEncodeOnce(b *testing.B) {
+	h, _ := setupBroadcastHub(10000)
+	message := newMessage("test", []byte(`{"hello world": true}`), "", nil)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		resp := newClientMessage()
+		resp.Body = *message
+		data, err := resp.Marshal()
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, _, err := h.broadcastMessage("test", data, nil, "", true); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkClientHubConnectStorm adds, subscribes and removes connections from
+// parallel goroutines like during reconnect of many clients, 200k connections
+// subscribe on 50k channels.
+func BenchmarkClientHubConnectStorm(b *testing.B) {
+	numConns := 200000
+	numChannels := 50000
+	conns := make([]*testClientConn, numConns)
+	for i := range conns {
+		c := newTestUserCC()
+		c.CID = ConnID(fmt.Sprintf("cid-%d", i))
+		c.UID = UserID(fmt.Sprintf("uid-%d", i))
+		conns[i] = c
+	}
+	h := newClientHub()
+	var next int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := int(atomic.AddInt64(&next, 1)) % numConns
+			c := conns[i]
+			ch := Channel(fmt.Sprintf("chan-%d", i%numChannels))
+			h.add(c)
+			h.addSub(ch, c)
+			h.numSubscribers(ch)
+			h.removeSub(ch, c)
+			h.remove(c)
+		}
+	})
+}
+
+func TestClientHubAddLimited(t *testing.T) {
+	h := newClientHub()
+	c1 := newTestUserCC()
+	c2 := newTestUserCC()
+	c2.CID = "another test uid"
+	assert.True(t, h.addLimited(c1, 1))
+	// already registered connection is not limited.
+	assert.True(t, h.addLimited(c1, 1))
+	assert.False(t, h.addLimited(c2, 1))
+	assert.Equal(t, 1, h.nClients())
+	assert.True(t, h.addLimited(c2, 0))
+	assert.Equal(t, 2, h.nClients())
+}
+
+func TestClientHubAddEvicting(t *testing.T) {
+	h := newClientHub()
+	c1 := newTestUserCC()
+	c1.Connected = 1
+	c2 := newTestUserCC()
+	c2.CID = "c2"
+	c2.Connected = 2
+	c3 := newTestUserCC()
+	c3.CID = "c3"
+	c3.Connected = 3
+	assert.Equal(t, 0, len(h.addEvicting(c1, 2)))
+	assert.Equal(t, 0, len(h.addEvicting(c2, 2)))
+	evicted := h.addEvicting(c3, 2)
+	assert.Equal(t, 1, len(evicted))
+	assert.Equal(t, c1.CID, evicted[0].uid())
+	// evicted connection not counted for user anymore.
+	assert.Equal(t, 2, len(h.userConnections(c1.UID)))
+	c4 := newTestUserCC()
+	c4.CID = "c4"
+	c4.Connected = 4
+	evicted = h.addEvicting(c4, 2)
+	assert.Equal(t, 1, len(evicted))
+	assert.Equal(t, c2.CID, evicted[0].uid())
+}
+
+func TestClientHubDrain(t *testing.T) {
+	h := newClientHub()
+	conns := make([]*testClientConn, 20)
+	for i := range conns {
+		conns[i] = &testClientConn{CID: ConnID(fmt.Sprint(i)), UID: "user"}
+		h.add(conns[i])
+	}
+	started := time.Now()
+	h.drain(100 * time.Millisecond)
+	// 10 batches with 9 pauses of 10ms between them.
+	assert.True(t, time.Since(started) >= 90*time.Millisecond)
+	for _, c := range conns {
+		assert.True(t, c.Closed)
+		assert.True(t, c.Reconnect)
+	}
+}
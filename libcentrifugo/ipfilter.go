@@ -0,0 +1,140 @@
+package libcentrifugo
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/FZambia/go-logger"
+)
+
+// ipFilter contains networks parsed from api_allowed_ips, admin_allowed_ips and
+// trusted_proxies options.
+type ipFilter struct {
+	api     []*net.IPNet
+	admin   []*net.IPNet
+	trusted []*net.IPNet
+}
+
+// ipFilter parses networks of IP filter options.
+func (c *Config) ipFilter() (ipFilter, error) {
+	var f ipFilter
+	var err error
+	if f.api, err = parseIPNets(c.APIAllowedIPs); err != nil {
+		return ipFilter{}, errors.New("wrong api_allowed_ips – " + err.Error())
+	}
+	if f.admin, err = parseIPNets(c.AdminAllowedIPs); err != nil {
+		return ipFilter{}, errors.New("wrong admin_allowed_ips – " + err.Error())
+	}
+	if f.trusted, err = parseIPNets(c.TrustedProxies); err != nil {
+		return ipFilter{}, errors.New("wrong trusted_proxies – " + err.Error())
+	}
+	return f, nil
+}
+
+// parseIPNets parses list of CIDR networks, single address means network with
+// this address only.
+func parseIPNets(list []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, errors.New("malformed address " + s)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, errors.New("malformed network " + s)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// ipInNets checks whether address belongs to one of networks.
+func ipInNets(ip net.IP, nets []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestIP returns address of client made request. Connections over unix socket
+// have no address and considered coming from loopback. When request came from
+// trusted proxy X-Forwarded-For header is walked from right to left skipping
+// trusted proxies, so client can not forge its address by sending header itself.
+func requestIP(r *http.Request, trusted []*net.IPNet) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		ip = net.IPv4(127, 0, 0, 1)
+	}
+	if !ipInNets(ip, trusted) {
+		return ip
+	}
+	forwarded := strings.Split(strings.Join(r.Header["X-Forwarded-For"], ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if addr == nil {
+			// malformed header, use last valid address.
+			break
+		}
+		ip = addr
+		if !ipInNets(ip, trusted) {
+			break
+		}
+	}
+	return ip
+}
+
+// requestAddr returns address of client made request resolved through trusted
+// proxies.
+func (app *Application) requestAddr(r *http.Request) string {
+	app.RLock()
+	trusted := app.ipFilter.trusted
+	app.RUnlock()
+	return requestIP(r, trusted).String()
+}
+
+// WrapAllowedIPs rejects requests with 403 Forbidden if address of client not in
+// admin_allowed_ips (when admin is true) or api_allowed_ips.
+func (app *Application) WrapAllowedIPs(h http.Handler, admin bool) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		app.RLock()
+		allowed := app.ipFilter.api
+		if admin {
+			allowed = app.ipFilter.admin
+		}
+		trusted := app.ipFilter.trusted
+		app.RUnlock()
+		if len(allowed) > 0 {
+			ip := requestIP(r, trusted)
+			if !ipInNets(ip, allowed) {
+				logger.ERROR.Printf("request to %s from %s rejected as address not allowed", r.URL.Path, ip)
+				app.metrics.NumIPDenied.Inc()
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
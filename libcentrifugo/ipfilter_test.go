@@ -0,0 +1,84 @@
+package libcentrifugo
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseIPNets(t *testing.T) {
+	nets, err := parseIPNets([]string{"10.0.0.0/8", "192.168.1.1", "::1"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, len(nets))
+	assert.True(t, ipInNets(net.ParseIP("10.1.2.3"), nets))
+	assert.True(t, ipInNets(net.ParseIP("192.168.1.1"), nets))
+	assert.False(t, ipInNets(net.ParseIP("192.168.1.2"), nets))
+	assert.True(t, ipInNets(net.ParseIP("::1"), nets))
+
+	_, err = parseIPNets([]string{"10.0.0.0/33"})
+	assert.NotEqual(t, nil, err)
+	_, err = parseIPNets([]string{"localhost"})
+	assert.NotEqual(t, nil, err)
+
+	c := newTestConfig()
+	c.AdminAllowedIPs = []string{"wrong"}
+	assert.NotEqual(t, nil, c.Validate())
+}
+
+func TestRequestIP(t *testing.T) {
+	trusted, _ := parseIPNets([]string{"10.0.0.1"})
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "1.2.3.4:5000"
+	r.Header.Set("X-Forwarded-For", "5.6.7.8")
+	// header of untrusted peer ignored.
+	assert.Equal(t, "1.2.3.4", requestIP(r, trusted).String())
+
+	r.RemoteAddr = "10.0.0.1:5000"
+	assert.Equal(t, "5.6.7.8", requestIP(r, trusted).String())
+	// address prepended by client itself ignored.
+	r.Header.Set("X-Forwarded-For", "127.0.0.1, 5.6.7.8")
+	assert.Equal(t, "5.6.7.8", requestIP(r, trusted).String())
+	r.Header.Del("X-Forwarded-For")
+	assert.Equal(t, "10.0.0.1", requestIP(r, trusted).String())
+
+	// unix socket connection.
+	r.RemoteAddr = "@"
+	assert.True(t, requestIP(r, trusted).IsLoopback())
+}
+
+func TestWrapAllowedIPs(t *testing.T) {
+	app := testApp()
+	c := *app.config
+	c.APIAllowedIPs = []string{"10.0.0.0/8"}
+	c.TrustedProxies = []string{"127.0.0.1"}
+	app.SetConfig(&c)
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req, _ := http.NewRequest("POST", "/api/", nil)
+	req.RemoteAddr = "10.1.1.1:5000"
+	rec := httptest.NewRecorder()
+	app.WrapAllowedIPs(h, false).ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	req.RemoteAddr = "127.0.0.1:5000"
+	rec = httptest.NewRecorder()
+	app.WrapAllowedIPs(h, false).ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Equal(t, int64(1), app.metrics.NumIPDenied.LoadRaw())
+
+	req.Header.Set("X-Forwarded-For", "10.2.2.2")
+	rec = httptest.NewRecorder()
+	app.WrapAllowedIPs(h, false).ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// admin endpoints not restricted by api_allowed_ips.
+	req.RemoteAddr = "1.2.3.4:5000"
+	rec = httptest.NewRecorder()
+	app.WrapAllowedIPs(h, true).ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
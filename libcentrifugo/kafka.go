@@ -0,0 +1,206 @@
+package libcentrifugo
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/FZambia/go-logger"
+	"github.com/segmentio/kafka-go"
+)
+
+// Kafka bridge behaviour when record can not be published because of engine error.
+const (
+	kafkaErrorPolicySkip  = "skip"
+	kafkaErrorPolicyRetry = "retry"
+)
+
+const (
+	// kafkaRetryBackoff is a base interval between retries of reading from Kafka
+	// and publishing record.
+	kafkaRetryBackoff = 100 * time.Millisecond
+	// kafkaRetryMaxDelay is a max interval between retries.
+	kafkaRetryMaxDelay = 10 * time.Second
+)
+
+// kafkaReader is a part of Kafka consumer used by bridge.
+type kafkaReader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Stats() kafka.ReaderStats
+	Close() error
+}
+
+// kafkaRecord is a value of Kafka record published into channel.
+type kafkaRecord struct {
+	Channel Channel         `json:"channel"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// decodeKafkaRecord returns channel and data of Kafka record. Record key used as
+// channel when channel not set in record value.
+func decodeKafkaRecord(msg kafka.Message) (Channel, []byte, error) {
+	var record kafkaRecord
+	if err := json.Unmarshal(msg.Value, &record); err != nil {
+		return "", nil, err
+	}
+	if record.Channel == "" {
+		record.Channel = Channel(msg.Key)
+	}
+	if record.Channel == "" || len(record.Data) == 0 {
+		return "", nil, ErrInvalidMessage
+	}
+	return record.Channel, record.Data, nil
+}
+
+// permanentPublishError returns true if publish failed because of message itself
+// or channel options so retry can not succeed.
+func permanentPublishError(err error) bool {
+	switch err {
+	case ErrInvalidMessage, ErrNamespaceNotFound, ErrLimitExceeded, ErrPermissionDenied:
+		return true
+	}
+	return false
+}
+
+// kafkaBridge consumes Kafka topics and publishes records into channels using
+// node publish path so channel options and history respected. Offset of record
+// committed after record published or skipped.
+type kafkaBridge struct {
+	app         *Application
+	readers     []kafkaReader
+	errorPolicy string
+	ctx         context.Context
+	cancel      context.CancelFunc
+	wg          sync.WaitGroup
+}
+
+// newKafkaBridge creates consumers of configured topics in consumer group.
+func newKafkaBridge(app *Application, c *Config) *kafkaBridge {
+	readers := make([]kafkaReader, len(c.KafkaTopics))
+	for i, topic := range c.KafkaTopics {
+		readers[i] = kafka.NewReader(kafka.ReaderConfig{
+			Brokers:        c.KafkaBrokers,
+			GroupID:        c.KafkaConsumerGroup,
+			Topic:          topic,
+			CommitInterval: c.KafkaCommitInterval,
+			ErrorLogger:    logger.ERROR,
+		})
+	}
+	return newKafkaBridgeWithReaders(app, readers, c.KafkaErrorPolicy)
+}
+
+func newKafkaBridgeWithReaders(app *Application, readers []kafkaReader, errorPolicy string) *kafkaBridge {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &kafkaBridge{
+		app:         app,
+		readers:     readers,
+		errorPolicy: errorPolicy,
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+}
+
+// run starts consuming topics.
+func (b *kafkaBridge) run() {
+	for _, r := range b.readers {
+		b.wg.Add(1)
+		go func(r kafkaReader) {
+			defer b.wg.Done()
+			b.consume(r)
+		}(r)
+	}
+}
+
+// close stops consuming and closes consumers committing pending offsets.
+func (b *kafkaBridge) close() {
+	b.cancel()
+	b.wg.Wait()
+	for _, r := range b.readers {
+		if err := r.Close(); err != nil {
+			logger.ERROR.Printf("error closing Kafka consumer: %v", err)
+		}
+	}
+}
+
+// lag returns sum of consumer lag of topics.
+func (b *kafkaBridge) lag() int64 {
+	var lag int64
+	for _, r := range b.readers {
+		if l := r.Stats().Lag; l > 0 {
+			lag += l
+		}
+	}
+	return lag
+}
+
+// wait sleeps before retry attempt, returns false if bridge closed meanwhile.
+func (b *kafkaBridge) wait(attempt int) bool {
+	delay := time.Duration(attempt) * kafkaRetryBackoff
+	if delay > kafkaRetryMaxDelay {
+		delay = kafkaRetryMaxDelay
+	}
+	select {
+	case <-b.ctx.Done():
+		return false
+	case <-time.After(delay):
+		return true
+	}
+}
+
+func (b *kafkaBridge) consume(r kafkaReader) {
+	failures := 0
+	for {
+		msg, err := r.FetchMessage(b.ctx)
+		if err != nil {
+			if b.ctx.Err() != nil || err == io.EOF {
+				return
+			}
+			failures++
+			logger.ERROR.Printf("error reading from Kafka: %v", err)
+			if !b.wait(failures) {
+				return
+			}
+			continue
+		}
+		failures = 0
+		if !b.handle(msg) {
+			return
+		}
+		if err := r.CommitMessages(b.ctx, msg); err != nil {
+			if b.ctx.Err() != nil {
+				return
+			}
+			logger.ERROR.Printf("error committing offset %d of Kafka topic %s partition %d: %v", msg.Offset, msg.Topic, msg.Partition, err)
+		}
+	}
+}
+
+// handle publishes record into channel. It returns false if bridge closed while
+// record publish retried so record must not be committed.
+func (b *kafkaBridge) handle(msg kafka.Message) bool {
+	ch, data, err := decodeKafkaRecord(msg)
+	if err != nil {
+		logger.ERROR.Printf("malformed record %d of Kafka topic %s partition %d skipped: %v", msg.Offset, msg.Topic, msg.Partition, err)
+		b.app.metrics.NumKafkaSkipped.Inc()
+		return true
+	}
+	for attempt := 1; ; attempt++ {
+		_, err := b.app.publish(ch, data, "", "", nil, false, nil)
+		if err == nil {
+			b.app.metrics.NumKafkaPublished.Inc()
+			return true
+		}
+		if b.errorPolicy != kafkaErrorPolicyRetry || permanentPublishError(err) {
+			logger.ERROR.Printf("record %d of Kafka topic %s partition %d not published into channel %s, skipped: %v", msg.Offset, msg.Topic, msg.Partition, ch, err)
+			b.app.metrics.NumKafkaSkipped.Inc()
+			return true
+		}
+		logger.ERROR.Printf("error publishing record %d of Kafka topic %s partition %d into channel %s, retrying: %v", msg.Offset, msg.Topic, msg.Partition, ch, err)
+		if !b.wait(attempt) {
+			return false
+		}
+	}
+}
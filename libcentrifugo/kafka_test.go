@@ -0,0 +1,171 @@
+package libcentrifugo
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// testKafkaReader is a Kafka consumer returning records from channel and keeping
+// committed offsets.
+type testKafkaReader struct {
+	sync.Mutex
+	msgs    chan kafka.Message
+	commits []int64
+	closed  bool
+	lag     int64
+}
+
+func newTestKafkaReader(msgs ...kafka.Message) *testKafkaReader {
+	r := &testKafkaReader{msgs: make(chan kafka.Message, len(msgs))}
+	for i, msg := range msgs {
+		msg.Topic = "events"
+		msg.Offset = int64(i)
+		r.msgs <- msg
+	}
+	return r
+}
+
+func (r *testKafkaReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	select {
+	case <-ctx.Done():
+		return kafka.Message{}, ctx.Err()
+	case msg := <-r.msgs:
+		return msg, nil
+	}
+}
+
+func (r *testKafkaReader) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	r.Lock()
+	defer r.Unlock()
+	for _, msg := range msgs {
+		r.commits = append(r.commits, msg.Offset)
+	}
+	return nil
+}
+
+func (r *testKafkaReader) committed() []int64 {
+	r.Lock()
+	defer r.Unlock()
+	return append([]int64(nil), r.commits...)
+}
+
+func (r *testKafkaReader) Stats() kafka.ReaderStats {
+	return kafka.ReaderStats{Lag: r.lag}
+}
+
+func (r *testKafkaReader) Close() error {
+	r.Lock()
+	defer r.Unlock()
+	r.closed = true
+	return nil
+}
+
+// testFailingEngine is an engine which fails to publish first failures messages.
+type testFailingEngine struct {
+	*testEngine
+	failures  int32
+	published int32
+}
+
+func (e *testFailingEngine) publishMessage(ch Channel, message *Message, opts *ChannelOptions) <-chan error {
+	if atomic.AddInt32(&e.failures, -1) >= 0 {
+		return makeErrChan(errors.New("engine error"))
+	}
+	atomic.AddInt32(&e.published, 1)
+	return makeErrChan(nil)
+}
+
+func TestDecodeKafkaRecord(t *testing.T) {
+	ch, data, err := decodeKafkaRecord(kafka.Message{Value: []byte(`{"channel":"test","data":{"n":1}}`)})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, Channel("test"), ch)
+	assert.Equal(t, `{"n":1}`, string(data))
+
+	ch, _, err = decodeKafkaRecord(kafka.Message{Key: []byte("key"), Value: []byte(`{"data":{}}`)})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, Channel("key"), ch)
+
+	_, _, err = decodeKafkaRecord(kafka.Message{Value: []byte(`{"data":{}}`)})
+	assert.Equal(t, ErrInvalidMessage, err)
+	_, _, err = decodeKafkaRecord(kafka.Message{Value: []byte(`{"channel":"test"}`)})
+	assert.Equal(t, ErrInvalidMessage, err)
+	_, _, err = decodeKafkaRecord(kafka.Message{Value: []byte(`not json`)})
+	assert.NotEqual(t, nil, err)
+}
+
+func TestKafkaBridge(t *testing.T) {
+	c := newTestConfig()
+	c.ChannelOptions.HistorySize = 10
+	c.ChannelOptions.HistoryLifetime = 60
+	app := testMemoryAppWithConfig(&c)
+
+	r := newTestKafkaReader(
+		kafka.Message{Value: []byte(`{"channel":"test","data":{"n":1}}`)},
+		kafka.Message{Key: []byte("test"), Value: []byte(`{"data":{"n":2}}`)},
+		kafka.Message{Value: []byte(`not json`)},
+		kafka.Message{Value: []byte(`{"channel":"unknown:test","data":{}}`)},
+	)
+	r.lag = 5
+	b := newKafkaBridgeWithReaders(app, []kafkaReader{r}, kafkaErrorPolicyRetry)
+	b.run()
+	assert.True(t, waitCondition(func() bool {
+		return len(r.committed()) == 4
+	}))
+	assert.Equal(t, []int64{0, 1, 2, 3}, r.committed())
+	assert.Equal(t, int64(5), b.lag())
+	b.close()
+	assert.True(t, r.closed)
+
+	history, err := app.History("test")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(history))
+	assert.Equal(t, `{"n":2}`, string(*history[0].Data))
+	assert.Equal(t, int64(2), app.metrics.NumKafkaPublished.LoadRaw())
+	assert.Equal(t, int64(2), app.metrics.NumKafkaSkipped.LoadRaw())
+}
+
+func TestKafkaBridgeErrorPolicy(t *testing.T) {
+	app := testApp()
+	e := &testFailingEngine{testEngine: newTestEngine(), failures: 2}
+	app.SetEngine(e)
+
+	// record published again until engine succeeds.
+	r := newTestKafkaReader(kafka.Message{Value: []byte(`{"channel":"test","data":{}}`)})
+	b := newKafkaBridgeWithReaders(app, []kafkaReader{r}, kafkaErrorPolicyRetry)
+	b.run()
+	assert.True(t, waitCondition(func() bool {
+		return len(r.committed()) == 1
+	}))
+	b.close()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&e.published))
+	assert.Equal(t, int64(1), app.metrics.NumKafkaPublished.LoadRaw())
+
+	// record skipped after first failure.
+	atomic.StoreInt32(&e.failures, 1)
+	r = newTestKafkaReader(kafka.Message{Value: []byte(`{"channel":"test","data":{}}`)})
+	b = newKafkaBridgeWithReaders(app, []kafkaReader{r}, kafkaErrorPolicySkip)
+	b.run()
+	assert.True(t, waitCondition(func() bool {
+		return len(r.committed()) == 1
+	}))
+	b.close()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&e.published))
+	assert.Equal(t, int64(1), app.metrics.NumKafkaSkipped.LoadRaw())
+
+	// record retried when bridge closed is not committed.
+	atomic.StoreInt32(&e.failures, 1000)
+	r = newTestKafkaReader(kafka.Message{Value: []byte(`{"channel":"test","data":{}}`)})
+	b = newKafkaBridgeWithReaders(app, []kafkaReader{r}, kafkaErrorPolicyRetry)
+	b.run()
+	assert.True(t, waitCondition(func() bool {
+		return atomic.LoadInt32(&e.failures) < 999
+	}))
+	b.close()
+	assert.Equal(t, 0, len(r.committed()))
+}
@@ -1,6 +1,7 @@
 package libcentrifugo
 
 import (
+	"encoding/json"
 	"strconv"
 	"time"
 
@@ -17,6 +18,44 @@ func newClientInfo(user UserID, client ConnID, defaultInfo *raw.Raw, channelInfo
 	}
 }
 
+// PayloadEncodingBinary marks message with raw binary data. Such data encoded
+// into base64 string only when message sent using JSON framing.
+const PayloadEncodingBinary = "binary"
+
+// jsonMessage is a Message without custom JSON marshaling.
+type jsonMessage Message
+
+// MarshalJSON encodes message into JSON, binary data encoded as base64 string.
+func (m Message) MarshalJSON() ([]byte, error) {
+	if m.Encoding == PayloadEncodingBinary && m.Data != nil {
+		encoded, err := json.Marshal([]byte(*m.Data))
+		if err != nil {
+			return nil, err
+		}
+		data := raw.Raw(encoded)
+		m.Data = &data
+	}
+	return json.Marshal(jsonMessage(m))
+}
+
+// decodePayload returns message data for publish command. Binary data expected
+// to be sent as base64 encoded JSON string.
+func decodePayload(data []byte, encoding string) ([]byte, error) {
+	switch encoding {
+	case "":
+		return data, nil
+	case PayloadEncodingBinary:
+		var payload []byte
+		err := json.Unmarshal(data, &payload)
+		if err != nil || len(payload) == 0 {
+			return nil, ErrInvalidMessage
+		}
+		return payload, nil
+	default:
+		return nil, ErrInvalidMessage
+	}
+}
+
 func newMessage(ch Channel, data []byte, client ConnID, info *ClientInfo) *Message {
 	raw := raw.Raw(data)
 	return &Message{
@@ -69,6 +69,7 @@ type Message struct {
 	Data      *github_com_centrifugal_centrifugo_libcentrifugo_raw.Raw `protobuf:"bytes,4,opt,name=Data,customtype=github.com/centrifugal/centrifugo/libcentrifugo/raw.Raw" json:"data"`
 	Client    string                                                   `protobuf:"bytes,5,opt,name=Client" json:"client,omitempty"`
 	Info      *ClientInfo                                              `protobuf:"bytes,6,opt,name=Info" json:"info,omitempty"`
+	Encoding  string                                                   `protobuf:"bytes,7,opt,name=Encoding" json:"encoding,omitempty"`
 }
 
 func (m *Message) Reset()                    { *m = Message{} }
@@ -111,6 +112,13 @@ func (m *Message) GetInfo() *ClientInfo {
 	return nil
 }
 
+func (m *Message) GetEncoding() string {
+	if m != nil {
+		return m.Encoding
+	}
+	return ""
+}
+
 type JoinMessage struct {
 	Channel string     `protobuf:"bytes,1,opt,name=Channel" json:"channel"`
 	Data    ClientInfo `protobuf:"bytes,2,opt,name=Data" json:"data"`
@@ -313,6 +321,9 @@ func (this *Message) Equal(that interface{}) bool {
 	if !this.Info.Equal(that1.Info) {
 		return false
 	}
+	if this.Encoding != that1.Encoding {
+		return false
+	}
 	return true
 }
 func (this *JoinMessage) Equal(that interface{}) bool {
@@ -558,6 +569,10 @@ func (m *Message) MarshalTo(data []byte) (int, error) {
 		}
 		i += n4
 	}
+	data[i] = 0x3a
+	i++
+	i = encodeVarintMessage(data, i, uint64(len(m.Encoding)))
+	i += copy(data[i:], m.Encoding)
 	return i, nil
 }
 
@@ -747,6 +762,7 @@ func NewPopulatedMessage(r randyMessage, easy bool) *Message {
 	if r.Intn(10) != 0 {
 		this.Info = NewPopulatedClientInfo(r, easy)
 	}
+	this.Encoding = randStringMessage(r)
 	if !easy && r.Intn(10) != 0 {
 	}
 	return this
@@ -905,6 +921,8 @@ func (m *Message) Size() (n int) {
 		l = m.Info.Size()
 		n += 1 + l + sovMessage(uint64(l))
 	}
+	l = len(m.Encoding)
+	n += 1 + l + sovMessage(uint64(l))
 	return n
 }
 
@@ -1351,6 +1369,35 @@ func (m *Message) Unmarshal(data []byte) error {
 				return err
 			}
 			iNdEx = postIndex
+		case 7:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Encoding", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowMessage
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthMessage
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Encoding = string(data[iNdEx:postIndex])
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipMessage(data[iNdEx:])
@@ -1976,35 +2023,37 @@ var (
 )
 
 var fileDescriptorMessage = []byte{
-	// 477 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0xcc, 0x53, 0xcd, 0x8a, 0x13, 0x41,
-	0x10, 0xde, 0x4e, 0xc6, 0x09, 0xa9, 0x64, 0xa3, 0xf6, 0x41, 0xc6, 0x45, 0x92, 0x25, 0x78, 0xd8,
-	0x83, 0x4e, 0x40, 0x10, 0x0f, 0x9e, 0x9c, 0x04, 0x24, 0xe2, 0x82, 0x88, 0x39, 0xec, 0x49, 0x3a,
-	0x33, 0x3d, 0x93, 0x86, 0xe9, 0xe9, 0x61, 0xa6, 0xc7, 0xc5, 0xb7, 0xf0, 0x1d, 0x44, 0x58, 0xdf,
-	0xc0, 0x47, 0xd8, 0xa3, 0x67, 0x0f, 0xc1, 0x9f, 0x9b, 0x4f, 0xe0, 0xd1, 0xda, 0x9e, 0xde, 0x24,
-	0x1b, 0x84, 0x3d, 0xac, 0x82, 0x87, 0x82, 0xee, 0xae, 0xea, 0xef, 0xab, 0xef, 0xab, 0x6e, 0xd8,
-	0x95, 0xbc, 0x2c, 0x59, 0xc2, 0xfd, 0xbc, 0x50, 0x5a, 0xd1, 0xdd, 0x54, 0xcc, 0x43, 0x9e, 0xe9,
-	0x42, 0xc4, 0x55, 0xa2, 0xf6, 0xee, 0x27, 0x42, 0x2f, 0xaa, 0xb9, 0x1f, 0x2a, 0x39, 0x4a, 0x54,
-	0xa2, 0x46, 0xa6, 0x6a, 0x5e, 0xc5, 0x66, 0x67, 0x36, 0x66, 0x55, 0xdf, 0x1e, 0x9e, 0x34, 0x00,
-	0xc6, 0xa9, 0xc0, 0xfb, 0xd3, 0x2c, 0x56, 0x74, 0x0f, 0x9c, 0x59, 0xc9, 0x0b, 0x8f, 0xec, 0x93,
-	0x83, 0x76, 0xd0, 0x3d, 0x5d, 0x0e, 0x76, 0x7e, 0x2e, 0x07, 0x4e, 0x85, 0x67, 0xb4, 0x0f, 0x6e,
-	0x5d, 0xe9, 0x35, 0x4c, 0xb6, 0x67, 0xb3, 0x6e, 0x68, 0x4e, 0x69, 0x06, 0x9d, 0x09, 0x8f, 0x59,
-	0x95, 0x1a, 0x28, 0xaf, 0x89, 0x45, 0xdd, 0xe0, 0x08, 0x8b, 0xc8, 0x97, 0xe5, 0xe0, 0xd1, 0x46,
-	0x5b, 0xab, 0x6e, 0x59, 0xba, 0x5e, 0xab, 0xd1, 0x05, 0x1d, 0xa3, 0x82, 0x1d, 0xfb, 0x2f, 0xd9,
-	0x31, 0xe2, 0xdf, 0x8a, 0x6a, 0xd4, 0xd7, 0x02, 0x61, 0xef, 0x29, 0x29, 0x34, 0x97, 0xb9, 0x7e,
-	0x7b, 0xc6, 0x37, 0x5e, 0xb0, 0x2c, 0xe3, 0xa9, 0xe1, 0x73, 0xfe, 0x1a, 0x5f, 0x58, 0xa3, 0x6e,
-	0xf1, 0x0d, 0x3f, 0x36, 0xa0, 0x75, 0x58, 0x5b, 0x4f, 0x3d, 0x68, 0xce, 0xa6, 0x13, 0x6b, 0x53,
-	0xc7, 0x1a, 0xd1, 0xac, 0x44, 0x44, 0xef, 0x42, 0xfb, 0x95, 0xc0, 0x09, 0x69, 0x26, 0x73, 0x6b,
-	0xd4, 0x4d, 0x9b, 0x6f, 0xeb, 0xf3, 0x04, 0xdd, 0x87, 0x96, 0xed, 0xdd, 0xf8, 0xd4, 0x0e, 0xae,
-	0xdb, 0x9a, 0x96, 0x25, 0xa7, 0x33, 0x70, 0x26, 0x4c, 0x33, 0x2b, 0xeb, 0xe9, 0xd5, 0x65, 0x39,
-	0x11, 0xc2, 0xd1, 0x83, 0xd5, 0x10, 0xaf, 0x19, 0x5e, 0xcf, 0xf2, 0xde, 0xa8, 0x87, 0xb8, 0x61,
-	0xef, 0x63, 0x70, 0x8c, 0xaf, 0x2e, 0xd6, 0x75, 0x1e, 0xdc, 0xf6, 0x2f, 0xe0, 0xfa, 0xeb, 0x37,
-	0x13, 0x50, 0xbc, 0xde, 0xdb, 0xf2, 0x2a, 0x86, 0xce, 0x33, 0x25, 0xb2, 0x73, 0xbb, 0x36, 0xe4,
-	0x92, 0x3f, 0xcb, 0x7d, 0x68, 0xe5, 0x36, 0x2e, 0x63, 0x5b, 0xbd, 0xc9, 0x33, 0x39, 0xc3, 0x04,
-	0xba, 0xcf, 0x39, 0x7b, 0xc3, 0xff, 0x39, 0xd1, 0x07, 0x02, 0xbd, 0xb1, 0xc2, 0x3a, 0x95, 0x5e,
-	0xfe, 0x06, 0xf0, 0xa7, 0x1c, 0x72, 0xbd, 0x50, 0xd1, 0xf6, 0x4f, 0x91, 0xe6, 0x94, 0x1e, 0x81,
-	0xfb, 0x82, 0x15, 0x4c, 0x96, 0xf6, 0x93, 0x4c, 0xaf, 0x3e, 0x5d, 0x37, 0x37, 0x80, 0xc3, 0xf7,
-	0x04, 0xba, 0x4f, 0x22, 0xb9, 0xb6, 0xfe, 0x7f, 0xec, 0x32, 0xb8, 0xf3, 0xeb, 0x5b, 0x9f, 0x9c,
-	0x7c, 0xef, 0x93, 0x4f, 0x18, 0xa7, 0x18, 0x9f, 0x31, 0xbe, 0x62, 0xbc, 0xfb, 0xd1, 0xdf, 0xf9,
-	0x1d, 0x00, 0x00, 0xff, 0xff, 0x75, 0xf2, 0xa2, 0xb4, 0xe1, 0x04, 0x00, 0x00,
+	// 501 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xcc, 0x93, 0x41, 0x8b, 0xd3, 0x40,
+	0x14, 0xc7, 0x77, 0xda, 0x98, 0xda, 0xd7, 0x6e, 0xd5, 0x39, 0x48, 0x2c, 0x92, 0x94, 0xe2, 0xa1,
+	0x87, 0xb5, 0x05, 0x41, 0x3c, 0x78, 0x32, 0xad, 0x48, 0xc5, 0x05, 0x11, 0x7b, 0xd8, 0x93, 0x4c,
+	0x93, 0x49, 0x3a, 0x90, 0x99, 0x29, 0xc9, 0xc4, 0xc5, 0x6f, 0xe1, 0x77, 0x10, 0x61, 0x3f, 0x82,
+	0x1f, 0xa1, 0x47, 0x6f, 0x82, 0x87, 0xa0, 0xf1, 0xd6, 0x4f, 0xe0, 0x51, 0x76, 0x32, 0xdb, 0xed,
+	0x16, 0x61, 0x0f, 0xab, 0xb0, 0xb7, 0x99, 0xf7, 0xde, 0xbc, 0xff, 0x7b, 0xbf, 0xf7, 0x06, 0xf6,
+	0x39, 0xcd, 0x32, 0x12, 0xd3, 0xe1, 0x32, 0x95, 0x4a, 0xe2, 0xfd, 0x84, 0xcd, 0x03, 0x2a, 0x54,
+	0xca, 0xa2, 0x3c, 0x96, 0xdd, 0x87, 0x31, 0x53, 0x8b, 0x7c, 0x3e, 0x0c, 0x24, 0x1f, 0xc5, 0x32,
+	0x96, 0x23, 0x1d, 0x35, 0xcf, 0x23, 0x7d, 0xd3, 0x17, 0x7d, 0xaa, 0x5e, 0xf7, 0x4f, 0x6a, 0x00,
+	0xe3, 0x84, 0x51, 0xa1, 0xa6, 0x22, 0x92, 0xb8, 0x0b, 0xd6, 0x2c, 0xa3, 0xa9, 0x83, 0x7a, 0x68,
+	0xd0, 0xf4, 0xdb, 0xab, 0xc2, 0xdb, 0x5b, 0x17, 0x9e, 0x95, 0x67, 0x34, 0xc5, 0x2e, 0xd8, 0x55,
+	0xa4, 0x53, 0xd3, 0xde, 0x8e, 0xf1, 0xda, 0x81, 0xb6, 0x62, 0x01, 0xad, 0x09, 0x8d, 0x48, 0x9e,
+	0xe8, 0x54, 0x4e, 0xbd, 0x87, 0x06, 0x6d, 0xff, 0x68, 0x55, 0x78, 0xe8, 0x7b, 0xe1, 0x3d, 0xd9,
+	0x2a, 0x6b, 0x53, 0x2d, 0x49, 0xce, 0xcf, 0x72, 0x74, 0xa1, 0x8f, 0x51, 0x4a, 0x8e, 0x87, 0x6f,
+	0xc8, 0xf1, 0xba, 0xf0, 0xee, 0x86, 0x55, 0xd6, 0x77, 0x4c, 0x44, 0xf2, 0x40, 0x72, 0xa6, 0x28,
+	0x5f, 0xaa, 0x0f, 0xa7, 0x7a, 0xe3, 0x05, 0x11, 0x82, 0x26, 0x5a, 0xcf, 0xfa, 0x67, 0x7a, 0x41,
+	0x95, 0x75, 0x47, 0xaf, 0xff, 0xad, 0x06, 0x8d, 0xc3, 0x0a, 0x3d, 0x76, 0xa0, 0x3e, 0x9b, 0x4e,
+	0x0c, 0xa6, 0x96, 0x01, 0x51, 0xcf, 0x59, 0x88, 0x1f, 0x40, 0xf3, 0x2d, 0xe3, 0x34, 0x53, 0x84,
+	0x2f, 0x0d, 0xa8, 0x3b, 0xc6, 0xdf, 0x54, 0x67, 0x0e, 0xdc, 0x83, 0x86, 0xa9, 0x5d, 0x73, 0x6a,
+	0xfa, 0xb7, 0x4c, 0x4c, 0xc3, 0x88, 0xe3, 0x19, 0x58, 0x13, 0xa2, 0x88, 0x69, 0xeb, 0xc5, 0xd5,
+	0xdb, 0xb2, 0x42, 0xa2, 0x08, 0x1e, 0x6c, 0x86, 0x78, 0x43, 0xeb, 0x3a, 0x46, 0xf7, 0x76, 0x35,
+	0xc4, 0x2d, 0xbc, 0x4f, 0xc1, 0xd2, 0x5c, 0xed, 0x1e, 0x1a, 0xb4, 0x1e, 0xdd, 0x1b, 0x5e, 0xc8,
+	0x3b, 0x3c, 0xdf, 0x19, 0x1f, 0xaf, 0x0b, 0xaf, 0xb3, 0x33, 0x9b, 0x03, 0xb8, 0xf9, 0x5c, 0x04,
+	0x32, 0x64, 0x22, 0x76, 0x1a, 0x5a, 0xa8, 0x6b, 0x84, 0x30, 0x35, 0xf6, 0x2d, 0xb2, 0x11, 0xb4,
+	0x5e, 0x4a, 0x26, 0xce, 0xe0, 0x6e, 0xc1, 0x41, 0x7f, 0x87, 0xf3, 0xd8, 0xc0, 0xa9, 0x5d, 0x56,
+	0xdb, 0x66, 0x83, 0x4f, 0x9b, 0xef, 0xc7, 0xd0, 0x7e, 0x45, 0xc9, 0x7b, 0xfa, 0xdf, 0x85, 0x3e,
+	0x23, 0xe8, 0x8c, 0xa5, 0x50, 0xa9, 0x4c, 0x2e, 0xdf, 0x18, 0x17, 0xec, 0x43, 0xaa, 0x16, 0x32,
+	0xdc, 0xfd, 0x57, 0x5c, 0x5b, 0xf1, 0x11, 0xd8, 0xaf, 0x49, 0x4a, 0x78, 0x66, 0xbe, 0xd4, 0xf4,
+	0xea, 0xbb, 0x60, 0x2f, 0x75, 0xc2, 0xfe, 0x27, 0x04, 0xed, 0x67, 0x21, 0x67, 0xe2, 0x3a, 0x57,
+	0xe9, 0xdf, 0xff, 0xfd, 0xd3, 0x45, 0x27, 0xa5, 0x8b, 0xbe, 0x94, 0x2e, 0x5a, 0x95, 0x2e, 0xfa,
+	0x5a, 0xba, 0xe8, 0x47, 0xe9, 0xa2, 0x8f, 0xbf, 0xdc, 0xbd, 0x3f, 0x03, 0x00, 0x68, 0x4c, 0xfa,
+	0xbc, 0x0f, 0x05, 0x00, 0x00,
 }
@@ -25,6 +25,40 @@ func TestMessage(t *testing.T) {
 	assert.Equal(t, "test", unmarshalledMsg.Channel)
 }
 
+func TestMessageBinaryJSON(t *testing.T) {
+	msg := newMessage(Channel("test"), []byte{0, 1, 2}, "", nil)
+	msg.Encoding = PayloadEncodingBinary
+	msgBytes, err := json.Marshal(msg)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, strings.Contains(string(msgBytes), `"data":"AAEC"`))
+	assert.Equal(t, true, strings.Contains(string(msgBytes), `"encoding":"binary"`))
+
+	resp := newClientMessage()
+	resp.Body = *msg
+	respBytes, err := resp.Marshal()
+	assert.Equal(t, nil, err)
+	var unmarshalledResp clientMessageResponse
+	err = json.Unmarshal(respBytes, &unmarshalledResp)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, `"AAEC"`, string(*unmarshalledResp.Body.Data))
+	assert.Equal(t, PayloadEncodingBinary, unmarshalledResp.Body.Encoding)
+}
+
+func TestDecodePayload(t *testing.T) {
+	data, err := decodePayload([]byte(`{"a": 1}`), "")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, `{"a": 1}`, string(data))
+	data, err = decodePayload([]byte(`"AAEC"`), PayloadEncodingBinary)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []byte{0, 1, 2}, data)
+	_, err = decodePayload([]byte(`"not base64"`), PayloadEncodingBinary)
+	assert.Equal(t, ErrInvalidMessage, err)
+	_, err = decodePayload([]byte(`{"a": 1}`), PayloadEncodingBinary)
+	assert.Equal(t, ErrInvalidMessage, err)
+	_, err = decodePayload([]byte(`"AAEC"`), "unknown")
+	assert.Equal(t, ErrInvalidMessage, err)
+}
+
 func BenchmarkClientResponseMarshalJSON(b *testing.B) {
 	responses := make([]*clientMessageResponse, 10000)
 	for i := 0; i < 10000; i++ {
@@ -21,6 +21,22 @@ type serverStats struct {
 	MetricsInterval int64      `json:"metrics_interval"`
 }
 
+// clusterNode contains short information about node from its last ping.
+type clusterNode struct {
+	UID      string `json:"uid"`
+	Name     string `json:"name"`
+	Clients  int    `json:"num_clients"`
+	Unique   int    `json:"num_unique_clients"`
+	Channels int    `json:"num_channels"`
+	Started  int64  `json:"started_at"`
+	Uptime   int64  `json:"uptime"`
+	// LastPing is a number of seconds since last ping received from node.
+	LastPing int64 `json:"last_ping"`
+	// Stale is true if node did not send ping during node_info_max_delay, such
+	// node is removed from list on next node info cleanup.
+	Stale bool `json:"stale"`
+}
+
 // nodeInfo contains information and statistics about Centrifugo node.
 type nodeInfo struct {
 	UID        string `json:"uid"`
@@ -29,9 +45,15 @@ type nodeInfo struct {
 	Clients    int    `json:"num_clients"`
 	Unique     int    `json:"num_unique_clients"`
 	Channels   int    `json:"num_channels"`
-	Started    int64  `json:"started_at"`
-	Gomaxprocs int    `json:"gomaxprocs"`
-	NumCPU     int    `json:"num_cpu"`
+	// Subscriptions is a total number of subscriptions in all node channels.
+	Subscriptions int   `json:"num_subscriptions"`
+	Started       int64 `json:"started_at"`
+	// Uptime is a number of seconds node is running, calculated when info requested.
+	Uptime     int64 `json:"uptime"`
+	Gomaxprocs int   `json:"gomaxprocs"`
+	NumCPU     int   `json:"num_cpu"`
+	// Alarms contains levels of alarms currently raised on node.
+	Alarms map[string]string `json:"alarms,omitempty"`
 	metrics
 	updated int64
 }
@@ -51,6 +73,10 @@ type metrics struct {
 	// NumAPIRequests shows amount of requests to server API.
 	NumAPIRequests int64 `json:"num_api_requests"`
 
+	// NumAPILegacyFormRequests shows amount of requests to server API sent in deprecated
+	// application/x-www-form-urlencoded format.
+	NumAPILegacyFormRequests int64 `json:"num_api_legacy_form_requests"`
+
 	// NumClientRequests shows amount of requests to client API.
 	NumClientRequests int64 `json:"num_client_requests"`
 
@@ -80,8 +106,146 @@ type metrics struct {
 	// MemSys shows system memory usage in bytes.
 	MemSys int64 `json:"memory_sys"`
 
+	// NumConnections shows current number of client transport connections on node
+	// including not authenticated yet.
+	NumConnections int64 `json:"num_connections"`
+
+	// ClientQueueSizeMax shows max size in bytes of messages waiting in single
+	// client connection queue.
+	ClientQueueSizeMax int64 `json:"client_queue_size_max"`
+
+	// ClientQueueSizeP99 shows 99th percentile of client connection queue sizes in bytes.
+	ClientQueueSizeP99 int64 `json:"client_queue_size_p99"`
+
+	// ClientQueueAgeMax shows max time in milliseconds oldest message waits in
+	// client connection queue.
+	ClientQueueAgeMax int64 `json:"client_queue_age_max"`
+
+	// KafkaLag shows number of records Kafka bridge has not read yet – sum over
+	// consumed topics of lag of partition consumer fetched from last.
+	KafkaLag int64 `json:"kafka_lag"`
+
 	// CPU shows cpu usage (actually just a snapshot value) in percents.
 	CPU int64 `json:"cpu_usage"`
+
+	// NumDynamicNamespaces shows amount of namespaces created from namespace template.
+	NumDynamicNamespaces int64 `json:"num_dynamic_namespaces"`
+
+	// NumClientSlow shows amount of client connections closed because their
+	// message queue exceeded client_queue_max_size.
+	NumClientSlow int64 `json:"num_client_slow"`
+
+	// NumClientLimitExceeded shows amount of client commands rejected because of rate limit.
+	NumClientLimitExceeded int64 `json:"num_client_limit_exceeded"`
+
+	// NumClientDisconnects shows amount of client connections closed.
+	NumClientDisconnects int64 `json:"num_client_disconnects"`
+
+	// NumClientChurnLimited shows amount of subscribe commands rejected because
+	// connection exceeded subscription_churn_limit.
+	NumClientChurnLimited int64 `json:"num_client_churn_limited"`
+
+	// NumConnectionLogDropped shows amount of connection log events dropped because
+	// connection log writer could not keep up.
+	NumConnectionLogDropped int64 `json:"num_connection_log_dropped"`
+
+	// NumAcks shows amount of message acknowledgements received from clients.
+	NumAcks int64 `json:"num_acks"`
+
+	// NumAcksDropped shows amount of unacked messages dropped from full connection ack windows.
+	NumAcksDropped int64 `json:"num_acks_dropped"`
+
+	// BytesUncompressedOut shows size of messages sent into raw Websocket connections
+	// using permessage-deflate compression before compression.
+	BytesUncompressedOut int64 `json:"bytes_uncompressed_out"`
+
+	// BytesCompressedOut shows amount of data in bytes written into connections for
+	// compressed messages including frame headers.
+	BytesCompressedOut int64 `json:"bytes_compressed_out"`
+
+	// NumAPIRateLimited shows amount of HTTP API requests rejected because of
+	// api_requests_per_second limit.
+	NumAPIRateLimited int64 `json:"num_api_rate_limited"`
+
+	// NumAPIAsyncRejected shows amount of async API publications rejected because
+	// async queue was full.
+	NumAPIAsyncRejected int64 `json:"num_api_async_rejected"`
+
+	// NumConnectProxyErrors shows amount of connect proxy requests failed because
+	// proxy endpoint was not available or returned unexpected response.
+	NumConnectProxyErrors int64 `json:"num_connect_proxy_errors"`
+
+	// NumSubscribeProxyErrors shows amount of subscribe proxy requests failed because
+	// proxy endpoint was not available or returned unexpected response.
+	NumSubscribeProxyErrors int64 `json:"num_subscribe_proxy_errors"`
+
+	// NumPublishProxyRejected shows amount of client publications rejected by publish
+	// proxy backend or because publish proxy endpoint was not available.
+	NumPublishProxyRejected int64 `json:"num_publish_proxy_rejected"`
+
+	// NumOriginRejected shows how many browser requests rejected as their Origin not
+	// in allowed origins.
+	NumOriginRejected int64 `json:"num_origin_rejected"`
+
+	// NumClientSendTimeouts shows how many client connections closed as message was
+	// not written into connection during message_send_timeout.
+	NumClientSendTimeouts int64 `json:"num_client_send_timeouts"`
+
+	// NumClientsRejected shows how many new connections rejected as node reached
+	// max_clients limit.
+	NumClientsRejected int64 `json:"num_clients_rejected"`
+
+	// NumSecretPreviousUsed shows amount of connection tokens and private channel
+	// signs valid only with previous secret.
+	NumSecretPreviousUsed int64 `json:"num_secret_previous_used"`
+
+	// NumIPDenied shows how many API and admin requests rejected with 403 as their
+	// address not in api_allowed_ips or admin_allowed_ips.
+	NumIPDenied int64 `json:"num_ip_denied"`
+
+	// NumAdminAuthRejected shows how many admin web interface login attempts
+	// rejected because of wrong password or temporary lockout of remote address.
+	NumAdminAuthRejected int64 `json:"num_admin_auth_rejected"`
+
+	// NumAuditDropped shows how many audit log entries dropped because writer
+	// could not keep up.
+	NumAuditDropped int64 `json:"num_audit_dropped"`
+
+	// NumEngineErrors shows how many publications and channel subscriptions failed
+	// because of engine error.
+	NumEngineErrors int64 `json:"num_engine_errors"`
+
+	// NumPresenceCacheHits shows how many channel presence requests served from
+	// presence cache.
+	NumPresenceCacheHits int64 `json:"num_presence_cache_hits"`
+
+	// NumPresenceCacheMisses shows how many channel presence requests loaded
+	// presence from engine while presence cache enabled.
+	NumPresenceCacheMisses int64 `json:"num_presence_cache_misses"`
+
+	// NumClientQueueDropped shows amount of queued messages dropped from queues of
+	// slow connections subscribed only to channels with drop_oldest slow client policy.
+	NumClientQueueDropped int64 `json:"num_client_queue_dropped"`
+
+	// NumTracingSpansDropped shows amount of finished trace spans dropped because
+	// span exporter could not keep up.
+	NumTracingSpansDropped int64 `json:"num_tracing_spans_dropped"`
+
+	// NumKafkaPublished shows amount of Kafka records published into channels by
+	// Kafka bridge.
+	NumKafkaPublished int64 `json:"num_kafka_published"`
+
+	// NumKafkaSkipped shows amount of Kafka records Kafka bridge skipped because
+	// they are malformed or could not be published.
+	NumKafkaSkipped int64 `json:"num_kafka_skipped"`
+
+	// NumAPIKeyRequests shows amount of requests to server API signed with API keys
+	// by key name.
+	NumAPIKeyRequests map[string]int64 `json:"num_api_key_requests,omitempty"`
+
+	// Namespaces shows message counters by channel namespace, channels without
+	// namespace counted under default name.
+	Namespaces map[string]namespaceMetrics `json:"namespaces,omitempty"`
 }
 
 // metricsRegistry contains various Centrifugo statistic and metric information aggregated
@@ -95,21 +259,62 @@ type metrics struct {
 // Add any new members to the END of this struct unless they can guarantee 64 bit alignment
 // (i.e. (u)int64 or 2 x (u)int32 etc.)
 type metricsRegistry struct {
-	NumMsgPublished   metricCounter
-	NumMsgQueued      metricCounter
-	NumMsgSent        metricCounter
-	NumAPIRequests    metricCounter
-	NumClientRequests metricCounter
-	BytesClientIn     metricCounter
-	BytesClientOut    metricCounter
-	histograms        *hdrhistogram.HDRHistogramRegistry
-	MemSys            int64
-	CPU               int64
+	NumMsgPublished          metricCounter
+	NumMsgQueued             metricCounter
+	NumMsgSent               metricCounter
+	NumAPIRequests           metricCounter
+	NumClientRequests        metricCounter
+	BytesClientIn            metricCounter
+	BytesClientOut           metricCounter
+	NumAPILegacyFormRequests metricCounter
+	NumClientDisconnects     metricCounter
+	NumClientLimitExceeded   metricCounter
+	NumClientSlow            metricCounter
+	NumClientChurnLimited    metricCounter
+	NumConnectionLogDropped  metricCounter
+	NumAcks                  metricCounter
+	NumAcksDropped           metricCounter
+	BytesUncompressedOut     metricCounter
+	BytesCompressedOut       metricCounter
+	NumAPIRateLimited        metricCounter
+	NumAPIAsyncRejected      metricCounter
+	NumConnectProxyErrors    metricCounter
+	NumSubscribeProxyErrors  metricCounter
+	NumPublishProxyRejected  metricCounter
+	NumOriginRejected        metricCounter
+	NumClientSendTimeouts    metricCounter
+	NumClientsRejected       metricCounter
+	NumSecretPreviousUsed    metricCounter
+	NumIPDenied              metricCounter
+	NumAdminAuthRejected     metricCounter
+	NumAuditDropped          metricCounter
+	NumEngineErrors          metricCounter
+	NumPresenceCacheHits     metricCounter
+	NumPresenceCacheMisses   metricCounter
+	NumClientQueueDropped    metricCounter
+	NumTracingSpansDropped   metricCounter
+	NumKafkaPublished        metricCounter
+	NumKafkaSkipped          metricCounter
+	histograms               *hdrhistogram.HDRHistogramRegistry
+	MemSys                   int64
+	NumConnections           int64
+	ClientQueueSizeMax       int64
+	ClientQueueSizeP99       int64
+	ClientQueueAgeMax        int64
+	KafkaLag                 int64
+	CPU                      int64
+	NumDynamicNamespaces     int64
 
 	// mu protects from multiple processes updating snapshot values at once
 	// but raw counters may still increment atomically while held so it's not a strict
 	// point-in-time snapshot of all values.
 	mu sync.Mutex
+
+	// apiKeyRequests are counters of API requests per API key name.
+	apiKeyRequests *namedCounters
+
+	// namespaces are message counters per channel namespace.
+	namespaces *namespaceRegistry
 }
 
 func newMetricsHistogramRegistry() *hdrhistogram.HDRHistogramRegistry {
@@ -121,11 +326,19 @@ func newMetricsHistogramRegistry() *hdrhistogram.HDRHistogramRegistry {
 	registry := hdrhistogram.NewHDRHistogramRegistry()
 	registry.Register(hdrhistogram.NewHDRHistogram("http_api", numBuckets, minValue, maxValue, sigfigs, quantiles, "microseconds"))
 	registry.Register(hdrhistogram.NewHDRHistogram("client_api", numBuckets, minValue, maxValue, sigfigs, quantiles, "microseconds"))
+	registry.Register(hdrhistogram.NewHDRHistogram("engine_subscribe", numBuckets, minValue, maxValue, sigfigs, quantiles, "microseconds"))
+	registry.Register(hdrhistogram.NewHDRHistogram("client_ack", numBuckets, minValue, maxValue, sigfigs, quantiles, "microseconds"))
+	registry.Register(hdrhistogram.NewHDRHistogram("connect_proxy", numBuckets, minValue, maxValue, sigfigs, quantiles, "microseconds"))
+	registry.Register(hdrhistogram.NewHDRHistogram("subscribe_proxy", numBuckets, minValue, maxValue, sigfigs, quantiles, "microseconds"))
+	registry.Register(hdrhistogram.NewHDRHistogram("publish_proxy", numBuckets, minValue, maxValue, sigfigs, quantiles, "microseconds"))
 	return registry
 }
 
 func newMetricsRegistry() *metricsRegistry {
-	registry := &metricsRegistry{}
+	registry := &metricsRegistry{
+		apiKeyRequests: newNamedCounters(),
+		namespaces:     newNamespaceRegistry(),
+	}
 	registry.histograms = newMetricsHistogramRegistry()
 	return registry
 }
@@ -176,6 +389,61 @@ func (c *metricCounter) updateDelta() {
 	c.lastIntervalValue = now
 }
 
+// namedCounters is a set of counters created on first use by name.
+type namedCounters struct {
+	// mu protects map itself, counters incremented atomically.
+	mu       sync.RWMutex
+	counters map[string]*metricCounter
+}
+
+func newNamedCounters() *namedCounters {
+	return &namedCounters{counters: make(map[string]*metricCounter)}
+}
+
+// inc increments counter with name.
+func (n *namedCounters) inc(name string) {
+	n.mu.RLock()
+	counter, ok := n.counters[name]
+	n.mu.RUnlock()
+	if !ok {
+		n.mu.Lock()
+		counter, ok = n.counters[name]
+		if !ok {
+			counter = &metricCounter{}
+			n.counters[name] = counter
+		}
+		n.mu.Unlock()
+	}
+	counter.Inc()
+}
+
+// values returns raw or last interval values of counters, nil if no counters
+// created yet.
+func (n *namedCounters) values(raw bool) map[string]int64 {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if len(n.counters) == 0 {
+		return nil
+	}
+	values := make(map[string]int64, len(n.counters))
+	for name, counter := range n.counters {
+		if raw {
+			values[name] = counter.LoadRaw()
+		} else {
+			values[name] = counter.LastIn()
+		}
+	}
+	return values
+}
+
+func (n *namedCounters) updateDelta() {
+	n.mu.RLock()
+	for _, counter := range n.counters {
+		counter.updateDelta()
+	}
+	n.mu.RUnlock()
+}
+
 func (m *metricsRegistry) UpdateSnapshot() {
 	// We update under a lock to ensure that no other process is also updating
 	// snapshot nor copying the values with GetRawMetrics/GetSnapshotMetrics.
@@ -203,6 +471,38 @@ func (m *metricsRegistry) UpdateSnapshot() {
 	m.NumClientRequests.updateDelta()
 	m.BytesClientIn.updateDelta()
 	m.BytesClientOut.updateDelta()
+	m.NumAPILegacyFormRequests.updateDelta()
+	m.NumClientDisconnects.updateDelta()
+	m.NumClientLimitExceeded.updateDelta()
+	m.NumClientSlow.updateDelta()
+	m.NumClientChurnLimited.updateDelta()
+	m.NumConnectionLogDropped.updateDelta()
+	m.NumAcks.updateDelta()
+	m.NumAcksDropped.updateDelta()
+	m.BytesUncompressedOut.updateDelta()
+	m.BytesCompressedOut.updateDelta()
+	m.NumAPIRateLimited.updateDelta()
+	m.NumAPIAsyncRejected.updateDelta()
+	m.NumConnectProxyErrors.updateDelta()
+	m.NumSubscribeProxyErrors.updateDelta()
+	m.NumPublishProxyRejected.updateDelta()
+	m.NumOriginRejected.updateDelta()
+	m.NumClientSendTimeouts.updateDelta()
+	m.NumClientsRejected.updateDelta()
+	m.NumSecretPreviousUsed.updateDelta()
+	m.NumIPDenied.updateDelta()
+	m.NumAdminAuthRejected.updateDelta()
+	m.NumAuditDropped.updateDelta()
+	m.NumEngineErrors.updateDelta()
+	m.NumPresenceCacheHits.updateDelta()
+	m.NumPresenceCacheMisses.updateDelta()
+	m.NumClientQueueDropped.updateDelta()
+	m.NumTracingSpansDropped.updateDelta()
+	m.NumKafkaPublished.updateDelta()
+	m.NumKafkaSkipped.updateDelta()
+
+	m.apiKeyRequests.updateDelta()
+	m.namespaces.updateDelta()
 
 	m.histograms.Rotate()
 }
@@ -213,16 +513,53 @@ func (m *metricsRegistry) GetRawMetrics() *metrics {
 	defer m.mu.Unlock()
 
 	return &metrics{
-		NumMsgPublished:   m.NumMsgPublished.LoadRaw(),
-		NumMsgQueued:      m.NumMsgQueued.LoadRaw(),
-		NumMsgSent:        m.NumMsgSent.LoadRaw(),
-		NumAPIRequests:    m.NumAPIRequests.LoadRaw(),
-		NumClientRequests: m.NumClientRequests.LoadRaw(),
-		BytesClientIn:     m.BytesClientIn.LoadRaw(),
-		BytesClientOut:    m.BytesClientOut.LoadRaw(),
-		MemSys:            atomic.LoadInt64(&m.MemSys),
-		CPU:               atomic.LoadInt64(&m.CPU),
-		Latencies:         m.histograms.LoadValues(),
+		NumMsgPublished:          m.NumMsgPublished.LoadRaw(),
+		NumMsgQueued:             m.NumMsgQueued.LoadRaw(),
+		NumMsgSent:               m.NumMsgSent.LoadRaw(),
+		NumAPIRequests:           m.NumAPIRequests.LoadRaw(),
+		NumClientRequests:        m.NumClientRequests.LoadRaw(),
+		BytesClientIn:            m.BytesClientIn.LoadRaw(),
+		BytesClientOut:           m.BytesClientOut.LoadRaw(),
+		NumAPILegacyFormRequests: m.NumAPILegacyFormRequests.LoadRaw(),
+		NumClientDisconnects:     m.NumClientDisconnects.LoadRaw(),
+		NumClientLimitExceeded:   m.NumClientLimitExceeded.LoadRaw(),
+		NumClientSlow:            m.NumClientSlow.LoadRaw(),
+		NumClientChurnLimited:    m.NumClientChurnLimited.LoadRaw(),
+		NumConnectionLogDropped:  m.NumConnectionLogDropped.LoadRaw(),
+		NumAcks:                  m.NumAcks.LoadRaw(),
+		NumAcksDropped:           m.NumAcksDropped.LoadRaw(),
+		BytesUncompressedOut:     m.BytesUncompressedOut.LoadRaw(),
+		BytesCompressedOut:       m.BytesCompressedOut.LoadRaw(),
+		NumAPIRateLimited:        m.NumAPIRateLimited.LoadRaw(),
+		NumAPIAsyncRejected:      m.NumAPIAsyncRejected.LoadRaw(),
+		NumConnectProxyErrors:    m.NumConnectProxyErrors.LoadRaw(),
+		NumSubscribeProxyErrors:  m.NumSubscribeProxyErrors.LoadRaw(),
+		NumPublishProxyRejected:  m.NumPublishProxyRejected.LoadRaw(),
+		NumOriginRejected:        m.NumOriginRejected.LoadRaw(),
+		NumClientSendTimeouts:    m.NumClientSendTimeouts.LoadRaw(),
+		NumClientsRejected:       m.NumClientsRejected.LoadRaw(),
+		NumSecretPreviousUsed:    m.NumSecretPreviousUsed.LoadRaw(),
+		NumIPDenied:              m.NumIPDenied.LoadRaw(),
+		NumAdminAuthRejected:     m.NumAdminAuthRejected.LoadRaw(),
+		NumAuditDropped:          m.NumAuditDropped.LoadRaw(),
+		NumEngineErrors:          m.NumEngineErrors.LoadRaw(),
+		NumPresenceCacheHits:     m.NumPresenceCacheHits.LoadRaw(),
+		NumPresenceCacheMisses:   m.NumPresenceCacheMisses.LoadRaw(),
+		NumClientQueueDropped:    m.NumClientQueueDropped.LoadRaw(),
+		NumTracingSpansDropped:   m.NumTracingSpansDropped.LoadRaw(),
+		NumKafkaPublished:        m.NumKafkaPublished.LoadRaw(),
+		NumKafkaSkipped:          m.NumKafkaSkipped.LoadRaw(),
+		NumAPIKeyRequests:        m.apiKeyRequests.values(true),
+		Namespaces:               m.namespaces.values(true),
+		MemSys:                   atomic.LoadInt64(&m.MemSys),
+		NumConnections:           atomic.LoadInt64(&m.NumConnections),
+		ClientQueueSizeMax:       atomic.LoadInt64(&m.ClientQueueSizeMax),
+		ClientQueueSizeP99:       atomic.LoadInt64(&m.ClientQueueSizeP99),
+		ClientQueueAgeMax:        atomic.LoadInt64(&m.ClientQueueAgeMax),
+		KafkaLag:                 atomic.LoadInt64(&m.KafkaLag),
+		CPU:                      atomic.LoadInt64(&m.CPU),
+		NumDynamicNamespaces:     atomic.LoadInt64(&m.NumDynamicNamespaces),
+		Latencies:                m.histograms.LoadValues(),
 	}
 }
 
@@ -233,16 +570,53 @@ func (m *metricsRegistry) GetSnapshotMetrics() *metrics {
 	defer m.mu.Unlock()
 
 	return &metrics{
-		NumMsgPublished:   m.NumMsgPublished.LastIn(),
-		NumMsgQueued:      m.NumMsgQueued.LastIn(),
-		NumMsgSent:        m.NumMsgSent.LastIn(),
-		NumAPIRequests:    m.NumAPIRequests.LastIn(),
-		NumClientRequests: m.NumClientRequests.LastIn(),
-		BytesClientIn:     m.BytesClientIn.LastIn(),
-		BytesClientOut:    m.BytesClientOut.LastIn(),
-		MemSys:            atomic.LoadInt64(&m.MemSys),
-		CPU:               atomic.LoadInt64(&m.CPU),
-		Latencies:         m.histograms.LoadValues(),
+		NumMsgPublished:          m.NumMsgPublished.LastIn(),
+		NumMsgQueued:             m.NumMsgQueued.LastIn(),
+		NumMsgSent:               m.NumMsgSent.LastIn(),
+		NumAPIRequests:           m.NumAPIRequests.LastIn(),
+		NumClientRequests:        m.NumClientRequests.LastIn(),
+		BytesClientIn:            m.BytesClientIn.LastIn(),
+		BytesClientOut:           m.BytesClientOut.LastIn(),
+		NumAPILegacyFormRequests: m.NumAPILegacyFormRequests.LastIn(),
+		NumClientDisconnects:     m.NumClientDisconnects.LastIn(),
+		NumClientLimitExceeded:   m.NumClientLimitExceeded.LastIn(),
+		NumClientSlow:            m.NumClientSlow.LastIn(),
+		NumClientChurnLimited:    m.NumClientChurnLimited.LastIn(),
+		NumConnectionLogDropped:  m.NumConnectionLogDropped.LastIn(),
+		NumAcks:                  m.NumAcks.LastIn(),
+		NumAcksDropped:           m.NumAcksDropped.LastIn(),
+		BytesUncompressedOut:     m.BytesUncompressedOut.LastIn(),
+		BytesCompressedOut:       m.BytesCompressedOut.LastIn(),
+		NumAPIRateLimited:        m.NumAPIRateLimited.LastIn(),
+		NumAPIAsyncRejected:      m.NumAPIAsyncRejected.LastIn(),
+		NumConnectProxyErrors:    m.NumConnectProxyErrors.LastIn(),
+		NumSubscribeProxyErrors:  m.NumSubscribeProxyErrors.LastIn(),
+		NumPublishProxyRejected:  m.NumPublishProxyRejected.LastIn(),
+		NumOriginRejected:        m.NumOriginRejected.LastIn(),
+		NumClientSendTimeouts:    m.NumClientSendTimeouts.LastIn(),
+		NumClientsRejected:       m.NumClientsRejected.LastIn(),
+		NumSecretPreviousUsed:    m.NumSecretPreviousUsed.LastIn(),
+		NumIPDenied:              m.NumIPDenied.LastIn(),
+		NumAdminAuthRejected:     m.NumAdminAuthRejected.LastIn(),
+		NumAuditDropped:          m.NumAuditDropped.LastIn(),
+		NumEngineErrors:          m.NumEngineErrors.LastIn(),
+		NumPresenceCacheHits:     m.NumPresenceCacheHits.LastIn(),
+		NumPresenceCacheMisses:   m.NumPresenceCacheMisses.LastIn(),
+		NumClientQueueDropped:    m.NumClientQueueDropped.LastIn(),
+		NumTracingSpansDropped:   m.NumTracingSpansDropped.LastIn(),
+		NumKafkaPublished:        m.NumKafkaPublished.LastIn(),
+		NumKafkaSkipped:          m.NumKafkaSkipped.LastIn(),
+		NumAPIKeyRequests:        m.apiKeyRequests.values(false),
+		Namespaces:               m.namespaces.values(false),
+		MemSys:                   atomic.LoadInt64(&m.MemSys),
+		NumConnections:           atomic.LoadInt64(&m.NumConnections),
+		ClientQueueSizeMax:       atomic.LoadInt64(&m.ClientQueueSizeMax),
+		ClientQueueSizeP99:       atomic.LoadInt64(&m.ClientQueueSizeP99),
+		ClientQueueAgeMax:        atomic.LoadInt64(&m.ClientQueueAgeMax),
+		KafkaLag:                 atomic.LoadInt64(&m.KafkaLag),
+		CPU:                      atomic.LoadInt64(&m.CPU),
+		NumDynamicNamespaces:     atomic.LoadInt64(&m.NumDynamicNamespaces),
+		Latencies:                m.histograms.LoadValues(),
 	}
 }
 
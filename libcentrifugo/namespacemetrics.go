@@ -0,0 +1,202 @@
+package libcentrifugo
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// namespaceMetricsDefault is a name metrics of channels without namespace counted
+// under.
+const namespaceMetricsDefault = "default"
+
+// namespaceCounterShards is a number of shards of namespace counters. Channels of
+// namespace spread over shards by name hash so broadcasts into different channels
+// of busy namespace do not contend on the same counters.
+const namespaceCounterShards = 8
+
+// namespaceMetrics contains message counters of channel namespace.
+type namespaceMetrics struct {
+	// NumMsgPublished is how many messages were published into namespace channels.
+	NumMsgPublished int64 `json:"num_msg_published"`
+
+	// NumMsgDelivered is how many messages were sent to subscribers of namespace
+	// channels on node, every message counted once per subscriber.
+	NumMsgDelivered int64 `json:"num_msg_delivered"`
+
+	// BytesDelivered is a size of messages sent to subscribers of namespace channels.
+	BytesDelivered int64 `json:"bytes_delivered"`
+
+	// NumJoinMsg is how many join messages were published into namespace channels.
+	NumJoinMsg int64 `json:"num_join_msg"`
+
+	// NumLeaveMsg is how many leave messages were published into namespace channels.
+	NumLeaveMsg int64 `json:"num_leave_msg"`
+
+	// NumClientSlow is how many slow connections subscribed to namespace channels
+	// were closed.
+	NumClientSlow int64 `json:"num_client_slow"`
+}
+
+// namespaceShard is a shard of namespace counters. Every counter is padded so
+// shards updated concurrently do not contend on the same cache line.
+type namespaceShard struct {
+	NumMsgPublished metricCounter
+	NumMsgDelivered metricCounter
+	BytesDelivered  metricCounter
+	NumJoinMsg      metricCounter
+	NumLeaveMsg     metricCounter
+	NumClientSlow   metricCounter
+}
+
+func (c *namespaceShard) updateDelta() {
+	c.NumMsgPublished.updateDelta()
+	c.NumMsgDelivered.updateDelta()
+	c.BytesDelivered.updateDelta()
+	c.NumJoinMsg.updateDelta()
+	c.NumLeaveMsg.updateDelta()
+	c.NumClientSlow.updateDelta()
+}
+
+// add adds raw or last interval values of shard counters to m.
+func (c *namespaceShard) add(m *namespaceMetrics, raw bool) {
+	if raw {
+		m.NumMsgPublished += c.NumMsgPublished.LoadRaw()
+		m.NumMsgDelivered += c.NumMsgDelivered.LoadRaw()
+		m.BytesDelivered += c.BytesDelivered.LoadRaw()
+		m.NumJoinMsg += c.NumJoinMsg.LoadRaw()
+		m.NumLeaveMsg += c.NumLeaveMsg.LoadRaw()
+		m.NumClientSlow += c.NumClientSlow.LoadRaw()
+		return
+	}
+	m.NumMsgPublished += c.NumMsgPublished.LastIn()
+	m.NumMsgDelivered += c.NumMsgDelivered.LastIn()
+	m.BytesDelivered += c.BytesDelivered.LastIn()
+	m.NumJoinMsg += c.NumJoinMsg.LastIn()
+	m.NumLeaveMsg += c.NumLeaveMsg.LastIn()
+	m.NumClientSlow += c.NumClientSlow.LastIn()
+}
+
+// namespaceCounters are counters of single namespace split into shards.
+type namespaceCounters struct {
+	shards [namespaceCounterShards]namespaceShard
+}
+
+// shard returns shard of counters channel messages counted in.
+func (c *namespaceCounters) shard(ch Channel) *namespaceShard {
+	// FNV-1a hash of channel name.
+	h := uint32(2166136261)
+	for i := 0; i < len(ch); i++ {
+		h ^= uint32(ch[i])
+		h *= 16777619
+	}
+	return &c.shards[h%namespaceCounterShards]
+}
+
+func (c *namespaceCounters) updateDelta() {
+	for i := range c.shards {
+		c.shards[i].updateDelta()
+	}
+}
+
+func (c *namespaceCounters) values(raw bool) namespaceMetrics {
+	var m namespaceMetrics
+	for i := range c.shards {
+		c.shards[i].add(&m, raw)
+	}
+	return m
+}
+
+// namespaceChannelFormat contains options needed to extract namespace name from
+// channel name.
+type namespaceChannelFormat struct {
+	privatePrefix string
+	boundary      string
+}
+
+// namespace returns name of namespace channel belongs to.
+func (f namespaceChannelFormat) namespace(ch Channel) string {
+	name := strings.TrimPrefix(string(ch), f.privatePrefix)
+	if f.boundary == "" {
+		return namespaceMetricsDefault
+	}
+	if i := strings.Index(name, f.boundary); i >= 0 {
+		return name[:i]
+	}
+	return namespaceMetricsDefault
+}
+
+// namespaceRegistry keeps counters of namespaces. Counters looked up without lock
+// as they are on broadcast path: map is replaced by copy when counters of new
+// namespace created, which happens once per namespace. Channel format is kept
+// here too so application lock not needed to find namespace of channel.
+type namespaceRegistry struct {
+	// mu serializes creation of counters.
+	mu       sync.Mutex
+	counters atomic.Value // map[string]*namespaceCounters
+	format   atomic.Value // namespaceChannelFormat
+}
+
+func newNamespaceRegistry() *namespaceRegistry {
+	r := &namespaceRegistry{}
+	r.counters.Store(map[string]*namespaceCounters{})
+	r.format.Store(namespaceChannelFormat{})
+	return r
+}
+
+// setFormat updates channel format used to find namespace of channel, called
+// when configuration set.
+func (r *namespaceRegistry) setFormat(privatePrefix, boundary string) {
+	r.format.Store(namespaceChannelFormat{privatePrefix: privatePrefix, boundary: boundary})
+}
+
+// channel returns counters of namespace channel belongs to.
+func (r *namespaceRegistry) channel(ch Channel) *namespaceCounters {
+	return r.get(r.format.Load().(namespaceChannelFormat).namespace(ch))
+}
+
+// get returns counters of namespace creating them on first use.
+func (r *namespaceRegistry) get(name string) *namespaceCounters {
+	if c, ok := r.counters.Load().(map[string]*namespaceCounters)[name]; ok {
+		return c
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	counters := r.counters.Load().(map[string]*namespaceCounters)
+	if c, ok := counters[name]; ok {
+		return c
+	}
+	c := &namespaceCounters{}
+	updated := make(map[string]*namespaceCounters, len(counters)+1)
+	for k, v := range counters {
+		updated[k] = v
+	}
+	updated[name] = c
+	r.counters.Store(updated)
+	return c
+}
+
+// values returns raw or last interval values of namespace counters, nil if no
+// messages counted yet.
+func (r *namespaceRegistry) values(raw bool) map[string]namespaceMetrics {
+	counters := r.counters.Load().(map[string]*namespaceCounters)
+	if len(counters) == 0 {
+		return nil
+	}
+	values := make(map[string]namespaceMetrics, len(counters))
+	for name, c := range counters {
+		values[name] = c.values(raw)
+	}
+	return values
+}
+
+func (r *namespaceRegistry) updateDelta() {
+	for _, c := range r.counters.Load().(map[string]*namespaceCounters) {
+		c.updateDelta()
+	}
+}
+
+// namespaceMetrics returns shard of counters of namespace channel belongs to.
+func (app *Application) namespaceMetrics(ch Channel) *namespaceShard {
+	return app.metrics.namespaces.channel(ch).shard(ch)
+}
@@ -0,0 +1,51 @@
+package libcentrifugo
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamespaceRegistry(t *testing.T) {
+	r := newNamespaceRegistry()
+	assert.Equal(t, 0, len(r.values(true)))
+	r.get("news").shard("news:1").NumMsgPublished.Inc()
+	r.get("news").shard("news:2").NumMsgPublished.Inc()
+	r.get("chat").shard("chat:1").NumJoinMsg.Inc()
+	r.updateDelta()
+	r.get("news").shard("news:3").NumMsgPublished.Inc()
+
+	raw := r.values(true)
+	assert.Equal(t, int64(3), raw["news"].NumMsgPublished)
+	assert.Equal(t, int64(1), raw["chat"].NumJoinMsg)
+	assert.Equal(t, int64(2), r.values(false)["news"].NumMsgPublished)
+}
+
+func TestNamespaceChannelFormat(t *testing.T) {
+	r := newNamespaceRegistry()
+	r.setFormat("$", ":")
+	assert.True(t, r.channel("news:1") == r.get("news"))
+	assert.True(t, r.channel("$news:1") == r.get("news"))
+	assert.True(t, r.channel("news") == r.get("default"))
+}
+
+func TestNamespaceMetrics(t *testing.T) {
+	app := testMemoryApp()
+	c, err := newClient(app, &testSession{})
+	assert.Equal(t, nil, err)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	err = c.handleCommands([]clientCommand{testConnectCmd(timestamp), testSubscribeCmd("test:channel")})
+	assert.Equal(t, nil, err)
+
+	assert.Equal(t, nil, app.Publish("test:channel", []byte(`{}`), "", nil))
+	assert.Equal(t, nil, app.Publish("channel", []byte(`{}`), "", nil))
+
+	namespaces := app.metrics.GetRawMetrics().Namespaces
+	assert.Equal(t, int64(1), namespaces["test"].NumMsgPublished)
+	assert.Equal(t, int64(1), namespaces["test"].NumMsgDelivered)
+	assert.True(t, namespaces["test"].BytesDelivered > 0)
+	assert.Equal(t, int64(1), namespaces["default"].NumMsgPublished)
+	assert.Equal(t, int64(0), namespaces["default"].NumMsgDelivered)
+}
@@ -0,0 +1,77 @@
+package libcentrifugo
+
+import (
+	"container/list"
+	"sync"
+)
+
+// dynamicNamespaceHub keeps namespaces materialized from namespace template
+// on first use. Least recently used namespaces evicted when hub size limit
+// reached.
+type dynamicNamespaceHub struct {
+	sync.Mutex
+
+	// ll is a list of namespaces ordered by usage – most recently used in front.
+	ll *list.List
+
+	// namespaces allows to find list element by namespace name.
+	namespaces map[NamespaceKey]*list.Element
+}
+
+// newDynamicNamespaceHub initializes dynamicNamespaceHub.
+func newDynamicNamespaceHub() *dynamicNamespaceHub {
+	return &dynamicNamespaceHub{
+		ll:         list.New(),
+		namespaces: make(map[NamespaceKey]*list.Element),
+	}
+}
+
+// get returns channel options of dynamic namespace and marks it as recently used.
+func (h *dynamicNamespaceHub) get(nk NamespaceKey) (ChannelOptions, bool) {
+	h.Lock()
+	defer h.Unlock()
+	el, ok := h.namespaces[nk]
+	if !ok {
+		return ChannelOptions{}, false
+	}
+	h.ll.MoveToFront(el)
+	return el.Value.(*Namespace).ChannelOptions, true
+}
+
+// add saves dynamic namespace evicting least recently used namespaces if
+// more than max namespaces kept. It returns number of namespaces in hub.
+func (h *dynamicNamespaceHub) add(nk NamespaceKey, opts ChannelOptions, max int) int {
+	h.Lock()
+	defer h.Unlock()
+	if el, ok := h.namespaces[nk]; ok {
+		el.Value.(*Namespace).ChannelOptions = opts
+		h.ll.MoveToFront(el)
+		return h.ll.Len()
+	}
+	h.namespaces[nk] = h.ll.PushFront(&Namespace{Name: nk, ChannelOptions: opts})
+	for h.ll.Len() > max {
+		el := h.ll.Back()
+		h.ll.Remove(el)
+		delete(h.namespaces, el.Value.(*Namespace).Name)
+	}
+	return h.ll.Len()
+}
+
+// reset removes all dynamic namespaces.
+func (h *dynamicNamespaceHub) reset() {
+	h.Lock()
+	defer h.Unlock()
+	h.ll.Init()
+	h.namespaces = make(map[NamespaceKey]*list.Element)
+}
+
+// list returns all dynamic namespaces starting from most recently used.
+func (h *dynamicNamespaceHub) list() []Namespace {
+	h.Lock()
+	defer h.Unlock()
+	nss := make([]Namespace, 0, h.ll.Len())
+	for el := h.ll.Front(); el != nil; el = el.Next() {
+		nss = append(nss, *el.Value.(*Namespace))
+	}
+	return nss
+}
@@ -0,0 +1,160 @@
+package libcentrifugo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testNamespaceTemplateApp(max int) *Application {
+	c := newTestConfig()
+	c.Namespaces[0].HistorySize = 10
+	c.NamespaceTemplate = NamespaceTemplate{
+		Pattern:       "tenant_*",
+		Namespace:     "test",
+		MaxNamespaces: max,
+	}
+	return testMemoryAppWithConfig(&c)
+}
+
+func TestNamespaceTemplateMatch(t *testing.T) {
+	app := testNamespaceTemplateApp(10)
+	opts, err := app.channelOpts(Channel("tenant_1:channel"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 10, opts.HistorySize)
+	assert.Equal(t, 1, len(app.dynamicNamespaces.list()))
+	assert.Equal(t, int64(1), app.metrics.GetRawMetrics().NumDynamicNamespaces)
+
+	// already created namespace does not create new one.
+	_, err = app.channelOpts(Channel("tenant_1:other"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(app.dynamicNamespaces.list()))
+}
+
+func TestNamespaceTemplateNoMatch(t *testing.T) {
+	app := testNamespaceTemplateApp(10)
+	_, err := app.channelOpts(Channel("customer_1:channel"))
+	assert.Equal(t, ErrNamespaceNotFound, err)
+	assert.Equal(t, 0, len(app.dynamicNamespaces.list()))
+
+	// template disabled.
+	app = testMemoryApp()
+	_, err = app.channelOpts(Channel("tenant_1:channel"))
+	assert.Equal(t, ErrNamespaceNotFound, err)
+}
+
+func TestNamespaceTemplateMaxNamespaces(t *testing.T) {
+	app := testNamespaceTemplateApp(2)
+	for _, ch := range []Channel{"tenant_1:channel", "tenant_2:channel", "tenant_1:channel", "tenant_3:channel"} {
+		_, err := app.channelOpts(ch)
+		assert.Equal(t, nil, err)
+	}
+	nss := app.dynamicNamespaces.list()
+	assert.Equal(t, 2, len(nss))
+	// tenant_2 was least recently used so it was evicted.
+	assert.Equal(t, NamespaceKey("tenant_3"), nss[0].Name)
+	assert.Equal(t, NamespaceKey("tenant_1"), nss[1].Name)
+	assert.Equal(t, int64(2), app.metrics.GetRawMetrics().NumDynamicNamespaces)
+}
+
+func TestNamespaceTemplateReload(t *testing.T) {
+	app := testNamespaceTemplateApp(10)
+	_, err := app.channelOpts(Channel("tenant_1:channel"))
+	assert.Equal(t, nil, err)
+
+	c := newTestConfig()
+	c.NamespaceTemplate = NamespaceTemplate{
+		Pattern:       "tenant_*",
+		Namespace:     "test",
+		MaxNamespaces: 10,
+	}
+	app.SetConfig(&c)
+	assert.Equal(t, 0, len(app.dynamicNamespaces.list()))
+
+	// namespace created again using options from new configuration.
+	opts, err := app.channelOpts(Channel("tenant_1:channel"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, opts.HistorySize)
+
+	// namespace template removed from configuration.
+	c = newTestConfig()
+	app.SetConfig(&c)
+	_, err = app.channelOpts(Channel("tenant_1:channel"))
+	assert.Equal(t, ErrNamespaceNotFound, err)
+}
+
+func TestValidateNamespaceTemplate(t *testing.T) {
+	c := newTestConfig()
+	c.NamespaceTemplate = NamespaceTemplate{Pattern: "tenant_*", Namespace: "test", MaxNamespaces: 10}
+	assert.Equal(t, nil, c.Validate())
+
+	c.NamespaceTemplate.Pattern = "tenant_["
+	assert.NotEqual(t, nil, c.Validate())
+
+	c.NamespaceTemplate.Pattern = "tenant_*"
+	c.NamespaceTemplate.Namespace = "unknown"
+	assert.NotEqual(t, nil, c.Validate())
+
+	c.NamespaceTemplate.Namespace = "test"
+	c.NamespaceTemplate.MaxNamespaces = 0
+	assert.NotEqual(t, nil, c.Validate())
+}
+
+func testNamespacePatternsApp() *Application {
+	c := newTestConfig()
+	c.Namespaces = append(c.Namespaces,
+		Namespace{Name: "game_events", Pattern: "game:*:events", ChannelOptions: ChannelOptions{HistorySize: 20}},
+		Namespace{Name: "game_chat", Regexp: "^game:[0-9]+:chat$", ChannelOptions: ChannelOptions{HistorySize: 30}},
+		Namespace{Name: "game_other", Pattern: "game:*", ChannelOptions: ChannelOptions{HistorySize: 40}},
+	)
+	return testMemoryAppWithConfig(&c)
+}
+
+func TestNamespacePatterns(t *testing.T) {
+	app := testNamespacePatternsApp()
+	for ch, size := range map[Channel]int{
+		"game:1:events":  20,
+		"$game:1:events": 20,
+		"game:1:chat":    30,
+		"game:x:chat":    40,
+		"game:1":         40,
+		"channel":        app.config.HistorySize,
+	} {
+		opts, err := app.channelOpts(ch)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, size, opts.HistorySize, string(ch))
+	}
+	_, err := app.channelOpts(Channel("other:1:events"))
+	assert.Equal(t, ErrNamespaceNotFound, err)
+
+	// namespace with name from channel prefix takes precedence.
+	c := *app.config
+	c.Namespaces = append([]Namespace{{Name: "game", ChannelOptions: ChannelOptions{HistorySize: 50}}}, c.Namespaces...)
+	app.SetConfig(&c)
+	opts, err := app.channelOpts(Channel("game:1:events"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 50, opts.HistorySize)
+
+	nk, _, err := app.channelNamespace(Channel("test:1:events"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, NamespaceKey("test"), nk)
+}
+
+func TestValidateNamespacePatterns(t *testing.T) {
+	c := newTestConfig()
+	c.Namespaces[0].Pattern = "game:*"
+	assert.Equal(t, nil, c.Validate())
+
+	c.Namespaces[0].Pattern = "game:["
+	assert.NotEqual(t, nil, c.Validate())
+
+	c.Namespaces[0].Pattern = ""
+	c.Namespaces[0].Regexp = "^game:("
+	assert.NotEqual(t, nil, c.Validate())
+
+	c.Namespaces[0].Regexp = "^game:"
+	assert.Equal(t, nil, c.Validate())
+
+	c.Namespaces[0].Pattern = "game:*"
+	assert.NotEqual(t, nil, c.Validate())
+}
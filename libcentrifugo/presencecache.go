@@ -0,0 +1,157 @@
+package libcentrifugo
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+type presenceCacheEntry struct {
+	channel  Channel
+	presence map[ConnID]ClientInfo
+	expires  time.Time
+}
+
+// presenceCache keeps channel presence loaded from engine for a short time so
+// frequent presence and presence_stats requests for busy channels do not load
+// full presence from engine every time. Entry of channel dropped as soon as
+// client joins or leaves channel on this node, changes made on other nodes become
+// visible after ttl. Cache bounded using LRU eviction.
+type presenceCache struct {
+	sync.Mutex
+	ll    *list.List
+	items map[Channel]*list.Element
+	// loads tracks channels which presence is being loaded from engine.
+	loads map[Channel]*presenceLoad
+}
+
+// presenceLoad is a generation of channel presence incremented by invalidation
+// and a number of loads of channel presence in progress.
+type presenceLoad struct {
+	generation uint64
+	n          int
+}
+
+func newPresenceCache() *presenceCache {
+	return &presenceCache{
+		ll:    list.New(),
+		items: make(map[Channel]*list.Element),
+		loads: make(map[Channel]*presenceLoad),
+	}
+}
+
+// reset removes all cached presence.
+func (c *presenceCache) reset() {
+	c.Lock()
+	defer c.Unlock()
+	c.ll.Init()
+	c.items = make(map[Channel]*list.Element)
+	for _, load := range c.loads {
+		load.generation++
+	}
+}
+
+// get returns cached presence of channel if it is not expired. Returned map must
+// not be modified.
+func (c *presenceCache) get(ch Channel, now time.Time) (map[ConnID]ClientInfo, bool) {
+	c.Lock()
+	defer c.Unlock()
+	el, ok := c.items[ch]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*presenceCacheEntry)
+	if !now.Before(entry.expires) {
+		c.ll.Remove(el)
+		delete(c.items, ch)
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return entry.presence, true
+}
+
+// begin must be called before loading presence of channel from engine, returned
+// generation passed to set or release when load finished.
+func (c *presenceCache) begin(ch Channel) uint64 {
+	c.Lock()
+	defer c.Unlock()
+	load, ok := c.loads[ch]
+	if !ok {
+		load = &presenceLoad{}
+		c.loads[ch] = load
+	}
+	load.n++
+	return load.generation
+}
+
+// release finishes load of channel presence started with begin and returns true
+// if channel presence was not invalidated since then. Must be called with lock held.
+func (c *presenceCache) release(ch Channel, generation uint64) bool {
+	load, ok := c.loads[ch]
+	if !ok {
+		return false
+	}
+	load.n--
+	if load.n <= 0 {
+		delete(c.loads, ch)
+	}
+	return load.generation == generation
+}
+
+// abort finishes load of channel presence started with begin without caching.
+func (c *presenceCache) abort(ch Channel, generation uint64) {
+	c.Lock()
+	defer c.Unlock()
+	c.release(ch, generation)
+}
+
+// set caches presence of channel loaded since begin returned generation for ttl,
+// at most size channels kept. Presence not cached if channel invalidated during
+// load as it can be stale already.
+func (c *presenceCache) set(ch Channel, generation uint64, presence map[ConnID]ClientInfo, ttl time.Duration, size int, now time.Time) {
+	c.Lock()
+	defer c.Unlock()
+	if !c.release(ch, generation) {
+		return
+	}
+	if el, ok := c.items[ch]; ok {
+		entry := el.Value.(*presenceCacheEntry)
+		entry.presence = presence
+		entry.expires = now.Add(ttl)
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[ch] = c.ll.PushFront(&presenceCacheEntry{channel: ch, presence: presence, expires: now.Add(ttl)})
+	for c.ll.Len() > size {
+		el := c.ll.Back()
+		c.ll.Remove(el)
+		delete(c.items, el.Value.(*presenceCacheEntry).channel)
+	}
+}
+
+// invalidate drops cached presence of channel and prevents caching presence
+// being loaded at the moment.
+func (c *presenceCache) invalidate(ch Channel) {
+	c.Lock()
+	defer c.Unlock()
+	if load, ok := c.loads[ch]; ok {
+		load.generation++
+	}
+	if el, ok := c.items[ch]; ok {
+		c.ll.Remove(el)
+		delete(c.items, ch)
+	}
+}
+
+// contains returns true if client is in cached presence of channel. Used to
+// avoid dropping cache when client only refreshes its presence.
+func (c *presenceCache) contains(ch Channel, uid ConnID) bool {
+	c.Lock()
+	defer c.Unlock()
+	el, ok := c.items[ch]
+	if !ok {
+		return false
+	}
+	_, ok = el.Value.(*presenceCacheEntry).presence[uid]
+	return ok
+}
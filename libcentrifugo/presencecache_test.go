@@ -0,0 +1,126 @@
+package libcentrifugo
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPresenceCache(t *testing.T) {
+	cache := newPresenceCache()
+	now := time.Now()
+	presence := map[ConnID]ClientInfo{"uid": {User: "1"}}
+
+	_, ok := cache.get("test", now)
+	assert.False(t, ok)
+	cache.set("test", cache.begin("test"), presence, time.Second, 10, now)
+	cached, ok := cache.get("test", now)
+	assert.True(t, ok)
+	assert.Equal(t, presence, cached)
+	assert.True(t, cache.contains("test", "uid"))
+	assert.False(t, cache.contains("test", "other"))
+
+	// expired entry removed.
+	_, ok = cache.get("test", now.Add(time.Second))
+	assert.False(t, ok)
+	assert.Equal(t, 0, len(cache.items))
+
+	cache.set("test", cache.begin("test"), presence, time.Second, 10, now)
+	cache.invalidate("test")
+	_, ok = cache.get("test", now)
+	assert.False(t, ok)
+}
+
+func TestPresenceCacheInvalidatedDuringLoad(t *testing.T) {
+	cache := newPresenceCache()
+	now := time.Now()
+	presence := map[ConnID]ClientInfo{"uid": {User: "1"}}
+
+	generation := cache.begin("test")
+	// client joined while presence was loaded from engine.
+	cache.invalidate("test")
+	cache.set("test", generation, presence, time.Second, 10, now)
+	_, ok := cache.get("test", now)
+	assert.False(t, ok)
+	assert.Equal(t, 0, len(cache.loads))
+
+	generation = cache.begin("test")
+	cache.abort("test", generation)
+	assert.Equal(t, 0, len(cache.loads))
+	cache.set("test", cache.begin("test"), presence, time.Second, 10, now)
+	_, ok = cache.get("test", now)
+	assert.True(t, ok)
+}
+
+func TestPresenceCacheEviction(t *testing.T) {
+	cache := newPresenceCache()
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		ch := Channel(strconv.Itoa(i))
+		cache.set(ch, cache.begin(ch), map[ConnID]ClientInfo{}, time.Minute, 2, now)
+		// keep first channel recently used.
+		cache.get("0", now)
+	}
+	assert.Equal(t, 2, cache.ll.Len())
+	_, ok := cache.get("0", now)
+	assert.True(t, ok)
+	_, ok = cache.get("1", now)
+	assert.False(t, ok)
+	_, ok = cache.get("2", now)
+	assert.True(t, ok)
+}
+
+func TestPresenceCached(t *testing.T) {
+	app := testMemoryApp()
+	app.config.PresenceCacheTTL = time.Minute
+	createTestClients(app, 1, 2, nil)
+
+	presence, err := app.Presence("channel-0")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(presence))
+	assert.Equal(t, int64(1), app.metrics.NumPresenceCacheMisses.LoadRaw())
+
+	_, err = app.Presence("channel-0")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(1), app.metrics.NumPresenceCacheHits.LoadRaw())
+
+	// presence refresh of existing subscriber keeps cache.
+	for uid, info := range presence {
+		assert.Equal(t, nil, app.addPresence("channel-0", uid, info))
+	}
+	_, err = app.Presence("channel-0")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(2), app.metrics.NumPresenceCacheHits.LoadRaw())
+
+	// local join drops cache.
+	c, err := newClient(app, &testSession{})
+	assert.Equal(t, nil, err)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	assert.Equal(t, nil, c.handleCommands([]clientCommand{testConnectCmd(timestamp), testSubscribeCmd("channel-0")}))
+	presence, err = app.Presence("channel-0")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, len(presence))
+
+	// local leave drops cache.
+	_, err = c.handleCmd(testUnsubscribeCmd("channel-0"))
+	assert.Equal(t, nil, err)
+	presence, err = app.Presence("channel-0")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(presence))
+	assert.Equal(t, int64(3), app.metrics.NumPresenceCacheMisses.LoadRaw())
+}
+
+func TestPresenceCacheDisabled(t *testing.T) {
+	app := testMemoryApp()
+	app.config.PresenceCacheTTL = 0
+	createTestClients(app, 1, 1, nil)
+	_, err := app.Presence("channel-0")
+	assert.Equal(t, nil, err)
+	_, err = app.Presence("channel-0")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(0), app.metrics.NumPresenceCacheHits.LoadRaw())
+	assert.Equal(t, int64(0), app.metrics.NumPresenceCacheMisses.LoadRaw())
+	assert.Equal(t, 0, len(app.presenceCache.items))
+}
@@ -0,0 +1,52 @@
+package libcentrifugo
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// proxyRetryBackoff is a base interval between retries of proxy request.
+const proxyRetryBackoff = 100 * time.Millisecond
+
+// errProxyStatus returned when proxy endpoint responded with unexpected status.
+var errProxyStatus = errors.New("unexpected proxy response status")
+
+// requestProxy posts JSON data to proxy endpoint and decodes response into reply.
+// Request retried when endpoint is not available or responded with server error.
+func requestProxy(endpoint string, timeout time.Duration, retries int, data []byte, reply interface{}) error {
+	client := &http.Client{Timeout: timeout}
+	var err error
+	for i := 0; i <= retries; i++ {
+		if i > 0 {
+			time.Sleep(time.Duration(i) * proxyRetryBackoff)
+		}
+		var retry bool
+		retry, err = postProxy(client, endpoint, data, reply)
+		if err == nil || !retry {
+			break
+		}
+	}
+	return err
+}
+
+// postProxy sends single proxy request. It returns true when request failed but
+// can be retried.
+func postProxy(client *http.Client, endpoint string, data []byte, reply interface{}) (bool, error) {
+	resp, err := client.Post(endpoint, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return true, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode >= http.StatusInternalServerError, errProxyStatus
+	}
+	return false, json.Unmarshal(body, reply)
+}
@@ -0,0 +1,59 @@
+package libcentrifugo
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// publishProxyRequest is a body of request sent to publish_proxy_endpoint.
+type publishProxyRequest struct {
+	Client   ConnID          `json:"client"`
+	User     UserID          `json:"user"`
+	Channel  Channel         `json:"channel"`
+	Data     json.RawMessage `json:"data"`
+	Encoding string          `json:"encoding,omitempty"`
+}
+
+// publishProxyReply is a body of publish_proxy_endpoint response. Backend either
+// passes publication through, optionally replacing its data, or rejects it with
+// error.
+type publishProxyReply struct {
+	Data  json.RawMessage `json:"data,omitempty"`
+	Error *proxyError     `json:"error,omitempty"`
+}
+
+// publishProxyRequest returns request to publish proxy endpoint. Must be called
+// with client lock held.
+func (c *client) publishProxyRequest(cmd *publishClientCommand) publishProxyRequest {
+	return publishProxyRequest{
+		Client:   c.UID,
+		User:     c.User,
+		Channel:  cmd.Channel,
+		Data:     cmd.Data,
+		Encoding: cmd.Encoding,
+	}
+}
+
+// publishProxy asks publish proxy endpoint to validate data client publishes
+// into channel.
+func (app *Application) publishProxy(req publishProxyRequest) (*publishProxyReply, error) {
+	app.RLock()
+	endpoint := app.config.PublishProxyEndpoint
+	timeout := app.config.PublishProxyTimeout
+	retries := app.config.PublishProxyRetries
+	app.RUnlock()
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	started := time.Now()
+	var reply publishProxyReply
+	err = requestProxy(endpoint, timeout, retries, data, &reply)
+	app.metrics.histograms.RecordMicroseconds("publish_proxy", time.Now().Sub(started))
+	if err != nil {
+		return nil, err
+	}
+	return &reply, nil
+}
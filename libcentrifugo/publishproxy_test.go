@@ -0,0 +1,106 @@
+package libcentrifugo
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testPublishProxyApp(endpoint string) *Application {
+	c := newTestConfig()
+	c.Insecure = true
+	c.Publish = true
+	c.PublishProxy = true
+	c.PublishProxyEndpoint = endpoint
+	c.PublishProxyRetries = 0
+	return testMemoryAppWithConfig(&c)
+}
+
+// publishProxyCmd handles publish command with client lock held as handler
+// releases it while publish proxy requested.
+func publishProxyCmd(c *client, cmd *publishClientCommand) (response, error) {
+	c.Lock()
+	defer c.Unlock()
+	return c.publishCmd(cmd)
+}
+
+func TestPublishProxy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req publishProxyRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if string(req.Data) == `"spam"` {
+			w.Write([]byte(`{"error": {"code": 1000, "message": "spam detected"}}`))
+			return
+		}
+		w.Write([]byte(`{"data": "sanitized"}`))
+	}))
+	defer server.Close()
+
+	app := testPublishProxyApp(server.URL)
+	c := testSubscribeProxyClient(t, app, "user1")
+	_, err := c.subscribeCmd(&subscribeClientCommand{Channel: "test"})
+	assert.Equal(t, nil, err)
+
+	resp, err := publishProxyCmd(c, &publishClientCommand{Channel: "test", Data: json.RawMessage(`"<script>"`)})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, resp.(*clientPublishResponse).err)
+	history, err := app.History("test")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, `"sanitized"`, string(*history[0].Data))
+
+	resp, err = publishProxyCmd(c, &publishClientCommand{Channel: "test", Data: json.RawMessage(`"spam"`)})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "spam detected", resp.(*clientPublishResponse).err.Error())
+	assert.Equal(t, 1000, resp.(*clientPublishResponse).Code)
+	assert.Equal(t, int64(1), app.metrics.NumPublishProxyRejected.LoadRaw())
+}
+
+func TestPublishProxyUnavailable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	app := testPublishProxyApp(server.URL)
+	c := testSubscribeProxyClient(t, app, "user1")
+	_, err := c.subscribeCmd(&subscribeClientCommand{Channel: "test"})
+	assert.Equal(t, nil, err)
+
+	resp, err := publishProxyCmd(c, &publishClientCommand{Channel: "test", Data: json.RawMessage(`{}`)})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, ErrInternalServerError, resp.(*clientPublishResponse).err)
+	assert.Equal(t, int64(1), app.metrics.NumPublishProxyRejected.LoadRaw())
+
+	app.config.PublishProxyPassThrough = true
+	resp, err = publishProxyCmd(c, &publishClientCommand{Channel: "test", Data: json.RawMessage(`{}`)})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, resp.(*clientPublishResponse).err)
+}
+
+func TestPublishProxyUnlocked(t *testing.T) {
+	var c *client
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// client unsubscribed while proxy requested, it is only possible
+		// without client lock held.
+		c.Lock()
+		c.unsubscribeCmd(&unsubscribeClientCommand{Channel: "test"})
+		c.Unlock()
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	app := testPublishProxyApp(server.URL)
+	c = testSubscribeProxyClient(t, app, "user1")
+	_, err := c.subscribeCmd(&subscribeClientCommand{Channel: "test"})
+	assert.Equal(t, nil, err)
+
+	resp, err := publishProxyCmd(c, &publishClientCommand{Channel: "test", Data: json.RawMessage(`{}`)})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, ErrPermissionDenied, resp.(*clientPublishResponse).err)
+	history, err := app.History("test")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(history))
+}
@@ -0,0 +1,174 @@
+package libcentrifugo
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// tokenBucket is a simple token bucket rate limiter. It is not safe for concurrent
+// use – client accesses its buckets under client lock.
+type tokenBucket struct {
+	rate     int
+	burst    int
+	capacity float64
+	tokens   float64
+	last     time.Time
+}
+
+// newTokenBucket creates full bucket refilled with rate tokens per second and
+// holding not more than burst tokens. If burst not positive then rate used as burst.
+func newTokenBucket(rate int, burst int, now time.Time) *tokenBucket {
+	capacity := burst
+	if capacity <= 0 {
+		capacity = rate
+	}
+	return &tokenBucket{
+		rate:     rate,
+		burst:    burst,
+		capacity: float64(capacity),
+		tokens:   float64(capacity),
+		last:     now,
+	}
+}
+
+// refill adds tokens accumulated since last refill.
+func (b *tokenBucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed > 0 {
+		b.tokens += elapsed * float64(b.rate)
+		if b.tokens > b.capacity {
+			b.tokens = b.capacity
+		}
+		b.last = now
+	}
+}
+
+// setLimits changes rate and burst of bucket keeping tokens left.
+func (b *tokenBucket) setLimits(rate int, burst int, now time.Time) {
+	if b.rate == rate && b.burst == burst {
+		return
+	}
+	b.refill(now)
+	capacity := burst
+	if capacity <= 0 {
+		capacity = rate
+	}
+	b.rate = rate
+	b.burst = burst
+	b.capacity = float64(capacity)
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+}
+
+// allow takes one token from bucket and returns true if it was available.
+func (b *tokenBucket) allow(now time.Time) bool {
+	b.refill(now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// retryAfter returns time until next token available in bucket.
+func (b *tokenBucket) retryAfter() time.Duration {
+	if b.tokens >= 1 || b.rate <= 0 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / float64(b.rate) * float64(time.Second))
+}
+
+// apiRateLimiterPruneInterval is how often API rate limiter forgets buckets of
+// clients which did not send requests recently.
+const apiRateLimiterPruneInterval = time.Minute
+
+// apiRateLimiter limits rate of HTTP API requests with separate token bucket for
+// every API key or remote address.
+type apiRateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastPrune time.Time
+}
+
+func newAPIRateLimiter() *apiRateLimiter {
+	return &apiRateLimiter{
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// allow takes token from bucket of key, when request not allowed it also returns
+// time after which request can be retried.
+func (l *apiRateLimiter) allow(key string, rate int, burst int, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastPrune) > apiRateLimiterPruneInterval {
+		for k, b := range l.buckets {
+			// bucket not used for this time is full so it is the same as new one.
+			if now.Sub(b.last).Seconds()*float64(b.rate) >= b.capacity {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastPrune = now
+	}
+	bucket, ok := l.buckets[key]
+	if !ok || bucket.rate != rate || bucket.burst != burst {
+		// create new bucket if limits changed after configuration reload.
+		bucket = newTokenBucket(rate, burst, now)
+		l.buckets[key] = bucket
+	}
+	if bucket.allow(now) {
+		return true, 0
+	}
+	return false, bucket.retryAfter()
+}
+
+// rateLimitExempt contains client commands which do not count towards
+// client command rate limit.
+var rateLimitExempt = map[string]bool{
+	"ping": true,
+}
+
+// allowCommand checks command against client command rate limit. Connection has one
+// bucket for all its commands, limits of namespace command targets applied to it so
+// client can not multiply allowed rate spreading commands over many namespaces.
+// Commands without channel limited using global channel options. Must be called
+// with client lock held.
+func (c *client) allowCommand(command clientCommand, now time.Time) bool {
+	if rateLimitExempt[command.Method] {
+		return true
+	}
+
+	var params struct {
+		Channel Channel `json:"channel"`
+	}
+	if len(command.Params) > 0 {
+		// error ignored here as invalid params rejected by command handler.
+		json.Unmarshal(command.Params, &params)
+	}
+
+	c.app.RLock()
+	maxChannelLength := c.app.config.MaxChannelLength
+	c.app.RUnlock()
+
+	// commands with invalid channel fail in handler anyway, they are limited
+	// using global options.
+	chOpts, _ := c.app.channelOpts("")
+	if params.Channel != "" && len(params.Channel) <= maxChannelLength {
+		// command not validated yet so namespace from template must not be created.
+		if _, opts, err := c.app.lookupChannelNamespace(params.Channel, false); err == nil {
+			chOpts = opts
+		}
+	}
+	if chOpts.ClientCommandsPerSecond <= 0 {
+		return true
+	}
+
+	if c.rateLimit == nil {
+		c.rateLimit = newTokenBucket(chOpts.ClientCommandsPerSecond, chOpts.ClientCommandsBurst, now)
+	} else {
+		c.rateLimit.setLimits(chOpts.ClientCommandsPerSecond, chOpts.ClientCommandsBurst, now)
+	}
+	return c.rateLimit.allow(now)
+}
@@ -0,0 +1,118 @@
+package libcentrifugo
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(2, 3, now)
+	assert.True(t, b.allow(now))
+	assert.True(t, b.allow(now))
+	assert.True(t, b.allow(now))
+	assert.False(t, b.allow(now))
+
+	// 2 tokens per second refilled.
+	now = now.Add(500 * time.Millisecond)
+	assert.True(t, b.allow(now))
+	assert.False(t, b.allow(now))
+
+	// bucket never holds more than burst.
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		assert.True(t, b.allow(now))
+	}
+	assert.False(t, b.allow(now))
+
+	// rate used as burst if burst not set.
+	b = newTokenBucket(1, 0, now)
+	assert.True(t, b.allow(now))
+	assert.False(t, b.allow(now))
+}
+
+func TestClientCommandsRateLimit(t *testing.T) {
+	app := testMemoryApp()
+	app.config.ChannelOptions.ClientCommandsPerSecond = 1
+	app.config.ChannelOptions.ClientCommandsBurst = 2
+	app.config.ClientCommandsMaxViolations = 3
+	c, err := newClient(app, &testSession{})
+	assert.Equal(t, nil, err)
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	err = c.handleCommands([]clientCommand{testConnectCmd(timestamp), testSubscribeCmd("channel")})
+	assert.Equal(t, nil, err)
+
+	now := time.Now()
+	// ping commands not limited.
+	assert.True(t, c.allowCommand(clientCommand{Method: "ping"}, now))
+	assert.False(t, c.allowCommand(testPresenceCmd("channel"), now))
+
+	// namespace options override global ones.
+	assert.True(t, c.allowCommand(testPresenceCmd("test:channel"), now))
+
+	err = c.handleCommands([]clientCommand{testPresenceCmd("channel")})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, c.limitViolations)
+	assert.Equal(t, int64(1), app.metrics.NumClientLimitExceeded.LoadRaw())
+
+	// successful command resets consecutive violations.
+	c.rateLimit.tokens = 1
+	err = c.handleCommands([]clientCommand{testPresenceCmd("channel")})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, c.limitViolations)
+
+	err = c.handleCommands([]clientCommand{testPresenceCmd("channel"), testPresenceCmd("channel")})
+	assert.Equal(t, nil, err)
+	err = c.handleCommands([]clientCommand{testPresenceCmd("channel")})
+	assert.Equal(t, ErrLimitExceeded, err)
+}
+
+func TestClientCommandsRateLimitPerConnection(t *testing.T) {
+	app := testNamespaceTemplateApp(10)
+	app.config.ChannelOptions.ClientCommandsPerSecond = 1
+	app.config.Namespaces[0].ClientCommandsPerSecond = 1
+	c, err := newClient(app, &testSession{})
+	assert.Equal(t, nil, err)
+
+	now := time.Now()
+	assert.True(t, c.allowCommand(testPresenceCmd("channel"), now))
+	// commands targeting other namespaces share connection limit.
+	assert.False(t, c.allowCommand(testPresenceCmd("test:channel"), now))
+	assert.False(t, c.allowCommand(testPresenceCmd("tenant_1:channel"), now))
+	// namespace from template not created before command validated.
+	assert.Equal(t, 0, len(app.dynamicNamespaces.list()))
+}
+
+func TestClientCommandsRateLimitResponse(t *testing.T) {
+	resp := newClientErrorResponse("presence", responseError{ErrLimitExceeded, errorAdviceRetry})
+	resp.SetUID("1")
+	assert.Equal(t, ErrLimitExceeded.Error(), resp.(*clientResponse).Error)
+	assert.Equal(t, "presence", resp.(*clientResponse).Method)
+}
+
+func TestAPIRateLimiter(t *testing.T) {
+	l := newAPIRateLimiter()
+	now := time.Now()
+	assert.True(t, mustAllow(l.allow("a", 2, 2, now)))
+	assert.True(t, mustAllow(l.allow("a", 2, 2, now)))
+	allowed, retryAfter := l.allow("a", 2, 2, now)
+	assert.False(t, allowed)
+	assert.Equal(t, 500*time.Millisecond, retryAfter)
+	// other keys have own buckets.
+	assert.True(t, mustAllow(l.allow("b", 2, 2, now)))
+
+	// limits changed on reload.
+	assert.True(t, mustAllow(l.allow("a", 10, 10, now)))
+
+	// idle buckets forgotten.
+	l.allow("c", 2, 2, now.Add(2*apiRateLimiterPruneInterval))
+	assert.Equal(t, 1, len(l.buckets))
+}
+
+func mustAllow(allowed bool, retryAfter time.Duration) bool {
+	return allowed
+}
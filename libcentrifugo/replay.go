@@ -0,0 +1,239 @@
+package libcentrifugo
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/FZambia/go-logger"
+)
+
+// ErrReplayInProgress returned when replay into target channel already running.
+var ErrReplayInProgress = errors.New("replay in progress")
+
+// Replay statuses reported to admins in replay progress messages.
+const (
+	replayStatusStarted   = "started"
+	replayStatusRunning   = "running"
+	replayStatusFinished  = "finished"
+	replayStatusCancelled = "cancelled"
+	replayStatusStopped   = "stopped"
+	replayStatusFailed    = "failed"
+)
+
+// clock abstracts time so replay pacing can be controlled in tests.
+type clock interface {
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// replayProgress is sent to admins when replay state changes.
+type replayProgress struct {
+	SourceChannel Channel `json:"source_channel"`
+	TargetChannel Channel `json:"target_channel"`
+	Published     int     `json:"published"`
+	Total         int     `json:"total"`
+	Status        string  `json:"status"`
+	Error         string  `json:"error,omitempty"`
+}
+
+// replay is a running republish of source channel history into target channel.
+type replay struct {
+	source   Channel
+	target   Channel
+	speed    float64
+	messages []Message
+	cancel   chan struct{}
+	done     chan struct{}
+}
+
+// replayHub keeps running replays – only one replay per target channel allowed.
+type replayHub struct {
+	sync.Mutex
+	clock   clock
+	replays map[Channel]*replay
+}
+
+func newReplayHub() *replayHub {
+	return &replayHub{
+		clock:   realClock{},
+		replays: make(map[Channel]*replay),
+	}
+}
+
+// add registers replay, returns false if target channel already has one running.
+func (h *replayHub) add(r *replay) bool {
+	h.Lock()
+	defer h.Unlock()
+	if _, ok := h.replays[r.target]; ok {
+		return false
+	}
+	h.replays[r.target] = r
+	return true
+}
+
+func (h *replayHub) remove(r *replay) {
+	h.Lock()
+	defer h.Unlock()
+	if h.replays[r.target] == r {
+		delete(h.replays, r.target)
+	}
+}
+
+// cancel stops replay into target channel, returns false if there is no such replay.
+func (h *replayHub) cancel(target Channel) bool {
+	h.Lock()
+	defer h.Unlock()
+	r, ok := h.replays[target]
+	if !ok {
+		return false
+	}
+	delete(h.replays, target)
+	close(r.cancel)
+	return true
+}
+
+// replayMessages returns history messages published not earlier than since
+// (unix seconds, 0 means whole history) ordered from oldest to newest.
+func replayMessages(history []Message, since int64) []Message {
+	messages := make([]Message, 0, len(history))
+	for _, msg := range history {
+		if since > 0 && messageTimestamp(msg) < since {
+			continue
+		}
+		messages = append(messages, msg)
+	}
+	// history comes newest first.
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages
+}
+
+func messageTimestamp(msg Message) int64 {
+	ts, _ := strconv.ParseInt(msg.Timestamp, 10, 64)
+	return ts
+}
+
+// startReplay starts republishing history of source channel into target channel.
+// Source channel history is only read. It returns number of messages to replay.
+func (app *Application) startReplay(source Channel, target Channel, speed float64, since int64) (int, error) {
+	if speed <= 0 {
+		speed = 1
+	}
+
+	chOpts, err := app.channelOpts(target)
+	if err != nil {
+		return 0, err
+	}
+
+	history, err := app.History(source)
+	if err != nil {
+		return 0, err
+	}
+
+	r := &replay{
+		source:   source,
+		target:   target,
+		speed:    speed,
+		messages: replayMessages(history, since),
+		cancel:   make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if !app.replays.add(r) {
+		return 0, ErrReplayInProgress
+	}
+	go app.runReplay(r, chOpts)
+	return len(r.messages), nil
+}
+
+// cancelReplay stops replay into target channel.
+func (app *Application) cancelReplay(target Channel) bool {
+	return app.replays.cancel(target)
+}
+
+// runReplay publishes replay messages into target channel keeping intervals
+// between them scaled by replay speed. Runner stops when replay cancelled or
+// application shuts down.
+func (app *Application) runReplay(r *replay, chOpts ChannelOptions) {
+	defer close(r.done)
+	defer app.replays.remove(r)
+
+	progress := replayProgress{
+		SourceChannel: r.source,
+		TargetChannel: r.target,
+		Total:         len(r.messages),
+		Status:        replayStatusStarted,
+	}
+	app.pubReplayProgress(progress)
+
+	progress.Status = replayStatusFinished
+	for i, msg := range r.messages {
+		var delay time.Duration
+		if i > 0 {
+			delay = time.Duration(float64(messageTimestamp(msg)-messageTimestamp(r.messages[i-1])) * float64(time.Second) / r.speed)
+		}
+		if status := app.waitReplay(r, delay); status != "" {
+			progress.Status = status
+			break
+		}
+
+		var data []byte
+		if msg.Data != nil {
+			data = []byte(*msg.Data)
+		}
+		_, errCh := app.pubClient(r.target, chOpts, data, msg.Encoding, ConnID(msg.Client), msg.Info, nil)
+		if err := <-errCh; err != nil {
+			logger.ERROR.Printf("error replaying message into channel %s: %v", r.target, err)
+			progress.Status = replayStatusFailed
+			progress.Error = err.Error()
+			break
+		}
+		progress.Published++
+		if progress.Published < progress.Total {
+			progress.Status = replayStatusRunning
+			app.pubReplayProgress(progress)
+			progress.Status = replayStatusFinished
+		}
+	}
+	app.pubReplayProgress(progress)
+}
+
+// waitReplay waits for delay before publishing next replay message. It returns
+// status replay must stop with if replay cancelled or application shut down.
+func (app *Application) waitReplay(r *replay, delay time.Duration) string {
+	select {
+	case <-r.cancel:
+		return replayStatusCancelled
+	case <-app.shutdownCh:
+		return replayStatusStopped
+	default:
+	}
+	if delay <= 0 {
+		return ""
+	}
+	select {
+	case <-app.replays.clock.After(delay):
+		return ""
+	case <-r.cancel:
+		return replayStatusCancelled
+	case <-app.shutdownCh:
+		return replayStatusStopped
+	}
+}
+
+func (app *Application) pubReplayProgress(progress replayProgress) {
+	params, err := json.Marshal(progress)
+	if err != nil {
+		logger.ERROR.Println(err)
+		return
+	}
+	app.pubAdmin("replay", params)
+}
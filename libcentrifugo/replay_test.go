@@ -0,0 +1,173 @@
+package libcentrifugo
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock reports requested delays and fires them only when test asks to.
+type fakeClock struct {
+	delays chan time.Duration
+	fire   chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{
+		delays: make(chan time.Duration, 10),
+		fire:   make(chan time.Time),
+	}
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.delays <- d
+	return c.fire
+}
+
+func (c *fakeClock) waitDelay(t *testing.T) time.Duration {
+	select {
+	case d := <-c.delays:
+		return d
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for replay delay")
+	}
+	return 0
+}
+
+func testReplayApp(clock clock) *Application {
+	c := newTestConfig()
+	c.ChannelOptions.HistorySize = 10
+	c.ChannelOptions.HistoryLifetime = 60
+	app := testMemoryAppWithConfig(&c)
+	app.replays.clock = clock
+	return app
+}
+
+// addReplayHistory adds messages with provided unix timestamps into channel history.
+func addReplayHistory(app *Application, ch Channel, timestamps ...int64) {
+	e := app.engine.(*MemoryEngine)
+	for i, ts := range timestamps {
+		msg := newMessage(ch, []byte(strconv.Itoa(i)), "", nil)
+		msg.Timestamp = strconv.FormatInt(ts, 10)
+		e.historyHub.add(ch, *msg, addHistoryOpts{Size: 10, Lifetime: 60})
+	}
+}
+
+func historyData(t *testing.T, app *Application, ch Channel) []string {
+	history, err := app.History(ch)
+	assert.Equal(t, nil, err)
+	data := make([]string, len(history))
+	for i, msg := range history {
+		data[i] = string(*msg.Data)
+	}
+	return data
+}
+
+func TestReplayMessages(t *testing.T) {
+	history := []Message{
+		{UID: "3", Timestamp: "30"},
+		{UID: "2", Timestamp: "20"},
+		{UID: "1", Timestamp: "10"},
+	}
+	messages := replayMessages(history, 0)
+	assert.Equal(t, 3, len(messages))
+	assert.Equal(t, "1", messages[0].UID)
+	assert.Equal(t, "3", messages[2].UID)
+
+	messages = replayMessages(history, 20)
+	assert.Equal(t, 2, len(messages))
+	assert.Equal(t, "2", messages[0].UID)
+}
+
+func TestReplayPacing(t *testing.T) {
+	clock := newFakeClock()
+	app := testReplayApp(clock)
+	addReplayHistory(app, "source", 100, 102, 103)
+
+	total, err := app.startReplay("source", "target", 2, 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, total)
+
+	assert.Equal(t, time.Second, clock.waitDelay(t))
+	assert.Equal(t, []string{"0"}, historyData(t, app, "target"))
+	clock.fire <- time.Now()
+	assert.Equal(t, 500*time.Millisecond, clock.waitDelay(t))
+	clock.fire <- time.Now()
+
+	assert.True(t, waitCondition(func() bool {
+		return len(historyData(t, app, "target")) == 3
+	}))
+	assert.Equal(t, []string{"2", "1", "0"}, historyData(t, app, "target"))
+	assert.Equal(t, []string{"2", "1", "0"}, historyData(t, app, "source"))
+	assert.True(t, waitCondition(func() bool {
+		return !app.cancelReplay("target")
+	}))
+}
+
+func TestReplayCancel(t *testing.T) {
+	clock := newFakeClock()
+	app := testReplayApp(clock)
+	addReplayHistory(app, "source", 100, 110, 120)
+
+	_, err := app.startReplay("source", "target", 1, 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 10*time.Second, clock.waitDelay(t))
+
+	_, err = app.startReplay("source", "target", 1, 0)
+	assert.Equal(t, ErrReplayInProgress, err)
+
+	assert.True(t, app.cancelReplay("target"))
+	assert.False(t, app.cancelReplay("target"))
+	assert.Equal(t, []string{"0"}, historyData(t, app, "target"))
+	assert.Equal(t, 3, len(historyData(t, app, "source")))
+
+	// new replay into the same target allowed after cancellation.
+	total, err := app.startReplay("source", "target", 1, 110)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, total)
+	assert.Equal(t, 10*time.Second, clock.waitDelay(t))
+	assert.True(t, app.cancelReplay("target"))
+}
+
+func TestReplayShutdown(t *testing.T) {
+	clock := newFakeClock()
+	app := testReplayApp(clock)
+	addReplayHistory(app, "source", 100, 110)
+
+	_, err := app.startReplay("source", "target", 1, 0)
+	assert.Equal(t, nil, err)
+	app.replays.Lock()
+	r := app.replays.replays["target"]
+	app.replays.Unlock()
+	clock.waitDelay(t)
+
+	app.Shutdown()
+	select {
+	case <-r.done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("replay not stopped on shutdown")
+	}
+	assert.Equal(t, []string{"0"}, historyData(t, app, "target"))
+}
+
+func TestAPIReplay(t *testing.T) {
+	app := testReplayApp(newFakeClock())
+
+	params, _ := json.Marshal(replayAPICommand{SourceChannel: "source", TargetChannel: "source"})
+	_, err := app.apiCmd(apiCommand{Method: "replay", Params: params})
+	assert.Equal(t, ErrInvalidMessage, err)
+
+	params, _ = json.Marshal(replayAPICommand{SourceChannel: "source", TargetChannel: "target"})
+	resp, err := app.apiCmd(apiCommand{Method: "replay", Params: params})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, resp.(*apiReplayResponse).err)
+	assert.Equal(t, 0, resp.(*apiReplayResponse).Body.Total)
+
+	params, _ = json.Marshal(replayCancelAPICommand{TargetChannel: "target"})
+	resp, err = app.apiCmd(apiCommand{Method: "replay_cancel", Params: params})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "replay_cancel", resp.(*apiReplayCancelResponse).Method)
+}
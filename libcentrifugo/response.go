@@ -1,14 +1,34 @@
 package libcentrifugo
 
 import (
+	"encoding/base64"
+	"encoding/json"
+
 	"github.com/centrifugal/centrifugo/libcentrifugo/encode"
 	"github.com/centrifugal/centrifugo/libcentrifugo/raw"
 	"github.com/valyala/bytebufferpool"
 )
 
+// encodeJSON encodes value to JSON using pooled buffer. Connection queues keep
+// messages until they are written so result is copied out of buffer before it
+// returned to pool.
+func encodeJSON(v interface{}) ([]byte, error) {
+	buf := bytebufferpool.Get()
+	defer bytebufferpool.Put(buf)
+	if err := json.NewEncoder(buf).Encode(v); err != nil {
+		return nil, err
+	}
+	// Encoder terminates value with newline.
+	data := buf.Bytes()[:buf.Len()-1]
+	c := make([]byte, len(data))
+	copy(c, data)
+	return c, nil
+}
+
 // clientMessageResponse can not have an error.
 type clientMessageResponse struct {
 	Method string  `json:"method"`
+	Ack    string  `json:"ack,omitempty"`
 	Body   Message `json:"body"`
 }
 
@@ -66,13 +86,26 @@ func writeMessage(buf *bytebufferpool.ByteBuffer, message *Message) {
 	buf.WriteString(`"channel":`)
 	encode.EncodeJSONString(buf, message.Channel, true)
 	buf.WriteString(`,"data":`)
-	buf.Write(*message.Data)
+	if message.Encoding == PayloadEncodingBinary {
+		buf.WriteString(`"`)
+		buf.WriteString(base64.StdEncoding.EncodeToString(*message.Data))
+		buf.WriteString(`","encoding":`)
+		encode.EncodeJSONString(buf, message.Encoding, true)
+	} else {
+		buf.Write(*message.Data)
+	}
 	buf.WriteString(`}`)
 }
 
 func (m *clientMessageResponse) Marshal() ([]byte, error) {
 	buf := bytebufferpool.Get()
-	buf.WriteString(`{"method":"message","body":`)
+	buf.WriteString(`{"method":"message",`)
+	if m.Ack != "" {
+		buf.WriteString(`"ack":`)
+		encode.EncodeJSONString(buf, m.Ack, true)
+		buf.WriteString(`,`)
+	}
+	buf.WriteString(`"body":`)
 	writeMessage(buf, &m.Body)
 	buf.WriteString(`}`)
 	c := make([]byte, buf.Len())
@@ -149,15 +182,113 @@ type presenceBody struct {
 	Data    map[ConnID]ClientInfo `json:"data"`
 }
 
+// presenceStatsBody represents body of response in case of successful presence_stats command.
+type presenceStatsBody struct {
+	Channel    Channel `json:"channel"`
+	NumClients int     `json:"num_clients"`
+	NumUsers   int     `json:"num_users"`
+}
+
+// limitAdviceBody represents body of message sent to client when it exceeded
+// hard limit and limit will be enforced after grace period.
+type limitAdviceBody struct {
+	Limit string `json:"limit"`
+	Value int    `json:"value"`
+	Max   int    `json:"max"`
+	// Grace is a grace period in seconds.
+	Grace int64 `json:"grace"`
+}
+
+// ackBody represents body of response in case of successful ack command.
+type ackBody struct {
+	Token  string `json:"token"`
+	Status bool   `json:"status"`
+}
+
+// ackAdviceBody represents body of message sent to client when number of messages
+// it has not acknowledged reached threshold.
+type ackAdviceBody struct {
+	Outstanding int `json:"outstanding"`
+}
+
+// disconnectClientBody represents body of response in case of successful disconnect_client command.
+type disconnectClientBody struct {
+	Client ConnID `json:"client"`
+	// Found is true when connection was found on some node and closed.
+	Found bool `json:"found"`
+}
+
+// userConnectionsBody represents body of response in case of successful user_connections command.
+type userConnectionsBody struct {
+	User UserID           `json:"user"`
+	Data []userConnection `json:"data"`
+	// Incomplete is true when some nodes did not report connections in time.
+	Incomplete bool `json:"incomplete,omitempty"`
+}
+
+// deliveryStateBody represents body of response in case of successful delivery_state command.
+type deliveryStateBody struct {
+	User UserID          `json:"user"`
+	Data []deliveryState `json:"data"`
+}
+
+// replayBody represents body of response in case of successful replay command.
+type replayBody struct {
+	SourceChannel Channel `json:"source_channel"`
+	TargetChannel Channel `json:"target_channel"`
+	Total         int     `json:"total"`
+}
+
+// replayCancelBody represents body of response in case of successful replay_cancel command.
+type replayCancelBody struct {
+	TargetChannel Channel `json:"target_channel"`
+	Cancelled     bool    `json:"cancelled"`
+}
+
 // historyBody represents body of response in case of successful history command.
 type historyBody struct {
 	Channel Channel   `json:"channel"`
 	Data    []Message `json:"data"`
 }
 
+// channelInfoBody represents body of response in case of successful channel_info command.
+type channelInfoBody struct {
+	Channel        Channel `json:"channel"`
+	NumSubscribers int     `json:"num_subscribers"`
+	// Options are channel options resolved for channel namespace.
+	Options *ChannelOptions `json:"options,omitempty"`
+	// Presence set only when presence enabled for channel.
+	Presence *channelPresenceInfo `json:"presence,omitempty"`
+	// History set only when history enabled for channel.
+	History *channelHistoryInfo `json:"history,omitempty"`
+}
+
+// channelPresenceInfo is a presence summary of channel in channel_info response.
+type channelPresenceInfo struct {
+	NumClients int `json:"num_clients"`
+	NumUsers   int `json:"num_users"`
+}
+
+// channelHistoryInfo is a history summary of channel in channel_info response,
+// timestamps are empty when history is empty.
+type channelHistoryInfo struct {
+	Length          int    `json:"length"`
+	OldestTimestamp string `json:"oldest_timestamp,omitempty"`
+	NewestTimestamp string `json:"newest_timestamp,omitempty"`
+}
+
+// disconnectBulkBody represents body of response in case of successful disconnect_bulk command.
+type disconnectBulkBody struct {
+	// Matched is a number of connections matching filter on this node.
+	Matched int  `json:"matched"`
+	DryRun  bool `json:"dry_run"`
+}
+
 // channelsBody represents body of response in case of successful channels command.
 type channelsBody struct {
 	Data []Channel `json:"data"`
+	// Truncated is true when there are more matching channels than limit.
+	Truncated bool `json:"truncated,omitempty"`
 }
 
 // connectBody represents body of response in case of successful connect command.
@@ -167,6 +298,8 @@ type connectBody struct {
 	Expires bool   `json:"expires"`
 	Expired bool   `json:"expired"`
 	TTL     int64  `json:"ttl"`
+	// Subs contains results of server side subscriptions made on connect.
+	Subs batchSubscribeBody `json:"subs,omitempty"`
 }
 
 // subscribeBody represents body of response in case of successful subscribe command.
@@ -176,18 +309,60 @@ type subscribeBody struct {
 	Last      MessageID `json:"last"`
 	Messages  []Message `json:"messages"`
 	Recovered bool      `json:"recovered"`
+	// History contains last channel messages (newest first) if client asked for
+	// them in subscribe command.
+	History []Message `json:"history,omitempty"`
+}
+
+// subscribeResult represents result of subscription on one channel in batch subscribe command.
+type subscribeResult struct {
+	subscribeBody
+	Error  string      `json:"error,omitempty"`
+	Advice errorAdvice `json:"advice,omitempty"`
+}
+
+// batchSubscribeBody represents body of response on batch subscribe command – it
+// contains subscription result for every channel.
+type batchSubscribeBody map[Channel]subscribeResult
+
+// unsubscribeAPIBody represents body of response to unsubscribe API command.
+type unsubscribeAPIBody struct {
+	// Affected is a number of connections on this node unsubscribed from channel.
+	Affected int `json:"affected"`
 }
 
 // unsubscribeBody represents body of response in case of successful unsubscribe command.
 type unsubscribeBody struct {
-	Channel Channel `json:"channel"`
-	Status  bool    `json:"status"`
+	Channel Channel            `json:"channel"`
+	Status  bool               `json:"status"`
+	Advice  *unsubscribeAdvice `json:"advice,omitempty"`
+}
+
+// unsubscribeAdvice sent to client when server unsubscribed it from channel.
+type unsubscribeAdvice struct {
+	// Resubscribe tells client whether it makes sense to subscribe on channel again.
+	Resubscribe bool `json:"resubscribe"`
+}
+
+// unsubscribeAllBody represents body of response in case of successful unsubscribe_all command.
+type unsubscribeAllBody struct {
+	Channels []Channel `json:"channels"`
 }
 
 // publishBody represents body of response in case of successful publish command.
 type publishBody struct {
 	Channel Channel `json:"channel"`
 	Status  bool    `json:"status"`
+	UID     string  `json:"uid,omitempty"`
+	// Size is a size of message data set when it exceeds channel max_message_size.
+	Size int `json:"size,omitempty"`
+}
+
+// apiPublishBody represents body of API response in case of successful publish command.
+type apiPublishBody struct {
+	UID string `json:"uid,omitempty"`
+	// Size is a size of message data set when it exceeds channel max_message_size.
+	Size int `json:"size,omitempty"`
 }
 
 // disconnectBody represents body of disconnect response when we want to tell
@@ -201,6 +376,8 @@ type disconnectBody struct {
 // pingBody represents body of response in case of successful ping command.
 type pingBody struct {
 	Data string `json:"data"`
+	// Time is a server unix time in milliseconds.
+	Time int64 `json:"time"`
 }
 
 // statsBody represents body of response in case of successful stats command.
@@ -213,13 +390,32 @@ type nodeBody struct {
 	Data nodeInfo `json:"data"`
 }
 
+// nodesBody represents body of response in case of successful nodes command.
+type nodesBody struct {
+	Data []clusterNode `json:"data"`
+}
+
+// diagBody represents body of response in case of successful diag command.
+type diagBody struct {
+	Data raw.Raw `json:"data"`
+}
+
 type adminMessageBody struct {
 	Message Message `json:"message"`
 }
 
 type adminInfoBody struct {
-	Engine string  `json:"engine"`
-	Config *Config `json:"config"`
+	Engine            string             `json:"engine"`
+	Config            *Config            `json:"config"`
+	DynamicNamespaces []dynamicNamespace `json:"dynamic_namespaces"`
+	// Nodes contains known nodes of cluster.
+	Nodes []clusterNode `json:"nodes"`
+}
+
+// dynamicNamespace represents namespace created from namespace template.
+type dynamicNamespace struct {
+	Namespace
+	Dynamic bool `json:"dynamic"`
 }
 
 type response interface {
@@ -246,6 +442,8 @@ type clientResponse struct {
 	UID    string `json:"uid,omitempty"`
 	Method string `json:"method"`
 	Error  string `json:"error,omitempty"`
+	// Code is an error code set when connection or subscription rejected by proxy.
+	Code int `json:"code,omitempty"`
 	responseError
 }
 
@@ -259,12 +457,25 @@ func (r *clientResponse) SetErr(err responseError) {
 	r.responseError = err
 	e := err.err.Error()
 	r.Error = e
+	if proxyErr, ok := err.err.(*proxyError); ok {
+		r.Code = proxyErr.Code
+	}
 }
 
 func (r *clientResponse) SetUID(uid string) {
 	r.UID = uid
 }
 
+// newClientErrorResponse creates response with error for command which was not
+// handled at all, for example because of rate limit.
+func newClientErrorResponse(method string, err responseError) response {
+	resp := &clientResponse{
+		Method: method,
+	}
+	resp.SetErr(err)
+	return resp
+}
+
 type clientConnectResponse struct {
 	clientResponse
 	Body connectBody `json:"body"`
@@ -307,6 +518,20 @@ func newClientSubscribeResponse(body subscribeBody) response {
 	}
 }
 
+type clientBatchSubscribeResponse struct {
+	clientResponse
+	Body batchSubscribeBody `json:"body"`
+}
+
+func newClientBatchSubscribeResponse(body batchSubscribeBody) response {
+	return &clientBatchSubscribeResponse{
+		clientResponse: clientResponse{
+			Method: "subscribe",
+		},
+		Body: body,
+	}
+}
+
 type clientUnsubscribeResponse struct {
 	clientResponse
 	Body unsubscribeBody `json:"body"`
@@ -321,6 +546,20 @@ func newClientUnsubscribeResponse(body unsubscribeBody) response {
 	}
 }
 
+type clientUnsubscribeAllResponse struct {
+	clientResponse
+	Body unsubscribeAllBody `json:"body"`
+}
+
+func newClientUnsubscribeAllResponse(body unsubscribeAllBody) response {
+	return &clientUnsubscribeAllResponse{
+		clientResponse: clientResponse{
+			Method: "unsubscribe_all",
+		},
+		Body: body,
+	}
+}
+
 type clientPresenceResponse struct {
 	clientResponse
 	Body presenceBody `json:"body"`
@@ -335,6 +574,62 @@ func newClientPresenceResponse(body presenceBody) response {
 	}
 }
 
+type clientPresenceStatsResponse struct {
+	clientResponse
+	Body presenceStatsBody `json:"body"`
+}
+
+func newClientPresenceStatsResponse(body presenceStatsBody) response {
+	return &clientPresenceStatsResponse{
+		clientResponse: clientResponse{
+			Method: "presence_stats",
+		},
+		Body: body,
+	}
+}
+
+type clientAckResponse struct {
+	clientResponse
+	Body ackBody `json:"body"`
+}
+
+func newClientAckResponse(body ackBody) response {
+	return &clientAckResponse{
+		clientResponse: clientResponse{
+			Method: "ack",
+		},
+		Body: body,
+	}
+}
+
+type clientLimitAdviceResponse struct {
+	clientResponse
+	Body limitAdviceBody `json:"body"`
+}
+
+func newClientLimitAdviceResponse(body limitAdviceBody) response {
+	return &clientLimitAdviceResponse{
+		clientResponse: clientResponse{
+			Method: "limit_advice",
+		},
+		Body: body,
+	}
+}
+
+type clientAckAdviceResponse struct {
+	clientResponse
+	Body ackAdviceBody `json:"body"`
+}
+
+func newClientAckAdviceResponse(body ackAdviceBody) response {
+	return &clientAckAdviceResponse{
+		clientResponse: clientResponse{
+			Method: "ack_advice",
+		},
+		Body: body,
+	}
+}
+
 type clientHistoryResponse struct {
 	clientResponse
 	Body historyBody `json:"body"`
@@ -420,6 +715,20 @@ func (r *apiResponse) SetUID(uid string) {
 	r.UID = uid
 }
 
+func (r *apiResponse) responseErr() error {
+	return r.err
+}
+
+// newAPIErrorResponse returns response to API command without body, used when
+// command rejected before reaching its handler.
+func newAPIErrorResponse(method string, err responseError) response {
+	resp := &apiResponse{
+		Method: method,
+	}
+	resp.SetErr(err)
+	return resp
+}
+
 type apiPublishResponse struct {
 	apiResponse
 	Body interface{} `json:"body"` // TODO: interface{} for API protocol backwards compatibility.
@@ -488,6 +797,20 @@ func newAPIChannelsResponse(body channelsBody) response {
 	}
 }
 
+type apiChannelInfoResponse struct {
+	apiResponse
+	Body channelInfoBody `json:"body"`
+}
+
+func newAPIChannelInfoResponse(body channelInfoBody) response {
+	return &apiChannelInfoResponse{
+		apiResponse: apiResponse{
+			Method: "channel_info",
+		},
+		Body: body,
+	}
+}
+
 type apiStatsResponse struct {
 	apiResponse
 	Body statsBody `json:"body"`
@@ -504,14 +827,15 @@ func newAPIStatsResponse(body statsBody) response {
 
 type apiUnsubscribeResponse struct {
 	apiResponse
-	Body interface{} `json:"body"` // TODO: interface{} for API protocol backwards compatibility.
+	Body unsubscribeAPIBody `json:"body"`
 }
 
-func newAPIUnsubscribeResponse() response {
+func newAPIUnsubscribeResponse(body unsubscribeAPIBody) response {
 	return &apiUnsubscribeResponse{
 		apiResponse: apiResponse{
 			Method: "unsubscribe",
 		},
+		Body: body,
 	}
 }
 
@@ -528,6 +852,62 @@ func newAPIDisconnectResponse() response {
 	}
 }
 
+type apiDisconnectBulkResponse struct {
+	apiResponse
+	Body disconnectBulkBody `json:"body"`
+}
+
+func newAPIDisconnectBulkResponse(body disconnectBulkBody) response {
+	return &apiDisconnectBulkResponse{
+		apiResponse: apiResponse{
+			Method: "disconnect_bulk",
+		},
+		Body: body,
+	}
+}
+
+type apiDeliveryStateResponse struct {
+	apiResponse
+	Body deliveryStateBody `json:"body"`
+}
+
+func newAPIDeliveryStateResponse(body deliveryStateBody) response {
+	return &apiDeliveryStateResponse{
+		apiResponse: apiResponse{
+			Method: "delivery_state",
+		},
+		Body: body,
+	}
+}
+
+type apiDisconnectClientResponse struct {
+	apiResponse
+	Body disconnectClientBody `json:"body"`
+}
+
+func newAPIDisconnectClientResponse(body disconnectClientBody) response {
+	return &apiDisconnectClientResponse{
+		apiResponse: apiResponse{
+			Method: "disconnect_client",
+		},
+		Body: body,
+	}
+}
+
+type apiUserConnectionsResponse struct {
+	apiResponse
+	Body userConnectionsBody `json:"body"`
+}
+
+func newAPIUserConnectionsResponse(body userConnectionsBody) response {
+	return &apiUserConnectionsResponse{
+		apiResponse: apiResponse{
+			Method: "user_connections",
+		},
+		Body: body,
+	}
+}
+
 type apiNodeResponse struct {
 	apiResponse
 	Body nodeBody `json:"body"`
@@ -542,6 +922,62 @@ func newAPINodeResponse(body nodeBody) response {
 	}
 }
 
+type apiNodesResponse struct {
+	apiResponse
+	Body nodesBody `json:"body"`
+}
+
+func newAPINodesResponse(body nodesBody) response {
+	return &apiNodesResponse{
+		apiResponse: apiResponse{
+			Method: "nodes",
+		},
+		Body: body,
+	}
+}
+
+type apiReplayResponse struct {
+	apiResponse
+	Body replayBody `json:"body"`
+}
+
+func newAPIReplayResponse(body replayBody) response {
+	return &apiReplayResponse{
+		apiResponse: apiResponse{
+			Method: "replay",
+		},
+		Body: body,
+	}
+}
+
+type apiReplayCancelResponse struct {
+	apiResponse
+	Body replayCancelBody `json:"body"`
+}
+
+func newAPIReplayCancelResponse(body replayCancelBody) response {
+	return &apiReplayCancelResponse{
+		apiResponse: apiResponse{
+			Method: "replay_cancel",
+		},
+		Body: body,
+	}
+}
+
+type apiDiagResponse struct {
+	apiResponse
+	Body diagBody `json:"body"`
+}
+
+func newAPIDiagResponse(body diagBody) response {
+	return &apiDiagResponse{
+		apiResponse: apiResponse{
+			Method: "diag",
+		},
+		Body: body,
+	}
+}
+
 type apiAdminConnectResponse struct {
 	apiResponse
 	Body bool `json:"body"`
@@ -584,15 +1020,57 @@ func newAPIAdminPingResponse(body string) response {
 	}
 }
 
+// adminRefreshBody contains new admin token and its expiration unix time.
+type adminRefreshBody struct {
+	Token   string `json:"token"`
+	Expires int64  `json:"expires,omitempty"`
+}
+
+type apiAdminRefreshResponse struct {
+	apiResponse
+	Body adminRefreshBody `json:"body"`
+}
+
+func newAPIAdminRefreshResponse(body adminRefreshBody) response {
+	return &apiAdminRefreshResponse{
+		apiResponse: apiResponse{
+			Method: "refresh",
+		},
+		Body: body,
+	}
+}
+
+// adminWatchBody describes channel watch of admin connection, Expires is a unix
+// time when watch stops.
+type adminWatchBody struct {
+	Channel Channel `json:"channel,omitempty"`
+	Pattern string  `json:"pattern,omitempty"`
+	Expires int64   `json:"expires,omitempty"`
+}
+
+type apiAdminWatchResponse struct {
+	apiResponse
+	Body adminWatchBody `json:"body"`
+}
+
+func newAPIAdminWatchResponse(method string, body adminWatchBody) response {
+	return &apiAdminWatchResponse{
+		apiResponse: apiResponse{
+			Method: method,
+		},
+		Body: body,
+	}
+}
+
 type apiAdminMessageResponse struct {
 	apiResponse
 	Body *raw.Raw `json:"body"`
 }
 
-func newAPIAdminMessageResponse(body *raw.Raw) response {
+func newAPIAdminMessageResponse(method string, body *raw.Raw) response {
 	return &apiAdminMessageResponse{
 		apiResponse: apiResponse{
-			Method: "message",
+			Method: method,
 		},
 		Body: body,
 	}
@@ -48,6 +48,24 @@ func TestMultiResponse(t *testing.T) {
 	assert.Equal(t, true, strings.Contains(string(marshalledResponse), "\"error\":null"))
 }
 
+func TestEncodeJSON(t *testing.T) {
+	mr := multiClientResponse{
+		newClientPublishResponse(publishBody{Channel: "<test>", Status: true}),
+		newClientPingResponse(pingBody{}),
+	}
+	expected, err := json.Marshal(mr)
+	assert.Equal(t, nil, err)
+	encoded, err := encodeJSON(mr)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, string(expected), string(encoded))
+
+	// result must not share memory with pooled buffer used by next encoding.
+	other, err := encodeJSON(newClientPingResponse(pingBody{Data: "other"}))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, string(expected), string(encoded))
+	assert.True(t, strings.Contains(string(other), `"other"`))
+}
+
 func TestClientResponse(t *testing.T) {
 	resp := newClientPublishResponse(publishBody{Status: true})
 	resp.SetErr(responseError{errors.New("error1"), errorAdviceFix})
@@ -59,7 +77,7 @@ func TestClientResponse(t *testing.T) {
 
 func TestAdminMessageResponse(t *testing.T) {
 	data := raw.Raw([]byte("test"))
-	resp := newAPIAdminMessageResponse(&data)
+	resp := newAPIAdminMessageResponse("message", &data)
 	assert.Equal(t, "message", resp.(*apiAdminMessageResponse).Method)
 }
 
@@ -1,11 +1,28 @@
 package libcentrifugo
 
 import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gorilla/websocket"
 )
 
+// WebsocketBinarySubprotocol is a Websocket subprotocol client can negotiate to
+// receive messages with binary payloads as binary frames containing Message
+// encoded with protobuf (see message.proto). All other frames are JSON.
+const WebsocketBinarySubprotocol = "centrifugo-binary"
+
+// WebsocketMsgpackSubprotocol is a Websocket subprotocol client can negotiate to
+// send commands and receive all messages encoded with MessagePack in binary frames.
+// The same can be requested with format=msgpack query parameter.
+const WebsocketMsgpackSubprotocol = "centrifugo-msgpack"
+
 // Session represents a connection between server and client.
 type session interface {
 	// Send sends one message to session
@@ -21,15 +38,31 @@ type websocketConn interface {
 	ReadMessage() (messageType int, p []byte, err error)
 	WriteMessage(messageType int, data []byte) error
 	WriteControl(messageType int, data []byte, deadline time.Time) error
+	EnableWriteCompression(enable bool)
+	SetWriteDeadline(t time.Time) error
 	Close() error
 }
 
 // wsSession is a wrapper struct over websocket connection to fit session interface so client will accept it.
 type wsSession struct {
+	// writeMu serializes data frame writes as gorilla/websocket supports only
+	// one concurrent writer, control frames can be written concurrently.
+	writeMu      sync.Mutex
 	ws           websocketConn
 	closeCh      chan struct{}
 	pingInterval time.Duration
 	pingTimer    *time.Timer
+	// binary is true when client negotiated binary or msgpack subprotocol.
+	binary bool
+	// compression is not nil when client negotiated permessage-deflate extension,
+	// it counts bytes written into connection.
+	compression *countingConn
+	// compressionMinSize is a min size of message to compress.
+	compressionMinSize int
+	metrics            *metricsRegistry
+	// writeTimeout is a max time to write message into connection, zero means
+	// no timeout.
+	writeTimeout time.Duration
 }
 
 func newWSSession(ws websocketConn, pingInterval time.Duration) *wsSession {
@@ -63,11 +96,101 @@ func (sess *wsSession) Send(message []byte) error {
 	case <-sess.closeCh:
 		return nil
 	default:
-		return sess.ws.WriteMessage(websocket.TextMessage, message)
+		sess.writeMu.Lock()
+		defer sess.writeMu.Unlock()
+		if sess.writeTimeout > 0 {
+			sess.ws.SetWriteDeadline(time.Now().Add(sess.writeTimeout))
+		}
+		err := sess.write(message)
+		if e, ok := err.(net.Error); ok && e.Timeout() {
+			return ErrSendTimeout
+		}
+		return err
+	}
+}
+
+// write writes message into connection choosing frame type and compression.
+func (sess *wsSession) write(message []byte) error {
+	messageType := websocket.TextMessage
+	if sess.binary && !isJSONFrame(message) {
+		messageType = websocket.BinaryMessage
+	}
+	if sess.compression == nil {
+		return sess.ws.WriteMessage(messageType, message)
+	}
+	compress := len(message) >= sess.compressionMinSize
+	sess.ws.EnableWriteCompression(compress)
+	if !compress {
+		return sess.ws.WriteMessage(messageType, message)
+	}
+	written := sess.compression.written()
+	err := sess.ws.WriteMessage(messageType, message)
+	if sess.metrics != nil {
+		sess.metrics.BytesUncompressedOut.Add(int64(len(message)))
+		sess.metrics.BytesCompressedOut.Add(sess.compression.written() - written)
+	}
+	return err
+}
+
+// maxCloseReasonLength is a max length of Websocket close frame reason as control
+// frame payload limited to 125 bytes including 2-byte status code.
+const maxCloseReasonLength = 123
+
+// truncateCloseReason cuts reason to fit into close frame keeping it valid UTF-8.
+func truncateCloseReason(reason string) string {
+	if len(reason) <= maxCloseReasonLength {
+		return reason
+	}
+	n := maxCloseReasonLength
+	for n > 0 && !utf8.RuneStart(reason[n]) {
+		n--
+	}
+	return reason[:n]
+}
+
+// isJSONFrame returns true if message is JSON object or array.
+func isJSONFrame(message []byte) bool {
+	return len(message) > 0 && (message[0] == objectJSONPrefix || message[0] == arrayJSONPrefix)
+}
+
+// countingConn counts bytes written into connection.
+type countingConn struct {
+	net.Conn
+	n int64
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	atomic.AddInt64(&c.n, int64(n))
+	return n, err
+}
+
+func (c *countingConn) written() int64 {
+	return atomic.LoadInt64(&c.n)
+}
+
+// countingResponseWriter allows to count bytes written into hijacked connection.
+type countingResponseWriter struct {
+	http.ResponseWriter
+	conn *countingConn
+}
+
+func (w *countingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not implement http.Hijacker")
+	}
+	conn, rw, err := h.Hijack()
+	if err != nil {
+		return nil, nil, err
 	}
+	w.conn = &countingConn{Conn: conn}
+	return w.conn, rw, nil
 }
 
+// Close sends close frame and closes connection. It does not wait for data frame
+// being written so connection blocked by slow client can still be closed.
 func (sess *wsSession) Close(status uint32, reason string) error {
-	sess.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(int(status), reason), time.Now().Add(time.Second))
+	sess.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(int(status), truncateCloseReason(reason)), time.Now().Add(time.Second))
 	return sess.ws.Close()
 }
@@ -2,8 +2,11 @@ package libcentrifugo
 
 import (
 	"errors"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
@@ -15,8 +18,19 @@ type testWSConnection struct {
 	controlErr bool
 	closeErr   bool
 	closed     bool
+	// messageTypes contains types of written messages.
+	messageTypes []int
+	deadline     time.Time
+	writeTimeout bool
 }
 
+// testTimeoutError is a network error returned when write deadline exceeded.
+type testTimeoutError struct{}
+
+func (e testTimeoutError) Error() string   { return "i/o timeout" }
+func (e testTimeoutError) Timeout() bool   { return true }
+func (e testTimeoutError) Temporary() bool { return true }
+
 func (c *testWSConnection) ReadMessage() (messageType int, p []byte, err error) {
 	if c.readErr {
 		return websocket.TextMessage, nil, errors.New("error")
@@ -28,6 +42,10 @@ func (c *testWSConnection) WriteMessage(messageType int, data []byte) error {
 	if c.writeErr {
 		return errors.New("error")
 	}
+	if c.writeTimeout {
+		return testTimeoutError{}
+	}
+	c.messageTypes = append(c.messageTypes, messageType)
 	return nil
 }
 
@@ -38,6 +56,13 @@ func (c *testWSConnection) WriteControl(messageType int, data []byte, deadline t
 	return nil
 }
 
+func (c *testWSConnection) EnableWriteCompression(enable bool) {}
+
+func (c *testWSConnection) SetWriteDeadline(t time.Time) error {
+	c.deadline = t
+	return nil
+}
+
 func (c *testWSConnection) Close() error {
 	c.closed = true
 	if c.closeErr {
@@ -71,6 +96,24 @@ func TestWSConnPingAfterClose(t *testing.T) {
 	assert.Equal(t, true, c.ws.(*testWSConnection).closed)
 }
 
+func TestWSConnSendBinary(t *testing.T) {
+	ws := &testWSConnection{}
+	c := newWSSession(ws, time.Minute)
+	defer close(c.closeCh)
+	c.Send([]byte(`{"method":"message"}`))
+	c.Send([]byte{0x0a, 0x01})
+	assert.Equal(t, []int{websocket.TextMessage, websocket.TextMessage}, ws.messageTypes)
+
+	ws = &testWSConnection{}
+	c = newWSSession(ws, time.Minute)
+	defer close(c.closeCh)
+	c.binary = true
+	c.Send([]byte(`{"method":"message"}`))
+	c.Send([]byte(`[{"method":"message"}]`))
+	c.Send([]byte{0x0a, 0x01})
+	assert.Equal(t, []int{websocket.TextMessage, websocket.TextMessage, websocket.BinaryMessage}, ws.messageTypes)
+}
+
 func TestSendAfterClose(t *testing.T) {
 	ws := &testWSConnection{}
 	c := newWSSession(ws, 1*time.Nanosecond)
@@ -81,3 +124,62 @@ func TestSendAfterClose(t *testing.T) {
 	err = c.Send([]byte("test"))
 	assert.Equal(t, nil, err)
 }
+
+func TestWSConnSendTimeout(t *testing.T) {
+	ws := &testWSConnection{writeTimeout: true}
+	c := newWSSession(ws, time.Second)
+	defer close(c.closeCh)
+	c.writeTimeout = time.Second
+	err := c.Send([]byte("test"))
+	assert.Equal(t, ErrSendTimeout, err)
+	assert.False(t, ws.deadline.IsZero())
+}
+
+// testSlowSession is a session which never manages to write message in time.
+type testSlowSession struct {
+	testSession
+}
+
+func (s *testSlowSession) Send(msg []byte) error {
+	return ErrSendTimeout
+}
+
+func TestClientSendTimeout(t *testing.T) {
+	app := testApp()
+	sess := &testSlowSession{}
+	c := newTestClient(app, sess)
+	err := c.send([]byte("test"))
+	assert.Equal(t, nil, err)
+	assert.True(t, waitCondition(func() bool {
+		sess.Lock()
+		defer sess.Unlock()
+		return sess.closed
+	}))
+	sess.Lock()
+	assert.Contains(t, sess.reason, "slow")
+	sess.Unlock()
+	assert.Equal(t, int64(1), app.metrics.NumClientSendTimeouts.LoadRaw())
+}
+
+func TestWSConnConcurrentSend(t *testing.T) {
+	ws := &testWSConnection{}
+	c := newWSSession(ws, time.Minute)
+	defer c.pingTimer.Stop()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Send([]byte("test"))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 10, len(ws.messageTypes))
+}
+
+func TestTruncateCloseReason(t *testing.T) {
+	assert.Equal(t, "test", truncateCloseReason("test"))
+	reason := truncateCloseReason(strings.Repeat("ы", 100))
+	assert.Equal(t, 122, len(reason))
+	assert.True(t, utf8.ValidString(reason))
+}
@@ -0,0 +1,144 @@
+package libcentrifugo
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/centrifugal/centrifugo/libcentrifugo/auth"
+)
+
+// signCacheKey identifies verified private channel subscription. Channel sign is
+// HMAC over client connection UID, channel and channel info so all of them are
+// part of key – cached verification never reused for another connection UID. If
+// sign scheme ever becomes UID independent the key must be revised.
+type signCacheKey struct {
+	client  ConnID
+	channel Channel
+	info    string
+	sign    string
+	// secret is a namespace secret sign made with, empty when global secret used.
+	secret string
+}
+
+type signCacheEntry struct {
+	key     signCacheKey
+	expires time.Time
+}
+
+// signCache keeps successful private channel sign verifications for a short time
+// so repeated subscriptions with the same sign do not recompute HMAC. Only
+// successful verifications cached. Cache bounded using LRU eviction and purged
+// as soon as secret changes.
+type signCache struct {
+	sync.Mutex
+	secret string
+	ll     *list.List
+	items  map[signCacheKey]*list.Element
+}
+
+func newSignCache() *signCache {
+	return &signCache{
+		ll:    list.New(),
+		items: make(map[signCacheKey]*list.Element),
+	}
+}
+
+// reset removes all cached verifications.
+func (c *signCache) reset() {
+	c.Lock()
+	defer c.Unlock()
+	c.ll.Init()
+	c.items = make(map[signCacheKey]*list.Element)
+}
+
+// check returns true if sign is valid for key. Successful verification cached
+// for ttl, at most size verifications kept.
+func (c *signCache) check(secret string, key signCacheKey, ttl time.Duration, size int, now time.Time) bool {
+	c.Lock()
+	if c.secret != secret {
+		// secret rotated – verifications made with old secret are not valid anymore.
+		c.ll.Init()
+		c.items = make(map[signCacheKey]*list.Element)
+		c.secret = secret
+	}
+	if el, ok := c.items[key]; ok {
+		if now.Before(el.Value.(*signCacheEntry).expires) {
+			c.ll.MoveToFront(el)
+			c.Unlock()
+			return true
+		}
+		c.ll.Remove(el)
+		delete(c.items, key)
+	}
+	c.Unlock()
+
+	signSecret := secret
+	if key.secret != "" {
+		signSecret = key.secret
+	}
+	if !auth.CheckChannelSign(signSecret, string(key.client), string(key.channel), key.info, key.sign) {
+		return false
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if c.secret != secret {
+		// secret changed while verifying, do not cache.
+		return true
+	}
+	if el, ok := c.items[key]; ok {
+		el.Value.(*signCacheEntry).expires = now.Add(ttl)
+		c.ll.MoveToFront(el)
+		return true
+	}
+	c.items[key] = c.ll.PushFront(&signCacheEntry{key: key, expires: now.Add(ttl)})
+	for c.ll.Len() > size {
+		el := c.ll.Back()
+		c.ll.Remove(el)
+		delete(c.items, el.Value.(*signCacheEntry).key)
+	}
+	return true
+}
+
+// checkChannelSign checks private channel sign using sign cache if it is enabled.
+// Sign made with secret of channel namespace if namespace has one.
+func (app *Application) checkChannelSign(client ConnID, ch Channel, info string, sign string) bool {
+	nsSecret := app.namespaceSecret(ch)
+	app.RLock()
+	secret := app.config.Secret
+	previous := app.config.SecretPrevious
+	ttl := app.config.PrivateSignCacheTTL
+	size := app.config.PrivateSignCacheSize
+	app.RUnlock()
+
+	check := func(signSecret string) bool {
+		if ttl <= 0 || size <= 0 {
+			if signSecret == "" {
+				signSecret = secret
+			}
+			return auth.CheckChannelSign(signSecret, string(client), string(ch), info, sign)
+		}
+		key := signCacheKey{
+			client:  client,
+			channel: ch,
+			info:    info,
+			sign:    sign,
+			secret:  signSecret,
+		}
+		return app.signCache.check(secret, key, ttl, size, time.Now())
+	}
+
+	if check(nsSecret) {
+		return true
+	}
+	if nsSecret != "" || previous == "" || previous == secret {
+		return false
+	}
+	// namespace has no own secret so sign can be made with previous project secret.
+	if !check(previous) {
+		return false
+	}
+	app.previousSecretUsed("private channel sign")
+	return true
+}
@@ -0,0 +1,150 @@
+package libcentrifugo
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/centrifugal/centrifugo/libcentrifugo/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+func testSignCacheKey(secret string, client ConnID, ch Channel) signCacheKey {
+	return signCacheKey{
+		client:  client,
+		channel: ch,
+		info:    "{}",
+		sign:    auth.GenerateChannelSign(secret, string(client), string(ch), "{}"),
+	}
+}
+
+func TestSignCache(t *testing.T) {
+	cache := newSignCache()
+	now := time.Now()
+	key := testSignCacheKey("secret", "client", "$private")
+
+	assert.True(t, cache.check("secret", key, time.Second, 10, now))
+	assert.Equal(t, 1, len(cache.items))
+	// cache hit.
+	assert.True(t, cache.check("secret", key, time.Second, 10, now))
+
+	// failed verifications never cached.
+	invalid := key
+	invalid.sign = auth.GenerateChannelSign("other", "client", "$private", "{}")
+	assert.False(t, cache.check("secret", invalid, time.Second, 10, now))
+	assert.Equal(t, 1, len(cache.items))
+
+	// the same sign presented by another connection is not valid.
+	other := key
+	other.client = "another client"
+	assert.False(t, cache.check("secret", other, time.Second, 10, now))
+
+	// expired entry removed and verified again.
+	assert.True(t, cache.check("secret", key, time.Second, 10, now.Add(2*time.Second)))
+	assert.Equal(t, 1, len(cache.items))
+}
+
+func TestSignCacheRotation(t *testing.T) {
+	cache := newSignCache()
+	now := time.Now()
+	key := testSignCacheKey("old", "client", "$private")
+	assert.True(t, cache.check("old", key, time.Minute, 10, now))
+
+	// sign made with old secret must be rejected right after rotation.
+	assert.False(t, cache.check("new", key, time.Minute, 10, now))
+	assert.Equal(t, 0, len(cache.items))
+
+	newKey := testSignCacheKey("new", "client", "$private")
+	assert.True(t, cache.check("new", newKey, time.Minute, 10, now))
+	assert.False(t, cache.check("old", newKey, time.Minute, 10, now))
+}
+
+func TestSignCacheLRU(t *testing.T) {
+	cache := newSignCache()
+	now := time.Now()
+	keys := make([]signCacheKey, 5)
+	for i := range keys {
+		keys[i] = testSignCacheKey("secret", "client", Channel("$private"+strconv.Itoa(i)))
+		assert.True(t, cache.check("secret", keys[i], time.Minute, 3, now))
+	}
+	assert.Equal(t, 3, len(cache.items))
+	_, ok := cache.items[keys[0]]
+	assert.False(t, ok)
+	_, ok = cache.items[keys[4]]
+	assert.True(t, ok)
+}
+
+func TestAppSignCacheSetConfig(t *testing.T) {
+	app := testApp()
+	app.config.PrivateSignCacheTTL = time.Minute
+	key := testSignCacheKey("secret", "client", "$private")
+	assert.True(t, app.checkChannelSign(key.client, key.channel, key.info, key.sign))
+	assert.Equal(t, 1, len(app.signCache.items))
+
+	c := newTestConfig()
+	c.Secret = "rotated"
+	c.PrivateSignCacheTTL = time.Minute
+	app.SetConfig(&c)
+	assert.Equal(t, 0, len(app.signCache.items))
+	assert.False(t, app.checkChannelSign(key.client, key.channel, key.info, key.sign))
+}
+
+func TestAppNamespaceSecret(t *testing.T) {
+	for _, ttl := range []time.Duration{0, time.Minute} {
+		c := newTestConfig()
+		c.Secret = "secret"
+		c.Namespaces[0].Secret = "namespace secret"
+		c.PrivateSignCacheTTL = ttl
+		app := testMemoryAppWithConfig(&c)
+
+		key := testSignCacheKey("namespace secret", "client", "$test:private")
+		assert.True(t, app.checkChannelSign(key.client, key.channel, key.info, key.sign))
+		key = testSignCacheKey("secret", "client", "$test:private")
+		assert.False(t, app.checkChannelSign(key.client, key.channel, key.info, key.sign))
+
+		// channels without namespace secret use project secret.
+		key = testSignCacheKey("secret", "client", "$private")
+		assert.True(t, app.checkChannelSign(key.client, key.channel, key.info, key.sign))
+		key = testSignCacheKey("namespace secret", "client", "$private")
+		assert.False(t, app.checkChannelSign(key.client, key.channel, key.info, key.sign))
+	}
+}
+
+func TestAppChannelSignPreviousSecret(t *testing.T) {
+	for _, ttl := range []time.Duration{0, time.Minute} {
+		app := testApp()
+		app.config.Secret = "new"
+		app.config.SecretPrevious = "secret"
+		app.config.PrivateSignCacheTTL = ttl
+		key := testSignCacheKey("secret", "client", "$private")
+		assert.True(t, app.checkChannelSign(key.client, key.channel, key.info, key.sign))
+		key = testSignCacheKey("new", "client", "$private")
+		assert.True(t, app.checkChannelSign(key.client, key.channel, key.info, key.sign))
+		key = testSignCacheKey("other", "client", "$private")
+		assert.False(t, app.checkChannelSign(key.client, key.channel, key.info, key.sign))
+		assert.Equal(t, int64(1), app.metrics.NumSecretPreviousUsed.LoadRaw())
+	}
+}
+
+func benchmarkChannelSign(b *testing.B, ttl time.Duration) {
+	app := testApp()
+	app.config.PrivateSignCacheTTL = ttl
+	key := testSignCacheKey("secret", "client", "$private")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !app.checkChannelSign(key.client, key.channel, key.info, key.sign) {
+			b.Fatal("sign must be valid")
+		}
+	}
+}
+
+// BenchmarkChannelSignNoCache allows to bench private channel sign verification.
+func BenchmarkChannelSignNoCache(b *testing.B) {
+	benchmarkChannelSign(b, 0)
+}
+
+// BenchmarkChannelSignCache allows to bench private channel sign verification
+// when verification is cached.
+func BenchmarkChannelSignCache(b *testing.B) {
+	benchmarkChannelSign(b, time.Minute)
+}
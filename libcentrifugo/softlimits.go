@@ -0,0 +1,167 @@
+package libcentrifugo
+
+import (
+	"encoding/json"
+	"sync/atomic"
+	"time"
+
+	"github.com/FZambia/go-logger"
+)
+
+// Names of limits client can be warned about in limit advice message.
+const (
+	limitChannels = "channels"
+	limitQueue    = "queue"
+)
+
+// softLimit returns maximum value allowed during grace period for limit with
+// allowed overshoot in percents. Zero percents means that soft limit disabled.
+func softLimit(limit int, percent int) int {
+	if percent <= 0 {
+		return limit
+	}
+	return limit + limit*percent/100
+}
+
+// checkSoftLimit checks value against hard limit. Value above hard limit but not above
+// soft limit allowed during grace period counted from the moment limit was exceeded
+// first time. startedAt keeps that moment in Unix nanoseconds, zero means that value
+// is within hard limit, it is accessed atomically. First returned value is true if value
+// allowed, second is true if limit just exceeded and client must be warned.
+func checkSoftLimit(value int, limit int, percent int, grace time.Duration, startedAt *int64, now time.Time) (bool, bool) {
+	if value <= limit {
+		if atomic.LoadInt64(startedAt) != 0 {
+			atomic.StoreInt64(startedAt, 0)
+		}
+		return true, false
+	}
+	if value > softLimit(limit, percent) {
+		return false, false
+	}
+	started := atomic.LoadInt64(startedAt)
+	if started == 0 {
+		if atomic.CompareAndSwapInt64(startedAt, 0, now.UnixNano()) {
+			return true, true
+		}
+		return true, false
+	}
+	if now.Sub(time.Unix(0, started)) > grace {
+		return false, false
+	}
+	return true, false
+}
+
+// queueAdviceWatermark is a percent of client queue max size queue must reach to
+// warn client that queue limit is close.
+const queueAdviceWatermark = 80
+
+// disconnectChannelLimit is a reason connection closed with when it stays
+// subscribed on more channels than allowed after grace period.
+const disconnectChannelLimit = "channel limit exceeded"
+
+// limitAdvice builds message warning client that limit exceeded and will be enforced
+// after grace period.
+func limitAdvice(limit string, value int, max int, grace time.Duration) []byte {
+	resp := newClientLimitAdviceResponse(limitAdviceBody{
+		Limit: limit,
+		Value: value,
+		Max:   max,
+		Grace: int64(grace.Seconds()),
+	})
+	byteMessage, err := json.Marshal(resp)
+	if err != nil {
+		logger.ERROR.Println(err)
+		return nil
+	}
+	return byteMessage
+}
+
+// checkChannelLimit checks that client can be subscribed on total number of channels.
+// When grace period started client warned and its channels checked again when grace
+// period ends. Must be called with client lock held.
+func (c *client) checkChannelLimit(total int) bool {
+	c.app.RLock()
+	channelLimit := c.app.config.ClientChannelLimit
+	percent := c.app.config.ClientChannelLimitSoft
+	grace := c.app.config.LimitGracePeriod
+	c.app.RUnlock()
+
+	allowed, warn := checkSoftLimit(total, channelLimit, percent, grace, &c.channelGrace, time.Now())
+	if !allowed {
+		logger.ERROR.Printf("maximimum limit of channels per client reached: %d", channelLimit)
+	}
+	if warn {
+		logger.INFO.Printf("client %s exceeded channel limit %d, grace period started", c.uid(), channelLimit)
+		if msg := limitAdvice(limitChannels, total, channelLimit, grace); msg != nil {
+			c.send(msg)
+		}
+		if c.channelGraceTimer != nil {
+			c.channelGraceTimer.Stop()
+		}
+		c.channelGraceTimer = time.AfterFunc(grace, c.enforceChannelLimit)
+	}
+	return allowed
+}
+
+// enforceChannelLimit closes connection which is still subscribed on more channels
+// than allowed when grace period ended.
+func (c *client) enforceChannelLimit() {
+	c.Lock()
+	defer c.Unlock()
+	select {
+	case <-c.closeChan:
+		return
+	default:
+	}
+	c.app.RLock()
+	channelLimit := c.app.config.ClientChannelLimit
+	c.app.RUnlock()
+	if len(c.Channels) <= channelLimit {
+		return
+	}
+	logger.INFO.Printf("client %s still exceeds channel limit %d after grace period", c.uid(), channelLimit)
+	c.close(disconnectChannelLimit, false)
+}
+
+// resetChannelLimit stops channel limit grace period if client is within limit again.
+// Must be called with client lock held.
+func (c *client) resetChannelLimit() {
+	c.app.RLock()
+	channelLimit := c.app.config.ClientChannelLimit
+	c.app.RUnlock()
+	if len(c.Channels) <= channelLimit {
+		atomic.StoreInt64(&c.channelGrace, 0)
+		if c.channelGraceTimer != nil {
+			c.channelGraceTimer.Stop()
+			c.channelGraceTimer = nil
+		}
+	}
+}
+
+// checkQueueAdvice warns client once queue size reaches watermark below queue max
+// size so client is warned before queue limit exceeded. Warning repeated after queue
+// size dropped below watermark.
+func (c *client) checkQueueAdvice(size int) {
+	if c.maxQueueSize <= 0 {
+		return
+	}
+	if size < c.maxQueueSize*queueAdviceWatermark/100 {
+		if atomic.LoadInt32(&c.queueWarned) != 0 {
+			atomic.StoreInt32(&c.queueWarned, 0)
+		}
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&c.queueWarned, 0, 1) {
+		return
+	}
+	var grace time.Duration
+	if c.queueLimitSoft > 0 {
+		grace = c.limitGrace
+	}
+	// advice added into queue directly to not check limit again.
+	if msg := limitAdvice(limitQueue, size, c.maxQueueSize, grace); msg != nil {
+		if msg, err := c.encodeFrame(msg); err == nil {
+			c.messages.Add(msg)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package libcentrifugo
+
+import (
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckSoftLimit(t *testing.T) {
+	var started int64
+	now := time.Now()
+
+	allowed, warn := checkSoftLimit(10, 10, 50, time.Minute, &started, now)
+	assert.True(t, allowed)
+	assert.False(t, warn)
+
+	allowed, warn = checkSoftLimit(12, 10, 50, time.Minute, &started, now)
+	assert.True(t, allowed)
+	assert.True(t, warn)
+
+	// warned only once during grace period.
+	allowed, warn = checkSoftLimit(15, 10, 50, time.Minute, &started, now.Add(time.Second))
+	assert.True(t, allowed)
+	assert.False(t, warn)
+
+	// soft limit never exceeded.
+	allowed, _ = checkSoftLimit(16, 10, 50, time.Minute, &started, now)
+	assert.False(t, allowed)
+
+	// grace period over.
+	allowed, _ = checkSoftLimit(12, 10, 50, time.Minute, &started, now.Add(2*time.Minute))
+	assert.False(t, allowed)
+
+	// value within hard limit again resets grace period.
+	allowed, _ = checkSoftLimit(9, 10, 50, time.Minute, &started, now.Add(2*time.Minute))
+	assert.True(t, allowed)
+	assert.Equal(t, int64(0), started)
+
+	// soft limit disabled.
+	allowed, warn = checkSoftLimit(11, 10, 0, time.Minute, &started, now)
+	assert.False(t, allowed)
+	assert.False(t, warn)
+}
+
+func TestClientChannelSoftLimit(t *testing.T) {
+	app := testApp()
+	app.config.ClientChannelLimit = 2
+	app.config.ClientChannelLimitSoft = 100
+	app.config.LimitGracePeriod = 50 * time.Millisecond
+	sink := make(chan []byte, 100)
+	sess := &testSession{sink: sink}
+	c, err := newClient(app, sess)
+	assert.Equal(t, nil, err)
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	err = c.handleCommands([]clientCommand{testConnectCmd(timestamp), testSubscribeCmd("a"), testSubscribeCmd("b")})
+	assert.Equal(t, nil, err)
+	// limit checked from timer so commands handled with client lock held.
+	handleCmd := func(cmd clientCommand) (response, error) {
+		c.Lock()
+		defer c.Unlock()
+		return c.handleCmd(cmd)
+	}
+
+	resp, err := handleCmd(testSubscribeCmd("c"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, resp.(*clientSubscribeResponse).err)
+	assert.NotEqual(t, int64(0), atomic.LoadInt64(&c.channelGrace))
+
+	// client recovers within grace period.
+	_, err = handleCmd(testUnsubscribeCmd("c"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(0), atomic.LoadInt64(&c.channelGrace))
+
+	resp, err = handleCmd(testSubscribeCmd("c"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, resp.(*clientSubscribeResponse).err)
+
+	// limit enforced after grace period for channels client already subscribed on.
+	assert.True(t, waitCondition(func() bool {
+		sess.Lock()
+		defer sess.Unlock()
+		return sess.closed
+	}))
+	sess.Lock()
+	assert.Contains(t, sess.reason, disconnectChannelLimit)
+	sess.Unlock()
+
+	var advices int
+	for len(sink) > 0 {
+		if strings.Contains(string(<-sink), `"method":"limit_advice"`) {
+			advices++
+		}
+	}
+	assert.Equal(t, 2, advices)
+}
+
+func TestClientQueueSoftLimit(t *testing.T) {
+	app := testApp()
+	app.config.ClientQueueMaxSize = 1000
+	app.config.ClientQueueMaxSizeSoft = 100
+	app.config.LimitGracePeriod = 50 * time.Millisecond
+	// messages are not sent from queue of client.
+	c := initClient(app, &testSession{})
+	msg := []byte(strings.Repeat("1", 400))
+
+	// client warned before queue limit exceeded.
+	assert.Equal(t, nil, c.send(msg))
+	assert.Equal(t, 1, c.messages.Len())
+	assert.Equal(t, nil, c.send(msg))
+	assert.Equal(t, int64(0), c.queueGrace)
+	assert.Equal(t, 3, c.messages.Len())
+	c.messages.Remove()
+	c.messages.Remove()
+	advice, _ := c.messages.Remove()
+	assert.True(t, strings.Contains(string(advice), `"limit":"queue"`))
+
+	// queue drained within grace period.
+	assert.Equal(t, nil, c.send(msg))
+	assert.Equal(t, nil, c.send(msg))
+	assert.Equal(t, nil, c.send(msg))
+	assert.NotEqual(t, int64(0), c.queueGrace)
+	for c.messages.Len() > 0 {
+		c.messages.Remove()
+	}
+	assert.Equal(t, nil, c.send([]byte("1")))
+	assert.Equal(t, int64(0), c.queueGrace)
+
+	// queue stays above hard limit after grace period.
+	assert.Equal(t, nil, c.send(msg))
+	assert.Equal(t, nil, c.send(msg))
+	assert.Equal(t, nil, c.send(msg))
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, ErrClientClosed, c.send([]byte("1")))
+}
+
+func TestClientQueueDropOldest(t *testing.T) {
+	app := testApp()
+	app.config.ClientQueueMaxSize = 10
+	app.config.ChannelOptions.SlowClientPolicy = slowClientPolicyDropOldest
+	// messages stay in queue as client created without sending goroutine.
+	c := initClient(app, &testSession{})
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	err := c.handleCommands([]clientCommand{testConnectCmd(timestamp), testSubscribeCmd("ticker")})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&c.queueDropOldest))
+	for c.messages.Len() > 0 {
+		c.messages.Remove()
+	}
+
+	assert.Equal(t, nil, c.sendPublication([]byte("1234567")))
+	assert.Equal(t, nil, c.sendPublication([]byte("12345678")))
+	assert.Equal(t, 1, c.messages.Len())
+	msg, _ := c.messages.Remove()
+	assert.Equal(t, "12345678", string(msg))
+	assert.Equal(t, int64(1), app.metrics.NumClientQueueDropped.LoadRaw())
+
+	// only publications dropped, other messages kept.
+	assert.Equal(t, nil, c.send([]byte("123")))
+	assert.Equal(t, nil, c.sendPublication([]byte("1234")))
+	assert.Equal(t, nil, c.sendPublication([]byte("12345")))
+	assert.Equal(t, 2, c.messages.Len())
+	msg, _ = c.messages.Remove()
+	assert.Equal(t, "123", string(msg))
+	msg, _ = c.messages.Remove()
+	assert.Equal(t, "12345", string(msg))
+	assert.Equal(t, int64(2), app.metrics.NumClientQueueDropped.LoadRaw())
+
+	// channel with default policy requires disconnect.
+	_, err = c.handleCmd(testSubscribeCmd("test:chat"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&c.queueDropOldest))
+	for c.messages.Len() > 0 {
+		c.messages.Remove()
+	}
+	assert.Equal(t, nil, c.sendPublication([]byte("12345678")))
+	assert.Equal(t, ErrClientClosed, c.sendPublication([]byte("12345678")))
+	assert.Equal(t, int64(1), app.metrics.NumClientSlow.LoadRaw())
+
+	// slow disconnect counted in namespaces of client channels.
+	assert.True(t, waitCondition(func() bool {
+		return app.clients.nClients() == 0
+	}))
+	namespaces := app.metrics.namespaces.values(true)
+	assert.Equal(t, int64(1), namespaces["test"].NumClientSlow)
+	assert.Equal(t, int64(1), namespaces[namespaceMetricsDefault].NumClientSlow)
+}
+
+func TestClientHubQueueStats(t *testing.T) {
+	app := testApp()
+	maxSize, p99, age := app.clients.queueStats()
+	assert.Equal(t, 0, maxSize)
+	assert.Equal(t, 0, p99)
+	assert.Equal(t, time.Duration(0), age)
+
+	var clients []*client
+	for i := 0; i < 3; i++ {
+		c := initClient(app, &testSession{})
+		c.User = UserID(strconv.Itoa(i))
+		assert.Equal(t, nil, app.clients.add(c))
+		clients = append(clients, c)
+	}
+	clients[0].messages.Add([]byte("1"))
+	clients[1].messages.Add([]byte("12"))
+	time.Sleep(10 * time.Millisecond)
+	clients[2].messages.Add([]byte("123"))
+	maxSize, p99, age = app.clients.queueStats()
+	assert.Equal(t, 3, maxSize)
+	assert.Equal(t, 2, p99)
+	assert.True(t, age >= 10*time.Millisecond)
+
+	app.updateMetricsOnce()
+	assert.Equal(t, int64(3), app.metrics.GetRawMetrics().ClientQueueSizeMax)
+}
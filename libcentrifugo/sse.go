@@ -0,0 +1,299 @@
+package libcentrifugo
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/FZambia/go-logger"
+)
+
+// sseLastEventIDParam is a query param with last received message uid. Used by
+// clients which can not set Last-Event-ID header on first connection.
+const sseLastEventIDParam = "last_event_id"
+
+// sseEvent is a single Server-Sent event written into response stream.
+type sseEvent struct {
+	ID    string
+	Event string
+	Data  []byte
+}
+
+// sseSession is a session implementation for read-only Server-Sent Events (EventSource)
+// connections. Every response sent to client becomes an event named after response
+// method, channel messages use message uid as event id so browser sends it back in
+// Last-Event-ID header on reconnect.
+type sseSession struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+	closed  bool
+	closeCh chan struct{}
+}
+
+func newSSESession(w http.ResponseWriter, flusher http.Flusher) *sseSession {
+	return &sseSession{
+		w:       w,
+		flusher: flusher,
+		closeCh: make(chan struct{}),
+	}
+}
+
+func (sess *sseSession) Send(message []byte) error {
+	events, err := sseEvents(message)
+	if err != nil {
+		return err
+	}
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.closed {
+		return nil
+	}
+	return sess.write(events...)
+}
+
+// Close sends disconnect event with reason and stops session.
+func (sess *sseSession) Close(status uint32, reason string) error {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.closed {
+		return nil
+	}
+	sess.write(sseEvent{Event: "disconnect", Data: []byte(reason)})
+	sess.closed = true
+	close(sess.closeCh)
+	return nil
+}
+
+// ping writes comment line to keep connection alive through proxies.
+func (sess *sseSession) ping() error {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.closed {
+		return nil
+	}
+	if _, err := fmt.Fprint(sess.w, ": ping\n\n"); err != nil {
+		return err
+	}
+	sess.flusher.Flush()
+	return nil
+}
+
+// stop marks session closed without writing anything so nothing written into
+// response after handler returned.
+func (sess *sseSession) stop() {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.closed {
+		return
+	}
+	sess.closed = true
+	close(sess.closeCh)
+}
+
+// write writes events into response and flushes it. Must be called with session
+// lock held.
+func (sess *sseSession) write(events ...sseEvent) error {
+	for _, event := range events {
+		if event.ID != "" {
+			if _, err := fmt.Fprintf(sess.w, "id: %s\n", event.ID); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(sess.w, "event: %s\n", event.Event); err != nil {
+			return err
+		}
+		for _, line := range strings.Split(string(event.Data), "\n") {
+			if _, err := fmt.Fprintf(sess.w, "data: %s\n", line); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprint(sess.w, "\n"); err != nil {
+			return err
+		}
+	}
+	sess.flusher.Flush()
+	return nil
+}
+
+// sseResponse contains fields of client response needed to build events.
+type sseResponse struct {
+	Method string          `json:"method"`
+	Body   json.RawMessage `json:"body"`
+}
+
+// sseEvents converts client response (single or batch) into events. Messages
+// recovered in subscribe response also sent as separate message events (oldest
+// first) so event id points to last message client received.
+func sseEvents(message []byte) ([]sseEvent, error) {
+	var responses []json.RawMessage
+	if len(message) > 0 && message[0] == arrayJSONPrefix {
+		if err := json.Unmarshal(message, &responses); err != nil {
+			return nil, err
+		}
+	} else {
+		responses = []json.RawMessage{message}
+	}
+	events := make([]sseEvent, 0, len(responses))
+	for _, data := range responses {
+		var resp sseResponse
+		if err := json.Unmarshal(data, &resp); err != nil {
+			return nil, err
+		}
+		event := sseEvent{Event: resp.Method, Data: data}
+		switch resp.Method {
+		case "message":
+			var msg Message
+			if err := json.Unmarshal(resp.Body, &msg); err != nil {
+				return nil, err
+			}
+			event.ID = msg.UID
+			events = append(events, event)
+		case "subscribe":
+			events = append(events, event)
+			var body subscribeBody
+			if len(resp.Body) == 0 || json.Unmarshal(resp.Body, &body) != nil || !body.Recovered {
+				continue
+			}
+			for i := len(body.Messages) - 1; i >= 0; i-- {
+				msgResp := newClientMessage()
+				msgResp.Body = body.Messages[i]
+				msgData, err := json.Marshal(msgResp)
+				if err != nil {
+					return nil, err
+				}
+				events = append(events, sseEvent{ID: body.Messages[i].UID, Event: "message", Data: msgData})
+			}
+		default:
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// sseCommands builds connect and subscribe commands from SSE request query. Client
+// passes connection credentials (user, timestamp, info, exp, token) and channels
+// to subscribe as repeated channel params. Subscriptions recover messages after
+// Last-Event-ID when it is set.
+func sseCommands(r *http.Request) ([]clientCommand, error) {
+	query := r.URL.Query()
+	connect := connectClientCommand{
+		User:      UserID(query.Get("user")),
+		Timestamp: query.Get("timestamp"),
+		Info:      query.Get("info"),
+		Token:     query.Get("token"),
+		Exp:       query.Get("exp"),
+	}
+	params, err := json.Marshal(connect)
+	if err != nil {
+		return nil, err
+	}
+	commands := []clientCommand{{Method: "connect", Params: params}}
+
+	last := r.Header.Get("Last-Event-ID")
+	if last == "" {
+		last = query.Get(sseLastEventIDParam)
+	}
+	for _, ch := range query["channel"] {
+		subscribe := subscribeClientCommand{
+			Channel: Channel(ch),
+			Recover: last != "",
+			Last:    MessageID(last),
+		}
+		params, err := json.Marshal(subscribe)
+		if err != nil {
+			return nil, err
+		}
+		commands = append(commands, clientCommand{Method: "subscribe", Params: params})
+	}
+	return commands, nil
+}
+
+// SSEHandler handles read-only Server-Sent Events (EventSource) connections. Client
+// authenticates and subscribes using request query params, after that it only
+// receives messages – connection does not accept other commands. As client uid not
+// known in advance private channels can only be authorized by subscribe proxy.
+func (app *Application) SSEHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	commands, err := sseCommands(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	msg, err := json.Marshal(commands)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !app.acquireConnection() {
+		http.Error(w, errTooManyClients, http.StatusServiceUnavailable)
+		return
+	}
+	defer app.clients.release()
+
+	app.RLock()
+	pingInterval := app.config.PingInterval
+	app.RUnlock()
+
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	if originExplicitlyAllowed(r, app.allowedOrigins(false)) {
+		// allow EventSource with credentials only from explicitly configured origins.
+		header.Set("Access-Control-Allow-Origin", r.Header.Get("Origin"))
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	sess := newSSESession(w, flusher)
+	defer sess.stop()
+
+	c, err := newClient(app, sess)
+	if err != nil {
+		return
+	}
+	c.transportName = "sse"
+	c.remoteAddr = app.requestAddr(r)
+	c.headers = app.connectProxyHeaders(r)
+	logger.DEBUG.Printf("New SSE session established with uid %s\n", c.uid())
+	defer c.teardown("connection closed")
+
+	err = c.message(msg)
+	if err != nil {
+		sess.Close(CloseStatus, disconnectReason(err.Error(), shouldReconnect(err)))
+		return
+	}
+
+	var ticker *time.Ticker
+	var tick <-chan time.Time
+	if pingInterval > 0 {
+		ticker = time.NewTicker(pingInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-sess.closeCh:
+			return
+		case <-tick:
+			if err := sess.ping(); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package libcentrifugo
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testSSEApp() *Application {
+	c := newTestConfig()
+	c.Insecure = true
+	c.ChannelOptions.Recover = true
+	c.ChannelOptions.HistorySize = 10
+	c.ChannelOptions.HistoryLifetime = 60
+	app := testMemoryAppWithConfig(&c)
+	return app
+}
+
+// sseLines reads stream lines in background.
+func sseLines(body io.Reader) <-chan string {
+	lines := make(chan string)
+	reader := bufio.NewReader(body)
+	go func() {
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				close(lines)
+				return
+			}
+			lines <- strings.TrimRight(line, "\n")
+		}
+	}()
+	return lines
+}
+
+// readSSEEvents reads events from stream lines until n events received.
+func readSSEEvents(t *testing.T, lines <-chan string, n int) []sseEvent {
+	var events []sseEvent
+	var event sseEvent
+	for len(events) < n {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatal("stream closed")
+			}
+			switch {
+			case line == "":
+				if event.Event != "" {
+					events = append(events, event)
+				}
+				event = sseEvent{}
+			case strings.HasPrefix(line, "id: "):
+				event.ID = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				event.Event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				event.Data = append(event.Data, strings.TrimPrefix(line, "data: ")...)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("timeout waiting for SSE events")
+		}
+	}
+	return events
+}
+
+func TestSSEEvents(t *testing.T) {
+	events, err := sseEvents([]byte(`{"method":"message","body":{"uid":"1","channel":"test","data":{}}}`))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, "1", events[0].ID)
+	assert.Equal(t, "message", events[0].Event)
+
+	events, err = sseEvents([]byte(`[{"method":"connect","body":{}},{"method":"subscribe","body":{"channel":"test","status":true,"recovered":true,"messages":[{"uid":"3"},{"uid":"2"}]}}]`))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 4, len(events))
+	assert.Equal(t, "connect", events[0].Event)
+	assert.Equal(t, "subscribe", events[1].Event)
+	assert.Equal(t, "2", events[2].ID)
+	assert.Equal(t, "3", events[3].ID)
+	assert.Equal(t, "message", events[3].Event)
+}
+
+func TestSSEHandler(t *testing.T) {
+	app := testSSEApp()
+	server := httptest.NewServer(http.HandlerFunc(app.SSEHandler))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "?user=user1&channel=test")
+	assert.Equal(t, nil, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	lines := sseLines(resp.Body)
+
+	events := readSSEEvents(t, lines, 2)
+	assert.Equal(t, "connect", events[0].Event)
+	assert.Equal(t, "subscribe", events[1].Event)
+
+	assert.True(t, waitCondition(func() bool {
+		return app.clients.nClients() == 1
+	}))
+	err = app.Publish("test", []byte(`{"n":1}`), "", nil)
+	assert.Equal(t, nil, err)
+	err = app.Publish("test", []byte(`{"n":2}`), "", nil)
+	assert.Equal(t, nil, err)
+	events = readSSEEvents(t, lines, 2)
+	assert.Equal(t, "message", events[0].Event)
+	assert.True(t, events[0].ID != "")
+	assert.True(t, strings.Contains(string(events[1].Data), `{"n":2}`))
+	first := events[0].ID
+	resp.Body.Close()
+
+	assert.True(t, waitCondition(func() bool {
+		return app.clients.nClients() == 0
+	}))
+
+	// reconnect with Last-Event-ID recovers missed message.
+	req, _ := http.NewRequest("GET", server.URL+"?user=user1&channel=test", nil)
+	req.Header.Set("Last-Event-ID", first)
+	resp, err = http.DefaultClient.Do(req)
+	assert.Equal(t, nil, err)
+	defer resp.Body.Close()
+	events = readSSEEvents(t, sseLines(resp.Body), 3)
+	assert.Equal(t, "subscribe", events[1].Event)
+	assert.Equal(t, "message", events[2].Event)
+	assert.True(t, events[2].ID != first)
+	assert.True(t, strings.Contains(string(events[2].Data), `{"n":2}`))
+}
+
+func TestSSEHandlerUnauthorized(t *testing.T) {
+	app := testSSEApp()
+	app.config.Insecure = false
+	server := httptest.NewServer(http.HandlerFunc(app.SSEHandler))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "?user=user1&timestamp=1&token=invalid&channel=test")
+	assert.Equal(t, nil, err)
+	defer resp.Body.Close()
+	events := readSSEEvents(t, sseLines(resp.Body), 1)
+	assert.Equal(t, "disconnect", events[0].Event)
+}
+
+func TestSSEHandlerOrigin(t *testing.T) {
+	app := testSSEApp()
+	server := httptest.NewServer(http.HandlerFunc(app.SSEHandler))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL+"?user=user1&channel=test", nil)
+	req.Header.Set("Origin", "https://evil.org")
+	resp, err := http.DefaultClient.Do(req)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "", resp.Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "", resp.Header.Get("Access-Control-Allow-Credentials"))
+	resp.Body.Close()
+
+	app.Lock()
+	app.config.AllowedOrigins = []string{"https://example.com"}
+	app.Unlock()
+	req.Header.Set("Origin", "https://example.com")
+	resp, err = http.DefaultClient.Do(req)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "https://example.com", resp.Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", resp.Header.Get("Access-Control-Allow-Credentials"))
+	resp.Body.Close()
+}
+
+func TestSSEHandlerRemoteAddr(t *testing.T) {
+	app := testSSEApp()
+	server := httptest.NewServer(http.HandlerFunc(app.SSEHandler))
+	defer server.Close()
+
+	// forwarded headers of untrusted client are ignored.
+	req, _ := http.NewRequest("GET", server.URL+"?user=user1&channel=test", nil)
+	req.Header.Set("X-Real-IP", "10.0.0.2")
+	req.Header.Set("X-Forwarded-For", "10.0.0.2")
+	resp, err := http.DefaultClient.Do(req)
+	assert.Equal(t, nil, err)
+	defer resp.Body.Close()
+	readSSEEvents(t, sseLines(resp.Body), 2)
+
+	conns := app.clients.userConnections("user1")
+	assert.Equal(t, 1, len(conns))
+	for _, c := range conns {
+		assert.Equal(t, "127.0.0.1", c.(*client).remoteAddr)
+	}
+}
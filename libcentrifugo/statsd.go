@@ -0,0 +1,211 @@
+package libcentrifugo
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/FZambia/go-logger"
+)
+
+// Formats of metrics sent to statsd_addr.
+const (
+	statsdFormatStatsd    = "statsd"
+	statsdFormatDogStatsd = "dogstatsd"
+)
+
+// statsdMaxPacketSize is a max size of UDP packet with metrics, fits into common
+// network MTU so packets are not fragmented.
+const statsdMaxPacketSize = 1432
+
+// statsdWriteTimeout is a max time to write packet into UDP socket.
+const statsdWriteTimeout = time.Second
+
+// statsdMetric is a single counter or gauge value sent to statsd.
+type statsdMetric struct {
+	name  string
+	value int64
+	// kind is c for counters (values over last metrics interval) and g for gauges.
+	kind string
+	// namespace is a name of channel namespace for namespace metrics, empty for
+	// channels without namespace.
+	namespace string
+	// namespaced is true for metrics of channel namespace.
+	namespaced bool
+}
+
+// statsdExporter sends node metrics to statsd or DogStatsD agent over UDP. Metrics
+// sent from its own goroutine, flush never blocks caller – if previous flush not
+// finished yet new metrics dropped.
+type statsdExporter struct {
+	addr   string
+	prefix string
+	format string
+	tags   []string
+	conn   net.Conn
+	queue  chan []statsdMetric
+}
+
+// newStatsdExporter creates exporter and starts its writer. Address resolved on
+// first flush so exporter works even if agent is not resolvable on start.
+func newStatsdExporter(addr, prefix, format string, tags []string) *statsdExporter {
+	e := &statsdExporter{
+		addr:   addr,
+		prefix: prefix,
+		format: format,
+		tags:   tags,
+		queue:  make(chan []statsdMetric, 1),
+	}
+	go e.run()
+	return e
+}
+
+// flush puts metrics into writer queue without blocking.
+func (e *statsdExporter) flush(metrics []statsdMetric) {
+	select {
+	case e.queue <- metrics:
+	default:
+		logger.WARN.Printf("statsd export to %s not finished in metrics interval, metrics dropped", e.addr)
+	}
+}
+
+func (e *statsdExporter) run() {
+	for metrics := range e.queue {
+		if err := e.send(metrics); err != nil {
+			logger.WARN.Printf("error sending metrics to statsd %s: %v", e.addr, err)
+		}
+	}
+}
+
+func (e *statsdExporter) send(metrics []statsdMetric) error {
+	if e.conn == nil {
+		conn, err := net.Dial("udp", e.addr)
+		if err != nil {
+			return err
+		}
+		e.conn = conn
+	}
+	for _, packet := range e.packets(metrics) {
+		e.conn.SetWriteDeadline(time.Now().Add(statsdWriteTimeout))
+		if _, err := e.conn.Write(packet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// line formats metric in statsd or DogStatsD format. Plain statsd has no tags so
+// tags are only sent in DogStatsD format, namespace becomes part of metric name.
+func (e *statsdExporter) line(m statsdMetric) string {
+	name := m.name
+	tags := e.tags
+	if m.namespaced {
+		if e.format == statsdFormatDogStatsd {
+			tags = append(tags[:len(tags):len(tags)], "namespace:"+m.namespace)
+		} else if m.namespace != "" {
+			name = "namespaces." + m.namespace + "." + name
+		} else {
+			name = "namespaces." + name
+		}
+	}
+	if e.prefix != "" {
+		name = e.prefix + "." + name
+	}
+	line := name + ":" + strconv.FormatInt(m.value, 10) + "|" + m.kind
+	if e.format == statsdFormatDogStatsd && len(tags) > 0 {
+		line += "|#" + strings.Join(tags, ",")
+	}
+	return line
+}
+
+// packets splits metric lines into packets not exceeding statsdMaxPacketSize.
+func (e *statsdExporter) packets(metrics []statsdMetric) [][]byte {
+	var packets [][]byte
+	var buf bytes.Buffer
+	for _, m := range metrics {
+		line := e.line(m)
+		if buf.Len() > 0 && buf.Len()+1+len(line) > statsdMaxPacketSize {
+			packets = append(packets, append([]byte(nil), buf.Bytes()...))
+			buf.Reset()
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(line)
+	}
+	if buf.Len() > 0 {
+		packets = append(packets, buf.Bytes())
+	}
+	return packets
+}
+
+// statsdTags returns tags sent with every metric in DogStatsD format: node name
+// and statsd_tags from config.
+func statsdTags(c *Config) []string {
+	tags := []string{"node:" + c.Name}
+	return append(tags, c.StatsdTags...)
+}
+
+// statsdPrefix returns prefix of metric names. Plain statsd has no tags so node
+// name becomes part of metric name.
+func statsdPrefix(c *Config) string {
+	if c.StatsdFormat == statsdFormatDogStatsd {
+		return c.StatsdPrefix
+	}
+	node := strings.Replace(c.Name, ".", "_", -1)
+	if c.StatsdPrefix == "" {
+		return node
+	}
+	return c.StatsdPrefix + "." + node
+}
+
+// statsdMetrics collects node metrics exported to statsd. Counters are values over
+// last metrics interval so must be called after metrics snapshot updated.
+func (app *Application) statsdMetrics() []statsdMetric {
+	snapshot := app.metrics.GetSnapshotMetrics()
+	metrics := []statsdMetric{
+		{"num_connections", app.clients.connections(), "g", "", false},
+		{"num_clients", int64(app.clients.nClients()), "g", "", false},
+		{"num_unique_clients", int64(app.clients.nUniqueClients()), "g", "", false},
+		{"num_channels", int64(app.clients.nChannels()), "g", "", false},
+		{"memory_sys", snapshot.MemSys, "g", "", false},
+		{"client_queue_size_max", snapshot.ClientQueueSizeMax, "g", "", false},
+		{"client_queue_size_p99", snapshot.ClientQueueSizeP99, "g", "", false},
+		{"client_queue_age_max", snapshot.ClientQueueAgeMax, "g", "", false},
+		{"kafka_lag", snapshot.KafkaLag, "g", "", false},
+		{"num_msg_published", snapshot.NumMsgPublished, "c", "", false},
+		{"num_msg_queued", snapshot.NumMsgQueued, "c", "", false},
+		{"num_msg_sent", snapshot.NumMsgSent, "c", "", false},
+		{"num_api_requests", snapshot.NumAPIRequests, "c", "", false},
+		{"num_client_requests", snapshot.NumClientRequests, "c", "", false},
+		{"num_client_disconnects", snapshot.NumClientDisconnects, "c", "", false},
+		{"num_engine_errors", snapshot.NumEngineErrors, "c", "", false},
+		{"num_client_slow", snapshot.NumClientSlow, "c", "", false},
+		{"num_client_queue_dropped", snapshot.NumClientQueueDropped, "c", "", false},
+		{"num_presence_cache_hits", snapshot.NumPresenceCacheHits, "c", "", false},
+		{"num_presence_cache_misses", snapshot.NumPresenceCacheMisses, "c", "", false},
+		{"num_kafka_published", snapshot.NumKafkaPublished, "c", "", false},
+		{"num_kafka_skipped", snapshot.NumKafkaSkipped, "c", "", false},
+	}
+	for name, ns := range snapshot.Namespaces {
+		metrics = append(metrics,
+			statsdMetric{"num_msg_published", ns.NumMsgPublished, "c", name, true},
+			statsdMetric{"num_msg_delivered", ns.NumMsgDelivered, "c", name, true},
+			statsdMetric{"bytes_delivered", ns.BytesDelivered, "c", name, true},
+			statsdMetric{"num_join_msg", ns.NumJoinMsg, "c", name, true},
+			statsdMetric{"num_leave_msg", ns.NumLeaveMsg, "c", name, true},
+			statsdMetric{"num_client_slow", ns.NumClientSlow, "c", name, true},
+		)
+	}
+	return metrics
+}
+
+// exportStatsd sends metrics to statsd if statsd_addr configured.
+func (app *Application) exportStatsd() {
+	if app.statsd == nil {
+		return
+	}
+	app.statsd.flush(app.statsdMetrics())
+}
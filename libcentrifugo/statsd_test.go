@@ -0,0 +1,78 @@
+package libcentrifugo
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatsdLine(t *testing.T) {
+	e := &statsdExporter{prefix: "centrifugo.node1", format: statsdFormatStatsd, tags: []string{"node:node1"}}
+	assert.Equal(t, "centrifugo.node1.num_msg_sent:10|c", e.line(statsdMetric{"num_msg_sent", 10, "c", "", false}))
+
+	e = &statsdExporter{prefix: "centrifugo", format: statsdFormatDogStatsd, tags: []string{"node:node1", "env:prod"}}
+	assert.Equal(t, "centrifugo.num_clients:3|g|#node:node1,env:prod", e.line(statsdMetric{"num_clients", 3, "g", "", false}))
+	assert.Equal(t, "centrifugo.num_msg_published:1|c|#node:node1,env:prod,namespace:news", e.line(statsdMetric{"num_msg_published", 1, "c", "news", true}))
+	assert.Equal(t, []string{"node:node1", "env:prod"}, e.tags)
+
+	e = &statsdExporter{prefix: "centrifugo.node1", format: statsdFormatStatsd}
+	assert.Equal(t, "centrifugo.node1.namespaces.news.num_msg_published:1|c", e.line(statsdMetric{"num_msg_published", 1, "c", "news", true}))
+	// channels without namespace.
+	assert.Equal(t, "centrifugo.node1.namespaces.num_msg_published:1|c", e.line(statsdMetric{"num_msg_published", 1, "c", "", true}))
+}
+
+func TestStatsdPrefix(t *testing.T) {
+	c := newTestConfig()
+	c.Name = "host.example"
+	c.StatsdPrefix = "centrifugo"
+	c.StatsdFormat = statsdFormatStatsd
+	assert.Equal(t, "centrifugo.host_example", statsdPrefix(&c))
+	c.StatsdFormat = statsdFormatDogStatsd
+	assert.Equal(t, "centrifugo", statsdPrefix(&c))
+	assert.Equal(t, []string{"node:host.example"}, statsdTags(&c))
+}
+
+func TestStatsdPackets(t *testing.T) {
+	e := &statsdExporter{prefix: "centrifugo", format: statsdFormatStatsd}
+	var metrics []statsdMetric
+	for i := 0; i < 200; i++ {
+		metrics = append(metrics, statsdMetric{"num_msg_sent", int64(i), "c", "", false})
+	}
+	packets := e.packets(metrics)
+	assert.True(t, len(packets) > 1)
+	var lines int
+	for _, packet := range packets {
+		assert.True(t, len(packet) <= statsdMaxPacketSize)
+		lines += len(strings.Split(string(packet), "\n"))
+	}
+	assert.Equal(t, 200, lines)
+}
+
+func TestStatsdExport(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	defer conn.Close()
+
+	c := newTestConfig()
+	c.StatsdAddr = conn.LocalAddr().String()
+	c.StatsdFormat = statsdFormatDogStatsd
+	app := testMemoryAppWithConfig(&c)
+	app.statsd = newStatsdExporter(c.StatsdAddr, statsdPrefix(&c), c.StatsdFormat, statsdTags(&c))
+	app.metrics.NumMsgPublished.Add(5)
+	app.metrics.UpdateSnapshot()
+	app.exportStatsd()
+
+	buf := make([]byte, statsdMaxPacketSize)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	assert.Equal(t, nil, err)
+	assert.True(t, strings.Contains(string(buf[:n]), "centrifugo.num_msg_published:5|c|#node:"+c.Name))
+}
+
+func TestStatsdUnresolvedAddr(t *testing.T) {
+	e := newStatsdExporter("unknown.invalid:8125", "centrifugo", statsdFormatStatsd, nil)
+	assert.NotEqual(t, nil, e.send([]statsdMetric{{"num_clients", 1, "g", "", false}}))
+}
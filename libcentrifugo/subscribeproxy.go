@@ -0,0 +1,140 @@
+package libcentrifugo
+
+import (
+	"container/list"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// subscribeProxyRequest is a body of request sent to subscribe_proxy_endpoint.
+type subscribeProxyRequest struct {
+	Client     ConnID  `json:"client"`
+	User       UserID  `json:"user"`
+	Channel    Channel `json:"channel"`
+	Transport  string  `json:"transport"`
+	RemoteAddr string  `json:"remote_addr"`
+}
+
+// subscribeProxyReply is a body of subscribe_proxy_endpoint response. Backend
+// grants access to channel optionally providing channel info attached to presence
+// and join/leave messages or denies access with error.
+type subscribeProxyReply struct {
+	Info  json.RawMessage `json:"info,omitempty"`
+	Error *proxyError     `json:"error,omitempty"`
+}
+
+// subscribeProxyCacheKey identifies subscribe proxy reply in cache, reply does
+// not depend on connection so it is shared by all connections of user.
+type subscribeProxyCacheKey struct {
+	user    UserID
+	channel Channel
+}
+
+type subscribeProxyCacheEntry struct {
+	key     subscribeProxyCacheKey
+	reply   subscribeProxyReply
+	expires time.Time
+}
+
+// subscribeProxyCache keeps subscribe proxy replies for a short time so clients
+// reconnecting at once do not hammer backend. Cache bounded using LRU eviction.
+type subscribeProxyCache struct {
+	sync.Mutex
+	ll    *list.List
+	items map[subscribeProxyCacheKey]*list.Element
+}
+
+func newSubscribeProxyCache() *subscribeProxyCache {
+	return &subscribeProxyCache{
+		ll:    list.New(),
+		items: make(map[subscribeProxyCacheKey]*list.Element),
+	}
+}
+
+// get returns cached reply if it has not expired yet.
+func (c *subscribeProxyCache) get(key subscribeProxyCacheKey, now time.Time) (subscribeProxyReply, bool) {
+	c.Lock()
+	defer c.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return subscribeProxyReply{}, false
+	}
+	entry := el.Value.(*subscribeProxyCacheEntry)
+	if !now.Before(entry.expires) {
+		c.ll.Remove(el)
+		delete(c.items, key)
+		return subscribeProxyReply{}, false
+	}
+	c.ll.MoveToFront(el)
+	return entry.reply, true
+}
+
+// set caches reply for ttl keeping at most size replies.
+func (c *subscribeProxyCache) set(key subscribeProxyCacheKey, reply subscribeProxyReply, ttl time.Duration, size int, now time.Time) {
+	c.Lock()
+	defer c.Unlock()
+	if el, ok := c.items[key]; ok {
+		entry := el.Value.(*subscribeProxyCacheEntry)
+		entry.reply = reply
+		entry.expires = now.Add(ttl)
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&subscribeProxyCacheEntry{key: key, reply: reply, expires: now.Add(ttl)})
+	for c.ll.Len() > size {
+		el := c.ll.Back()
+		c.ll.Remove(el)
+		delete(c.items, el.Value.(*subscribeProxyCacheEntry).key)
+	}
+}
+
+// subscribeProxyRequest returns request to subscribe proxy endpoint. Must be
+// called with client lock held.
+func (c *client) subscribeProxyRequest(channel Channel) subscribeProxyRequest {
+	return subscribeProxyRequest{
+		Client:     c.UID,
+		User:       c.User,
+		Channel:    channel,
+		Transport:  c.transportName,
+		RemoteAddr: c.remoteAddr,
+	}
+}
+
+// subscribeProxy asks subscribe proxy endpoint whether client can subscribe on
+// private channel. Replies cached per user and channel if cache enabled, replies
+// for anonymous connections never cached as they can not be told apart.
+func (app *Application) subscribeProxy(req subscribeProxyRequest) (*subscribeProxyReply, error) {
+	app.RLock()
+	endpoint := app.config.SubscribeProxyEndpoint
+	timeout := app.config.SubscribeProxyTimeout
+	retries := app.config.SubscribeProxyRetries
+	cacheTTL := app.config.SubscribeProxyCacheTTL
+	cacheSize := app.config.SubscribeProxyCacheSize
+	app.RUnlock()
+
+	useCache := cacheTTL > 0 && cacheSize > 0 && req.User != ""
+	key := subscribeProxyCacheKey{user: req.User, channel: req.Channel}
+	if useCache {
+		if reply, ok := app.subscribeProxyCache.get(key, time.Now()); ok {
+			return &reply, nil
+		}
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	started := time.Now()
+	var reply subscribeProxyReply
+	err = requestProxy(endpoint, timeout, retries, data, &reply)
+	app.metrics.histograms.RecordMicroseconds("subscribe_proxy", time.Now().Sub(started))
+	if err != nil {
+		return nil, err
+	}
+	if useCache {
+		app.subscribeProxyCache.set(key, reply, cacheTTL, cacheSize, time.Now())
+	}
+	return &reply, nil
+}
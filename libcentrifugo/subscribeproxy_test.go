@@ -0,0 +1,156 @@
+package libcentrifugo
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testSubscribeProxyClient(t *testing.T, app *Application, user UserID) *client {
+	c, err := newClient(app, &testSession{})
+	assert.Equal(t, nil, err)
+	resp, err := c.connectCmd(&connectClientCommand{User: user})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, resp.(*clientConnectResponse).err)
+	return c
+}
+
+// subscribeProxyCmd handles subscribe command with client lock held as handler
+// releases it while subscribe proxy requested.
+func subscribeProxyCmd(c *client, cmd *subscribeClientCommand) (response, error) {
+	c.Lock()
+	defer c.Unlock()
+	return c.subscribeCmd(cmd)
+}
+
+func TestSubscribeProxy(t *testing.T) {
+	var requests int32
+	var req subscribeProxyRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Channel == "$denied" {
+			w.Write([]byte(`{"error": {"code": 403, "message": "forbidden"}}`))
+			return
+		}
+		w.Write([]byte(`{"info": {"role": "admin"}}`))
+	}))
+	defer server.Close()
+
+	app := testMemoryApp()
+	app.config.Insecure = true
+	app.config.SubscribeProxyEndpoint = server.URL
+	app.config.SubscribeProxyCacheTTL = time.Minute
+
+	c := testSubscribeProxyClient(t, app, "user1")
+	resp, err := subscribeProxyCmd(c, &subscribeClientCommand{Channel: "$private"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, resp.(*clientSubscribeResponse).err)
+	assert.Equal(t, `{"role": "admin"}`, string(c.channelInfo["$private"]))
+	assert.Equal(t, UserID("user1"), req.User)
+	assert.Equal(t, c.UID, req.Client)
+
+	// reply cached for another connection of the same user.
+	other := testSubscribeProxyClient(t, app, "user1")
+	resp, err = subscribeProxyCmd(other, &subscribeClientCommand{Channel: "$private"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, resp.(*clientSubscribeResponse).err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	// replies for anonymous connections not shared.
+	anon := testSubscribeProxyClient(t, app, "")
+	resp, err = subscribeProxyCmd(anon, &subscribeClientCommand{Channel: "$private"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, resp.(*clientSubscribeResponse).err)
+	anon = testSubscribeProxyClient(t, app, "")
+	resp, err = subscribeProxyCmd(anon, &subscribeClientCommand{Channel: "$private"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, resp.(*clientSubscribeResponse).err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+
+	resp, err = subscribeProxyCmd(c, &subscribeClientCommand{Channel: "$denied"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "forbidden", resp.(*clientSubscribeResponse).err.Error())
+	assert.Equal(t, 403, resp.(*clientSubscribeResponse).Code)
+
+	// public channels do not go through proxy.
+	resp, err = subscribeProxyCmd(c, &subscribeClientCommand{Channel: "public"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, resp.(*clientSubscribeResponse).err)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&requests))
+}
+
+func TestSubscribeProxyUnavailable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	app := testMemoryApp()
+	app.config.Insecure = true
+	app.config.SubscribeProxyEndpoint = server.URL
+	app.config.SubscribeProxyRetries = 0
+
+	c := testSubscribeProxyClient(t, app, "user1")
+	resp, err := subscribeProxyCmd(c, &subscribeClientCommand{Channel: "$private"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, ErrInternalServerError, resp.(*clientSubscribeResponse).err)
+	assert.Equal(t, 0, len(c.channels()))
+	assert.Equal(t, int64(1), app.metrics.NumSubscribeProxyErrors.LoadRaw())
+}
+
+func TestSubscribeProxyUnlocked(t *testing.T) {
+	var c *client
+	var locked int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// proxy requested without client lock held.
+		acquired := make(chan struct{})
+		go func() {
+			c.Lock()
+			c.Unlock()
+			close(acquired)
+		}()
+		select {
+		case <-acquired:
+		case <-time.After(time.Second):
+			atomic.StoreInt32(&locked, 1)
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	app := testMemoryApp()
+	app.config.Insecure = true
+	app.config.SubscribeProxyEndpoint = server.URL
+
+	c = testSubscribeProxyClient(t, app, "user1")
+	resp, err := subscribeProxyCmd(c, &subscribeClientCommand{Channel: "$private"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, resp.(*clientSubscribeResponse).err)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&locked))
+	assert.Equal(t, 1, len(c.channels()))
+
+	// connection closed, proxy reply must not be applied.
+	c.teardown("test")
+	resp, err = subscribeProxyCmd(c, &subscribeClientCommand{Channel: "$other"})
+	assert.Equal(t, ErrClientClosed, err)
+}
+
+func TestSubscribeProxyCache(t *testing.T) {
+	cache := newSubscribeProxyCache()
+	now := time.Now()
+	for _, ch := range []Channel{"$1", "$2", "$3"} {
+		cache.set(subscribeProxyCacheKey{"user", ch}, subscribeProxyReply{}, time.Second, 2, now)
+	}
+	_, ok := cache.get(subscribeProxyCacheKey{"user", "$1"}, now)
+	assert.False(t, ok)
+	_, ok = cache.get(subscribeProxyCacheKey{"user", "$3"}, now)
+	assert.True(t, ok)
+	_, ok = cache.get(subscribeProxyCacheKey{"user", "$3"}, now.Add(time.Second))
+	assert.False(t, ok)
+}
@@ -0,0 +1,468 @@
+package libcentrifugo
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	mathrand "math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/FZambia/go-logger"
+	"github.com/gogo/protobuf/proto"
+)
+
+// Protocols of exporting spans to tracing_endpoint – OTLP over HTTP with protobuf
+// or JSON encoded body.
+const (
+	tracingProtocolProtobuf = "http/protobuf"
+	tracingProtocolJSON     = "http/json"
+)
+
+const (
+	// tracingServiceName is a service.name resource attribute of exported spans.
+	tracingServiceName = "centrifugo"
+	// tracingQueueSize is a max number of finished spans waiting for export. Spans
+	// dropped when exporter can not keep up.
+	tracingQueueSize = 4096
+	// tracingBatchSize is a max number of spans exported in one request.
+	tracingBatchSize = 512
+	// tracingFlushInterval is a max time finished span waits for export.
+	tracingFlushInterval = 5 * time.Second
+	// tracingExportTimeout is a max time of export request.
+	tracingExportTimeout = 10 * time.Second
+	// traceparentHeader is a W3C Trace Context header with parent span.
+	traceparentHeader = "traceparent"
+)
+
+// Span kinds and status codes as defined by OTLP.
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+	spanStatusError  = 2
+)
+
+// spanAttr is a string attribute of span or resource.
+type spanAttr struct {
+	key   string
+	value string
+}
+
+// span is a single timed operation of trace. Methods of nil span do nothing so
+// code paths traced without checking whether tracing enabled and request sampled.
+type span struct {
+	tracer  *tracer
+	traceID [16]byte
+	id      [8]byte
+	parent  [8]byte
+	name    string
+	kind    int
+	start   time.Time
+	end     time.Time
+	attrs   []spanAttr
+	err     string
+}
+
+// child starts span of operation done as part of span.
+func (s *span) child(name string) *span {
+	if s == nil {
+		return nil
+	}
+	return &span{
+		tracer:  s.tracer,
+		traceID: s.traceID,
+		id:      newSpanID(),
+		parent:  s.id,
+		name:    name,
+		kind:    spanKindInternal,
+		start:   time.Now(),
+	}
+}
+
+// setAttr adds attribute to span.
+func (s *span) setAttr(key, value string) {
+	if s == nil {
+		return
+	}
+	s.attrs = append(s.attrs, spanAttr{key, value})
+}
+
+// setError marks span failed if err is not nil.
+func (s *span) setError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.err = err.Error()
+}
+
+// finish ends span and passes it to exporter. Span must not be used after finish.
+func (s *span) finish() {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	s.tracer.add(s)
+}
+
+func newSpanID() [8]byte {
+	var id [8]byte
+	rand.Read(id[:])
+	return id
+}
+
+func newTraceID() [16]byte {
+	var id [16]byte
+	rand.Read(id[:])
+	return id
+}
+
+// parseTraceparent extracts trace id, parent span id and sampled flag from W3C
+// traceparent header value (version-traceid-spanid-flags).
+func parseTraceparent(value string) (traceID [16]byte, parent [8]byte, sampled bool, ok bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return
+	}
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return
+	}
+	if _, err := hex.Decode(traceID[:], []byte(parts[1])); err != nil || traceID == [16]byte{} {
+		return
+	}
+	if _, err := hex.Decode(parent[:], []byte(parts[2])); err != nil || parent == [8]byte{} {
+		return
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return
+	}
+	return traceID, parent, flags&1 == 1, true
+}
+
+// tracer samples traces started by node and exports finished spans to OTLP/HTTP
+// receiver from its own goroutine in batches.
+type tracer struct {
+	endpoint   string
+	protocol   string
+	sampleRate float64
+	resource   []spanAttr
+	client     *http.Client
+	// mu protects closed flag so spans never exported after tracer closed.
+	mu      sync.RWMutex
+	closed  bool
+	queue   chan *span
+	dropped *metricCounter
+	closeCh chan struct{}
+	done    chan struct{}
+}
+
+// newTracer creates tracer and starts exporter. Node name and version sent as
+// resource attributes of spans.
+func newTracer(endpoint, protocol string, sampleRate float64, node, version string, dropped *metricCounter) *tracer {
+	t := &tracer{
+		endpoint:   endpoint,
+		protocol:   protocol,
+		sampleRate: sampleRate,
+		resource: []spanAttr{
+			{"service.name", tracingServiceName},
+			{"service.version", version},
+			{"service.instance.id", node},
+		},
+		client:  &http.Client{Timeout: tracingExportTimeout},
+		queue:   make(chan *span, tracingQueueSize),
+		dropped: dropped,
+		closeCh: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// startRequest starts server span of HTTP request. Trace continued when request
+// has valid traceparent header and its sampled flag respected, otherwise new trace
+// sampled with configured rate. Nil returned when tracing disabled or request not
+// sampled.
+func (t *tracer) startRequest(name string, r *http.Request) *span {
+	if t == nil {
+		return nil
+	}
+	s := &span{
+		tracer: t,
+		name:   name,
+		kind:   spanKindServer,
+	}
+	if traceID, parent, sampled, ok := parseTraceparent(r.Header.Get(traceparentHeader)); ok {
+		if !sampled {
+			return nil
+		}
+		s.traceID = traceID
+		s.parent = parent
+	} else {
+		if t.sampleRate <= 0 || mathrand.Float64() >= t.sampleRate {
+			return nil
+		}
+		s.traceID = newTraceID()
+	}
+	s.id = newSpanID()
+	s.start = time.Now()
+	return s
+}
+
+// add puts finished span into exporter queue without blocking.
+func (t *tracer) add(s *span) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.closed {
+		return
+	}
+	select {
+	case t.queue <- s:
+	default:
+		t.dropped.Inc()
+	}
+}
+
+// close stops accepting new spans and waits until queued spans exported.
+func (t *tracer) close() {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return
+	}
+	t.closed = true
+	close(t.closeCh)
+	t.mu.Unlock()
+	<-t.done
+}
+
+func (t *tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(tracingFlushInterval)
+	defer ticker.Stop()
+	batch := make([]*span, 0, tracingBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.export(batch); err != nil {
+			logger.WARN.Printf("error exporting %d spans to %s: %v", len(batch), t.endpoint, err)
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case s := <-t.queue:
+			batch = append(batch, s)
+			if len(batch) >= tracingBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-t.closeCh:
+			for {
+				select {
+				case s := <-t.queue:
+					batch = append(batch, s)
+					if len(batch) >= tracingBatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// export sends spans to OTLP/HTTP receiver.
+func (t *tracer) export(spans []*span) error {
+	var body []byte
+	contentType := "application/x-protobuf"
+	if t.protocol == tracingProtocolJSON {
+		contentType = "application/json"
+		data, err := json.Marshal(otlpJSONRequest(t.resource, spans))
+		if err != nil {
+			return err
+		}
+		body = data
+	} else {
+		body = otlpProtobufRequest(t.resource, spans)
+	}
+	resp, err := t.client.Post(t.endpoint, contentType, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// validateTracingProtocol checks tracing_protocol option value.
+func validateTracingProtocol(protocol string) error {
+	switch protocol {
+	case "", tracingProtocolProtobuf, tracingProtocolJSON:
+		return nil
+	}
+	return errors.New("unknown tracing_protocol – " + protocol)
+}
+
+// OTLP/JSON representation of ExportTraceServiceRequest. Trace and span ids are
+// hex encoded, timestamps are decimal strings.
+type otlpJSONKeyValue struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+type otlpJSONStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpJSONSpan struct {
+	TraceID           string             `json:"traceId"`
+	SpanID            string             `json:"spanId"`
+	ParentSpanID      string             `json:"parentSpanId,omitempty"`
+	Name              string             `json:"name"`
+	Kind              int                `json:"kind"`
+	StartTimeUnixNano string             `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string             `json:"endTimeUnixNano"`
+	Attributes        []otlpJSONKeyValue `json:"attributes,omitempty"`
+	Status            otlpJSONStatus     `json:"status"`
+}
+
+type otlpJSONScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpJSONSpan `json:"spans"`
+}
+
+type otlpJSONResourceSpans struct {
+	Resource struct {
+		Attributes []otlpJSONKeyValue `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpJSONScopeSpans `json:"scopeSpans"`
+}
+
+type otlpJSONExportRequest struct {
+	ResourceSpans []otlpJSONResourceSpans `json:"resourceSpans"`
+}
+
+func otlpJSONAttributes(attrs []spanAttr) []otlpJSONKeyValue {
+	kvs := make([]otlpJSONKeyValue, len(attrs))
+	for i, attr := range attrs {
+		kvs[i].Key = attr.key
+		kvs[i].Value.StringValue = attr.value
+	}
+	return kvs
+}
+
+func otlpJSONRequest(resource []spanAttr, spans []*span) otlpJSONExportRequest {
+	scope := otlpJSONScopeSpans{Spans: make([]otlpJSONSpan, len(spans))}
+	scope.Scope.Name = tracingServiceName
+	for i, s := range spans {
+		js := otlpJSONSpan{
+			TraceID:           hex.EncodeToString(s.traceID[:]),
+			SpanID:            hex.EncodeToString(s.id[:]),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        otlpJSONAttributes(s.attrs),
+		}
+		if s.parent != [8]byte{} {
+			js.ParentSpanID = hex.EncodeToString(s.parent[:])
+		}
+		if s.err != "" {
+			js.Status = otlpJSONStatus{Code: spanStatusError, Message: s.err}
+		}
+		scope.Spans[i] = js
+	}
+	rs := otlpJSONResourceSpans{ScopeSpans: []otlpJSONScopeSpans{scope}}
+	rs.Resource.Attributes = otlpJSONAttributes(resource)
+	return otlpJSONExportRequest{ResourceSpans: []otlpJSONResourceSpans{rs}}
+}
+
+// Protobuf wire types used by OTLP messages.
+const (
+	protoWireVarint  = 0
+	protoWireFixed64 = 1
+	protoWireBytes   = 2
+)
+
+func protoTag(b *proto.Buffer, field, wireType int) {
+	b.EncodeVarint(uint64(field<<3 | wireType))
+}
+
+func protoBytes(b *proto.Buffer, field int, data []byte) {
+	protoTag(b, field, protoWireBytes)
+	b.EncodeRawBytes(data)
+}
+
+func protoString(b *proto.Buffer, field int, s string) {
+	protoBytes(b, field, []byte(s))
+}
+
+// protoKeyValues encodes attributes as repeated KeyValue field with string values.
+func protoKeyValues(b *proto.Buffer, field int, attrs []spanAttr) {
+	for _, attr := range attrs {
+		value := proto.NewBuffer(nil)
+		protoString(value, 1, attr.value)
+		kv := proto.NewBuffer(nil)
+		protoString(kv, 1, attr.key)
+		protoBytes(kv, 2, value.Bytes())
+		protoBytes(b, field, kv.Bytes())
+	}
+}
+
+// otlpProtobufRequest encodes spans as protobuf ExportTraceServiceRequest of
+// opentelemetry/proto/collector/trace/v1.
+func otlpProtobufRequest(resource []spanAttr, spans []*span) []byte {
+	scope := proto.NewBuffer(nil)
+	scopeInfo := proto.NewBuffer(nil)
+	protoString(scopeInfo, 1, tracingServiceName)
+	protoBytes(scope, 1, scopeInfo.Bytes())
+	for _, s := range spans {
+		ps := proto.NewBuffer(nil)
+		protoBytes(ps, 1, s.traceID[:])
+		protoBytes(ps, 2, s.id[:])
+		if s.parent != [8]byte{} {
+			protoBytes(ps, 4, s.parent[:])
+		}
+		protoString(ps, 5, s.name)
+		protoTag(ps, 6, protoWireVarint)
+		ps.EncodeVarint(uint64(s.kind))
+		protoTag(ps, 7, protoWireFixed64)
+		ps.EncodeFixed64(uint64(s.start.UnixNano()))
+		protoTag(ps, 8, protoWireFixed64)
+		ps.EncodeFixed64(uint64(s.end.UnixNano()))
+		protoKeyValues(ps, 9, s.attrs)
+		if s.err != "" {
+			status := proto.NewBuffer(nil)
+			protoString(status, 2, s.err)
+			protoTag(status, 3, protoWireVarint)
+			status.EncodeVarint(spanStatusError)
+			protoBytes(ps, 15, status.Bytes())
+		}
+		protoBytes(scope, 2, ps.Bytes())
+	}
+	res := proto.NewBuffer(nil)
+	protoKeyValues(res, 1, resource)
+	rs := proto.NewBuffer(nil)
+	protoBytes(rs, 1, res.Bytes())
+	protoBytes(rs, 2, scope.Bytes())
+	req := proto.NewBuffer(nil)
+	protoBytes(req, 1, rs.Bytes())
+	return req.Bytes()
+}
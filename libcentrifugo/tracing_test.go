@@ -0,0 +1,243 @@
+package libcentrifugo
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/centrifugal/centrifugo/libcentrifugo/auth"
+	"github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+// testCollector is OTLP/HTTP receiver keeping bodies of export requests.
+type testCollector struct {
+	sync.Mutex
+	contentType string
+	bodies      [][]byte
+}
+
+func (c *testCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	c.Lock()
+	c.contentType = r.Header.Get("Content-Type")
+	c.bodies = append(c.bodies, body)
+	c.Unlock()
+}
+
+// jsonSpans decodes spans from OTLP/JSON export requests.
+func (c *testCollector) jsonSpans(t *testing.T) []otlpJSONSpan {
+	c.Lock()
+	defer c.Unlock()
+	var spans []otlpJSONSpan
+	for _, body := range c.bodies {
+		var req otlpJSONExportRequest
+		assert.Equal(t, nil, json.Unmarshal(body, &req))
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+	}
+	return spans
+}
+
+// protoBytesFields returns values of length-delimited fields of protobuf message
+// by field number skipping fields of other wire types.
+func protoBytesFields(t *testing.T, data []byte) map[int][][]byte {
+	fields := map[int][][]byte{}
+	b := proto.NewBuffer(data)
+	for {
+		tag, err := b.DecodeVarint()
+		if err != nil {
+			return fields
+		}
+		switch tag & 7 {
+		case protoWireVarint:
+			_, err = b.DecodeVarint()
+		case protoWireFixed64:
+			_, err = b.DecodeFixed64()
+		case protoWireBytes:
+			var value []byte
+			value, err = b.DecodeRawBytes(true)
+			fields[int(tag>>3)] = append(fields[int(tag>>3)], value)
+		default:
+			t.Fatalf("unexpected wire type %d", tag&7)
+		}
+		assert.Equal(t, nil, err)
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	traceID, parent, sampled, ok := parseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	assert.True(t, ok)
+	assert.True(t, sampled)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", hex.EncodeToString(traceID[:]))
+	assert.Equal(t, "00f067aa0ba902b7", hex.EncodeToString(parent[:]))
+
+	_, _, sampled, ok = parseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	assert.True(t, ok)
+	assert.False(t, sampled)
+
+	// future versions can have more fields.
+	_, _, _, ok = parseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra")
+	assert.True(t, ok)
+
+	invalid := []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e47zz-00f067aa0ba902b7-01",
+	}
+	for _, value := range invalid {
+		_, _, _, ok = parseTraceparent(value)
+		assert.False(t, ok, value)
+	}
+}
+
+func TestTracerStartRequest(t *testing.T) {
+	var nilTracer *tracer
+	req, _ := http.NewRequest("POST", "/api/", nil)
+	sp := nilTracer.startRequest("api", req)
+	assert.Equal(t, (*span)(nil), sp)
+	// methods of nil span do nothing.
+	sp.child("apiCmd").finish()
+	sp.setAttr("method", "publish")
+	sp.setError(errors.New("error"))
+	sp.finish()
+
+	var dropped metricCounter
+	tr := &tracer{sampleRate: 0, queue: make(chan *span, 1), dropped: &dropped}
+	assert.Equal(t, (*span)(nil), tr.startRequest("api", req))
+
+	// sampled flag of caller respected regardless of sample rate.
+	req.Header.Set(traceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	sp = tr.startRequest("api", req)
+	assert.NotEqual(t, (*span)(nil), sp)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", hex.EncodeToString(sp.traceID[:]))
+	assert.Equal(t, "00f067aa0ba902b7", hex.EncodeToString(sp.parent[:]))
+	child := sp.child("apiCmd")
+	assert.Equal(t, sp.traceID, child.traceID)
+	assert.Equal(t, sp.id, child.parent)
+
+	tr.sampleRate = 1
+	req.Header.Set(traceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	assert.Equal(t, (*span)(nil), tr.startRequest("api", req))
+
+	req.Header.Del(traceparentHeader)
+	sp = tr.startRequest("api", req)
+	assert.NotEqual(t, (*span)(nil), sp)
+	assert.Equal(t, [8]byte{}, sp.parent)
+
+	// spans dropped when queue is full.
+	child.finish()
+	sp.finish()
+	assert.Equal(t, int64(1), dropped.LoadRaw())
+}
+
+func TestTracerExportJSON(t *testing.T) {
+	collector := &testCollector{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	var dropped metricCounter
+	tr := newTracer(server.URL, tracingProtocolJSON, 1, "node", "1.5.1", &dropped)
+	req, _ := http.NewRequest("POST", "/api/", nil)
+	sp := tr.startRequest("api", req)
+	child := sp.child("apiCmd")
+	child.setAttr("method", "publish")
+	child.setError(errors.New("permission denied"))
+	child.finish()
+	sp.finish()
+	tr.close()
+
+	assert.Equal(t, "application/json", collector.contentType)
+	spans := collector.jsonSpans(t)
+	assert.Equal(t, 2, len(spans))
+	assert.Equal(t, "apiCmd", spans[0].Name)
+	assert.Equal(t, spans[1].SpanID, spans[0].ParentSpanID)
+	assert.Equal(t, spans[1].TraceID, spans[0].TraceID)
+	assert.Equal(t, spanStatusError, spans[0].Status.Code)
+	assert.Equal(t, "permission denied", spans[0].Status.Message)
+	assert.Equal(t, "method", spans[0].Attributes[0].Key)
+	assert.Equal(t, "publish", spans[0].Attributes[0].Value.StringValue)
+	assert.Equal(t, "api", spans[1].Name)
+	assert.Equal(t, spanKindServer, spans[1].Kind)
+	assert.Equal(t, "", spans[1].ParentSpanID)
+
+	// spans finished after close not exported.
+	tr.startRequest("api", req).finish()
+	assert.Equal(t, 1, len(collector.bodies))
+}
+
+func TestTracerExportProtobuf(t *testing.T) {
+	collector := &testCollector{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	var dropped metricCounter
+	tr := newTracer(server.URL, tracingProtocolProtobuf, 1, "node", "1.5.1", &dropped)
+	req, _ := http.NewRequest("POST", "/api/", nil)
+	req.Header.Set(traceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	sp := tr.startRequest("api", req)
+	sp.setError(errors.New("error"))
+	sp.finish()
+	tr.close()
+
+	assert.Equal(t, "application/x-protobuf", collector.contentType)
+	assert.Equal(t, 1, len(collector.bodies))
+	resourceSpans := protoBytesFields(t, collector.bodies[0])[1]
+	assert.Equal(t, 1, len(resourceSpans))
+	rs := protoBytesFields(t, resourceSpans[0])
+	resource := protoBytesFields(t, rs[1][0])
+	assert.Equal(t, 3, len(resource[1]))
+	assert.Equal(t, "service.name", string(protoBytesFields(t, resource[1][0])[1][0]))
+	scope := protoBytesFields(t, rs[2][0])
+	assert.Equal(t, 1, len(scope[2]))
+	ps := protoBytesFields(t, scope[2][0])
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", hex.EncodeToString(ps[1][0]))
+	assert.Equal(t, "00f067aa0ba902b7", hex.EncodeToString(ps[4][0]))
+	assert.Equal(t, "api", string(ps[5][0]))
+	assert.Equal(t, "error", string(protoBytesFields(t, ps[15][0])[2][0]))
+}
+
+func TestAPIHandlerTracing(t *testing.T) {
+	collector := &testCollector{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	c := newTestConfig()
+	c.ChannelOptions.HistorySize = 10
+	c.ChannelOptions.HistoryLifetime = 60
+	app := testMemoryAppWithConfig(&c)
+	app.tracer = newTracer(server.URL, tracingProtocolJSON, 1, "node", "1.5.1", &app.metrics.NumTracingSpansDropped)
+
+	data := `{"method":"publish","params":{"channel":"test","data":{}}}`
+	req := newTestAPIJSONRequest(data, auth.GenerateApiSign("secret", []byte(data)))
+	req.Header.Set(traceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+	app.APIHandler(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	app.tracer.close()
+
+	spans := collector.jsonSpans(t)
+	names := map[string]otlpJSONSpan{}
+	for _, sp := range spans {
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sp.TraceID)
+		names[sp.Name] = sp
+	}
+	assert.Equal(t, 5, len(names))
+	assert.Equal(t, "00f067aa0ba902b7", names["api"].ParentSpanID)
+	assert.Equal(t, names["api"].SpanID, names["apiCmd"].ParentSpanID)
+	assert.Equal(t, names["apiCmd"].SpanID, names["engine.publish"].ParentSpanID)
+	assert.Equal(t, names["engine.publish"].SpanID, names["history.add"].ParentSpanID)
+	assert.Equal(t, names["engine.publish"].SpanID, names["broadcast"].ParentSpanID)
+}
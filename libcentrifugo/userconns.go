@@ -0,0 +1,75 @@
+package libcentrifugo
+
+import (
+	"encoding/json"
+
+	"github.com/FZambia/go-logger"
+)
+
+// userConnection describes single connection of user on some node.
+type userConnection struct {
+	Client      ConnID    `json:"client"`
+	NodeUID     string    `json:"node_uid"`
+	NodeName    string    `json:"node_name"`
+	ConnectedAt int64     `json:"connected_at"`
+	Transport   string    `json:"transport"`
+	Channels    []Channel `json:"channels"`
+}
+
+// localUserConnections returns connections of user on current node.
+func (app *Application) localUserConnections(user UserID) []userConnection {
+	app.RLock()
+	name := app.config.Name
+	app.RUnlock()
+	conns := []userConnection{}
+	for _, c := range app.clients.userConnections(user) {
+		conns = append(conns, userConnection{
+			Client:      c.uid(),
+			NodeUID:     app.uid,
+			NodeName:    name,
+			ConnectedAt: c.connected(),
+			Transport:   c.transport(),
+			Channels:    c.channels(),
+		})
+	}
+	return conns
+}
+
+// UserConnections returns connections of user across cluster. Other nodes asked
+// with control message and their replies collected during controlRequestTimeout,
+// returned bool is false when not all known nodes answered in time.
+func (app *Application) UserConnections(user UserID) ([]userConnection, bool, error) {
+	if user == "" {
+		return nil, false, ErrInvalidMessage
+	}
+	conns := app.localUserConnections(user)
+
+	replies, complete, err := app.requestNodes("user_connections", func(requestID string) interface{} {
+		return &userConnectionsControlCommand{
+			RequestID: requestID,
+			User:      user,
+		}
+	})
+	if err != nil {
+		logger.ERROR.Println(err)
+		return conns, false, nil
+	}
+	for _, reply := range replies {
+		if nodeConns, ok := reply.([]userConnection); ok {
+			conns = append(conns, nodeConns...)
+		}
+	}
+	return conns, complete, nil
+}
+
+// userConnectionsControlCmd answers user connections request of other node.
+func (app *Application) userConnectionsControlCmd(cmd *userConnectionsControlCommand) error {
+	cmdBytes, err := json.Marshal(&userConnectionsReplyControlCommand{
+		RequestID:   cmd.RequestID,
+		Connections: app.localUserConnections(cmd.User),
+	})
+	if err != nil {
+		return err
+	}
+	return app.pubControl("user_connections_reply", cmdBytes)
+}
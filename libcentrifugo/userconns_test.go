@@ -0,0 +1,76 @@
+package libcentrifugo
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserConnectionsLocal(t *testing.T) {
+	app := testMemoryApp()
+	app.config.Name = "node-1"
+	createTestClients(app, 2, 2, nil)
+
+	_, _, err := app.UserConnections("")
+	assert.Equal(t, ErrInvalidMessage, err)
+
+	conns, complete, err := app.UserConnections("user-0")
+	assert.Equal(t, nil, err)
+	assert.True(t, complete)
+	assert.Equal(t, 1, len(conns))
+	assert.Equal(t, "node-1", conns[0].NodeName)
+	assert.Equal(t, app.uid, conns[0].NodeUID)
+	assert.Equal(t, 2, len(conns[0].Channels))
+}
+
+func TestUserConnectionsRemote(t *testing.T) {
+	app := testApp()
+	createTestClients(app, 1, 1, nil)
+	app.nodes["node-2"] = nodeInfo{UID: "node-2"}
+	app.nodes["node-3"] = nodeInfo{UID: "node-3"}
+
+	type result struct {
+		conns    []userConnection
+		complete bool
+	}
+	done := make(chan result)
+	go func() {
+		conns, complete, _ := app.UserConnections("user-0")
+		done <- result{conns, complete}
+	}()
+
+	var requestID string
+	assert.True(t, waitCondition(func() bool {
+		app.controlRequests.Lock()
+		defer app.controlRequests.Unlock()
+		for id := range app.controlRequests.requests {
+			requestID = id
+		}
+		return requestID != ""
+	}))
+	reply, _ := json.Marshal(userConnectionsReplyControlCommand{
+		RequestID:   requestID,
+		Connections: []userConnection{{Client: "remote", NodeUID: "node-2"}},
+	})
+	assert.Equal(t, nil, app.controlMsg(newControlMessage("node-2", "user_connections_reply", reply)))
+
+	// node-3 never answers so result is incomplete after timeout.
+	res := <-done
+	assert.False(t, res.complete)
+	assert.Equal(t, 2, len(res.conns))
+	assert.Equal(t, ConnID("remote"), res.conns[1].Client)
+	assert.Equal(t, 0, len(app.controlRequests.requests))
+}
+
+func TestAPIUserConnections(t *testing.T) {
+	app := testMemoryApp()
+	createTestClients(app, 1, 1, nil)
+	params, _ := json.Marshal(userConnectionsAPICommand{User: "user-0"})
+	resp, err := app.apiCmd(apiCommand{Method: "user_connections", Params: params})
+	assert.Equal(t, nil, err)
+	body := resp.(*apiUserConnectionsResponse).Body
+	assert.Equal(t, UserID("user-0"), body.User)
+	assert.Equal(t, 1, len(body.Data))
+	assert.False(t, body.Incomplete)
+}
@@ -0,0 +1,228 @@
+package libcentrifugo
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/FZambia/go-logger"
+	"github.com/centrifugal/centrifugo/libcentrifugo/raw"
+)
+
+// adminWatchDefaultDuration is a duration of watch used when neither command nor
+// admin_watch_max_duration sets it.
+const adminWatchDefaultDuration = 5 * time.Minute
+
+// adminWatcher is an admin connection receiving messages of watched channels.
+type adminWatcher interface {
+	uid() ConnID
+	// enqueue sends message to admin connection.
+	enqueue(message []byte) error
+}
+
+// adminWatch is a watch of admin connection on exact channel or on channels
+// matching glob pattern.
+type adminWatch struct {
+	admin   adminWatcher
+	channel Channel
+	pattern string
+	expires time.Time
+	timer   *time.Timer
+	// bucket limits rate of messages forwarded to admin, excess messages dropped.
+	// Rate not limited when nil.
+	bucket *tokenBucket
+}
+
+func (w *adminWatch) matches(ch Channel) bool {
+	if w.pattern != "" {
+		return globMatch(w.pattern, string(ch))
+	}
+	return w.channel == ch
+}
+
+// adminWatchHub keeps channel watches of admin connections on this node.
+type adminWatchHub struct {
+	mu      sync.Mutex
+	watches map[ConnID][]*adminWatch
+	// channels contains number of watches on exact channel, node subscribed on
+	// channel in engine while channel watched.
+	channels map[Channel]int
+	// n is a number of watches checked without lock on every message.
+	n int32
+}
+
+func newAdminWatchHub() *adminWatchHub {
+	return &adminWatchHub{
+		watches:  make(map[ConnID][]*adminWatch),
+		channels: make(map[Channel]int),
+	}
+}
+
+// add adds watch and returns true if it is a first watch on exact channel.
+func (h *adminWatchHub) add(w *adminWatch) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.watches[w.admin.uid()] = append(h.watches[w.admin.uid()], w)
+	atomic.AddInt32(&h.n, 1)
+	if w.pattern != "" {
+		return false
+	}
+	h.channels[w.channel]++
+	return h.channels[w.channel] == 1
+}
+
+// remove removes watches of admin connection on channel or pattern, all watches of
+// connection removed when both are empty. It returns exact channels not watched
+// anymore.
+func (h *adminWatchHub) remove(uid ConnID, ch Channel, pattern string) []Channel {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var unwatched []Channel
+	var kept []*adminWatch
+	for _, w := range h.watches[uid] {
+		all := ch == "" && pattern == ""
+		if !all && (w.channel != ch || w.pattern != pattern) {
+			kept = append(kept, w)
+			continue
+		}
+		w.timer.Stop()
+		atomic.AddInt32(&h.n, -1)
+		if w.pattern != "" {
+			continue
+		}
+		h.channels[w.channel]--
+		if h.channels[w.channel] == 0 {
+			delete(h.channels, w.channel)
+			unwatched = append(unwatched, w.channel)
+		}
+	}
+	if len(kept) == 0 {
+		delete(h.watches, uid)
+	} else {
+		h.watches[uid] = kept
+	}
+	return unwatched
+}
+
+// watched returns true if exact channel watched by admin connection.
+func (h *adminWatchHub) watched(ch Channel) bool {
+	if atomic.LoadInt32(&h.n) == 0 {
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.channels[ch] > 0
+}
+
+// receivers returns admin connections watching channel which did not exceed rate
+// of forwarded messages.
+func (h *adminWatchHub) receivers(ch Channel, now time.Time) []adminWatcher {
+	if atomic.LoadInt32(&h.n) == 0 {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var admins []adminWatcher
+	for _, watches := range h.watches {
+		for _, w := range watches {
+			if !w.matches(ch) || !now.Before(w.expires) {
+				continue
+			}
+			if w.bucket == nil || w.bucket.allow(now) {
+				admins = append(admins, w.admin)
+			}
+			// one message per admin connection even if several watches match.
+			break
+		}
+	}
+	return admins
+}
+
+// watchChannel starts forwarding messages, joins and leaves of channel (or channels
+// matching pattern) to admin connection. Watch stops automatically after duration
+// limited by admin_watch_max_duration. Node subscribes on exact channel in engine,
+// pattern matches only channels node already subscribed on.
+func (app *Application) watchChannel(admin adminWatcher, ch Channel, pattern string, duration time.Duration) (time.Time, error) {
+	if (ch == "") == (pattern == "") {
+		return time.Time{}, errors.New("watch requires one of channel and pattern")
+	}
+	app.RLock()
+	maxDuration := app.config.AdminWatchMaxDuration
+	rate := app.config.AdminWatchRate
+	app.RUnlock()
+	if duration <= 0 || (maxDuration > 0 && duration > maxDuration) {
+		duration = maxDuration
+	}
+	if duration <= 0 {
+		// watch must never last forever.
+		duration = adminWatchDefaultDuration
+	}
+	now := time.Now()
+	w := &adminWatch{
+		admin:   admin,
+		channel: ch,
+		pattern: pattern,
+		expires: now.Add(duration),
+	}
+	if rate > 0 {
+		w.bucket = newTokenBucket(rate, 0, now)
+	}
+	// existing watch on the same channel or pattern replaced.
+	app.unwatchChannel(admin.uid(), ch, pattern)
+	w.timer = time.AfterFunc(duration, func() {
+		app.unwatchChannel(admin.uid(), ch, pattern)
+	})
+	s := app.engineSubs.lock(ch)
+	var errCh <-chan error
+	if app.adminWatches.add(w) {
+		errCh = app.engine.subscribe(ch)
+	}
+	app.engineSubs.unlock(ch, s)
+	if errCh != nil {
+		if err := <-errCh; err != nil {
+			app.unwatchChannel(admin.uid(), ch, pattern)
+			return time.Time{}, err
+		}
+	}
+	return w.expires, nil
+}
+
+// unwatchChannel stops watch of admin connection, all watches of connection
+// stopped when channel and pattern are empty. Node unsubscribes from channels not
+// watched anymore if there are no clients subscribed.
+func (app *Application) unwatchChannel(uid ConnID, ch Channel, pattern string) {
+	for _, unwatched := range app.adminWatches.remove(uid, ch, pattern) {
+		s := app.engineSubs.lock(unwatched)
+		if app.clients.numSubscribers(unwatched) == 0 && !app.adminWatches.watched(unwatched) {
+			if err := app.engine.unsubscribe(unwatched); err != nil {
+				logger.ERROR.Println(err)
+			}
+		}
+		app.engineSubs.unlock(unwatched, s)
+	}
+}
+
+// watchMsg forwards message, join or leave received from channel to admin
+// connections watching it.
+func (app *Application) watchMsg(ch Channel, method string, message interface{}) {
+	admins := app.adminWatches.receivers(ch, time.Now())
+	if len(admins) == 0 {
+		return
+	}
+	data, err := json.Marshal(message)
+	if err != nil {
+		logger.ERROR.Println(err)
+		return
+	}
+	body := raw.Raw(data)
+	byteMessage, err := json.Marshal(newAPIAdminMessageResponse(method, &body))
+	if err != nil {
+		logger.ERROR.Println(err)
+		return
+	}
+	for _, admin := range admins {
+		admin.enqueue(byteMessage)
+	}
+}
@@ -0,0 +1,104 @@
+package libcentrifugo
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testAdminWatcher struct {
+	sync.Mutex
+	id       ConnID
+	messages []string
+}
+
+func (w *testAdminWatcher) uid() ConnID {
+	return w.id
+}
+
+func (w *testAdminWatcher) enqueue(message []byte) error {
+	w.Lock()
+	defer w.Unlock()
+	w.messages = append(w.messages, string(message))
+	return nil
+}
+
+func (w *testAdminWatcher) received() []string {
+	w.Lock()
+	defer w.Unlock()
+	return append([]string{}, w.messages...)
+}
+
+func TestWatchChannel(t *testing.T) {
+	app := testMemoryApp()
+	admin := &testAdminWatcher{id: "admin1"}
+
+	_, err := app.watchChannel(admin, "", "", time.Minute)
+	assert.NotEqual(t, nil, err)
+
+	expires, err := app.watchChannel(admin, "test", "", time.Minute)
+	assert.Equal(t, nil, err)
+	assert.True(t, expires.After(time.Now()))
+	assert.True(t, app.adminWatches.watched("test"))
+
+	err = app.Publish("test", []byte(`{"n":1}`), "", nil)
+	assert.Equal(t, nil, err)
+	err = app.Publish("other", []byte(`{"n":2}`), "", nil)
+	assert.Equal(t, nil, err)
+	assert.True(t, waitCondition(func() bool {
+		return len(admin.received()) == 1
+	}))
+	assert.True(t, strings.Contains(admin.received()[0], `"method":"message"`))
+	assert.True(t, strings.Contains(admin.received()[0], `{"n":1}`))
+
+	// second watch of other admin does not subscribe node again.
+	other := &testAdminWatcher{id: "admin2"}
+	_, err = app.watchChannel(other, "test", "", time.Minute)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, app.adminWatches.channels["test"])
+
+	app.unwatchChannel(admin.uid(), "", "")
+	assert.True(t, app.adminWatches.watched("test"))
+	app.unwatchChannel(other.uid(), "test", "")
+	assert.False(t, app.adminWatches.watched("test"))
+}
+
+func TestWatchChannelPattern(t *testing.T) {
+	app := testMemoryApp()
+	app.config.AdminWatchRate = 1
+	admin := &testAdminWatcher{id: "admin1"}
+	_, err := app.watchChannel(admin, "", "test:*", time.Minute)
+	assert.Equal(t, nil, err)
+
+	app.Publish("test:1", []byte(`{}`), "", nil)
+	app.Publish("test:2", []byte(`{}`), "", nil)
+	app.Publish("other", []byte(`{}`), "", nil)
+	time.Sleep(50 * time.Millisecond)
+	// second message dropped as rate exceeded.
+	assert.Equal(t, 1, len(admin.received()))
+}
+
+func TestWatchChannelExpires(t *testing.T) {
+	app := testMemoryApp()
+	app.config.AdminWatchMaxDuration = 10 * time.Millisecond
+	admin := &testAdminWatcher{id: "admin1"}
+	_, err := app.watchChannel(admin, "test", "", time.Minute)
+	assert.Equal(t, nil, err)
+	assert.True(t, waitCondition(func() bool {
+		return !app.adminWatches.watched("test")
+	}))
+}
+
+func TestWatchChannelDefaultDuration(t *testing.T) {
+	app := testMemoryApp()
+	app.config.AdminWatchMaxDuration = 0
+	admin := &testAdminWatcher{id: "admin1"}
+	expires, err := app.watchChannel(admin, "test", "", 0)
+	assert.Equal(t, nil, err)
+	assert.True(t, expires.After(time.Now()))
+	assert.True(t, expires.Before(time.Now().Add(adminWatchDefaultDuration+time.Second)))
+	app.unwatchChannel(admin.uid(), "test", "")
+}
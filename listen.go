@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// unixSocketPrefix marks port option value as path to unix socket, for example
+// unix:/var/run/centrifugo.sock.
+const unixSocketPrefix = "unix:"
+
+// listenFdsStart is a first file descriptor passed by systemd socket activation.
+const listenFdsStart = 3
+
+// activatedSocketName is a name of socket passed by systemd without FileDescriptorName
+// option set in socket unit.
+const activatedSocketName = "unknown"
+
+// unixSocketPath returns path to unix socket if port is a unix socket address.
+func unixSocketPath(port string) (string, bool) {
+	if !strings.HasPrefix(port, unixSocketPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(port, unixSocketPrefix), true
+}
+
+// listenAddr returns human readable address of listener for port.
+func listenAddr(address, port string) string {
+	if _, ok := unixSocketPath(port); ok {
+		return port
+	}
+	return net.JoinHostPort(address, port)
+}
+
+// activatedListeners returns listeners passed by systemd socket activation keyed
+// by socket name (FileDescriptorName option of socket unit). Nil map returned when
+// process was not started by socket activation.
+func activatedListeners() (map[string]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	// do not pass sockets to child processes.
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := map[string]net.Listener{}
+	for i := 0; i < n; i++ {
+		fd := listenFdsStart + i
+		syscall.CloseOnExec(fd)
+		name := activatedSocketName
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		if _, ok := listeners[name]; ok {
+			return nil, fmt.Errorf("duplicate activated socket name %s, set FileDescriptorName for sockets", name)
+		}
+		f := os.NewFile(uintptr(fd), name)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error using activated socket %s: %v", name, err)
+		}
+		listeners[name] = ln
+	}
+	return listeners, nil
+}
+
+// activatedListener returns listener passed by socket activation for one of
+// endpoint names served on port (client, api or admin). Single unnamed socket used
+// for client endpoint.
+func activatedListener(activated map[string]net.Listener, names []string) net.Listener {
+	for _, name := range names {
+		if ln, ok := activated[name]; ok {
+			return ln
+		}
+	}
+	if ln, ok := activated[activatedSocketName]; ok && len(activated) == 1 {
+		for _, name := range names {
+			if name == "client" {
+				return ln
+			}
+		}
+	}
+	return nil
+}
+
+// loopbackListener checks that listener accepts connections only from local host:
+// it is a unix socket or TCP listener bound to loopback address.
+func loopbackListener(ln net.Listener) bool {
+	switch addr := ln.Addr().(type) {
+	case *net.UnixAddr:
+		return true
+	case *net.TCPAddr:
+		return addr.IP.IsLoopback()
+	}
+	return false
+}
+
+// listen creates TCP listener or unix socket listener if port is a unix socket
+// address.
+func listen(address, port string) (net.Listener, error) {
+	if path, ok := unixSocketPath(port); ok {
+		return listenUnix(path)
+	}
+	return net.Listen("tcp", net.JoinHostPort(address, port))
+}
+
+// listenUnix removes stale socket file, creates unix socket listener and applies
+// socket file mode and ownership from config.
+func listenUnix(path string) (net.Listener, error) {
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := setSocketPermissions(path); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// removeStaleSocket removes socket file left by process which was not stopped
+// gracefully. Socket other process still listens on is not removed.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("socket %s is in use", path)
+	}
+	return os.Remove(path)
+}
+
+// setSocketPermissions sets unix socket file mode (octal, for example 0660) and
+// owner user and group if configured.
+func setSocketPermissions(path string) error {
+	if mode := viper.GetString("unix_socket_mode"); mode != "" {
+		perm, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil {
+			return fmt.Errorf("malformed unix socket mode %s", mode)
+		}
+		if err := os.Chmod(path, os.FileMode(perm)); err != nil {
+			return err
+		}
+	}
+	uid, gid := -1, -1
+	if name := viper.GetString("unix_socket_user"); name != "" {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return err
+		}
+		if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return err
+		}
+	}
+	if name := viper.GetString("unix_socket_group"); name != "" {
+		g, err := user.LookupGroup(name)
+		if err != nil {
+			return err
+		}
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return err
+		}
+	}
+	if uid == -1 && gid == -1 {
+		return nil
+	}
+	return os.Chown(path, uid, gid)
+}
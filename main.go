@@ -1,7 +1,11 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
@@ -15,8 +19,12 @@ import (
 
 	"github.com/FZambia/go-logger"
 	"github.com/centrifugal/centrifugo/libcentrifugo"
+	"github.com/centrifugal/centrifugo/libcentrifugo/auth"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"golang.org/x/crypto/acme/autocert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"gopkg.in/igm/sockjs-go.v2/sockjs"
 )
 
@@ -35,16 +43,218 @@ func setupLogging() {
 	}
 }
 
-func handleSignals(app *libcentrifugo.Application) {
+// shutdownExitGrace is an extra time given to stop engine after client connections
+// drained and HTTP servers stopped before process forcefully exits.
+const shutdownExitGrace = 5 * time.Second
+
+// envOptions are options which can be set using environment variables with
+// CENTRIFUGO_ prefix.
+var envOptions = []string{
+	"debug", "engine", "insecure", "insecure_api", "web", "admin", "admin_password", "admin_secret",
+	"insecure_web", "insecure_admin", "api_legacy_form_enabled", "api_legacy_sign_enabled", "secret",
+	"secret_previous", "connection_lifetime", "watch", "publish", "anonymous", "join_leave", "presence",
+	"recover", "history_size", "history_lifetime", "history_drop_inactive", "redis_host", "redis_port",
+	"redis_url", "nats_url", "config_source", "config_source_endpoint", "config_source_key",
+	"insecure_public_bind", "statsd_addr", "tracing_endpoint",
+}
+
+// flagOptions are options which can be set using command line flags.
+var flagOptions = []string{
+	"port", "api_port", "admin_port", "address", "debug", "name", "admin", "insecure_admin", "web",
+	"web_path", "insecure_web", "engine", "insecure", "insecure_api", "ssl", "ssl_cert", "ssl_key",
+	"log_level", "log_file", "redis_host", "redis_port", "redis_password", "redis_db", "redis_url",
+	"redis_api", "redis_pool", "redis_api_num_shards", "redis_master_name", "redis_sentinels",
+	"redis_replica_url", "nats_url", "nats_user", "nats_password", "nats_token",
+	"ssl_autocert", "ssl_autocert_host_whitelist", "ssl_autocert_cache_dir", "ssl_autocert_email",
+	"ssl_autocert_ports", "ssl_autocert_http", "ssl_autocert_http_addr", "api_ssl_cert", "api_ssl_key",
+	"api_ssl_client_ca", "api_ssl_client_cn", "config_source", "config_source_endpoint",
+	"config_source_key", "insecure_public_bind", "grpc_api", "grpc_api_port", "grpc_client",
+	"grpc_client_port",
+}
+
+// listenerOptions are options HTTP listeners created with. Changing them in config
+// file takes effect only after restart.
+var listenerOptions = []string{
+	"port", "api_port", "admin_port", "address", "prefix", "web", "web_path", "admin",
+	"debug", "ssl", "ssl_cert", "ssl_key", "ssl_autocert", "ssl_autocert_host_whitelist",
+	"ssl_autocert_cache_dir", "ssl_autocert_email", "ssl_autocert_ports",
+	"ssl_autocert_http", "ssl_autocert_http_addr", "sockjs_url", "unix_socket_mode",
+	"unix_socket_user", "unix_socket_group", "api_ssl_cert", "api_ssl_key", "api_ssl_client_ca",
+	"api_ssl_client_cn", "grpc_api", "grpc_api_port", "grpc_client", "grpc_client_port",
+}
+
+// listenerSettings returns current values of listener options.
+func listenerSettings() map[string]string {
+	settings := map[string]string{}
+	for _, key := range listenerOptions {
+		settings[key] = viper.GetString(key)
+	}
+	return settings
+}
+
+// httpServers keeps started HTTP and gRPC servers to shut them down gracefully.
+type httpServers struct {
+	sync.Mutex
+	servers     []*http.Server
+	grpcServers []*grpc.Server
+	binds       []publicBind
+	closed      bool
+}
+
+// publicBind describes non-loopback listener serving API or admin endpoints.
+type publicBind struct {
+	addr  string
+	api   bool
+	admin bool
+	grpc  bool
+}
+
+// addBind remembers non-loopback listener to check insecure modes against it on
+// configuration reload.
+func (s *httpServers) addBind(bind publicBind) {
+	s.Lock()
+	s.binds = append(s.binds, bind)
+	s.Unlock()
+}
+
+// checkBinds returns error if configuration enables insecure API or admin mode
+// while such endpoints served on non-loopback address and insecure_public_bind
+// not set.
+func (s *httpServers) checkBinds(c *libcentrifugo.Config) error {
+	if viper.GetBool("insecure_public_bind") {
+		return nil
+	}
+	s.Lock()
+	defer s.Unlock()
+	for _, bind := range s.binds {
+		if c.InsecureAPI && bind.api {
+			name := "API"
+			if bind.grpc {
+				name = "gRPC API"
+			}
+			return fmt.Errorf("refusing to serve insecure %s on non-loopback address %s, bind to loopback address or set insecure_public_bind", name, bind.addr)
+		}
+		if c.InsecureAdmin && bind.admin {
+			return fmt.Errorf("refusing to serve insecure admin on non-loopback address %s, bind to loopback address or set insecure_public_bind", bind.addr)
+		}
+	}
+	return nil
+}
+
+func (s *httpServers) add(server *http.Server) {
+	s.Lock()
+	s.servers = append(s.servers, server)
+	s.Unlock()
+}
+
+func (s *httpServers) addGRPC(server *grpc.Server) {
+	s.Lock()
+	s.grpcServers = append(s.grpcServers, server)
+	s.Unlock()
+}
+
+// stopped returns true if shutdown started.
+func (s *httpServers) stopped() bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.closed
+}
+
+// shutdown stops all servers waiting for active requests until context done.
+func (s *httpServers) shutdown(ctx context.Context) {
+	s.Lock()
+	defer s.Unlock()
+	s.closed = true
+	for _, server := range s.servers {
+		if err := server.Shutdown(ctx); err != nil {
+			logger.ERROR.Println("Error shutting down HTTP server:", err)
+		}
+	}
+	for _, server := range s.grpcServers {
+		stopped := make(chan struct{})
+		go func(server *grpc.Server) {
+			server.GracefulStop()
+			close(stopped)
+		}(server)
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			logger.ERROR.Println("Error shutting down gRPC server:", ctx.Err())
+			server.Stop()
+		}
+	}
+}
+
+// reloadConfig applies configuration already read into viper to application.
+// Invalid configuration or configuration enabling insecure modes on running
+// non-loopback listeners is not applied.
+func reloadConfig(app *libcentrifugo.Application, servers *httpServers, listeners map[string]string) {
+	setupLogging()
+	c := newConfig()
+	if err := c.Validate(); err != nil {
+		logger.CRITICAL.Printf("Error validating configuration: %s\n", err)
+		return
+	}
+	if err := servers.checkBinds(c); err != nil {
+		logger.CRITICAL.Printf("Error validating configuration: %s\n", err)
+		return
+	}
+	if viper.GetString("engine") == "nats" {
+		if err := libcentrifugo.ValidateNatsConfig(c); err != nil {
+			logger.CRITICAL.Printf("Error validating configuration: %s\n", err)
+			return
+		}
+	}
+	app.SetConfig(c)
+	configDump.update()
+	logger.INFO.Println("Configuration successfully reloaded")
+	for key, value := range listenerSettings() {
+		if value != listeners[key] {
+			logger.WARN.Printf("Option %s changed, restart required to apply it\n", key)
+		}
+	}
+}
+
+// handleSignals reloads configuration on SIGHUP or when remote configuration
+// changed and shuts down gracefully on SIGINT and SIGTERM closing done channel
+// when HTTP servers stopped.
+func handleSignals(app *libcentrifugo.Application, servers *httpServers, remote *remoteConfig, done chan struct{}) {
+	listeners := listenerSettings()
 	sigc := make(chan os.Signal, 1)
 	signal.Notify(sigc, syscall.SIGHUP, syscall.SIGINT, os.Interrupt, syscall.SIGTERM)
+	var updates <-chan []byte
+	if remote != nil {
+		updates = remote.watch()
+	}
 	for {
-		sig := <-sigc
+		var sig os.Signal
+		select {
+		case data := <-updates:
+			if !remote.changed(data) {
+				continue
+			}
+			logger.INFO.Println("Remote configuration changed, reloading")
+			if err := remote.load(data); err != nil {
+				logger.CRITICAL.Printf("Error parsing configuration: %s\n", err)
+				continue
+			}
+			reloadConfig(app, servers, listeners)
+			continue
+		case sig = <-sigc:
+		}
 		logger.INFO.Println("Signal received:", sig)
 		switch sig {
 		case syscall.SIGHUP:
 			// reload application configuration on SIGHUP
 			logger.INFO.Println("Reloading configuration")
+			if remote != nil {
+				if err := remote.read(); err != nil {
+					logger.CRITICAL.Printf("Error reading remote configuration: %s\n", err)
+					continue
+				}
+				reloadConfig(app, servers, listeners)
+				continue
+			}
 			err := viper.ReadInConfig()
 			if err != nil {
 				switch err.(type) {
@@ -56,34 +266,224 @@ func handleSignals(app *libcentrifugo.Application) {
 					continue
 				}
 			}
-			setupLogging()
-			c := newConfig()
-			app.SetConfig(c)
-			logger.INFO.Println("Configuration successfully reloaded")
+			reloadConfig(app, servers, listeners)
 		case syscall.SIGINT, os.Interrupt, syscall.SIGTERM:
 			logger.INFO.Println("Shutting down")
-			go time.AfterFunc(10*time.Second, func() {
+			shutdownTimeout := time.Duration(viper.GetInt("shutdown_timeout")) * time.Second
+			httpShutdownTimeout := time.Duration(viper.GetInt("http_shutdown_timeout")) * time.Second
+			time.AfterFunc(shutdownTimeout+httpShutdownTimeout+shutdownExitGrace, func() {
+				logger.FATAL.Println("Shutdown timed out")
 				os.Exit(1)
 			})
+			// drain client connections first, then stop accepting new connections
+			// and wait for in-flight requests.
 			app.Shutdown()
-			os.Exit(0)
+			ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+			servers.shutdown(ctx)
+			cancel()
+			close(done)
+			return
 		}
 	}
 }
 
-func listenHTTP(mux http.Handler, addr string, useSSL bool, sslCert, sslKey string, wg *sync.WaitGroup) {
+func listenHTTP(server *http.Server, ln net.Listener, wg *sync.WaitGroup) {
 	defer wg.Done()
-	if useSSL {
-		if err := http.ListenAndServeTLS(addr, sslCert, sslKey, mux); err != nil {
+	if server.TLSConfig != nil {
+		// certificates provided by TLS config so no files passed.
+		if err := server.ServeTLS(ln, "", ""); err != nil && err != http.ErrServerClosed {
 			logger.FATAL.Fatalln("ListenAndServe:", err)
 		}
 	} else {
-		if err := http.ListenAndServe(addr, mux); err != nil {
+		if err := server.Serve(ln); err != nil && err != http.ErrServerClosed {
 			logger.FATAL.Fatalln("ListenAndServe:", err)
 		}
 	}
 }
 
+func listenGRPC(server *grpc.Server, ln net.Listener, servers *httpServers, wg *sync.WaitGroup) {
+	defer wg.Done()
+	if err := server.Serve(ln); err != nil && !servers.stopped() {
+		logger.FATAL.Fatalln("Serve gRPC:", err)
+	}
+}
+
+// autocertManager returns manager obtaining TLS certificates from Let's Encrypt for
+// whitelisted hosts and keeping them in cache directory. Host whitelist checked
+// to be non empty on start.
+func autocertManager() *autocert.Manager {
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Email:      viper.GetString("ssl_autocert_email"),
+		HostPolicy: autocert.HostWhitelist(splitList(viper.GetString("ssl_autocert_host_whitelist"))...),
+	}
+	if cacheDir := viper.GetString("ssl_autocert_cache_dir"); cacheDir != "" {
+		manager.Cache = autocert.DirCache(cacheDir)
+	}
+	return manager
+}
+
+// splitList splits comma separated list skipping empty values.
+func splitList(s string) []string {
+	var values []string
+	for _, value := range strings.Split(s, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// setDefaults sets default values of configuration options.
+func setDefaults() {
+	viper.SetDefault("gomaxprocs", 0)
+	viper.SetDefault("debug", false)
+	viper.SetDefault("prefix", "")
+	viper.SetDefault("web", false)
+	viper.SetDefault("web_path", "")
+	viper.SetDefault("admin_password", "")
+	viper.SetDefault("admin_secret", "")
+	viper.SetDefault("web_password", "") // Deprecated. Use admin_password
+	viper.SetDefault("web_secret", "")   // Deprecated. Use admin_secret
+	viper.SetDefault("max_channel_length", 255)
+	viper.SetDefault("channel_prefix", "centrifugo")
+	viper.SetDefault("node_ping_interval", 3)
+	viper.SetDefault("message_send_timeout", 0)
+	viper.SetDefault("shutdown_timeout", 10)
+	viper.SetDefault("http_shutdown_timeout", 5)
+	viper.SetDefault("unix_socket_mode", "")
+	viper.SetDefault("unix_socket_user", "")
+	viper.SetDefault("unix_socket_group", "")
+	viper.SetDefault("subscribe_engine_timeout", 5)
+	viper.SetDefault("bulk_disconnect_rate", 100)
+	viper.SetDefault("client_acks_window", 100)
+	viper.SetDefault("client_commands_per_second", 0)
+	viper.SetDefault("client_commands_burst", 0)
+	viper.SetDefault("publish_proxy", false)
+	viper.SetDefault("max_message_size", 0)
+	viper.SetDefault("slow_client_policy", "disconnect")
+	viper.SetDefault("client_commands_max_violations", 10)
+	viper.SetDefault("alarm_hysteresis", 0.1)
+	viper.SetDefault("alarm_interval", 60)
+	viper.SetDefault("ping_interval", 25)
+	viper.SetDefault("node_metrics_interval", 60)
+	viper.SetDefault("stale_connection_close_delay", 25)
+	viper.SetDefault("expired_connection_close_delay", 25)
+	viper.SetDefault("client_channel_limit", 100)
+	viper.SetDefault("max_clients", 0)
+	viper.SetDefault("client_user_connection_limit", 0)
+	viper.SetDefault("client_user_connection_limit_policy", "reject")
+	viper.SetDefault("client_anonymous_connection_limit", 0)
+	viper.SetDefault("server_subscriptions", []string{})
+	viper.SetDefault("client_channel_limit_soft", 0)
+	viper.SetDefault("limit_grace_period", 30)
+	viper.SetDefault("diag_max_size", 1048576)
+	viper.SetDefault("subscription_churn_limit", 0)
+	viper.SetDefault("connection_log_file", "")
+	viper.SetDefault("connection_log_max_size", 104857600)
+	viper.SetDefault("connection_log_max_backups", 5)
+	viper.SetDefault("statsd_addr", "")
+	viper.SetDefault("statsd_prefix", "centrifugo")
+	viper.SetDefault("statsd_format", "statsd")
+	viper.SetDefault("statsd_tags", []string{})
+	viper.SetDefault("tracing_endpoint", "")
+	viper.SetDefault("tracing_protocol", "http/protobuf")
+	viper.SetDefault("tracing_sample_rate", 1.0)
+	viper.SetDefault("kafka_brokers", []string{})
+	viper.SetDefault("kafka_topics", []string{})
+	viper.SetDefault("kafka_consumer_group", "centrifugo")
+	viper.SetDefault("kafka_commit_interval", 0)
+	viper.SetDefault("kafka_error_policy", "skip")
+	viper.SetDefault("audit_log", false)
+	viper.SetDefault("audit_log_file", "")
+	viper.SetDefault("audit_log_max_size", 104857600)
+	viper.SetDefault("audit_log_max_backups", 5)
+	viper.SetDefault("audit_log_publish", false)
+	viper.SetDefault("subscription_churn_window", 10)
+	viper.SetDefault("websocket_compression", false)
+	viper.SetDefault("websocket_compression_min_size", 512)
+	viper.SetDefault("private_sign_cache_ttl", 0)
+	viper.SetDefault("private_sign_cache_size", 10000)
+	viper.SetDefault("presence_cache_ttl", 1)
+	viper.SetDefault("presence_cache_size", 10000)
+	viper.SetDefault("peers", []string{})
+	viper.SetDefault("peer_retries", 3)
+	viper.SetDefault("peer_timeout", 1)
+	viper.SetDefault("client_request_max_size", 65536)  // 64KB
+	viper.SetDefault("client_queue_max_size", 10485760) // 10MB
+	viper.SetDefault("client_queue_initial_capacity", 2)
+	viper.SetDefault("client_request_max_commands", 1000)
+	viper.SetDefault("client_queue_max_size_soft", 0)
+	viper.SetDefault("presence_ping_interval", 25)
+	viper.SetDefault("presence_expire_interval", 60)
+	viper.SetDefault("private_channel_prefix", "$")
+	viper.SetDefault("namespace_channel_boundary", ":")
+	viper.SetDefault("user_channel_boundary", "#")
+	viper.SetDefault("user_channel_separator", ",")
+	viper.SetDefault("client_channel_boundary", "&")
+	viper.SetDefault("sockjs_url", "//cdn.jsdelivr.net/sockjs/1.1/sockjs.min.js")
+
+	viper.SetDefault("redis_connect_timeout", 1)
+	viper.SetDefault("redis_write_timeout", 1)
+	viper.SetDefault("redis_replica_pool", 0)
+	viper.SetDefault("recover_read_from", "master")
+
+	viper.SetDefault("nats_max_reconnect", -1)
+	viper.SetDefault("nats_reconnect_wait", 2)
+	viper.SetDefault("nats_connect_timeout", 2)
+
+	viper.SetDefault("api_legacy_form_enabled", true)
+	viper.SetDefault("api_legacy_sign_enabled", true)
+	viper.SetDefault("api_sign_window", 30)
+	viper.SetDefault("api_requests_per_second", 0)
+	viper.SetDefault("api_requests_burst", 0)
+	viper.SetDefault("api_async_queue_size", 10000)
+	viper.SetDefault("allowed_origins", []string{})
+	viper.SetDefault("api_allowed_origins", []string{})
+	viper.SetDefault("admin_actions_enabled", true)
+	viper.SetDefault("admin_watch_max_duration", 300)
+	viper.SetDefault("admin_watch_rate", 100)
+	viper.SetDefault("admin_auth_ttl", 86400)
+	viper.SetDefault("admin_auth_legacy_enabled", true)
+	viper.SetDefault("admin_auth_max_attempts", 5)
+	viper.SetDefault("admin_auth_lockout", 10)
+	viper.SetDefault("admin_auth_lockout_max", 3600)
+	viper.SetDefault("api_allowed_ips", []string{})
+	viper.SetDefault("admin_allowed_ips", []string{})
+	viper.SetDefault("trusted_proxies", []string{})
+	viper.SetDefault("insecure_public_bind", false)
+	viper.SetDefault("connect_proxy_endpoint", "")
+	viper.SetDefault("connect_proxy_timeout", 1)
+	viper.SetDefault("connect_proxy_retries", 1)
+	viper.SetDefault("connect_proxy_fail_open", false)
+	viper.SetDefault("connect_proxy_headers", []string{"Cookie", "Authorization"})
+	viper.SetDefault("subscribe_proxy_endpoint", "")
+	viper.SetDefault("subscribe_proxy_timeout", 1)
+	viper.SetDefault("subscribe_proxy_retries", 1)
+	viper.SetDefault("subscribe_proxy_cache_ttl", 0)
+	viper.SetDefault("subscribe_proxy_cache_size", 10000)
+	viper.SetDefault("publish_proxy_endpoint", "")
+	viper.SetDefault("publish_proxy_timeout", 1)
+	viper.SetDefault("publish_proxy_retries", 1)
+	viper.SetDefault("publish_proxy_pass_through", false)
+
+	viper.SetDefault("secret", "")
+	viper.SetDefault("secret_previous", "")
+	viper.SetDefault("config_source", "")
+	viper.SetDefault("config_source_endpoint", "")
+	viper.SetDefault("config_source_key", "")
+	viper.SetDefault("connection_lifetime", 0)
+	viper.SetDefault("watch", false)
+	viper.SetDefault("publish", false)
+	viper.SetDefault("anonymous", false)
+	viper.SetDefault("presence", false)
+	viper.SetDefault("history_size", 0)
+	viper.SetDefault("history_lifetime", 0)
+	viper.SetDefault("recover", false)
+	viper.SetDefault("history_drop_inactive", false)
+	viper.SetDefault("namespaces", "")
+}
+
 // Main starts Centrifugo server.
 func Main() {
 
@@ -95,6 +495,7 @@ func Main() {
 	var name string
 	var admin bool
 	var insecureAdmin bool
+	var insecurePublicBind bool
 	var web bool
 	var webPath string
 	var insecureWeb bool
@@ -106,8 +507,23 @@ func Main() {
 	var useSSL bool
 	var sslCert string
 	var sslKey string
+	var apiSSLCert string
+	var apiSSLKey string
+	var apiSSLClientCA string
+	var apiSSLClientCN string
+	var sslAutocert bool
+	var sslAutocertHostWhitelist string
+	var sslAutocertCacheDir string
+	var sslAutocertEmail string
+	var sslAutocertPorts string
+	var sslAutocertHTTP bool
+	var sslAutocertHTTPAddr string
 	var apiPort string
 	var adminPort string
+	var grpcAPI bool
+	var grpcAPIPort string
+	var grpcClient bool
+	var grpcClientPort string
 
 	var redisHost string
 	var redisPort string
@@ -119,79 +535,31 @@ func Main() {
 	var redisAPINumShards int
 	var redisMasterName string
 	var redisSentinels string
+	var redisReplicaURL string
+	var natsURL string
+	var natsUser string
+	var natsPassword string
+	var natsToken string
+
+	var configSource string
+	var configSourceEndpoint string
+	var configSourceKey string
 
 	var rootCmd = &cobra.Command{
 		Use:   "",
 		Short: "Centrifugo",
 		Long:  "Centrifugo. Real-time messaging (Websockets or SockJS) server in Go.",
 		Run: func(cmd *cobra.Command, args []string) {
-
-			viper.SetDefault("gomaxprocs", 0)
-			viper.SetDefault("debug", false)
-			viper.SetDefault("prefix", "")
-			viper.SetDefault("web", false)
-			viper.SetDefault("web_path", "")
-			viper.SetDefault("admin_password", "")
-			viper.SetDefault("admin_secret", "")
-			viper.SetDefault("web_password", "") // Deprecated. Use admin_password
-			viper.SetDefault("web_secret", "")   // Deprecated. Use admin_secret
-			viper.SetDefault("max_channel_length", 255)
-			viper.SetDefault("channel_prefix", "centrifugo")
-			viper.SetDefault("node_ping_interval", 3)
-			viper.SetDefault("message_send_timeout", 0)
-			viper.SetDefault("ping_interval", 25)
-			viper.SetDefault("node_metrics_interval", 60)
-			viper.SetDefault("stale_connection_close_delay", 25)
-			viper.SetDefault("expired_connection_close_delay", 25)
-			viper.SetDefault("client_channel_limit", 100)
-			viper.SetDefault("client_request_max_size", 65536)  // 64KB
-			viper.SetDefault("client_queue_max_size", 10485760) // 10MB
-			viper.SetDefault("client_queue_initial_capacity", 2)
-			viper.SetDefault("presence_ping_interval", 25)
-			viper.SetDefault("presence_expire_interval", 60)
-			viper.SetDefault("private_channel_prefix", "$")
-			viper.SetDefault("namespace_channel_boundary", ":")
-			viper.SetDefault("user_channel_boundary", "#")
-			viper.SetDefault("user_channel_separator", ",")
-			viper.SetDefault("client_channel_boundary", "&")
-			viper.SetDefault("sockjs_url", "//cdn.jsdelivr.net/sockjs/1.1/sockjs.min.js")
-
-			viper.SetDefault("redis_connect_timeout", 1)
-			viper.SetDefault("redis_write_timeout", 1)
-
-			viper.SetDefault("secret", "")
-			viper.SetDefault("connection_lifetime", 0)
-			viper.SetDefault("watch", false)
-			viper.SetDefault("publish", false)
-			viper.SetDefault("anonymous", false)
-			viper.SetDefault("presence", false)
-			viper.SetDefault("history_size", 0)
-			viper.SetDefault("history_lifetime", 0)
-			viper.SetDefault("recover", false)
-			viper.SetDefault("history_drop_inactive", false)
-			viper.SetDefault("namespaces", "")
+			setDefaults()
 
 			viper.SetEnvPrefix("centrifugo")
-
-			bindEnvs := []string{
-				"debug", "engine", "insecure", "insecure_api", "web", "admin", "admin_password", "admin_secret",
-				"insecure_web", "insecure_admin", "secret", "connection_lifetime", "watch", "publish", "anonymous",
-				"join_leave", "presence", "recover", "history_size", "history_lifetime", "history_drop_inactive",
-				"redis_host", "redis_port", "redis_url",
-			}
-			for _, env := range bindEnvs {
+			for _, env := range envOptions {
 				viper.BindEnv(env)
 			}
-
-			bindPFlags := []string{
-				"port", "api_port", "admin_port", "address", "debug", "name", "admin", "insecure_admin", "web",
-				"web_path", "insecure_web", "engine", "insecure", "insecure_api", "ssl", "ssl_cert", "ssl_key",
-				"log_level", "log_file", "redis_host", "redis_port", "redis_password", "redis_db", "redis_url",
-				"redis_api", "redis_pool", "redis_api_num_shards", "redis_master_name", "redis_sentinels",
-			}
-			for _, flag := range bindPFlags {
+			for _, flag := range flagOptions {
 				viper.BindPFlag(flag, cmd.Flags().Lookup(flag))
 			}
+			configDump.flags = cmd.Flags()
 
 			viper.SetConfigFile(configFile)
 
@@ -214,6 +582,22 @@ func Main() {
 				}
 			}
 
+			// configuration from remote source replaces local config file which can
+			// still be used to set remote source options.
+			var remote *remoteConfig
+			if source := viper.GetString("config_source"); source != "" {
+				remote, err = newRemoteConfig(source, viper.GetString("config_source_endpoint"), viper.GetString("config_source_key"))
+				if err != nil {
+					logger.FATAL.Fatalln(err)
+				}
+				logger.INFO.Printf("Config source: %s, key %s", source, remote.key)
+				if err := remote.read(); err != nil {
+					logger.FATAL.Fatalf("Error reading remote configuration: %s\n", err)
+				}
+				configDump.fileSource = source
+			}
+			configDump.update()
+
 			setupLogging()
 
 			if os.Getenv("GOMAXPROCS") == "" {
@@ -231,6 +615,16 @@ func Main() {
 			if err != nil {
 				logger.FATAL.Fatalln(err)
 			}
+			errs, warnings := c.Lint()
+			for _, problem := range append(errs, warnings...) {
+				logger.WARN.Println(problem)
+			}
+			if viper.GetString("engine") == "nats" {
+				err = libcentrifugo.ValidateNatsConfig(c)
+				if err != nil {
+					logger.FATAL.Fatalln(err)
+				}
+			}
 
 			app, err := libcentrifugo.NewApplication(c)
 			if err != nil {
@@ -246,11 +640,19 @@ func Main() {
 			if c.InsecureAdmin {
 				logger.WARN.Println("Running in INSECURE admin mode")
 			}
+			if c.AdminPassword != "" && !auth.IsPasswordHash(c.AdminPassword) {
+				logger.WARN.Println("admin_password is a plain password, use hash generated by genpassword command instead")
+			}
 
 			var e libcentrifugo.Engine
 			switch viper.GetString("engine") {
 			case "memory":
-				e = libcentrifugo.NewMemoryEngine(app)
+				memoryConf := &libcentrifugo.MemoryEngineConfig{
+					Peers:       viper.GetStringSlice("peers"),
+					PeerRetries: viper.GetInt("peer_retries"),
+					PeerTimeout: time.Duration(viper.GetInt("peer_timeout")) * time.Second,
+				}
+				e = libcentrifugo.NewMemoryEngineWithConfig(app, memoryConf)
 			case "redis":
 				masterName := viper.GetString("redis_master_name")
 				sentinels := viper.GetString("redis_sentinels")
@@ -276,22 +678,52 @@ func Main() {
 					logger.FATAL.Fatalln("Redis master name required when Sentinel used")
 				}
 
+				replicaURLs := []string{}
+				if replicas := viper.GetString("redis_replica_url"); replicas != "" {
+					for _, replicaURL := range strings.Split(replicas, ",") {
+						replicaURL := strings.TrimSpace(replicaURL)
+						if replicaURL == "" {
+							continue
+						}
+						replicaURLs = append(replicaURLs, replicaURL)
+					}
+				}
+
+				recoverReadFrom := viper.GetString("recover_read_from")
+				if recoverReadFrom != libcentrifugo.RedisReadFromMaster && recoverReadFrom != libcentrifugo.RedisReadFromReplica {
+					logger.FATAL.Fatalf("Unknown recover_read_from value: %s", recoverReadFrom)
+				}
+
 				redisConf := &libcentrifugo.RedisEngineConfig{
-					Host:           viper.GetString("redis_host"),
-					Port:           viper.GetString("redis_port"),
-					Password:       viper.GetString("redis_password"),
-					DB:             viper.GetString("redis_db"),
-					URL:            viper.GetString("redis_url"),
-					PoolSize:       viper.GetInt("redis_pool"),
-					API:            viper.GetBool("redis_api"),
-					NumAPIShards:   viper.GetInt("redis_api_num_shards"),
-					MasterName:     masterName,
-					SentinelAddrs:  sentinelAddrs,
-					ConnectTimeout: time.Duration(viper.GetInt("redis_connect_timeout")) * time.Second,
-					ReadTimeout:    time.Duration(viper.GetInt("node_ping_interval")*3+1) * time.Second,
-					WriteTimeout:   time.Duration(viper.GetInt("redis_write_timeout")) * time.Second,
+					Host:            viper.GetString("redis_host"),
+					Port:            viper.GetString("redis_port"),
+					Password:        viper.GetString("redis_password"),
+					DB:              viper.GetString("redis_db"),
+					URL:             viper.GetString("redis_url"),
+					PoolSize:        viper.GetInt("redis_pool"),
+					API:             viper.GetBool("redis_api"),
+					NumAPIShards:    viper.GetInt("redis_api_num_shards"),
+					MasterName:      masterName,
+					SentinelAddrs:   sentinelAddrs,
+					ConnectTimeout:  time.Duration(viper.GetInt("redis_connect_timeout")) * time.Second,
+					ReadTimeout:     time.Duration(viper.GetInt("node_ping_interval")*3+1) * time.Second,
+					WriteTimeout:    time.Duration(viper.GetInt("redis_write_timeout")) * time.Second,
+					ReplicaURLs:     replicaURLs,
+					ReplicaPoolSize: viper.GetInt("redis_replica_pool"),
+					RecoverReadFrom: recoverReadFrom,
 				}
 				e = libcentrifugo.NewRedisEngine(app, redisConf)
+			case "nats":
+				natsConf := &libcentrifugo.NatsEngineConfig{
+					URL:            viper.GetString("nats_url"),
+					User:           viper.GetString("nats_user"),
+					Password:       viper.GetString("nats_password"),
+					Token:          viper.GetString("nats_token"),
+					MaxReconnect:   viper.GetInt("nats_max_reconnect"),
+					ReconnectWait:  time.Duration(viper.GetInt("nats_reconnect_wait")) * time.Second,
+					ConnectTimeout: time.Duration(viper.GetInt("nats_connect_timeout")) * time.Second,
+				}
+				e = libcentrifugo.NewNatsEngine(app, natsConf)
 			default:
 				logger.FATAL.Fatalln("Unknown engine: " + viper.GetString("engine"))
 			}
@@ -309,13 +741,28 @@ func Main() {
 					os.Exit(1)
 				}
 			}
+			if viper.GetBool("ssl_autocert") {
+				logger.INFO.Println("Use SSL autocert:", true)
+				if len(splitList(viper.GetString("ssl_autocert_host_whitelist"))) == 0 {
+					// without whitelist certificate would be requested for any SNI
+					// name allowing to exhaust Let's Encrypt rate limits.
+					logger.FATAL.Println("SSL autocert requires ssl_autocert_host_whitelist")
+					os.Exit(1)
+				}
+			}
+			if viper.GetBool("ssl_autocert") && (viper.GetString("ssl_cert") != "" || viper.GetString("ssl_key") != "") {
+				logger.FATAL.Println("SSL certificate can not be used together with SSL autocert")
+				os.Exit(1)
+			}
 			app.SetEngine(e)
 			err = app.Run()
 			if err != nil {
 				logger.FATAL.Fatalln(err)
 			}
 
-			go handleSignals(app)
+			servers := &httpServers{}
+			done := make(chan struct{})
+			go handleSignals(app, servers, remote, done)
 
 			sockjsOpts := sockjs.DefaultOptions
 
@@ -363,7 +810,7 @@ func Main() {
 			var portFlags libcentrifugo.HandlerFlag
 
 			portFlags = portToHandlerFlags[clientPort]
-			portFlags |= libcentrifugo.HandlerRawWS | libcentrifugo.HandlerSockJS
+			portFlags |= libcentrifugo.HandlerRawWS | libcentrifugo.HandlerSockJS | libcentrifugo.HandlerHealth | libcentrifugo.HandlerSSE
 			portToHandlerFlags[clientPort] = portFlags
 
 			portFlags = portToHandlerFlags[apiPort]
@@ -380,6 +827,70 @@ func Main() {
 			portToHandlerFlags[adminPort] = portFlags
 
 			var wg sync.WaitGroup
+
+			// autocertPorts contains ports serving TLS with certificates obtained
+			// automatically, by default only client port.
+			autocertPorts := map[string]bool{}
+			var certManager *autocert.Manager
+			if viper.GetBool("ssl_autocert") {
+				certManager = autocertManager()
+				ports := splitList(viper.GetString("ssl_autocert_ports"))
+				if len(ports) == 0 {
+					ports = []string{clientPort}
+				}
+				for _, p := range ports {
+					autocertPorts[p] = true
+				}
+				if viper.GetBool("ssl_autocert_http") {
+					// serve HTTP-01 challenge, other requests redirected to HTTPS.
+					addr := viper.GetString("ssl_autocert_http_addr")
+					logger.INFO.Printf("Start serving ACME HTTP challenge on %s\n", addr)
+					ln, err := net.Listen("tcp", addr)
+					if err != nil {
+						logger.FATAL.Fatalln("Listen:", err)
+					}
+					server := &http.Server{Addr: addr, Handler: certManager.HTTPHandler(nil)}
+					servers.add(server)
+					wg.Add(1)
+					go listenHTTP(server, ln, &wg)
+				}
+			}
+
+			// tlsConfigs contains TLS configuration of ports. API port served
+			// separately can have own certificate and require client certificates.
+			if viper.GetString("api_ssl_client_ca") != "" && (apiPort == clientPort || apiPort == adminPort) {
+				logger.FATAL.Fatalln("api_ssl_client_ca requires api_port different from client and admin ports")
+			}
+			tlsConfigs := map[string]*tls.Config{}
+			for handlerPort := range portToHandlerFlags {
+				var opts tlsOptions
+				if handlerPort == apiPort && apiPort != clientPort && apiPort != adminPort {
+					opts = apiTLSOptions()
+				} else if viper.GetBool("ssl") {
+					opts = tlsOptions{cert: viper.GetString("ssl_cert"), key: viper.GetString("ssl_key")}
+				}
+				if autocertPorts[handlerPort] {
+					opts.autocert = certManager
+				}
+				tlsConfig, err := opts.tlsConfig()
+				if err != nil {
+					logger.FATAL.Fatalf("TLS configuration of port %s: %v", handlerPort, err)
+				}
+				tlsConfigs[handlerPort] = tlsConfig
+			}
+
+			// portNames contains names of endpoints served on port used to find
+			// sockets passed by systemd socket activation.
+			portNames := map[string][]string{}
+			portNames[clientPort] = append(portNames[clientPort], "client")
+			portNames[apiPort] = append(portNames[apiPort], "api")
+			portNames[adminPort] = append(portNames[adminPort], "admin")
+
+			activated, err := activatedListeners()
+			if err != nil {
+				logger.FATAL.Fatalln(err)
+			}
+
 			// Iterate over port to flags mapping and start HTTP servers
 			// on separate ports serving handlers specified in flags.
 			for handlerPort, handlerFlags := range portToHandlerFlags {
@@ -393,14 +904,114 @@ func Main() {
 					SockjsOptions: sockjsOpts,
 				}
 				mux := libcentrifugo.DefaultMux(app, muxOpts)
+				if handlerFlags&libcentrifugo.HandlerDebug != 0 {
+					mux.Handle(muxOpts.Prefix+"/debug/config", configDump)
+				}
 
-				addr := net.JoinHostPort(viper.GetString("address"), handlerPort)
+				addr := listenAddr(viper.GetString("address"), handlerPort)
+
+				ln := activatedListener(activated, portNames[handlerPort])
+				if ln != nil {
+					addr = "activated socket " + ln.Addr().String()
+				} else {
+					ln, err = listen(viper.GetString("address"), handlerPort)
+					if err != nil {
+						logger.FATAL.Fatalln("Listen:", err)
+					}
+				}
+
+				if !loopbackListener(ln) {
+					servers.addBind(publicBind{
+						addr:  addr,
+						api:   handlerFlags&libcentrifugo.HandlerAPI != 0,
+						admin: handlerFlags&libcentrifugo.HandlerAdmin != 0,
+					})
+					if err := servers.checkBinds(c); err != nil {
+						logger.FATAL.Fatalln(err)
+					}
+				}
 
 				logger.INFO.Printf("Start serving %s endpoints on %s\n", handlerFlags, addr)
+				server := &http.Server{Addr: addr, Handler: mux, TLSConfig: tlsConfigs[handlerPort]}
+				servers.add(server)
 				wg.Add(1)
-				go listenHTTP(mux, addr, useSSL, sslCert, sslKey, &wg)
+				go listenHTTP(server, ln, &wg)
+			}
+
+			if viper.GetBool("grpc_api") {
+				grpcAPIPort := viper.GetString("grpc_api_port")
+				// gRPC API uses TLS settings of separate API port, client certificates
+				// verified with api_ssl_client_ca authenticate calls.
+				tlsConfig, err := apiTLSOptions().tlsConfig()
+				if err != nil {
+					logger.FATAL.Fatalf("TLS configuration of gRPC API port %s: %v", grpcAPIPort, err)
+				}
+				var opts []grpc.ServerOption
+				if tlsConfig != nil {
+					opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+				}
+				addr := listenAddr(viper.GetString("address"), grpcAPIPort)
+				ln := activatedListener(activated, []string{"grpc_api"})
+				if ln != nil {
+					addr = "activated socket " + ln.Addr().String()
+				} else {
+					ln, err = listen(viper.GetString("address"), grpcAPIPort)
+					if err != nil {
+						logger.FATAL.Fatalln("Listen:", err)
+					}
+				}
+				if !loopbackListener(ln) {
+					servers.addBind(publicBind{addr: addr, api: true, grpc: true})
+					if err := servers.checkBinds(c); err != nil {
+						logger.FATAL.Fatalln(err)
+					}
+					// API secret sent in call metadata so it must not travel in
+					// plain text over network.
+					if !viper.GetBool("insecure_public_bind") && tlsConfig == nil {
+						logger.FATAL.Fatalf("Refusing to serve gRPC API without TLS on non-loopback address %s, enable ssl, bind to loopback address or set insecure_public_bind", addr)
+					}
+				}
+				logger.INFO.Printf("Start serving gRPC API on %s\n", addr)
+				server := app.GRPCAPIServer(opts...)
+				servers.addGRPC(server)
+				wg.Add(1)
+				go listenGRPC(server, ln, servers, &wg)
+			}
+
+			if viper.GetBool("grpc_client") {
+				grpcClientPort := viper.GetString("grpc_client_port")
+				var tlsOpts tlsOptions
+				if viper.GetBool("ssl") {
+					tlsOpts = tlsOptions{cert: viper.GetString("ssl_cert"), key: viper.GetString("ssl_key")}
+				}
+				tlsConfig, err := tlsOpts.tlsConfig()
+				if err != nil {
+					logger.FATAL.Fatalf("TLS configuration of gRPC client port %s: %v", grpcClientPort, err)
+				}
+				var opts []grpc.ServerOption
+				if tlsConfig != nil {
+					opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+				}
+				addr := listenAddr(viper.GetString("address"), grpcClientPort)
+				ln := activatedListener(activated, []string{"grpc_client"})
+				if ln != nil {
+					addr = "activated socket " + ln.Addr().String()
+				} else {
+					ln, err = listen(viper.GetString("address"), grpcClientPort)
+					if err != nil {
+						logger.FATAL.Fatalln("Listen:", err)
+					}
+				}
+				logger.INFO.Printf("Start serving gRPC client transport on %s\n", addr)
+				server := app.GRPCClientServer(opts...)
+				servers.addGRPC(server)
+				wg.Add(1)
+				go listenGRPC(server, ln, servers, &wg)
 			}
 			wg.Wait()
+			// servers stop accepting connections as soon as shutdown started, wait
+			// until in-flight requests finished.
+			<-done
 		},
 	}
 	rootCmd.Flags().StringVarP(&port, "port", "p", "8000", "port to bind to")
@@ -411,14 +1022,30 @@ func Main() {
 	rootCmd.Flags().BoolVarP(&admin, "admin", "", false, "Enable admin socket")
 	rootCmd.Flags().BoolVarP(&web, "web", "w", false, "serve admin web interface application (warning: automatically enables admin socket)")
 	rootCmd.Flags().StringVarP(&webPath, "web_path", "", "", "optional path to web interface application")
-	rootCmd.Flags().StringVarP(&engn, "engine", "e", "memory", "engine to use: memory or redis")
+	rootCmd.Flags().StringVarP(&engn, "engine", "e", "memory", "engine to use: memory, redis or nats")
 	rootCmd.Flags().BoolVarP(&insecure, "insecure", "", false, "start in insecure client mode")
 	rootCmd.Flags().BoolVarP(&insecureAPI, "insecure_api", "", false, "use insecure API mode")
 	rootCmd.Flags().BoolVarP(&insecureWeb, "insecure_web", "", false, "use insecure web mode – no web password and web secret required for web interface (warning: automatically enables insecure_admin option)")
 	rootCmd.Flags().BoolVarP(&insecureAdmin, "insecure_admin", "", false, "use insecure admin mode – no auth required for admin socket")
+	rootCmd.Flags().BoolVarP(&insecurePublicBind, "insecure_public_bind", "", false, "allow insecure API and admin modes and gRPC API without TLS on non-loopback address")
 	rootCmd.Flags().BoolVarP(&useSSL, "ssl", "", false, "accept SSL connections. This requires an X509 certificate and a key file")
 	rootCmd.Flags().StringVarP(&sslCert, "ssl_cert", "", "", "path to an X509 certificate file")
 	rootCmd.Flags().StringVarP(&sslKey, "ssl_key", "", "", "path to an X509 certificate key")
+	rootCmd.Flags().StringVarP(&apiSSLCert, "api_ssl_cert", "", "", "path to an X509 certificate file of separate API port, ssl_cert by default")
+	rootCmd.Flags().StringVarP(&apiSSLKey, "api_ssl_key", "", "", "path to an X509 certificate key of separate API port, ssl_key by default")
+	rootCmd.Flags().StringVarP(&apiSSLClientCA, "api_ssl_client_ca", "", "", "path to CA bundle to verify client certificates required on separate API port")
+	rootCmd.Flags().StringVarP(&apiSSLClientCN, "api_ssl_client_cn", "", "", "comma separated list of allowed common names of API client certificates")
+	rootCmd.Flags().BoolVarP(&grpcAPI, "grpc_api", "", false, "serve API over gRPC on separate port")
+	rootCmd.Flags().StringVarP(&grpcAPIPort, "grpc_api_port", "", "10000", "port to bind gRPC API to")
+	rootCmd.Flags().BoolVarP(&grpcClient, "grpc_client", "", false, "serve client connections over gRPC on separate port")
+	rootCmd.Flags().StringVarP(&grpcClientPort, "grpc_client_port", "", "10001", "port to bind gRPC client transport to")
+	rootCmd.Flags().BoolVarP(&sslAutocert, "ssl_autocert", "", false, "automatically obtain SSL certificates from Let's Encrypt")
+	rootCmd.Flags().StringVarP(&sslAutocertHostWhitelist, "ssl_autocert_host_whitelist", "", "", "comma separated list of hosts to obtain SSL certificates for, required for SSL autocert")
+	rootCmd.Flags().StringVarP(&sslAutocertCacheDir, "ssl_autocert_cache_dir", "", "", "directory to keep obtained SSL certificates in (SSL autocert)")
+	rootCmd.Flags().StringVarP(&sslAutocertEmail, "ssl_autocert_email", "", "", "contact email for Let's Encrypt account (SSL autocert)")
+	rootCmd.Flags().StringVarP(&sslAutocertPorts, "ssl_autocert_ports", "", "", "comma separated list of ports serving SSL with obtained certificates, client port by default (SSL autocert)")
+	rootCmd.Flags().BoolVarP(&sslAutocertHTTP, "ssl_autocert_http", "", false, "serve ACME HTTP-01 challenge (SSL autocert)")
+	rootCmd.Flags().StringVarP(&sslAutocertHTTPAddr, "ssl_autocert_http_addr", "", ":80", "address to serve ACME HTTP-01 challenge on (SSL autocert)")
 	rootCmd.Flags().StringVarP(&apiPort, "api_port", "", "", "port to bind api endpoints to (optional until this is required by your deploy setup)")
 	rootCmd.Flags().StringVarP(&adminPort, "admin_port", "", "", "port to bind admin endpoints to (optional until this is required by your deploy setup)")
 	rootCmd.Flags().StringVarP(&logLevel, "log_level", "", "debug", "set the log level: trace, debug, info, error, critical, fatal or none")
@@ -433,6 +1060,14 @@ func Main() {
 	rootCmd.Flags().IntVarP(&redisAPINumShards, "redis_api_num_shards", "", 0, "Number of shards for redis API queue (Redis engine)")
 	rootCmd.Flags().StringVarP(&redisMasterName, "redis_master_name", "", "", "Name of Redis master Sentinel monitors (Redis engine)")
 	rootCmd.Flags().StringVarP(&redisSentinels, "redis_sentinels", "", "", "Comma separated list of Sentinels (Redis engine)")
+	rootCmd.Flags().StringVarP(&redisReplicaURL, "redis_replica_url", "", "", "Comma separated list of Redis replica URLs to read history and presence from (Redis engine)")
+	rootCmd.Flags().StringVarP(&natsURL, "nats_url", "", "nats://127.0.0.1:4222", "comma separated list of NATS server URLs (NATS engine)")
+	rootCmd.Flags().StringVarP(&natsUser, "nats_user", "", "", "NATS user (NATS engine)")
+	rootCmd.Flags().StringVarP(&natsPassword, "nats_password", "", "", "NATS password (NATS engine)")
+	rootCmd.Flags().StringVarP(&natsToken, "nats_token", "", "", "NATS auth token (NATS engine)")
+	rootCmd.Flags().StringVarP(&configSource, "config_source", "", "", "load configuration from remote source and watch it for changes: etcd or consul")
+	rootCmd.Flags().StringVarP(&configSourceEndpoint, "config_source_endpoint", "", "", "comma separated list of etcd or Consul addresses (remote config source)")
+	rootCmd.Flags().StringVarP(&configSourceKey, "config_source_key", "", "", "key with configuration document, format detected by extension (remote config source)")
 
 	var versionCmd = &cobra.Command{
 		Use:   "version",
@@ -450,32 +1085,175 @@ func Main() {
 		Short: "Check configuration file",
 		Long:  `Check Centrifugo configuration file`,
 		Run: func(cmd *cobra.Command, args []string) {
-			err := validateConfig(checkConfigFile)
+			warnings, err := validateConfig(checkConfigFile)
+			for _, warning := range warnings {
+				logger.WARN.Println(warning)
+			}
 			if err != nil {
 				logger.FATAL.Fatalln(err)
 			}
+			if len(warnings) > 0 {
+				os.Exit(2)
+			}
 		},
 	}
 	checkConfigCmd.Flags().StringVarP(&checkConfigFile, "config", "c", "config.json", "path to config file to check")
 
 	var outputConfigFile string
+	var outputConfigFormat string
+	var outputConfigForce bool
+	var outputConfigInteractive bool
+	var outputConfigOpts genConfigOptions
 
 	var generateConfigCmd = &cobra.Command{
 		Use:   "genconfig",
 		Short: "Generate simple configuration file to start with",
 		Long:  `Generate simple configuration file to start with`,
 		Run: func(cmd *cobra.Command, args []string) {
-			err := generateConfig(outputConfigFile)
+			if outputConfigFile == "" {
+				format := outputConfigFormat
+				if format == "" {
+					format = "json"
+				}
+				outputConfigFile = "config." + format
+			}
+			if outputConfigInteractive {
+				err := promptConfigOptions(os.Stdin, os.Stdout, &outputConfigOpts)
+				if err != nil {
+					logger.FATAL.Fatalln(err)
+				}
+			}
+			err := generateConfig(outputConfigFile, outputConfigFormat, outputConfigOpts, outputConfigForce)
+			if err != nil {
+				logger.FATAL.Fatalln(err)
+			}
+		},
+	}
+	generateConfigCmd.Flags().StringVarP(&outputConfigFile, "config", "c", "", "path to output config file, config.<format> by default")
+	generateConfigCmd.Flags().StringVarP(&outputConfigFormat, "format", "f", "", "output config format: json, toml or yaml, detected by config file extension if not set")
+	generateConfigCmd.Flags().BoolVarP(&outputConfigForce, "force", "", false, "overwrite existing config file")
+	generateConfigCmd.Flags().BoolVarP(&outputConfigInteractive, "interactive", "i", false, "ask for engine, port and admin web interface options")
+	generateConfigCmd.Flags().StringVarP(&outputConfigOpts.Engine, "engine", "e", "memory", "engine to use: memory, redis or nats")
+	generateConfigCmd.Flags().StringVarP(&outputConfigOpts.Port, "port", "p", "8000", "port to bind to")
+	generateConfigCmd.Flags().StringVarP(&outputConfigOpts.RedisURL, "redis_url", "", "", "redis connection URL (Redis engine)")
+	generateConfigCmd.Flags().StringVarP(&outputConfigOpts.NatsURL, "nats_url", "", "", "comma separated list of NATS server URLs (NATS engine)")
+	generateConfigCmd.Flags().BoolVarP(&outputConfigOpts.Web, "web", "w", false, "enable admin web interface")
+
+	var genPasswordCmd = &cobra.Command{
+		Use:   "genpassword",
+		Short: "Generate admin password hash",
+		Long:  `Ask for admin password and print its bcrypt hash to use as admin_password option value`,
+		Run: func(cmd *cobra.Command, args []string) {
+			password, err := promptPassword(os.Stdin, os.Stderr)
+			if err != nil {
+				logger.FATAL.Fatalln(err)
+			}
+			hash, err := auth.GeneratePasswordHash(password)
+			if err != nil {
+				logger.FATAL.Fatalln(err)
+			}
+			fmt.Println(hash)
+		},
+	}
+
+	var showConfigFile string
+
+	var showConfigCmd = &cobra.Command{
+		Use:   "showconfig",
+		Short: "Show effective configuration",
+		Long:  `Show configuration merged from defaults, config file and environment variables with source of every value, secrets redacted`,
+		Run: func(cmd *cobra.Command, args []string) {
+			setDefaults()
+			viper.SetEnvPrefix("centrifugo")
+			for _, env := range envOptions {
+				viper.BindEnv(env)
+			}
+			viper.SetConfigFile(showConfigFile)
+			err := viper.ReadInConfig()
+			if err != nil {
+				switch err.(type) {
+				case viper.ConfigParseError:
+					logger.FATAL.Fatalf("Error parsing configuration: %s\n", err)
+				default:
+					logger.WARN.Println("No config file found")
+				}
+			}
+			fileSource := sourceFile
+			if source := viper.GetString("config_source"); source != "" {
+				remote, err := newRemoteConfig(source, viper.GetString("config_source_endpoint"), viper.GetString("config_source_key"))
+				if err != nil {
+					logger.FATAL.Fatalln(err)
+				}
+				if err := remote.read(); err != nil {
+					logger.FATAL.Fatalf("Error reading remote configuration: %s\n", err)
+				}
+				fileSource = source
+			}
+			data, err := json.MarshalIndent(effectiveConfig(nil, fileSource), "", "  ")
+			if err != nil {
+				logger.FATAL.Fatalln(err)
+			}
+			fmt.Println(string(data))
+		},
+	}
+	showConfigCmd.Flags().StringVarP(&showConfigFile, "config", "c", "config.json", "path to config file")
+
+	var diagConfigFile, diagOutputFile, diagURL string
+
+	var diagCmd = &cobra.Command{
+		Use:   "diag",
+		Short: "Get diagnostic bundle from running node",
+		Long:  `Get diagnostic bundle with redacted config, stats, engine state and goroutine summary from running Centrifugo node`,
+		Run: func(cmd *cobra.Command, args []string) {
+			bundle, err := requestDiag(diagConfigFile, diagURL)
+			if err != nil {
+				logger.FATAL.Fatalln(err)
+			}
+			if diagOutputFile == "" {
+				os.Stdout.Write(bundle)
+				return
+			}
+			err = ioutil.WriteFile(diagOutputFile, bundle, 0600)
+			if err != nil {
+				logger.FATAL.Fatalln(err)
+			}
+		},
+	}
+	diagCmd.Flags().StringVarP(&diagConfigFile, "config", "c", "config.json", "path to config file of running node")
+	diagCmd.Flags().StringVarP(&diagOutputFile, "output", "o", "", "path to output bundle file, stdout if not set")
+	diagCmd.Flags().StringVarP(&diagURL, "url", "", "", "API endpoint URL of node, built from config if not set")
+
+	var benchOpts benchOptions
+	var benchDuration int
+
+	var benchCmd = &cobra.Command{
+		Use:   "bench",
+		Short: "Benchmark embedded node",
+		Long:  `Run embedded node, simulate publishers and subscribers over in-process connections and print throughput and latency percentiles`,
+		Run: func(cmd *cobra.Command, args []string) {
+			benchOpts.Duration = time.Duration(benchDuration) * time.Second
+			err := runBench(benchOpts, os.Stdout)
 			if err != nil {
 				logger.FATAL.Fatalln(err)
 			}
 		},
 	}
-	generateConfigCmd.Flags().StringVarP(&outputConfigFile, "config", "c", "config.json", "path to output config file")
+	benchCmd.Flags().StringVarP(&benchOpts.Engine, "engine", "e", "memory", "engine to use: memory or redis")
+	benchCmd.Flags().StringVarP(&benchOpts.RedisURL, "redis_url", "", "", "redis connection URL (Redis engine), redis://127.0.0.1:6379/0 if not set")
+	benchCmd.Flags().IntVarP(&benchOpts.Publishers, "publishers", "", 4, "number of publishers")
+	benchCmd.Flags().IntVarP(&benchOpts.Subscribers, "subscribers", "", 1000, "number of subscribers")
+	benchCmd.Flags().IntVarP(&benchOpts.Channels, "channels", "", 10, "number of channels subscribers spread over")
+	benchCmd.Flags().IntVarP(&benchOpts.PayloadSize, "payload", "", 128, "size of published data in bytes")
+	benchCmd.Flags().IntVarP(&benchOpts.Rate, "rate", "", 0, "messages published by each publisher per second, 0 means as fast as possible")
+	benchCmd.Flags().IntVarP(&benchDuration, "duration", "d", 10, "benchmark duration in seconds")
 
 	rootCmd.AddCommand(versionCmd)
 	rootCmd.AddCommand(checkConfigCmd)
 	rootCmd.AddCommand(generateConfigCmd)
+	rootCmd.AddCommand(diagCmd)
+	rootCmd.AddCommand(showConfigCmd)
+	rootCmd.AddCommand(genPasswordCmd)
+	rootCmd.AddCommand(benchCmd)
 	rootCmd.Execute()
 }
 
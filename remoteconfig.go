@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/FZambia/go-logger"
+	"github.com/spf13/viper"
+	crypt "github.com/xordataexchange/crypt/config"
+)
+
+// remoteConfig loads configuration document kept in etcd or Consul key instead of
+// local config file and watches it for changes. Format of document (JSON, TOML or
+// YAML) detected by key extension, JSON used when key has no extension.
+type remoteConfig struct {
+	source  string
+	key     string
+	format  string
+	manager crypt.ConfigManager
+	// last is a document currently loaded into configuration.
+	last []byte
+}
+
+// newRemoteConfig creates remote configuration source. Endpoints is a comma
+// separated list of etcd (http://127.0.0.1:2379) or Consul (127.0.0.1:8500)
+// addresses.
+func newRemoteConfig(source, endpoints, key string) (*remoteConfig, error) {
+	machines := splitList(endpoints)
+	if len(machines) == 0 {
+		return nil, errors.New("config_source_endpoint required for remote config source")
+	}
+	if key == "" {
+		return nil, errors.New("config_source_key required for remote config source")
+	}
+	format := normalizeConfigFormat(configFormat(key))
+	if format == "" {
+		format = "json"
+	}
+	if !stringInSlice(format, []string{"json", "toml", "yaml"}) {
+		return nil, errors.New("config_source_key must have one of supported extensions: json, toml, yaml")
+	}
+
+	var manager crypt.ConfigManager
+	var err error
+	switch source {
+	case "etcd":
+		manager, err = crypt.NewStandardEtcdConfigManager(machines)
+	case "consul":
+		manager, err = crypt.NewStandardConsulConfigManager(machines)
+	default:
+		return nil, errors.New("unknown config_source " + source + ", must be etcd or consul")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &remoteConfig{
+		source:  source,
+		key:     key,
+		format:  format,
+		manager: manager,
+	}, nil
+}
+
+// read loads current document from remote source into configuration.
+func (r *remoteConfig) read() error {
+	data, err := r.manager.Get(r.key)
+	if err != nil {
+		return err
+	}
+	return r.load(data)
+}
+
+// load replaces configuration with document. Document parsed into separate viper
+// instance first so invalid document leaves current configuration untouched. Only
+// called from goroutine handling configuration reloads.
+func (r *remoteConfig) load(data []byte) error {
+	v := viper.New()
+	v.SetConfigType(r.format)
+	if err := v.ReadConfig(bytes.NewReader(data)); err != nil {
+		return err
+	}
+	viper.SetConfigType(r.format)
+	if err := viper.ReadConfig(bytes.NewReader(data)); err != nil {
+		return err
+	}
+	r.last = data
+	return nil
+}
+
+// changed returns true if document differs from currently loaded one.
+func (r *remoteConfig) changed(data []byte) bool {
+	return !bytes.Equal(data, r.last)
+}
+
+// watch returns channel with new versions of document. Watch errors only logged –
+// watcher keeps retrying so running node never stops because remote source is
+// unavailable.
+func (r *remoteConfig) watch() <-chan []byte {
+	updates := make(chan []byte)
+	responses := r.manager.Watch(r.key, nil)
+	go func() {
+		for resp := range responses {
+			if resp.Error != nil {
+				logger.ERROR.Printf("Error watching %s config key %s: %v", r.source, r.key, resp.Error)
+				continue
+			}
+			updates <- resp.Value
+		}
+	}()
+	return updates
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// tlsOptions are TLS settings of single listener.
+type tlsOptions struct {
+	// autocert is a manager of certificates obtained automatically, certificate
+	// files not used when set.
+	autocert *autocert.Manager
+	cert     string
+	key      string
+	// clientCA is a path to PEM bundle of CA certificates client certificates must
+	// be signed with. Client certificate not required when empty.
+	clientCA string
+	// clientCNs is a list of subject common names of allowed client certificates,
+	// any certificate signed by clientCA allowed when empty.
+	clientCNs []string
+}
+
+// apiTLSOptions returns TLS settings of API port served separately from client
+// port. Certificate files default to ssl_cert and ssl_key.
+func apiTLSOptions() tlsOptions {
+	opts := tlsOptions{
+		cert:      viper.GetString("api_ssl_cert"),
+		key:       viper.GetString("api_ssl_key"),
+		clientCA:  viper.GetString("api_ssl_client_ca"),
+		clientCNs: splitList(viper.GetString("api_ssl_client_cn")),
+	}
+	if opts.cert == "" && opts.key == "" && viper.GetBool("ssl") {
+		opts.cert = viper.GetString("ssl_cert")
+		opts.key = viper.GetString("ssl_key")
+	}
+	return opts
+}
+
+// tlsConfig builds TLS config of listener, nil returned when listener serves
+// plain HTTP.
+func (o tlsOptions) tlsConfig() (*tls.Config, error) {
+	var config *tls.Config
+	if o.autocert != nil {
+		config = o.autocert.TLSConfig()
+	} else if o.cert != "" || o.key != "" {
+		if o.cert == "" || o.key == "" {
+			return nil, errors.New("both SSL certificate and key required")
+		}
+		cert, err := tls.LoadX509KeyPair(o.cert, o.key)
+		if err != nil {
+			return nil, err
+		}
+		config = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	if o.clientCA == "" {
+		if len(o.clientCNs) > 0 {
+			return nil, errors.New("client certificate common names require client CA")
+		}
+		return config, nil
+	}
+	if config == nil {
+		return nil, errors.New("client certificate authentication requires SSL")
+	}
+	data, err := ioutil.ReadFile(o.clientCA)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("no certificates found in client CA bundle " + o.clientCA)
+	}
+	config.ClientCAs = pool
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	if len(o.clientCNs) > 0 {
+		allowed := o.clientCNs
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, chains [][]*x509.Certificate) error {
+			for _, chain := range chains {
+				if len(chain) > 0 && stringInSlice(chain[0].Subject.CommonName, allowed) {
+					return nil
+				}
+			}
+			return errors.New("client certificate common name not allowed")
+		}
+	}
+	return config, nil
+}